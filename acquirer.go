@@ -0,0 +1,58 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Acquirer captures something other than the target files into the same output, such as physical memory. Acquirers
+// are run before any files are searched for, since walking MFTs and reading files disturbs what's in memory.
+type Acquirer interface {
+	// Name is the path the capture is written to in the output, e.g. memory/physical_memory.raw.
+	Name() string
+
+	// Acquire starts the capture, logging through the collection's logger. size is how many bytes the reader will
+	// return, or zero when that isn't known. The reader is closed once it's been read to the end or fails.
+	Acquire(ctx context.Context, logger Logger) (reader io.ReadCloser, size int64, err error)
+}
+
+// runAcquirers writes each acquirer's capture into the output. One that fails is recorded in the report like a file
+// that couldn't be read and the rest still run.
+func (collector *Collector) runAcquirers(ctx context.Context, fileReaders chan fileReader) (err error) {
+	for _, acquirer := range collector.Options.Acquirers {
+		acquirer = collector.Options.footprintAcquirer(acquirer)
+		name := acquirer.Name()
+		collector.logger().Infof("Acquiring %s.", name)
+		reader, size, acquireErr := acquirer.Acquire(ctx, collector.logger())
+		if acquireErr != nil {
+			collector.logger().Errorf("Failed to acquire %s: %v", name, acquireErr)
+			collector.report.fileFailed(name, "", fmt.Errorf("failed to acquire %s: %w", name, acquireErr))
+			continue
+		}
+		fileReader := fileReader{
+			fullPath: name,
+			method:   readMethodAcquired,
+			reader: collector.instrumentReader(ctx, &closingReader{file: reader}, Progress{
+				Stage:      StageCopy,
+				FileName:   name,
+				TotalBytes: size,
+			}),
+		}
+		err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(fileReader, ""))
+		if err != nil {
+			_ = reader.Close()
+			return
+		}
+	}
+	return
+}
+
+// isOutputName reports whether a name given to a capture, such as a command's, can be used as a file or directory name
+// in the output.
+func isOutputName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\:*?"<>|`)
+}
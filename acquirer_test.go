@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// testAcquirer returns its data, or fails with err.
+type testAcquirer struct {
+	name   string
+	data   string
+	err    error
+	closed bool
+	logger Logger // the logger it was acquired with
+}
+
+func (acquirer *testAcquirer) Name() string {
+	return acquirer.name
+}
+
+func (acquirer *testAcquirer) Acquire(ctx context.Context, logger Logger) (reader io.ReadCloser, size int64, err error) {
+	acquirer.logger = logger
+	if acquirer.err != nil {
+		err = acquirer.err
+		return
+	}
+	reader = &testReadCloser{Reader: strings.NewReader(acquirer.data), closed: &acquirer.closed}
+	size = int64(len(acquirer.data))
+	return
+}
+
+type testReadCloser struct {
+	io.Reader
+	closed *bool
+}
+
+func (readCloser *testReadCloser) Close() error {
+	*readCloser.closed = true
+	return nil
+}
+
+func Test_runAcquirers(t *testing.T) {
+	working := &testAcquirer{name: "memory/test.raw", data: "memory"}
+	broken := &testAcquirer{name: "memory/broken.raw", err: errors.New("no driver")}
+	logger := &recordingLogger{}
+	collector := &Collector{Options: CollectOptions{Acquirers: []Acquirer{broken, working}, Logger: logger}, report: newReportBuilder()}
+	fileReaders := make(chan fileReader, 2)
+	if err := collector.runAcquirers(context.Background(), fileReaders); err != nil {
+		t.Fatalf("runAcquirers() error = %v", err)
+	}
+	close(fileReaders)
+
+	var got []string
+	for file := range fileReaders {
+		data, err := ioutil.ReadAll(file.reader)
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", file.fullPath, err)
+		}
+		if file.method != readMethodAcquired {
+			t.Errorf("runAcquirers() method = %v, want %v", file.method, readMethodAcquired)
+		}
+		got = append(got, file.fullPath+"="+string(data))
+	}
+	if len(got) != 1 || got[0] != "memory/test.raw=memory" {
+		t.Errorf("runAcquirers() sent %v, want only the working acquirer's capture", got)
+	}
+	if !working.closed {
+		t.Error("runAcquirers() didn't close the capture after reading it")
+	}
+	if working.logger != logger {
+		t.Error("runAcquirers() didn't hand the acquirer the collection's Logger")
+	}
+
+	report := collector.report.snapshot()
+	if len(report.Files) != 2 || report.Files[0].Status != "failed" || report.Files[1].Status != "collected" {
+		t.Errorf("snapshot() files = %+v, want the broken acquirer failed and the working one collected", report.Files)
+	}
+}
+
+func Test_isOutputName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "ipconfig", want: true},
+		{name: "run_once.v2", want: true},
+		{name: ""},
+		{name: ".."},
+		{name: `..\run`},
+		{name: "run/once"},
+		{name: "run:stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOutputName(tt.name); got != tt.want {
+				t.Errorf("isOutputName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"golang.org/x/sys/windows/registry"
+	"strings"
+	"sync"
+	"time"
+)
+
+const warningsFileName = "warnings.json"
+
+// The anti-forensic indicators reported in warnings.json.
+const (
+	IndicatorTimestomp         = "timestomp"
+	IndicatorUsnJournalMissing = "usn_journal_missing"
+	IndicatorPrefetchDisabled  = "prefetch_disabled"
+	IndicatorEventLogCleared   = "event_log_cleared"
+)
+
+// emptyEventLogSize is the size of an event log with nothing in it, the file header plus one chunk. A log that is no
+// bigger than this has most likely been cleared.
+const emptyEventLogSize = 0x11000
+
+// maxTimestompWarnings caps how many files per volume get a timestomp warning of their own, since software installs
+// can produce a lot of them. The rest are counted in one more warning.
+const maxTimestompWarnings = 100
+
+// Warning is an anti-forensic indicator spotted while collecting. None of them prove anything on their own, they point
+// a responder at what to look at first.
+type Warning struct {
+	Indicator string `json:"indicator"`
+	Volume    string `json:"volume,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Detail    string `json:"detail"`
+}
+
+// eventLogsToWatch are the logs that get cleared to cover tracks, by file name.
+var eventLogsToWatch = map[string]bool{
+	"security.evtx":    true,
+	"system.evtx":      true,
+	"application.evtx": true,
+	"microsoft-windows-powershell%4operational.evtx": true,
+	"windows powershell.evtx":                        true,
+}
+
+// warningCollector gathers warnings from every volume. Like the reportBuilder its methods do nothing when it's nil.
+type warningCollector struct {
+	mutex    sync.Mutex
+	warnings []Warning
+}
+
+func newWarningCollector(enabled bool) *warningCollector {
+	if !enabled {
+		return nil
+	}
+	return &warningCollector{warnings: make([]Warning, 0)}
+}
+
+func (collector *warningCollector) add(warnings ...Warning) {
+	if collector == nil {
+		return
+	}
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.warnings = append(collector.warnings, warnings...)
+}
+
+func (collector *warningCollector) snapshot() (warnings []Warning) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	warnings = append(make([]Warning, 0, len(collector.warnings)), collector.warnings...)
+	return
+}
+
+// fileRecordNote is a file the inspector wants to report, kept until the directory tree is resolved and its path known.
+type fileRecordNote struct {
+	parent uint32
+	name   string
+	size   int64
+	detail string
+}
+
+// mftInspector looks at every file record during the MFT walk for signs of anti-forensics. Like the reportBuilder its
+// methods do nothing when it's nil, so the walk doesn't need to check whether warnings were asked for.
+type mftInspector struct {
+	volumeLetter      string
+	timestomped       []fileRecordNote
+	timestompOverflow int
+	eventLogs         []fileRecordNote
+	prefetchFiles     map[uint32]int // number of .pf files in each directory
+	usnJournalFound   bool
+}
+
+func newMftInspector(volumeLetter string) *mftInspector {
+	return &mftInspector{
+		volumeLetter:  volumeLetter,
+		prefetchFiles: make(map[uint32]int),
+	}
+}
+
+// inspectRecord checks a single file record.
+func (inspector *mftInspector) inspectRecord(recordHeader mft.RecordHeader, fileNameAttributes mft.FileNameAttributes, standardInformation mft.StandardInformationAttribute, dataAttribute mft.DataAttribute) {
+	if inspector == nil || recordHeader.Flags.FlagDeleted {
+		return
+	}
+	fileName, found := longFileName(fileNameAttributes)
+	if !found {
+		return
+	}
+	name := strings.ToLower(fileName.FileName)
+
+	switch {
+	case name == "$usnjrnl":
+		inspector.usnJournalFound = true
+	case strings.HasSuffix(name, ".pf"):
+		inspector.prefetchFiles[fileName.ParentDirRecordNumber]++
+	case eventLogsToWatch[name]:
+		size := int64(len(dataAttribute.ResidentDataAttribute))
+		for _, dataRun := range dataAttribute.NonResidentDataAttribute.DataRuns {
+			size += dataRun.Length
+		}
+		inspector.eventLogs = append(inspector.eventLogs, fileRecordNote{parent: fileName.ParentDirRecordNumber, name: name, size: size})
+	}
+
+	if detail := timestompDetail(standardInformation, fileName); detail != "" {
+		if len(inspector.timestomped) < maxTimestompWarnings {
+			inspector.timestomped = append(inspector.timestomped, fileRecordNote{parent: fileName.ParentDirRecordNumber, name: name, detail: detail})
+		} else {
+			inspector.timestompOverflow++
+		}
+	}
+}
+
+// longFileName returns the Win32 or POSIX name of a file rather than its 8.3 name.
+func longFileName(fileNameAttributes mft.FileNameAttributes) (fileName mft.FileNameAttribute, found bool) {
+	if longNames := longFileNames(fileNameAttributes); len(longNames) != 0 {
+		return longNames[0], true
+	}
+	return
+}
+
+// timestompDetail describes why a file's $STANDARD_INFORMATION timestamps look set by hand, or returns nothing. Tools
+// that change timestamps write $SI, which user mode can reach, but not $FN. A file can't have been created before its
+// $FN says it was, and a tool setting $SI usually leaves its fractions of a second at zero.
+func timestompDetail(standardInformation mft.StandardInformationAttribute, fileName mft.FileNameAttribute) string {
+	if standardInformation.SiCreated.IsZero() || fileName.FnCreated.IsZero() {
+		return ""
+	}
+	if fileName.FnCreated.Sub(standardInformation.SiCreated) > time.Second {
+		return fmt.Sprintf("$SI created %s is before $FN created %s", standardInformation.SiCreated.UTC().Format(time.RFC3339Nano), fileName.FnCreated.UTC().Format(time.RFC3339Nano))
+	}
+	if standardInformation.SiCreated.Nanosecond() == 0 && standardInformation.SiModified.Nanosecond() == 0 && fileName.FnCreated.Nanosecond() != 0 {
+		return fmt.Sprintf("$SI created %s and modified %s have no fractions of a second, $FN created %s does", standardInformation.SiCreated.UTC().Format(time.RFC3339), standardInformation.SiModified.UTC().Format(time.RFC3339), fileName.FnCreated.UTC().Format(time.RFC3339Nano))
+	}
+	return ""
+}
+
+// finish turns what the inspector saw into warnings once the directory tree has been resolved. The checks for the
+// journal, prefetch and event logs only apply to a volume with Windows installed on it.
+func (inspector *mftInspector) finish(directoryTree mft.DirectoryTree) (warnings []Warning) {
+	if inspector == nil {
+		return
+	}
+	directories := make(map[string]uint32)
+	for recordNumber, path := range directoryTree {
+		directories[strings.ToLower(path)] = recordNumber
+	}
+	pathOf := func(note fileRecordNote) string {
+		return strings.ToLower(directoryTree[note.parent]) + `\` + note.name
+	}
+
+	for _, note := range inspector.timestomped {
+		warnings = append(warnings, Warning{Indicator: IndicatorTimestomp, Volume: inspector.volumeLetter, Path: pathOf(note), Detail: note.detail})
+	}
+	if inspector.timestompOverflow > 0 {
+		warnings = append(warnings, Warning{
+			Indicator: IndicatorTimestomp,
+			Volume:    inspector.volumeLetter,
+			Detail:    fmt.Sprintf("%d more files have timestamps that look set by hand", inspector.timestompOverflow),
+		})
+	}
+
+	windowsDirectory := strings.ToLower(inspector.volumeLetter) + `:\windows`
+	if _, found := directories[windowsDirectory]; !found {
+		return
+	}
+	if !inspector.usnJournalFound {
+		warnings = append(warnings, Warning{
+			Indicator: IndicatorUsnJournalMissing,
+			Volume:    inspector.volumeLetter,
+			Path:      strings.ToLower(inspector.volumeLetter) + `:\$extend\$usnjrnl`,
+			Detail:    "the system volume has no change journal, it may have been deleted with 'fsutil usn deletejournal'",
+		})
+	}
+	prefetchDirectory := windowsDirectory + `\prefetch`
+	if recordNumber, found := directories[prefetchDirectory]; !found || inspector.prefetchFiles[recordNumber] == 0 {
+		warnings = append(warnings, Warning{
+			Indicator: IndicatorPrefetchDisabled,
+			Volume:    inspector.volumeLetter,
+			Path:      prefetchDirectory,
+			Detail:    "there are no prefetch files, prefetching may have been disabled or the files deleted (it is off by default on Windows Server)",
+		})
+	}
+	logsDirectory := windowsDirectory + `\system32\winevt\logs`
+	for _, note := range inspector.eventLogs {
+		if strings.ToLower(directoryTree[note.parent]) != logsDirectory || note.size > emptyEventLogSize {
+			continue
+		}
+		warnings = append(warnings, Warning{
+			Indicator: IndicatorEventLogCleared,
+			Volume:    inspector.volumeLetter,
+			Path:      pathOf(note),
+			Detail:    fmt.Sprintf("the log takes up %d bytes, no more than an empty log, it may have been cleared", note.size),
+		})
+	}
+	return
+}
+
+// prefetcherSetting reads EnablePrefetcher from the registry, where 0 means prefetching is turned off. It's a variable
+// so tests don't depend on the host.
+var prefetcherSetting = func() (value uint64, found bool) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager\Memory Management\PrefetchParameters`, registry.QUERY_VALUE)
+	if err != nil {
+		return
+	}
+	defer key.Close()
+	value, _, err = key.GetIntegerValue("EnablePrefetcher")
+	found = err == nil
+	return
+}
+
+// hostWarnings checks the host's settings for anti-forensics.
+func hostWarnings() (warnings []Warning) {
+	if value, found := prefetcherSetting(); found && value == 0 {
+		warnings = append(warnings, Warning{
+			Indicator: IndicatorPrefetchDisabled,
+			Path:      `HKLM\SYSTEM\CurrentControlSet\Control\Session Manager\Memory Management\PrefetchParameters\EnablePrefetcher`,
+			Detail:    "EnablePrefetcher is 0, prefetching is turned off",
+		})
+	}
+	return
+}
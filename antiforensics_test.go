@@ -0,0 +1,167 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_timestompDetail(t *testing.T) {
+	fnCreated := time.Date(2020, 3, 1, 10, 0, 0, 123456700, time.UTC)
+	tests := []struct {
+		name                string
+		standardInformation mft.StandardInformationAttribute
+		want                bool
+	}{
+		{
+			name:                "untouched",
+			standardInformation: mft.StandardInformationAttribute{SiCreated: fnCreated, SiModified: fnCreated.Add(time.Hour)},
+			want:                false,
+		},
+		{
+			name:                "created before $FN",
+			standardInformation: mft.StandardInformationAttribute{SiCreated: fnCreated.AddDate(-2, 0, 0), SiModified: fnCreated.Add(time.Hour)},
+			want:                true,
+		},
+		{
+			name:                "whole seconds",
+			standardInformation: mft.StandardInformationAttribute{SiCreated: time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC), SiModified: time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)},
+			want:                true,
+		},
+		{
+			name:                "only created in whole seconds",
+			standardInformation: mft.StandardInformationAttribute{SiCreated: time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC), SiModified: fnCreated.Add(time.Hour)},
+			want:                false,
+		},
+		{
+			name:                "no timestamps",
+			standardInformation: mft.StandardInformationAttribute{},
+			want:                false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := timestompDetail(tt.standardInformation, mft.FileNameAttribute{FnCreated: fnCreated})
+			if (got != "") != tt.want {
+				t.Errorf("timestompDetail() = '%s', want a detail %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mftInspector(t *testing.T) {
+	const (
+		root = iota + 5
+		windows
+		prefetch
+		logs
+	)
+	directoryTree := mft.DirectoryTree{
+		root:     `C:`,
+		windows:  `C:\Windows`,
+		prefetch: `C:\Windows\Prefetch`,
+		logs:     `C:\Windows\System32\winevt\Logs`,
+	}
+	created := time.Date(2020, 3, 1, 10, 0, 0, 123456700, time.UTC)
+	record := func(parent uint32, name string, siCreated time.Time, size int64) (mft.RecordHeader, mft.FileNameAttributes, mft.StandardInformationAttribute, mft.DataAttribute) {
+		fileNameAttributes := mft.FileNameAttributes{
+			{FileName: "SHORT~1", FileNamespace: "DOS", ParentDirRecordNumber: parent, FnCreated: created},
+			{FileName: name, FileNamespace: "WIN32", ParentDirRecordNumber: parent, FnCreated: created},
+		}
+		dataAttribute := mft.DataAttribute{NonResidentDataAttribute: mft.NonResidentDataAttribute{DataRuns: mft.DataRuns{0: {Length: size}}}}
+		return mft.RecordHeader{}, fileNameAttributes, mft.StandardInformationAttribute{SiCreated: siCreated, SiModified: created}, dataAttribute
+	}
+	tests := []struct {
+		name           string
+		files          func(inspector *mftInspector)
+		wantIndicators map[string][]string
+	}{
+		{
+			name: "clean system volume",
+			files: func(inspector *mftInspector) {
+				inspector.inspectRecord(record(11, "$UsnJrnl", created, 0))
+				inspector.inspectRecord(record(prefetch, "CMD.EXE-0BD30981.pf", created, 4096))
+				inspector.inspectRecord(record(logs, "Security.evtx", created, 20*1024*1024))
+			},
+			wantIndicators: map[string][]string{},
+		},
+		{
+			name: "everything wiped",
+			files: func(inspector *mftInspector) {
+				inspector.inspectRecord(record(logs, "Security.evtx", created, emptyEventLogSize))
+				inspector.inspectRecord(record(logs, "Setup.evtx", created, emptyEventLogSize))
+				inspector.inspectRecord(record(windows, "evil.exe", created.AddDate(-3, 0, 0), 4096))
+			},
+			wantIndicators: map[string][]string{
+				IndicatorTimestomp:         {`c:\windows\evil.exe`},
+				IndicatorUsnJournalMissing: {`c:\$extend\$usnjrnl`},
+				IndicatorPrefetchDisabled:  {`c:\windows\prefetch`},
+				IndicatorEventLogCleared:   {`c:\windows\system32\winevt\logs\security.evtx`},
+			},
+		},
+		{
+			name: "deleted records are ignored",
+			files: func(inspector *mftInspector) {
+				header, fileNameAttributes, standardInformation, dataAttribute := record(root, "$UsnJrnl", created, 0)
+				header.Flags.FlagDeleted = true
+				inspector.inspectRecord(header, fileNameAttributes, standardInformation, dataAttribute)
+				inspector.inspectRecord(record(prefetch, "CMD.EXE-0BD30981.pf", created, 4096))
+			},
+			wantIndicators: map[string][]string{
+				IndicatorUsnJournalMissing: {`c:\$extend\$usnjrnl`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspector := newMftInspector("C")
+			tt.files(inspector)
+			got := make(map[string][]string)
+			for _, warning := range inspector.finish(directoryTree) {
+				got[warning.Indicator] = append(got[warning.Indicator], warning.Path)
+			}
+			if !reflect.DeepEqual(got, tt.wantIndicators) {
+				t.Errorf("finish() = %v, want %v", got, tt.wantIndicators)
+			}
+		})
+	}
+}
+
+func Test_mftInspector_notASystemVolume(t *testing.T) {
+	inspector := newMftInspector("D")
+	got := inspector.finish(mft.DirectoryTree{5: `D:`, 40: `D:\Data`})
+	if len(got) != 0 {
+		t.Errorf("finish() = %v, want no warnings for a volume without Windows", got)
+	}
+
+	var nilInspector *mftInspector
+	nilInspector.inspectRecord(mft.RecordHeader{}, nil, mft.StandardInformationAttribute{}, mft.DataAttribute{})
+	if got := nilInspector.finish(mft.DirectoryTree{}); got != nil {
+		t.Errorf("finish() on a nil inspector = %v, want nil", got)
+	}
+}
+
+func Test_hostWarnings(t *testing.T) {
+	defer func(original func() (uint64, bool)) { prefetcherSetting = original }(prefetcherSetting)
+	tests := []struct {
+		name  string
+		value uint64
+		found bool
+		want  int
+	}{
+		{name: "prefetch enabled", value: 3, found: true, want: 0},
+		{name: "prefetch disabled", value: 0, found: true, want: 1},
+		{name: "no setting", value: 0, found: false, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefetcherSetting = func() (uint64, bool) { return tt.value, tt.found }
+			if got := hostWarnings(); len(got) != tt.want {
+				t.Errorf("hostWarnings() = %v, want %d warnings", got, tt.want)
+			}
+		})
+	}
+}
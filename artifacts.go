@@ -0,0 +1,244 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ForensicArtifact is an artifact definition in the ForensicArtifacts format, as found in the artifacts.yaml files of
+// the digital-forensics-artifacts repository.
+type ForensicArtifact struct {
+	Name        string                   `yaml:"name"`
+	Doc         string                   `yaml:"doc"`
+	Sources     []ForensicArtifactSource `yaml:"sources"`
+	SupportedOS []string                 `yaml:"supported_os"`
+}
+
+// ForensicArtifactSource is one source of an artifact. Only the FILE, PATH and ARTIFACT_GROUP types are used, the others
+// describe data that isn't in files, such as registry values and WMI queries.
+type ForensicArtifactSource struct {
+	Type        string                     `yaml:"type"`
+	Attributes  ForensicArtifactAttributes `yaml:"attributes"`
+	SupportedOS []string                   `yaml:"supported_os"`
+}
+
+// ForensicArtifactAttributes holds the attributes of a source that are needed to find its files.
+type ForensicArtifactAttributes struct {
+	Paths     []string `yaml:"paths"`
+	Separator string   `yaml:"separator"`
+	Names     []string `yaml:"names"` // artifacts that an ARTIFACT_GROUP pulls in
+}
+
+// artifactVariables are the path variables that can be resolved without a knowledge base. Everything in a user profile
+// goes through %user% so it matches every profile.
+var artifactVariables = map[string]string{
+	"environ_systemdrive":     `C:`,
+	"environ_systemroot":      `C:\Windows`,
+	"environ_windir":          `C:\Windows`,
+	"environ_programfiles":    `C:\Program Files`,
+	"environ_programfilesx86": `C:\Program Files (x86)`,
+	"environ_programdata":     `C:\ProgramData`,
+	"environ_allusersappdata": `C:\ProgramData`,
+	"environ_allusersprofile": `C:\ProgramData`,
+	"users.homedir":           `C:\Users\%user%`,
+	"users.userprofile":       `C:\Users\%user%`,
+	"users.appdata":           `C:\Users\%user%\AppData\Roaming`,
+	"users.localappdata":      `C:\Users\%user%\AppData\Local`,
+	"users.localappdata_low":  `C:\Users\%user%\AppData\LocalLow`,
+	"users.temp":              `C:\Users\%user%\AppData\Local\Temp`,
+	"users.internet_cache":    `C:\Users\%user%\AppData\Local\Microsoft\Windows\INetCache`,
+	"users.recent":            `C:\Users\%user%\AppData\Roaming\Microsoft\Windows\Recent`,
+	"users.startup":           `C:\Users\%user%\AppData\Roaming\Microsoft\Windows\Start Menu\Programs\Startup`,
+	"users.desktop":           `C:\Users\%user%\Desktop`,
+	"users.personal":          `C:\Users\%user%\Documents`,
+	"users.cookies":           `C:\Users\%user%\AppData\Roaming\Microsoft\Windows\Cookies`,
+	"users.username":          `%user%`,
+	"environ_temp":            `C:\Windows\Temp`,
+	"environ_programfilesx64": `C:\Program Files`,
+}
+
+var (
+	artifactVariablePattern  = regexp.MustCompile(`%%([^%]+)%%`)
+	artifactRecursivePattern = regexp.MustCompile(`^\*\*\d*$`)
+)
+
+// ParseForensicArtifacts parses a ForensicArtifacts YAML file, which holds one artifact per YAML document.
+func ParseForensicArtifacts(data []byte) (artifacts []ForensicArtifact, err error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var artifact ForensicArtifact
+		err = decoder.Decode(&artifact)
+		if err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			err = fmt.Errorf("ParseForensicArtifacts() failed to parse artifact %d: %w", len(artifacts)+1, err)
+			return
+		}
+		if artifact.Name == "" {
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return
+}
+
+// LoadForensicArtifacts reads the ForensicArtifacts definitions in path, a single YAML file or a directory of them such
+// as the data directory of the digital-forensics-artifacts repository, and converts the FILE and PATH sources of the
+// artifacts in names into a ListOfFilesToExport. ARTIFACT_GROUP sources are resolved against every loaded definition.
+// Without names every Windows artifact is used. Paths go through the same conversion as KAPE targets, and a "**"
+// right before the file name matches any number of directories rather than the three the format defaults to. Names
+// that weren't loaded and paths using variables that need a knowledge base to resolve are returned in skipped, while
+// sources other than FILE, PATH, DIRECTORY and ARTIFACT_GROUP, such as registry keys, are left out.
+func LoadForensicArtifacts(path string, names []string) (exportList ListOfFilesToExport, skipped []SkippedTarget, err error) {
+	artifacts := make(map[string]ForensicArtifact)
+	var loaded []string
+	err = filepath.Walk(path, func(filePath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		extension := strings.ToLower(filepath.Ext(filePath))
+		if info.IsDir() || (extension != ".yaml" && extension != ".yml") {
+			return nil
+		}
+		data, readErr := ioutil.ReadFile(filePath)
+		if readErr != nil {
+			return readErr
+		}
+		parsed, parseErr := ParseForensicArtifacts(data)
+		if parseErr != nil {
+			skipped = append(skipped, SkippedTarget{Target: info.Name(), Reason: parseErr.Error()})
+			return nil
+		}
+		for _, artifact := range parsed {
+			if _, found := artifacts[artifact.Name]; !found {
+				loaded = append(loaded, artifact.Name)
+			}
+			artifacts[artifact.Name] = artifact
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("LoadForensicArtifacts() failed to read %s: %w", path, err)
+		return
+	}
+
+	if len(names) == 0 {
+		for _, name := range loaded {
+			if supportsWindows(artifacts[name].SupportedOS) {
+				names = append(names, name)
+			}
+		}
+	}
+	seen := make(map[FileToExport]bool)
+	for _, name := range names {
+		if _, found := artifacts[name]; !found {
+			skipped = append(skipped, SkippedTarget{Target: name, Reason: "no artifact with this name was loaded"})
+			continue
+		}
+		skipped = append(skipped, expandForensicArtifact(name, artifacts, make(map[string]bool), seen, &exportList)...)
+	}
+	return
+}
+
+// expandForensicArtifact adds an artifact's files to exportList, following artifact groups. It works the same way as
+// expandKapeTarget.
+func expandForensicArtifact(name string, artifacts map[string]ForensicArtifact, resolving map[string]bool, seen map[FileToExport]bool, exportList *ListOfFilesToExport) (skipped []SkippedTarget) {
+	resolving[name] = true
+	defer delete(resolving, name)
+	for _, source := range artifacts[name].Sources {
+		if !supportsWindows(source.SupportedOS) {
+			continue
+		}
+		switch strings.ToUpper(source.Type) {
+		case "ARTIFACT_GROUP":
+			for _, reference := range source.Attributes.Names {
+				if _, found := artifacts[reference]; !found {
+					skipped = append(skipped, SkippedTarget{Target: reference, Reason: fmt.Sprintf("referenced by %s but not found", name)})
+				} else if resolving[reference] {
+					skipped = append(skipped, SkippedTarget{Target: reference, Reason: fmt.Sprintf("references itself through %s", name)})
+				} else {
+					skipped = append(skipped, expandForensicArtifact(reference, artifacts, resolving, seen, exportList)...)
+				}
+			}
+		case "FILE", "PATH", "DIRECTORY":
+			isDirectory := strings.ToUpper(source.Type) != "FILE"
+			for _, path := range source.Attributes.Paths {
+				fileToExport, convertErr := artifactPathToFileToExport(path, source.Attributes.Separator, isDirectory)
+				if convertErr != nil {
+					skipped = append(skipped, SkippedTarget{Target: fmt.Sprintf("%s (%s)", path, name), Reason: convertErr.Error()})
+					continue
+				}
+				if !seen[fileToExport] {
+					seen[fileToExport] = true
+					*exportList = append(*exportList, fileToExport)
+				}
+			}
+		}
+	}
+	return
+}
+
+// artifactPathToFileToExport converts a single artifact path. The paths of PATH sources are directories, and everything
+// directly in them is collected.
+func artifactPathToFileToExport(path string, separator string, isDirectory bool) (fileToExport FileToExport, err error) {
+	var unresolved string
+	path = artifactVariablePattern.ReplaceAllStringFunc(path, func(variable string) string {
+		value, found := artifactVariables[strings.ToLower(strings.Trim(variable, "%"))]
+		if !found {
+			unresolved = variable
+		}
+		return value
+	})
+	if unresolved != "" {
+		err = fmt.Errorf("path uses %s, which can't be resolved from the MFT", unresolved)
+		return
+	}
+	if separator != "" && separator != `\` {
+		path = strings.ReplaceAll(path, separator, `\`)
+	}
+
+	segments := strings.Split(strings.TrimRight(path, `\`), `\`)
+	fileMask := "*"
+	if !isDirectory {
+		fileMask = segments[len(segments)-1]
+		segments = segments[:len(segments)-1]
+	}
+	recursive := false
+	if artifactRecursivePattern.MatchString(fileMask) {
+		fileMask = "*"
+		recursive = true
+	} else if len(segments) > 0 && artifactRecursivePattern.MatchString(segments[len(segments)-1]) {
+		segments = segments[:len(segments)-1]
+		recursive = true
+	}
+	for _, segment := range segments {
+		if strings.Contains(segment, "**") {
+			err = fmt.Errorf("path '%s' uses ** somewhere other than right before the file name", path)
+			return
+		}
+	}
+	return globToFileToExport(strings.Join(segments, `\`), fileMask, recursive)
+}
+
+// supportsWindows reports whether a supported_os list includes Windows. An empty list supports every OS.
+func supportsWindows(supportedOS []string) bool {
+	if len(supportedOS) == 0 {
+		return true
+	}
+	for _, name := range supportedOS {
+		if strings.EqualFold(name, "Windows") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_artifactPathToFileToExport(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		separator   string
+		isDirectory bool
+		want        FileToExport
+		wantMatch   []string
+		wantMiss    []string
+		wantErr     bool
+	}{
+		{
+			name: "literal file",
+			path: `%%environ_systemroot%%\System32\config\SAM`,
+			want: FileToExport{FullPath: `%SYSTEMDRIVE%:\Windows\System32\config\SAM`, FileName: "SAM"},
+		},
+		{
+			name:      "user profiles",
+			path:      `%%users.appdata%%\Microsoft\Windows\Recent\*.lnk`,
+			wantMatch: []string{`c:\users\alice\appdata\roaming\microsoft\windows\recent\report.docx.lnk`},
+			wantMiss:  []string{`c:\users\alice\appdata\roaming\microsoft\windows\recent\customdestinations\report.lnk`},
+		},
+		{
+			name:        "directory",
+			path:        `%%environ_systemroot%%\Prefetch`,
+			isDirectory: true,
+			wantMatch:   []string{`c:\windows\prefetch\cmd.exe-0bd30981.pf`},
+			wantMiss:    []string{`c:\windows\prefetch\readyboot\trace1.fx`},
+		},
+		{
+			name:      "recursive before the file name",
+			path:      `%%users.homedir%%\**5\*.exe`,
+			wantMatch: []string{`c:\users\bob\downloads\tools\psexec.exe`, `c:\users\bob\setup.exe`},
+			wantMiss:  []string{`c:\users\bob\downloads\notes.txt`},
+		},
+		{
+			name:      "recursive file name",
+			path:      `%%environ_systemroot%%\Tasks\**`,
+			wantMatch: []string{`c:\windows\tasks\backup.job`, `c:\windows\tasks\microsoft\update.job`},
+		},
+		{
+			name:      "forward slash separator",
+			path:      `%%environ_systemdrive%%/$Recycle.Bin/*/$I*`,
+			separator: "/",
+			wantMatch: []string{`c:\$recycle.bin\s-1-5-21-1000\$i4f2a1c.txt`},
+		},
+		{
+			name:    "variable needing a knowledge base",
+			path:    `%%users.sid%%\ntuser.dat`,
+			wantErr: true,
+		},
+		{
+			name:    "recursion in the middle",
+			path:    `%%users.homedir%%\**\Temp\*.exe`,
+			wantErr: true,
+		},
+		{
+			name:    "alternate data stream",
+			path:    `%%environ_systemdrive%%\$Extend\$UsnJrnl:$J`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := artifactPathToFileToExport(tt.path, tt.separator, tt.isDirectory)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("artifactPathToFileToExport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want != (FileToExport{}) && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("artifactPathToFileToExport() = %+v, want %+v", got, tt.want)
+			}
+			got = ExpandUserProfiles(ListOfFilesToExport{got}, nil)[0]
+			got.FullPath = strings.Replace(got.FullPath, "%SYSTEMDRIVE%", "c", 1)
+			terms, err := compileSearchTerms(got)
+			if err != nil {
+				t.Fatalf("compileSearchTerms() error = %v for %+v", err, got)
+			}
+			for _, path := range tt.wantMatch {
+				if !kapeTermMatches(terms, path) {
+					t.Errorf("%+v doesn't match %s", got, path)
+				}
+			}
+			for _, path := range tt.wantMiss {
+				if kapeTermMatches(terms, path) {
+					t.Errorf("%+v matches %s", got, path)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadForensicArtifacts(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-artifacts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	definitions := `name: WindowsTriage
+doc: Triage collection.
+sources:
+- type: ARTIFACT_GROUP
+  attributes:
+    names: [WindowsSystemRegistryFiles, WindowsEventLogs, DoesNotExist]
+supported_os: [Windows]
+---
+name: WindowsSystemRegistryFiles
+doc: Windows system Registry files.
+sources:
+- type: FILE
+  attributes:
+    paths:
+    - '%%environ_systemroot%%\System32\config\SYSTEM'
+    - '%%environ_systemroot%%\System32\config\SOFTWARE'
+    separator: '\'
+- type: REGISTRY_KEY
+  attributes:
+    keys: ['HKEY_LOCAL_MACHINE\System\CurrentControlSet\Services\*']
+supported_os: [Windows]
+---
+name: WindowsEventLogs
+doc: Windows Event logs.
+sources:
+- type: FILE
+  attributes:
+    paths: ['%%environ_systemroot%%\System32\winevt\Logs\*.evtx']
+    separator: '\'
+- type: FILE
+  attributes:
+    paths: ['%%environ_systemroot%%\System32\config\SysEvent.Evt']
+    separator: '\'
+  supported_os: [Linux]
+supported_os: [Windows]
+---
+name: LinuxAuthLogs
+doc: Linux authentication logs.
+sources:
+- type: FILE
+  attributes:
+    paths: ['/var/log/auth.log*']
+supported_os: [Linux]
+`
+	if err = ioutil.WriteFile(filepath.Join(directory, "windows.yaml"), []byte(definitions), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(directory, "broken.yaml"), []byte("name: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		names       []string
+		wantPaths   []string
+		wantSkipped []string
+	}{
+		{
+			name:  "group",
+			names: []string{"WindowsTriage", "Nope"},
+			wantPaths: []string{
+				`%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`,
+				`%SYSTEMDRIVE%:\Windows\System32\config\SOFTWARE`,
+				`%SYSTEMDRIVE%:\\Windows\\System32\\winevt\\Logs\\[^\\]*\.evtx$`,
+			},
+			wantSkipped: []string{"broken.yaml", "DoesNotExist", "Nope"},
+		},
+		{
+			name:  "every Windows artifact",
+			names: nil,
+			wantPaths: []string{
+				`%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`,
+				`%SYSTEMDRIVE%:\Windows\System32\config\SOFTWARE`,
+				`%SYSTEMDRIVE%:\\Windows\\System32\\winevt\\Logs\\[^\\]*\.evtx$`,
+			},
+			wantSkipped: []string{"broken.yaml", "DoesNotExist"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exportList, skipped, err := LoadForensicArtifacts(directory, tt.names)
+			if err != nil {
+				t.Fatalf("LoadForensicArtifacts() error = %v", err)
+			}
+			var gotPaths []string
+			for _, fileToExport := range exportList {
+				gotPaths = append(gotPaths, fileToExport.FullPath)
+			}
+			if !reflect.DeepEqual(gotPaths, tt.wantPaths) {
+				t.Errorf("LoadForensicArtifacts() paths = %v, want %v", gotPaths, tt.wantPaths)
+			}
+			var gotSkipped []string
+			for _, skip := range skipped {
+				gotSkipped = append(gotSkipped, skip.Target)
+			}
+			if !reflect.DeepEqual(gotSkipped, tt.wantSkipped) {
+				t.Errorf("LoadForensicArtifacts() skipped = %+v, want %v", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const auditLogFileName = "audit.jsonl"
+
+// The events recorded in audit.jsonl.
+const (
+	AuditCollectionStarted = "collection_started"
+	AuditPrivilegeEnabled  = "privilege_enabled"
+	AuditVolumeOpened      = "volume_opened"   // a handle to the volume was opened for raw reads
+	AuditVolumeReopened    = "volume_reopened" // after a raw read failed
+	AuditVolumeFallback    = "volume_fallback" // the volume wasn't read raw, or its MFT wasn't found where the boot record says
+	AuditVolumeFailed      = "volume_failed"
+	AuditReadDecision      = "read_decision" // whether a file is read through the API or raw, and why
+	AuditFileCollected     = "file_collected"
+	AuditFileSkipped       = "file_skipped"
+	AuditFileFailed        = "file_failed"
+	AuditLogClosed         = "audit_log_closed"
+
+	// Only written as ETW events and to CollectOptions.OnAudit, since the collection ends after audit.jsonl is written
+	AuditCollectionFinished = "collection_finished"
+	AuditCollectionFailed   = "collection_failed"
+)
+
+// AuditEntry is a step of a collection as recorded in audit.jsonl, one per line in the order they happened.
+type AuditEntry struct {
+	Sequence int       `json:"sequence"`
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Volume   string    `json:"volume,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// auditLog records what a collection does for the chain of custody, apart from the log which is there to debug it.
+// Entries are encoded as they're recorded and can't be changed after, and none are taken once it's written into the
+// output. Each entry is also written as an ETW event when there's a provider, and handed to onEntry when it's set,
+// whether or not audit.jsonl is kept. Like the reportBuilder its methods do nothing when it's nil.
+type auditLog struct {
+	mutex    sync.Mutex
+	entries  bytes.Buffer
+	keep     bool // the entries go into audit.jsonl
+	etw      *etwProvider
+	onEntry  func(entry AuditEntry)
+	sequence int
+	closed   bool
+}
+
+func newAuditLog(enabled bool, etw *etwProvider, onEntry func(entry AuditEntry)) *auditLog {
+	if !enabled && etw == nil && onEntry == nil {
+		return nil
+	}
+	return &auditLog{keep: enabled, etw: etw, onEntry: onEntry}
+}
+
+func (audit *auditLog) record(event string, volumeLetter string, path string, detail string) {
+	if audit == nil {
+		return
+	}
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	if audit.closed && audit.etw == nil && audit.onEntry == nil {
+		return
+	}
+	audit.sequence++
+	entry := AuditEntry{Sequence: audit.sequence, Time: time.Now().UTC(), Event: event, Volume: volumeLetter, Path: path, Detail: detail}
+	audit.etw.write(entry)
+	if audit.onEntry != nil {
+		audit.onEntry(entry)
+	}
+	if audit.keep && !audit.closed {
+		_ = json.NewEncoder(&audit.entries).Encode(entry)
+	}
+}
+
+// finish records how the collection ended, which only goes out as an ETW event and to onEntry, and unregisters the ETW
+// provider.
+func (audit *auditLog) finish(report CollectionReport, err error) {
+	if audit == nil {
+		return
+	}
+	if err != nil {
+		audit.record(AuditCollectionFailed, "", "", err.Error())
+	} else {
+		audit.record(AuditCollectionFinished, "", "", fmt.Sprintf("%d of %d matched files collected, %d bytes read", report.FilesCollected, report.FilesMatched, report.BytesRead))
+	}
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	audit.closed = true
+	audit.etw.close()
+	audit.etw = nil
+	audit.onEntry = nil
+}
+
+// readDecision records how a found file is read and why, and why reading it raw failed when it was read some other way
+// after all.
+func (audit *auditLog) readDecision(volumeLetter string, path string, method string, why string, fallback string) {
+	detail := method
+	if why != "" {
+		detail += " since " + why
+	}
+	if fallback != "" {
+		detail += ", reading it raw failed: " + fallback
+	}
+	audit.record(AuditReadDecision, volumeLetter, path, detail)
+}
+
+// collectedDetail describes a file that was read to its end.
+func collectedDetail(bytesRead int64, method string) string {
+	if method == "" {
+		return fmt.Sprintf("%d bytes", bytesRead)
+	}
+	return fmt.Sprintf("%d bytes read %s", bytesRead, method)
+}
+
+// reader returns an io.Reader that closes the audit log when it is first read, so it covers every file written before
+// it.
+func (audit *auditLog) reader() io.Reader {
+	return &lazyReader{open: func() io.Reader {
+		audit.record(AuditLogClosed, "", "", "nothing after this is recorded, report.json follows")
+		audit.mutex.Lock()
+		defer audit.mutex.Unlock()
+		audit.closed = true
+		return bytes.NewReader(audit.entries.Bytes())
+	}}
+}
+
+// auditedPrivileges are the privileges that get the collector past the security of files and volumes.
+var auditedPrivileges = []string{"SeBackupPrivilege", "SeRestorePrivilege", "SeSecurityPrivilege", "SeManageVolumePrivilege", "SeDebugPrivilege"}
+
+// recordPrivileges records the audited privileges that are enabled on the process token and weren't before. It
+// returns every one that is enabled now.
+func (audit *auditLog) recordPrivileges(before map[string]bool, when string) (enabled map[string]bool) {
+	if audit == nil {
+		return
+	}
+	names, err := enabledPrivileges()
+	if err != nil {
+		audit.record(AuditPrivilegeEnabled, "", "", "could not read the privileges of the process token: "+err.Error())
+		return before
+	}
+	enabled = make(map[string]bool)
+	for _, name := range names {
+		enabled[name] = true
+		if !before[name] {
+			audit.record(AuditPrivilegeEnabled, "", "", name+" "+when)
+		}
+	}
+	return
+}
+
+// enabledPrivileges returns which of the audited privileges are enabled on the process token. It's a variable so tests
+// don't depend on the token they run with.
+var enabledPrivileges = func() (enabled []string, err error) {
+	token := windows.GetCurrentProcessToken()
+	var size uint32
+	_ = windows.GetTokenInformation(token, windows.TokenPrivileges, nil, 0, &size)
+	if size == 0 {
+		err = errors.New("GetTokenInformation() didn't say how big the token's privileges are")
+		return
+	}
+	buffer := make([]byte, size)
+	err = windows.GetTokenInformation(token, windows.TokenPrivileges, &buffer[0], size, &size)
+	if err != nil {
+		return
+	}
+	privileges := (*windows.Tokenprivileges)(unsafe.Pointer(&buffer[0])).AllPrivileges()
+	for _, name := range auditedPrivileges {
+		var luid windows.LUID
+		if windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr(name), &luid) != nil {
+			continue
+		}
+		for _, privilege := range privileges {
+			if privilege.Luid == luid && privilege.Attributes&windows.SE_PRIVILEGE_ENABLED != 0 {
+				enabled = append(enabled, name)
+			}
+		}
+	}
+	return
+}
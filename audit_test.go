@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// decodeAuditLog reads the entries of an audit.jsonl.
+func decodeAuditLog(t *testing.T, data []byte) (entries []AuditEntry) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var entry AuditEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("failed to decode the audit log: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func Test_auditLog(t *testing.T) {
+	var disabled *auditLog
+	disabled.record(AuditFileSkipped, "c", `c:\pagefile.sys`, "too big")
+	disabled.readDecision("c", `c:\pagefile.sys`, readMethodRaw, "Windows keeps it open while running", "")
+	if newAuditLog(false, nil, nil) != nil {
+		t.Errorf("newAuditLog(false, nil, nil) isn't nil")
+	}
+
+	audit := newAuditLog(true, nil, nil)
+	audit.readDecision("c", `c:\windows\system32\config\sam`, readMethodRaw, "the API couldn't open it: Access is denied.", "")
+	audit.readDecision("c", `c:\users\alice\ntuser.dat`, readMethodHiveExport, "", "a data run is past the end of the volume")
+	reader := audit.reader()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading the audit log failed: %v", err)
+	}
+	audit.record(AuditFileCollected, "c", `c:\late`, "after the audit log was written")
+
+	entries := decodeAuditLog(t, data)
+	want := []AuditEntry{
+		{Sequence: 1, Event: AuditReadDecision, Volume: "c", Path: `c:\windows\system32\config\sam`, Detail: "raw since the API couldn't open it: Access is denied."},
+		{Sequence: 2, Event: AuditReadDecision, Volume: "c", Path: `c:\users\alice\ntuser.dat`, Detail: "hive_export, reading it raw failed: a data run is past the end of the volume"},
+		{Sequence: 3, Event: AuditLogClosed, Detail: "nothing after this is recorded, report.json follows"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("the audit log has %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for index := range want {
+		if entries[index].Time.IsZero() {
+			t.Errorf("entry %d has no time", index)
+		}
+		entries[index].Time = want[index].Time
+		if entries[index] != want[index] {
+			t.Errorf("entry %d = %+v, want %+v", index, entries[index], want[index])
+		}
+	}
+}
+
+func Test_auditLog_recordPrivileges(t *testing.T) {
+	defer func(original func() ([]string, error)) { enabledPrivileges = original }(enabledPrivileges)
+	audit := newAuditLog(true, nil, nil)
+	enabledPrivileges = func() ([]string, error) { return []string{"SeSecurityPrivilege"}, nil }
+	before := audit.recordPrivileges(nil, "was enabled when the collection started")
+	enabledPrivileges = func() ([]string, error) { return []string{"SeSecurityPrivilege", "SeBackupPrivilege"}, nil }
+	audit.recordPrivileges(before, "was enabled during the collection")
+	enabledPrivileges = func() ([]string, error) { return nil, errors.New("access is denied") }
+	audit.recordPrivileges(before, "was enabled during the collection")
+
+	entries := decodeAuditLog(t, audit.entries.Bytes())
+	wantDetails := []string{
+		"SeSecurityPrivilege was enabled when the collection started",
+		"SeBackupPrivilege was enabled during the collection",
+		"could not read the privileges of the process token: access is denied",
+	}
+	if len(entries) != len(wantDetails) {
+		t.Fatalf("recordPrivileges() recorded %+v, want %v", entries, wantDetails)
+	}
+	for index, entry := range entries {
+		if entry.Event != AuditPrivilegeEnabled || entry.Detail != wantDetails[index] {
+			t.Errorf("recordPrivileges() recorded %+v, want %s", entry, wantDetails[index])
+		}
+	}
+}
+
+func TestCollect_auditLog(t *testing.T) {
+	defer func(original func() ([]string, error)) { enabledPrivileges = original }(enabledPrivileges)
+	enabledPrivileges = func() ([]string, error) { return nil, nil }
+	exportList := ListOfFilesToExport{{FullPath: `c:\$MFT`, FileName: `$MFT`}}
+	output := new(bytes.Buffer)
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	_, err := CollectWithReport(context.Background(), handler, exportList, &ZipResultWriter{ZipWriter: zip.NewWriter(output)}, CollectOptions{AuditLog: true})
+	if err != nil {
+		t.Fatalf("CollectWithReport() error = %v", err)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	for _, file := range zipReader.File {
+		if file.Name != auditLogFileName {
+			continue
+		}
+		reader, _ := file.Open()
+		defer reader.Close()
+		data, _ := ioutil.ReadAll(reader)
+		events := make(map[string]AuditEntry)
+		for index, entry := range decodeAuditLog(t, data) {
+			if entry.Sequence != index+1 {
+				t.Errorf("entry %+v is out of sequence, want %d", entry, index+1)
+			}
+			events[entry.Event] = entry
+		}
+		for _, event := range []string{AuditCollectionStarted, AuditVolumeOpened, AuditReadDecision, AuditFileCollected, AuditLogClosed} {
+			if _, found := events[event]; !found {
+				t.Errorf("the audit log has no %s entry: %q", event, data)
+			}
+		}
+		if decision := events[AuditReadDecision]; decision.Path != `c:\$mft` || decision.Detail != "raw since it's copied as it's read for the search" {
+			t.Errorf("the audit log's read decision = %+v, want $mft read raw for the search", decision)
+		}
+		return
+	}
+	t.Errorf("Collect() did not write %s into the output", auditLogFileName)
+}
+
+func TestCollect_onAudit(t *testing.T) {
+	defer func(original func() ([]string, error)) { enabledPrivileges = original }(enabledPrivileges)
+	enabledPrivileges = func() ([]string, error) { return nil, nil }
+	exportList := ListOfFilesToExport{{FullPath: `c:\$MFT`, FileName: `$MFT`}}
+	output := new(bytes.Buffer)
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	var entries []AuditEntry
+	options := CollectOptions{OnAudit: func(entry AuditEntry) { entries = append(entries, entry) }}
+	if err := Collect(context.Background(), handler, exportList, &ZipResultWriter{ZipWriter: zip.NewWriter(output)}, options); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if len(entries) < 2 || entries[0].Event != AuditCollectionStarted || entries[len(entries)-1].Event != AuditCollectionFinished {
+		t.Fatalf("OnAudit got %+v, want the collection starting through to it finishing", entries)
+	}
+	if !strings.HasPrefix(entries[len(entries)-1].Detail, "1 of 1 matched files collected") {
+		t.Errorf("the collection finished with %q, want 1 of 1 files collected", entries[len(entries)-1].Detail)
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	for _, file := range zipReader.File {
+		if file.Name == auditLogFileName {
+			t.Errorf("Collect() wrote %s without AuditLog", auditLogFileName)
+		}
+	}
+}
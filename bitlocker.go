@@ -0,0 +1,228 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// fileSystemBitLocker is what a volume boot record read from a volume BitLocker has locked looks like. Once a volume
+// is unlocked, the BitLocker filter driver sits below the \\.\C: device that volumes are opened through, so raw reads
+// of it get the decrypted NTFS volume and need nothing special.
+const fileSystemBitLocker = "BitLocker"
+
+// How BitLocker stands on a volume, as listed in its VolumeReport.
+const (
+	BitLockerOff                   = "off"
+	BitLockerUnlocked              = "unlocked"
+	BitLockerLocked                = "locked"
+	BitLockerUnlockedForCollection = "unlocked_for_collection" // it was locked, the collector unlocked it and locked it again afterwards
+	BitLockerUnknown               = "unknown"                 // not asked for, since manage-bde isn't run with a minimal footprint
+)
+
+// manageBDE runs the manage-bde tool that ships with Windows and returns what it printed. It's a variable so tests
+// don't need BitLocker.
+var manageBDE = func(ctx context.Context, args ...string) (output string, err error) {
+	combined, err := exec.CommandContext(ctx, "manage-bde.exe", args...).CombinedOutput()
+	output = string(combined)
+	if err != nil {
+		err = fmt.Errorf("manage-bde %s failed: %w: %s", args[0], err, strings.TrimSpace(output))
+	}
+	return
+}
+
+// encryptableVolumeNamespace and encryptableVolumeClass are the WMI class BitLocker volumes are managed through.
+const (
+	encryptableVolumeNamespace = `root\CIMV2\Security\MicrosoftVolumeEncryption`
+	encryptableVolumeClass     = "Win32_EncryptableVolume"
+)
+
+// The methods of Win32_EncryptableVolume that unlock and lock a volume.
+const (
+	unlockWithNumericalPassword = "UnlockWithNumericalPassword"
+	unlockWithExternalKey       = "UnlockWithExternalKey"
+	lockVolume                  = "Lock"
+)
+
+// callEncryptableVolume calls a method of a volume's Win32_EncryptableVolume. argument is the recovery password, or the
+// path of the .bek file BitLocker reads the recovery key out of, and goes to WMI as a parameter rather than on a
+// command line other processes can see. It's a variable so tests don't need BitLocker.
+var callEncryptableVolume = func(volumeLetter string, method string, argument string) (err error) {
+	err = withWMI(encryptableVolumeNamespace, func(services *comObject) (err error) {
+		path, err := instancePath(services, fmt.Sprintf("SELECT * FROM %s WHERE DriveLetter = '%s:'", encryptableVolumeClass, strings.ToUpper(volumeLetter)))
+		if err != nil {
+			return
+		}
+		if path == "" {
+			return errors.New("BitLocker doesn't manage the volume")
+		}
+		inputs := make(map[string]*variant)
+		switch method {
+		case unlockWithNumericalPassword:
+			password := stringVariant(argument)
+			defer password.clear()
+			inputs["NumericalPassword"] = &password
+		case unlockWithExternalKey:
+			keyFile := stringVariant(argument)
+			defer keyFile.clear()
+			keyOutputs, keyErr := execMethod(services, encryptableVolumeClass, path, "GetExternalKeyFromFile", map[string]*variant{"PathWithFileName": &keyFile})
+			if keyErr != nil {
+				return keyErr
+			}
+			key, keyErr := property(keyOutputs, "ExternalKey")
+			keyOutputs.release()
+			if keyErr != nil {
+				return keyErr
+			}
+			defer key.clear()
+			inputs["ExternalKey"] = &key
+		case lockVolume:
+			inputs["ForceDismount"] = &variant{vt: vtBool, data: 0xffff}
+		}
+		outputs, err := execMethod(services, encryptableVolumeClass, path, method, inputs)
+		outputs.release()
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("%s of volume %s failed: %w", method, volumeLetter, err)
+	}
+	return
+}
+
+// bitLockerStatus asks manage-bde how BitLocker stands on a volume.
+func bitLockerStatus(ctx context.Context, volumeLetter string) (status string, err error) {
+	output, err := manageBDE(ctx, "-status", volumeLetter+":")
+	if err != nil {
+		return
+	}
+	status = parseBitLockerStatus(output)
+	return
+}
+
+// parseBitLockerStatus reads the conversion and lock status out of what manage-bde -status printed for a volume. It's
+// empty when they aren't there.
+func parseBitLockerStatus(output string) (status string) {
+	var conversion, lock string
+	for _, line := range strings.Split(output, "\n") {
+		field := strings.SplitN(line, ":", 2)
+		if len(field) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(field[0]) {
+		case "Conversion Status":
+			conversion = strings.TrimSpace(field[1])
+		case "Lock Status":
+			lock = strings.TrimSpace(field[1])
+		}
+	}
+	switch {
+	case lock == "Locked":
+		return BitLockerLocked
+	case conversion == "Fully Decrypted":
+		return BitLockerOff
+	case conversion != "":
+		return BitLockerUnlocked
+	}
+	return ""
+}
+
+// bitLockerUnlocker unlocks the volumes BitLocker has locked with a recovery password or key through WMI, and locks
+// them again once the collection is done. Its methods are safe to call from several goroutines and do nothing on a nil
+// bitLockerUnlocker, which is what newBitLockerUnlocker returns when there is nothing to unlock with.
+type bitLockerUnlocker struct {
+	recoveryPassword string
+	recoveryKey      string
+	mutex            sync.Mutex
+	unlocked         []string
+}
+
+func newBitLockerUnlocker(recoveryPassword string, recoveryKey string) *bitLockerUnlocker {
+	if recoveryPassword == "" && recoveryKey == "" {
+		return nil
+	}
+	return &bitLockerUnlocker{recoveryPassword: recoveryPassword, recoveryKey: recoveryKey}
+}
+
+// unlock unlocks a volume with the recovery password, or the recovery key when there's no password or it doesn't work.
+func (unlocker *bitLockerUnlocker) unlock(logger Logger, volumeLetter string) (err error) {
+	if unlocker == nil {
+		err = &LockedVolumeError{Volume: volumeLetter}
+		return
+	}
+	logger.Infof("Volume %s is locked by BitLocker, unlocking it for the collection.", volumeLetter)
+	if unlocker.recoveryPassword != "" {
+		err = callEncryptableVolume(volumeLetter, unlockWithNumericalPassword, unlocker.recoveryPassword)
+	}
+	if unlocker.recoveryKey != "" && (unlocker.recoveryPassword == "" || err != nil) {
+		err = callEncryptableVolume(volumeLetter, unlockWithExternalKey, unlocker.recoveryKey)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to unlock volume %s: %w", volumeLetter, err)
+		return
+	}
+	unlocker.mutex.Lock()
+	defer unlocker.mutex.Unlock()
+	unlocker.unlocked = append(unlocker.unlocked, volumeLetter)
+	return
+}
+
+// unlockedVolume reports whether unlock unlocked a volume.
+func (unlocker *bitLockerUnlocker) unlockedVolume(volumeLetter string) bool {
+	if unlocker == nil {
+		return false
+	}
+	unlocker.mutex.Lock()
+	defer unlocker.mutex.Unlock()
+	for _, unlocked := range unlocker.unlocked {
+		if unlocked == volumeLetter {
+			return true
+		}
+	}
+	return false
+}
+
+// relock locks the volumes it unlocked again, leaving them as they were found. It's called once the result writer is
+// done, since the files it's handed are read from the volumes as they're written.
+func (unlocker *bitLockerUnlocker) relock(logger Logger) {
+	if unlocker == nil {
+		return
+	}
+	unlocker.mutex.Lock()
+	defer unlocker.mutex.Unlock()
+	for _, volumeLetter := range unlocker.unlocked {
+		if err := callEncryptableVolume(volumeLetter, lockVolume, ""); err != nil {
+			logger.Errorf("Failed to lock volume %s with BitLocker again after the collection: %v", volumeLetter, err)
+			continue
+		}
+		logger.Infof("Locked volume %s with BitLocker again.", volumeLetter)
+	}
+	unlocker.unlocked = nil
+}
+
+// recordBitLocker lists how BitLocker stands on a volume in the report. A status manage-bde can't give is left out,
+// and with a minimal footprint the status is unknown since manage-bde isn't run.
+func (collector *Collector) recordBitLocker(ctx context.Context, volumeLetter string) {
+	// BitLocker doesn't encrypt the EFI system partition, and manage-bde only knows volumes by their letters
+	if volumeLetter == espVolume {
+		return
+	}
+	status := BitLockerUnlockedForCollection
+	if collector.Options.MinimalFootprint {
+		status = BitLockerUnknown
+	} else if !collector.bitLocker.unlockedVolume(volumeLetter) {
+		var err error
+		status, err = bitLockerStatus(ctx, volumeLetter)
+		if err != nil {
+			collector.logger().Debugf("Could not get the BitLocker status of volume %s: %v", volumeLetter, err)
+			return
+		}
+	}
+	collector.report.updateVolume(volumeLetter, func(volume *VolumeReport) {
+		volume.BitLocker = status
+	})
+}
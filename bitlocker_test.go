@@ -0,0 +1,160 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseBitLockerStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "unlocked",
+			output: "Volume C: [OS]\r\n    Conversion Status:    Used Space Only Encrypted\r\n    Percentage Encrypted: 100.0%\r\n    Lock Status:          Unlocked\r\n",
+			want:   BitLockerUnlocked,
+		},
+		{
+			name:   "locked",
+			output: "Volume D: []\n    Conversion Status:    Unknown\n    Lock Status:          Locked\n",
+			want:   BitLockerLocked,
+		},
+		{
+			name:   "off",
+			output: "Volume C: [OS]\n    Conversion Status:    Fully Decrypted\n    Lock Status:          Unlocked\n",
+			want:   BitLockerOff,
+		},
+		{name: "not there", output: "ERROR: An attempt to access a required resource was denied."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseBitLockerStatus(tt.output); got != tt.want {
+				t.Errorf("parseBitLockerStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// unlockingHandler opens the locked volume boot record until unlocked is set, and the unlocked one after.
+type unlockingHandler struct {
+	locked   string
+	unlocked string
+	isOpen   *bool
+}
+
+func (handler unlockingHandler) GetHandle(volumeLetter string) (handle *os.File, err error) {
+	if *handler.isOpen {
+		return os.Open(handler.unlocked)
+	}
+	return os.Open(handler.locked)
+}
+
+func TestCollectWithReport_bitLocker(t *testing.T) {
+	directory, err := ioutil.TempDir("", "bitlocker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	locked, unlocked := directory+"/locked", directory+"/unlocked"
+	ioutil.WriteFile(locked, testBootRecord(0x03, "-FVE-FS-"), 0644)
+	ioutil.WriteFile(unlocked, testBootRecord(0x52, "FAT32   "), 0644)
+
+	isOpen := false
+	var calls []string
+	defer func(original func(ctx context.Context, args ...string) (string, error)) { manageBDE = original }(manageBDE)
+	manageBDE = func(ctx context.Context, args ...string) (output string, err error) {
+		calls = append(calls, "manage-bde "+strings.Join(args, " "))
+		return
+	}
+	defer func(original func(string, string, string) error) { callEncryptableVolume = original }(callEncryptableVolume)
+	callEncryptableVolume = func(volumeLetter string, method string, argument string) (err error) {
+		calls = append(calls, strings.TrimSpace(method+" "+volumeLetter+" "+argument))
+		switch method {
+		case unlockWithNumericalPassword:
+			err = errors.New("the password is not correct")
+		case unlockWithExternalKey:
+			isOpen = true
+		case lockVolume:
+			isOpen = false
+		}
+		return
+	}
+	defer func(original func(path string) (*os.File, error)) { openWithBackupSemantics = original }(openWithBackupSemantics)
+	openWithBackupSemantics = func(path string) (file *os.File, err error) {
+		return os.Open(unlocked)
+	}
+	targets := ListOfFilesToExport{{FullPath: `e:\evidence\notes.txt`, FileName: `notes.txt`}}
+
+	collection := NewCollector(CollectOptions{})
+	collection.volumes = unlockingHandler{locked: locked, unlocked: unlocked, isOpen: &isOpen}
+	report, err := collection.CollectWithReport(context.Background(), targets, &ZipResultWriter{ZipWriter: zip.NewWriter(new(bytes.Buffer))})
+	var lockedVolumeError *LockedVolumeError
+	if !errors.As(err, &lockedVolumeError) {
+		t.Errorf("Collector.CollectWithReport() error = %v, want a LockedVolumeError without a recovery password", err)
+	}
+	if len(report.Volumes) != 1 || report.Volumes[0].BitLocker != BitLockerLocked {
+		t.Errorf("Collector.CollectWithReport() report volumes = %+v, want e locked", report.Volumes)
+	}
+	if len(calls) != 0 {
+		t.Errorf("BitLocker was called with %q without a recovery password", calls)
+	}
+
+	collection.Options.BitLockerRecoveryPassword = "111111-222222-333333-444444-555555-666666-777777-888888"
+	collection.Options.BitLockerRecoveryKey = `f:\recovery.bek`
+	report, err = collection.CollectWithReport(context.Background(), targets, &ZipResultWriter{ZipWriter: zip.NewWriter(new(bytes.Buffer))})
+	if err != nil {
+		t.Fatalf("Collector.CollectWithReport() error = %v", err)
+	}
+	if report.FilesCollected != 1 || len(report.Volumes) != 1 || report.Volumes[0].BitLocker != BitLockerUnlockedForCollection {
+		t.Errorf("Collector.CollectWithReport() report = %+v, want notes.txt collected from e unlocked for the collection", report)
+	}
+	want := []string{
+		"UnlockWithNumericalPassword e 111111-222222-333333-444444-555555-666666-777777-888888",
+		`UnlockWithExternalKey e f:\recovery.bek`,
+		"Lock e",
+	}
+	if !reflect.DeepEqual(calls, want) || isOpen {
+		t.Errorf("BitLocker was called with %q, want %q", calls, want)
+	}
+}
+
+func TestCollector_recordBitLocker_minimalFootprint(t *testing.T) {
+	var calls []string
+	defer func(original func(ctx context.Context, args ...string) (string, error)) { manageBDE = original }(manageBDE)
+	manageBDE = func(ctx context.Context, args ...string) (output string, err error) {
+		calls = append(calls, strings.Join(args, " "))
+		return "Conversion Status:    Fully Decrypted\n", nil
+	}
+	tests := []struct {
+		name      string
+		options   CollectOptions
+		wantCalls int
+		want      string
+	}{
+		{name: "queried", options: CollectOptions{}, wantCalls: 1, want: BitLockerOff},
+		{name: "minimal footprint", options: CollectOptions{MinimalFootprint: true}, wantCalls: 0, want: BitLockerUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			collector := &Collector{Options: tt.options, report: newReportBuilder()}
+			collector.report.addVolume(VolumeHandler{VolumeLetter: "c"})
+			collector.recordBitLocker(context.Background(), "c")
+			report := collector.report.snapshot()
+			if len(calls) != tt.wantCalls || report.Volumes[0].BitLocker != tt.want {
+				t.Errorf("recordBitLocker() ran manage-bde %q and recorded %q, want %d runs and %q", calls, report.Volumes[0].BitLocker, tt.wantCalls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"os"
+	"sync"
+)
+
+const (
+	bootRecordsDirectory = "boot_records"
+	bootRecordsFileName  = bootRecordsDirectory + "/boot_records.json"
+
+	// The MBR, the GPT header and the 32 sectors of GPT partition entries
+	diskHeaderSectors = 34
+
+	bootRecordDisk      = "disk"
+	bootRecordVBR       = "vbr"
+	bootRecordBackupVBR = "backup_vbr"
+
+	partitionStyleMBR     = "mbr"
+	partitionStyleGPT     = "gpt"
+	partitionStyleUnknown = "unknown"
+)
+
+// BootRecord is a disk's first sectors or a volume's boot record written into the output under boot_records, which a
+// bootkit or someone tampering with the partitions would have to change.
+type BootRecord struct {
+	Kind           string `json:"kind"`   // disk, vbr or backup_vbr
+	Device         string `json:"device"` // e.g. \\.\PhysicalDrive0 or \\.\C:
+	Volume         string `json:"volume,omitempty"`
+	Output         string `json:"output"` // where it is in the output
+	Offset         int64  `json:"offset"`
+	Length         int64  `json:"length"`
+	SHA256         string `json:"sha256,omitempty"`
+	BootSignature  bool   `json:"boot_signature"`            // whether the first sector ends with 55 AA
+	PartitionStyle string `json:"partition_style,omitempty"` // mbr, gpt or unknown, for disks
+	Note           string `json:"note,omitempty"`
+}
+
+// bootRecordCollector lists the boot records read from all volumes and the disks under them, so a disk holding more
+// than one of the volumes is read once. Like the deletedFileRecovery it is nil when BootRecords isn't set.
+type bootRecordCollector struct {
+	mutex   sync.Mutex
+	disks   map[uint32]bool
+	records []BootRecord
+}
+
+func newBootRecordCollector(enabled bool) *bootRecordCollector {
+	if !enabled {
+		return nil
+	}
+	return &bootRecordCollector{disks: make(map[uint32]bool), records: make([]BootRecord, 0)}
+}
+
+// claimDisk reports whether a disk still has to be read, which only the first volume on it gets told.
+func (collector *bootRecordCollector) claimDisk(diskNumber uint32) bool {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	if collector.disks[diskNumber] {
+		return false
+	}
+	collector.disks[diskNumber] = true
+	return true
+}
+
+func (collector *bootRecordCollector) add(record BootRecord) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.records = append(collector.records, record)
+}
+
+func (collector *bootRecordCollector) snapshot() []BootRecord {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	return append([]BootRecord(nil), collector.records...)
+}
+
+// volumeDiskNumbers returns the numbers of the physical disks a volume is on, more than one for a spanned volume. It's
+// a variable so tests don't need a disk.
+var volumeDiskNumbers = func(volume *os.File) (diskNumbers []uint32, err error) {
+	const (
+		ioctlVolumeGetVolumeDiskExtents = 0x560000
+		maxDiskExtents                  = 32
+		diskExtentSize                  = 24
+	)
+	buffer := make([]byte, 8+maxDiskExtents*diskExtentSize)
+	var bytesReturned uint32
+	err = windows.DeviceIoControl(windows.Handle(volume.Fd()), ioctlVolumeGetVolumeDiskExtents, nil, 0, &buffer[0], uint32(len(buffer)), &bytesReturned, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to get the disk extents of the volume: %w", err)
+		return
+	}
+	diskNumbers = parseDiskExtents(buffer[:bytesReturned])
+	return
+}
+
+// parseDiskExtents reads the disk numbers out of a VOLUME_DISK_EXTENTS.
+func parseDiskExtents(extents []byte) (diskNumbers []uint32) {
+	const (
+		offsetFirstExtent = 8
+		diskExtentSize    = 24
+	)
+	if len(extents) < offsetFirstExtent {
+		return
+	}
+	numberOfExtents := int(binary.LittleEndian.Uint32(extents))
+	for index := 0; index < numberOfExtents; index++ {
+		offset := offsetFirstExtent + index*diskExtentSize
+		if offset+diskExtentSize > len(extents) {
+			break
+		}
+		diskNumber := binary.LittleEndian.Uint32(extents[offset:])
+		seen := false
+		for _, number := range diskNumbers {
+			seen = seen || number == diskNumber
+		}
+		if !seen {
+			diskNumbers = append(diskNumbers, diskNumber)
+		}
+	}
+	return
+}
+
+// physicalDrivePath is the device path of a physical disk.
+func physicalDrivePath(diskNumber uint32) string {
+	return fmt.Sprintf(`\\.\PhysicalDrive%d`, diskNumber)
+}
+
+// openPhysicalDrive opens a physical disk to read it raw. It's a variable so tests don't need a disk.
+var openPhysicalDrive = func(diskNumber uint32) (disk *os.File, err error) {
+	const (
+		genericRead        = 0x80000000
+		fileShareReadWrite = 0x01 | 0x02
+		openExisting       = 0x03
+	)
+	path := physicalDrivePath(diskNumber)
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return
+	}
+	handle, err := windows.CreateFile(name, genericRead, fileShareReadWrite, nil, openExisting, 0, 0)
+	if err != nil {
+		err = fmt.Errorf("failed to open %s: %w", path, err)
+		return
+	}
+	disk = os.NewFile(uintptr(handle), path)
+	return
+}
+
+// collectBootRecords writes a volume's boot record, its backup at the end of the volume, and the first sectors of the
+// disks under it that no other volume has written yet, into the output under boot_records. Those that can't be read are
+// listed as failed rather than failing the volume.
+func (collector *Collector) collectBootRecords(ctx context.Context, volumeHandler *VolumeHandler, fileReaders chan fileReader) (err error) {
+	if collector.bootRecords == nil || collector.planner != nil {
+		return
+	}
+	sectorSize := volumeHandler.Vbr.BytesPerSector
+	if sectorSize <= 0 {
+		sectorSize = fallbackSectorSize
+	}
+	volumeLetter := volumeHandler.VolumeLetter
+	device := fmt.Sprintf(`\\.\%s:`, volumeLetter)
+	outputDirectory := bootRecordsDirectory + `\` + volumeLetter
+
+	vbr, err := readSectors(volumeHandler.Handle, 0, sectorSize)
+	if err != nil {
+		collector.report.fileFailed(outputDirectory+`\vbr.bin`, volumeLetter, fmt.Errorf("failed to read the volume boot record: %w", err))
+		err = nil
+	} else {
+		err = collector.sendBootRecord(ctx, fileReaders, volumeLetter, BootRecord{Kind: bootRecordVBR, Device: device, Volume: volumeLetter, Output: outputDirectory + `\vbr.bin`}, vbr, sectorSize)
+		if err != nil {
+			return
+		}
+		// NTFS keeps a copy of the boot record in the sector after the last one it counts as part of the volume
+		const offsetTotalSectors = 0x28
+		backupOffset := int64(binary.LittleEndian.Uint64(vbr[offsetTotalSectors:])) * sectorSize
+		backup := BootRecord{Kind: bootRecordBackupVBR, Device: device, Volume: volumeLetter, Output: outputDirectory + `\backup_vbr.bin`, Offset: backupOffset}
+		data, readErr := readSectors(volumeHandler.Handle, backupOffset, sectorSize)
+		if readErr != nil {
+			collector.report.fileFailed(backup.Output, volumeLetter, fmt.Errorf("failed to read the backup volume boot record at offset %d: %w", backupOffset, readErr))
+		} else {
+			if !bytes.Equal(data, vbr) {
+				backup.Note = "it differs from the volume boot record"
+			}
+			err = collector.sendBootRecord(ctx, fileReaders, volumeLetter, backup, data, sectorSize)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	diskNumbers, diskErr := volumeDiskNumbers(volumeHandler.Handle)
+	if diskErr != nil {
+		volumeHandler.logger().Warnf("Could not find the disks under volume %s to read their first sectors: %v", volumeLetter, diskErr)
+		return
+	}
+	for _, diskNumber := range diskNumbers {
+		if !collector.bootRecords.claimDisk(diskNumber) {
+			continue
+		}
+		record := BootRecord{Kind: bootRecordDisk, Device: physicalDrivePath(diskNumber), Output: fmt.Sprintf(`%s\physicaldrive%d\first_sectors.bin`, bootRecordsDirectory, diskNumber)}
+		data, readErr := readDiskHeader(diskNumber, sectorSize)
+		if readErr != nil {
+			collector.report.fileFailed(record.Output, volumeLetter, fmt.Errorf("failed to read the first sectors of %s: %w", record.Device, readErr))
+			continue
+		}
+		record.PartitionStyle = partitionStyle(data, sectorSize)
+		err = collector.sendBootRecord(ctx, fileReaders, volumeLetter, record, data, sectorSize)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func readDiskHeader(diskNumber uint32, sectorSize int64) (data []byte, err error) {
+	disk, err := openPhysicalDrive(diskNumber)
+	if err != nil {
+		return
+	}
+	defer disk.Close()
+	data, err = readSectors(disk, 0, diskHeaderSectors*sectorSize)
+	return
+}
+
+// readSectors reads whole sectors of a raw volume or disk.
+func readSectors(device *os.File, offset int64, length int64) (data []byte, err error) {
+	data = make([]byte, length)
+	read, err := device.ReadAt(data, offset)
+	if int64(read) == length {
+		err = nil
+	} else if err == nil {
+		err = errors.New("the read came up short")
+	}
+	return
+}
+
+// partitionStyle tells apart a disk's MBR and GPT by the EFI PART signature of the GPT header after the protective MBR.
+func partitionStyle(diskHeader []byte, sectorSize int64) string {
+	switch {
+	case int64(len(diskHeader)) >= sectorSize+8 && string(diskHeader[sectorSize:sectorSize+8]) == "EFI PART":
+		return partitionStyleGPT
+	case hasBootSignature(diskHeader):
+		return partitionStyleMBR
+	}
+	return partitionStyleUnknown
+}
+
+// hasBootSignature reports whether the first sector ends with the 55 AA boot signature.
+func hasBootSignature(sectors []byte) bool {
+	return len(sectors) >= 512 && sectors[510] == 0x55 && sectors[511] == 0xaa
+}
+
+func (collector *Collector) sendBootRecord(ctx context.Context, fileReaders chan fileReader, volumeLetter string, record BootRecord, data []byte, sectorSize int64) (err error) {
+	sum := sha256.Sum256(data)
+	record.Length = int64(len(data))
+	record.SHA256 = hex.EncodeToString(sum[:])
+	record.BootSignature = hasBootSignature(data[:sectorSize])
+	collector.bootRecords.add(record)
+	err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(fileReader{
+		fullPath: record.Output,
+		reader:   bytes.NewReader(data),
+		method:   readMethodRaw,
+	}, volumeLetter))
+	return
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_parseDiskExtents(t *testing.T) {
+	extents := func(diskNumbers ...uint32) []byte {
+		data := make([]byte, 8+24*len(diskNumbers))
+		binary.LittleEndian.PutUint32(data, uint32(len(diskNumbers)))
+		for index, diskNumber := range diskNumbers {
+			binary.LittleEndian.PutUint32(data[8+24*index:], diskNumber)
+		}
+		return data
+	}
+	tests := []struct {
+		name    string
+		extents []byte
+		want    []uint32
+	}{
+		{name: "one disk", extents: extents(0), want: []uint32{0}},
+		{name: "spanned", extents: extents(1, 2, 1), want: []uint32{1, 2}},
+		{name: "truncated", extents: extents(3, 4)[:8+24], want: []uint32{3}},
+		{name: "empty", extents: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDiskExtents(tt.extents); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiskExtents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_partitionStyle(t *testing.T) {
+	mbr := make([]byte, 512*diskHeaderSectors)
+	mbr[510], mbr[511] = 0x55, 0xaa
+	gpt := append([]byte(nil), mbr...)
+	copy(gpt[512:], "EFI PART")
+	tests := []struct {
+		name       string
+		diskHeader []byte
+		want       string
+	}{
+		{name: "mbr", diskHeader: mbr, want: partitionStyleMBR},
+		{name: "gpt", diskHeader: gpt, want: partitionStyleGPT},
+		{name: "blank", diskHeader: make([]byte, 512*diskHeaderSectors), want: partitionStyleUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partitionStyle(tt.diskHeader, 512); got != tt.want {
+				t.Errorf("partitionStyle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_collectBootRecords(t *testing.T) {
+	defer func(original func(*os.File) ([]uint32, error)) { volumeDiskNumbers = original }(volumeDiskNumbers)
+	defer func(original func(uint32) (*os.File, error)) { openPhysicalDrive = original }(openPhysicalDrive)
+	volumeDiskNumbers = func(volume *os.File) ([]uint32, error) { return []uint32{0, 1}, nil }
+	openPhysicalDrive = func(diskNumber uint32) (*os.File, error) {
+		if diskNumber == 1 {
+			return nil, errors.New("access denied")
+		}
+		return os.Open(`test\testdata\dummyntfs`)
+	}
+	volume, err := ioutil.ReadFile(`test\testdata\dummyntfs`)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile() error = %v", err)
+	}
+
+	collector := &Collector{report: newReportBuilder(), bootRecords: newBootRecordCollector(true)}
+	fileReaders := make(chan fileReader, 10)
+	for _, volumeLetter := range []string{"c", "d"} {
+		volumeHandler, err := GetVolumeHandler(volumeLetter, dummyHandler{filePath: `test\testdata\dummyntfs`})
+		if err != nil {
+			t.Fatalf("GetVolumeHandler() error = %v", err)
+		}
+		err = collector.collectBootRecords(context.Background(), &volumeHandler, fileReaders)
+		volumeHandler.Handle.Close()
+		if err != nil {
+			t.Fatalf("collectBootRecords() error = %v", err)
+		}
+	}
+	close(fileReaders)
+
+	got := make(map[string]int)
+	for file := range fileReaders {
+		data, _ := ioutil.ReadAll(file.reader)
+		got[file.fullPath] = len(data)
+		if file.fullPath == `boot_records\c\vbr.bin` && !bytes.Equal(data, volume[:512]) {
+			t.Errorf("collectBootRecords() wrote a vbr that isn't the first sector of the volume")
+		}
+	}
+	want := map[string]int{`boot_records\c\vbr.bin`: 512, `boot_records\d\vbr.bin`: 512, `boot_records\physicaldrive0\first_sectors.bin`: 512 * diskHeaderSectors}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectBootRecords() wrote %v, want %v", got, want)
+	}
+
+	records := collector.bootRecords.snapshot()
+	if len(records) != 3 || records[1].Kind != bootRecordDisk || records[1].PartitionStyle != partitionStyleMBR || !records[0].BootSignature {
+		t.Errorf("collectBootRecords() listed %+v, want the vbr of c, disk 0 as mbr and the vbr of d", records)
+	}
+
+	// The backup boot records are past the end of the dummy volume and disk 1 can't be opened
+	failed := 0
+	for _, file := range collector.report.snapshot().Files {
+		if file.Status == "failed" {
+			failed++
+		}
+	}
+	if failed != 3 {
+		t.Errorf("collectBootRecords() reported %d failures, want 3", failed)
+	}
+
+	collector.bootRecords = nil
+	if err = collector.collectBootRecords(context.Background(), &VolumeHandler{}, nil); err != nil {
+		t.Errorf("collectBootRecords() error = %v when it's off", err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"sort"
+	"sync"
+)
+
+const budgetPlanFileName = "budget_plan.json"
+
+// BudgetPlan is written into the output as budget_plan.json when a collection has a ByteBudget. It lists the files that
+// were found but left out to stay within the budget, so they can be fetched later over a better link.
+type BudgetPlan struct {
+	Budget   int64          `json:"budget"`
+	Planned  int64          `json:"planned"` // bytes of the files that were kept
+	Deferred []DeferredFile `json:"deferred"`
+}
+
+// DeferredFile is a file that didn't fit in the budget.
+type DeferredFile struct {
+	Path     string `json:"path"`
+	Volume   string `json:"volume"`
+	Size     int64  `json:"size"`
+	Priority int    `json:"priority"`
+}
+
+// budgetPlanner hands out a byte budget across every volume of a collection. Volumes are planned in the order they are
+// searched, and within a volume the files with the highest priority go first, smallest first among equals so as many of
+// them fit as possible. Like the reportBuilder its methods do nothing when it's nil.
+type budgetPlanner struct {
+	mutex sync.Mutex
+	plan  BudgetPlan
+}
+
+// newBudgetPlanner returns nil when there is no budget.
+func newBudgetPlanner(budget int64) *budgetPlanner {
+	if budget <= 0 {
+		return nil
+	}
+	return &budgetPlanner{plan: BudgetPlan{Budget: budget, Deferred: make([]DeferredFile, 0)}}
+}
+
+// admit reserves room for a file if there's enough of the budget left, and records it as deferred if there isn't.
+func (planner *budgetPlanner) admit(path string, volumeLetter string, size int64, priority int) bool {
+	if planner == nil {
+		return true
+	}
+	planner.mutex.Lock()
+	defer planner.mutex.Unlock()
+	if planner.plan.Planned+size <= planner.plan.Budget {
+		planner.plan.Planned += size
+		return true
+	}
+	planner.plan.Deferred = append(planner.plan.Deferred, DeferredFile{
+		Path:     path,
+		Volume:   volumeLetter,
+		Size:     size,
+		Priority: priority,
+	})
+	return false
+}
+
+// planFiles orders a volume's files by value and returns the ones that fit in what is left of the budget, in the order
+// they should be collected. Without a budget the files are returned as they are.
+func (planner *budgetPlanner) planFiles(volumeLetter string, files foundFiles) (planned foundFiles) {
+	if planner == nil {
+		return files
+	}
+	ordered := append(foundFiles(nil), files...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].priority != ordered[j].priority {
+			return ordered[i].priority > ordered[j].priority
+		}
+		return ordered[i].totalSize() < ordered[j].totalSize()
+	})
+	planned = make(foundFiles, 0, len(ordered))
+	for _, file := range ordered {
+		if planner.admit(file.fullPath, volumeLetter, file.totalSize(), file.priority) {
+			planned = append(planned, file)
+		}
+	}
+	return
+}
+
+func (planner *budgetPlanner) snapshot() (plan BudgetPlan) {
+	planner.mutex.Lock()
+	defer planner.mutex.Unlock()
+	plan = planner.plan
+	plan.Deferred = append([]DeferredFile(nil), planner.plan.Deferred...)
+	return
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_budgetPlanner_planFiles(t *testing.T) {
+	files := foundFiles{
+		{fullPath: `c:\pagefile.sys`, fileSize: 800, priority: 0},
+		{fullPath: `c:\users\a\ntuser.dat`, fileSize: 300, priority: 40},
+		{fullPath: `c:\windows\system32\config\system`, fileSize: 500, priority: 50},
+		{fullPath: `c:\users\b\ntuser.dat`, fileSize: 200, priority: 40},
+	}
+	tests := []struct {
+		name         string
+		budget       int64
+		wantPlanned  []string
+		wantDeferred []string
+	}{
+		{
+			name:         "no budget",
+			budget:       0,
+			wantPlanned:  []string{`c:\pagefile.sys`, `c:\users\a\ntuser.dat`, `c:\windows\system32\config\system`, `c:\users\b\ntuser.dat`},
+			wantDeferred: nil,
+		},
+		{
+			name:         "everything fits",
+			budget:       1800,
+			wantPlanned:  []string{`c:\windows\system32\config\system`, `c:\users\b\ntuser.dat`, `c:\users\a\ntuser.dat`, `c:\pagefile.sys`},
+			wantDeferred: []string{},
+		},
+		{
+			name:         "smaller files of equal priority fit first",
+			budget:       750,
+			wantPlanned:  []string{`c:\windows\system32\config\system`, `c:\users\b\ntuser.dat`},
+			wantDeferred: []string{`c:\users\a\ntuser.dat`, `c:\pagefile.sys`},
+		},
+		{
+			name:         "lower priority files fill the gaps",
+			budget:       1000,
+			wantPlanned:  []string{`c:\windows\system32\config\system`, `c:\users\b\ntuser.dat`, `c:\users\a\ntuser.dat`},
+			wantDeferred: []string{`c:\pagefile.sys`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planner := newBudgetPlanner(tt.budget)
+			var gotPlanned []string
+			for _, file := range planner.planFiles("c", files) {
+				gotPlanned = append(gotPlanned, file.fullPath)
+			}
+			if !reflect.DeepEqual(gotPlanned, tt.wantPlanned) {
+				t.Errorf("planFiles() = %v, want %v", gotPlanned, tt.wantPlanned)
+			}
+			if planner == nil {
+				return
+			}
+			gotDeferred := make([]string, 0)
+			for _, file := range planner.snapshot().Deferred {
+				gotDeferred = append(gotDeferred, file.Path)
+			}
+			if !reflect.DeepEqual(gotDeferred, tt.wantDeferred) {
+				t.Errorf("deferred = %v, want %v", gotDeferred, tt.wantDeferred)
+			}
+		})
+	}
+}
+
+func Test_budgetPlanner_admit(t *testing.T) {
+	planner := newBudgetPlanner(100)
+	if !planner.admit(`c:\$mft`, "c", 60, 0) {
+		t.Error("admit() = false for a file within the budget")
+	}
+	if planner.admit(`d:\$mft`, "d", 60, 0) {
+		t.Error("admit() = true for a file over what's left of the budget")
+	}
+	plan := planner.snapshot()
+	if plan.Planned != 60 || len(plan.Deferred) != 1 || plan.Deferred[0].Volume != "d" {
+		t.Errorf("snapshot() = %+v, want 60 bytes planned and d:\\$mft deferred", plan)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows/registry"
+	"net"
+	"time"
+)
+
+const clockMetadataFileName = "clock.json"
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and the unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+const ntpTimeout = 5 * time.Second
+
+// ClockInfo describes the host's clock at the time of collection so artifact timestamps can be corrected during timeline
+// building.
+type ClockInfo struct {
+	CapturedAt       time.Time `json:"captured_at"`
+	TimeZone         string    `json:"time_zone"`
+	TimeZoneKeyName  string    `json:"time_zone_key_name,omitempty"`
+	UTCOffsetSeconds int       `json:"utc_offset_seconds"`
+	W32TimeType      string    `json:"w32time_type,omitempty"`
+	W32TimeServer    string    `json:"w32time_ntp_server,omitempty"`
+	NTPServer        string    `json:"ntp_server,omitempty"`
+	SkewSeconds      float64   `json:"skew_seconds"`
+	SkewMeasured     bool      `json:"skew_measured"`
+	Errors           []string  `json:"errors,omitempty"`
+}
+
+// captureClockInfo gathers the local time zone and w32time settings and, if an ntp server is given, measures how far the
+// system clock is off from it. Failures are recorded in the returned ClockInfo rather than failing the collection.
+func captureClockInfo(ctx context.Context, logger Logger, ntpServer string) (clock ClockInfo) {
+	now := time.Now()
+	clock.CapturedAt = now.UTC()
+	clock.TimeZone, clock.UTCOffsetSeconds = now.Zone()
+
+	timeZoneKey, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\TimeZoneInformation`, registry.QUERY_VALUE)
+	if err != nil {
+		clock.Errors = append(clock.Errors, fmt.Sprintf("failed to open the time zone registry key: %v", err))
+	} else {
+		clock.TimeZoneKeyName, _, _ = timeZoneKey.GetStringValue("TimeZoneKeyName")
+		timeZoneKey.Close()
+	}
+
+	w32timeKey, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\W32Time\Parameters`, registry.QUERY_VALUE)
+	if err != nil {
+		clock.Errors = append(clock.Errors, fmt.Sprintf("failed to open the w32time registry key: %v", err))
+	} else {
+		clock.W32TimeType, _, _ = w32timeKey.GetStringValue("Type")
+		clock.W32TimeServer, _, _ = w32timeKey.GetStringValue("NtpServer")
+		w32timeKey.Close()
+	}
+
+	if ntpServer != "" {
+		clock.NTPServer = ntpServer
+		skew, err := queryNTPSkew(ctx, ntpServer)
+		if err != nil {
+			clock.Errors = append(clock.Errors, fmt.Sprintf("failed to query ntp server %s: %v", ntpServer, err))
+		} else {
+			clock.SkewSeconds = skew.Seconds()
+			clock.SkewMeasured = true
+		}
+	}
+	logger.Debugf("Captured the following clock information: %+v", clock)
+	return
+}
+
+// queryNTPSkew sends a single SNTP request and returns how far the local clock is ahead (negative) or behind (positive)
+// the server.
+func queryNTPSkew(ctx context.Context, server string) (skew time.Duration, err error) {
+	if _, _, splitErr := net.SplitHostPort(server); splitErr != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	dialer := net.Dialer{Timeout: ntpTimeout}
+	connection, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return
+	}
+	defer connection.Close()
+	deadline := time.Now().Add(ntpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = connection.SetDeadline(deadline)
+
+	// Leap indicator 0, version 4, client mode
+	request := make([]byte, 48)
+	request[0] = 0x23
+	originateTime := time.Now()
+	putNTPTime(request[40:48], originateTime)
+	if _, err = connection.Write(request); err != nil {
+		return
+	}
+
+	response := make([]byte, 48)
+	numberOfBytesRead, err := connection.Read(response)
+	destinationTime := time.Now()
+	if err != nil {
+		return
+	}
+	if numberOfBytesRead < 48 {
+		err = errors.New("received a short ntp response")
+		return
+	}
+
+	receiveTime := getNTPTime(response[32:40])
+	transmitTime := getNTPTime(response[40:48])
+	skew = (receiveTime.Sub(originateTime) + transmitTime.Sub(destinationTime)) / 2
+	return
+}
+
+func putNTPTime(buffer []byte, timestamp time.Time) {
+	seconds := uint64(timestamp.Unix() + ntpEpochOffset)
+	fraction := uint64(timestamp.Nanosecond()) << 32 / uint64(time.Second)
+	binary.BigEndian.PutUint32(buffer[0:4], uint32(seconds))
+	binary.BigEndian.PutUint32(buffer[4:8], uint32(fraction))
+}
+
+func getNTPTime(buffer []byte) (timestamp time.Time) {
+	seconds := int64(binary.BigEndian.Uint32(buffer[0:4])) - ntpEpochOffset
+	fraction := uint64(binary.BigEndian.Uint32(buffer[4:8]))
+	nanoseconds := int64(fraction * uint64(time.Second) >> 32)
+	timestamp = time.Unix(seconds, nanoseconds)
+	return
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ntpTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		timestamp time.Time
+	}{
+		{
+			name:      "unix epoch",
+			timestamp: time.Unix(0, 0),
+		},
+		{
+			name:      "sub second precision",
+			timestamp: time.Date(2020, 3, 14, 15, 9, 26, 535897000, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := make([]byte, 8)
+			putNTPTime(buffer, tt.timestamp)
+			got := getNTPTime(buffer)
+			if difference := got.Sub(tt.timestamp); difference > time.Microsecond || difference < -time.Microsecond {
+				t.Errorf("getNTPTime() = %v, want %v", got, tt.timestamp)
+			}
+		})
+	}
+}
+
+func Test_queryNTPSkew(t *testing.T) {
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		wantSkew time.Duration
+	}{
+		{
+			name:     "server ahead",
+			offset:   10 * time.Second,
+			wantSkew: 10 * time.Second,
+		},
+		{
+			name:     "server behind",
+			offset:   -90 * time.Second,
+			wantSkew: -90 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Skipf("unable to listen on udp: %v", err)
+			}
+			defer server.Close()
+			go func() {
+				request := make([]byte, 48)
+				_, address, err := server.ReadFrom(request)
+				if err != nil {
+					return
+				}
+				response := make([]byte, 48)
+				response[0] = 0x24
+				putNTPTime(response[32:40], time.Now().Add(tt.offset))
+				putNTPTime(response[40:48], time.Now().Add(tt.offset))
+				_, _ = server.WriteTo(response, address)
+			}()
+
+			gotSkew, err := queryNTPSkew(context.Background(), server.LocalAddr().String())
+			if err != nil {
+				t.Errorf("queryNTPSkew() error = %v", err)
+				return
+			}
+			if difference := gotSkew - tt.wantSkew; difference > time.Second || difference < -time.Second {
+				t.Errorf("queryNTPSkew() = %v, want %v", gotSkew, tt.wantSkew)
+			}
+		})
+	}
+}
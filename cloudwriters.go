@@ -0,0 +1,229 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	azureAPIVersion        = "2019-12-12"
+	defaultGcsEndpoint     = "https://storage.googleapis.com"
+	gcsChunkAlignment      = 256 * 1024
+	cloudZipContentType    = "application/zip"
+	azureBlockIDPrintWidth = 20
+)
+
+// AzureBlobResultWriter uploads the collection as a zip straight into an Azure block blob while it is being collected.
+// Each chunk is staged as a block with Put Block and the blob is committed with Put Block List once the zip is done, so
+// a collection that fails part way leaves no blob behind. BlobURL is the URL of the blob to create including a SAS
+// token that allows writes; it is never logged.
+//
+// Azure allows 50,000 blocks per blob, which caps an upload at about 390 GiB with the default chunk size.
+type AzureBlobResultWriter struct {
+	BlobURL    string
+	Client     *http.Client  // defaults to http.DefaultClient
+	ChunkSize  int           // size of each block, defaults to 8 MiB
+	MaxRetries int           // retries per block, defaults to 10
+	RetryWait  time.Duration // see HttpResultWriter
+	Codec      string        // see ZipResultWriter
+	Logger     Logger        // see ZipResultWriter
+}
+
+// ResultWriter will upload found files as a zip. If ctx is cancelled the zip is closed out and the blob committed on a
+// best effort basis.
+func (azureResultWriter *AzureBlobResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	blobURL, err := url.Parse(azureResultWriter.BlobURL)
+	if err != nil {
+		err = fmt.Errorf("failed to parse the blob url: %w", err)
+		drainFileReaders(fileReaders)
+		return
+	}
+	target := &azureBlockTarget{
+		blobURL: blobURL,
+		client:  azureResultWriter.Client,
+	}
+	uploader := newChunkedUploader(ctx, azureResultWriter.Logger, target, azureResultWriter.ChunkSize, azureResultWriter.MaxRetries, azureResultWriter.RetryWait)
+	err = uploadResults(ctx, fileReaders, uploader, azureResultWriter.Codec)
+	return
+}
+
+// azureBlockTarget stages every chunk as a block and commits them all with the last one.
+type azureBlockTarget struct {
+	blobURL  *url.URL
+	client   *http.Client // defaults to http.DefaultClient
+	blockIDs []string
+}
+
+func (target *azureBlockTarget) String() string {
+	return target.blobURL.Scheme + "://" + target.blobURL.Host + target.blobURL.Path
+}
+
+// send stages data as a block named after its offset, so resending a chunk replaces the block rather than adding one.
+func (target *azureBlockTarget) send(ctx context.Context, data []byte, start int64, final bool) (committed int64, err error) {
+	if len(data) > 0 {
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%0*d", azureBlockIDPrintWidth, start)))
+		err = target.put(ctx, map[string]string{"comp": "block", "blockid": blockID}, data, nil)
+		if err != nil {
+			return
+		}
+		if len(target.blockIDs) == 0 || target.blockIDs[len(target.blockIDs)-1] != blockID {
+			target.blockIDs = append(target.blockIDs, blockID)
+		}
+	}
+	if final {
+		blockList, marshalErr := xml.Marshal(azureBlockList{Latest: target.blockIDs})
+		if marshalErr != nil {
+			err = permanentUploadError{marshalErr}
+			return
+		}
+		err = target.put(ctx, map[string]string{"comp": "blocklist"}, append([]byte(xml.Header), blockList...), map[string]string{"x-ms-blob-content-type": cloudZipContentType})
+		if err != nil {
+			return
+		}
+	}
+	committed = start + int64(len(data))
+	return
+}
+
+// query can't help, Azure only keeps blocks that were staged in full. The chunk gets staged again from the start.
+func (target *azureBlockTarget) query(ctx context.Context) (committed int64, err error) {
+	err = errors.New("staged blocks can't be resumed part way through")
+	return
+}
+
+func (target *azureBlockTarget) put(ctx context.Context, query map[string]string, body []byte, header map[string]string) (err error) {
+	requestURL := *target.blobURL
+	values := requestURL.Query()
+	for name, value := range query {
+		values.Set(name, value)
+	}
+	requestURL.RawQuery = values.Encode()
+	request, err := http.NewRequest(http.MethodPut, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		err = permanentUploadError{err}
+		return
+	}
+	request.Header.Set("x-ms-version", azureAPIVersion)
+	for name, value := range header {
+		request.Header.Set(name, value)
+	}
+	client := target.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		err = uploadStatusError(response)
+	}
+	return
+}
+
+type azureBlockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+// GcsResultWriter uploads the collection as a zip straight into a Google Cloud Storage object with a resumable upload
+// while it is being collected. The upload session is started with AccessToken, an OAuth 2.0 token that can create
+// objects in Bucket. Alternatively a server can start the session and hand its URL over as SessionURL, so the endpoint
+// never holds a token at all.
+//
+// GCS needs chunks in multiples of 256 KiB, ChunkSize is rounded up to one.
+type GcsResultWriter struct {
+	Bucket      string
+	Object      string
+	AccessToken string
+	SessionURL  string        // used instead of Bucket, Object and AccessToken when set
+	Endpoint    string        // defaults to https://storage.googleapis.com
+	Client      *http.Client  // defaults to http.DefaultClient
+	ChunkSize   int           // defaults to 8 MiB
+	MaxRetries  int           // retries per chunk, defaults to 10
+	RetryWait   time.Duration // see HttpResultWriter
+	Codec       string        // see ZipResultWriter
+	Logger      Logger        // see ZipResultWriter
+}
+
+// ResultWriter will upload found files as a zip. If ctx is cancelled the zip is closed out and what's left of it
+// uploaded on a best effort basis.
+func (gcsResultWriter *GcsResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	sessionURL := gcsResultWriter.SessionURL
+	if sessionURL == "" {
+		sessionURL, err = gcsResultWriter.startSession(ctx)
+		if err != nil {
+			drainFileReaders(fileReaders)
+			return
+		}
+	}
+	target := &rangeUploadTarget{
+		url:         sessionURL,
+		method:      http.MethodPut,
+		client:      gcsResultWriter.Client,
+		description: fmt.Sprintf("gs://%s/%s", gcsResultWriter.Bucket, gcsResultWriter.Object),
+		gcsStatus:   true,
+	}
+	chunkSize := gcsResultWriter.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultUploadChunkSize
+	}
+	chunkSize = (chunkSize + gcsChunkAlignment - 1) / gcsChunkAlignment * gcsChunkAlignment
+	uploader := newChunkedUploader(ctx, gcsResultWriter.Logger, target, chunkSize, gcsResultWriter.MaxRetries, gcsResultWriter.RetryWait)
+	err = uploadResults(ctx, fileReaders, uploader, gcsResultWriter.Codec)
+	return
+}
+
+// startSession starts a resumable upload and returns the session URL the chunks go to.
+func (gcsResultWriter *GcsResultWriter) startSession(ctx context.Context) (sessionURL string, err error) {
+	if gcsResultWriter.Bucket == "" || gcsResultWriter.Object == "" {
+		err = errors.New("GcsResultWriter needs a Bucket and Object, or a SessionURL")
+		return
+	}
+	endpoint := gcsResultWriter.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGcsEndpoint
+	}
+	query := url.Values{}
+	query.Set("uploadType", "resumable")
+	query.Set("name", gcsResultWriter.Object)
+	requestURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", endpoint, url.PathEscape(gcsResultWriter.Bucket), query.Encode())
+	request, err := http.NewRequest(http.MethodPost, requestURL, nil)
+	if err != nil {
+		return
+	}
+	request.Header.Set("Authorization", "Bearer "+gcsResultWriter.AccessToken)
+	request.Header.Set("X-Upload-Content-Type", cloudZipContentType)
+	client := gcsResultWriter.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		err = fmt.Errorf("failed to start an upload to gs://%s/%s: %w", gcsResultWriter.Bucket, gcsResultWriter.Object, err)
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("failed to start an upload to gs://%s/%s: %w", gcsResultWriter.Bucket, gcsResultWriter.Object, uploadStatusError(response))
+		return
+	}
+	sessionURL = response.Header.Get("Location")
+	if sessionURL == "" {
+		err = fmt.Errorf("GCS didn't return a session url for gs://%s/%s", gcsResultWriter.Bucket, gcsResultWriter.Object)
+	}
+	return
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// azureServer stages blocks and commits block lists the way the blob service does. When flaky every other Put Block
+// fails.
+type azureServer struct {
+	mutex    sync.Mutex
+	blocks   map[string][]byte
+	blob     []byte
+	flaky    bool
+	requests int
+}
+
+func (server *azureServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.requests++
+	if request.Method != http.MethodPut || request.URL.Query().Get("sig") != "secret" {
+		http.Error(writer, "forbidden", http.StatusForbidden)
+		return
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	switch request.URL.Query().Get("comp") {
+	case "block":
+		if server.flaky && server.requests%2 == 1 {
+			http.Error(writer, "connection dropped", http.StatusServiceUnavailable)
+			return
+		}
+		server.blocks[request.URL.Query().Get("blockid")] = body
+	case "blocklist":
+		blockList := azureBlockList{}
+		if err := xml.Unmarshal(body, &blockList); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		server.blob = nil
+		for _, blockID := range blockList.Latest {
+			block, found := server.blocks[blockID]
+			if !found {
+				http.Error(writer, "invalid block list", http.StatusBadRequest)
+				return
+			}
+			server.blob = append(server.blob, block...)
+		}
+	default:
+		http.Error(writer, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// gcsServer starts resumable upload sessions and takes their chunks. When flaky it keeps only the first 256 KiB of
+// every other chunk and fails the request.
+type gcsServer struct {
+	mutex    sync.Mutex
+	received []byte
+	complete bool
+	flaky    bool
+	requests int
+}
+
+func (server *gcsServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if request.Method == http.MethodPost {
+		if request.Header.Get("Authorization") != "Bearer token" || request.URL.Query().Get("uploadType") != "resumable" {
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		writer.Header().Set("Location", "http://"+request.Host+"/session/1")
+		writer.WriteHeader(http.StatusOK)
+		return
+	}
+	if request.URL.Path != "/session/1" {
+		http.Error(writer, "no such session", http.StatusNotFound)
+		return
+	}
+
+	match := contentRangePattern.FindStringSubmatch(request.Header.Get("Content-Range"))
+	if match == nil {
+		http.Error(writer, "bad Content-Range", http.StatusBadRequest)
+		return
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	if match[1] != "" {
+		server.requests++
+		start, _ := strconv.Atoi(match[1])
+		if start != len(server.received) {
+			http.Error(writer, "out of order", http.StatusBadRequest)
+			return
+		}
+		if server.flaky && server.requests%2 == 1 && len(body) > gcsChunkAlignment {
+			server.received = append(server.received, body[:gcsChunkAlignment]...)
+			http.Error(writer, "connection dropped", http.StatusServiceUnavailable)
+			return
+		}
+		server.received = append(server.received, body...)
+	}
+	if match[3] != "*" {
+		total, _ := strconv.Atoi(match[3])
+		server.complete = total == len(server.received)
+	}
+	if server.complete {
+		writer.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(server.received) > 0 {
+		writer.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(server.received)-1))
+	}
+	writer.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func checkUploadedZip(t *testing.T, upload []byte, content []byte) {
+	zipReader, err := zip.NewReader(bytes.NewReader(upload), int64(len(upload)))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	reader, _ := zipReader.File[0].Open()
+	got, _ := ioutil.ReadAll(reader)
+	if len(got) < len(content) || !bytes.Equal(got[:len(content)], content) {
+		t.Errorf("uploaded file doesn't match what was collected")
+	}
+}
+
+func TestAzureBlobResultWriter_ResultWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		flaky bool
+	}{
+		{name: "reliable link", flaky: false},
+		{name: "flaky link", flaky: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &azureServer{blocks: make(map[string][]byte), flaky: tt.flaky}
+			testServer := httptest.NewServer(server)
+			defer testServer.Close()
+
+			resultWriter := AzureBlobResultWriter{
+				BlobURL:   testServer.URL + "/container/collection.zip?sig=secret",
+				ChunkSize: 1024,
+				RetryWait: time.Millisecond,
+				Codec:     "store",
+			}
+			content := bytes.Repeat([]byte("evidence"), 1000)
+			fileReaders := make(chan fileReader, 1)
+			fileReaders <- fileReader{fullPath: `c:\evidence.bin`, reader: bytes.NewReader(content)}
+			close(fileReaders)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			if err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+				t.Fatalf("AzureBlobResultWriter.ResultWriter() error = %v", err)
+			}
+			if server.blob == nil {
+				t.Fatal("the block list was never committed")
+			}
+			checkUploadedZip(t, server.blob, content)
+		})
+	}
+}
+
+func TestAzureBlobResultWriter_permanentFailure(t *testing.T) {
+	server := &azureServer{blocks: make(map[string][]byte)}
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	resultWriter := AzureBlobResultWriter{BlobURL: testServer.URL + "/container/collection.zip?sig=wrong", ChunkSize: 1024, RetryWait: time.Millisecond}
+	fileReaders := make(chan fileReader, 1)
+	fileReaders <- fileReader{fullPath: `c:\evidence.bin`, reader: bytes.NewReader(make([]byte, 4096))}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+	if err == nil || !isPermanentUploadError(err) {
+		t.Errorf("AzureBlobResultWriter.ResultWriter() error = %v, want a permanent upload error", err)
+	}
+	if server.requests != 1 {
+		t.Errorf("server got %d requests, want 1", server.requests)
+	}
+}
+
+func TestGcsResultWriter_ResultWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		flaky bool
+	}{
+		{name: "reliable link", flaky: false},
+		{name: "flaky link", flaky: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &gcsServer{flaky: tt.flaky}
+			testServer := httptest.NewServer(server)
+			defer testServer.Close()
+
+			resultWriter := GcsResultWriter{
+				Bucket:      "evidence",
+				Object:      "host/collection.zip",
+				AccessToken: "token",
+				Endpoint:    testServer.URL,
+				ChunkSize:   1,
+				RetryWait:   time.Millisecond,
+				Codec:       "store",
+			}
+			content := bytes.Repeat([]byte("evidence"), 100000)
+			fileReaders := make(chan fileReader, 1)
+			fileReaders <- fileReader{fullPath: `c:\evidence.bin`, reader: bytes.NewReader(content)}
+			close(fileReaders)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			if err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+				t.Fatalf("GcsResultWriter.ResultWriter() error = %v", err)
+			}
+			if !server.complete {
+				t.Fatal("the upload was never completed")
+			}
+			checkUploadedZip(t, server.received, content)
+		})
+	}
+}
+
+func TestGcsResultWriter_badToken(t *testing.T) {
+	testServer := httptest.NewServer(&gcsServer{})
+	defer testServer.Close()
+
+	resultWriter := GcsResultWriter{Bucket: "evidence", Object: "collection.zip", AccessToken: "expired", Endpoint: testServer.URL}
+	fileReaders := make(chan fileReader, 1)
+	fileReaders <- fileReader{fullPath: `c:\evidence.bin`, reader: bytes.NewReader(make([]byte, 4096))}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	if err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err == nil {
+		t.Error("GcsResultWriter.ResultWriter() error = nil, want the session to fail to start")
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2020 Alec Randazzo
+
+// gofor-collector-gui is a small window around gofor-collector.exe for people who'd rather not use its flags: pick a
+// profile and where the zip goes, press Collect and watch its progress. It runs gofor-collector.exe from its own
+// directory with --progress json and reads the progress back.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	collectorExecutable = "gofor-collector.exe"
+	profilesDirectory   = "profiles"
+	createNoWindow      = 0x08000000
+)
+
+// profile is a set of gofor-collector.exe arguments offered by name. Besides the built in ones, every .json file in
+// the profiles directory next to the GUI is a profile, so an IR team can hand out their own.
+type profile struct {
+	Name      string   `json:"name"`
+	Arguments []string `json:"arguments"`
+}
+
+var builtInProfiles = []profile{
+	{Name: "Triage: $MFT, registry, event logs, user activity and browsers", Arguments: []string{"--gather=a"}},
+	{Name: "Triage and the live state: processes, connections, services", Arguments: []string{"--gather=ax"}},
+	{Name: "Registry hives only", Arguments: []string{"--gather=ru"}},
+	{Name: "Event logs only", Arguments: []string{"--gather=e"}},
+}
+
+func init() {
+	// The window and its messages belong to the thread that created them
+	runtime.LockOSThread()
+}
+
+func main() {
+	executable, err := os.Executable()
+	if err != nil {
+		showError(0, fmt.Sprintf("Failed to find where the GUI runs from: %v", err))
+		os.Exit(-1)
+	}
+	directory := filepath.Dir(executable)
+	profiles, err := loadProfiles(filepath.Join(directory, profilesDirectory))
+	if err != nil {
+		showError(0, err.Error())
+	}
+	gui := &collectionWindow{
+		collector: filepath.Join(directory, collectorExecutable),
+		profiles:  append(append([]profile(nil), builtInProfiles...), profiles...),
+	}
+	err = gui.run(defaultDestination())
+	if err != nil {
+		showError(0, err.Error())
+		os.Exit(-1)
+	}
+}
+
+// loadProfiles reads the profiles in directory, which doesn't have to exist.
+func loadProfiles(directory string) (profiles []profile, err error) {
+	entries, err := ioutil.ReadDir(directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		err = fmt.Errorf("failed to list the profiles: %w", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".json") {
+			continue
+		}
+		data, readErr := ioutil.ReadFile(filepath.Join(directory, entry.Name()))
+		if readErr != nil {
+			err = fmt.Errorf("failed to read the profile %s: %w", entry.Name(), readErr)
+			return
+		}
+		var loaded profile
+		if jsonErr := json.Unmarshal(data, &loaded); jsonErr != nil {
+			err = fmt.Errorf("failed to parse the profile %s: %w", entry.Name(), jsonErr)
+			return
+		}
+		if loaded.Name == "" {
+			loaded.Name = strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		}
+		profiles = append(profiles, loaded)
+	}
+	return
+}
+
+// defaultDestination is a zip on the desktop named after the host and the time.
+func defaultDestination() string {
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%s-%s.zip", hostname, time.Now().Format("20060102-150405"))
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(home, "Desktop", name)
+}
+
+// collectionStatus is what the window shows of a running collection. The collection updates it from its own goroutine
+// and the window reads it when it's told something changed.
+type collectionStatus struct {
+	mutex    sync.Mutex
+	running  bool
+	progress collector.Progress
+	files    int      // files copied so far
+	messages []string // what the collector printed besides its progress
+	err      error
+	finished bool
+}
+
+func (status *collectionStatus) update(change func(status *collectionStatus)) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	change(status)
+}
+
+// collect runs gofor-collector.exe with a profile into destination, calling changed whenever the status changes.
+func collect(collectorPath string, selected profile, destination string, status *collectionStatus, changed func()) {
+	args := append(append([]string(nil), selected.Arguments...), "--zipname", destination, "--progress", "json")
+	command := exec.Command(collectorPath, args...)
+	command.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, CreationFlags: createNoWindow}
+	stderr, err := command.StderrPipe()
+	if err == nil {
+		err = command.Start()
+	}
+	if err != nil {
+		status.update(func(status *collectionStatus) {
+			status.running, status.finished = false, true
+			status.err = fmt.Errorf("failed to start %s: %w", collectorExecutable, err)
+		})
+		changed()
+		return
+	}
+
+	lastFile := ""
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var progress collector.Progress
+		if json.Unmarshal([]byte(line), &progress) != nil {
+			status.update(func(status *collectionStatus) { status.messages = append(status.messages, line) })
+		} else {
+			status.update(func(status *collectionStatus) {
+				status.progress = progress
+				if progress.Stage == collector.StageCopy && progress.FileName != lastFile {
+					status.files++
+					lastFile = progress.FileName
+				}
+			})
+		}
+		changed()
+	}
+	err = command.Wait()
+	status.update(func(status *collectionStatus) {
+		status.running, status.finished = false, true
+		if err != nil {
+			status.err = fmt.Errorf("%s failed: %w", collectorExecutable, err)
+		}
+	})
+	changed()
+}
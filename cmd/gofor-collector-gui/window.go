@@ -0,0 +1,316 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	"golang.org/x/sys/windows"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32                   = windows.NewLazySystemDLL("user32.dll")
+	procRegisterClassExW     = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW      = user32.NewProc("CreateWindowExW")
+	procDefWindowProcW       = user32.NewProc("DefWindowProcW")
+	procDestroyWindow        = user32.NewProc("DestroyWindow")
+	procGetMessageW          = user32.NewProc("GetMessageW")
+	procIsDialogMessageW     = user32.NewProc("IsDialogMessageW")
+	procTranslateMessage     = user32.NewProc("TranslateMessage")
+	procDispatchMessageW     = user32.NewProc("DispatchMessageW")
+	procPostMessageW         = user32.NewProc("PostMessageW")
+	procPostQuitMessage      = user32.NewProc("PostQuitMessage")
+	procSendMessageW         = user32.NewProc("SendMessageW")
+	procSetWindowTextW       = user32.NewProc("SetWindowTextW")
+	procGetWindowTextW       = user32.NewProc("GetWindowTextW")
+	procGetWindowTextLengthW = user32.NewProc("GetWindowTextLengthW")
+	procEnableWindow         = user32.NewProc("EnableWindow")
+	procMessageBoxW          = user32.NewProc("MessageBoxW")
+	procLoadCursorW          = user32.NewProc("LoadCursorW")
+	kernel32                 = windows.NewLazySystemDLL("kernel32.dll")
+	procGetModuleHandleW     = kernel32.NewProc("GetModuleHandleW")
+	comctl32                 = windows.NewLazySystemDLL("comctl32.dll")
+	procInitCommonControlsEx = comctl32.NewProc("InitCommonControlsEx")
+	gdi32                    = windows.NewLazySystemDLL("gdi32.dll")
+	procGetStockObject       = gdi32.NewProc("GetStockObject")
+)
+
+// Window styles, messages and the like from winuser.h and commctrl.h.
+const (
+	wsOverlapped      = 0x00000000
+	wsCaption         = 0x00C00000
+	wsSysMenu         = 0x00080000
+	wsMinimizeBox     = 0x00020000
+	wsVisible         = 0x10000000
+	wsChild           = 0x40000000
+	wsTabStop         = 0x00010000
+	wsVScroll         = 0x00200000
+	wsExClientEdge    = 0x00000200
+	esAutoHScroll     = 0x0080
+	cbsDropDownList   = 0x0003
+	bsDefPushButton   = 0x0001
+	cwUseDefault      = 0x80000000
+	colorBtnFace      = 15
+	idcArrow          = 32512
+	defaultGUIFont    = 17
+	iccProgressClass  = 0x00000020
+	wmDestroy         = 0x0002
+	wmClose           = 0x0010
+	wmSetFont         = 0x0030
+	wmCommand         = 0x0111
+	wmApp             = 0x8000
+	bnClicked         = 0
+	cbAddString       = 0x0143
+	cbGetCurSel       = 0x0147
+	cbSetCurSel       = 0x014E
+	pbmSetPos         = 0x0402
+	pbmSetRange32     = 0x0406
+	mbOK              = 0x00000000
+	mbYesNo           = 0x00000004
+	mbIconError       = 0x00000010
+	mbIconWarning     = 0x00000030
+	mbIconInformation = 0x00000040
+	idYes             = 6
+)
+
+const (
+	windowClassName = "GoforCollectorWindow"
+	collectButtonID = 1
+	// wmStatusChanged tells the window the collection's status changed
+	wmStatusChanged = wmApp + 1
+)
+
+type wndClassEx struct {
+	size       uint32
+	style      uint32
+	wndProc    uintptr
+	clsExtra   int32
+	wndExtra   int32
+	instance   uintptr
+	icon       uintptr
+	cursor     uintptr
+	background uintptr
+	menuName   *uint16
+	className  *uint16
+	iconSm     uintptr
+}
+
+type msg struct {
+	hwnd    uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	x       int32
+	y       int32
+}
+
+type initCommonControlsEx struct {
+	size uint32
+	icc  uint32
+}
+
+// collectionWindow has a profile drop down, the zip to write, a Collect button, a progress bar and a status line.
+type collectionWindow struct {
+	collector string
+	profiles  []profile
+	status    collectionStatus
+	reported  bool // the window said how the last collection went
+
+	window      uintptr
+	profileList uintptr
+	destination uintptr
+	button      uintptr
+	progressBar uintptr
+	statusText  uintptr
+}
+
+// run shows the window and handles its messages until it's closed.
+func (gui *collectionWindow) run(destination string) (err error) {
+	controls := initCommonControlsEx{icc: iccProgressClass}
+	controls.size = uint32(unsafe.Sizeof(controls))
+	procInitCommonControlsEx.Call(uintptr(unsafe.Pointer(&controls)))
+
+	instance, _, _ := procGetModuleHandleW.Call(0)
+	cursor, _, _ := procLoadCursorW.Call(0, idcArrow)
+	class := wndClassEx{
+		wndProc:    windows.NewCallback(gui.windowProc),
+		instance:   instance,
+		cursor:     cursor,
+		background: colorBtnFace + 1,
+		className:  utf16Ptr(windowClassName),
+	}
+	class.size = uint32(unsafe.Sizeof(class))
+	if atom, _, callErr := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&class))); atom == 0 {
+		err = fmt.Errorf("failed to register the window class: %w", callErr)
+		return
+	}
+
+	gui.window, _, err = procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(utf16Ptr(windowClassName))),
+		uintptr(unsafe.Pointer(utf16Ptr("gofor-collector"))), wsOverlapped|wsCaption|wsSysMenu|wsMinimizeBox|wsVisible,
+		cwUseDefault, cwUseDefault, 500, 260, 0, 0, instance, 0)
+	if gui.window == 0 {
+		err = fmt.Errorf("failed to create the window: %w", err)
+		return
+	}
+	err = nil
+	font, _, _ := procGetStockObject.Call(defaultGUIFont)
+	gui.control(0, "STATIC", "Profile:", 0, 12, 16, 70, 20, 0, font)
+	gui.profileList = gui.control(0, "COMBOBOX", "", wsTabStop|wsVScroll|cbsDropDownList, 90, 12, 380, 200, 0, font)
+	for _, profile := range gui.profiles {
+		procSendMessageW.Call(gui.profileList, cbAddString, 0, uintptr(unsafe.Pointer(utf16Ptr(profile.Name))))
+	}
+	procSendMessageW.Call(gui.profileList, cbSetCurSel, 0, 0)
+	gui.control(0, "STATIC", "Save to:", 0, 12, 50, 70, 20, 0, font)
+	gui.destination = gui.control(wsExClientEdge, "EDIT", destination, wsTabStop|esAutoHScroll, 90, 46, 380, 24, 0, font)
+	gui.button = gui.control(0, "BUTTON", "Collect", wsTabStop|bsDefPushButton, 90, 82, 100, 28, collectButtonID, font)
+	gui.progressBar = gui.control(0, "msctls_progress32", "", 0, 12, 126, 458, 20, 0, font)
+	procSendMessageW.Call(gui.progressBar, pbmSetRange32, 0, 100)
+	gui.statusText = gui.control(0, "STATIC", "", 0, 12, 154, 458, 56, 0, font)
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		setText(gui.statusText, "Not running as administrator, so only part of what the profile lists can be collected. Right click the GUI and pick Run as administrator for everything.")
+	}
+
+	var message msg
+	for {
+		result, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&message)), 0, 0, 0)
+		if int32(result) <= 0 {
+			return
+		}
+		if handled, _, _ := procIsDialogMessageW.Call(gui.window, uintptr(unsafe.Pointer(&message))); handled != 0 {
+			continue
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&message)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&message)))
+	}
+}
+
+// control adds a child window of a standard class to the window.
+func (gui *collectionWindow) control(exStyle uintptr, class string, text string, style uintptr, x, y, width, height int, id uintptr, font uintptr) uintptr {
+	control, _, _ := procCreateWindowExW.Call(exStyle, uintptr(unsafe.Pointer(utf16Ptr(class))), uintptr(unsafe.Pointer(utf16Ptr(text))),
+		wsChild|wsVisible|style, uintptr(x), uintptr(y), uintptr(width), uintptr(height), gui.window, id, 0, 0)
+	procSendMessageW.Call(control, wmSetFont, font, 1)
+	return control
+}
+
+func (gui *collectionWindow) windowProc(window uintptr, message uintptr, wParam uintptr, lParam uintptr) uintptr {
+	switch message {
+	case wmCommand:
+		if wParam&0xffff == collectButtonID && wParam>>16 == bnClicked {
+			gui.start()
+			return 0
+		}
+	case wmStatusChanged:
+		gui.showStatus()
+		return 0
+	case wmClose:
+		running := false
+		gui.status.update(func(status *collectionStatus) { running = status.running })
+		if running && messageBox(window, "A collection is still running and closing the window won't stop it. Close anyway?", mbYesNo|mbIconWarning) != idYes {
+			return 0
+		}
+		procDestroyWindow.Call(window)
+		return 0
+	case wmDestroy:
+		procPostQuitMessage.Call(0)
+		return 0
+	}
+	result, _, _ := procDefWindowProcW.Call(window, message, wParam, lParam)
+	return result
+}
+
+// start runs the collection with the profile and destination picked.
+func (gui *collectionWindow) start() {
+	selection, _, _ := procSendMessageW.Call(gui.profileList, cbGetCurSel, 0, 0)
+	destination := strings.TrimSpace(getText(gui.destination))
+	if int(selection) < 0 || int(selection) >= len(gui.profiles) || destination == "" {
+		messageBox(gui.window, "Pick a profile and where to save the collection first.", mbOK|mbIconWarning)
+		return
+	}
+	gui.status.update(func(status *collectionStatus) {
+		status.running, status.finished, status.files, status.messages, status.err = true, false, 0, nil, nil
+		status.progress = collector.Progress{}
+	})
+	gui.reported = false
+	procEnableWindow.Call(gui.button, 0)
+	procEnableWindow.Call(gui.profileList, 0)
+	procEnableWindow.Call(gui.destination, 0)
+	setText(gui.statusText, "Starting...")
+	window := gui.window
+	go collect(gui.collector, gui.profiles[selection], destination, &gui.status, func() {
+		procPostMessageW.Call(window, wmStatusChanged, 0, 0)
+	})
+}
+
+// showStatus brings the progress bar and status line up to date, and says how it went once the collection is done.
+func (gui *collectionWindow) showStatus() {
+	var progress collector.Progress
+	var files int
+	var messages []string
+	var err error
+	var finished bool
+	gui.status.update(func(status *collectionStatus) {
+		progress, files, err, finished = status.progress, status.files, status.err, status.finished
+		messages = append(messages, status.messages...)
+	})
+
+	percent := 0
+	if progress.TotalBytes > 0 {
+		percent = int(progress.BytesRead * 100 / progress.TotalBytes)
+	}
+	if progress.Stage == collector.StageDone || (finished && err == nil) {
+		percent = 100
+	}
+	procSendMessageW.Call(gui.progressBar, pbmSetPos, uintptr(percent), 0)
+	setText(gui.statusText, fmt.Sprintf("%d files copied. Now: %s %s", files, progress.Stage, progress.FileName))
+	if !finished || gui.reported {
+		return
+	}
+	gui.reported = true
+
+	procEnableWindow.Call(gui.button, 1)
+	procEnableWindow.Call(gui.profileList, 1)
+	procEnableWindow.Call(gui.destination, 1)
+	summary := fmt.Sprintf("The collection is done, %d files were copied into %s.", files, getText(gui.destination))
+	style := uintptr(mbOK | mbIconInformation)
+	if err != nil {
+		summary, style = err.Error(), mbOK|mbIconError
+	}
+	if len(messages) != 0 {
+		summary += "\n\n" + strings.Join(messages, "\n")
+	}
+	setText(gui.statusText, summary)
+	messageBox(gui.window, summary, style)
+}
+
+func showError(window uintptr, text string) {
+	messageBox(window, text, mbOK|mbIconError)
+}
+
+func messageBox(window uintptr, text string, style uintptr) int {
+	result, _, _ := procMessageBoxW.Call(window, uintptr(unsafe.Pointer(utf16Ptr(text))), uintptr(unsafe.Pointer(utf16Ptr("gofor-collector"))), style)
+	return int(result)
+}
+
+func setText(window uintptr, text string) {
+	procSetWindowTextW.Call(window, uintptr(unsafe.Pointer(utf16Ptr(text))))
+}
+
+func getText(window uintptr) string {
+	length, _, _ := procGetWindowTextLengthW.Call(window)
+	buffer := make([]uint16, length+1)
+	procGetWindowTextW.Call(window, uintptr(unsafe.Pointer(&buffer[0])), length+1)
+	return syscall.UTF16ToString(buffer)
+}
+
+// utf16Ptr converts a string for the API, which none of the strings here have a NUL in.
+func utf16Ptr(text string) *uint16 {
+	pointer, err := syscall.UTF16PtrFromString(text)
+	if err != nil {
+		pointer, _ = syscall.UTF16PtrFromString(strings.Replace(text, "\x00", "", -1))
+	}
+	return pointer
+}
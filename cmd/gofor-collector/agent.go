@@ -0,0 +1,352 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// The agent serves a single server streaming gRPC method, /gofor.Collector/Collect. Messages are JSON rather than
+// protobuf so there is no generated code to keep in sync; clients call it with the "json" content subtype, send one
+// collectRequest, and get the zip back as a stream of collectResponse chunks. The last message carries the report.
+
+// agentChunkSize is how much of the zip goes in each message.
+const agentChunkSize = 256 * 1024
+
+type collectRequest struct {
+	Gather            string                              `json:"gather"`                      // data type abbreviations, the same as for /g
+	Targets           collector.ListOfFilesToExport       `json:"targets"`                     // extra targets on top of the ones from Gather
+	IOCs              string                              `json:"iocs"`                        // IOCs in STIX 2 JSON or CSV to collect the files of, see --ioc
+	Codec             string                              `json:"codec"`                       // defaults to the agent's /c
+	Workers           int                                 `json:"workers"`                     // defaults to the agent's /w
+	ExportHives       bool                                `json:"export_hives"`                // see --export-hives
+	APIFallback       bool                                `json:"api_fallback"`                // see --api-fallback
+	ReadPolicy        collector.ReadPolicy                `json:"read_policy"`                 // see --read-policy
+	Budget            int64                               `json:"budget"`                      // see --budget
+	MaxFileSize       int64                               `json:"max_file_size"`               // see --max-file-size
+	MaxTotalSize      int64                               `json:"max_total_size"`              // see --max-total-size
+	MaxMatches        int                                 `json:"max_matches"`                 // see --max-matches
+	Warnings          bool                                `json:"warnings"`                    // see --warnings
+	FileMetadata      bool                                `json:"file_metadata"`               // see --file-metadata
+	Deduplicate       bool                                `json:"dedup"`                       // see --dedup
+	Verify            bool                                `json:"verify"`                      // see --verify
+	AuditLog          bool                                `json:"audit_log"`                   // see --audit-log
+	ETW               bool                                `json:"etw"`                         // see --etw
+	CaseSensitive     bool                                `json:"case_sensitive"`              // see --case-sensitive
+	RecoverDeleted    bool                                `json:"recover_deleted"`             // see --recover-deleted
+	IndexDirectories  []collector.IndexDirectory          `json:"index_directories"`           // see --i30
+	Ranges            []collector.VolumeRange             `json:"ranges"`                      // see --range
+	BootRecords       bool                                `json:"boot_records"`                // see --boot-records
+	Timeline          collector.TimelineFormat            `json:"timeline"`                    // see --timeline
+	DirectoryTree     collector.DirectoryTreeScope        `json:"directory_tree"`              // see --directory-tree
+	EvtxJSON          string                              `json:"evtx_json"`                   // alongside or instead, see --evtx-json
+	Processors        map[string]string                   `json:"processors"`                  // the processor chain of each data type in gather, e.g. {"e": "evtx_json,gzip"}, see --process
+	ProcessInstead    bool                                `json:"process_instead"`             // see --process-instead
+	YaraRules         string                              `json:"yara_rules"`                  // the source of the YARA rules, see --yara
+	BitLockerPassword string                              `json:"bitlocker_recovery_password"` // see --bitlocker-recovery-password
+	BitLockerKey      string                              `json:"bitlocker_recovery_key"`      // see --bitlocker-recovery-key
+	ChangedSince      map[string]collector.USNJournalMark `json:"changed_since"`               // the usn_journal marks from an earlier report, see --since-report
+	ChangedAfter      time.Time                           `json:"changed_after"`               // see --changed-since
+	MemoryFileLimit   int64                               `json:"memory_file_limit"`           // defaults to the agent's --memory-file-limit
+	Memory            string                              `json:"memory"`                      // see --memory
+	Commands          []collector.Command                 `json:"commands"`                    // see --commands, agents only run them with --agent-allow-commands
+	EventLogChannels  []collector.EventLogChannel         `json:"event_log_channels"`          // see --event-log-channels
+	RegistryKeys      []collector.RegistryKey             `json:"registry_keys"`               // see --registry-keys
+	WMIQueries        []collector.WMIQuery                `json:"wmi_queries"`                 // see --wmi-queries
+}
+
+type collectResponse struct {
+	Chunk  []byte                      `json:"chunk,omitempty"`
+	Report *collector.CollectionReport `json:"report,omitempty"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type collectorServer interface {
+	Collect(request *collectRequest, stream grpc.ServerStream) error
+}
+
+var collectorServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gofor.Collector",
+	HandlerType: (*collectorServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Collect",
+			Handler:       collectHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "cmd/gofor-collector/agent.go",
+}
+
+func collectHandler(server interface{}, stream grpc.ServerStream) error {
+	request := new(collectRequest)
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	return server.(collectorServer).Collect(request, stream)
+}
+
+// agent runs collections on behalf of a central server, one at a time since they compete for the same disks.
+type agent struct {
+	opts     *options
+	busy     chan struct{}
+	mftCache *collector.MFTCache // nil unless --mft-cache is set
+}
+
+func (agent *agent) Collect(request *collectRequest, stream grpc.ServerStream) (err error) {
+	select {
+	case agent.busy <- struct{}{}:
+		defer func() { <-agent.busy }()
+	default:
+		return status.Error(codes.ResourceExhausted, "a collection is already running")
+	}
+
+	if len(request.Commands) != 0 && !agent.opts.AgentAllowCommands {
+		return status.Error(codes.PermissionDenied, "the agent doesn't run commands without --agent-allow-commands")
+	}
+	exportList, codec, collectOptions, err := request.collection(agent.opts)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	collectOptions.MFTCache = agent.mftCache
+	if client, ok := peer.FromContext(stream.Context()); ok {
+		log.Infof("Starting a collection requested by %s with %d targets.", client.Addr, len(exportList))
+		collectOptions.Logger = log.WithField("client", client.Addr.String())
+	}
+
+	output := bufio.NewWriterSize(&streamWriter{stream: stream}, agentChunkSize)
+	resultWriter := collector.ZipResultWriter{
+		ZipWriter: zip.NewWriter(collector.NewThrottledWriter(output, agent.opts.WriteLimit)),
+		Codec:     codec,
+		Logger:    collectOptions.Logger,
+	}
+	report, err := collector.NewCollector(collectOptions).CollectWithReport(stream.Context(), exportList, &resultWriter)
+	var collectionErrors collector.CollectionErrors
+	if stream.Context().Err() != nil {
+		return status.FromContextError(stream.Context().Err()).Err()
+	} else if err != nil && !errors.As(err, &collectionErrors) {
+		return status.Errorf(codes.Internal, "collection failed: %v", err)
+	}
+
+	// The result writer has closed the zip, so everything left is in the buffer
+	err = output.Flush()
+	if err != nil {
+		return
+	}
+	err = stream.SendMsg(&collectResponse{Report: &report})
+	log.Infof("Finished a collection, %d of %d files collected.", report.FilesCollected, report.FilesMatched)
+	return
+}
+
+// collection works out what a request collects and how, taking the codec, workers and memory file limit from the command line when the
+// request leaves them out.
+func (request *collectRequest) collection(opts *options) (exportList collector.ListOfFilesToExport, codec string, collectOptions collector.CollectOptions, err error) {
+	if request.Gather != "" {
+		memoryFileLimit := request.MemoryFileLimit
+		if memoryFileLimit == 0 {
+			memoryFileLimit = opts.MemoryFileLimit
+		}
+		exportList = exportListForDataTypes(request.Gather, memoryFileLimit, len(request.EventLogChannels) == 0)
+		exportList = processedTargets(exportList, request.Processors, memoryFileLimit, len(request.EventLogChannels) == 0)
+	}
+	exportList = append(exportList, request.Targets...)
+	if request.IOCs != "" {
+		iocs, skipped, parseErr := collector.ParseIOCs([]byte(request.IOCs))
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		iocTargets, skippedTargets := collector.IOCTargets(iocs, opts.SHA256Path)
+		for _, skip := range append(skipped, skippedTargets...) {
+			log.Warnf("Skipping IOC '%s': %s", skip.Target, skip.Reason)
+		}
+		exportList = append(exportList, iocTargets...)
+	}
+	if request.CaseSensitive {
+		exportList = caseSensitiveTargets(exportList)
+	}
+	acquirers, err := acquirersForDataTypes(request.Gather, request.Memory, request.WMIQueries, request.RegistryKeys, request.EventLogChannels)
+	if err != nil {
+		return
+	}
+	if len(exportList) == 0 && len(acquirers) == 0 && len(request.Commands) == 0 {
+		err = errors.New("the request has no targets")
+		return
+	}
+	codec = request.Codec
+	if codec == "" {
+		codec = opts.Codec
+	}
+	if _, err = collector.LookupCodec(codec); err != nil {
+		return
+	}
+	workers := request.Workers
+	if workers == 0 {
+		workers = opts.Workers
+	}
+	var yaraRules *collector.YaraRules
+	if request.YaraRules != "" {
+		if yaraRules, err = collector.ParseYaraRules(request.YaraRules); err != nil {
+			return
+		}
+	}
+	collectOptions = collector.CollectOptions{
+		Workers:                   workers,
+		ParallelVolumes:           opts.ParallelVolumes,
+		ReadBytesPerSecond:        opts.ReadLimit,
+		ReadRetries:               opts.ReadRetries,
+		ReadRetryDelay:            opts.ReadRetryDelay,
+		PendingFiles:              opts.PendingFiles,
+		PendingBytes:              opts.PendingBytes,
+		CaptureClock:              true,
+		NTPServer:                 opts.NTPServer,
+		ExportHives:               request.ExportHives,
+		APIFallback:               request.APIFallback,
+		ByteBudget:                request.Budget,
+		MaxFileSize:               request.MaxFileSize,
+		MaxTotalSize:              request.MaxTotalSize,
+		MaxMatches:                request.MaxMatches,
+		DetectAntiForensics:       request.Warnings,
+		FileMetadata:              request.FileMetadata,
+		Deduplicate:               request.Deduplicate,
+		Verify:                    request.Verify,
+		AuditLog:                  request.AuditLog,
+		ETW:                       request.ETW,
+		OnAudit:                   opts.shipping.onAudit(),
+		RecoverDeleted:            request.RecoverDeleted,
+		IndexDirectories:          request.IndexDirectories,
+		Ranges:                    request.Ranges,
+		BootRecords:               request.BootRecords,
+		Timeline:                  request.Timeline,
+		DirectoryTree:             request.DirectoryTree,
+		Processors:                collectProcessors(request.EvtxJSON, yaraRules),
+		ReplaceProcessed:          request.EvtxJSON == "instead" || request.ProcessInstead,
+		BitLockerRecoveryPassword: request.BitLockerPassword,
+		BitLockerRecoveryKey:      request.BitLockerKey,
+		ChangedSince:              request.ChangedSince,
+		ChangedAfter:              request.ChangedAfter,
+		ReadPolicy:                request.ReadPolicy,
+		MinimalFootprint:          opts.MinimalFootprint,
+		StartedAs:                 os.Getenv(startedAsVariable),
+	}
+	collectOptions.Acquirers = acquirers
+	collectOptions.Commands = request.Commands
+	return
+}
+
+// streamWriter sends everything written to it down the stream as zip chunks.
+type streamWriter struct {
+	stream grpc.ServerStream
+}
+
+func (streamWriter *streamWriter) Write(data []byte) (numberOfBytesWritten int, err error) {
+	err = streamWriter.stream.SendMsg(&collectResponse{Chunk: data})
+	if err != nil {
+		return
+	}
+	numberOfBytesWritten = len(data)
+	return
+}
+
+// agentTLSConfig sets up mutual TLS: the agent presents its own certificate and only accepts clients with a certificate
+// signed by the given CA.
+func agentTLSConfig(certFile string, keyFile string, caFile string) (tlsConfig *tls.Config, err error) {
+	if certFile == "" || keyFile == "" || caFile == "" {
+		err = errors.New("agent mode needs --agent-cert, --agent-key and --agent-ca")
+		return
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		err = fmt.Errorf("failed to load the agent's certificate: %w", err)
+		return
+	}
+	caCertificate, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		err = fmt.Errorf("failed to read the CA certificate: %w", err)
+		return
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caCertificate) {
+		err = fmt.Errorf("no certificates found in %s", caFile)
+		return
+	}
+	tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return
+}
+
+// runAgent serves collection requests until interrupted.
+func runAgent(opts *options) (err error) {
+	tlsConfig, err := agentTLSConfig(opts.AgentCert, opts.AgentKey, opts.AgentCA)
+	if err != nil {
+		return
+	}
+	listener, err := net.Listen("tcp", opts.AgentListen)
+	if err != nil {
+		err = fmt.Errorf("failed to listen on %s: %w", opts.AgentListen, err)
+		return
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	collectionAgent := &agent{
+		opts: opts,
+		busy: make(chan struct{}, 1),
+	}
+	if opts.MFTCache > 0 {
+		collectionAgent.mftCache = collector.NewMFTCache(opts.MFTCache)
+		defer collectionAgent.mftCache.Close()
+	}
+	server.RegisterService(&collectorServiceDesc, collectionAgent)
+
+	// Stopping the server cancels a running collection, which closes out its zip before the stream ends
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		log.Error("Received an interrupt, stopping the agent.")
+		server.Stop()
+	}()
+
+	log.Infof("Agent listening on %s.", listener.Addr())
+	err = server.Serve(listener)
+	return
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strings"
+	"time"
+)
+
+// The actions the control subcommand sends, one a line.
+const (
+	controlPause          = "pause"
+	controlResume         = "resume"
+	controlLowPriority    = "low-priority"
+	controlNormalPriority = "normal-priority"
+	controlStatus         = "status"
+)
+
+// controlCommand is the control subcommand, which pauses, resumes or changes the priority of a collection started with
+// --control.
+type controlCommand struct {
+	Address string `short:"a" long:"address" required:"true" description:"The address the collection was given with --control, e.g. 127.0.0.1:7601."`
+	Args    struct {
+		Action string `positional-arg-name:"action" required:"true" choice:"pause" choice:"resume" choice:"low-priority" choice:"normal-priority" choice:"status"`
+	} `positional-args:"true"`
+}
+
+func (command *controlCommand) Execute(args []string) (err error) {
+	connection, err := net.DialTimeout("tcp", command.Address, 10*time.Second)
+	if err != nil {
+		err = fmt.Errorf("failed to connect to the collection at %s: %w", command.Address, err)
+		return
+	}
+	defer connection.Close()
+	_ = connection.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err = fmt.Fprintln(connection, command.Args.Action); err != nil {
+		err = fmt.Errorf("failed to send '%s' to the collection: %w", command.Args.Action, err)
+		return
+	}
+	reply, err := bufio.NewReader(connection).ReadString('\n')
+	if err != nil {
+		err = fmt.Errorf("the collection didn't reply to '%s': %w", command.Args.Action, err)
+		return
+	}
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "error: ") {
+		err = fmt.Errorf("the collection failed to %s: %s", command.Args.Action, strings.TrimPrefix(reply, "error: "))
+		return
+	}
+	fmt.Printf("The collection is %s.\n", reply)
+	return
+}
+
+// listenForControl listens on a loopback address for the control subcommand. Only loopback addresses are allowed, so
+// nothing off the host can pause a collection.
+func listenForControl(address string, control *collector.CollectionControl) (listener net.Listener, err error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		err = fmt.Errorf("invalid control address '%s': %w", address, err)
+		return
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		err = fmt.Errorf("the control address '%s' isn't a loopback address", address)
+		return
+	}
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		err = fmt.Errorf("failed to listen for control on %s: %w", address, err)
+		return
+	}
+	go func() {
+		for {
+			connection, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				// The listener was closed when the collection finished
+				return
+			}
+			go handleControl(connection, control)
+		}
+	}()
+	return
+}
+
+// handleControl carries out the actions sent on a connection, replying to each with the collection's state.
+func handleControl(connection net.Conn, control *collector.CollectionControl) {
+	defer connection.Close()
+	scanner := bufio.NewScanner(connection)
+	for scanner.Scan() {
+		var err error
+		action := strings.TrimSpace(scanner.Text())
+		switch action {
+		case controlPause:
+			control.Pause()
+		case controlResume:
+			control.Resume()
+		case controlLowPriority:
+			err = control.SetLowPriority(true)
+		case controlNormalPriority:
+			err = control.SetLowPriority(false)
+		case controlStatus:
+		default:
+			err = fmt.Errorf("unknown action '%s'", action)
+		}
+		if err != nil {
+			log.Warnf("Failed to carry out '%s' sent through the control address: %v", action, err)
+			_, _ = fmt.Fprintf(connection, "error: %v\n", err)
+			continue
+		}
+		if action != controlStatus {
+			log.Infof("The collection was sent '%s' through the control address.", action)
+		}
+		_, _ = fmt.Fprintln(connection, controlState(control))
+	}
+}
+
+// controlState describes the collection's state, e.g. "paused at low priority".
+func controlState(control *collector.CollectionControl) (state string) {
+	state = "running"
+	if control.Paused() {
+		state = "paused"
+	}
+	if control.LowPriority() {
+		state += " at low priority"
+	}
+	return
+}
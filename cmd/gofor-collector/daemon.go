@@ -0,0 +1,321 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// daemonProfile is the JSON file given to --daemon. What to collect is given the same way as in an agent's
+// collectRequest, e.g.
+//
+//	{"schedule": "0 */6 * * *", "output_directory": "D:\\triage", "keep": 28, "gather": "mre", "incremental": true}
+type daemonProfile struct {
+	collectRequest
+	Schedule        string `json:"schedule"`         // cron expression in local time, or @hourly, @daily, @weekly or @monthly
+	OutputDirectory string `json:"output_directory"` // where the zips are written
+	Name            string `json:"name"`             // the zips are named <name>-<UTC time>.zip, defaults to the hostname
+	Keep            int    `json:"keep"`             // how many zips to keep, the oldest are removed first. 0 keeps them all
+	Incremental     bool   `json:"incremental"`      // collect only the files changed since the previous collection
+}
+
+func loadDaemonProfile(path string) (profile daemonProfile, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("failed to read the daemon profile: %w", err)
+		return
+	}
+	err = json.Unmarshal(data, &profile)
+	if err != nil {
+		err = fmt.Errorf("failed to parse the daemon profile %s: %w", path, err)
+		return
+	}
+	if profile.OutputDirectory == "" {
+		err = errors.New("the daemon profile has no output_directory")
+		return
+	}
+	if profile.Name == "" {
+		profile.Name, _ = os.Hostname()
+	}
+	return
+}
+
+// runDaemon runs collections on the profile's schedule until it's interrupted. A collection that's still running when
+// the next one is due delays it to the following scheduled time.
+func runDaemon(opts *options) (err error) {
+	profile, err := loadDaemonProfile(opts.Daemon)
+	if err != nil {
+		return
+	}
+	collectionSchedule, err := parseSchedule(profile.Schedule)
+	if err != nil {
+		return
+	}
+	// Check the targets and codec up front rather than at the first scheduled collection
+	if _, _, _, err = profile.collection(opts); err != nil {
+		err = fmt.Errorf("the daemon profile is invalid: %w", err)
+		return
+	}
+	err = os.MkdirAll(profile.OutputDirectory, 0755)
+	if err != nil {
+		err = fmt.Errorf("failed to create the output directory %s: %w", profile.OutputDirectory, err)
+		return
+	}
+	var mftCache *collector.MFTCache
+	if opts.MFTCache > 0 {
+		mftCache = collector.NewMFTCache(opts.MFTCache)
+		defer mftCache.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		log.Error("Received an interrupt, stopping the daemon.")
+		cancel()
+	}()
+
+	for {
+		next, found := collectionSchedule.next(time.Now())
+		if !found {
+			err = fmt.Errorf("the schedule '%s' never runs", profile.Schedule)
+			return
+		}
+		log.Infof("Next collection at %s.", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		report, collectErr := collectForDaemon(ctx, opts, &profile, next, mftCache)
+		if ctx.Err() != nil {
+			return
+		}
+		var collectionErrors collector.CollectionErrors
+		if collectErr != nil && !errors.As(collectErr, &collectionErrors) {
+			log.Errorf("The scheduled collection failed: %v", collectErr)
+			continue
+		}
+		log.Infof("Finished a scheduled collection, %d of %d files collected.", report.FilesCollected, report.FilesMatched)
+		if profile.Incremental {
+			profile.ChangedSince = report.USNJournalMarks()
+		}
+		pruneDaemonOutput(profile)
+	}
+}
+
+// collectForDaemon runs one scheduled collection into a zip named after the time it was scheduled for.
+func collectForDaemon(ctx context.Context, opts *options, profile *daemonProfile, scheduled time.Time, mftCache *collector.MFTCache) (report collector.CollectionReport, err error) {
+	exportList, codec, collectOptions, err := profile.collection(opts)
+	if err != nil {
+		return
+	}
+	collectOptions.MFTCache = mftCache
+	collectOptions.Logger = log.WithField("profile", profile.Name)
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	zipName := filepath.Join(profile.OutputDirectory, fmt.Sprintf("%s-%s.zip", profile.Name, scheduled.UTC().Format("20060102T150405Z")))
+	fileHandle, err := collector.CreatePartialFile(zipName)
+	if err != nil {
+		err = fmt.Errorf("failed to create zip file %s: %w", zipName, err)
+		return
+	}
+	log.Infof("Starting a scheduled collection into %s with %d targets.", zipName, len(exportList))
+	resultWriter := collector.ZipResultWriter{
+		ZipWriter:  zip.NewWriter(collector.NewThrottledWriter(fileHandle, opts.WriteLimit)),
+		FileHandle: fileHandle,
+		Codec:      codec,
+		Logger:     collectOptions.Logger,
+	}
+	report, err = collector.NewCollector(collectOptions).CollectWithReport(ctx, exportList, &resultWriter)
+	return
+}
+
+// pruneDaemonOutput removes the oldest of the daemon's zips beyond the number the profile keeps. Only files named the
+// way the daemon names its zips are touched.
+func pruneDaemonOutput(profile daemonProfile) {
+	if profile.Keep <= 0 {
+		return
+	}
+	zipNames, err := filepath.Glob(filepath.Join(profile.OutputDirectory, profile.Name+"-*.zip"))
+	if err != nil {
+		log.Errorf("Failed to list the daemon's zips: %v", err)
+		return
+	}
+	var ours []string
+	for _, zipName := range zipNames {
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(zipName), profile.Name+"-"), ".zip")
+		if _, parseErr := time.Parse("20060102T150405Z", timestamp); parseErr == nil {
+			ours = append(ours, zipName)
+		}
+	}
+	// The UTC timestamps in the names sort oldest first
+	sort.Strings(ours)
+	for len(ours) > profile.Keep {
+		log.Infof("Removing %s to keep the newest %d zips.", ours[0], profile.Keep)
+		if removeErr := os.Remove(ours[0]); removeErr != nil {
+			log.Errorf("Failed to remove %s: %v", ours[0], removeErr)
+		}
+		ours = ours[1:]
+	}
+}
+
+// schedule is a parsed cron expression. Each field is a bit set of the values it matches.
+type schedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+	anyDay      bool // the day of month field is *, so only the day of week restricts the day
+	anyWeekday  bool // the day of week field is *, so only the day of month restricts the day
+}
+
+var scheduleDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule parses a five field cron expression: minute, hour, day of month, month and day of week. Fields can be
+// *, a number, a range such as 1-5, a list of those, and take a step such as */15. Sunday is 0 or 7.
+func parseSchedule(expression string) (parsed schedule, err error) {
+	if descriptor, ok := scheduleDescriptors[strings.TrimSpace(expression)]; ok {
+		expression = descriptor
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		err = fmt.Errorf("the schedule '%s' doesn't have five fields", expression)
+		return
+	}
+	limits := []struct {
+		field   *uint64
+		minimum int
+		maximum int
+	}{
+		{&parsed.minutes, 0, 59},
+		{&parsed.hours, 0, 23},
+		{&parsed.daysOfMonth, 1, 31},
+		{&parsed.months, 1, 12},
+		{&parsed.daysOfWeek, 0, 7},
+	}
+	for index, limit := range limits {
+		*limit.field, err = parseScheduleField(fields[index], limit.minimum, limit.maximum)
+		if err != nil {
+			err = fmt.Errorf("the schedule '%s' is invalid: %w", expression, err)
+			return
+		}
+	}
+	// Sunday can be written as 7
+	if parsed.daysOfWeek&(1<<7) != 0 {
+		parsed.daysOfWeek |= 1
+	}
+	parsed.anyDay = fields[2] == "*"
+	parsed.anyWeekday = fields[4] == "*"
+	return
+}
+
+func parseScheduleField(field string, minimum int, maximum int) (set uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash != -1 {
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step < 1 {
+				err = fmt.Errorf("'%s' has an invalid step", part)
+				return
+			}
+			part = part[:slash]
+		}
+		low, high := minimum, maximum
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				err = fmt.Errorf("'%s' isn't a number", bounds[0])
+				return
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					err = fmt.Errorf("'%s' isn't a number", bounds[1])
+					return
+				}
+			} else if step != 1 {
+				// 5/10 means from 5 to the maximum every 10
+				high = maximum
+			}
+		}
+		if low < minimum || high > maximum || low > high {
+			err = fmt.Errorf("'%s' is outside %d-%d", part, minimum, maximum)
+			return
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return
+}
+
+// next returns the first time after the given time that the schedule matches, to the minute. found is false when the
+// schedule can't match in the next five years, such as on February 30.
+func (parsed schedule) next(after time.Time) (next time.Time, found bool) {
+	next = after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		switch {
+		case parsed.months&(1<<uint(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !parsed.matchesDay(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case parsed.hours&(1<<uint(next.Hour())) == 0:
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+		case parsed.minutes&(1<<uint(next.Minute())) == 0:
+			next = next.Add(time.Minute)
+		default:
+			found = true
+			return
+		}
+	}
+	return
+}
+
+// matchesDay follows cron in matching either the day of month or the day of week when both are restricted.
+func (parsed schedule) matchesDay(day time.Time) bool {
+	dayOfMonth := parsed.daysOfMonth&(1<<uint(day.Day())) != 0
+	dayOfWeek := parsed.daysOfWeek&(1<<uint(day.Weekday())) != 0
+	switch {
+	case parsed.anyDay && parsed.anyWeekday:
+		return true
+	case parsed.anyDay:
+		return dayOfWeek
+	case parsed.anyWeekday:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
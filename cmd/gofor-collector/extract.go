@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// extractCommand is the extract subcommand, which expands a collection on the receiving side.
+type extractCommand struct {
+	Output    string `short:"o" long:"output" required:"true" description:"Directory to extract the files into."`
+	PublicKey string `long:"public-key" description:"PEM encoded ed25519 public key the index of the tar or zip has to be signed with, as does the archive's .sig when there is one."`
+	Args      struct {
+		Archive string `positional-arg-name:"archive" required:"true"`
+	} `positional-args:"true"`
+}
+
+func (command *extractCommand) Execute(args []string) (err error) {
+	var publicKey ed25519.PublicKey
+	if command.PublicKey != "" {
+		publicKey, err = loadPublicKey(command.PublicKey)
+		if err != nil {
+			return
+		}
+		if _, statErr := os.Stat(command.Args.Archive + ".sig"); statErr == nil {
+			signature, verifyErr := collector.VerifyArchiveSignature(command.Args.Archive, publicKey)
+			if verifyErr != nil {
+				err = verifyErr
+				return
+			}
+			fmt.Printf("The archive signature is valid, it was collected by version %s with SHA-256 %s and signed at %s.\n", signature.Tool.Version, signature.Tool.SHA256, signature.SignedAt.Format(time.RFC3339))
+		}
+	}
+	result, err := collector.ExtractArchive(command.Args.Archive, command.Output, publicKey)
+	if err != nil {
+		return
+	}
+	fmt.Printf("Extracted %d files from the %s archive into %s.\n", len(result.Files), result.Format, command.Output)
+	if result.Truncated != "" {
+		fmt.Printf("Warning: the archive ends partway through %s, only what was there of it was extracted.\n", result.Truncated)
+	}
+
+	verification := result.Verification
+	if verification == nil {
+		return
+	}
+	if verification.Signed {
+		fmt.Println("The index signature is valid.")
+	}
+	if !verification.Complete {
+		fmt.Println("Warning: the collection was cancelled before it finished.")
+	}
+	if verification.Truncated {
+		fmt.Printf("Warning: the archive is truncated, only these %d files can be trusted: %s\n", len(verification.Entries), strings.Join(verification.Entries, ", "))
+	}
+	if len(verification.Corrupt) != 0 {
+		err = fmt.Errorf("%d files don't match their hashes: %s", len(verification.Corrupt), strings.Join(verification.Corrupt, ", "))
+	}
+	return
+}
+
+// loadPublicKey reads a PEM encoded PKIX ed25519 public key.
+func loadPublicKey(path string) (publicKey ed25519.PublicKey, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("failed to read the public key: %w", err)
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = fmt.Errorf("no PEM data found in %s", path)
+		return
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		err = fmt.Errorf("failed to parse the public key: %w", err)
+		return
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		err = fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return
+}
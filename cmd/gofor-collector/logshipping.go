@@ -0,0 +1,318 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The log is shipped in batches of up to this many entries, or whatever has been logged each interval.
+const (
+	logShippingBatch    = 100
+	logShippingInterval = time.Second
+	logShippingQueue    = 10000
+	logShippingTimeout  = 10 * time.Second
+)
+
+// shippedEntry is a log entry waiting to be shipped, already formatted as JSON.
+type shippedEntry struct {
+	level log.Level
+	time  time.Time
+	line  []byte
+}
+
+// logShipping is a logrus hook that ships the log to a syslog server or an HTTP endpoint as it's written, so the
+// collections of many endpoints can be watched from one place. Entries are queued and shipped in the background so a
+// slow or unreachable server never holds up the collection; once the queue is full entries are dropped and counted.
+// Like the collector's trackers its methods do nothing when it's nil.
+type logShipping struct {
+	destination string
+	levels      []log.Level
+	formatter   log.Formatter
+	send        func(batch []shippedEntry) error
+	entries     chan shippedEntry
+	done        chan struct{}
+	dropped     int64
+	failed      sync.Once
+}
+
+// startLogShipping ships the log where --syslog or --log-url say, at --ship-log-level, raising the level of the
+// standard logger when it has to without logging any more locally than before.
+func startLogShipping(opts *options) (shipping *logShipping, err error) {
+	if opts.Syslog == "" && opts.LogURL == "" {
+		return
+	}
+	if opts.Syslog != "" && opts.LogURL != "" {
+		err = errors.New("the log can be shipped to --syslog or --log-url, not both")
+		return
+	}
+	level, err := log.ParseLevel(opts.ShipLogLevel)
+	if err != nil {
+		err = fmt.Errorf("invalid --ship-log-level: %w", err)
+		return
+	}
+	shipping = &logShipping{
+		levels:    levelsUpTo(level),
+		formatter: &log.JSONFormatter{},
+		entries:   make(chan shippedEntry, logShippingQueue),
+		done:      make(chan struct{}),
+	}
+	if opts.Syslog != "" {
+		shipping.destination = opts.Syslog
+		shipping.send, err = syslogSender(opts.Syslog)
+	} else {
+		shipping.destination = opts.LogURL
+		shipping.send, err = httpLogSender(opts.LogURL, opts.LogURLAuth)
+	}
+	if err != nil {
+		shipping = nil
+		return
+	}
+
+	logger := log.StandardLogger()
+	if localLevel := logger.GetLevel(); level > localLevel {
+		// Keep logging locally at the level asked for, through a hook, while the logger passes the shipped entries on
+		logger.AddHook(&writerHook{writer: logger.Out, formatter: logger.Formatter, levels: levelsUpTo(localLevel)})
+		logger.SetOutput(ioutil.Discard)
+		logger.SetLevel(level)
+	}
+	logger.AddHook(shipping)
+	go shipping.run()
+	return
+}
+
+func levelsUpTo(level log.Level) (levels []log.Level) {
+	for _, each := range log.AllLevels {
+		if each <= level {
+			levels = append(levels, each)
+		}
+	}
+	return
+}
+
+// Levels is the levels of the entries that are shipped.
+func (shipping *logShipping) Levels() []log.Level {
+	return shipping.levels
+}
+
+// Fire queues an entry to be shipped.
+func (shipping *logShipping) Fire(entry *log.Entry) (err error) {
+	line, err := shipping.formatter.Format(entry)
+	if err != nil {
+		return
+	}
+	select {
+	case shipping.entries <- shippedEntry{level: entry.Level, time: entry.Time, line: bytes.TrimRight(line, "\n")}:
+	default:
+		atomic.AddInt64(&shipping.dropped, 1)
+	}
+	return
+}
+
+// onAudit ships the steps of a collection's audit log along with the log, whatever the level, or is nil when the log
+// isn't shipped.
+func (shipping *logShipping) onAudit() func(entry collector.AuditEntry) {
+	if shipping == nil {
+		return nil
+	}
+	return func(auditEntry collector.AuditEntry) {
+		entry := log.NewEntry(log.StandardLogger()).WithFields(log.Fields{
+			"audit":    auditEntry.Event,
+			"sequence": auditEntry.Sequence,
+			"volume":   auditEntry.Volume,
+			"path":     auditEntry.Path,
+			"detail":   auditEntry.Detail,
+		})
+		entry.Time, entry.Level, entry.Message = auditEntry.Time, log.InfoLevel, "audit: "+auditEntry.Event
+		_ = shipping.Fire(entry)
+	}
+}
+
+// run ships the queued entries in batches until the queue is closed.
+func (shipping *logShipping) run() {
+	defer close(shipping.done)
+	ticker := time.NewTicker(logShippingInterval)
+	defer ticker.Stop()
+	var batch []shippedEntry
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := shipping.send(batch); err != nil {
+			// The log can't be used to say so, it would only be queued up for shipping again
+			shipping.failed.Do(func() {
+				fmt.Fprintf(os.Stderr, "Warning: failed to ship the log to %s: %v\n", shipping.destination, err)
+			})
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case entry, ok := <-shipping.entries:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= logShippingBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// close ships what's left in the queue, giving up after a while if the server doesn't take it.
+func (shipping *logShipping) close() {
+	if shipping == nil {
+		return
+	}
+	close(shipping.entries)
+	select {
+	case <-shipping.done:
+	case <-time.After(logShippingTimeout):
+	}
+	if dropped := atomic.LoadInt64(&shipping.dropped); dropped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d log entries weren't shipped to %s since it couldn't keep up.\n", dropped, shipping.destination)
+	}
+}
+
+// syslogSender sends batches to a syslog server as RFC 5424 messages, each in its own datagram over UDP, or framed by
+// octet counting as RFC 6587 has it over TCP. The connection is made again after a batch fails.
+func syslogSender(address string) (send func(batch []shippedEntry) error, err error) {
+	parsed, err := url.Parse(address)
+	if err != nil || (parsed.Scheme != "udp" && parsed.Scheme != "tcp") || parsed.Host == "" {
+		err = fmt.Errorf("invalid --syslog '%s', it should look like udp://host:514 or tcp://host:601", address)
+		return
+	}
+	hostname, _ := os.Hostname()
+	header := fmt.Sprintf("%s gofor-collector %d - -", syslogField(hostname), os.Getpid())
+	var connection net.Conn
+	send = func(batch []shippedEntry) (err error) {
+		if connection == nil {
+			connection, err = net.DialTimeout(parsed.Scheme, parsed.Host, logShippingTimeout)
+			if err != nil {
+				return
+			}
+		}
+		_ = connection.SetWriteDeadline(time.Now().Add(logShippingTimeout))
+		for _, entry := range batch {
+			message := fmt.Sprintf("<%d>1 %s %s %s", syslogPriority(entry.level), entry.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), header, entry.line)
+			if parsed.Scheme == "tcp" {
+				message = fmt.Sprintf("%d %s", len(message), message)
+			}
+			if _, err = io.WriteString(connection, message); err != nil {
+				_ = connection.Close()
+				connection = nil
+				return
+			}
+		}
+		return
+	}
+	return
+}
+
+// syslogPriority is the priority of a log entry from the user-level facility.
+func syslogPriority(level log.Level) int {
+	const facilityUser = 1
+	severity := 7 // debug
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		severity = 2 // critical
+	case log.ErrorLevel:
+		severity = 3
+	case log.WarnLevel:
+		severity = 4
+	case log.InfoLevel:
+		severity = 6 // informational
+	}
+	return facilityUser*8 + severity
+}
+
+// syslogField is a header field with the characters RFC 5424 doesn't allow in one left out, or - when it's empty.
+func syslogField(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// httpLogSender POSTs batches to an HTTP endpoint as JSON lines.
+func httpLogSender(address string, auth string) (send func(batch []shippedEntry) error, err error) {
+	parsed, err := url.Parse(address)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		err = fmt.Errorf("invalid --log-url '%s', it should be an http or https URL", address)
+		return
+	}
+	client := &http.Client{Timeout: logShippingTimeout}
+	send = func(batch []shippedEntry) (err error) {
+		body := new(bytes.Buffer)
+		for _, entry := range batch {
+			body.Write(entry.line)
+			body.WriteByte('\n')
+		}
+		request, err := http.NewRequest(http.MethodPost, address, body)
+		if err != nil {
+			return
+		}
+		request.Header.Set("Content-Type", "application/x-ndjson")
+		if auth != "" {
+			request.Header.Set("Authorization", auth)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return
+		}
+		defer response.Body.Close()
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		if response.StatusCode/100 != 2 {
+			err = fmt.Errorf("the server replied %s", response.Status)
+		}
+		return
+	}
+	return
+}
+
+// writerHook writes the entries of its levels to a writer, for logging locally at a lower level than the logger's.
+type writerHook struct {
+	mutex     sync.Mutex
+	writer    io.Writer
+	formatter log.Formatter
+	levels    []log.Level
+}
+
+func (hook *writerHook) Levels() []log.Level {
+	return hook.levels
+}
+
+func (hook *writerHook) Fire(entry *log.Entry) (err error) {
+	line, err := hook.formatter.Format(entry)
+	if err != nil {
+		return
+	}
+	hook.mutex.Lock()
+	defer hook.mutex.Unlock()
+	_, err = hook.writer.Write(line)
+	return
+}
@@ -4,19 +4,123 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	collector "github.com/Go-Forensics/Windows-Collector"
 	"github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type options struct {
 	Debug string `short:"d" long:"debug" default:"" description:"Log debug information to output file."`
 	//SendTo             string   `short:"s" long:"sendto" required:"true" description:"Where to send collected files to." choice:"zip"`
-	ZipName            string `short:"z" long:"zipname" description:"Output file name for the zip." required:"true"`
-	DataTypesToCollect string `short:"g" long:"gather" default:"a" description:"Types of data to collect. Concatenate the abbreviation characters together for what you want. The order doesn't matter. Valid values are 'a' for all, 'm' for $MFT, 'r' for system registries, 'u' for user registries, 'e' for event logs, 'w' for web history. Examples: '/g mrue', '/g a'"`
+	ZipName            string        `short:"z" long:"zipname" description:"Output file name for the zip, the tar with --format tar, or the directory with --format directory. '-' writes the zip or tar to stdout, and an existing named pipe is written to as it is. Required unless running as an agent or daemon, or uploading."`
+	Timeout            time.Duration `short:"t" long:"timeout" description:"Stop the collection if it hasn't finished within this long, e.g. '30m'. The zip is left as its .partial file with whatever was collected up to that point."`
+	Codec              string        `short:"c" long:"codec" default:"deflate" description:"Compression codec for files in the zip. 'deflate' and 'store' are built in."`
+	Workers            int           `short:"w" long:"workers" default:"1" description:"How many files to read at the same time. Raising this helps on fast disks such as NVMe drives."`
+	ParallelVolumes    bool          `long:"parallel-volumes" description:"Parse the MFTs of all volumes being collected from at the same time."`
+	ReadLimit          int64         `long:"read-limit" description:"Maximum bytes per second to read from disk. 0 means unlimited."`
+	Control            string        `long:"control" description:"Listen on this loopback address, e.g. 127.0.0.1:7601, for the control subcommand to pause, resume or lower the priority of the collection while it runs."`
+	ReadRetries        int           `long:"read-retries" default:"3" description:"How many times to retry a raw read of a volume that fails, such as with a busy device or a CRC error, with a new handle to the volume, before giving up on the file."`
+	ReadRetryDelay     time.Duration `long:"read-retry-delay" default:"100ms" description:"How long to wait before the first retry of a failed raw read. It doubles for each retry after it."`
+	PendingFiles       int           `long:"pending-files" description:"How many files can be read ahead of writing the output, 100 when it isn't given or 8 with --minimal-footprint. Lower it when writing to a slow destination such as an upload, so the collector doesn't hold ever more files open waiting for it."`
+	PendingBytes       int64         `long:"pending-bytes" description:"Maximum bytes of files read ahead into memory, with more than one worker or --dedup, that can be waiting to be written to the output. 0 means no limit other than --pending-files, or 16 MiB with --minimal-footprint."`
+	WriteLimit         int64         `long:"write-limit" description:"Maximum bytes per second to write to the zip. 0 means unlimited."`
+	SplitSize          int64         `long:"split-size" description:"Split the zip or tar file into numbered parts of at most this many bytes, e.g. 4294967295 for a FAT32 USB stick, listed with their hashes in NAME.parts.json. 0 means one file."`
+	MaxFileSize        int64         `long:"max-file-size" description:"Skip matched files bigger than this many bytes, going by the MFT. Skipped files are listed in the report. 0 means no limit."`
+	MaxTotalSize       int64         `long:"max-total-size" description:"Skip matched files once the ones collected add up to this many bytes, in the order they are found. Unlike --budget nothing is prioritized. 0 means no limit."`
+	MaxMatches         int           `long:"max-matches" description:"Skip matched files after this many, in the order they are found. 0 means no limit."`
+	Budget             int64         `long:"budget" description:"Maximum bytes of files to collect, going by their sizes in the MFT. The most valuable targets are collected first and the rest are listed in budget_plan.json. 0 means no budget."`
+	Warnings           bool          `long:"warnings" description:"Look for signs of anti-forensics such as timestomped files, a deleted change journal, disabled prefetching and cleared event logs while the MFT is walked, and list them in warnings.json."`
+	Deduplicate        bool          `long:"dedup" description:"Write files with the same content, such as the same DLL on two volumes, into the output only once. The ones left out are listed in duplicates.json with the path of the copy that was collected."`
+	CaseSensitive      bool          `long:"case-sensitive" description:"Match the paths and names of targets in the case they're written in, so two files whose names only differ in case, as in a directory WSL made case-sensitive, are told apart. Files found at the same path in another case are collected under their own names either way."`
+	AuditLog           bool          `long:"audit-log" description:"Record every step of the collection into the output as audit.jsonl, apart from the log: the volumes opened, the privileges enabled, whether each file was read through the API or raw and why, and what was collected, skipped or failed."`
+	ETW                bool          `long:"etw" description:"Write the steps --audit-log records as ETW events from the Go-Forensics-Windows-Collector provider as the collection runs, along with how it finished, so EDR and SOC tooling watching the host can see it. It doesn't need --audit-log."`
+	Syslog             string        `long:"syslog" description:"Ship the log and the steps --audit-log records to this syslog server as RFC 5424 messages as the collection runs, e.g. 'udp://siem:514' or 'tcp://siem:601', so the collections of many endpoints can be watched in one place. It doesn't need --audit-log or --debug."`
+	LogURL             string        `long:"log-url" description:"Ship the log and the steps --audit-log records to this HTTP endpoint as the collection runs, POSTed as batches of JSON lines, instead of to --syslog."`
+	LogURLAuth         string        `long:"log-url-auth" description:"Authorization header to send with every request to --log-url, e.g. 'Bearer <token>'."`
+	ShipLogLevel       string        `long:"ship-log-level" default:"info" choice:"error" choice:"warning" choice:"info" choice:"debug" description:"The least severe log entries --syslog or --log-url ship, whatever is logged locally."`
+	Verify             bool          `long:"verify" description:"Read every collected file again once it's written, through the API when it was read raw and the other way round, and list the ones that don't match, such as raw copies cut short, in verification.json."`
+	Interactive        bool          `short:"i" long:"interactive" description:"Pick the categories to collect from a menu on the console, with a preview of how big each would be, instead of with /g. Asks for the output file too when there's no /z. Can't be combined with --run-once-as-service."`
+	DryRun             bool          `long:"dry-run" description:"Search the volumes and print the files that would be collected with their sizes and an estimate of the output's size as JSON, without collecting anything. The limits, budget and read policy are applied as they would be."`
+	RecoverDeleted     bool          `long:"recover-deleted" description:"Also match the targets against deleted file records in the MFT and recover their data into _deleted/ in the zip. _deleted/recovered.json lists how many of each file's clusters are in use again and how much to trust what was recovered."`
+	IndexDirectories   []string      `long:"i30" description:"Directory to collect the $I30 index of into i30/ in the zip, e.g. 'C:\\Windows\\Prefetch', can be repeated. The index's slack is carved for entries of files since deleted or renamed."`
+	IndexFormat        string        `long:"i30-format" default:"both" choice:"both" choice:"raw" choice:"parsed" description:"Write the --i30 indexes as their raw $INDEX_ROOT and $INDEX_ALLOCATION attributes, parsed into entries.json, or both."`
+	Ranges             []string      `long:"range" description:"Range of a volume to read raw into ranges/ in the zip as VOLUME:OFFSET:LENGTH in bytes, e.g. 'C:0:512' for the boot sector, or VOLUME:clusters:OFFSET:LENGTH in clusters, can be repeated."`
+	Timeline           string        `long:"timeline" choice:"bodyfile" choice:"csv" description:"Also write a timeline of every file and directory in the MFT of each NTFS volume collected from into timeline/ in the zip, built while the MFT is read for the search: a bodyfile for mactime and other timeline tools, or a CSV of every record with its $STANDARD_INFORMATION and $FILE_NAME timestamps."`
+	DirectoryTree      string        `long:"directory-tree" choice:"matched" choice:"all" description:"Also write the directory tree resolved from the MFT of each NTFS volume collected from into directory_tree/ in the zip, a CSV of each directory's path, MFT record number, parent record number and timestamps: 'matched' for the directories the matched files are in and those above them, or 'all' for every directory."`
+	EvtxJSON           string        `long:"evtx-json" choice:"alongside" choice:"instead" description:"Parse the collected .evtx event logs into JSON lines, a line for each event, written into the zip as FILE.evtx.jsonl as the logs are collected so a SIEM can ingest them right away: alongside the raw logs or instead of them."`
+	Processors         []string      `long:"process" description:"Run the files a data type collects through a chain of processors as they're written, as LETTER=NAME,NAME with the letter /g takes, e.g. 'e=evtx_json,gzip' for the event logs as gzipped JSON lines or 'r=sha256' for a .sha256 file next to each hive, can be repeated. The built in processors are evtx_json, sha256 and gzip. The processed copy goes alongside the raw file unless --process-instead is given."`
+	ProcessInstead     bool          `long:"process-instead" description:"Write the processed copies of --process and --evtx-json instead of the raw files."`
+	Yara               string        `long:"yara" description:"YARA rules file, or directory of .yar and .yara files, to scan every collected file with as it's written. The rules a file matches are written next to it as FILE.yara.json and listed in report.json. With --process-instead only those are written, which sweeps the host for the rules."`
+	BootRecords        bool          `long:"boot-records" description:"Also write the boot record of every NTFS volume collected from and its backup, and the first sectors of the disks under them with their MBR or GPT, into boot_records/ in the zip."`
+	BitLockerPassword  string        `long:"bitlocker-recovery-password" description:"Recovery password to unlock volumes BitLocker has locked with, so they can be collected from. They're locked again afterwards."`
+	BitLockerKey       string        `long:"bitlocker-recovery-key" description:"Path of a .bek recovery key file to unlock volumes BitLocker has locked with, if there's no --bitlocker-recovery-password or it doesn't work."`
+	FileMetadata       bool          `long:"file-metadata" description:"Write the $STANDARD_INFORMATION and $FILE_NAME timestamps, attributes, size, owner, group and DACL of every collected file into file_metadata.jsonl."`
+	SinceReport        string        `long:"since-report" description:"report.json of an earlier collection. Only target files the USN change journal shows were changed since then are collected. Volumes the journal can't vouch for are collected in full."`
+	Resume             string        `long:"resume" description:"State file of a collection that can be resumed, created by the first run. Rerunning the same command with it leaves out the files an earlier run wrote, and writes a zip, tar or directory output of a later run under a name with its run number, e.g. host.run2.zip, so nothing an earlier run wrote is overwritten. Uploads don't record what they wrote."`
+	ChangedSince       string        `long:"changed-since" description:"Only collect target files the USN change journal shows were changed after this time, e.g. '2020-03-01T00:00:00Z', on volumes --since-report has no mark for."`
+	Remote             string        `long:"remote" description:"Collect the targets from the administrative shares of this host instead of from the local volumes, e.g. '--remote WS042' reads %SYSTEMDRIVE% from \\\\WS042\\C$. The files are read through the API as the user running the collector, who needs to be an administrator there."`
+	NTPServer          string        `short:"n" long:"ntp" description:"NTP server to measure the system clock's skew against. Only use this when network egress is allowed."`
+	Format             string        `short:"f" long:"format" default:"zip" choice:"zip" choice:"tar" choice:"directory" description:"Output format. 'tar' streams the files with a hash per entry and a trailing index, so a truncated upload is detectable and still usable. 'directory' writes loose files under their original paths along with the same index."`
+	UploadURL          string        `long:"upload-url" description:"Upload the zip to this HTTPS endpoint in resumable chunks as it is collected instead of writing it to disk."`
+	UploadAuth         string        `long:"upload-auth" description:"Authorization header to send with every upload request, e.g. 'Bearer <token>'."`
+	AzureBlobURL       string        `long:"azure-blob-url" description:"Upload the zip to this Azure block blob as it is collected. The URL needs a SAS token that allows writes."`
+	GcsURL             string        `long:"gcs-url" description:"Upload the zip to this Google Cloud Storage object as it is collected, e.g. 'gs://bucket/host.zip'. Needs --gcs-token."`
+	GcsToken           string        `long:"gcs-token" description:"OAuth 2.0 access token for --gcs-url."`
+	Outputs            []string      `long:"output" description:"Also write the collection to this destination as KIND:DESTINATION, e.g. 'manifest:C:\\cases\\host.sha256' for a sha256sum manifest of the files, can be repeated. The kinds built in are zip, tar, directory, manifest, http and azure, and applications embedding the collector can register more. Every file is read once and written to all of them, and one failing doesn't stop the others."`
+	KapeTargets        string        `long:"kape-targets" description:"Directory of KAPE .tkape target files to collect. Compound targets are resolved against the same directory. Only these targets are collected unless /g is also given."`
+	Artifacts          string        `long:"artifacts" description:"ForensicArtifacts YAML file, or a directory of them such as the digital-forensics-artifacts repository's data directory, to collect the file artifacts of. Only these artifacts are collected unless /g is also given."`
+	ArtifactNames      []string      `long:"artifact" description:"Name of an artifact from --artifacts to collect, can be repeated. Defaults to every Windows artifact."`
+	Records            []string      `long:"record" description:"Collect the file with this MFT record number, such as one an EDR alert names, e.g. 'C:91234', or '91234' on the system drive. Can be repeated. Only these files are collected unless /g is also given."`
+	SHA256             []string      `long:"sha256" description:"Collect the files under --sha256-path with this SHA-256, can be repeated. Every file there is read to hash it. Only these files are collected unless /g is also given."`
+	SHA256Path         string        `long:"sha256-path" default:"%USERPROFILE%\\\\.*" description:"Full path regex of the files to hash for --sha256 and the hashes of --ioc."`
+	IOCs               string        `long:"ioc" description:"File of IOCs, in STIX 2 JSON or CSV, to collect the files of: file names are looked for anywhere on the system drive, paths where they are, and SHA-256s under --sha256-path. Indicators that aren't files are skipped. Only these files are collected unless /g is also given."`
+	Sweep              []string      `long:"sweep" description:"Collect every file with these extensions under --sweep-path, e.g. 'ps1,lnk,hta', matched on the file name alone while the MFT is read, can be repeated. Only these files are collected unless /g is also given."`
+	SweepPath          string        `long:"sweep-path" default:"%SYSTEMDRIVE%:" description:"Volume, e.g. 'D:', or directory, e.g. 'C:\\Users', to --sweep."`
+	SweepMaxSize       int64         `long:"sweep-max-size" description:"Leave out files --sweep finds that are bigger than this many bytes, going by the MFT. 0 means no limit."`
+	SweepWithin        time.Duration `long:"sweep-within" description:"Only collect files --sweep finds that were modified this long before the collection starts, e.g. 720h."`
+	SigningKey         string        `long:"signing-key" description:"PEM encoded PKCS #8 ed25519 private key to sign the index of the zip, tar or directory with. A zip or tar written to a file is also signed as a whole into the file's name with .sig added."`
+	ExportHives        bool          `long:"export-hives" description:"Save loaded registry hives with RegSaveKeyEx instead of copying them, giving clean hives that don't need their transaction logs replayed."`
+	APIFallback        bool          `long:"api-fallback" description:"Export a loaded registry hive with RegSaveKeyEx when copying its file from disk fails. report.json marks such hives as hive_export with the reason."`
+	MinimalFootprint   bool          `long:"minimal-footprint" description:"Keep what the collection leaves on the host to a minimum, for when an adversary may be watching the box: files are only read raw, nothing is spooled to temp files and no processes are started. Options that would, such as --workers above 1, --dedup, --verify, --export-hives, --format tar, --commands, the BitLocker recovery password and key and '/g i', which exports the firewall policy with netsh, are refused, the processes gathered by 'x' aren't hashed, and volumes that can't be read raw are failed rather than collected through the API. Unless --pending-files and --pending-bytes say otherwise, at most 8 files and 16 MiB of them wait to be written, so memory stays small. The report lists it under footprint."`
+	RandomName         bool          `long:"random-name" description:"Run as a copy of the collector under a random name next to it, removed once the collection is done, so it doesn't show up as gofor-collector in a process list. The report lists both names under footprint."`
+	ReadPolicy         string        `long:"read-policy" default:"api_first" choice:"api_first" choice:"raw_first" choice:"raw_only" choice:"api_only" description:"How files are read: through the API with raw reads to fall back on, raw with the API to fall back on, or only one of them. raw_only doesn't update access times or go through minifilter drivers. A target can set its own read_policy."`
+	AgentListen        string        `long:"agent-listen" description:"Run as a remote collection agent that serves gRPC collection requests on this address, e.g. ':8443', instead of collecting once."`
+	AgentCert          string        `long:"agent-cert" description:"TLS certificate the agent presents to clients."`
+	AgentKey           string        `long:"agent-key" description:"Private key for --agent-cert."`
+	AgentCA            string        `long:"agent-ca" description:"CA certificate that client certificates have to be signed by."`
+	AgentAllowCommands bool          `long:"agent-allow-commands" description:"Let collection requests sent to the agent run the commands they list. Without it requests with commands are refused."`
+	Daemon             string        `long:"daemon" description:"Run collections into zips on the schedule in this JSON profile, pruning the oldest, instead of collecting once. See the README for the profile's settings."`
+	MFTCache           time.Duration `long:"mft-cache" description:"Keep the MFT of each volume an agent or daemon collection reads and search it for later collections until it's this old, e.g. '10m', instead of reading it again. Collections that copy the $MFT or use --warnings always read it."`
+	MemoryFileLimit    int64         `long:"memory-file-limit" description:"Skip any of the memory files gathered with 'p' that are bigger than this many bytes. 0 collects them whatever their size."`
+	Memory             string        `long:"memory" description:"Capture physical memory into memory/physical_memory.raw before collecting files, reading it from the device of a memory acquisition driver that is already loaded, e.g. '\\\\.\\pmem' for WinPmem."`
+	RunOnceAsService   bool          `long:"run-once-as-service" description:"Run the collection as SYSTEM from a temporary Windows service that is removed again once it's done, for when an administrator's own token can't read some of the volumes or hives. Relative paths are kept relative to the current directory, but the output can't go to stdout."`
+	ServiceName        string        `long:"service-name" hidden:"true"`
+	ServiceDirectory   string        `long:"service-directory" hidden:"true"`
+	ServiceOutput      string        `long:"service-output" hidden:"true"`
+	Commands           string        `long:"commands" description:"JSON file listing commands to run, such as ipconfig /all, with their stdout and stderr captured into commands/ in the zip. See the README for the format."`
+	EventLogChannels   string        `long:"event-log-channels" description:"JSON file listing event log channels to export with the event log API, each with an optional XPath query selecting the events to keep, into eventlogs/ in the zip. The .evtx files 'e' would copy are left out. See the README for the format."`
+	RegistryKeys       string        `long:"registry-keys" description:"JSON file listing registry keys to read live through the registry API, with their values written to registry/ in the zip as JSON. They are read as well as the ones '/g k' reads. See the README for the format."`
+	WMIQueries         string        `long:"wmi-queries" description:"JSON file listing WMI queries to run, with their results written to wmi/ in the zip as JSON. They run as well as the ones '/g q' runs. See the README for the format."`
+	Progress           string        `short:"p" long:"progress" default:"none" choice:"none" choice:"bar" choice:"json" description:"Report collection progress to stderr as a progress bar or as periodic JSON events."`
+	DataTypesToCollect string        `short:"g" long:"gather" default:"a" description:"Types of data to collect. Concatenate the abbreviation characters together for what you want. The order doesn't matter. Valid values are 'a' for all of 'm', 'r', 'u', 'e', 'l', 's', 'v', 'w', 'h' and 'd', 'm' for $MFT, 'r' for the SYSTEM, SOFTWARE, SAM, SECURITY and DEFAULT hives with their logs, 'u' for user registries, 'e' for event logs, 'l' for each user's LNK files and jump lists, 's' for the Windows Search index and Activity Timeline, 'v' for Windows Defender logs, detection history and quarantine, 'w' for web history from the WebCache, Chrome, Edge and Firefox, 'h' for PowerShell history, transcripts and remoting configurations, RDP bitmap caches and Default.rdp, 'd' for OneDrive logs, the Dropbox file cache and Google Drive sync databases, 'b' for the EFI applications and boot configuration data on the EFI system partition, 'c' for crash dumps and Windows Error Reporting reports, 'o' for the IIS, Exchange and SQL Server logs found through the registry, 'n' for the NTFS $Boot, $Secure, $Bitmap and $AttrDef metadata files, 'p' for hiberfil.sys, pagefile.sys and swapfile.sys, 'x' for the running processes, network connections, logged on users, services and drivers, 'i' for the hosts file, firewall log, firewall policy from the registry and a netsh export, DNS cache, ARP table and network profiles, 'k' for the Run, Winlogon, Services, TypedPaths, USB and MountedDevices registry keys read live and 'q' for WMI queries of processes, services, startup commands, scheduled jobs, hotfixes, shadow copies and event subscriptions. Examples: '/g mrue', '/g a'"`
+
+	shipping *logShipping // where the log is shipped, if --syslog or --log-url say
 }
 
 func init() {
@@ -28,13 +132,59 @@ func init() {
 func main() {
 	opts := new(options)
 	parsedOpts := flags.NewParser(opts, flags.Default)
+	parsedOpts.SubcommandsOptional = true
+	_, _ = parsedOpts.AddCommand("extract", "Extract a collection", "Extract a zip or tar written by the collector into a directory, decompressing every codec and checking a tar's hashes, index and signature.", new(extractCommand))
+	_, _ = parsedOpts.AddCommand("control", "Control a running collection", "Pause, resume, lower or restore the priority of, or get the status of a collection started with --control on this host. The action is one of pause, resume, low-priority, normal-priority or status.", new(controlCommand))
 	_, err := parsedOpts.Parse()
 	if err != nil {
 		os.Exit(-1)
 	}
+	if parsedOpts.Active != nil {
+		// A subcommand ran instead of a collection
+		return
+	}
+	if opts.RandomName && os.Getenv(startedAsVariable) == "" {
+		exitCode, relaunchErr := relaunchUnderRandomName()
+		if relaunchErr != nil {
+			fmt.Fprintln(os.Stderr, relaunchErr)
+			os.Exit(-1)
+		}
+		os.Exit(exitCode)
+	}
+	if opts.RunOnceAsService {
+		if opts.Interactive {
+			fmt.Fprintln(os.Stderr, "--interactive can't be combined with --run-once-as-service, which has no console")
+			os.Exit(-1)
+		}
+		err = runOnceAsService(opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		return
+	}
+	if opts.ServiceName != "" {
+		err = runService(opts, func(ctx context.Context) {
+			run(ctx, opts, parsedOpts)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		return
+	}
+	run(context.Background(), opts, parsedOpts)
+}
 
+// run collects as the command line says, or runs the agent or daemon.
+func run(ctx context.Context, opts *options, parsedOpts *flags.Parser) {
+	var err error
 	log.SetFormatter(&log.JSONFormatter{})
-	if opts.Debug == "" {
+	if opts.Debug == "" && (opts.ZipName == "-" || opts.DryRun) {
+		// stdout carries the collection itself, or the plan
+		log.SetOutput(os.Stderr)
+		log.SetLevel(log.ErrorLevel)
+	} else if opts.Debug == "" {
 		log.SetOutput(os.Stdout)
 		log.SetLevel(log.ErrorLevel)
 	} else {
@@ -43,120 +193,593 @@ func main() {
 		log.SetOutput(debugLog)
 		log.SetLevel(log.DebugLevel)
 	}
+	opts.shipping, err = startLogShipping(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+	defer opts.shipping.close()
 
+	if opts.AgentListen != "" {
+		err = runAgent(opts)
+		if err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+	if opts.Daemon != "" {
+		err = runDaemon(opts)
+		if err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+	if opts.Interactive {
+		opts.DataTypesToCollect, err = pickDataTypes(ctx, opts, os.Stdin, os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+	}
+	if opts.ZipName == "" && !opts.DryRun && opts.UploadURL == "" && opts.AzureBlobURL == "" && opts.GcsURL == "" && len(opts.Outputs) == 0 {
+		fmt.Fprintln(os.Stderr, "the required flag `/z, /zipname' was not specified")
+		os.Exit(-1)
+	}
+	if opts.SplitSize < 0 || (opts.SplitSize > 0 && opts.ZipName != "" && !opts.writesArchiveFile()) {
+		fmt.Fprintln(os.Stderr, "--split-size only applies to a zip or tar written to a file")
+		os.Exit(-1)
+	}
+
+	var eventLogChannels []collector.EventLogChannel
+	if opts.EventLogChannels != "" {
+		if err = loadJSONFile(opts.EventLogChannels, "event log channels", &eventLogChannels); err != nil {
+			log.Panic(err)
+		}
+	}
+	var yaraRules *collector.YaraRules
+	if opts.Yara != "" {
+		if yaraRules, err = collector.LoadYaraRules(opts.Yara); err != nil {
+			log.Panic(err)
+		}
+	}
 	var exportList collector.ListOfFilesToExport
-	if strings.Contains(opts.DataTypesToCollect, "a") {
-		exportList = collector.ListOfFilesToExport{
-			{
-				FullPath:        `%SYSTEMDRIVE%:\$MFT`,
-				IsFullPathRegex: false,
-				FileName:        `$MFT`,
-				IsFileNameRegex: false,
-			},
-			{
-				FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`,
-				IsFullPathRegex: false,
-				FileName:        `SYSTEM`,
-				IsFileNameRegex: false,
-			},
-			{
-				FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SOFTWARE`,
-				IsFullPathRegex: false,
-				FileName:        `SOFTWARE`,
-				IsFileNameRegex: false,
-			},
-			{
-				FullPath:        `%SYSTEMDRIVE%:\\Windows\\System32\\winevt\\Logs\\.*\.evtx$`,
-				IsFullPathRegex: true,
-				FileName:        `.*\.evtx$`,
-				IsFileNameRegex: true,
-			},
-			{
-				FullPath:        `%SYSTEMDRIVE%:\\users\\([^\\]+)\\ntuser.dat`,
-				IsFullPathRegex: true,
-				FileName:        `ntuser.dat`,
-				IsFileNameRegex: false,
-			},
-			{
-				FullPath:        `%SYSTEMDRIVE%:\\Users\\([^\\]+)\\AppData\\Local\\Microsoft\\Windows\\usrclass.dat`,
-				IsFullPathRegex: true,
-				FileName:        `usrclass.dat`,
-				IsFileNameRegex: false,
-			},
-			{
-				FullPath:        `%SYSTEMDRIVE%:\\Users\\([^\\]+)\\AppData\\Local\\Microsoft\\Windows\\WebCache\\WebCacheV01.dat`,
-				IsFullPathRegex: true,
-				FileName:        `WebCacheV01.dat`,
-				IsFileNameRegex: false,
-			},
+	if (opts.KapeTargets == "" && opts.Artifacts == "" && len(opts.Records) == 0 && len(opts.SHA256) == 0 && opts.IOCs == "" && len(opts.Sweep) == 0) || !parsedOpts.FindOptionByLongName("gather").IsSetDefault() || opts.Interactive {
+		exportList = exportListForDataTypes(opts.DataTypesToCollect, opts.MemoryFileLimit, len(eventLogChannels) == 0)
+		chains, chainsErr := parseProcessorChains(opts.Processors)
+		if chainsErr != nil {
+			log.Panic(chainsErr)
 		}
-	} else {
-		if strings.Contains(opts.DataTypesToCollect, "m") {
-			exportList = append(exportList, collector.FileToExport{
-				FullPath:        `%SYSTEMDRIVE%:\$MFT`,
-				IsFullPathRegex: false,
-				FileName:        `$MFT`,
-				IsFileNameRegex: false,
-			})
-		}
-		if strings.Contains(opts.DataTypesToCollect, "r") {
-			exportList = append(exportList, collector.FileToExport{
-				FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`,
-				IsFullPathRegex: false,
-				FileName:        `SYSTEM`,
-				IsFileNameRegex: false,
-			})
-			exportList = append(exportList, collector.FileToExport{
-				FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SOFTWARE`,
-				IsFullPathRegex: false,
-				FileName:        `SOFTWARE`,
-				IsFileNameRegex: false,
-			})
-		}
-		if strings.Contains(opts.DataTypesToCollect, "u") {
-			exportList = append(exportList, collector.FileToExport{
-				FullPath:        `%SYSTEMDRIVE%:\\users\\([^\\]+)\\ntuser.dat`,
-				IsFullPathRegex: true,
-				FileName:        `ntuser.dat`,
-				IsFileNameRegex: false,
-			})
-			exportList = append(exportList, collector.FileToExport{
-				FullPath:        `%SYSTEMDRIVE%:\\Users\\([^\\]+)\\AppData\\Local\\Microsoft\\Windows\\usrclass.dat`,
-				IsFullPathRegex: true,
-				FileName:        `usrclass.dat`,
-				IsFileNameRegex: false,
-			})
-		}
-		if strings.Contains(opts.DataTypesToCollect, "e") {
-			exportList = append(exportList, collector.FileToExport{
-				FullPath:        `%SYSTEMDRIVE%:\\Windows\\System32\\winevt\\Logs\\.*\\.evtx$`,
-				IsFullPathRegex: true,
-				FileName:        `.*\\.evtx$`,
-				IsFileNameRegex: true,
-			})
-		}
-		if strings.Contains(opts.DataTypesToCollect, "w") {
-			exportList = append(exportList, collector.FileToExport{
-				FullPath:        `%SYSTEMDRIVE%:\\Users\\([^\\]+)\\AppData\\Local\\Microsoft\\Windows\\WebCache\\WebCacheV01.dat`,
-				IsFullPathRegex: true,
-				FileName:        `WebCacheV01.dat`,
-				IsFileNameRegex: false,
-			})
-		}
-	}
-
-	fileHandle, err := os.Create(opts.ZipName)
+		exportList = processedTargets(exportList, chains, opts.MemoryFileLimit, len(eventLogChannels) == 0)
+	}
+	if opts.KapeTargets != "" {
+		kapeTargets, skipped, kapeErr := collector.LoadKapeTargets(opts.KapeTargets)
+		if kapeErr != nil {
+			log.Panic(kapeErr)
+		}
+		for _, skip := range skipped {
+			log.Warnf("Skipping KAPE target '%s': %s", skip.Target, skip.Reason)
+			fmt.Fprintf(os.Stderr, "Warning: skipping KAPE target '%s': %s\n", skip.Target, skip.Reason)
+		}
+		exportList = append(exportList, kapeTargets...)
+	}
+	if opts.Artifacts != "" {
+		artifacts, skipped, artifactsErr := collector.LoadForensicArtifacts(opts.Artifacts, opts.ArtifactNames)
+		if artifactsErr != nil {
+			log.Panic(artifactsErr)
+		}
+		for _, skip := range skipped {
+			log.Warnf("Skipping artifact '%s': %s", skip.Target, skip.Reason)
+			fmt.Fprintf(os.Stderr, "Warning: skipping artifact '%s': %s\n", skip.Target, skip.Reason)
+		}
+		exportList = append(exportList, artifacts...)
+	}
+	if opts.IOCs != "" {
+		iocTargets, skipped, iocErr := collector.LoadIOCs(opts.IOCs, opts.SHA256Path)
+		if iocErr != nil {
+			log.Panic(iocErr)
+		}
+		for _, skip := range skipped {
+			log.Warnf("Skipping IOC '%s': %s", skip.Target, skip.Reason)
+			fmt.Fprintf(os.Stderr, "Warning: skipping IOC '%s': %s\n", skip.Target, skip.Reason)
+		}
+		exportList = append(exportList, iocTargets...)
+	}
+	records, err := recordTargets(opts.Records)
 	if err != nil {
-		err = fmt.Errorf("failed to create zip file %s", opts.ZipName)
+		log.Panic(err)
+	}
+	exportList = append(append(exportList, records...), hashTargets(opts.SHA256, opts.SHA256Path)...)
+	exportList = append(exportList, sweepTargets(opts.Sweep, opts.SweepPath, opts.SweepMaxSize, opts.SweepWithin)...)
+	if opts.CaseSensitive {
+		exportList = caseSensitiveTargets(exportList)
+	}
+
+	if opts.Remote != "" {
+		if strings.Contains(opts.DataTypesToCollect, "o") {
+			log.Panic("--remote can't be combined with 'o', which finds the server logs through this machine's registry")
+		}
+		exportList = remoteTargets(exportList, opts.Remote)
+	}
+
+	if _, err = collector.LookupCodec(opts.Codec); err != nil {
+		log.Panic(err)
+	}
+
+	// Cancel the collection on Ctrl+C or when the timeout expires so the zip gets closed out properly
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		log.Error("Received an interrupt, stopping the collection.")
+		cancel()
+	}()
+	var control *collector.CollectionControl
+	if opts.Control != "" {
+		control = collector.NewCollectionControl()
+		controlListener, controlErr := listenForControl(opts.Control, control)
+		if controlErr != nil {
+			log.Panic(controlErr)
+		}
+		defer controlListener.Close()
+	}
+
+	collectOptions := collector.CollectOptions{
+		Progress:                  newProgressFunc(opts.Progress, os.Stderr),
+		Workers:                   opts.Workers,
+		ParallelVolumes:           opts.ParallelVolumes,
+		ReadBytesPerSecond:        opts.ReadLimit,
+		Control:                   control,
+		ReadRetries:               opts.ReadRetries,
+		ReadRetryDelay:            opts.ReadRetryDelay,
+		PendingFiles:              opts.PendingFiles,
+		PendingBytes:              opts.PendingBytes,
+		CaptureClock:              true,
+		NTPServer:                 opts.NTPServer,
+		ExportHives:               opts.ExportHives,
+		APIFallback:               opts.APIFallback,
+		ByteBudget:                opts.Budget,
+		MaxFileSize:               opts.MaxFileSize,
+		MaxTotalSize:              opts.MaxTotalSize,
+		MaxMatches:                opts.MaxMatches,
+		DetectAntiForensics:       opts.Warnings,
+		FileMetadata:              opts.FileMetadata,
+		Deduplicate:               opts.Deduplicate,
+		Verify:                    opts.Verify,
+		AuditLog:                  opts.AuditLog,
+		ETW:                       opts.ETW,
+		OnAudit:                   opts.shipping.onAudit(),
+		RecoverDeleted:            opts.RecoverDeleted,
+		BootRecords:               opts.BootRecords,
+		Timeline:                  collector.TimelineFormat(opts.Timeline),
+		DirectoryTree:             collector.DirectoryTreeScope(opts.DirectoryTree),
+		Processors:                collectProcessors(opts.EvtxJSON, yaraRules),
+		ReplaceProcessed:          opts.EvtxJSON == "instead" || opts.ProcessInstead,
+		BitLockerRecoveryPassword: opts.BitLockerPassword,
+		BitLockerRecoveryKey:      opts.BitLockerKey,
+		ReadPolicy:                collector.ReadPolicy(opts.ReadPolicy),
+		MinimalFootprint:          opts.MinimalFootprint,
+		StartedAs:                 os.Getenv(startedAsVariable),
 	}
-	zipWriter := zip.NewWriter(fileHandle)
-	resultWriter := collector.ZipResultWriter{
-		ZipWriter:  zipWriter,
-		FileHandle: fileHandle,
+	if opts.MinimalFootprint {
+		if parsedOpts.FindOptionByLongName("read-policy").IsSetDefault() {
+			collectOptions.ReadPolicy = collector.ReadRawOnly
+		}
+		if opts.Format == "tar" || hasOutputKind(opts.Outputs, "tar") {
+			fmt.Fprintln(os.Stderr, "--minimal-footprint can't write a tar, which spools each file to learn its size")
+			os.Exit(-1)
+		}
+	}
+	for _, directory := range opts.IndexDirectories {
+		collectOptions.IndexDirectories = append(collectOptions.IndexDirectories, collector.IndexDirectory{
+			Path:   directory,
+			Raw:    opts.IndexFormat != "parsed",
+			Parsed: opts.IndexFormat != "raw",
+		})
 	}
-	var volume collector.VolumeHandler
-	err = collector.Collect(volume, exportList, &resultWriter)
+	for _, volumeRange := range opts.Ranges {
+		parsed, parseErr := parseVolumeRange(volumeRange)
+		if parseErr != nil {
+			log.Panic(parseErr)
+		}
+		collectOptions.Ranges = append(collectOptions.Ranges, parsed)
+	}
+	var wmiQueries []collector.WMIQuery
+	if opts.WMIQueries != "" {
+		if err = loadJSONFile(opts.WMIQueries, "wmi queries", &wmiQueries); err != nil {
+			log.Panic(err)
+		}
+	}
+	var registryKeys []collector.RegistryKey
+	if opts.RegistryKeys != "" {
+		if err = loadJSONFile(opts.RegistryKeys, "registry keys", &registryKeys); err != nil {
+			log.Panic(err)
+		}
+	}
+	collectOptions.Acquirers, err = acquirersForDataTypes(opts.DataTypesToCollect, opts.Memory, wmiQueries, registryKeys, eventLogChannels)
 	if err != nil {
 		log.Panic(err)
 	}
+	if opts.Commands != "" {
+		if err = loadJSONFile(opts.Commands, "commands", &collectOptions.Commands); err != nil {
+			log.Panic(err)
+		}
+	}
+	if opts.Remote != "" && (len(collectOptions.Acquirers) != 0 || len(collectOptions.Commands) != 0) {
+		log.Panic("--remote can't be combined with 'x', 'i', 'k', 'q', --memory, --event-log-channels, --registry-keys, --wmi-queries or --commands, which capture this machine's state")
+	}
+	if opts.SinceReport != "" {
+		collectOptions.ChangedSince, err = loadUSNJournalMarks(opts.SinceReport)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	if opts.ChangedSince != "" {
+		collectOptions.ChangedAfter, err = time.Parse(time.RFC3339, opts.ChangedSince)
+		if err != nil {
+			log.Panicf("--changed-since isn't an RFC 3339 time: %v", err)
+		}
+	}
+	if opts.DryRun {
+		err = printPlan(ctx, collector.NewCollector(collectOptions), exportList)
+		if err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+	var signingKey ed25519.PrivateKey
+	if opts.SigningKey != "" {
+		signingKey, err = loadSigningKey(opts.SigningKey)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	if opts.Resume != "" {
+		collectOptions.Resume, err = openResumeState(opts)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	var report collector.CollectionReport
+	collection := collector.NewCollector(collectOptions)
+	var resultWriter collector.ResultWriter
+	if opts.UploadURL != "" {
+		resultWriter = &collector.HttpResultWriter{
+			URL:    opts.UploadURL,
+			Header: uploadHeader(opts.UploadAuth),
+			Codec:  opts.Codec,
+		}
+	} else if opts.AzureBlobURL != "" {
+		resultWriter = &collector.AzureBlobResultWriter{
+			BlobURL: opts.AzureBlobURL,
+			Codec:   opts.Codec,
+		}
+	} else if opts.GcsURL != "" {
+		bucket, object, parseErr := parseGcsURL(opts.GcsURL)
+		if parseErr != nil {
+			log.Panic(parseErr)
+		}
+		resultWriter = &collector.GcsResultWriter{
+			Bucket:      bucket,
+			Object:      object,
+			AccessToken: opts.GcsToken,
+			Codec:       opts.Codec,
+		}
+	} else if opts.ZipName == "" {
+		// Only written to the --output destinations
+	} else if opts.Format == "tar" {
+		fileHandle, createErr := openOutput(opts.ZipName, opts.SplitSize)
+		if createErr != nil {
+			log.Panicf("failed to create tar file %s: %v", opts.ZipName, createErr)
+		}
+		resultWriter = &collector.TarResultWriter{
+			Output:     &throttledFile{Writer: collector.NewThrottledWriter(fileHandle, opts.WriteLimit), Closer: fileHandle},
+			SigningKey: signingKey,
+		}
+	} else if opts.Format == "directory" {
+		if opts.ZipName == "-" {
+			fmt.Fprintln(os.Stderr, "--format directory can't be written to stdout")
+			os.Exit(-1)
+		}
+		resultWriter = &collector.DirectoryResultWriter{
+			Directory:  opts.ZipName,
+			SigningKey: signingKey,
+		}
+	} else {
+		fileHandle, createErr := openOutput(opts.ZipName, opts.SplitSize)
+		if createErr != nil {
+			log.Panicf("failed to create zip file %s: %v", opts.ZipName, createErr)
+		}
+		resultWriter = &collector.ZipResultWriter{
+			ZipWriter:  zip.NewWriter(collector.NewThrottledWriter(fileHandle, opts.WriteLimit)),
+			FileHandle: fileHandle,
+			Codec:      opts.Codec,
+			SigningKey: signingKey,
+		}
+	}
+	if len(opts.Outputs) != 0 {
+		settings := collector.ResultWriterSettings{
+			Codec:               opts.Codec,
+			SigningKey:          signingKey,
+			Header:              uploadHeader(opts.UploadAuth),
+			WriteBytesPerSecond: opts.WriteLimit,
+			SplitSize:           opts.SplitSize,
+		}
+		resultWriter, err = addOutputs(resultWriter, opts.Outputs, settings)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	report, err = collection.CollectWithReport(ctx, exportList, resultWriter)
+	if closeErr := collectOptions.Resume.Close(); closeErr != nil {
+		log.Errorf("The resume state may be missing files this run wrote, they would be collected again: %v", closeErr)
+	}
+	// An archive written to a file is signed as a whole as well, which a stream can't be. A split one is signed through
+	// its manifest, which has the hashes of the parts.
+	var collectionErrors collector.CollectionErrors
+	if signingKey != nil && opts.writesArchiveFile() && (err == nil || errors.As(err, &collectionErrors)) {
+		signedName := opts.ZipName
+		if opts.SplitSize > 0 {
+			signedName += collector.SplitManifestExtension
+		}
+		if signErr := collector.SignArchive(signedName, signingKey); signErr != nil {
+			log.Panic(signErr)
+		}
+	}
+	log.Debugf("Collection report: %+v", report)
+	if report.Partial {
+		fmt.Fprintln(os.Stderr, "Warning: not running as administrator, this is a partial collection. See partial_collection.json in the zip for what was skipped.")
+	}
+	if errors.As(err, &collectionErrors) {
+		// Everything else was still collected, so this isn't worth a panic
+		log.Error(err)
+		fmt.Fprintf(os.Stderr, "Warning: %d files or volumes could not be collected, see report.json in the zip for details.\n", len(collectionErrors))
+	} else if err != nil {
+		log.Panic(err)
+	}
+}
+
+// printPlan prints what a collection would collect as JSON to stdout, with a summary on stderr. Volumes that couldn't be
+// searched are listed in the plan rather than stopping it.
+func printPlan(ctx context.Context, collection *collector.Collector, exportList collector.ListOfFilesToExport) (err error) {
+	plan, err := collection.Plan(ctx, exportList)
+	var collectionErrors collector.CollectionErrors
+	if errors.As(err, &collectionErrors) {
+		log.Error(err)
+		err = nil
+	} else if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Println(string(data))
+	fmt.Fprintf(os.Stderr, "%d files, %d bytes, about %d bytes of output. %d skipped, %d deferred.\n", plan.TotalFiles, plan.TotalBytes, plan.EstimatedOutputBytes, len(plan.Skipped), len(plan.Deferred))
+	return
+}
+
+// throttledFile pairs a throttled writer with the file underneath it so the result writer can still close the file.
+type throttledFile struct {
+	io.Writer
+	io.Closer
+}
+
+// Complete completes the file underneath when it's a PartialFile or SplitFile, and otherwise closes it.
+func (file *throttledFile) Complete() error {
+	if completer, ok := file.Closer.(interface{ Complete() error }); ok {
+		return completer.Complete()
+	}
+	return file.Close()
+}
+
+// parseGcsURL splits a gs://bucket/object URL.
+func parseGcsURL(gcsURL string) (bucket string, object string, err error) {
+	path := strings.TrimPrefix(gcsURL, "gs://")
+	slash := strings.Index(path, "/")
+	if path == gcsURL || slash <= 0 || slash == len(path)-1 {
+		err = fmt.Errorf("'%s' is not a gs://bucket/object url", gcsURL)
+		return
+	}
+	bucket, object = path[:slash], path[slash+1:]
+	return
+}
+
+// uploadHeader is the header sent with every upload request, with --upload-auth as its Authorization.
+func uploadHeader(uploadAuth string) (header http.Header) {
+	header = http.Header{}
+	if uploadAuth != "" {
+		header.Set("Authorization", uploadAuth)
+	}
+	return
+}
+
+// addOutputs makes a result writer for each --output, KIND:DESTINATION, and fans the collection out to them along with
+// resultWriter, if there is one.
+func addOutputs(resultWriter collector.ResultWriter, outputs []string, settings collector.ResultWriterSettings) (multiResultWriter collector.ResultWriter, err error) {
+	var writers []collector.ResultWriter
+	if resultWriter != nil {
+		writers = append(writers, resultWriter)
+	}
+	for _, output := range outputs {
+		colon := strings.Index(output, ":")
+		if colon <= 0 || colon == len(output)-1 {
+			err = fmt.Errorf("'%s' is not a KIND:DESTINATION output, the kinds are %s", output, strings.Join(collector.RegisteredResultWriters(), ", "))
+			return
+		}
+		var outputWriter collector.ResultWriter
+		outputWriter, err = collector.NewResultWriter(output[:colon], output[colon+1:], settings)
+		if err != nil {
+			return
+		}
+		writers = append(writers, outputWriter)
+	}
+	if len(writers) == 1 {
+		multiResultWriter = writers[0]
+		return
+	}
+	multiResultWriter = &collector.MultiResultWriter{Writers: writers}
+	return
+}
+
+// hasOutputKind reports whether one of the --output destinations is of kind.
+func hasOutputKind(outputs []string, kind string) bool {
+	for _, output := range outputs {
+		if strings.HasPrefix(strings.ToLower(output), kind+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// collectProcessors are the processors for --evtx-json and --yara, none when neither is set.
+func collectProcessors(evtxJSON string, yaraRules *collector.YaraRules) (processors []collector.Processor) {
+	if evtxJSON != "" {
+		processors = append(processors, collector.EvtxJSONProcessor{})
+	}
+	if yaraRules != nil {
+		processors = append(processors, collector.YaraProcessor{Rules: yaraRules})
+	}
+	return
+}
+
+// parseVolumeRange parses a --range, VOLUME:OFFSET:LENGTH in bytes or VOLUME:clusters:OFFSET:LENGTH in clusters.
+func parseVolumeRange(value string) (volumeRange collector.VolumeRange, err error) {
+	fields := strings.Split(value, ":")
+	if len(fields) == 4 && strings.EqualFold(fields[1], "clusters") {
+		volumeRange.Clusters = true
+		fields = append(fields[:1], fields[2:]...)
+	}
+	if len(fields) != 3 {
+		err = fmt.Errorf("'%s' is not a VOLUME:OFFSET:LENGTH or VOLUME:clusters:OFFSET:LENGTH range", value)
+		return
+	}
+	volumeRange.Volume = fields[0]
+	if volumeRange.Offset, err = strconv.ParseInt(fields[1], 0, 64); err != nil {
+		err = fmt.Errorf("the offset of the range '%s' is not a number: %w", value, err)
+		return
+	}
+	if volumeRange.Length, err = strconv.ParseInt(fields[2], 0, 64); err != nil {
+		err = fmt.Errorf("the length of the range '%s' is not a number: %w", value, err)
+		return
+	}
+	return
+}
+
+// writesArchiveFile reports whether the zip or tar is written to a file, rather than stdout, a named pipe or an upload.
+func (opts *options) writesArchiveFile() bool {
+	uploading := opts.UploadURL != "" || opts.AzureBlobURL != "" || opts.GcsURL != ""
+	return !uploading && opts.ZipName != "" && opts.Format != "directory" && opts.ZipName != "-" && !strings.HasPrefix(strings.ToLower(opts.ZipName), `\\.\pipe\`)
+}
+
+// openResumeState opens the state file of a resumable collection. A later run's local outputs are renamed with its run
+// number so they don't overwrite an earlier run's.
+func openResumeState(opts *options) (state *collector.ResumeState, err error) {
+	state, err = collector.OpenResumeState(opts.Resume)
+	if err != nil {
+		return
+	}
+	if opts.ZipName != "" && (opts.writesArchiveFile() || opts.Format == "directory") {
+		opts.ZipName = resumedOutputName(opts.ZipName, state.Run())
+	}
+	for index, output := range opts.Outputs {
+		colon := strings.Index(output, ":")
+		switch strings.ToLower(output[:colon+1]) {
+		case "zip:", "tar:", "directory:", "manifest:":
+			opts.Outputs[index] = output[:colon+1] + resumedOutputName(output[colon+1:], state.Run())
+		}
+	}
+	if files, bytes := state.Written(); state.Run() > 1 {
+		fmt.Fprintf(os.Stderr, "Resuming the collection as run %d, leaving out the %d files (%d bytes) written to %s.\n", state.Run(), files, bytes, strings.Join(state.Outputs(), ", "))
+	}
+	err = state.Begin(opts.ZipName)
+	return
+}
+
+// resumedOutputName is what a run of a resumed collection writes its output as, e.g. host.run2.zip for host.zip, so it
+// doesn't overwrite what an earlier run wrote. The first run keeps the name.
+func resumedOutputName(name string, run int) string {
+	if run <= 1 {
+		return name
+	}
+	extension := filepath.Ext(name)
+	return fmt.Sprintf("%s.run%d%s", strings.TrimSuffix(name, extension), run, extension)
+}
+
+// openOutput opens where the zip or tar is written: stdout for "-", an existing named pipe such as \\.\pipe\collection,
+// which has to be opened rather than created, or otherwise a new file, written as NAME.partial until it's complete, or
+// as numbered parts of splitSize bytes when it's set.
+func openOutput(name string, splitSize int64) (output io.WriteCloser, err error) {
+	switch {
+	case name == "-":
+		output = os.Stdout
+	case strings.HasPrefix(strings.ToLower(name), `\\.\pipe\`):
+		output, err = os.OpenFile(name, os.O_WRONLY, 0)
+	case splitSize > 0:
+		output, err = collector.CreateSplitFile(name, splitSize)
+	default:
+		output, err = collector.CreatePartialFile(name)
+	}
+	return
+}
+
+// loadUSNJournalMarks reads the change journal marks out of an earlier collection's report.json.
+func loadUSNJournalMarks(path string) (marks map[string]collector.USNJournalMark, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("failed to read the earlier report: %w", err)
+		return
+	}
+	var report collector.CollectionReport
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		err = fmt.Errorf("failed to parse the earlier report %s: %w", path, err)
+		return
+	}
+	marks = report.USNJournalMarks()
+	return
+}
+
+// loadJSONFile reads one of the JSON lists given on the command line, such as --commands, into value. what names the
+// list in errors.
+func loadJSONFile(path string, what string, value interface{}) (err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("failed to read the %s: %w", what, err)
+		return
+	}
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		err = fmt.Errorf("failed to parse the %s %s: %w", what, path, err)
+	}
+	return
+}
+
+// loadSigningKey reads a PEM encoded PKCS #8 ed25519 private key.
+func loadSigningKey(path string) (signingKey ed25519.PrivateKey, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("failed to read the signing key: %w", err)
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = fmt.Errorf("no PEM data found in %s", path)
+		return
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		err = fmt.Errorf("failed to parse the signing key: %w", err)
+		return
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		err = fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return
 }
@@ -0,0 +1,210 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// dataTypeCategory is one of the data types /g takes, as the interactive picker lists it.
+type dataTypeCategory struct {
+	letter      string
+	description string
+	selected    bool // what the picker starts with, the same as '/g a'
+}
+
+var dataTypeCategories = []dataTypeCategory{
+	{letter: "m", description: "$MFT", selected: true},
+	{letter: "r", description: "SYSTEM, SOFTWARE, SAM, SECURITY and DEFAULT hives with their logs", selected: true},
+	{letter: "u", description: "Users' ntuser.dat and usrclass.dat", selected: true},
+	{letter: "e", description: "Event logs", selected: true},
+	{letter: "l", description: "LNK files and jump lists", selected: true},
+	{letter: "s", description: "Windows Search index and Activity Timeline", selected: true},
+	{letter: "v", description: "Windows Defender logs, detection history and quarantine", selected: true},
+	{letter: "w", description: "Web history from the WebCache, Chrome, Edge and Firefox", selected: true},
+	{letter: "h", description: "PowerShell history and transcripts, remoting session configurations and RDP caches", selected: true},
+	{letter: "d", description: "OneDrive, Dropbox and Google Drive sync logs and databases", selected: true},
+	{letter: "b", description: "EFI applications and boot configuration data"},
+	{letter: "c", description: "Crash dumps and Windows Error Reporting reports"},
+	{letter: "o", description: "IIS, Exchange and SQL Server logs, wherever the registry says they are"},
+	{letter: "n", description: "NTFS $Boot, $Secure, $Bitmap and $AttrDef"},
+	{letter: "p", description: "hiberfil.sys, pagefile.sys and swapfile.sys"},
+	{letter: "x", description: "Running processes, network connections, logged on users, services and drivers"},
+	{letter: "i", description: "Hosts file, firewall log, policy and rules, DNS cache, ARP table and network profiles"},
+	{letter: "k", description: "Autostart, USB and MountedDevices registry keys read live"},
+	{letter: "q", description: "WMI queries of processes, services, startup commands and more"},
+}
+
+// liveDataTypes are captured by acquirers rather than found on the volumes, so there's nothing to estimate for them.
+const liveDataTypes = "xkq"
+
+// dataTypePicker lets an operator at the console pick what to collect without a prepared profile. Categories are
+// toggled by typing their letters, 'preview' estimates the size of each selected one and 'collect' starts the collection.
+type dataTypePicker struct {
+	opts       *options
+	input      *bufio.Scanner
+	output     io.Writer
+	categories []dataTypeCategory
+	estimates  map[string]string // what the last preview found for each letter
+}
+
+// pickDataTypes runs the picker on the console and returns the /g letters picked, or an error if the operator quit.
+func pickDataTypes(ctx context.Context, opts *options, input io.Reader, output io.Writer) (dataTypes string, err error) {
+	picker := dataTypePicker{
+		opts:       opts,
+		input:      bufio.NewScanner(input),
+		output:     output,
+		categories: append([]dataTypeCategory(nil), dataTypeCategories...),
+		estimates:  make(map[string]string),
+	}
+	for {
+		picker.render()
+		line, ok := picker.readLine("> ")
+		if !ok {
+			err = errors.New("the picker's input ended before a collection was started")
+			return
+		}
+		switch line {
+		case "":
+		case "collect":
+			dataTypes = picker.selected()
+			if dataTypes == "" {
+				fmt.Fprintln(output, "Pick at least one category first.")
+				continue
+			}
+			err = picker.pickOutput()
+			return
+		case "quit":
+			err = errors.New("the operator quit the picker")
+			return
+		case "preview":
+			picker.preview(ctx)
+		default:
+			picker.toggle(line)
+		}
+	}
+}
+
+func (picker *dataTypePicker) render() {
+	fmt.Fprintln(picker.output)
+	fmt.Fprintln(picker.output, "Type the letters of categories to toggle them, 'preview' to estimate their sizes, 'collect' to start or 'quit'.")
+	for _, category := range picker.categories {
+		mark := " "
+		if category.selected {
+			mark = "x"
+		}
+		fmt.Fprintf(picker.output, " [%s] %s  %-80s %s\n", mark, category.letter, category.description, picker.estimates[category.letter])
+	}
+}
+
+func (picker *dataTypePicker) readLine(prompt string) (line string, ok bool) {
+	fmt.Fprint(picker.output, prompt)
+	if !picker.input.Scan() {
+		return
+	}
+	return strings.TrimSpace(picker.input.Text()), true
+}
+
+// toggle flips every category whose letter is in letters.
+func (picker *dataTypePicker) toggle(letters string) {
+	for _, letter := range letters {
+		found := false
+		for index := range picker.categories {
+			if picker.categories[index].letter == string(letter) {
+				picker.categories[index].selected = !picker.categories[index].selected
+				found = true
+			}
+		}
+		if !found && letter != ' ' {
+			fmt.Fprintf(picker.output, "There's no category '%c'.\n", letter)
+		}
+	}
+}
+
+func (picker *dataTypePicker) selected() (dataTypes string) {
+	for _, category := range picker.categories {
+		if category.selected {
+			dataTypes += category.letter
+		}
+	}
+	return
+}
+
+// preview plans each selected category on its own. They share an MFT cache, so each volume's MFT is only read once.
+func (picker *dataTypePicker) preview(ctx context.Context) {
+	mftCache := collector.NewMFTCache(time.Hour)
+	defer mftCache.Close()
+	previewer := collector.NewCollector(collector.CollectOptions{
+		MaxFileSize:  picker.opts.MaxFileSize,
+		MaxTotalSize: picker.opts.MaxTotalSize,
+		MaxMatches:   picker.opts.MaxMatches,
+		ReadPolicy:   collector.ReadPolicy(picker.opts.ReadPolicy),
+		MFTCache:     mftCache,
+	})
+	var totalBytes int64
+	for _, category := range picker.categories {
+		if !category.selected {
+			continue
+		}
+		if strings.Contains(liveDataTypes, category.letter) {
+			picker.estimates[category.letter] = "captured live, not estimated"
+			continue
+		}
+		fmt.Fprintf(picker.output, "Searching for %s...\n", category.description)
+		targets := exportListForDataTypes(category.letter, picker.opts.MemoryFileLimit, picker.opts.EventLogChannels == "")
+		if picker.opts.Remote != "" {
+			targets = remoteTargets(targets, picker.opts.Remote)
+		}
+		plan, err := previewer.Plan(ctx, targets)
+		var collectionErrors collector.CollectionErrors
+		if err != nil && !errors.As(err, &collectionErrors) {
+			picker.estimates[category.letter] = fmt.Sprintf("failed: %v", err)
+			continue
+		}
+		totalBytes += plan.EstimatedOutputBytes
+		picker.estimates[category.letter] = fmt.Sprintf("%d files, %s", plan.TotalFiles, formatBytes(plan.EstimatedOutputBytes))
+	}
+	fmt.Fprintf(picker.output, "About %s before compression in all.\n", formatBytes(totalBytes))
+}
+
+// pickOutput asks where the zip goes when neither /z nor an upload was given.
+func (picker *dataTypePicker) pickOutput() (err error) {
+	opts := picker.opts
+	if opts.ZipName != "" || opts.UploadURL != "" || opts.AzureBlobURL != "" || opts.GcsURL != "" {
+		return
+	}
+	hostname, _ := os.Hostname()
+	defaultName := hostname + ".zip"
+	line, ok := picker.readLine(fmt.Sprintf("Write the collection to [%s]: ", defaultName))
+	if !ok {
+		err = errors.New("the picker's input ended before the output was picked")
+		return
+	}
+	opts.ZipName = defaultName
+	if line != "" {
+		opts.ZipName = line
+	}
+	return
+}
+
+// formatBytes gives a size in the largest binary unit it has at least one of.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	divisor, exponent := int64(unit), 0
+	for remaining := size / unit; remaining >= unit; remaining /= unit {
+		divisor *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(divisor), "KMGTPE"[exponent])
+}
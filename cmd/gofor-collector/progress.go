@@ -0,0 +1,64 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressBarWidth = 40
+
+// newProgressFunc returns a progress callback that renders to output in the requested style.
+func newProgressFunc(style string, output io.Writer) collector.ProgressFunc {
+	var mutex sync.Mutex
+	switch style {
+	case "bar":
+		return func(progress collector.Progress) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			renderProgressBar(output, progress)
+		}
+	case "json":
+		encoder := json.NewEncoder(output)
+		return func(progress collector.Progress) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			_ = encoder.Encode(struct {
+				Time time.Time `json:"time"`
+				collector.Progress
+			}{time.Now().UTC(), progress})
+		}
+	default:
+		return nil
+	}
+}
+
+func renderProgressBar(output io.Writer, progress collector.Progress) {
+	if progress.Stage == collector.StageDone {
+		fmt.Fprintf(output, "\r%s\rdone\n", strings.Repeat(" ", progressBarWidth+80))
+		return
+	}
+
+	percent := 0
+	if progress.TotalBytes > 0 {
+		percent = int(progress.BytesRead * 100 / progress.TotalBytes)
+		if percent > 100 {
+			percent = 100
+		}
+	}
+	filled := percent * progressBarWidth / 100
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	// Keep the line a fixed width so a shorter file name doesn't leave junk from the previous one
+	fileName := progress.FileName
+	if len(fileName) > 60 {
+		fileName = "..." + fileName[len(fileName)-57:]
+	}
+	fmt.Fprintf(output, "\r[%s] %3d%% %-9s %-60s", bar, percent, progress.Stage, fileName)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+)
+
+// startedAsVariable tells a collector relaunched under a random name what it was started as, for the report.
+const startedAsVariable = "GOFOR_STARTED_AS"
+
+// relaunchUnderRandomName copies the collector's executable next to itself under a random name and runs the copy with
+// the same arguments, so it doesn't show up as gofor-collector in a process list. The copy goes next to the executable
+// rather than into the temp directory, and is removed once it exits. exitCode is the copy's.
+func relaunchUnderRandomName() (exitCode int, err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		err = fmt.Errorf("failed to find the collector's executable: %w", err)
+		return
+	}
+	name, err := randomProcessName()
+	if err != nil {
+		return
+	}
+	copyPath := filepath.Join(filepath.Dir(executable), name)
+	err = copyExecutable(executable, copyPath)
+	if err != nil {
+		err = fmt.Errorf("failed to copy the collector to %s: %w", copyPath, err)
+		return
+	}
+	defer os.Remove(copyPath)
+
+	// The copy gets the interrupt as well and stops the collection itself
+	signal.Ignore(os.Interrupt)
+	command := exec.Command(copyPath, os.Args[1:]...)
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	command.Env = append(os.Environ(), startedAsVariable+"="+filepath.Base(executable))
+	err = command.Run()
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		return exitError.ExitCode(), nil
+	} else if err != nil {
+		err = fmt.Errorf("failed to run the collector as %s: %w", name, err)
+	}
+	return
+}
+
+// randomProcessName is a random executable name of eight lower case letters.
+func randomProcessName() (name string, err error) {
+	letters := make([]byte, 8)
+	if _, err = rand.Read(letters); err != nil {
+		err = fmt.Errorf("failed to pick a random name: %w", err)
+		return
+	}
+	for index := range letters {
+		letters[index] = 'a' + letters[index]%26
+	}
+	name = string(letters) + ".exe"
+	return
+}
+
+func copyExecutable(source string, destination string) (err error) {
+	input, err := os.Open(source)
+	if err != nil {
+		return
+	}
+	defer input.Close()
+	output, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(output, input)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(destination)
+	}
+	return
+}
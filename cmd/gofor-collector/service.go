@@ -0,0 +1,176 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"time"
+	"unsafe"
+)
+
+// runOnceAsService runs the collection with the same arguments from a temporary service running as SYSTEM, waits for
+// it to finish and removes the service again. Only SYSTEM can read some hives, and some endpoint products only let it
+// open volumes raw, while operators often only have an administrator account. What the collection prints is passed
+// through once it's done.
+func runOnceAsService(opts *options) (err error) {
+	if opts.ZipName == "-" {
+		err = errors.New("--run-once-as-service can't write the collection to stdout")
+		return
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		err = fmt.Errorf("failed to find the collector's executable: %w", err)
+		return
+	}
+	directory, err := os.Getwd()
+	if err != nil {
+		err = fmt.Errorf("failed to get the current directory: %w", err)
+		return
+	}
+	output, err := ioutil.TempFile("", "gofor-collector-service-")
+	if err != nil {
+		err = fmt.Errorf("failed to create a file for the service's output: %w", err)
+		return
+	}
+	output.Close()
+	defer os.Remove(output.Name())
+
+	name := fmt.Sprintf("gofor-collector-%d", os.Getpid())
+	args := append(serviceArguments(os.Args[1:]), "--service-name", name, "--service-directory", directory, "--service-output", output.Name())
+	manager, err := mgr.Connect()
+	if err != nil {
+		err = fmt.Errorf("failed to connect to the service control manager, which needs administrator rights: %w", err)
+		return
+	}
+	defer manager.Disconnect()
+	service, err := manager.CreateService(name, executable, mgr.Config{
+		StartType:   mgr.StartManual,
+		DisplayName: "gofor-collector collection",
+		Description: "Runs a single collection as SYSTEM. It's removed once the collection is done.",
+	}, args...)
+	if err != nil {
+		err = fmt.Errorf("failed to create the service %s: %w", name, err)
+		return
+	}
+	defer func() {
+		if deleteErr := service.Delete(); deleteErr != nil && err == nil {
+			err = fmt.Errorf("failed to remove the service %s: %w", name, deleteErr)
+		}
+		service.Close()
+	}()
+	err = service.Start()
+	if err != nil {
+		err = fmt.Errorf("failed to start the service %s: %w", name, err)
+		return
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	var status windows.SERVICE_STATUS_PROCESS
+	for status.CurrentState != windows.SERVICE_STOPPED {
+		select {
+		case <-interrupt:
+			fmt.Fprintln(os.Stderr, "Received an interrupt, stopping the collection.")
+			_, _ = service.Control(svc.Stop)
+		case <-time.After(time.Second):
+		}
+		var bytesNeeded uint32
+		err = windows.QueryServiceStatusEx(service.Handle, windows.SC_STATUS_PROCESS_INFO, (*byte)(unsafe.Pointer(&status)), uint32(unsafe.Sizeof(status)), &bytesNeeded)
+		if err != nil {
+			err = fmt.Errorf("failed to query the service %s: %w", name, err)
+			return
+		}
+	}
+	if printed, readErr := ioutil.ReadFile(output.Name()); readErr == nil {
+		os.Stderr.Write(printed)
+	}
+	if status.Win32ExitCode != windows.NO_ERROR {
+		err = fmt.Errorf("the collection failed as SYSTEM, exit code %d", status.Win32ExitCode)
+	}
+	return
+}
+
+// serviceArguments returns the command line without --run-once-as-service, so the service collects instead of
+// starting another service.
+func serviceArguments(args []string) (serviceArgs []string) {
+	for _, arg := range args {
+		if arg == "--run-once-as-service" || arg == "/run-once-as-service" {
+			continue
+		}
+		serviceArgs = append(serviceArgs, arg)
+	}
+	return
+}
+
+// runService runs collect as the service runOnceAsService created. Services start in System32 without a console, so it
+// moves to the directory the collector was started from and prints into the file runOnceAsService passes through.
+func runService(opts *options, collect func(ctx context.Context)) (err error) {
+	err = os.Chdir(opts.ServiceDirectory)
+	if err != nil {
+		return
+	}
+	output, err := os.OpenFile(opts.ServiceOutput, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return
+	}
+	defer output.Close()
+	os.Stdout, os.Stderr = output, output
+	err = svc.Run(opts.ServiceName, &collectionService{collect: collect})
+	return
+}
+
+// collectionService runs a single collection, stopping it when the service is told to stop.
+type collectionService struct {
+	collect func(ctx context.Context)
+}
+
+func (service *collectionService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (serviceSpecificExitCode bool, exitCode uint32) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan bool, 1)
+	go func() {
+		succeeded := false
+		defer func() {
+			// Collections fail with log.Panic
+			if recovered := recover(); recovered != nil {
+				if entry, ok := recovered.(*log.Entry); ok {
+					recovered = entry.Message
+				}
+				fmt.Fprintf(os.Stderr, "The collection failed: %v\n", recovered)
+			}
+			finished <- succeeded
+		}()
+		service.collect(ctx)
+		succeeded = true
+	}()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case succeeded := <-finished:
+			changes <- svc.Status{State: svc.StopPending}
+			if !succeeded {
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
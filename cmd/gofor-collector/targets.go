@@ -0,0 +1,703 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package main
+
+import (
+	"fmt"
+	collector "github.com/Go-Forensics/Windows-Collector"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemRegistryTargets are the hives in system32\config collected for 'r', along with their transaction logs so
+// whatever is still pending in them can be replayed. They are always locked on a live system.
+var systemRegistryTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`,
+		IsFullPathRegex: false,
+		FileName:        `SYSTEM`,
+		IsFileNameRegex: false,
+		Priority:        50,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SOFTWARE`,
+		IsFullPathRegex: false,
+		FileName:        `SOFTWARE`,
+		IsFileNameRegex: false,
+		Priority:        50,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SAM`,
+		IsFullPathRegex: false,
+		FileName:        `SAM`,
+		IsFileNameRegex: false,
+		Priority:        50,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\SECURITY`,
+		IsFullPathRegex: false,
+		FileName:        `SECURITY`,
+		IsFileNameRegex: false,
+		Priority:        50,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\System32\config\DEFAULT`,
+		IsFullPathRegex: false,
+		FileName:        `DEFAULT`,
+		IsFileNameRegex: false,
+		Priority:        50,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\Windows\\System32\\config\\(SYSTEM|SOFTWARE|SAM|SECURITY|DEFAULT)\.LOG[12]?$`,
+		IsFullPathRegex: true,
+		FileName:        `^(SYSTEM|SOFTWARE|SAM|SECURITY|DEFAULT)\.LOG[12]?$`,
+		IsFileNameRegex: true,
+		Priority:        50,
+	},
+}
+
+// recentItemsTargets are each user's Recent items collected for 'l': the shortcuts Windows creates for opened files and
+// folders, and the AutomaticDestinations and CustomDestinations jump lists.
+var recentItemsTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Roaming\\Microsoft\\Windows\\Recent\\(.*\\)?[^\\]+\.lnk$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.lnk$`,
+		IsFileNameRegex: true,
+		Priority:        25,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Roaming\\Microsoft\\Windows\\Recent\\AutomaticDestinations\\[^\\]+\.automaticDestinations-ms$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.automaticDestinations-ms$`,
+		IsFileNameRegex: true,
+		Priority:        25,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Roaming\\Microsoft\\Windows\\Recent\\CustomDestinations\\[^\\]+\.customDestinations-ms$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.customDestinations-ms$`,
+		IsFileNameRegex: true,
+		Priority:        25,
+	},
+}
+
+// activityTargets are collected for 's': the Windows Search index, an ESE database that can run to gigabytes so it
+// comes last when there's a budget, and each user's Activity Timeline, a SQLite database under every account in
+// ConnectedDevicesPlatform. Both are locked while Windows runs.
+var activityTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%SYSTEMDRIVE%:\ProgramData\Microsoft\Search\Data\Applications\Windows\Windows.edb`,
+		IsFullPathRegex: false,
+		FileName:        `Windows.edb`,
+		IsFileNameRegex: false,
+		Priority:        5,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\ConnectedDevicesPlatform\\[^\\]+\\ActivitiesCache\.db$`,
+		IsFullPathRegex: true,
+		FileName:        `ActivitiesCache.db`,
+		IsFileNameRegex: false,
+		Priority:        15,
+	},
+}
+
+// defenderTargets are collected for 'v': Defender's support logs, such as MPLog and MPDetection, its detection history,
+// and its quarantine. The quarantined files are kept encrypted the way Defender stores them, and since they can be
+// large they come last when there's a budget.
+var defenderTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\ProgramData\\Microsoft\\Windows Defender\\Support\\[^\\]+\.log$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.log$`,
+		IsFileNameRegex: true,
+		Priority:        25,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\ProgramData\\Microsoft\\Windows Defender\\Scans\\History\\Service\\DetectionHistory\\.+`,
+		IsFullPathRegex: true,
+		FileName:        `^[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}$`,
+		IsFileNameRegex: true,
+		Priority:        25,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\ProgramData\\Microsoft\\Windows Defender\\Quarantine\\Entries\\[^\\]+$`,
+		IsFullPathRegex: true,
+		FileName:        `^\{[0-9a-f]{8}(-[0-9a-f]{4}){3}-[0-9a-f]{12}\}$`,
+		IsFileNameRegex: true,
+		Priority:        5,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\ProgramData\\Microsoft\\Windows Defender\\Quarantine\\(ResourceData|Resources)\\[0-9a-f]{2}\\[0-9a-f]{40}$`,
+		IsFullPathRegex: true,
+		FileName:        `^[0-9a-f]{40}$`,
+		IsFileNameRegex: true,
+		Priority:        5,
+	},
+}
+
+// lateralMovementTargets are collected for 'h', for tracing PowerShell and RDP use: each user's PSReadLine history, the
+// transcripts PowerShell transcription writes, to the user's Documents unless a policy points it elsewhere on the system
+// volume, the session configuration files of the WSMan plugins PowerShell remoting registers, each user's RDP bitmap
+// cache and the Default.rdp Remote Desktop Connection saves the last connection to.
+var lateralMovementTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Roaming\\Microsoft\\Windows\\PowerShell\\PSReadLine\\[^\\]+_history\.txt$`,
+		IsFullPathRegex: true,
+		FileName:        `.*_history\.txt$`,
+		IsFileNameRegex: true,
+		Priority:        35,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\.*\\PowerShell_transcript\.[^\\]+\.txt$`,
+		IsFullPathRegex: true,
+		FileName:        `^PowerShell_transcript\..+\.txt$`,
+		IsFileNameRegex: true,
+		Priority:        30,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\Windows\\System32\\WindowsPowerShell\\v1\.0\\SessionConfig\\[^\\]+\.pssc$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.pssc$`,
+		IsFileNameRegex: true,
+		Priority:        30,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Microsoft\\Terminal Server Client\\Cache\\[^\\]+\.(bmc|bin)$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.(bmc|bin)$`,
+		IsFileNameRegex: true,
+		Priority:        15,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\Documents\\Default\.rdp$`,
+		IsFullPathRegex: true,
+		FileName:        `Default.rdp`,
+		IsFileNameRegex: false,
+		Priority:        30,
+	},
+}
+
+// networkTargets are collected for 'i' along with the network state read live: the hosts file, which malware points
+// names at its own servers with, and the firewall's log with the one it rolled over to.
+var networkTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\System32\drivers\etc\hosts`,
+		IsFullPathRegex: false,
+		FileName:        `hosts`,
+		IsFileNameRegex: false,
+		Priority:        35,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\Windows\\System32\\LogFiles\\Firewall\\pfirewall\.log(\.old)?$`,
+		IsFullPathRegex: true,
+		FileName:        `^pfirewall\.log(\.old)?$`,
+		IsFileNameRegex: true,
+		Priority:        25,
+	},
+}
+
+// metafileTargets are collected for 'n', for digging into the file system itself: the boot sector NTFS keeps as
+// $Boot, the cluster allocation bitmap, the security descriptors in the $SDS stream of $Secure and the attribute
+// definitions. They're read raw like the $MFT.
+var metafileTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%SYSTEMDRIVE%:\$Boot`,
+		IsFullPathRegex: false,
+		FileName:        `$Boot`,
+		IsFileNameRegex: false,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\$Secure`,
+		IsFullPathRegex: false,
+		FileName:        `$Secure`,
+		IsFileNameRegex: false,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\$Bitmap`,
+		IsFullPathRegex: false,
+		FileName:        `$Bitmap`,
+		IsFileNameRegex: false,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\$AttrDef`,
+		IsFullPathRegex: false,
+		FileName:        `$AttrDef`,
+		IsFileNameRegex: false,
+		Priority:        20,
+	},
+}
+
+// bootTargets are collected for 'b', for bootkit investigations: every EFI application on the EFI system partition,
+// such as bootmgfw.efi and whatever a bootkit put next to it, the boot configuration data with its logs, and the
+// copies of the boot manager and loader on the system volume to compare them against.
+var bootTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%ESP%:\\EFI\\.+\.efi$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.efi$`,
+		IsFileNameRegex: true,
+		Priority:        35,
+	},
+	{
+		FullPath:        `%ESP%:\\EFI\\Microsoft\\Boot\\BCD(\.LOG[12]?)?$`,
+		IsFullPathRegex: true,
+		FileName:        `^BCD(\.LOG[12]?)?$`,
+		IsFileNameRegex: true,
+		Priority:        35,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\Windows\\Boot\\EFI\\[^\\]+\.efi$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.efi$`,
+		IsFileNameRegex: true,
+		Priority:        15,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\System32\winload.efi`,
+		IsFullPathRegex: false,
+		FileName:        `winload.efi`,
+		IsFileNameRegex: false,
+		Priority:        15,
+	},
+}
+
+// cloudSyncTargets are the sync clients' records collected for 'd' from every user's profile, for exfiltration
+// investigations: OneDrive's ODL logs and SyncDiagnostics.log, with the ObfuscationStringMap.txt and general.keystore
+// that undo the logs' obfuscation, Dropbox's filecache.dbx and config.dbx, which are encrypted with the user's DPAPI
+// keys, and the databases Google Drive for desktop and Backup and Sync keep of what they synced.
+var cloudSyncTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Microsoft\\OneDrive\\logs\\.+\.(odl|odlgz|odlsent|aodl)$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.(odl|odlgz|odlsent|aodl)$`,
+		IsFileNameRegex: true,
+		Priority:        15,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Microsoft\\OneDrive\\logs\\.+\\(SyncDiagnostics\.log|ObfuscationStringMap\.txt|general\.keystore)$`,
+		IsFullPathRegex: true,
+		FileName:        `^(SyncDiagnostics\.log|ObfuscationStringMap\.txt|general\.keystore)$`,
+		IsFileNameRegex: true,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Dropbox\\instance[^\\]*\\(filecache|config)\.dbx$`,
+		IsFullPathRegex: true,
+		FileName:        `^(filecache|config)\.dbx$`,
+		IsFileNameRegex: true,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Google\\DriveFS\\[^\\]+\\(metadata_sqlite_db|mirror_sqlite\.db|root_preference_sqlite\.db)$`,
+		IsFullPathRegex: true,
+		FileName:        `^(metadata_sqlite_db|mirror_sqlite\.db|root_preference_sqlite\.db)$`,
+		IsFileNameRegex: true,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Google\\Drive\\user_default\\(snapshot|sync_config)\.db$`,
+		IsFullPathRegex: true,
+		FileName:        `^(snapshot|sync_config)\.db$`,
+		IsFileNameRegex: true,
+		Priority:        20,
+	},
+}
+
+// crashTargets are collected for 'c', which 'a' leaves out, since crash dumps often hold the memory of an exploited
+// process: the minidumps and complete memory dump Windows writes when it crashes, the reports Windows Error Reporting
+// keeps in its ReportArchive and ReportQueue, machine wide and for each user, and the dumps each user's LocalDumps
+// writes to CrashDumps. MEMORY.DMP can be as big as the machine's RAM so it comes last when there's a budget.
+var crashTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\Windows\\Minidump\\[^\\]+\.dmp$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.dmp$`,
+		IsFileNameRegex: true,
+		Priority:        25,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\Windows\MEMORY.DMP`,
+		IsFullPathRegex: false,
+		FileName:        `MEMORY.DMP`,
+		IsFileNameRegex: false,
+		Priority:        1,
+	},
+	{
+		FullPath:        `%SYSTEMDRIVE%:\\ProgramData\\Microsoft\\Windows\\WER\\(ReportArchive|ReportQueue)\\.+`,
+		IsFullPathRegex: true,
+		FileName:        `.+`,
+		IsFileNameRegex: true,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Microsoft\\Windows\\WER\\(ReportArchive|ReportQueue)\\.+`,
+		IsFullPathRegex: true,
+		FileName:        `.+`,
+		IsFileNameRegex: true,
+		Priority:        20,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\CrashDumps\\[^\\]+\.dmp$`,
+		IsFullPathRegex: true,
+		FileName:        `.*\.dmp$`,
+		IsFileNameRegex: true,
+		Priority:        20,
+	},
+}
+
+// webHistoryTargets are the browser databases collected for 'w' from every user's profile: the WebCache, Chrome's and
+// Edge's history, cookies, saved logins and autofill data in each of their profiles, and Firefox's history and cookies.
+// Running browsers keep these locked, which reading them raw gets around. Newer versions of Chrome and Edge keep their
+// cookies under Network.
+var webHistoryTargets = collector.ListOfFilesToExport{
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\Microsoft\\Windows\\WebCache\\WebCacheV01.dat`,
+		IsFullPathRegex: true,
+		FileName:        `WebCacheV01.dat`,
+		IsFileNameRegex: false,
+		Priority:        10,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\(Google\\Chrome|Microsoft\\Edge)\\User Data\\[^\\]+\\History$`,
+		IsFullPathRegex: true,
+		FileName:        `History`,
+		IsFileNameRegex: false,
+		Priority:        10,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\(Google\\Chrome|Microsoft\\Edge)\\User Data\\[^\\]+\\(Network\\)?Cookies$`,
+		IsFullPathRegex: true,
+		FileName:        `Cookies`,
+		IsFileNameRegex: false,
+		Priority:        10,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\(Google\\Chrome|Microsoft\\Edge)\\User Data\\[^\\]+\\Login Data$`,
+		IsFullPathRegex: true,
+		FileName:        `Login Data`,
+		IsFileNameRegex: false,
+		Priority:        10,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Local\\(Google\\Chrome|Microsoft\\Edge)\\User Data\\[^\\]+\\Web Data$`,
+		IsFullPathRegex: true,
+		FileName:        `Web Data`,
+		IsFileNameRegex: false,
+		Priority:        10,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Roaming\\Mozilla\\Firefox\\Profiles\\[^\\]+\\places\.sqlite$`,
+		IsFullPathRegex: true,
+		FileName:        `places.sqlite`,
+		IsFileNameRegex: false,
+		Priority:        10,
+	},
+	{
+		FullPath:        `%USERPROFILE%\\AppData\\Roaming\\Mozilla\\Firefox\\Profiles\\[^\\]+\\cookies\.sqlite$`,
+		IsFullPathRegex: true,
+		FileName:        `cookies.sqlite`,
+		IsFileNameRegex: false,
+		Priority:        10,
+	},
+}
+
+// memoryTargets are collected for 'p', which 'a' leaves out: the hibernation file, the page file and the swap file.
+// Windows keeps them open exclusively so they can only be read from their data runs. Each can be as big as the
+// machine's RAM, so maxSize, when it isn't zero, skips any that are bigger.
+func memoryTargets(maxSize int64) collector.ListOfFilesToExport {
+	var targets collector.ListOfFilesToExport
+	for _, fileName := range []string{"hiberfil.sys", "pagefile.sys", "swapfile.sys"} {
+		targets = append(targets, collector.FileToExport{
+			FullPath:        `%SYSTEMDRIVE%:\` + fileName,
+			IsFullPathRegex: false,
+			FileName:        fileName,
+			IsFileNameRegex: false,
+			Priority:        1,
+			MaxFileSize:     maxSize,
+		})
+	}
+	return targets
+}
+
+// exportListForDataTypes returns the targets for the data type abbreviations given to /g, e.g. "mr" for the $MFT and
+// the system registries. memoryFileLimit caps the size of the memory files collected for 'p'. copyEventLogs is false when
+// the event logs are exported by channel instead of copying their .evtx files.
+func exportListForDataTypes(dataTypes string, memoryFileLimit int64, copyEventLogs bool) (exportList collector.ListOfFilesToExport) {
+	if strings.Contains(dataTypes, "a") {
+		exportList = collector.ListOfFilesToExport{
+			{
+				FullPath:        `%SYSTEMDRIVE%:\$MFT`,
+				IsFullPathRegex: false,
+				FileName:        `$MFT`,
+				IsFileNameRegex: false,
+				Priority:        20,
+			},
+			{
+				FullPath:        `%USERPROFILE%\\ntuser.dat`,
+				IsFullPathRegex: true,
+				FileName:        `ntuser.dat`,
+				IsFileNameRegex: false,
+				Priority:        40,
+			},
+			{
+				FullPath:        `%USERPROFILE%\\AppData\\Local\\Microsoft\\Windows\\usrclass.dat`,
+				IsFullPathRegex: true,
+				FileName:        `usrclass.dat`,
+				IsFileNameRegex: false,
+				Priority:        40,
+			},
+		}
+		exportList = append(exportList, systemRegistryTargets...)
+		exportList = append(exportList, recentItemsTargets...)
+		exportList = append(exportList, activityTargets...)
+		exportList = append(exportList, defenderTargets...)
+		exportList = append(exportList, webHistoryTargets...)
+		exportList = append(exportList, lateralMovementTargets...)
+		exportList = append(exportList, cloudSyncTargets...)
+	} else {
+		if strings.Contains(dataTypes, "m") {
+			exportList = append(exportList, collector.FileToExport{
+				FullPath:        `%SYSTEMDRIVE%:\$MFT`,
+				IsFullPathRegex: false,
+				FileName:        `$MFT`,
+				IsFileNameRegex: false,
+				Priority:        20,
+			})
+		}
+		if strings.Contains(dataTypes, "r") {
+			exportList = append(exportList, systemRegistryTargets...)
+		}
+		if strings.Contains(dataTypes, "u") {
+			exportList = append(exportList, collector.FileToExport{
+				FullPath:        `%USERPROFILE%\\ntuser.dat`,
+				IsFullPathRegex: true,
+				FileName:        `ntuser.dat`,
+				IsFileNameRegex: false,
+				Priority:        40,
+			})
+			exportList = append(exportList, collector.FileToExport{
+				FullPath:        `%USERPROFILE%\\AppData\\Local\\Microsoft\\Windows\\usrclass.dat`,
+				IsFullPathRegex: true,
+				FileName:        `usrclass.dat`,
+				IsFileNameRegex: false,
+				Priority:        40,
+			})
+		}
+		if strings.Contains(dataTypes, "l") {
+			exportList = append(exportList, recentItemsTargets...)
+		}
+		if strings.Contains(dataTypes, "s") {
+			exportList = append(exportList, activityTargets...)
+		}
+		if strings.Contains(dataTypes, "v") {
+			exportList = append(exportList, defenderTargets...)
+		}
+		if strings.Contains(dataTypes, "w") {
+			exportList = append(exportList, webHistoryTargets...)
+		}
+		if strings.Contains(dataTypes, "h") {
+			exportList = append(exportList, lateralMovementTargets...)
+		}
+		if strings.Contains(dataTypes, "d") {
+			exportList = append(exportList, cloudSyncTargets...)
+		}
+	}
+	if copyEventLogs && (strings.Contains(dataTypes, "a") || strings.Contains(dataTypes, "e")) {
+		exportList = append(exportList, collector.FileToExport{
+			FullPath:        `%SYSTEMDRIVE%:\\Windows\\System32\\winevt\\Logs\\.*\.evtx$`,
+			IsFullPathRegex: true,
+			FileName:        `.*\.evtx$`,
+			IsFileNameRegex: true,
+			Priority:        30,
+		})
+	}
+	if strings.Contains(dataTypes, "b") {
+		exportList = append(exportList, bootTargets...)
+	}
+	if strings.Contains(dataTypes, "c") {
+		exportList = append(exportList, crashTargets...)
+	}
+	if strings.Contains(dataTypes, "i") {
+		exportList = append(exportList, networkTargets...)
+	}
+	if strings.Contains(dataTypes, "o") {
+		exportList = append(exportList, collector.ServerLogTargets(log.StandardLogger())...)
+	}
+	if strings.Contains(dataTypes, "n") {
+		exportList = append(exportList, metafileTargets...)
+	}
+	if strings.Contains(dataTypes, "p") {
+		if memoryFileLimit == 0 {
+			log.Warn("Collecting hiberfil.sys, pagefile.sys and swapfile.sys, each of which can be as big as the machine's RAM. Use --memory-file-limit to skip the big ones.")
+		}
+		exportList = append(exportList, memoryTargets(memoryFileLimit)...)
+	}
+	return
+}
+
+// caseSensitiveTargets makes every target match paths and names only in the case it's written in.
+func caseSensitiveTargets(exportList collector.ListOfFilesToExport) (caseSensitive collector.ListOfFilesToExport) {
+	for _, target := range exportList {
+		target.CaseSensitive = true
+		caseSensitive = append(caseSensitive, target)
+	}
+	return
+}
+
+// processedTargets sets the processor chain of the targets of each data type in chains, keyed by the data type's
+// abbreviation, e.g. {"e": "evtx_json,gzip"}. A target that's in several data types takes the chain of the last one.
+func processedTargets(exportList collector.ListOfFilesToExport, chains map[string]string, memoryFileLimit int64, copyEventLogs bool) (processed collector.ListOfFilesToExport) {
+	processed = append(processed, exportList...)
+	dataTypes := make([]string, 0, len(chains))
+	for dataType := range chains {
+		dataTypes = append(dataTypes, dataType)
+	}
+	sort.Strings(dataTypes)
+	for _, dataType := range dataTypes {
+		for _, target := range exportListForDataTypes(dataType, memoryFileLimit, copyEventLogs) {
+			for index := range processed {
+				if processed[index].FullPath == target.FullPath && processed[index].FileName == target.FileName {
+					processed[index].Processors = chains[dataType]
+				}
+			}
+		}
+	}
+	return
+}
+
+// parseProcessorChains parses --process values, LETTER=NAME,NAME, into the chain of each data type.
+func parseProcessorChains(values []string) (chains map[string]string, err error) {
+	chains = make(map[string]string)
+	for _, value := range values {
+		fields := strings.SplitN(value, "=", 2)
+		if len(fields) != 2 || len(fields[0]) != 1 || fields[1] == "" {
+			err = fmt.Errorf("'%s' is not a LETTER=NAME,NAME processor chain", value)
+			return
+		}
+		chains[strings.ToLower(fields[0])] = fields[1]
+	}
+	return
+}
+
+// remoteTargets points targets at the administrative shares of host, so %SYSTEMDRIVE%:\Windows becomes \\host\c$\Windows
+// and D:\Data becomes \\host\d$\Data. Targets on the EFI system partition can't be reached through a share and are left
+// out.
+func remoteTargets(exportList collector.ListOfFilesToExport, host string) (remote collector.ListOfFilesToExport) {
+	drive := regexp.MustCompile(`^(?i)(%systemdrive%|[a-z]):`)
+	// This machine's profiles say nothing about the other's, so every directory under its Users is a profile
+	for _, target := range collector.ExpandUserProfiles(collector.ExpandTargetTokens(exportList, host, nil), nil) {
+		if target.RecordNumber != 0 {
+			log.Warnf("Skipping MFT record %d of %s, a share has no MFT to look it up in.", target.RecordNumber, target.FullPath)
+			continue
+		}
+		match := drive.FindStringSubmatch(target.FullPath)
+		if match == nil {
+			log.Warnf("Skipping the target '%s', it isn't on a drive %s shares.", target.FullPath, host)
+			continue
+		}
+		letter := strings.ToLower(match[1])
+		if letter == "%systemdrive%" {
+			letter = "c"
+		}
+		share := `\\` + host + `\` + letter + "$"
+		if target.IsFullPathRegex {
+			share = regexp.QuoteMeta(share)
+		}
+		target.FullPath = share + target.FullPath[len(match[0]):]
+		remote = append(remote, target)
+	}
+	return
+}
+
+// recordTargets selects files by their MFT record numbers, given as C:91234, or just 91234 for the system drive.
+func recordTargets(records []string) (exportList collector.ListOfFilesToExport, err error) {
+	for _, record := range records {
+		volume, number := "%SYSTEMDRIVE%:", record
+		if index := strings.Index(record, ":"); index != -1 {
+			volume, number = record[:index+1], record[index+1:]
+		}
+		recordNumber, parseErr := strconv.ParseUint(number, 10, 32)
+		if parseErr != nil || recordNumber == 0 {
+			err = fmt.Errorf("'%s' isn't an MFT record number such as C:91234", record)
+			return
+		}
+		exportList = append(exportList, collector.FileToExport{FullPath: volume, RecordNumber: uint32(recordNumber), Priority: 100})
+	}
+	return
+}
+
+// hashTargets selects the files under candidates, a full path regex, with one of the SHA-256 hashes.
+func hashTargets(hashes []string, candidates string) collector.ListOfFilesToExport {
+	if len(hashes) == 0 {
+		return nil
+	}
+	return collector.ListOfFilesToExport{{
+		FullPath:        candidates,
+		IsFullPathRegex: true,
+		FileName:        `.*`,
+		IsFileNameRegex: true,
+		SHA256:          strings.Join(hashes, ","),
+		Priority:        100,
+	}}
+}
+
+// sweepTargets selects every file under path, a volume or directory, with one of the extensions, each of which can be
+// several separated by commas.
+func sweepTargets(extensions []string, path string, maxFileSize int64, within time.Duration) collector.ListOfFilesToExport {
+	if len(extensions) == 0 {
+		return nil
+	}
+	return collector.ListOfFilesToExport{{
+		FullPath:    strings.TrimRight(path, `\`),
+		Extensions:  strings.Join(extensions, ","),
+		MaxFileSize: maxFileSize,
+		Modified:    collector.TimeWindow{Within: within},
+	}}
+}
+
+// acquirersForDataTypes returns what is captured besides files: the host's live state for 'x', the network state and
+// its registry keys for 'i', the default registry keys for 'k' and the default WMI queries for 'q', none of which 'a'
+// includes, then any other registry keys, event log channels and WMI queries, and physical memory when a memory device
+// is given. The live state goes first since it changes the fastest.
+func acquirersForDataTypes(dataTypes string, memoryDevice string, wmiQueries []collector.WMIQuery, registryKeys []collector.RegistryKey, eventLogChannels []collector.EventLogChannel) (acquirers []collector.Acquirer, err error) {
+	if strings.Contains(dataTypes, "x") {
+		acquirers = append(acquirers, collector.VolatileAcquirers()...)
+	}
+	if strings.Contains(dataTypes, "i") {
+		acquirers = append(acquirers, collector.NetworkAcquirers()...)
+		registryKeys = append(append([]collector.RegistryKey(nil), collector.NetworkRegistryKeys...), registryKeys...)
+	}
+	if strings.Contains(dataTypes, "k") {
+		registryKeys = append(append([]collector.RegistryKey(nil), collector.DefaultRegistryKeys...), registryKeys...)
+	}
+	registryAcquirers, err := collector.RegistryAcquirers(registryKeys)
+	if err != nil {
+		return
+	}
+	acquirers = append(acquirers, registryAcquirers...)
+	eventLogAcquirers, err := collector.EventLogAcquirers(eventLogChannels)
+	if err != nil {
+		return
+	}
+	acquirers = append(acquirers, eventLogAcquirers...)
+	if strings.Contains(dataTypes, "q") {
+		wmiQueries = append(append([]collector.WMIQuery(nil), collector.DefaultWMIQueries...), wmiQueries...)
+	}
+	wmiAcquirers, err := collector.WMIAcquirers(wmiQueries)
+	if err != nil {
+		return
+	}
+	acquirers = append(acquirers, wmiAcquirers...)
+	if memoryDevice != "" {
+		acquirers = append(acquirers, &collector.PhysicalMemoryAcquirer{DevicePath: memoryDevice})
+	}
+	return
+}
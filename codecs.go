@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Codec is a compression method that result writers can use for the files they write. Method is the zip compression
+// method id stored in each entry's header. Compressor and Decompressor may be left nil for the methods archive/zip
+// already knows about (store and deflate).
+type Codec struct {
+	Name         string
+	Method       uint16
+	Compressor   zip.Compressor
+	Decompressor zip.Decompressor
+}
+
+// DefaultCodec is the codec used when neither the result writer nor the target asks for one.
+const DefaultCodec = "deflate"
+
+var (
+	codecRegistryLock sync.RWMutex
+	codecRegistry     = map[string]Codec{
+		"store":   {Name: "store", Method: zip.Store},
+		"deflate": {Name: "deflate", Method: zip.Deflate},
+	}
+)
+
+// RegisterCodec makes a codec available by name so it can be selected for a result writer or for individual targets.
+// This is how embedding applications add codecs such as zstd, lz4 or brotli without this package depending on them.
+func RegisterCodec(codec Codec) (err error) {
+	codec.Name = strings.ToLower(codec.Name)
+	if codec.Name == "" {
+		err = errors.New("RegisterCodec() received a codec without a name")
+		return
+	}
+	if codec.Compressor == nil && codec.Method != zip.Store && codec.Method != zip.Deflate {
+		err = fmt.Errorf("RegisterCodec() received codec '%s' without a compressor", codec.Name)
+		return
+	}
+
+	codecRegistryLock.Lock()
+	defer codecRegistryLock.Unlock()
+	for name, registered := range codecRegistry {
+		if registered.Method == codec.Method && name != codec.Name {
+			err = fmt.Errorf("RegisterCodec() can't register '%s', method %d is already used by '%s'", codec.Name, codec.Method, name)
+			return
+		}
+	}
+	codecRegistry[codec.Name] = codec
+	return
+}
+
+// LookupCodec returns the registered codec with the given name. An empty name returns the default codec.
+func LookupCodec(name string) (codec Codec, err error) {
+	if name == "" {
+		name = DefaultCodec
+	}
+	codecRegistryLock.RLock()
+	defer codecRegistryLock.RUnlock()
+	codec, ok := codecRegistry[strings.ToLower(name)]
+	if !ok {
+		err = fmt.Errorf("no codec named '%s' has been registered", name)
+	}
+	return
+}
+
+// RegisteredCodecs returns the names of every registered codec.
+func RegisteredCodecs() (names []string) {
+	codecRegistryLock.RLock()
+	defer codecRegistryLock.RUnlock()
+	for name := range codecRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// registeredDecompressors returns the registered codecs that archive/zip needs a decompressor for.
+func registeredDecompressors() (codecs []Codec) {
+	codecRegistryLock.RLock()
+	defer codecRegistryLock.RUnlock()
+	for _, codec := range codecRegistry {
+		if codec.Decompressor != nil {
+			codecs = append(codecs, codec)
+		}
+	}
+	return
+}
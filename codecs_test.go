@@ -0,0 +1,141 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// bestCompressionCodec stands in for a third party codec an embedding application would register.
+var bestCompressionCodec = Codec{
+	Name:   "deflate-best",
+	Method: 99,
+	Compressor: func(writer io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(writer, flate.BestCompression)
+	},
+	Decompressor: flate.NewReader,
+}
+
+func TestRegisterCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   Codec
+		wantErr bool
+	}{
+		{
+			name:    "no name",
+			codec:   Codec{Method: 98, Compressor: bestCompressionCodec.Compressor},
+			wantErr: true,
+		},
+		{
+			name:    "no compressor",
+			codec:   Codec{Name: "nothing", Method: 98},
+			wantErr: true,
+		},
+		{
+			name:    "method already taken",
+			codec:   Codec{Name: "not-deflate", Method: zip.Deflate, Compressor: bestCompressionCodec.Compressor},
+			wantErr: true,
+		},
+		{
+			name:    "valid codec",
+			codec:   bestCompressionCodec,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RegisterCodec(tt.codec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RegisterCodec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	tests := []struct {
+		name       string
+		codecName  string
+		wantMethod uint16
+		wantErr    bool
+	}{
+		{
+			name:       "default",
+			codecName:  "",
+			wantMethod: zip.Deflate,
+			wantErr:    false,
+		},
+		{
+			name:       "store",
+			codecName:  "STORE",
+			wantMethod: zip.Store,
+			wantErr:    false,
+		},
+		{
+			name:      "unknown",
+			codecName: "zstd",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCodec, err := LookupCodec(tt.codecName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LookupCodec() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && gotCodec.Method != tt.wantMethod {
+				t.Errorf("LookupCodec() method = %d, want %d", gotCodec.Method, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestZipResultWriter_perFileCodec(t *testing.T) {
+	_ = RegisterCodec(bestCompressionCodec)
+	output := new(bytes.Buffer)
+	zipResultWriter := ZipResultWriter{
+		ZipWriter: zip.NewWriter(output),
+		Codec:     "store",
+	}
+	fileReaders := make(chan fileReader, 2)
+	fileReaders <- fileReader{fullPath: "stored", reader: bytes.NewReader([]byte("stored data"))}
+	fileReaders <- fileReader{fullPath: "compressed", reader: bytes.NewReader([]byte("compressed data")), codec: "deflate-best"}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	_ = zipResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+
+	zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	zipReader.RegisterDecompressor(bestCompressionCodec.Method, bestCompressionCodec.Decompressor)
+	wantMethods := map[string]uint16{
+		"stored":     zip.Store,
+		"compressed": bestCompressionCodec.Method,
+	}
+	for _, file := range zipReader.File {
+		if file.Method != wantMethods[file.Name] {
+			t.Errorf("ZipResultWriter.ResultWriter() wrote %s with method %d, want %d", file.Name, file.Method, wantMethods[file.Name])
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Errorf("failed to open %s: %v", file.Name, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		if !bytes.HasPrefix(data, []byte(file.Name)) {
+			t.Errorf("ZipResultWriter.ResultWriter() wrote %q for %s", data, file.Name)
+		}
+	}
+}
@@ -3,81 +3,777 @@
 package windowscollector
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	mft "github.com/Go-Forensics/MFT-Parser"
-	log "github.com/sirupsen/logrus"
 	"io"
+	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
-// Collect will find and collect target files into a format depending on the resultWriter type
-func Collect(injectedHandlerDependency handler, exportList ListOfFilesToExport, resultWriter resultWriter) (err error) {
+// CollectOptions holds the optional settings for a collection. The zero value is a valid configuration.
+type CollectOptions struct {
+	// Progress is called with progress updates as the collection runs.
+	Progress ProgressFunc
+
+	// Workers is how many files are read at once, each spooled for the result writer. Zero or one reads them in turn.
+	Workers int
+
+	// ParallelVolumes parses the MFTs of every volume in scope at the same time.
+	ParallelVolumes bool
+
+	// ReadBytesPerSecond caps how fast files and MFTs are read across every volume and worker, zero for no limit.
+	ReadBytesPerSecond int64
+
+	// Control pauses and resumes the collection's reads and lowers the process' priority while it runs.
+	Control *CollectionControl
+
+	// CaptureClock records the host's time zone and time service settings into the output.
+	CaptureClock bool
+
+	// NTPServer is queried along with CaptureClock to measure the skew of the system clock, and isn't when empty.
+	NTPServer string
+
+	// ExportHives saves loaded registry hives with RegSaveKeyEx instead of copying their files from disk.
+	ExportHives bool
+
+	// ByteBudget caps the bytes of files collected, highest Priority first, listing the rest in budget_plan.json.
+	ByteBudget int64
+
+	// MaxFileSize, MaxTotalSize and MaxMatches cap the files collected across every target, zero for no limit.
+	MaxFileSize  int64
+	MaxTotalSize int64
+	MaxMatches   int
+
+	// APIFallback exports a loaded registry hive with RegSaveKeyEx when its file can't be read raw.
+	APIFallback bool
+
+	// DetectAntiForensics writes the signs of anti-forensics found while the MFT is walked into warnings.json.
+	DetectAntiForensics bool
+
+	// FileMetadata writes what the MFT holds about each collected file into file_metadata.jsonl.
+	FileMetadata bool
+
+	// RecoverDeleted also matches deleted file records and recovers their data into the output under _deleted.
+	RecoverDeleted bool
+
+	// ChangedSince only collects the files the change journal shows changed since each volume's mark, by letter.
+	ChangedSince map[string]USNJournalMark
+
+	// ChangedAfter only collects the files changed after this time on volumes without a mark in ChangedSince.
+	ChangedAfter time.Time
+
+	// MFTCache keeps the MFT of each volume so later collections search it instead of reading it again.
+	MFTCache *MFTCache
+
+	// Acquirers capture more than files into the output, such as RAM, one after the other before the volumes.
+	Acquirers []Acquirer
+
+	// Deduplicate writes files with the same content only once, listing the others in duplicates.json.
+	Deduplicate bool
+
+	// IndexDirectories are directories whose $I30 index is collected into the output under i30.
+	IndexDirectories []IndexDirectory
+
+	// Ranges are ranges of volumes read raw into the output under ranges.
+	Ranges []VolumeRange
+
+	// BootRecords writes the boot records of the volumes and of the disks under them under boot_records.
+	BootRecords bool
+
+	// Timeline writes a filesystem timeline of each NTFS volume's MFT under timeline, and none when empty.
+	Timeline TimelineFormat
+
+	// DirectoryTree writes the directory tree of each NTFS volume under directory_tree, and none when empty.
+	DirectoryTree DirectoryTreeScope
+
+	// Processors write processed copies of the files after them, or the first in their place with ReplaceProcessed.
+	Processors       []Processor
+	ReplaceProcessed bool
+
+	// ReadRetries is how often a failed raw read is retried, after ReadRetryDelay, 100ms when zero, doubling each time.
+	ReadRetries    int
+	ReadRetryDelay time.Duration
+
+	// Commands are run after the Acquirers with their output captured under commands/.
+	Commands []Command
+
+	// BitLockerRecoveryPassword, or the .bek file at BitLockerRecoveryKey, unlocks the volumes BitLocker has locked.
+	BitLockerRecoveryPassword string
+	BitLockerRecoveryKey      string
+
+	// Verify reads each collected file again the other way and compares their hashes in verification.json.
+	Verify bool
+
+	// ReadPolicy is how files are read, through the API first when it's empty, unless a FileToExport sets its own.
+	ReadPolicy ReadPolicy
+
+	// AuditLog records every step of the collection into the output as audit.jsonl.
+	AuditLog bool
+
+	// ETW writes the steps the audit log records as ETW events from the ETWProviderName provider.
+	ETW bool
+
+	// OnAudit is called with each step the audit log records, and how the collection finished, as they happen.
+	OnAudit func(entry AuditEntry)
+
+	// PendingFiles is how many files can wait for the result writer, 100 when it isn't set or 8 with MinimalFootprint.
+	PendingFiles int
+
+	// PendingBytes caps the bytes of pending files in memory, no limit when it isn't set or 16 MiB with MinimalFootprint.
+	PendingBytes int64
+
+	// Resume leaves out the files an earlier run wrote, going by its state file, and records the ones this run writes.
+	Resume *ResumeState
+
+	// MinimalFootprint reads raw, refuses what creates temp files, opens files or starts processes, and bounds memory.
+	MinimalFootprint bool
+
+	// StartedAs is the name the executable was started as, when it relaunched itself under another one.
+	StartedAs string
+
+	// Logger is what the collection logs through, logrus' standard logger when nil.
+	Logger Logger
+}
+
+// Collector runs collections with the same CollectOptions, so a program embedding it can set it up once with its
+// workers, throttling, logger and so on, and then collect whenever it needs to:
+//
+//	c := windowscollector.NewCollector(windowscollector.CollectOptions{Workers: 4, Logger: logger})
+//	report, err := c.CollectWithReport(ctx, targets, &windowscollector.ZipResultWriter{...})
+//
+// Each collection runs on its own copy of the Collector, which keeps the state of the collection.
+type Collector struct {
+	Options CollectOptions
+	volumes handler // opens the volumes, the raw volumes unless a test swaps it out
+
+	readLimiter  *rateLimiter
+	userProfiles map[string]string
+	report       *reportBuilder
+	partial      *partialCollectionTracker
+	budget       *budgetPlanner
+	limits       *limitTracker
+	warnings     *warningCollector
+	metadata     *metadataCollector
+	dedup        *deduplicator
+	deleted      *deletedFileRecovery
+	bootRecords  *bootRecordCollector
+	bitLocker    *bitLockerUnlocker
+	verifier     *fileVerifier
+	planner      *filePlanner
+	audit        *auditLog
+	pipeline     *filePipeline // between the files being read and the result writer, nil when they're sent straight to a channel
+}
+
+// NewCollector returns a Collector that collects from the host's volumes with options.
+func NewCollector(options CollectOptions) *Collector {
+	return &Collector{Options: options, volumes: VolumeHandler{}}
+}
+
+// newRun returns a copy of the Collector with its options and none of the state of a collection, for one to run on.
+func (collector *Collector) newRun() *Collector {
+	volumes := collector.volumes
+	if volumes == nil {
+		volumes = VolumeHandler{}
+	}
+	return &Collector{Options: collector.Options, volumes: volumes}
+}
+
+// Collect finds the targets and writes them with resultWriter, the way the Collect function does.
+func (collector *Collector) Collect(ctx context.Context, targets ListOfFilesToExport, resultWriter ResultWriter) (err error) {
+	return collector.newRun().collect(ctx, targets, resultWriter)
+}
+
+// CollectRange reads ranges of volumes raw with resultWriter, on their own, along with the collector's Ranges.
+func (collector *Collector) CollectRange(ctx context.Context, resultWriter ResultWriter, ranges ...VolumeRange) (err error) {
+	run := collector.newRun()
+	run.Options.Ranges = append(append([]VolumeRange(nil), run.Options.Ranges...), ranges...)
+	return run.collect(ctx, nil, resultWriter)
+}
+
+// CollectWithReport finds the targets and writes them with resultWriter along with report.json, the way the
+// CollectWithReport function does.
+func (collector *Collector) CollectWithReport(ctx context.Context, targets ListOfFilesToExport, resultWriter ResultWriter) (report CollectionReport, err error) {
+	run := collector.newRun()
+	run.report = newReportBuilder()
+	err = run.collect(ctx, targets, resultWriter)
+	report = run.report.snapshot()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return
+}
+
+// Collect will find and collect target files into a format depending on the resultWriter type. Cancelling ctx stops the
+// collection between reads and closes out the result writer so whatever was collected up to that point is still usable.
+// Files and volumes that fail are skipped and the rest are still collected; their errors are returned together as
+// CollectionErrors once the collection has finished.
+// Programs embedding the collector would rather use a Collector, which doesn't need the volumes' handler passed in.
+func Collect(ctx context.Context, injectedHandlerDependency handler, exportList ListOfFilesToExport, resultWriter ResultWriter, options CollectOptions) (err error) {
 	// volumeHandler as an arg is a dependency injection
-	log.Debugf("Attempting to acquire the following files %+v", exportList)
-	volumesOfInterest, err := identifyVolumesOfInterest(&exportList)
+	collector := &Collector{Options: options, volumes: injectedHandlerDependency}
+	return collector.Collect(ctx, exportList, resultWriter)
+}
+
+// CollectWithReport works like Collect, and also writes a summary of the collection into the output as report.json and
+// returns it. The report is returned even when the collection fails part way through.
+func CollectWithReport(ctx context.Context, injectedHandlerDependency handler, exportList ListOfFilesToExport, resultWriter ResultWriter, options CollectOptions) (report CollectionReport, err error) {
+	collector := &Collector{Options: options, volumes: injectedHandlerDependency}
+	return collector.CollectWithReport(ctx, exportList, resultWriter)
+}
+
+// collect runs a collection on a Collector from newRun: it checks the options, sets up the collection's state, starts
+// the result writer, runs the acquirers and commands, collects from each volume and then writes what was found out
+// along the way.
+func (collector *Collector) collect(ctx context.Context, exportList ListOfFilesToExport, resultWriter ResultWriter) (err error) {
+	collector.logger().Debugf("Attempting to acquire the following files %+v", exportList)
+	exportList, volumesOfInterest, searchTerms, err := collector.prepare(exportList)
+	if err != nil {
+		return
+	}
+	collectionLimits, err := collector.Options.validate(exportList)
+	if err != nil {
+		return
+	}
+	err = collector.Options.Resume.Begin("")
+	if err != nil {
+		return
+	}
+
+	writeReport := collector.report != nil
+	privileged := collector.begin(exportList, volumesOfInterest, collectionLimits)
+	defer func() {
+		if collector.audit != nil {
+			collector.audit.finish(collector.report.snapshot(), err)
+		}
+	}()
+	startingPrivileges := collector.audit.recordPrivileges(nil, "was enabled when the collection started")
+
+	ctx, fileReaders, waitForResultWriter := collector.startResultWriter(ctx, resultWriter, exportList)
+	defer func() {
+		err = waitForResultWriter(err)
+		collector.bitLocker.relock(collector.logger())
+		if err == nil {
+			err = collector.report.err()
+		}
+		collector.Options.Progress.report(Progress{Stage: StageDone})
+	}()
+
+	// A dry run only searches the volumes
+	if collector.planner == nil {
+		err = collector.runAcquirers(ctx, fileReaders)
+		if err != nil {
+			return
+		}
+		err = collector.runCommands(ctx, fileReaders)
+		if err != nil {
+			return
+		}
+	}
+
+	err = collector.collectVolumes(ctx, volumesOfInterest, fileReaders, searchTerms)
+	if err != nil {
+		return
+	}
+
+	if collector.planner != nil {
+		collector.planner.setBudget(collector.budget)
+		return
+	}
+	err = collector.sendSummaries(ctx, fileReaders, privileged, startingPrivileges, writeReport)
+	return
+}
+
+// prepare expands the targets' tokens and user profiles, and works out the volumes they are on and their search terms,
+// along with the volumes of the IndexDirectories and Ranges.
+func (collector *Collector) prepare(exportList ListOfFilesToExport) (expanded ListOfFilesToExport, volumesOfInterest []string, searchTerms listOfSearchTerms, err error) {
+	expanded = exportList
+	if usesUserProfiles(expanded) || usesTargetTokens(expanded) {
+		profiles := listUserProfiles(collector.logger())
+		hostname, _ := os.Hostname()
+		expanded = ExpandUserProfiles(ExpandTargetTokens(expanded, hostname, profiles), profileDirectories(profiles))
+	}
+	volumesOfInterest, err = identifyVolumesOfInterest(&expanded)
 	if err != nil {
 		err = fmt.Errorf("identifyVolumesOfInterest() returned an error: %w", err)
 		return
 	}
 
-	searchTerms, err := setupSearchTerms(exportList)
+	collector.Options.IndexDirectories, err = normalizeIndexDirectories(collector.Options.IndexDirectories)
+	if err != nil {
+		err = fmt.Errorf("normalizeIndexDirectories() returned an error: %w", err)
+		return
+	}
+	volumesOfInterest = addIndexVolumes(volumesOfInterest, collector.Options.IndexDirectories)
+
+	collector.Options.Ranges, err = normalizeVolumeRanges(collector.Options.Ranges)
+	if err != nil {
+		err = fmt.Errorf("normalizeVolumeRanges() returned an error: %w", err)
+		return
+	}
+	volumesOfInterest = addRangeVolumes(volumesOfInterest, collector.Options.Ranges)
+
+	searchTerms, err = setupSearchTerms(expanded)
 	if err != nil {
 		err = fmt.Errorf("setupSearchTerms() returned the following error: %w", err)
+	}
+	return
+}
+
+// validate checks the options for a collection of exportList, returning the limits of the collection.
+func (options CollectOptions) validate(exportList ListOfFilesToExport) (collectionLimits fileLimits, err error) {
+	collectionLimits = fileLimits{maxFileSize: options.MaxFileSize, maxTotalSize: options.MaxTotalSize, maxMatches: options.MaxMatches}
+	if err = collectionLimits.validate(); err != nil {
+		err = fmt.Errorf("the collection has invalid limits: %w", err)
+		return
+	}
+
+	if err = options.ReadPolicy.validate(); err != nil {
+		err = fmt.Errorf("the collection has an invalid read policy: %w", err)
+		return
+	}
+
+	if err = options.Timeline.validate(); err != nil {
+		err = fmt.Errorf("the collection has an invalid timeline format: %w", err)
+		return
+	}
+
+	if err = options.DirectoryTree.validate(); err != nil {
+		err = fmt.Errorf("the collection has an invalid directory tree scope: %w", err)
+		return
+	}
+
+	err = validateCommands(options.Commands)
+	if err != nil {
+		err = fmt.Errorf("validateCommands() returned an error: %w", err)
+		return
+	}
+
+	if err = options.checkMinimalFootprint(); err != nil {
 		return
 	}
+	if options.MinimalFootprint && !options.ReplaceProcessed && exportList.processed() {
+		err = errors.New("a minimal footprint doesn't allow targets with processors alongside the files, which spools their copies")
+	}
+	return
+}
+
+// begin sets up the state of the collection and records that it started, returning whether the process is elevated.
+func (collector *Collector) begin(exportList ListOfFilesToExport, volumesOfInterest []string, collectionLimits fileLimits) (privileged bool) {
+	options := collector.Options
+	collector.readLimiter = newRateLimiter(options.ReadBytesPerSecond)
+	if options.ExportHives || options.APIFallback {
+		collector.userProfiles = userProfiles(collector.logger())
+	}
+
+	// The report builder also keeps track of what failed, so there always is one even if report.json wasn't asked for
+	if collector.report == nil {
+		collector.report = newReportBuilder()
+	}
+	privileged = processIsElevated()
+	collector.partial = newPartialCollectionTracker(privileged, collector.logger())
+	collector.budget = newBudgetPlanner(options.ByteBudget)
+	collector.limits = newLimitTracker(collectionLimits)
+	collector.warnings = newWarningCollector(options.DetectAntiForensics)
+	collector.metadata = newMetadataCollector(options.FileMetadata, collector.logger())
+	collector.dedup = newDeduplicator(options.Deduplicate)
+	collector.deleted = newDeletedFileRecovery(options.RecoverDeleted)
+	collector.bootRecords = newBootRecordCollector(options.BootRecords)
+	collector.bitLocker = newBitLockerUnlocker(options.BitLockerRecoveryPassword, options.BitLockerRecoveryKey)
+	collector.verifier = newFileVerifier(options.Verify, collector.volumes)
+	etw, etwErr := newETWProvider(options.ETW)
+	if etwErr != nil {
+		collector.logger().Warnf("Not writing ETW events: %v", etwErr)
+	}
+	collector.audit = newAuditLog(options.AuditLog, etw, options.OnAudit)
+	collector.report.audit = collector.audit
+	collector.report.setResume(options.Resume)
+	collector.report.setFootprint(options.footprintReport())
+	collector.report.setHost(captureHostInfo(collector.logger(), volumesOfInterest))
+	hostname, _ := os.Hostname()
+	collector.audit.record(AuditCollectionStarted, "", "", fmt.Sprintf("version %s on %s, elevated: %v, %d targets on volumes %s", Version, hostname, privileged, len(exportList), strings.Join(volumesOfInterest, ", ")))
+	return
+}
 
+// startResultWriter runs resultWriter on the files sent to fileReaders. Every volume feeds the same result writer so all
+// the files end up in one output. If the result writer fails or returns early, the returned context is cancelled since
+// there is nowhere left to put the files, and whatever is sending a file gets the result writer's error. wait closes
+// fileReaders, waits for the result writer to finish and returns the collection's error given the one it had.
+func (collector *Collector) startResultWriter(ctx context.Context, resultWriter ResultWriter, exportList ListOfFilesToExport) (collectionCtx context.Context, fileReaders chan fileReader, wait func(err error) error) {
+	collectionCtx, cancelCollection := context.WithCancel(ctx)
+	pipeline := newFilePipeline(collector.Options)
+	collector.pipeline = pipeline
+	fileReaders = pipeline.files
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	resultWriterErr := make(chan error, 1)
+	resultWriter = collector.withProcessors(resultWriter, exportList)
+	go func() {
+		writerErr := resultWriter.ResultWriter(collectionCtx, fileReaders, &waitForFileCopying)
+		pipeline.stop(writerErr)
+		cancelCollection()
+		resultWriterErr <- writerErr
+	}()
+
+	wait = func(err error) error {
+		defer cancelCollection()
+		// The result writer only returns before the files run out when it fails or gives up
+		stoppedEarly := pipeline.failure()
+		// A collection that failed is cancelled first, so the result writer closes out its output as incomplete
+		failed := err != nil
+		if failed {
+			cancelCollection()
+		}
+		close(fileReaders)
+		waitForFileCopying.Wait()
+		writerErr := <-resultWriterErr
+		if ctx.Err() != nil {
+			if err == nil {
+				err = fmt.Errorf("collection was cancelled: %w", ctx.Err())
+			}
+		} else if writerErr != nil && !(failed && stoppedEarly == nil && errors.Is(writerErr, context.Canceled)) {
+			err = fmt.Errorf("the result writer failed: %w", writerErr)
+		} else if stoppedEarly != nil {
+			err = stoppedEarly
+		}
+		return err
+	}
+	return
+}
+
+// collectVolumes collects from each volume in turn, or from all of them at once with ParallelVolumes.
+func (collector *Collector) collectVolumes(ctx context.Context, volumesOfInterest []string, fileReaders chan fileReader, searchTerms listOfSearchTerms) (err error) {
+	if collector.Options.ParallelVolumes && len(volumesOfInterest) > 1 {
+		return collector.collectVolumesInParallel(ctx, volumesOfInterest, fileReaders, searchTerms)
+	}
 	for _, volumeLetter := range volumesOfInterest {
-		var volumeHandler VolumeHandler
-		volumeHandler, err = GetVolumeHandler(volumeLetter, injectedHandlerDependency)
+		err = collector.collectVolume(ctx, volumeLetter, fileReaders, searchTerms)
 		if err != nil {
-			err = fmt.Errorf("GetVolumeHandler() failed to get a handle to the volume %s: %w", volumeLetter, err)
 			return
 		}
+	}
+	return
+}
+
+// sendSummaries writes what the collection found out along the way into the output once the volumes are done.
+func (collector *Collector) sendSummaries(ctx context.Context, fileReaders chan fileReader, privileged bool, startingPrivileges map[string]bool, writeReport bool) (err error) {
+	// Make it obvious in the output when some volumes could only be collected from through the API
+	collector.report.setPrivileges(privileged, collector.partial.isPartial())
+	if collector.partial.isPartial() {
+		err = collector.sendMetadata(ctx, fileReaders, partialCollectionFileName, collector.partial.snapshot())
+		if err != nil {
+			err = fmt.Errorf("failed to write the partial collection notice: %w", err)
+			return
+		}
+	}
+
+	if collector.budget != nil {
+		plan := collector.budget.snapshot()
+		collector.report.setDeferred(len(plan.Deferred))
+		err = collector.sendMetadata(ctx, fileReaders, budgetPlanFileName, plan)
+		if err != nil {
+			err = fmt.Errorf("failed to write the budget plan: %w", err)
+			return
+		}
+	}
 
-		err = getFiles(&volumeHandler, resultWriter, searchTerms)
+	if collector.warnings != nil {
+		collector.warnings.add(hostWarnings()...)
+		warnings := collector.warnings.snapshot()
+		collector.report.setWarnings(len(warnings))
+		err = collector.sendMetadata(ctx, fileReaders, warningsFileName, warnings)
 		if err != nil {
-			err = fmt.Errorf("getFiles() failed to get files: %w", err)
+			err = fmt.Errorf("failed to write the warnings: %w", err)
+			return
+		}
+	}
+
+	err = collector.sendDuplicates(ctx, fileReaders)
+	if err != nil {
+		return
+	}
+
+	if collector.deleted != nil {
+		err = collector.sendMetadata(ctx, fileReaders, recoveredFileName, collector.deleted.snapshot())
+		if err != nil {
+			err = fmt.Errorf("failed to write the recovered deleted files: %w", err)
+			return
+		}
+	}
+
+	if collector.bootRecords != nil {
+		err = collector.sendMetadata(ctx, fileReaders, bootRecordsFileName, collector.bootRecords.snapshot())
+		if err != nil {
+			err = fmt.Errorf("failed to write the boot records: %w", err)
+			return
+		}
+	}
+
+	if collector.metadata != nil {
+		var metadataReader io.Reader
+		metadataReader, err = collector.metadata.reader()
+		if err != nil {
+			return
+		}
+		err = collector.sendFileReader(ctx, fileReaders, fileReader{
+			fullPath: fileMetadataFileName,
+			reader:   metadataReader,
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to write the file metadata: %w", err)
+			return
+		}
+	}
+
+	if collector.verifier != nil {
+		// The files are read again once the result writer gets to verification.json, after it has written them all
+		err = collector.sendFileReader(ctx, fileReaders, fileReader{
+			fullPath: verificationFileName,
+			reader:   collector.verifier.reader(ctx, collector),
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to write the verification: %w", err)
+			return
+		}
+	}
+
+	if collector.Options.CaptureClock {
+		clock := captureClockInfo(ctx, collector.logger(), collector.Options.NTPServer)
+		collector.report.setClock(clock)
+		err = collector.sendMetadata(ctx, fileReaders, clockMetadataFileName, clock)
+		if err != nil {
+			err = fmt.Errorf("failed to write the clock metadata: %w", err)
+			return
+		}
+	}
+
+	if collector.Options.AuditLog {
+		collector.audit.recordPrivileges(startingPrivileges, "was enabled during the collection")
+		err = collector.sendFileReader(ctx, fileReaders, fileReader{
+			fullPath: auditLogFileName,
+			reader:   collector.audit.reader(),
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to write the audit log: %w", err)
+			return
+		}
+	}
+
+	// The report goes last so it covers everything the result writer wrote before it
+	if writeReport {
+		err = collector.sendFileReader(ctx, fileReaders, fileReader{
+			fullPath: reportFileName,
+			reader:   collector.report.reader(),
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to write the collection report: %w", err)
 			return
 		}
 	}
 	return
 }
 
-func getFiles(volumeHandler *VolumeHandler, resultWriter resultWriter, listOfSearchKeywords listOfSearchTerms) (err error) {
-	// Init a few things
-	fileReaders := make(chan fileReader, 100)
-	waitForFileCopying := sync.WaitGroup{}
-	waitForFileCopying.Add(1)
-	go resultWriter.ResultWriter(fileReaders, &waitForFileCopying)
+// collectVolume finds the search terms on a single volume and hands what it finds to the result writer. A volume that
+// fails is recorded in the report so the other volumes still get collected; only cancellation is returned as an error.
+func (collector *Collector) collectVolume(ctx context.Context, volumeLetter string, fileReaders chan fileReader, searchTerms listOfSearchTerms) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			collector.logger().Errorf("Skipping the rest of volume %s: %v", volumeLetter, err)
+			collector.report.volumeFailed(volumeLetter, err)
+			err = nil
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		err = fmt.Errorf("collection stopped before volume %s: %w", volumeLetter, err)
+		return
+	}
+
+	if isShare(volumeLetter) {
+		if err = collector.Options.refuseAPIFallback(volumeLetter, "is a share"); err != nil {
+			return
+		}
+		err = collector.collectShare(ctx, volumeLetter, fileReaders, searchTerms)
+		return
+	}
+	volumeHandler, err := GetVolumeHandler(volumeLetter, collector.volumes)
+	var lockedVolumeError *LockedVolumeError
+	if errors.As(err, &lockedVolumeError) {
+		err = collector.bitLocker.unlock(collector.logger(), volumeLetter)
+		if err != nil {
+			return
+		}
+		volumeHandler, err = GetVolumeHandler(volumeLetter, collector.volumes)
+	}
+	var fileSystemError *FileSystemError
+	if err != nil && isVolumeAccessDenied(err) {
+		collector.rangesFailed(volumeLetter, errors.New("the volume can't be read raw"))
+		if err = collector.Options.refuseAPIFallback(volumeLetter, "can't be read raw"); err != nil {
+			return
+		}
+		err = collector.collectVolumeViaAPI(ctx, volumeLetter, fileReaders, searchTerms)
+		return
+	} else if errors.As(err, &fileSystemError) {
+		collector.rangesFailed(volumeLetter, fmt.Errorf("ranges are only read from NTFS volumes, not %s", fileSystemError.FileSystem))
+		if err = collector.Options.refuseAPIFallback(volumeLetter, "is "+fileSystemError.FileSystem); err != nil {
+			return
+		}
+		err = collector.collectVolumeByWalking(ctx, volumeLetter, fileSystemError.FileSystem, fileReaders, searchTerms)
+		collector.recordBitLocker(ctx, volumeLetter)
+		return
+	} else if err != nil {
+		err = fmt.Errorf("GetVolumeHandler() failed to get a handle to the volume %s: %w", volumeLetter, err)
+		return
+	}
+	volumeHandler.Logger = collector.Options.Logger
+	volumeHandler.retry = newReadRetry(collector.Options.ReadRetries, collector.Options.ReadRetryDelay)
+	volumeHandler.audit = collector.audit
+	volumeHandler.logger().Debugf("Successfully got a file handle to volume %v and read its volume boot record.", volumeLetter)
+	collector.report.addVolume(volumeHandler)
+	collector.recordBitLocker(ctx, volumeLetter)
+
+	err = collector.collectBootRecords(ctx, &volumeHandler, fileReaders)
+	if err != nil {
+		return
+	}
+	if collector.planner == nil {
+		err = collector.collectRanges(ctx, &volumeHandler, fileReaders)
+		if err != nil {
+			return
+		}
+	}
+	if !hasFilesToFind(volumeLetter, searchTerms, collector.Options) {
+		return
+	}
+
+	err = collector.getFiles(ctx, &volumeHandler, fileReaders, searchTerms)
+	if errors.Is(err, errMFTNotFound) && collector.planner == nil {
+		// Without the MFT's data runs nothing can be found raw, but whatever the API can still open is worth having
+		volumeHandler.logger().Errorf("Could not find the MFT of volume %s: %v", volumeLetter, err)
+		if err = collector.Options.refuseAPIFallback(volumeLetter, "has no MFT where its boot record says"); err != nil {
+			return
+		}
+		collector.report.setMFTFallback(volumeLetter, mftFallbackAPI)
+		err = collector.collectVolumeViaAPI(ctx, volumeLetter, fileReaders, searchTerms)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("getFiles() failed to get files: %w", err)
+		return
+	}
+	return
+}
+
+// collectVolumesInParallel parses each volume's MFT at the same time. They all share the compiled search terms and feed
+// the same result writer. The first error is returned after every volume has finished.
+func (collector *Collector) collectVolumesInParallel(ctx context.Context, volumesOfInterest []string, fileReaders chan fileReader, searchTerms listOfSearchTerms) (err error) {
+	volumeErrors := make(chan error, len(volumesOfInterest))
+	waitForVolumes := sync.WaitGroup{}
+	for _, volumeLetter := range volumesOfInterest {
+		waitForVolumes.Add(1)
+		go func(volumeLetter string) {
+			defer waitForVolumes.Done()
+			volumeErrors <- collector.collectVolume(ctx, volumeLetter, fileReaders, searchTerms)
+		}(volumeLetter)
+	}
+	collector.logger().Debugf("Collecting from volumes %v in parallel.", volumesOfInterest)
+	waitForVolumes.Wait()
+	close(volumeErrors)
+
+	for volumeErr := range volumeErrors {
+		if volumeErr != nil && err == nil {
+			err = volumeErr
+		}
+	}
+	return
+}
 
+func (collector *Collector) getFiles(ctx context.Context, volumeHandler *VolumeHandler, fileReaders chan fileReader, listOfSearchKeywords listOfSearchTerms) (err error) {
 	// parse the mft's mft record to get its dataruns
-	mftRecord0, err := parseMFTRecord0(volumeHandler)
+	collector.Options.Progress.report(Progress{Stage: StageMFTParse, VolumeLetter: volumeHandler.VolumeLetter})
+	mftRecord0, usedMirror, err := locateMFT(volumeHandler)
 	if err != nil {
-		err = fmt.Errorf("parseMFTRecord0() failed to parse mft record 0 from the volume %s: %w", volumeHandler.VolumeLetter, err)
+		err = fmt.Errorf("locateMFT() failed to parse mft record 0 from the volume %s: %w", volumeHandler.VolumeLetter, err)
 		return
 	}
-	log.Debugf("Parsed the MFT's MFT record and got the following: %+v", mftRecord0)
+	if usedMirror {
+		collector.report.setMFTFallback(volumeHandler.VolumeLetter, mftFallbackMirror)
+	}
+	volumeHandler.logger().Debugf("Parsed the MFT's MFT record and got the following: %+v", mftRecord0)
 
 	// Go back to the beginning of the mft record
 	_, _ = volumeHandler.Handle.Seek(volumeHandler.Vbr.MftByteOffset, 0)
-	log.Debugf("Seeked back to the beginning offset to the MFT at offset %d", volumeHandler.Vbr.MftByteOffset)
+	volumeHandler.logger().Debugf("Seeked back to the beginning offset to the MFT at offset %d", volumeHandler.Vbr.MftByteOffset)
+
+	// Note where the change journal is before the MFT is read, so the next incremental collection picks up whatever
+	// changes while this one runs
+	journal, journalErr := queryUSNJournal(volumeHandler)
+	if journalErr == nil {
+		collector.report.setUSNJournal(volumeHandler.VolumeLetter, USNJournalMark{JournalID: journal.UsnJournalID, NextUSN: journal.NextUsn})
+	} else {
+		volumeHandler.logger().Debugf("Failed to query the change journal of volume %s: %v", volumeHandler.VolumeLetter, journalErr)
+	}
+	var changes *changeFilter
+	if collector.Options.incremental() {
+		var fallback string
+		changes, fallback = newChangeFilter(volumeHandler, journal, journalErr, collector.Options)
+		if changes == nil {
+			volumeHandler.logger().Warnf("Collecting every target file on volume %s since %s.", volumeHandler.VolumeLetter, fallback)
+			collector.report.setIncrementalFallback(volumeHandler.VolumeLetter, fallback)
+		}
+	}
 
 	// Open a raw reader on the MFT
 	foundFile := foundFile{
 		dataRuns: mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns,
 		fullPath: "$mft",
 	}
-	mftReader := rawFileReader(volumeHandler, foundFile)
-	log.Debug("Obtained a raw io.Reader to the MFT's dataruns.")
+	mftReader := collector.instrumentReader(ctx, rawFileReader(volumeHandler, foundFile), Progress{
+		Stage:        StageSearch,
+		VolumeLetter: volumeHandler.VolumeLetter,
+		FileName:     foundFile.fullPath,
+		TotalBytes:   foundFile.totalSize(),
+	})
+	volumeHandler.logger().Debugf("Obtained a raw io.Reader to the MFT's dataruns.")
+
+	// Work on a copy of the search terms since other volumes may be searching with the same list
+	listOfSearchKeywords = append(listOfSearchTerms(nil), listOfSearchKeywords...)
 
 	// Do we need to stream a copy of the mft while we read it?
 	areWeCopyingTheMFT := false
 	directoryTree := mft.DirectoryTree{}
 	possibleMatches := possibleMatches{}
+	if collector.warnings != nil {
+		volumeHandler.inspector = newMftInspector(volumeHandler.VolumeLetter)
+	}
+	if collector.planner == nil {
+		volumeHandler.timeline = newMftTimeline(volumeHandler.VolumeLetter, collector.Options.Timeline)
+		volumeHandler.directoryExport = newDirectoryTreeExport(volumeHandler.VolumeLetter, collector.Options.DirectoryTree)
+	}
+	volumeHandler.recoverDeleted = collector.deleted != nil
 
+	mftCodec := ""
+	var mftProcessors ProcessorChain
 	for index, value := range listOfSearchKeywords {
 		if value.fileNameString == "$mft" {
-			areWeCopyingTheMFT = true
+			// The MFT is copied while it's searched, so it gets its share of the limits and the budget before anything
+			// else is found
+			mftFile := foundFile
+			mftFile.fullPath = fmt.Sprintf("%s:\\$mft", volumeHandler.VolumeLetter)
+			mftFile.limits, mftFile.target = value.limits, value.target
+			mftFiles, numberResumed := collector.Options.Resume.filterFiles(foundFiles{mftFile})
+			collector.report.addResumed(volumeHandler.VolumeLetter, numberResumed)
+			areWeCopyingTheMFT = len(collector.applyLimits(volumeHandler.VolumeLetter, mftFiles, false)) == 1 &&
+				collector.budget.admit(mftFile.fullPath, volumeHandler.VolumeLetter, foundFile.totalSize(), value.priority)
+			if areWeCopyingTheMFT && collector.planner.planned(volumeHandler.VolumeLetter, foundFiles{mftFile}) {
+				areWeCopyingTheMFT = false
+			}
+			mftCodec, mftProcessors = value.codec, value.processors
 
 			// delete this from our search list
 			listOfSearchKeywords[index] = listOfSearchKeywords[len(listOfSearchKeywords)-1]
@@ -87,18 +783,64 @@ func getFiles(volumeHandler *VolumeHandler, resultWriter resultWriter, listOfSea
 		}
 	}
 
-	if areWeCopyingTheMFT == true {
-		log.Debug("We are configured to grab a copy of the MFT, so we'll set up a io.TeeReader with an io.Pipe so we can copy the mft as we read it. We do this so we only have to read the MFT's data runs once and only once.")
+	// A cached MFT can't be copied, inspected, timelined, have its directory tree written or be searched by record
+	// number, so those collections read the MFT again and refresh the cache
+	var cached *cachedMFT
+	if areWeCopyingTheMFT == false && volumeHandler.inspector == nil && volumeHandler.timeline == nil && volumeHandler.directoryExport == nil && !listOfSearchKeywords.selectsRecords() {
+		cached = collector.Options.MFTCache.lookup(volumeHandler.logger(), volumeHandler.VolumeLetter, foundFile.totalSize())
+	}
+	if cached == nil {
+		volumeHandler.cacheBuilder, err = collector.Options.MFTCache.newBuilder(volumeHandler.logger(), volumeHandler.VolumeLetter, foundFile.totalSize(), volumeHandler.Vbr.MftRecordSize)
+		if err != nil {
+			return
+		}
+		defer volumeHandler.cacheBuilder.discard()
+	}
+
+	if cached != nil {
+		volumeHandler.logger().Debugf("Searching the cached MFT of volume %s instead of reading it again.", volumeHandler.VolumeLetter)
+		possibleMatches, directoryTree, err = cached.search(volumeHandler, listOfSearchKeywords)
+		if err != nil {
+			err = fmt.Errorf("cachedMFT.search() failed: %w", err)
+			return
+		}
+	} else if areWeCopyingTheMFT == true {
+		volumeHandler.logger().Debugf("We are configured to grab a copy of the MFT, so we'll set up a io.TeeReader with an io.Pipe so we can copy the mft as we read it. We do this so we only have to read the MFT's data runs once and only once.")
 		pipeReader, pipeWriter := io.Pipe()
 		teeReader := io.TeeReader(mftReader, pipeWriter)
 		fileReader := fileReader{
-			fullPath: fmt.Sprintf("%s__$mft", volumeHandler.VolumeLetter),
-			reader:   pipeReader,
+			fullPath:   fmt.Sprintf("%s:\\$mft", volumeHandler.VolumeLetter),
+			reader:     pipeReader,
+			codec:      mftCodec,
+			processors: mftProcessors,
+			method:     readMethodRaw,
+			times: fileTimes{
+				created:  mftRecord0.StandardInformationAttributes.SiCreated,
+				modified: mftRecord0.StandardInformationAttributes.SiModified,
+				accessed: mftRecord0.StandardInformationAttributes.SiAccessed,
+			},
+		}
+		collector.report.addMatches(volumeHandler.VolumeLetter, 1)
+		collector.audit.readDecision(volumeHandler.VolumeLetter, fileReader.fullPath, readMethodRaw, "it's copied as it's read for the search", "")
+		err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(fileReader, volumeHandler.VolumeLetter))
+		if err != nil {
+			return
 		}
-		fileReaders <- fileReader
+
+		// If the collection is cancelled the result writer stops draining the pipe, so close it to unblock the tee
+		stopWatchingPipe := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = pipeReader.CloseWithError(ctx.Err())
+			case <-stopWatchingPipe:
+			}
+		}()
 		volumeHandler.mftReader = teeReader
 		possibleMatches, directoryTree, err = findPossibleMatches(volumeHandler, listOfSearchKeywords)
+		close(stopWatchingPipe)
 		if err != nil {
+			_ = pipeWriter.CloseWithError(err)
 			err = fmt.Errorf("findPossibleMatches() failed: %w", err)
 			return
 		}
@@ -116,30 +858,189 @@ func getFiles(volumeHandler *VolumeHandler, resultWriter resultWriter, listOfSea
 		}
 	}
 
-	foundFiles := confirmFoundFiles(listOfSearchKeywords, possibleMatches, directoryTree)
+	collector.warnings.add(volumeHandler.inspector.finish(directoryTree)...)
+	err = collector.sendTimeline(ctx, fileReaders, volumeHandler.timeline, directoryTree)
+	volumeHandler.timeline = nil
+	if err != nil {
+		return
+	}
+	foundFiles := confirmFoundFiles(volumeHandler.logger(), listOfSearchKeywords, possibleMatches, directoryTree)
 	if err != nil {
 		err = fmt.Errorf("confirmFoundFiles() failed with error: %w", err)
 		return
 	}
-
-	for _, file := range foundFiles {
-		// try to get an io.reader via api first
-		reader, err := apiFileReader(file)
+	foundFiles = collector.keepHashMatches(ctx, volumeHandler.VolumeLetter, foundFiles, collector.foundFileOpener(volumeHandler))
+	collector.report.addMatches(volumeHandler.VolumeLetter, len(foundFiles))
+	err = collector.sendDirectoryTree(ctx, fileReaders, volumeHandler.directoryExport, directoryTree, foundFiles)
+	volumeHandler.directoryExport = nil
+	if err != nil {
+		return
+	}
+	foundFiles = collector.recoverDeletedFiles(volumeHandler, foundFiles)
+	if collector.planner == nil {
+		err = collector.collectIndexes(ctx, volumeHandler, directoryTree, fileReaders)
 		if err != nil {
-			log.Debugf("Got a raw io.Reader for '%s' with data runs: %+v", file.fullPath, file.dataRuns)
-			// failed to get an API handle, trying to get an io.reader via raw method
-			reader = rawFileReader(volumeHandler, file)
-		} else {
-			log.Debugf("Got an API io.Reader for '%s'.", file.fullPath)
+			return
 		}
+	}
+	foundFiles, numberOfUnchanged := changes.filterFiles(foundFiles)
+	collector.report.addUnchanged(volumeHandler.VolumeLetter, numberOfUnchanged)
+	foundFiles, numberResumed := collector.Options.Resume.filterFiles(foundFiles)
+	collector.report.addResumed(volumeHandler.VolumeLetter, numberResumed)
+	foundFiles = collector.applyLimits(volumeHandler.VolumeLetter, foundFiles, true)
+	foundFiles = collector.budget.planFiles(volumeHandler.VolumeLetter, foundFiles)
+	if collector.planner.planned(volumeHandler.VolumeLetter, foundFiles) {
+		return
+	}
+
+	if collector.Options.Workers > 1 {
+		err = collector.collectInParallel(ctx, volumeHandler, fileReaders, foundFiles)
+		return
+	}
+
+	for _, file := range foundFiles {
+		collector.metadata.add(file.fileMetadata(volumeHandler.VolumeLetter))
+		reader, method, fallback := collector.openFoundFile(volumeHandler, file)
 		fileReader := fileReader{
-			fullPath: file.fullPath,
-			reader:   reader,
+			fullPath:   file.outputPath(),
+			codec:      file.codec,
+			processors: file.processors,
+			method:     method,
+			fallback:   fallback,
+			links:      file.links,
+			times:      file.metadata.times(),
+			reader: collector.instrumentReader(ctx, reader, Progress{
+				Stage:        StageCopy,
+				VolumeLetter: volumeHandler.VolumeLetter,
+				FileName:     file.fullPath,
+				TotalBytes:   file.totalSize(),
+			}),
+		}
+		if collector.dedup != nil {
+			// The whole file has to be read to know whether it's a duplicate before anything is written
+			spooled := collector.spoolUnlessDuplicate(fileReader.reader, fileReader.fullPath, volumeHandler.VolumeLetter)
+			if closer, ok := reader.(io.Closer); ok {
+				closer.Close()
+			}
+			if spooled == nil {
+				continue
+			}
+			fileReader.reader = spooled
+			fileReader.pendingBytes = spooled.inMemory
+		}
+		err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(collector.verifier.track(fileReader, file, volumeHandler.VolumeLetter), volumeHandler.VolumeLetter))
+		if err != nil {
+			return
 		}
-		fileReaders <- fileReader
 	}
-	close(fileReaders)
 	err = nil
-	waitForFileCopying.Wait()
+	return
+}
+
+// openFoundFile picks how to read a found file. Deleted files are always read from their data runs. Loaded hives are exported when ExportHives is set, everything else is
+// read through the API first and then from its data runs if the API can't open it. With APIFallback a loaded hive whose
+// data runs can't be read is exported after all, and fallback says why.
+func (collector *Collector) openFoundFile(volumeHandler *VolumeHandler, file foundFile) (reader io.Reader, method string, fallback string) {
+	var why string
+	defer func() {
+		collector.audit.readDecision(volumeHandler.VolumeLetter, file.fullPath, method, why, fallback)
+	}()
+	policy := collector.Options.readPolicyFor(file)
+	// The API would open whatever file has the path now, and a loaded hive can't be what was deleted
+	if file.deleted {
+		if policy == ReadAPIOnly {
+			why = "a deleted file can only be read raw and its read policy is api_only"
+			return &failedReader{err: errors.New(why)}, readMethodAPI, ""
+		}
+		why = "deleted files are only read raw"
+		return rawFileReader(volumeHandler, file), readMethodRaw, ""
+	}
+	if collector.Options.ExportHives && policy != ReadRawOnly {
+		if hive, ok := loadedHiveForPath(file.fullPath, collector.userProfiles); ok {
+			hiveReader, exportErr := exportHive(volumeHandler.logger(), hive)
+			if exportErr == nil {
+				volumeHandler.logger().Debugf("Exported %s for '%s'.", hive, file.fullPath)
+				why = fmt.Sprintf("it's loaded as %s", hive)
+				return hiveReader, readMethodHiveExport, ""
+			}
+			volumeHandler.logger().Debugf("Failed to export %s for '%s', copying it instead: %v", hive, file.fullPath, exportErr)
+		}
+	}
+
+	why = fmt.Sprintf("the read policy is %s", policy)
+	switch policy {
+	case ReadRawOnly:
+		return rawFileReader(volumeHandler, file), readMethodRaw, ""
+	case ReadRawFirst:
+		return collector.openRawFirst(volumeHandler, file)
+	case ReadAPIOnly:
+		return openAPIOnly(volumeHandler, file)
+	}
+
+	// try to get an io.reader via api first, unless it's a file the api can't open
+	if reason := rawFirstReason(file); reason != "" {
+		volumeHandler.logger().Debugf("Reading '%s' raw since %s.", file.fullPath, reason)
+		why = reason
+		return collector.openRaw(volumeHandler, file)
+	}
+	reader, apiErr := apiFileReader(file)
+	if apiErr != nil {
+		volumeHandler.logger().Debugf("Failed to open '%s' through the API, reading it raw instead: %v", file.fullPath, apiErr)
+		why = fmt.Sprintf("the API couldn't open it: %v", apiErr)
+		return collector.openRaw(volumeHandler, file)
+	}
+	volumeHandler.logger().Debugf("Got an API io.Reader for '%s'.", file.fullPath)
+	return reader, readMethodAPI, ""
+}
+
+// openRaw reads a file from its data runs, checking a loaded hive copied that way is whole when asked to.
+func (collector *Collector) openRaw(volumeHandler *VolumeHandler, file foundFile) (reader io.Reader, method string, fallback string) {
+	volumeHandler.logger().Debugf("Got a raw io.Reader for '%s' with data runs: %+v", file.fullPath, file.dataRuns)
+	if collector.Options.APIFallback {
+		if hive, ok := loadedHiveForPath(file.fullPath, collector.userProfiles); ok {
+			return readHiveWithFallback(volumeHandler.logger(), rawFileReader(volumeHandler, file), hive)
+		}
+	}
+	return rawFileReader(volumeHandler, file), readMethodRaw, ""
+}
+
+// instrumentReader wraps a reader so it stops when the collection is cancelled, waits while it's paused, keeps to the
+// read rate limit, and reports its progress.
+func (collector *Collector) instrumentReader(ctx context.Context, reader io.Reader, update Progress) io.Reader {
+	return newProgressReader(collector.limitReader(ctx, reader), collector.Options.Progress, update)
+}
+
+// limitReader wraps a reader so it stops when the collection is cancelled, waits while it's paused, and keeps to the
+// read rate limit.
+func (collector *Collector) limitReader(ctx context.Context, reader io.Reader) io.Reader {
+	return newThrottledReader(newPausableReader(ctx, newContextReader(ctx, reader), collector.Options.Control), collector.readLimiter)
+}
+
+// sendFileReader hands a file reader to the result writer unless the collection has been cancelled first. Within a
+// collection it waits for the pipeline to have room for the file.
+func (collector *Collector) sendFileReader(ctx context.Context, fileReaders chan fileReader, reader fileReader) (err error) {
+	if collector.pipeline != nil {
+		return collector.pipeline.send(ctx, fileReaders, reader)
+	}
+	select {
+	case fileReaders <- reader:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// sendMetadata serializes a value as JSON and hands it to the result writer as a file of its own.
+func (collector *Collector) sendMetadata(ctx context.Context, fileReaders chan fileReader, fileName string, value interface{}) (err error) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("failed to serialize %s: %w", fileName, err)
+		return
+	}
+	fileReader := fileReader{
+		fullPath: fileName,
+		reader:   bytes.NewReader(data),
+	}
+	err = collector.sendFileReader(ctx, fileReaders, fileReader)
 	return
 }
@@ -4,16 +4,25 @@ package windowscollector
 
 import (
 	"archive/zip"
-	"crypto/md5"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	vbr "github.com/Go-Forensics/VBR-Parser"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCollect(t *testing.T) {
+	defer os.Setenv("SYSTEMDRIVE", os.Getenv("SYSTEMDRIVE"))
+	os.Setenv("SYSTEMDRIVE", "C:")
 	type args struct {
 		exportList   ListOfFilesToExport
 		resultWriter ZipResultWriter
@@ -24,7 +33,7 @@ func TestCollect(t *testing.T) {
 		args          args
 		wantErr       bool
 		zipTestOutput string
-		wantZipHash   string
+		wantEntries   []zipEntry
 	}{
 		{
 			name: "test1",
@@ -49,7 +58,8 @@ func TestCollect(t *testing.T) {
 			},
 			wantErr:       false,
 			zipTestOutput: `test\testdata\collecttestzip.zip`,
-			wantZipHash:   "29f689d96a790b68df7e84c9e04ef741",
+			// The volume letter is written as SYSTEMDRIVE has it
+			wantEntries: []zipEntry{{name: "C/$mft", sha256: dummyNTFSMFT.sha256, modified: dummyNTFSModified}},
 		},
 	}
 	for _, tt := range tests {
@@ -60,21 +70,162 @@ func TestCollect(t *testing.T) {
 				ZipWriter:  zipWriter,
 				FileHandle: fileHandle,
 			}
-			_ = Collect(tt.args.handler, tt.args.exportList, &tt.args.resultWriter)
-			// Get file hash
-			file, _ := os.Open(tt.zipTestOutput)
-			defer file.Close()
-			hash := md5.New()
-			_, _ = io.Copy(hash, file)
-			hashInBytes := hash.Sum(nil)[:]
-			gotZipHash := hex.EncodeToString(hashInBytes)
-			if gotZipHash != tt.wantZipHash {
-				t.Errorf("collect() gotZipHash = %v, want %v", gotZipHash, tt.wantZipHash)
-			}
+			_ = Collect(context.Background(), tt.args.handler, tt.args.exportList, &tt.args.resultWriter, CollectOptions{})
+			checkZipEntries(t, tt.zipTestOutput, tt.wantEntries)
 		})
 	}
 }
 
+// zipEntry is what an entry of a collection's zip should hold.
+type zipEntry struct {
+	name     string
+	sha256   string
+	modified time.Time
+}
+
+// The files of test\testdata\dummyntfs that the tests collect.
+var (
+	dummyNTFSModified = time.Date(2018, 2, 25, 0, 10, 45, 0, time.UTC)
+	dummyNTFSMFT      = zipEntry{name: "c/$mft", sha256: "92544758d367f01c987ac830fea3b5ae466fbcde45cbf6d3b61b7d0a690bfb82", modified: dummyNTFSModified}
+	dummyNTFSMFTMirr  = zipEntry{name: "c//$mftmirr", sha256: "d52878ec8c5b42c78297699dbb6d7e07cb756764120d75ea77296c4cc1680113", modified: dummyNTFSModified}
+)
+
+// checkZipEntries compares the entries of the zip at path with want by their names, contents and modification times,
+// rather than the zip's bytes, which change with anything else in the layout of the output.
+func checkZipEntries(t *testing.T, path string, want []zipEntry) {
+	t.Helper()
+	zipReader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("zip.OpenReader() error = %v", err)
+	}
+	defer zipReader.Close()
+	if len(zipReader.File) != len(want) {
+		t.Fatalf("the zip has %d entries, want %d", len(zipReader.File), len(want))
+	}
+	for index, entry := range zipReader.File {
+		reader, err := entry.Open()
+		if err != nil {
+			t.Fatalf("opening %s failed: %v", entry.Name, err)
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", entry.Name, err)
+		}
+		got := zipEntry{name: entry.Name, sha256: hex.EncodeToString(hash.Sum(nil)), modified: entry.Modified.UTC()}
+		if got.name != want[index].name || got.sha256 != want[index].sha256 || !got.modified.Equal(want[index].modified) {
+			t.Errorf("the zip's entry %d = %+v, want %+v", index, got, want[index])
+		}
+	}
+}
+
+func TestCollect_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exportList := ListOfFilesToExport{
+		0: {
+			FullPath:        `c:\$MFT`,
+			IsFullPathRegex: false,
+			FileName:        `$MFT`,
+			IsFileNameRegex: false,
+		},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	fileHandle, _ := os.Create(`test\testdata\cancelledCollect.zip`)
+	resultWriter := ZipResultWriter{
+		ZipWriter:  zip.NewWriter(fileHandle),
+		FileHandle: fileHandle,
+	}
+	err := Collect(ctx, handler, exportList, &resultWriter, CollectOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Collect() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+// failingResultWriter gives up without writing anything, like a writer whose destination has gone away.
+type failingResultWriter struct{}
+
+func (failingResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	err = errors.New("destination went away")
+	return
+}
+
+func TestCollect_resultWriterFails(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	err := Collect(context.Background(), handler, exportList, failingResultWriter{}, CollectOptions{})
+	if err == nil || !strings.Contains(err.Error(), "destination went away") {
+		t.Errorf("Collect() error = %v, want the result writer's error", err)
+	}
+}
+
+// refusingResultWriter reads every file but hands each one back as failed, like an output that rejects some names.
+type refusingResultWriter struct{}
+
+func (refusingResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	for file := range fileReaders {
+		_, _ = io.Copy(ioutil.Discard, file.Reader())
+		file.Failed(errors.New("the name is not allowed"))
+	}
+	return
+}
+
+func TestCollect_fileWriteFails(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	report, err := CollectWithReport(context.Background(), handler, exportList, refusingResultWriter{}, CollectOptions{})
+	var collectionErrors CollectionErrors
+	var writeError *WriteError
+	if !errors.As(err, &collectionErrors) || !errors.As(err, &writeError) || !strings.Contains(writeError.Error(), "the name is not allowed") {
+		t.Fatalf("CollectWithReport() error = %v, want CollectionErrors with the WriteError", err)
+	}
+	if len(report.Files) != 1 || report.Files[0].Status != "partial" || report.Files[0].Collected {
+		t.Errorf("CollectWithReport() reported %+v, want the $MFT as partial", report.Files)
+	}
+}
+
+// missingVolumeHandler fails to open one volume and hands every other one to dummyHandler.
+type missingVolumeHandler struct {
+	dummyHandler
+	missingVolume string
+}
+
+func (handler missingVolumeHandler) GetHandle(volumeLetter string) (handle *os.File, err error) {
+	if volumeLetter == handler.missingVolume {
+		err = errors.New("no such volume")
+		return
+	}
+	return handler.dummyHandler.GetHandle(volumeLetter)
+}
+
+func TestCollect_continuesPastFailedVolume(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `d:\$MFT`, FileName: `$MFT`},
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	output := new(bytes.Buffer)
+	resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+	handler := missingVolumeHandler{
+		dummyHandler:  dummyHandler{filePath: `test\testdata\dummyntfs`},
+		missingVolume: "d",
+	}
+	report, err := CollectWithReport(context.Background(), handler, exportList, &resultWriter, CollectOptions{})
+	var volumeError *VolumeError
+	if !errors.As(err, &volumeError) || volumeError.Volume != "d" {
+		t.Errorf("Collect() error = %v, want a VolumeError for d", err)
+	}
+	if report.FilesCollected != 1 {
+		t.Errorf("Collect() collected %d files, want the 1 from volume c", report.FilesCollected)
+	}
+}
+
 func Test_getFiles(t *testing.T) {
 	type args struct {
 		volumeHandler        *VolumeHandler
@@ -87,7 +238,7 @@ func Test_getFiles(t *testing.T) {
 		wantErr     bool
 		dummyFile   string
 		testZip     string
-		wantZipHash string
+		wantEntries []zipEntry
 	}{
 		{
 			name: "test1",
@@ -112,7 +263,7 @@ func Test_getFiles(t *testing.T) {
 			dummyFile:   `test\testdata\dummyntfs`,
 			testZip:     `test\testdata\getFilesTest1.zip`,
 			wantErr:     false,
-			wantZipHash: "e37081c5c97884bd419cfadaa281f77a",
+			wantEntries: []zipEntry{dummyNTFSMFT, dummyNTFSMFTMirr},
 		},
 		{
 			name: "test2",
@@ -131,7 +282,7 @@ func Test_getFiles(t *testing.T) {
 			dummyFile:   `test\testdata\dummyntfs`,
 			testZip:     `test\testdata\getFilesTest2.zip`,
 			wantErr:     false,
-			wantZipHash: "04c3f56fb7388624c0119eee3c97cae2",
+			wantEntries: []zipEntry{dummyNTFSMFTMirr},
 		},
 	}
 	for _, tt := range tests {
@@ -157,18 +308,121 @@ func Test_getFiles(t *testing.T) {
 			}
 			defer tt.args.volumeHandler.Handle.Close()
 
-			_ = getFiles(tt.args.volumeHandler, &tt.args.resultWriter, tt.args.listOfSearchKeywords)
-
-			// Get file hash
-			file, _ := os.Open(tt.testZip)
-			defer file.Close()
-			hash := md5.New()
-			_, _ = io.Copy(hash, file)
-			hashInBytes := hash.Sum(nil)[:]
-			gotZipHash := hex.EncodeToString(hashInBytes)
-			if gotZipHash != tt.wantZipHash {
-				t.Errorf("getFiles() gotZipHash = %v, want %v", gotZipHash, tt.wantZipHash)
+			fileReaders := make(chan fileReader, 100)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			go tt.args.resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+			_ = (&Collector{Options: CollectOptions{}}).getFiles(context.Background(), tt.args.volumeHandler, fileReaders, tt.args.listOfSearchKeywords)
+			close(fileReaders)
+			waitForFileCopying.Wait()
+			checkZipEntries(t, tt.testZip, tt.wantEntries)
+		})
+	}
+}
+
+func Test_openFoundFile(t *testing.T) {
+	defer func(original func(path string) (*os.File, error)) { openWithBackupSemantics = original }(openWithBackupSemantics)
+	tests := []struct {
+		name       string
+		fullPath   string
+		openErr    error
+		wantOpened bool
+		wantMethod string
+	}{
+		{name: "opened through the api", fullPath: `c:\users\bob\ntuser.dat`, wantOpened: true, wantMethod: readMethodAPI},
+		{name: "api fails", fullPath: `c:\users\bob\ntuser.dat`, openErr: errors.New("sharing violation"), wantOpened: true, wantMethod: readMethodRaw},
+		{name: "metadata file read raw first", fullPath: `c:\$MFT`, wantMethod: readMethodRaw},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened := false
+			openWithBackupSemantics = func(path string) (file *os.File, err error) {
+				opened = true
+				if tt.openErr != nil {
+					return nil, tt.openErr
+				}
+				return os.Open(`test\testdata\dummyntfs`)
+			}
+			file := foundFile{fullPath: tt.fullPath, resident: true, residentData: []byte("raw")}
+			reader, method, _ := (&Collector{Options: CollectOptions{}}).openFoundFile(&VolumeHandler{}, file)
+			if closer, ok := reader.(io.Closer); ok {
+				closer.Close()
+			}
+			if opened != tt.wantOpened || method != tt.wantMethod {
+				t.Errorf("openFoundFile() opened through the api %v with method %v, want %v and %v", opened, method, tt.wantOpened, tt.wantMethod)
 			}
 		})
 	}
 }
+
+func Test_collectVolumesInParallel(t *testing.T) {
+	searchTerms := listOfSearchTerms{
+		0: searchTerms{
+			fullPathString: `c:\$mft`,
+			fileNameString: "$mft",
+		},
+	}
+	output := new(bytes.Buffer)
+	resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+	fileReaders := make(chan fileReader, 100)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	go resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	collector := &Collector{volumes: handler}
+	err := collector.collectVolumesInParallel(context.Background(), []string{"c", "d"}, fileReaders, searchTerms)
+	close(fileReaders)
+	waitForFileCopying.Wait()
+	if err != nil {
+		t.Fatalf("collectVolumesInParallel() error = %v", err)
+	}
+	if len(searchTerms) != 1 || searchTerms[0].fileNameString != "$mft" {
+		t.Errorf("collectVolumesInParallel() modified the shared search terms: %+v", searchTerms)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	gotNames := make(map[string]bool)
+	for _, file := range zipReader.File {
+		gotNames[file.Name] = true
+	}
+	for _, wantName := range []string{"c/$mft", "d/$mft"} {
+		if !gotNames[wantName] {
+			t.Errorf("collectVolumesInParallel() output is missing %s, got %v", wantName, gotNames)
+		}
+	}
+}
+
+func TestCollector_CollectWithReport(t *testing.T) {
+	logger := &recordingLogger{}
+	collection := NewCollector(CollectOptions{Logger: logger})
+	collection.volumes = missingVolumeHandler{missingVolume: "c"}
+	targets := ListOfFilesToExport{{FullPath: `c:\$MFT`, FileName: `$MFT`}}
+
+	report, err := collection.CollectWithReport(context.Background(), targets, &ZipResultWriter{ZipWriter: zip.NewWriter(new(bytes.Buffer))})
+	var volumeError *VolumeError
+	if !errors.As(err, &volumeError) || volumeError.Volume != "c" {
+		t.Errorf("Collector.CollectWithReport() error = %v, want a VolumeError for c", err)
+	}
+	if len(report.Volumes) != 1 || report.Volumes[0].Error == "" {
+		t.Errorf("Collector.CollectWithReport() report volumes = %+v, want c failed", report.Volumes)
+	}
+	logged := false
+	for _, line := range logger.lines {
+		logged = logged || strings.HasPrefix(line, "error Skipping the rest of volume c")
+	}
+	if !logged {
+		t.Errorf("the collection logged %q, want the failed volume through the Collector's Logger", logger.lines)
+	}
+
+	err = collection.Collect(context.Background(), targets, failingResultWriter{})
+	if err == nil || !strings.Contains(err.Error(), "destination went away") {
+		t.Errorf("Collector.Collect() error = %v, want the result writer's error", err)
+	}
+	if collection.Options.Logger != logger || collection.report != nil {
+		t.Error("Collector.Collect() kept the state of the collection in the Collector")
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const commandsFileName = "commands.json"
+
+// Command is an external program to run during the collection, such as ipconfig /all, whose stdout and stderr are
+// captured into the output under commands/<Name>/.
+type Command struct {
+	Name           string   `json:"name"` // defaults to the program's file name without its extension
+	Program        string   `json:"program"`
+	Args           []string `json:"args"`
+	TimeoutSeconds int      `json:"timeout_seconds"` // the command is killed after this long, zero leaves it to the collection's deadline
+}
+
+// CommandResult is how running a Command went. They are all listed in commands.json.
+type CommandResult struct {
+	Name            string    `json:"name"`
+	Program         string    `json:"program"`
+	Args            []string  `json:"args"`
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	ExitCode        int       `json:"exit_code"` // -1 when the command didn't run or was killed
+	TimedOut        bool      `json:"timed_out"`
+	Stdout          string    `json:"stdout,omitempty"` // where stdout is in the output
+	Stderr          string    `json:"stderr,omitempty"` // where stderr is in the output
+	Error           string    `json:"error,omitempty"`
+}
+
+func (command Command) name() string {
+	if command.Name != "" {
+		return command.Name
+	}
+	base := filepath.Base(strings.Replace(command.Program, `\`, "/", -1))
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// validateCommands checks every command has a program and a name that is unique and can be used as a directory name.
+func validateCommands(commands []Command) (err error) {
+	names := make(map[string]bool)
+	for _, command := range commands {
+		name := command.name()
+		switch {
+		case command.Program == "":
+			err = fmt.Errorf("the command '%s' has no program", command.Name)
+		case !isOutputName(name):
+			err = fmt.Errorf("the command name '%s' can't be used as a directory name", name)
+		case names[strings.ToLower(name)]:
+			err = fmt.Errorf("more than one command is named '%s'", name)
+		}
+		if err != nil {
+			return
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return
+}
+
+// runCommands runs the commands one after the other, writing their output as they finish and then commands.json. A
+// command that fails doesn't stop the collection; what went wrong is in its CommandResult.
+func (collector *Collector) runCommands(ctx context.Context, fileReaders chan fileReader) (err error) {
+	if len(collector.Options.Commands) == 0 {
+		return
+	}
+	results := make([]CommandResult, 0, len(collector.Options.Commands))
+	for _, command := range collector.Options.Commands {
+		result, stdout, stderr := runCommand(ctx, collector.logger(), command)
+		results = append(results, result)
+		if ctx.Err() != nil {
+			stdout.discard()
+			stderr.discard()
+			err = ctx.Err()
+			return
+		}
+		if stdout != nil {
+			err = collector.sendFileReader(ctx, fileReaders, fileReader{fullPath: result.Stdout, reader: stdout, pendingBytes: stdout.inMemory})
+			if err != nil {
+				stdout.discard()
+				stderr.discard()
+				return
+			}
+		}
+		if stderr != nil {
+			err = collector.sendFileReader(ctx, fileReaders, fileReader{fullPath: result.Stderr, reader: stderr, pendingBytes: stderr.inMemory})
+			if err != nil {
+				stderr.discard()
+				return
+			}
+		}
+	}
+	err = collector.sendMetadata(ctx, fileReaders, commandsFileName, results)
+	if err != nil {
+		err = fmt.Errorf("failed to write the command results: %w", err)
+	}
+	return
+}
+
+// runCommand runs a command, spooling its stdout and stderr. They are nil when it couldn't be started.
+func runCommand(ctx context.Context, logger Logger, command Command) (result CommandResult, stdout *spooledFile, stderr *spooledFile) {
+	name := command.name()
+	result = CommandResult{
+		Name:     name,
+		Program:  command.Program,
+		Args:     command.Args,
+		ExitCode: -1,
+	}
+	if command.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(command.TimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+	logger.Infof("Running the command %s.", name)
+	stdout, stderr, err := executeCommand(exec.CommandContext(ctx, command.Program, command.Args...), &result)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && command.TimeoutSeconds > 0 {
+		result.TimedOut = true
+		if err == nil {
+			err = fmt.Errorf("killed after %d seconds", command.TimeoutSeconds)
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+		logger.Warnf("The command %s failed: %v", name, err)
+	}
+	if stdout != nil {
+		result.Stdout = fmt.Sprintf("commands/%s/stdout.txt", name)
+	}
+	if stderr != nil {
+		result.Stderr = fmt.Sprintf("commands/%s/stderr.txt", name)
+	}
+	return
+}
+
+// executeCommand runs the process to completion, filling in the result's timing and exit code.
+func executeCommand(process *exec.Cmd, result *CommandResult) (stdout *spooledFile, stderr *spooledFile, err error) {
+	stdoutPipe, err := process.StdoutPipe()
+	if err != nil {
+		return
+	}
+	stderrPipe, err := process.StderrPipe()
+	if err != nil {
+		return
+	}
+	result.StartTime = time.Now().UTC()
+	err = process.Start()
+	if err != nil {
+		return
+	}
+
+	// Both pipes have to be read to the end before waiting on the process
+	var stderrErr error
+	waitForStderr := sync.WaitGroup{}
+	waitForStderr.Add(1)
+	go func() {
+		defer waitForStderr.Done()
+		stderr, stderrErr = spoolFile(stderrPipe)
+	}()
+	stdout, stdoutErr := spoolFile(stdoutPipe)
+	waitForStderr.Wait()
+	err = process.Wait()
+	result.EndTime = time.Now().UTC()
+	result.DurationSeconds = result.EndTime.Sub(result.StartTime).Seconds()
+	result.ExitCode = process.ProcessState.ExitCode()
+
+	// A non-zero exit code is part of the result rather than a failure
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = nil
+	}
+	if err == nil {
+		err = stdoutErr
+	}
+	if err == nil {
+		err = stderrErr
+	}
+	return
+}
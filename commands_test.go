@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestHelperCommand isn't a real test. The command tests run the test binary again with GOFOR_HELPER_COMMAND set so
+// they have a program to run on any machine.
+func TestHelperCommand(t *testing.T) {
+	switch os.Getenv("GOFOR_HELPER_COMMAND") {
+	case "":
+		return
+	case "output":
+		fmt.Fprint(os.Stdout, "Windows IP Configuration")
+		fmt.Fprint(os.Stderr, "a warning")
+		os.Exit(3)
+	case "hang":
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+func helperCommand(t *testing.T, mode string) Command {
+	if err := os.Setenv("GOFOR_HELPER_COMMAND", mode); err != nil {
+		t.Fatal(err)
+	}
+	return Command{Name: mode, Program: os.Args[0], Args: []string{"-test.run=TestHelperCommand"}}
+}
+
+func Test_runCommands(t *testing.T) {
+	defer os.Unsetenv("GOFOR_HELPER_COMMAND")
+	collector := &Collector{Options: CollectOptions{Commands: []Command{
+		helperCommand(t, "output"),
+		{Name: "missing", Program: "gofor-no-such-program"},
+	}}}
+	fileReaders := make(chan fileReader, 10)
+	if err := collector.runCommands(context.Background(), fileReaders); err != nil {
+		t.Fatalf("runCommands() error = %v", err)
+	}
+	close(fileReaders)
+
+	got := make(map[string]string)
+	for file := range fileReaders {
+		data, _ := ioutil.ReadAll(file.reader)
+		got[file.fullPath] = string(data)
+	}
+	if got["commands/output/stdout.txt"] != "Windows IP Configuration" || got["commands/output/stderr.txt"] != "a warning" {
+		t.Errorf("runCommands() wrote %v, want the command's stdout and stderr", got)
+	}
+	var results []CommandResult
+	if err := json.Unmarshal([]byte(got[commandsFileName]), &results); err != nil {
+		t.Fatalf("failed to parse %s: %v", commandsFileName, err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("%s has %d results, want 2", commandsFileName, len(results))
+	}
+	if results[0].ExitCode != 3 || results[0].Error != "" || results[0].StartTime.IsZero() || results[0].Stdout != "commands/output/stdout.txt" {
+		t.Errorf("the result of a command that ran = %+v, want exit code 3 and where its output is", results[0])
+	}
+	if results[1].ExitCode != -1 || results[1].Error == "" || results[1].Stdout != "" {
+		t.Errorf("the result of a missing program = %+v, want it to have failed without output", results[1])
+	}
+}
+
+func Test_runCommand_timeout(t *testing.T) {
+	defer os.Unsetenv("GOFOR_HELPER_COMMAND")
+	command := helperCommand(t, "hang")
+	command.TimeoutSeconds = 1
+	result, stdout, stderr := runCommand(context.Background(), loggerOrDefault(nil), command)
+	stdout.discard()
+	stderr.discard()
+	if !result.TimedOut || result.ExitCode != -1 {
+		t.Errorf("runCommand() = %+v, want it killed for taking too long", result)
+	}
+}
+
+func Test_validateCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []Command
+		wantErr  bool
+	}{
+		{name: "named after the program", commands: []Command{{Program: `C:\Windows\System32\ipconfig.exe`}, {Program: "netstat"}}},
+		{name: "no program", commands: []Command{{Name: "empty"}}, wantErr: true},
+		{name: "same name", commands: []Command{{Program: "ipconfig"}, {Name: "IPCONFIG", Program: "cmd"}}, wantErr: true},
+		{name: "path in the name", commands: []Command{{Name: `..\escape`, Program: "cmd"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCommands(tt.commands); (err != nil) != tt.wantErr {
+				t.Errorf("validateCommands() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
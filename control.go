@@ -0,0 +1,136 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io"
+	"sync"
+)
+
+// CollectionControl pauses, resumes and lowers the priority of collections while they run, so an operator can back
+// off when a user complains the endpoint is slow without losing the collection's progress. It's safe to use from
+// other goroutines, and can be shared between collections. A nil CollectionControl never pauses.
+type CollectionControl struct {
+	mutex       sync.Mutex
+	resumed     chan struct{} // closed when the collection is resumed, nil while it isn't paused
+	lowPriority bool
+}
+
+// NewCollectionControl returns a CollectionControl that starts out running at normal priority.
+func NewCollectionControl() *CollectionControl {
+	return &CollectionControl{}
+}
+
+// Pause holds every read from disk until Resume is called. Reads already under way finish first.
+func (control *CollectionControl) Pause() {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+	if control.resumed == nil {
+		control.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets reads held by Pause carry on.
+func (control *CollectionControl) Resume() {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+	if control.resumed != nil {
+		close(control.resumed)
+		control.resumed = nil
+	}
+}
+
+// Paused reports whether reads are being held.
+func (control *CollectionControl) Paused() bool {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+	return control.resumed != nil
+}
+
+// SetLowPriority puts the collector's process into background mode, which lowers its CPU, I/O and memory priority so
+// the rest of the system gets the disk first, or takes it back out of it.
+func (control *CollectionControl) SetLowPriority(lowPriority bool) (err error) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+	if control.lowPriority == lowPriority {
+		return
+	}
+	err = setProcessBackgroundMode(lowPriority)
+	if err != nil {
+		err = fmt.Errorf("failed to change the priority of the process: %w", err)
+		return
+	}
+	control.lowPriority = lowPriority
+	return
+}
+
+// LowPriority reports whether the process was put into background mode.
+func (control *CollectionControl) LowPriority() bool {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+	return control.lowPriority
+}
+
+// wait blocks while the collection is paused, returning early with the context's error if it's cancelled.
+func (control *CollectionControl) wait(ctx context.Context) (err error) {
+	if control == nil {
+		return
+	}
+	control.mutex.Lock()
+	resumed := control.resumed
+	control.mutex.Unlock()
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// setProcessBackgroundMode begins or ends background processing mode for the process. It's a variable so tests don't
+// change the priority of the test binary.
+var setProcessBackgroundMode = func(background bool) (err error) {
+	process, err := windows.GetCurrentProcess()
+	if err != nil {
+		return
+	}
+	mode := uint32(windows.PROCESS_MODE_BACKGROUND_END)
+	if background {
+		mode = windows.PROCESS_MODE_BACKGROUND_BEGIN
+	}
+	err = windows.SetPriorityClass(process, mode)
+	return
+}
+
+// pausableReader holds reads from the underlying reader while its control is paused.
+type pausableReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	control *CollectionControl
+}
+
+func newPausableReader(ctx context.Context, reader io.Reader, control *CollectionControl) io.Reader {
+	if control == nil {
+		return reader
+	}
+	return &pausableReader{
+		ctx:     ctx,
+		reader:  reader,
+		control: control,
+	}
+}
+
+func (pausableReader *pausableReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	err = pausableReader.control.wait(pausableReader.ctx)
+	if err != nil {
+		return
+	}
+	numberOfBytesRead, err = pausableReader.reader.Read(byteSliceToPopulate)
+	return
+}
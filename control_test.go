@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectionControl_pause(t *testing.T) {
+	control := NewCollectionControl()
+	reader := newPausableReader(context.Background(), strings.NewReader("data"), control)
+	control.Pause()
+	control.Pause()
+	if !control.Paused() {
+		t.Fatal("Paused() = false after Pause()")
+	}
+
+	read := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		read <- string(data)
+	}()
+	select {
+	case data := <-read:
+		t.Fatalf("read %q while paused", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+	control.Resume()
+	control.Resume()
+	if got := <-read; got != "data" {
+		t.Errorf("read %q after Resume(), want %q", got, "data")
+	}
+	if control.Paused() {
+		t.Error("Paused() = true after Resume()")
+	}
+}
+
+func TestCollectionControl_wait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	control := NewCollectionControl()
+	control.Pause()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := control.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait() error = %v, want context.Canceled once the collection is cancelled", err)
+	}
+
+	var disabled *CollectionControl
+	if err := disabled.wait(ctx); err != nil {
+		t.Errorf("wait() on a nil control error = %v", err)
+	}
+	if newPausableReader(ctx, strings.NewReader(""), nil) == nil {
+		t.Error("newPausableReader() = nil without a control")
+	}
+}
+
+func TestCollectionControl_SetLowPriority(t *testing.T) {
+	defer func(original func(bool) error) { setProcessBackgroundMode = original }(setProcessBackgroundMode)
+	var calls []bool
+	fail := false
+	setProcessBackgroundMode = func(background bool) error {
+		if fail {
+			return errors.New("access denied")
+		}
+		calls = append(calls, background)
+		return nil
+	}
+
+	control := NewCollectionControl()
+	if err := control.SetLowPriority(true); err != nil || !control.LowPriority() {
+		t.Fatalf("SetLowPriority(true) error = %v, LowPriority() = %v", err, control.LowPriority())
+	}
+	// Background mode can't be begun twice, so asking again does nothing
+	_ = control.SetLowPriority(true)
+	fail = true
+	if err := control.SetLowPriority(false); err == nil || !control.LowPriority() {
+		t.Errorf("SetLowPriority(false) error = %v, LowPriority() = %v, want an error and still low priority", err, control.LowPriority())
+	}
+	fail = false
+	if err := control.SetLowPriority(false); err != nil || control.LowPriority() {
+		t.Errorf("SetLowPriority(false) error = %v, LowPriority() = %v", err, control.LowPriority())
+	}
+	if len(calls) != 2 || !calls[0] || calls[1] {
+		t.Errorf("the background mode was set to %v, want [true false]", calls)
+	}
+}
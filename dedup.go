@@ -0,0 +1,110 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const duplicatesFileName = "duplicates.json"
+
+// DuplicateFile is a file left out of the output because a file with the same content was already collected.
+type DuplicateFile struct {
+	Path        string `json:"path"`
+	Volume      string `json:"volume"`
+	DuplicateOf string `json:"duplicate_of"` // the path of the collected file with the same content
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+}
+
+// contentKey identifies a file's content.
+type contentKey struct {
+	sha256 string
+	size   int64
+}
+
+// deduplicator remembers the content of every file collected so far across all volumes. Like the warningCollector it
+// is nil when deduplication wasn't asked for.
+type deduplicator struct {
+	mutex      sync.Mutex
+	collected  map[contentKey]string
+	duplicates []DuplicateFile
+}
+
+func newDeduplicator(enabled bool) *deduplicator {
+	if !enabled {
+		return nil
+	}
+	return &deduplicator{collected: make(map[contentKey]string), duplicates: make([]DuplicateFile, 0)}
+}
+
+// claim returns the path of the file already collected with the same content, or an empty string when the content is
+// new, in which case fullPath becomes its copy.
+func (dedup *deduplicator) claim(key contentKey, fullPath string, volumeLetter string) (original string) {
+	dedup.mutex.Lock()
+	defer dedup.mutex.Unlock()
+	original, found := dedup.collected[key]
+	if !found {
+		dedup.collected[key] = fullPath
+		return ""
+	}
+	dedup.duplicates = append(dedup.duplicates, DuplicateFile{
+		Path:        fullPath,
+		Volume:      volumeLetter,
+		DuplicateOf: original,
+		SHA256:      key.sha256,
+		Size:        key.size,
+	})
+	return
+}
+
+func (dedup *deduplicator) snapshot() []DuplicateFile {
+	dedup.mutex.Lock()
+	defer dedup.mutex.Unlock()
+	return append([]DuplicateFile(nil), dedup.duplicates...)
+}
+
+// spoolUnlessDuplicate spools a file, hashing it on the way when deduplicating. It returns nil when the file couldn't be
+// read or has the same content as a file already collected, after putting that in the report.
+func (collector *Collector) spoolUnlessDuplicate(reader io.Reader, fullPath string, volumeLetter string) (spooled *spooledFile) {
+	hash := sha256.New()
+	size := &countingWriter{writer: ioutil.Discard}
+	if collector.dedup != nil {
+		reader = io.TeeReader(reader, io.MultiWriter(hash, size))
+	}
+	spooled, err := spoolFile(reader)
+	if err != nil {
+		collector.logger().Debugf("Failed to collect '%s' due to %v", fullPath, err)
+		collector.report.fileFailed(fullPath, volumeLetter, err)
+		return nil
+	}
+	if collector.dedup == nil {
+		return
+	}
+	key := contentKey{sha256: hex.EncodeToString(hash.Sum(nil)), size: size.count}
+	if original := collector.dedup.claim(key, fullPath, volumeLetter); original != "" {
+		collector.logger().Debugf("Leaving out '%s', it has the same content as '%s'.", fullPath, original)
+		spooled.discard()
+		collector.report.fileDuplicate(fullPath, volumeLetter, original)
+		return nil
+	}
+	return
+}
+
+// sendDuplicates writes the list of files that were left out as duplicates into the output.
+func (collector *Collector) sendDuplicates(ctx context.Context, fileReaders chan fileReader) (err error) {
+	if collector.dedup == nil {
+		return
+	}
+	err = collector.sendMetadata(ctx, fileReaders, duplicatesFileName, collector.dedup.snapshot())
+	if err != nil {
+		err = fmt.Errorf("failed to write the duplicates: %w", err)
+	}
+	return
+}
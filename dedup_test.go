@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func Test_spoolUnlessDuplicate(t *testing.T) {
+	collector := &Collector{report: newReportBuilder(), dedup: newDeduplicator(true)}
+	files := []struct {
+		path    string
+		volume  string
+		content string
+		want    bool
+	}{
+		{path: `c:\windows\system32\kernel32.dll`, volume: "c", content: "MZ kernel32", want: true},
+		{path: `d:\windows\system32\kernel32.dll`, volume: "d", content: "MZ kernel32", want: false},
+		{path: `d:\windows\system32\ntdll.dll`, volume: "d", content: "MZ ntdll", want: true},
+		{path: `d:\windows\system32\kernel32.dll.bak`, volume: "d", content: "MZ kernel32 ", want: true},
+	}
+	for _, file := range files {
+		spooled := collector.spoolUnlessDuplicate(bytes.NewReader([]byte(file.content)), file.path, file.volume)
+		if (spooled != nil) != file.want {
+			t.Fatalf("spoolUnlessDuplicate(%s) = %v, want a copy %v", file.path, spooled, file.want)
+		}
+		if spooled == nil {
+			continue
+		}
+		if data, _ := ioutil.ReadAll(spooled); string(data) != file.content {
+			t.Errorf("spoolUnlessDuplicate(%s) read back %q, want %q", file.path, data, file.content)
+		}
+	}
+
+	want := []DuplicateFile{{
+		Path:        `d:\windows\system32\kernel32.dll`,
+		Volume:      "d",
+		DuplicateOf: `c:\windows\system32\kernel32.dll`,
+		SHA256:      "52d705884e1c385ef3f441f76db9b5c73c4e09c07eff063618e5668b1677e957",
+		Size:        11,
+	}}
+	got := collector.dedup.snapshot()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deduplicator.snapshot() = %+v, want %+v", got, want)
+	}
+	report := collector.report.snapshot()
+	if len(report.Files) != 1 || report.Files[0].Status != "duplicate" || report.Files[0].DuplicateOf != `c:\windows\system32\kernel32.dll` {
+		t.Errorf("snapshot() files = %+v, want the duplicate kernel32.dll", report.Files)
+	}
+
+	collector.dedup.claim(contentKey{sha256: want[0].SHA256, size: 11}, `e:\kernel32.dll`, "e")
+	if duplicates := collector.dedup.snapshot(); len(duplicates) != 2 || duplicates[1].DuplicateOf != `c:\windows\system32\kernel32.dll` {
+		t.Errorf("claim() of the same hash = %+v, want a second duplicate of the first copy", duplicates)
+	}
+}
+
+func Test_spoolUnlessDuplicate_failures(t *testing.T) {
+	// Without deduplication a file is only spooled
+	collector := &Collector{report: newReportBuilder()}
+	for i := 0; i < 2; i++ {
+		if spooled := collector.spoolUnlessDuplicate(bytes.NewReader([]byte("same")), `c:\same`, "c"); spooled == nil {
+			t.Fatal("spoolUnlessDuplicate() without deduplication left out a file")
+		}
+	}
+
+	collector.dedup = newDeduplicator(true)
+	if spooled := collector.spoolUnlessDuplicate(failingReader{}, `c:\broken`, "c"); spooled != nil {
+		t.Error("spoolUnlessDuplicate() of an unreadable file should return nil")
+	}
+	report := collector.report.snapshot()
+	if len(report.Files) != 1 || report.Files[0].Status != "failed" {
+		t.Errorf("snapshot() files = %+v, want the unreadable file failed", report.Files)
+	}
+	if duplicates := collector.dedup.snapshot(); len(duplicates) != 0 {
+		t.Errorf("snapshot() = %+v, an unreadable file isn't a duplicate", duplicates)
+	}
+}
+
+// failingReader fails every read.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("the device is not ready")
+}
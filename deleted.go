@@ -0,0 +1,203 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+const (
+	deletedDirectory     = "_deleted"
+	recoveredFileName    = deletedDirectory + "/recovered.json"
+	bitmapRecordNumber   = 6
+	confidenceHigh       = "high"
+	confidenceLow        = "low"
+	confidenceUnknown    = "unknown"
+	confidenceNone       = "none"
+	residentDeletedNote  = "its data was kept in its MFT record"
+	unreadableBitmapNote = "the $Bitmap couldn't be read to check whether its clusters are in use again: %v"
+)
+
+// DeletedFile is a file matched through a deleted MFT record, and how likely the data recovered for it is to be what it
+// held before it was deleted. A cluster that is in use again may have been overwritten by another file.
+type DeletedFile struct {
+	Path           string `json:"path"`   // where the file was before it was deleted
+	Output         string `json:"output"` // where the recovered data is in the output, empty when it wasn't recovered
+	Volume         string `json:"volume"`
+	RecordNumber   uint32 `json:"record_number"`
+	Confidence     string `json:"confidence"` // high, low, unknown or none
+	Note           string `json:"note"`
+	Clusters       int64  `json:"clusters"`
+	ClustersReused int64  `json:"clusters_reused"`
+}
+
+// deletedFileRecovery lists the deleted files matched across all volumes. Like the warningCollector it is nil when
+// RecoverDeleted isn't set.
+type deletedFileRecovery struct {
+	mutex sync.Mutex
+	files []DeletedFile
+}
+
+func newDeletedFileRecovery(enabled bool) *deletedFileRecovery {
+	if !enabled {
+		return nil
+	}
+	return &deletedFileRecovery{files: make([]DeletedFile, 0)}
+}
+
+func (recovery *deletedFileRecovery) add(file DeletedFile) {
+	recovery.mutex.Lock()
+	defer recovery.mutex.Unlock()
+	recovery.files = append(recovery.files, file)
+}
+
+func (recovery *deletedFileRecovery) snapshot() []DeletedFile {
+	recovery.mutex.Lock()
+	defer recovery.mutex.Unlock()
+	return append([]DeletedFile(nil), recovery.files...)
+}
+
+// deletedOutputPath is where a deleted file's data goes in the output, under _deleted, e.g. c:\temp\file becomes
+// _deleted\c\temp\file.
+func deletedOutputPath(fullPath string) string {
+	return deletedDirectory + `\` + strings.Replace(fullPath, ":", "", 1)
+}
+
+// outputPath is the path a found file is written to the output under.
+func (file foundFile) outputPath() string {
+	if file.deleted {
+		return deletedOutputPath(file.fullPath)
+	}
+	return file.fullPath
+}
+
+// clusterBitmap is a volume's $Bitmap, with a bit for each cluster that is set while the cluster is in use.
+type clusterBitmap []byte
+
+// allocated reports whether a cluster is in use. Clusters past the end of the bitmap count as in use.
+func (bitmap clusterBitmap) allocated(cluster int64) bool {
+	if cluster < 0 || cluster/8 >= int64(len(bitmap)) {
+		return true
+	}
+	return bitmap[cluster/8]&(1<<uint(cluster%8)) != 0
+}
+
+// countReused counts the clusters of a file's data runs and how many of them are in use again. Sparse runs have no
+// clusters.
+func (bitmap clusterBitmap) countReused(dataRuns mft.DataRuns, bytesPerCluster int64) (clusters int64, reused int64) {
+	for _, dataRun := range dataRuns {
+		if dataRun.AbsoluteOffset == 0 || bytesPerCluster == 0 {
+			continue
+		}
+		first := dataRun.AbsoluteOffset / bytesPerCluster
+		count := (dataRun.Length + bytesPerCluster - 1) / bytesPerCluster
+		for cluster := first; cluster < first+count; cluster++ {
+			clusters++
+			if bitmap.allocated(cluster) {
+				reused++
+			}
+		}
+	}
+	return
+}
+
+// readClusterBitmap reads the $Bitmap of a volume. Its record is in the first extent of the MFT along with the other
+// metadata files. It's a variable so tests don't need a volume.
+var readClusterBitmap = func(volumeHandler *VolumeHandler) (bitmap clusterBitmap, err error) {
+	_, err = volumeHandler.Handle.Seek(volumeHandler.Vbr.MftByteOffset+bitmapRecordNumber*volumeHandler.Vbr.MftRecordSize, 0)
+	if err != nil {
+		err = fmt.Errorf("failed to seek to the $Bitmap's mft record: %w", err)
+		return
+	}
+	buffer := make([]byte, volumeHandler.Vbr.MftRecordSize)
+	_, err = volumeHandler.Handle.Read(buffer)
+	if err != nil {
+		err = fmt.Errorf("failed to read the $Bitmap's mft record: %w", err)
+		return
+	}
+	record, err := mft.RawMasterFileTableRecord(buffer).Parse(volumeHandler.Vbr.BytesPerCluster)
+	if err != nil {
+		err = fmt.Errorf("failed to parse the $Bitmap's mft record: %w", err)
+		return
+	}
+	bitmap, err = ioutil.ReadAll(rawFileReader(volumeHandler, foundFile{
+		dataRuns: record.DataAttribute.NonResidentDataAttribute.DataRuns,
+		fullPath: fmt.Sprintf("%s:\\$bitmap", volumeHandler.VolumeLetter),
+	}))
+	if err != nil {
+		err = fmt.Errorf("failed to read the $Bitmap: %w", err)
+	}
+	return
+}
+
+// assessDeletedFile works out how much of a deleted file's data is still there going by how many of its clusters are
+// in use again. A file whose every cluster is in use again isn't recovered.
+func assessDeletedFile(file foundFile, volumeLetter string, bitmap clusterBitmap, bitmapErr error, bytesPerCluster int64) (deletedFile DeletedFile, recover bool) {
+	deletedFile = DeletedFile{
+		Path:         file.fullPath,
+		Output:       file.outputPath(),
+		Volume:       volumeLetter,
+		RecordNumber: file.metadata.recordNumber,
+	}
+	recover = true
+	if file.resident {
+		deletedFile.Confidence, deletedFile.Note = confidenceHigh, residentDeletedNote
+		return
+	}
+	if bitmapErr != nil {
+		deletedFile.Confidence, deletedFile.Note = confidenceUnknown, fmt.Sprintf(unreadableBitmapNote, bitmapErr)
+		return
+	}
+	deletedFile.Clusters, deletedFile.ClustersReused = bitmap.countReused(file.dataRuns, bytesPerCluster)
+	switch {
+	case deletedFile.ClustersReused == 0:
+		deletedFile.Confidence, deletedFile.Note = confidenceHigh, "none of its clusters are in use again"
+	case deletedFile.ClustersReused < deletedFile.Clusters:
+		deletedFile.Confidence = confidenceLow
+		deletedFile.Note = fmt.Sprintf("%d of its %d clusters are in use again and may hold another file's data", deletedFile.ClustersReused, deletedFile.Clusters)
+	default:
+		deletedFile.Confidence, deletedFile.Note, deletedFile.Output = confidenceNone, "every one of its clusters is in use again", ""
+		recover = false
+	}
+	return
+}
+
+// recoverDeletedFiles assesses the files matched through deleted MFT records, lists them for recovered.json and
+// returns the found files without the deleted ones that can't be recovered. The $Bitmap is only read when there is a
+// deleted file with data runs.
+func (collector *Collector) recoverDeletedFiles(volumeHandler *VolumeHandler, files foundFiles) (recoverable foundFiles) {
+	if collector.deleted == nil {
+		return files
+	}
+	recoverable = make(foundFiles, 0, len(files))
+	var bitmap clusterBitmap
+	var bitmapErr error
+	bitmapRead := false
+	for _, file := range files {
+		if !file.deleted {
+			recoverable = append(recoverable, file)
+			continue
+		}
+		if !file.resident && !bitmapRead {
+			bitmap, bitmapErr = readClusterBitmap(volumeHandler)
+			bitmapRead = true
+			if bitmapErr != nil {
+				volumeHandler.logger().Warnf("Failed to read the $Bitmap of volume %s: %v", volumeHandler.VolumeLetter, bitmapErr)
+			}
+		}
+		deletedFile, recover := assessDeletedFile(file, volumeHandler.VolumeLetter, bitmap, bitmapErr, volumeHandler.Vbr.BytesPerCluster)
+		collector.deleted.add(deletedFile)
+		if !recover {
+			volumeHandler.logger().Debugf("Not recovering the deleted file '%s', %s.", file.fullPath, deletedFile.Note)
+			fileMetadata := file.fileMetadata(volumeHandler.VolumeLetter)
+			collector.report.fileSkipped(file.outputPath(), volumeHandler.VolumeLetter, file.totalSize(), &fileMetadata, deletedFile.Note)
+			continue
+		}
+		recoverable = append(recoverable, file)
+	}
+	return
+}
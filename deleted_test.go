@@ -0,0 +1,135 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"reflect"
+	"testing"
+)
+
+func Test_clusterBitmap_countReused(t *testing.T) {
+	// Clusters 0-7 and 9 are in use
+	bitmap := clusterBitmap{0xff, 0x02}
+	tests := []struct {
+		name       string
+		dataRuns   mft.DataRuns
+		wantTotal  int64
+		wantReused int64
+	}{
+		{name: "free", dataRuns: mft.DataRuns{0: {AbsoluteOffset: 10 * 4096, Length: 2 * 4096}}, wantTotal: 2},
+		{name: "partly reused", dataRuns: mft.DataRuns{0: {AbsoluteOffset: 8 * 4096, Length: 3 * 4096}}, wantTotal: 3, wantReused: 1},
+		{name: "partial cluster", dataRuns: mft.DataRuns{0: {AbsoluteOffset: 6 * 4096, Length: 4096 + 1}}, wantTotal: 2, wantReused: 2},
+		{name: "sparse", dataRuns: mft.DataRuns{0: {AbsoluteOffset: 0, Length: 4096}, 1: {AbsoluteOffset: 12 * 4096, Length: 4096}}, wantTotal: 1},
+		{name: "past the end", dataRuns: mft.DataRuns{0: {AbsoluteOffset: 15 * 4096, Length: 2 * 4096}}, wantTotal: 2, wantReused: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, reused := bitmap.countReused(tt.dataRuns, 4096)
+			if total != tt.wantTotal || reused != tt.wantReused {
+				t.Errorf("countReused() = %d, %d, want %d, %d", total, reused, tt.wantTotal, tt.wantReused)
+			}
+		})
+	}
+}
+
+func Test_assessDeletedFile(t *testing.T) {
+	bitmap := clusterBitmap{0x0f}
+	tests := []struct {
+		name           string
+		file           foundFile
+		bitmapErr      error
+		wantConfidence string
+		wantOutput     string
+		wantRecover    bool
+	}{
+		{
+			name:           "resident",
+			file:           foundFile{fullPath: `c:\temp\a.ps1`, deleted: true, resident: true, residentData: []byte("iex")},
+			wantConfidence: confidenceHigh,
+			wantOutput:     `_deleted\c\temp\a.ps1`,
+			wantRecover:    true,
+		},
+		{
+			name:           "free clusters",
+			file:           foundFile{fullPath: `c:\temp\b.exe`, deleted: true, dataRuns: mft.DataRuns{0: {AbsoluteOffset: 4 * 512, Length: 1024}}},
+			wantConfidence: confidenceHigh,
+			wantOutput:     `_deleted\c\temp\b.exe`,
+			wantRecover:    true,
+		},
+		{
+			name:           "some clusters reused",
+			file:           foundFile{fullPath: `c:\temp\c.exe`, deleted: true, dataRuns: mft.DataRuns{0: {AbsoluteOffset: 3 * 512, Length: 1024}}},
+			wantConfidence: confidenceLow,
+			wantOutput:     `_deleted\c\temp\c.exe`,
+			wantRecover:    true,
+		},
+		{
+			name:           "every cluster reused",
+			file:           foundFile{fullPath: `c:\temp\d.exe`, deleted: true, dataRuns: mft.DataRuns{0: {AbsoluteOffset: 512, Length: 1024}}},
+			wantConfidence: confidenceNone,
+			wantRecover:    false,
+		},
+		{
+			name:           "unreadable bitmap",
+			file:           foundFile{fullPath: `c:\temp\e.exe`, deleted: true, dataRuns: mft.DataRuns{0: {AbsoluteOffset: 512, Length: 1024}}},
+			bitmapErr:      errors.New("access denied"),
+			wantConfidence: confidenceUnknown,
+			wantOutput:     `_deleted\c\temp\e.exe`,
+			wantRecover:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deletedFile, recover := assessDeletedFile(tt.file, "c", bitmap, tt.bitmapErr, 512)
+			if recover != tt.wantRecover || deletedFile.Confidence != tt.wantConfidence || deletedFile.Output != tt.wantOutput {
+				t.Errorf("assessDeletedFile() = %+v, %v, want confidence %s, output %q and recover %v", deletedFile, recover, tt.wantConfidence, tt.wantOutput, tt.wantRecover)
+			}
+			if deletedFile.Path != tt.file.fullPath || deletedFile.Note == "" {
+				t.Errorf("assessDeletedFile() = %+v, want the original path and a note", deletedFile)
+			}
+		})
+	}
+}
+
+func Test_recoverDeletedFiles(t *testing.T) {
+	original := readClusterBitmap
+	defer func() { readClusterBitmap = original }()
+	bitmapReads := 0
+	readClusterBitmap = func(volumeHandler *VolumeHandler) (clusterBitmap, error) {
+		bitmapReads++
+		return clusterBitmap{0x01}, nil
+	}
+
+	volumeHandler := &VolumeHandler{VolumeLetter: "c"}
+	volumeHandler.Vbr.BytesPerCluster = 4096
+	files := foundFiles{
+		{fullPath: `c:\windows\system32\config\sam`},
+		{fullPath: `c:\temp\free.exe`, deleted: true, dataRuns: mft.DataRuns{0: {AbsoluteOffset: 4 * 4096, Length: 4096}}},
+		{fullPath: `c:\temp\reused.exe`, deleted: true, dataRuns: mft.DataRuns{0: {AbsoluteOffset: 4096 / 2, Length: 10}}},
+	}
+
+	// Without RecoverDeleted there aren't any deleted files to look at
+	collector := &Collector{report: newReportBuilder()}
+	if got := collector.recoverDeletedFiles(volumeHandler, files[:1]); !reflect.DeepEqual(got, files[:1]) {
+		t.Errorf("recoverDeletedFiles() = %+v, want the files unchanged", got)
+	}
+
+	collector.deleted = newDeletedFileRecovery(true)
+	got := collector.recoverDeletedFiles(volumeHandler, files)
+	if !reflect.DeepEqual(got, files[:2]) {
+		t.Errorf("recoverDeletedFiles() = %+v, want the live file and the recoverable deleted one", got)
+	}
+	if bitmapReads != 1 {
+		t.Errorf("recoverDeletedFiles() read the $Bitmap %d times, want once", bitmapReads)
+	}
+	recovered := collector.deleted.snapshot()
+	if len(recovered) != 2 || recovered[0].Confidence != confidenceHigh || recovered[1].Confidence != confidenceNone {
+		t.Errorf("snapshot() = %+v, want free.exe with high confidence and reused.exe with none", recovered)
+	}
+	report := collector.report.snapshot()
+	if len(report.Files) != 1 || report.Files[0].Path != `_deleted\c\temp\reused.exe` || report.Files[0].Status != "skipped" {
+		t.Errorf("snapshot() files = %+v, want reused.exe skipped", report.Files)
+	}
+}
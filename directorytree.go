@@ -0,0 +1,147 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DirectoryTreeScope is which directories of the tree resolved from each volume's MFT are written into the output.
+type DirectoryTreeScope string
+
+// The directory tree scopes.
+const (
+	DirectoryTreeMatched DirectoryTreeScope = "matched" // the directories the files matched are in, and the directories above them
+	DirectoryTreeAll     DirectoryTreeScope = "all"     // every directory in the MFT
+)
+
+const directoryTreeDirectory = "directory_tree"
+
+// validate checks a directory tree scope is one of the directory tree scopes. Empty leaves the directory tree out.
+func (scope DirectoryTreeScope) validate() (err error) {
+	switch scope {
+	case "", DirectoryTreeMatched, DirectoryTreeAll:
+		return
+	}
+	err = fmt.Errorf("unknown directory tree scope '%s'", scope)
+	return
+}
+
+// directoryTreeExport gathers the directory records during the MFT walk so the directory tree can be written with their
+// record numbers and timestamps once it's resolved. Like the mftTimeline its methods do nothing when it's nil.
+type directoryTreeExport struct {
+	volumeLetter string
+	scope        DirectoryTreeScope
+	records      []timelineRecord
+}
+
+func newDirectoryTreeExport(volumeLetter string, scope DirectoryTreeScope) *directoryTreeExport {
+	if scope == "" {
+		return nil
+	}
+	return &directoryTreeExport{volumeLetter: volumeLetter, scope: scope}
+}
+
+// outputPath is where the volume's directory tree goes in the output.
+func (export *directoryTreeExport) outputPath() string {
+	return fmt.Sprintf(`%s\%s.csv`, directoryTreeDirectory, export.volumeLetter)
+}
+
+// addDirectory adds a directory record.
+func (export *directoryTreeExport) addDirectory(buffer mft.RawMasterFileTableRecord, bytesPerCluster int64) {
+	if export == nil {
+		return
+	}
+	if record, found := directoryTimelineRecord(buffer, bytesPerCluster); found {
+		export.records = append(export.records, record)
+	}
+}
+
+// matchedDirectories is the lowercased paths of the directories the files are in and every directory above them.
+func matchedDirectories(files foundFiles) (directories map[string]bool) {
+	directories = make(map[string]bool)
+	for _, file := range files {
+		for _, path := range append([]string{file.fullPath}, file.links...) {
+			path = strings.ToLower(path)
+			for end := strings.LastIndex(path, `\`); end != -1; end = strings.LastIndex(path, `\`) {
+				path = path[:end]
+				if !strings.Contains(path, `\`) {
+					// The volume's root is in the directory tree with its backslash, e.g. c:\
+					path += `\`
+					directories[path] = true
+					break
+				}
+				directories[path] = true
+			}
+		}
+	}
+	return
+}
+
+// write writes the directories in the scope as a CSV sorted by path, with their $STANDARD_INFORMATION and $FILE_NAME
+// timestamps.
+func (export *directoryTreeExport) write(writer io.Writer, directoryTree mft.DirectoryTree, files foundFiles) (err error) {
+	var matched map[string]bool
+	if export.scope == DirectoryTreeMatched {
+		matched = matchedDirectories(files)
+	}
+	type row struct {
+		path   string
+		record timelineRecord
+	}
+	var rows []row
+	for _, record := range export.records {
+		path, ok := directoryTree[record.recordNumber]
+		if !ok || (matched != nil && !matched[strings.ToLower(path)]) {
+			continue
+		}
+		rows = append(rows, row{path: path, record: record})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return strings.ToLower(rows[i].path) < strings.ToLower(rows[j].path)
+	})
+
+	buffered := bufio.NewWriter(writer)
+	csvWriter := csv.NewWriter(buffered)
+	_ = csvWriter.Write([]string{"path", "record_number", "parent_record_number", "si_created", "si_modified", "si_accessed", "si_changed", "fn_created", "fn_modified", "fn_accessed", "fn_changed"})
+	for _, row := range rows {
+		line := []string{lowerVolume(row.path), strconv.FormatUint(uint64(row.record.recordNumber), 10), strconv.FormatUint(uint64(row.record.parent), 10)}
+		for _, unixNanos := range append(row.record.siTimes[:], row.record.fnTimes[:]...) {
+			line = append(line, directoryTreeTime(unixNanos))
+		}
+		_ = csvWriter.Write(line)
+	}
+	csvWriter.Flush()
+	if err = csvWriter.Error(); err != nil {
+		return
+	}
+	err = buffered.Flush()
+	return
+}
+
+func directoryTreeTime(unixNanos int64) string {
+	if unixNanos == 0 {
+		return ""
+	}
+	return time.Unix(0, unixNanos).UTC().Format(time.RFC3339Nano)
+}
+
+// sendDirectoryTree hands the volume's directory tree to the result writer once the files it matched are known.
+func (collector *Collector) sendDirectoryTree(ctx context.Context, fileReaders chan fileReader, export *directoryTreeExport, directoryTree mft.DirectoryTree, files foundFiles) (err error) {
+	if export == nil {
+		return
+	}
+	err = collector.sendGenerated(ctx, fileReaders, export.outputPath(), export.volumeLetter, func(writer io.Writer) error {
+		return export.write(writer, directoryTree, files)
+	})
+	return
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirectoryTreeScope_validate(t *testing.T) {
+	tests := []struct {
+		scope   DirectoryTreeScope
+		wantErr bool
+	}{
+		{scope: ""},
+		{scope: DirectoryTreeMatched},
+		{scope: DirectoryTreeAll},
+		{scope: "some", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.scope), func(t *testing.T) {
+			if err := tt.scope.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDirectoryTreeExport_write(t *testing.T) {
+	created := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	modified := created.Add(time.Hour)
+	directoryTree := mft.DirectoryTree{5: `C:\`, 40: `C:\Windows`, 41: `C:\Windows\System32`, 42: `C:\Users`, 43: `C:\Users\bob`}
+	records := []timelineRecord{
+		{recordNumber: 43, parent: 42, name: "bob", flags: timelineDirectoryFlag},
+		{recordNumber: 42, parent: 5, name: "Users", flags: timelineDirectoryFlag},
+		{recordNumber: 41, parent: 40, name: "System32", flags: timelineDirectoryFlag},
+		{recordNumber: 40, parent: 5, name: "Windows", flags: timelineDirectoryFlag, siTimes: timelineTimes(created, modified, modified, modified), fnTimes: timelineTimes(created, created, created, created)},
+		{recordNumber: 5, parent: 5, name: ".", flags: timelineDirectoryFlag},
+		{recordNumber: 99, parent: 5, name: "unresolved", flags: timelineDirectoryFlag},
+	}
+	files := foundFiles{{fullPath: `c:\windows\system32\config\sam`}, {fullPath: `c:\windows\notepad.exe`}}
+	header := "path,record_number,parent_record_number,si_created,si_modified,si_accessed,si_changed,fn_created,fn_modified,fn_accessed,fn_changed\n"
+	windows := "c:\\Windows,40,5,2020-03-01T12:00:00Z,2020-03-01T13:00:00Z,2020-03-01T13:00:00Z,2020-03-01T13:00:00Z,2020-03-01T12:00:00Z,2020-03-01T12:00:00Z,2020-03-01T12:00:00Z,2020-03-01T12:00:00Z\n"
+	tests := []struct {
+		name  string
+		scope DirectoryTreeScope
+		want  string
+	}{
+		{
+			name:  "matched",
+			scope: DirectoryTreeMatched,
+			want:  header + "c:\\,5,5,,,,,,,,\n" + windows + "c:\\Windows\\System32,41,40,,,,,,,,\n",
+		},
+		{
+			name:  "all",
+			scope: DirectoryTreeAll,
+			want:  header + "c:\\,5,5,,,,,,,,\nc:\\Users,42,5,,,,,,,,\nc:\\Users\\bob,43,42,,,,,,,,\n" + windows + "c:\\Windows\\System32,41,40,,,,,,,,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := newDirectoryTreeExport("c", tt.scope)
+			export.records = records
+			output := new(bytes.Buffer)
+			if err := export.write(output, directoryTree, files); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+			if output.String() != tt.want {
+				t.Errorf("write() wrote %q, want %q", output.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCollect_directoryTree(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	tests := []struct {
+		name     string
+		scope    DirectoryTreeScope
+		wantLine string
+	}{
+		{name: "none"},
+		{name: "all", scope: DirectoryTreeAll, wantLine: "\nc:\\,5,5,"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := new(bytes.Buffer)
+			resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+			_, err := CollectWithReport(context.Background(), handler, exportList, &resultWriter, CollectOptions{DirectoryTree: tt.scope})
+			if err != nil {
+				t.Fatalf("CollectWithReport() error = %v", err)
+			}
+			reader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var directories []byte
+			for _, file := range reader.File {
+				if !strings.HasPrefix(file.Name, "directory_tree/") {
+					continue
+				}
+				if file.Name != "directory_tree/c.csv" {
+					t.Fatalf("the zip holds %s, want directory_tree/c.csv", file.Name)
+				}
+				fileReader, err := file.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				directories, _ = ioutil.ReadAll(fileReader)
+				fileReader.Close()
+			}
+			if !strings.Contains(string(directories), tt.wantLine) {
+				t.Errorf("the directory tree %q doesn't have a line with %q", directories, tt.wantLine)
+			}
+		})
+	}
+}
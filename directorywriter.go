@@ -0,0 +1,238 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DirectoryResultWriter writes the collection as loose files under Directory, each at its original path with the drive
+// letter as the top directory, e.g. c/windows/system32/config/sam, for parsers that want raw files rather than an
+// archive. Like the TarResultWriter, every file is hashed as it's written and the directory ends up with a
+// gofor-index.json listing them with their SHA-256, signed when SigningKey is set, so VerifyDirectory can check the
+// files later. The index is in the same format as the tar index, with every Offset zero. Files that couldn't be read
+// are left out and listed in the index with their error.
+type DirectoryResultWriter struct {
+	Directory  string
+	SigningKey ed25519.PrivateKey
+	Logger     Logger // see ZipResultWriter
+
+	index      TarIndex
+	entryNames map[string]bool
+}
+
+// ResultWriter will write found files into the directory, followed by the index. If ctx is cancelled the index is still
+// written, marked incomplete.
+func (directoryResultWriter *DirectoryResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	logger := loggerOrDefault(directoryResultWriter.Logger)
+	directoryResultWriter.index = TarIndex{Entries: make([]TarIndexEntry, 0), Tool: currentTool()}
+	directoryResultWriter.entryNames = map[string]bool{
+		tarIndexFileName:     true,
+		tarSignatureFileName: true,
+	}
+	// The os package only gets past MAX_PATH with absolute paths, which deep profiles under a relative directory need
+	if absolute, absErr := filepath.Abs(directoryResultWriter.Directory); absErr == nil {
+		directoryResultWriter.Directory = absolute
+	}
+	err = os.MkdirAll(directoryResultWriter.Directory, 0755)
+	if err != nil {
+		err = fmt.Errorf("resultWriter failed to create the output directory %s: %w", directoryResultWriter.Directory, err)
+		return
+	}
+
+	for {
+		var fileReader fileReader
+		var openChannel bool
+		select {
+		case fileReader, openChannel = <-fileReaders:
+		case <-ctx.Done():
+			logger.Debugf("Collection was cancelled, writing the index to the output directory: %v", ctx.Err())
+			_ = directoryResultWriter.finish(false)
+			err = ctx.Err()
+			return
+		}
+		if !openChannel {
+			break
+		}
+		err = directoryResultWriter.writeFile(logger, fileReader)
+		if err != nil {
+			fileReader.Failed(err)
+			err = fmt.Errorf("resultWriter failed to add a file to the output directory: %w", err)
+			return
+		}
+		fileReader.Written()
+	}
+	err = directoryResultWriter.finish(true)
+	return
+}
+
+// writeFile copies a file into the directory, hashing it on the way. A file that fails part way through is removed
+// again so only complete files are left in the directory.
+func (directoryResultWriter *DirectoryResultWriter) writeFile(logger Logger, fileReader fileReader) (err error) {
+	entry := TarIndexEntry{
+		Name:  uniqueEntryName(directoryResultWriter.entryNames, treeEntryName(fileReader.fullPath)),
+		Links: fileReader.links,
+	}
+	outputPath := filepath.Join(directoryResultWriter.Directory, filepath.FromSlash(entry.Name))
+	err = os.MkdirAll(filepath.Dir(outputPath), 0755)
+	if err != nil {
+		err = fmt.Errorf("failed to create the directory for '%s': %w", entry.Name, err)
+		return
+	}
+	output, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		err = fmt.Errorf("failed to create '%s': %w", entry.Name, err)
+		return
+	}
+
+	hash := sha256.New()
+	fileWriter := &trackedWriter{writer: output}
+	written, copyErr := io.Copy(io.MultiWriter(fileWriter, hash), fileReader.reader)
+	closeErr := output.Close()
+	if fileWriter.err == nil && copyErr == nil {
+		fileWriter.err = closeErr
+	}
+	if fileWriter.err != nil {
+		// Writing to the directory failed rather than reading the file, which the next file would run into too
+		_ = os.Remove(outputPath)
+		err = fmt.Errorf("failed to write '%s': %w", entry.Name, fileWriter.err)
+		return
+	}
+	if copyErr != nil {
+		_ = os.Remove(outputPath)
+		logger.Debugf("Failed to collect '%s' due to %v", fileReader.fullPath, copyErr)
+		entry.Error = copyErr.Error()
+		directoryResultWriter.index.Entries = append(directoryResultWriter.index.Entries, entry)
+		return
+	}
+	entry.Size = written
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if !fileReader.times.modified.IsZero() {
+		accessed := fileReader.times.accessed
+		if accessed.IsZero() {
+			accessed = fileReader.times.modified
+		}
+		if timesErr := os.Chtimes(outputPath, accessed, fileReader.times.modified); timesErr != nil {
+			logger.Debugf("Failed to set the timestamps of '%s': %v", outputPath, timesErr)
+		}
+	}
+	directoryResultWriter.index.Entries = append(directoryResultWriter.index.Entries, entry)
+	logger.Debugf("Successfully collected '%s'", fileReader.fullPath)
+	return
+}
+
+// finish writes the index, and its signature when there's a signing key.
+func (directoryResultWriter *DirectoryResultWriter) finish(complete bool) (err error) {
+	directoryResultWriter.index.Complete = complete
+	indexData, err := json.MarshalIndent(directoryResultWriter.index, "", "  ")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(directoryResultWriter.Directory, tarIndexFileName), indexData, 0644)
+	if err != nil {
+		err = fmt.Errorf("failed to write the index: %w", err)
+		return
+	}
+	if directoryResultWriter.SigningKey != nil {
+		signature := ed25519.Sign(directoryResultWriter.SigningKey, indexData)
+		err = ioutil.WriteFile(filepath.Join(directoryResultWriter.Directory, tarSignatureFileName), signature, 0644)
+		if err != nil {
+			err = fmt.Errorf("failed to write the index signature: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// VerifyDirectory checks the files written by a DirectoryResultWriter against the hashes in its index. If publicKey is
+// set the index has to carry a valid signature from the matching private key. Files that are missing or don't match
+// their hash are listed as Corrupt.
+func VerifyDirectory(directory string, publicKey ed25519.PublicKey) (verification TarVerification, err error) {
+	if absolute, absErr := filepath.Abs(directory); absErr == nil {
+		directory = absolute
+	}
+	indexData, err := ioutil.ReadFile(filepath.Join(directory, tarIndexFileName))
+	if os.IsNotExist(err) {
+		err = nil
+		verification.Truncated = true
+		return
+	} else if err != nil {
+		err = fmt.Errorf("VerifyDirectory() failed to read the index: %w", err)
+		return
+	}
+	verification.IndexFound = true
+	if publicKey != nil {
+		signature, _ := ioutil.ReadFile(filepath.Join(directory, tarSignatureFileName))
+		if !ed25519.Verify(publicKey, indexData, signature) {
+			err = errors.New("VerifyDirectory() found an index that doesn't match its signature")
+			return
+		}
+		verification.Signed = true
+	}
+
+	index := TarIndex{}
+	err = json.Unmarshal(indexData, &index)
+	if err != nil {
+		err = fmt.Errorf("VerifyDirectory() failed to parse the index: %w", err)
+		return
+	}
+	verification.Complete = index.Complete
+	for _, entry := range index.Entries {
+		if entry.Error != "" {
+			continue
+		}
+		if !isSafeEntryName(entry.Name) {
+			verification.Corrupt = append(verification.Corrupt, entry.Name)
+			continue
+		}
+		hash, hashErr := hashFile(filepath.Join(directory, filepath.FromSlash(entry.Name)))
+		if hashErr != nil || hash != entry.SHA256 {
+			verification.Corrupt = append(verification.Corrupt, entry.Name)
+			continue
+		}
+		verification.Entries = append(verification.Entries, entry.Name)
+	}
+	return
+}
+
+// trackedWriter remembers the first error its writer returned, so a failed write can be told apart from a failed read.
+type trackedWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func (trackedWriter *trackedWriter) Write(data []byte) (numberOfBytesWritten int, err error) {
+	numberOfBytesWritten, err = trackedWriter.writer.Write(data)
+	if err != nil && trackedWriter.err == nil {
+		trackedWriter.err = err
+	}
+	return
+}
+
+func hashFile(path string) (hash string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		return
+	}
+	hash = hex.EncodeToString(hasher.Sum(nil))
+	return
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func writeTestDirectory(t *testing.T, directory string, signingKey ed25519.PrivateKey) {
+	resultWriter := DirectoryResultWriter{Directory: directory, SigningKey: signingKey}
+	fileReaders := make(chan fileReader, 5)
+	modified := time.Date(2019, 11, 21, 0, 25, 55, 0, time.UTC)
+	fileReaders <- fileReader{fullPath: `c:\windows\system32\config\system`, reader: strings.NewReader("regf system hive"), times: fileTimes{modified: modified}}
+	fileReaders <- fileReader{fullPath: `c:\unreadable`, reader: iotest.TimeoutReader(strings.NewReader("x"))}
+	fileReaders <- fileReader{fullPath: `c:\$mft`, reader: bytes.NewReader(make([]byte, 4096))}
+	fileReaders <- fileReader{fullPath: `c:\windows\system32\config\system`, reader: strings.NewReader("regf exported hive")}
+	fileReaders <- fileReader{fullPath: reportFileName, reader: strings.NewReader("{}")}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	if err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+		t.Fatalf("DirectoryResultWriter.ResultWriter() error = %v", err)
+	}
+}
+
+func TestDirectoryResultWriter_ResultWriter(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-directory-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	writeTestDirectory(t, directory, nil)
+
+	wantContents := map[string]string{
+		"c/windows/system32/config/system":     "regf system hive",
+		"c/windows/system32/config/system (2)": "regf exported hive",
+		"c/$mft":                               string(make([]byte, 4096)),
+		reportFileName:                         "{}",
+	}
+	for name, want := range wantContents {
+		data, readErr := ioutil.ReadFile(filepath.Join(directory, filepath.FromSlash(name)))
+		if readErr != nil || string(data) != want {
+			t.Errorf("DirectoryResultWriter.ResultWriter() wrote %q to %s, want %q (%v)", data, name, want, readErr)
+		}
+	}
+	if _, statErr := os.Stat(filepath.Join(directory, "c", "unreadable")); !os.IsNotExist(statErr) {
+		t.Error("DirectoryResultWriter.ResultWriter() left a file that couldn't be read in the directory")
+	}
+	info, _ := os.Stat(filepath.Join(directory, "c", "windows", "system32", "config", "system"))
+	if info == nil || !info.ModTime().Equal(time.Date(2019, 11, 21, 0, 25, 55, 0, time.UTC)) {
+		t.Errorf("DirectoryResultWriter.ResultWriter() didn't keep the modification time, got %v", info)
+	}
+
+	indexData, _ := ioutil.ReadFile(filepath.Join(directory, tarIndexFileName))
+	index := TarIndex{}
+	if err = json.Unmarshal(indexData, &index); err != nil {
+		t.Fatalf("the index isn't JSON: %v", err)
+	}
+	var names []string
+	for _, entry := range index.Entries {
+		names = append(names, entry.Name)
+	}
+	wantNames := []string{"c/windows/system32/config/system", "c/unreadable", "c/$mft", "c/windows/system32/config/system (2)", reportFileName}
+	if !reflect.DeepEqual(names, wantNames) || !index.Complete || index.Entries[1].Error == "" {
+		t.Errorf("DirectoryResultWriter.ResultWriter() index = %+v, want the entries %v", index, wantNames)
+	}
+}
+
+func TestVerifyDirectory(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	otherPublicKey, _, _ := ed25519.GenerateKey(nil)
+	allEntries := []string{"c/windows/system32/config/system", "c/$mft", "c/windows/system32/config/system (2)", reportFileName}
+	tests := []struct {
+		name      string
+		publicKey ed25519.PublicKey
+		tamper    func(directory string)
+		want      TarVerification
+		wantErr   bool
+	}{
+		{
+			name:      "signed",
+			publicKey: publicKey,
+			want:      TarVerification{Entries: allEntries, IndexFound: true, Signed: true, Complete: true},
+		},
+		{
+			name:      "wrong key",
+			publicKey: otherPublicKey,
+			wantErr:   true,
+		},
+		{
+			name: "changed and missing files",
+			tamper: func(directory string) {
+				_ = ioutil.WriteFile(filepath.Join(directory, "c", "windows", "system32", "config", "system"), []byte("regf system hivE"), 0644)
+				_ = os.Remove(filepath.Join(directory, "c", "$mft"))
+			},
+			want: TarVerification{
+				Entries:    []string{"c/windows/system32/config/system (2)", reportFileName},
+				Corrupt:    []string{"c/windows/system32/config/system", "c/$mft"},
+				IndexFound: true,
+				Complete:   true,
+			},
+		},
+		{
+			name: "no index",
+			tamper: func(directory string) {
+				_ = os.Remove(filepath.Join(directory, tarIndexFileName))
+			},
+			want: TarVerification{Truncated: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "gofor-directory-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(directory)
+			writeTestDirectory(t, directory, privateKey)
+			if tt.tamper != nil {
+				tt.tamper(directory)
+			}
+
+			got, err := VerifyDirectory(directory, tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyDirectory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VerifyDirectory() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CollectionErrors is returned by Collect when some files or volumes couldn't be collected but the collection carried
+// on without them. errors.Is and errors.As look through every error it holds.
+type CollectionErrors []error
+
+func (collectionErrors CollectionErrors) Error() string {
+	messages := make([]string, 0, len(collectionErrors))
+	for _, err := range collectionErrors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d errors occurred during the collection: %s", len(collectionErrors), strings.Join(messages, "; "))
+}
+
+// Is reports whether any of the errors matches target.
+func (collectionErrors CollectionErrors) Is(target error) bool {
+	for _, err := range collectionErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error that matches target.
+func (collectionErrors CollectionErrors) As(target interface{}) bool {
+	for _, err := range collectionErrors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileError is a single file that couldn't be collected.
+type FileError struct {
+	Path   string
+	Volume string
+	Err    error
+}
+
+func (fileError *FileError) Error() string {
+	return fmt.Sprintf("failed to collect '%s' from volume %s: %v", fileError.Path, fileError.Volume, fileError.Err)
+}
+
+func (fileError *FileError) Unwrap() error {
+	return fileError.Err
+}
+
+// WriteError is a file that was read but couldn't be written to the output, such as when the disk is full. Collect
+// returns it as the Err of a FileError.
+type WriteError struct {
+	Err error
+}
+
+func (writeError *WriteError) Error() string {
+	return fmt.Sprintf("the result writer couldn't write it: %v", writeError.Err)
+}
+
+func (writeError *WriteError) Unwrap() error {
+	return writeError.Err
+}
+
+// FileSystemError is returned by GetVolumeHandler for a volume that isn't NTFS, such as a USB drive or an EFI system
+// partition, so there is no MFT to search. Collect walks such volumes' directories instead.
+type FileSystemError struct {
+	Volume     string
+	FileSystem string // FAT, FAT32 or exFAT
+}
+
+func (fileSystemError *FileSystemError) Error() string {
+	return fmt.Sprintf("volume %s is %s, not NTFS", fileSystemError.Volume, fileSystemError.FileSystem)
+}
+
+// LockedVolumeError is returned by GetVolumeHandler for a volume BitLocker has locked. Collect unlocks such volumes when
+// it's given a recovery password or key.
+type LockedVolumeError struct {
+	Volume string
+}
+
+func (lockedVolumeError *LockedVolumeError) Error() string {
+	return fmt.Sprintf("volume %s is locked by BitLocker", lockedVolumeError.Volume)
+}
+
+// VolumeError is a volume that couldn't be searched.
+type VolumeError struct {
+	Volume string
+	Err    error
+}
+
+func (volumeError *VolumeError) Error() string {
+	return fmt.Sprintf("failed to collect from volume %s: %v", volumeError.Volume, volumeError.Err)
+}
+
+func (volumeError *VolumeError) Unwrap() error {
+	return volumeError.Err
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCollectionErrors(t *testing.T) {
+	collectionErrors := CollectionErrors{
+		&FileError{Path: `c:\test`, Volume: "c", Err: io.ErrUnexpectedEOF},
+		&VolumeError{Volume: "d", Err: os.ErrPermission},
+	}
+	tests := []struct {
+		name   string
+		target error
+		want   bool
+	}{
+		{name: "file error", target: io.ErrUnexpectedEOF, want: true},
+		{name: "volume error", target: os.ErrPermission, want: true},
+		{name: "not there", target: io.EOF, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(collectionErrors, tt.target); got != tt.want {
+				t.Errorf("errors.Is() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var volumeError *VolumeError
+	if !errors.As(collectionErrors, &volumeError) || volumeError.Volume != "d" {
+		t.Errorf("errors.As() did not find the volume error, got %v", volumeError)
+	}
+	want := `2 errors occurred during the collection: failed to collect 'c:\test' from volume c: unexpected EOF; failed to collect from volume d: permission denied`
+	if got := collectionErrors.Error(); got != want {
+		t.Errorf("CollectionErrors.Error() = %v, want %v", got, want)
+	}
+}
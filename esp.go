@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"strings"
+)
+
+// espVolume stands in for a drive letter for the EFI system partition, which doesn't get one, so targets can be
+// written as %ESP%:\EFI\Microsoft\Boot\bootmgfw.efi and its files end up under esp in the output.
+const espVolume = "esp"
+
+// systemPartitionType is the GPT partition type GUID of the EFI system partition, c12a7328-f81f-11d2-ba4b-00a0c93ec93b,
+// as it's laid out on disk.
+var systemPartitionType = []byte{0x28, 0x73, 0x2a, 0xc1, 0x1f, 0xf8, 0xd2, 0x11, 0xba, 0x4b, 0x00, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b}
+
+// systemPartitionPath finds the EFI system partition among the volumes and returns its volume GUID path, e.g.
+// \\?\Volume{26a21bda-a627-11d7-9931-806e6f6e6963}\. It's a variable so tests don't need one.
+var systemPartitionPath = func() (path string, err error) {
+	volumeName := make([]uint16, 260)
+	find, err := windows.FindFirstVolume(&volumeName[0], uint32(len(volumeName)))
+	if err != nil {
+		err = fmt.Errorf("failed to list the volumes: %w", err)
+		return
+	}
+	defer windows.FindVolumeClose(find)
+	for {
+		candidate := windows.UTF16ToString(volumeName)
+		if information, infoErr := partitionInformation(strings.TrimSuffix(candidate, `\`)); infoErr == nil && isSystemPartition(information) {
+			path = candidate
+			return
+		}
+		if windows.FindNextVolume(find, &volumeName[0], uint32(len(volumeName))) != nil {
+			break
+		}
+	}
+	err = errors.New("there is no EFI system partition")
+	return
+}
+
+// partitionInformation gets the PARTITION_INFORMATION_EX of a volume. It doesn't need administrator rights.
+func partitionInformation(volumePath string) (information []byte, err error) {
+	const (
+		fileShareReadWrite          = 0x01 | 0x02
+		openExisting                = 0x03
+		ioctlDiskGetPartitionInfoEx = 0x70048
+		partitionInformationSize    = 144
+	)
+	name, err := windows.UTF16PtrFromString(volumePath)
+	if err != nil {
+		return
+	}
+	handle, err := windows.CreateFile(name, 0, fileShareReadWrite, nil, openExisting, 0, 0)
+	if err != nil {
+		return
+	}
+	defer windows.CloseHandle(handle)
+	information = make([]byte, partitionInformationSize)
+	var bytesReturned uint32
+	err = windows.DeviceIoControl(handle, ioctlDiskGetPartitionInfoEx, nil, 0, &information[0], uint32(len(information)), &bytesReturned, nil)
+	return
+}
+
+// isSystemPartition reports whether a PARTITION_INFORMATION_EX is for an EFI system partition, going by its GPT
+// partition type, or its MBR partition type on the rare machine that boots UEFI from an MBR disk.
+func isSystemPartition(information []byte) bool {
+	const (
+		offsetPartitionType    = 0x20
+		partitionStyleMBR      = 0
+		partitionStyleGPT      = 1
+		mbrTypeSystemPartition = 0xef
+	)
+	if len(information) < offsetPartitionType+len(systemPartitionType) {
+		return false
+	}
+	switch binary.LittleEndian.Uint32(information) {
+	case partitionStyleGPT:
+		return bytes.Equal(information[offsetPartitionType:offsetPartitionType+len(systemPartitionType)], systemPartitionType)
+	case partitionStyleMBR:
+		return information[offsetPartitionType] == mbrTypeSystemPartition
+	}
+	return false
+}
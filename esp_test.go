@@ -0,0 +1,92 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_isSystemPartition(t *testing.T) {
+	partition := func(style byte, partitionType []byte) []byte {
+		information := make([]byte, 144)
+		information[0] = style
+		copy(information[0x20:], partitionType)
+		return information
+	}
+	basicData := []byte{0xa2, 0xa0, 0xd0, 0xeb, 0xe5, 0xb9, 0x33, 0x44, 0x87, 0xc0, 0x68, 0xb6, 0xb7, 0x26, 0x99, 0xc7}
+	tests := []struct {
+		name        string
+		information []byte
+		want        bool
+	}{
+		{name: "gpt system partition", information: partition(1, systemPartitionType), want: true},
+		{name: "gpt basic data", information: partition(1, basicData)},
+		{name: "mbr system partition", information: partition(0, []byte{0xef}), want: true},
+		{name: "mbr ntfs", information: partition(0, []byte{0x07})},
+		{name: "raw disk", information: partition(2, systemPartitionType)},
+		{name: "truncated", information: partition(1, systemPartitionType)[:0x28]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSystemPartition(tt.information); got != tt.want {
+				t.Errorf("isSystemPartition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectWithReport_systemPartition(t *testing.T) {
+	directory, err := ioutil.TempDir("", "esp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	bootRecord := filepath.Join(directory, "boot record")
+	ioutil.WriteFile(bootRecord, testBootRecord(0x52, "FAT32   "), 0644)
+	partition := filepath.Join(directory, "partition")
+	os.Mkdir(partition, 0755)
+	ioutil.WriteFile(filepath.Join(partition, "bootx64.efi"), []byte("MZ"), 0644)
+	ioutil.WriteFile(filepath.Join(partition, "notes.txt"), []byte("notes"), 0644)
+
+	defer func(original func() (string, error)) { systemPartitionPath = original }(systemPartitionPath)
+	systemPartitionPath = func() (path string, err error) {
+		return partition + string(os.PathSeparator), nil
+	}
+	var opened []string
+	defer func(original func(path string) (*os.File, error)) { openWithBackupSemantics = original }(openWithBackupSemantics)
+	openWithBackupSemantics = func(path string) (file *os.File, err error) {
+		opened = append(opened, path)
+		return os.Open(path)
+	}
+	collection := NewCollector(CollectOptions{})
+	collection.volumes = dummyHandler{filePath: bootRecord}
+	targets := ListOfFilesToExport{{FullPath: `%ESP%:\\[^\\]+\.efi$`, IsFullPathRegex: true, FileName: `.*\.efi$`, IsFileNameRegex: true}}
+	output := new(bytes.Buffer)
+	report, err := collection.CollectWithReport(context.Background(), targets, &ZipResultWriter{ZipWriter: zip.NewWriter(output)})
+	if err != nil {
+		t.Fatalf("Collector.CollectWithReport() error = %v", err)
+	}
+	if len(report.Volumes) != 1 || report.Volumes[0].Letter != espVolume || report.FilesCollected != 1 {
+		t.Errorf("Collector.CollectWithReport() report = %+v, want bootx64.efi collected from the EFI system partition", report)
+	}
+	if len(opened) != 1 || opened[0] != filepath.Join(partition, "bootx64.efi") {
+		t.Errorf("the collection opened %q, want bootx64.efi through the partition's path", opened)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, file := range archive.File {
+		found = found || file.Name == "esp/bootx64.efi"
+	}
+	if !found {
+		t.Error("the zip has no esp/bootx64.efi")
+	}
+}
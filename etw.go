@@ -0,0 +1,125 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// ETWProviderName is the name of the ETW provider the collector writes its events to. Its GUID is derived from the
+// name the way EventSource and TraceLogging derive them, so tools can enable it by name as well as by GUID.
+const ETWProviderName = "Go-Forensics-Windows-Collector"
+
+var (
+	procEventRegister    = advapi32.NewProc("EventRegister")
+	procEventUnregister  = advapi32.NewProc("EventUnregister")
+	procEventWriteString = advapi32.NewProc("EventWriteString")
+)
+
+// The ETW levels the events are written at.
+const (
+	etwLevelError       = 2
+	etwLevelWarning     = 3
+	etwLevelInformation = 4
+)
+
+// etwProvider writes the steps of a collection as ETW events, so tools already watching the host can see it being
+// collected from as it happens. Like the auditLog its methods do nothing when it's nil.
+type etwProvider struct {
+	handle      uint64
+	writeString func(handle uint64, level uint8, message string) error // EventWriteString, replaced by tests
+}
+
+// etwProviderGUID derives a provider's GUID from its name: the SHA-1 of the EventSource namespace and the name in
+// upper case UTF-16BE, as a version 5 GUID.
+func etwProviderGUID(name string) (guid windows.GUID) {
+	namespace := []byte{0x48, 0x2c, 0x2d, 0xb2, 0xc3, 0x90, 0x47, 0xc8, 0x87, 0xf8, 0x1a, 0x15, 0xbf, 0xc1, 0x30, 0xfb}
+	hash := sha1.New()
+	_, _ = hash.Write(namespace)
+	for _, unit := range utf16.Encode([]rune(strings.ToUpper(name))) {
+		_, _ = hash.Write([]byte{byte(unit >> 8), byte(unit)})
+	}
+	sum := hash.Sum(nil)
+	sum[7] = sum[7]&0x0f | 0x50
+	guid.Data1 = binary.LittleEndian.Uint32(sum[0:])
+	guid.Data2 = binary.LittleEndian.Uint16(sum[4:])
+	guid.Data3 = binary.LittleEndian.Uint16(sum[6:])
+	copy(guid.Data4[:], sum[8:16])
+	return
+}
+
+// newETWProvider registers the collector's ETW provider, or returns nil when the events weren't asked for.
+func newETWProvider(enabled bool) (provider *etwProvider, err error) {
+	if !enabled {
+		return
+	}
+	guid := etwProviderGUID(ETWProviderName)
+	provider = &etwProvider{writeString: eventWriteString}
+	result, _, _ := procEventRegister.Call(uintptr(unsafe.Pointer(&guid)), 0, 0, uintptr(unsafe.Pointer(&provider.handle)))
+	if result != 0 {
+		provider = nil
+		err = fmt.Errorf("EventRegister failed: %w", windows.Errno(result))
+	}
+	return
+}
+
+// write writes an audit entry as an event, its JSON as the event's string, at a level that goes by its event.
+func (provider *etwProvider) write(entry AuditEntry) {
+	if provider == nil {
+		return
+	}
+	message, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	_ = provider.writeString(provider.handle, etwLevel(entry.Event), string(message))
+}
+
+// close unregisters the provider.
+func (provider *etwProvider) close() {
+	if provider == nil || provider.handle == 0 {
+		return
+	}
+	_, _, _ = procEventUnregister.Call(etwUint64Args(provider.handle)...)
+	provider.handle = 0
+}
+
+func etwLevel(event string) uint8 {
+	switch event {
+	case AuditFileFailed, AuditVolumeFailed, AuditCollectionFailed:
+		return etwLevelError
+	case AuditFileSkipped, AuditVolumeFallback, AuditVolumeReopened:
+		return etwLevelWarning
+	}
+	return etwLevelInformation
+}
+
+func eventWriteString(handle uint64, level uint8, message string) (err error) {
+	messagePointer, err := windows.UTF16PtrFromString(message)
+	if err != nil {
+		return
+	}
+	args := append(etwUint64Args(handle), uintptr(level))
+	args = append(args, etwUint64Args(0)...) // no keywords
+	args = append(args, uintptr(unsafe.Pointer(messagePointer)))
+	result, _, _ := procEventWriteString.Call(args...)
+	if result != 0 {
+		err = fmt.Errorf("EventWriteString failed: %w", windows.Errno(result))
+	}
+	return
+}
+
+// etwUint64Args passes a 64 bit argument, which takes two arguments of a 32 bit process.
+func etwUint64Args(value uint64) []uintptr {
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		return []uintptr{uintptr(value)}
+	}
+	return []uintptr{uintptr(uint32(value)), uintptr(value >> 32)}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func Test_etwProviderGUID(t *testing.T) {
+	guid := etwProviderGUID(ETWProviderName)
+	if guid.Data3>>12 != 5 {
+		t.Errorf("etwProviderGUID() = %+v, want a version 5 GUID", guid)
+	}
+	if etwProviderGUID("go-forensics-windows-collector") != guid {
+		t.Error("etwProviderGUID() depends on the case of the name")
+	}
+	if etwProviderGUID("Another-Provider") == guid {
+		t.Error("etwProviderGUID() is the same for another name")
+	}
+}
+
+func Test_auditLog_etw(t *testing.T) {
+	type event struct {
+		level uint8
+		entry AuditEntry
+	}
+	var events []event
+	provider := &etwProvider{writeString: func(handle uint64, level uint8, message string) error {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(message), &entry); err != nil {
+			t.Fatalf("the event %q isn't an audit entry: %v", message, err)
+		}
+		events = append(events, event{level: level, entry: entry})
+		return nil
+	}}
+
+	// The events are written whether or not audit.jsonl is kept
+	audit := newAuditLog(false, provider, nil)
+	audit.record(AuditCollectionStarted, "", "", "version 1")
+	audit.record(AuditFileSkipped, "c", `c:\pagefile.sys`, "too big")
+	audit.record(AuditFileFailed, "c", `c:\locked`, "access denied")
+	audit.finish(CollectionReport{FilesMatched: 3, FilesCollected: 1, BytesRead: 10}, nil)
+	audit.record(AuditFileCollected, "c", `c:\late`, "after the collection finished")
+	if audit.entries.Len() != 0 {
+		t.Errorf("the audit log kept %q without being asked to", audit.entries.String())
+	}
+
+	want := []event{
+		{level: etwLevelInformation, entry: AuditEntry{Sequence: 1, Event: AuditCollectionStarted, Detail: "version 1"}},
+		{level: etwLevelWarning, entry: AuditEntry{Sequence: 2, Event: AuditFileSkipped, Volume: "c", Path: `c:\pagefile.sys`, Detail: "too big"}},
+		{level: etwLevelError, entry: AuditEntry{Sequence: 3, Event: AuditFileFailed, Volume: "c", Path: `c:\locked`, Detail: "access denied"}},
+		{level: etwLevelInformation, entry: AuditEntry{Sequence: 4, Event: AuditCollectionFinished, Detail: "1 of 3 matched files collected, 10 bytes read"}},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("wrote %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for index := range want {
+		events[index].entry.Time = want[index].entry.Time
+		if events[index] != want[index] {
+			t.Errorf("event %d = %+v, want %+v", index, events[index], want[index])
+		}
+	}
+
+	events = nil
+	audit = newAuditLog(true, &etwProvider{writeString: provider.writeString}, nil)
+	audit.finish(CollectionReport{}, errors.New("the output is full"))
+	if len(events) != 1 || events[0].level != etwLevelError || events[0].entry.Event != AuditCollectionFailed {
+		t.Errorf("finish() wrote %+v, want a collection_failed error", events)
+	}
+
+	var disabled *etwProvider
+	disabled.write(AuditEntry{})
+	disabled.close()
+	if provider, err := newETWProvider(false); provider != nil || err != nil {
+		t.Errorf("newETWProvider(false) = %v, %v, want nil", provider, err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+var (
+	wevtapi          = windows.NewLazySystemDLL("wevtapi.dll")
+	procEvtExportLog = wevtapi.NewProc("EvtExportLog")
+)
+
+// evtExportLogChannelPath tells EvtExportLog the path is a channel rather than a log file.
+const evtExportLogChannelPath = 1
+
+// EventLogChannel is an event log channel exported through the event log API into eventlogs/<Name>.evtx. Only the
+// events its XPath query selects are exported, which is much smaller than copying the channel's whole .evtx file when
+// only a few event IDs are needed.
+type EventLogChannel struct {
+	Name    string `json:"name"`    // defaults to the channel's file name, e.g. Microsoft-Windows-Sysmon%4Operational
+	Channel string `json:"channel"` // e.g. Security or Microsoft-Windows-Sysmon/Operational
+	Query   string `json:"query"`   // e.g. *[System[(EventID=4624 or EventID=4625)]], empty exports every event
+}
+
+func (channel EventLogChannel) name() string {
+	if channel.Name != "" {
+		return channel.Name
+	}
+	return strings.Replace(channel.Channel, "/", "%4", -1)
+}
+
+// EventLogAcquirers returns an Acquirer for each channel.
+func EventLogAcquirers(channels []EventLogChannel) (acquirers []Acquirer, err error) {
+	names := make(map[string]bool)
+	for _, channel := range channels {
+		name := strings.ToLower(channel.name())
+		switch {
+		case channel.Channel == "":
+			err = fmt.Errorf("the event log channel '%s' has no channel", channel.Name)
+		case !isOutputName(name):
+			err = fmt.Errorf("the event log channel name '%s' can't be used as a file name", channel.name())
+		case names[name]:
+			err = fmt.Errorf("more than one event log channel is named '%s'", channel.name())
+		}
+		if err != nil {
+			acquirers = nil
+			return
+		}
+		names[name] = true
+		acquirers = append(acquirers, &eventLogAcquirer{channel: channel})
+	}
+	return
+}
+
+type eventLogAcquirer struct {
+	channel EventLogChannel
+}
+
+func (acquirer *eventLogAcquirer) Name() string {
+	return fmt.Sprintf("eventlogs/%s.evtx", acquirer.channel.name())
+}
+
+// Acquire exports the channel to a temp file, which is removed once it has been read.
+func (acquirer *eventLogAcquirer) Acquire(ctx context.Context, logger Logger) (reader io.ReadCloser, size int64, err error) {
+	tempFile, err := ioutil.TempFile("", "gofor-evtx-")
+	if err != nil {
+		return
+	}
+	// EvtExportLog refuses to overwrite, so only the name of the temp file is used
+	tempFileName := tempFile.Name()
+	tempFile.Close()
+	os.Remove(tempFileName)
+
+	query := acquirer.channel.Query
+	if query == "" {
+		query = "*"
+	}
+	err = exportEventLog(acquirer.channel.Channel, query, tempFileName)
+	if err != nil {
+		os.Remove(tempFileName)
+		err = fmt.Errorf("failed to export the event log channel %s: %w", acquirer.channel.Channel, err)
+		return
+	}
+	tempFile, err = os.Open(tempFileName)
+	if err != nil {
+		os.Remove(tempFileName)
+		return
+	}
+	if info, statErr := tempFile.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	reader = &spooledFile{reader: tempFile, tempFile: tempFile}
+	return
+}
+
+// exportEventLog exports the events in a channel that match an XPath query into a new .evtx file with EvtExportLog.
+// It's a variable so tests don't depend on the host's event logs.
+var exportEventLog = func(channel string, query string, targetPath string) (err error) {
+	channelPointer, err := windows.UTF16PtrFromString(channel)
+	if err != nil {
+		return
+	}
+	queryPointer, err := windows.UTF16PtrFromString(query)
+	if err != nil {
+		return
+	}
+	targetPointer, err := windows.UTF16PtrFromString(targetPath)
+	if err != nil {
+		return
+	}
+	result, _, callErr := procEvtExportLog.Call(0, uintptr(unsafe.Pointer(channelPointer)), uintptr(unsafe.Pointer(queryPointer)), uintptr(unsafe.Pointer(targetPointer)), evtExportLogChannelPath)
+	if result == 0 {
+		err = fmt.Errorf("EvtExportLog() failed: %w", callErr)
+	}
+	return
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEventLogAcquirers(t *testing.T) {
+	tests := []struct {
+		name      string
+		channels  []EventLogChannel
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name: "names",
+			channels: []EventLogChannel{
+				{Channel: "Security", Query: "*[System[(EventID=4624 or EventID=4625)]]"},
+				{Channel: "Microsoft-Windows-Sysmon/Operational"},
+				{Name: "rdp", Channel: "Microsoft-Windows-TerminalServices-LocalSessionManager/Operational"},
+			},
+			wantNames: []string{"eventlogs/Security.evtx", "eventlogs/Microsoft-Windows-Sysmon%4Operational.evtx", "eventlogs/rdp.evtx"},
+		},
+		{
+			name:     "no channel",
+			channels: []EventLogChannel{{Name: "logons"}},
+			wantErr:  true,
+		},
+		{
+			name:     "bad name",
+			channels: []EventLogChannel{{Name: "logons:4624", Channel: "Security"}},
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate name",
+			channels: []EventLogChannel{{Channel: "Security"}, {Name: "security", Channel: "System"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acquirers, err := EventLogAcquirers(tt.channels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EventLogAcquirers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, acquirer := range acquirers {
+				names = append(names, acquirer.Name())
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("EventLogAcquirers() names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func Test_eventLogAcquirer(t *testing.T) {
+	original := exportEventLog
+	defer func() { exportEventLog = original }()
+	var gotChannel, gotQuery, gotPath string
+	exportEventLog = func(channel string, query string, targetPath string) error {
+		gotChannel, gotQuery, gotPath = channel, query, targetPath
+		if _, err := os.Stat(targetPath); err == nil {
+			return errors.New("the target file already exists")
+		}
+		return ioutil.WriteFile(targetPath, []byte("ElfFile\x00"), 0600)
+	}
+	acquirer := &eventLogAcquirer{channel: EventLogChannel{Channel: "Security"}}
+	reader, size, err := acquirer.Acquire(context.Background(), loggerOrDefault(nil))
+	if err != nil {
+		t.Fatalf("eventLogAcquirer.Acquire() error = %v", err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	if string(data) != "ElfFile\x00" || size != int64(len(data)) {
+		t.Errorf("eventLogAcquirer.Acquire() = %q of size %d, want the exported file", data, size)
+	}
+	if gotChannel != "Security" || gotQuery != "*" {
+		t.Errorf("eventLogAcquirer.Acquire() exported %s with %q, want Security with *", gotChannel, gotQuery)
+	}
+	if _, err = os.Stat(gotPath); !os.IsNotExist(err) {
+		t.Errorf("eventLogAcquirer.Acquire() left the exported file behind: %v", err)
+	}
+
+	exportEventLog = func(channel string, query string, targetPath string) error {
+		gotPath = targetPath
+		_ = ioutil.WriteFile(targetPath, nil, 0600)
+		return errors.New("the specified channel could not be found")
+	}
+	if _, _, err = acquirer.Acquire(context.Background(), loggerOrDefault(nil)); err == nil {
+		t.Error("eventLogAcquirer.Acquire() should fail when the export fails")
+	}
+	if _, err = os.Stat(gotPath); !os.IsNotExist(err) {
+		t.Errorf("eventLogAcquirer.Acquire() left the failed export behind: %v", err)
+	}
+}
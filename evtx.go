@@ -0,0 +1,762 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// The layout of an .evtx file: a file header block followed by chunks of records, each with its own string and
+// template tables, so a chunk can be parsed on its own.
+const (
+	evtxFileHeaderSize  = 0x1000
+	evtxChunkSize       = 0x10000
+	evtxChunkHeaderSize = 0x200
+	evtxRecordSignature = 0x00002a2a
+	evtxRecordHeaderLen = 0x18
+	evtxMaxNesting      = 32 // how deep templates and binary XML values can be nested, against loops in a damaged file
+)
+
+var (
+	evtxFileSignature  = []byte("ElfFile\x00")
+	evtxChunkSignature = []byte("ElfChnk\x00")
+
+	errEvtxTruncated = errors.New("the binary XML runs past the end of its chunk")
+)
+
+// The binary XML tokens. The ones that can be followed by more of their kind have a version with 0x40 set.
+const (
+	binXMLEndOfFragment      = 0x00
+	binXMLOpenStartElement   = 0x01
+	binXMLCloseStartElement  = 0x02
+	binXMLCloseEmptyElement  = 0x03
+	binXMLEndElement         = 0x04
+	binXMLValue              = 0x05
+	binXMLAttributeToken     = 0x06
+	binXMLCDATASection       = 0x07
+	binXMLCharRef            = 0x08
+	binXMLEntityRef          = 0x09
+	binXMLPITarget           = 0x0a
+	binXMLPIData             = 0x0b
+	binXMLTemplateInstance   = 0x0c
+	binXMLNormalSubstitution = 0x0d
+	binXMLOptionalSubstitute = 0x0e
+	binXMLFragmentHeader     = 0x0f
+	binXMLHasMoreFlag        = 0x40
+)
+
+// The types of binary XML values. An array of a type has 0x80 set.
+const (
+	binXMLTypeNull       = 0x00
+	binXMLTypeWString    = 0x01
+	binXMLTypeString     = 0x02
+	binXMLTypeInt8       = 0x03
+	binXMLTypeUInt8      = 0x04
+	binXMLTypeInt16      = 0x05
+	binXMLTypeUInt16     = 0x06
+	binXMLTypeInt32      = 0x07
+	binXMLTypeUInt32     = 0x08
+	binXMLTypeInt64      = 0x09
+	binXMLTypeUInt64     = 0x0a
+	binXMLTypeReal32     = 0x0b
+	binXMLTypeReal64     = 0x0c
+	binXMLTypeBool       = 0x0d
+	binXMLTypeBinary     = 0x0e
+	binXMLTypeGUID       = 0x0f
+	binXMLTypeSizeT      = 0x10
+	binXMLTypeFileTime   = 0x11
+	binXMLTypeSystemTime = 0x12
+	binXMLTypeSID        = 0x13
+	binXMLTypeHexInt32   = 0x14
+	binXMLTypeHexInt64   = 0x15
+	binXMLTypeBinXML     = 0x21
+	binXMLTypeArray      = 0x80
+)
+
+// binXMLFixedSizes are the sizes of the types of binary XML values that have one.
+var binXMLFixedSizes = map[byte]int{
+	binXMLTypeInt8: 1, binXMLTypeUInt8: 1, binXMLTypeInt16: 2, binXMLTypeUInt16: 2, binXMLTypeInt32: 4,
+	binXMLTypeUInt32: 4, binXMLTypeInt64: 8, binXMLTypeUInt64: 8, binXMLTypeReal32: 4, binXMLTypeReal64: 8,
+	binXMLTypeBool: 4, binXMLTypeGUID: 16, binXMLTypeFileTime: 8, binXMLTypeSystemTime: 16, binXMLTypeHexInt32: 4,
+	binXMLTypeHexInt64: 8,
+}
+
+// evtxRecord is an event record parsed out of an .evtx file.
+type evtxRecord struct {
+	RecordID  uint64                 `json:"record_id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Event     map[string]interface{} `json:"Event"`
+}
+
+// readEvtx reads the records of an .evtx file a chunk at a time, so only one chunk is held in memory, and hands each
+// to each. Chunks that were never used or are damaged are skipped, as are records that can't be parsed, with the number
+// skipped returned.
+func readEvtx(input io.Reader, each func(record evtxRecord) error) (skipped int, err error) {
+	header := make([]byte, evtxFileHeaderSize)
+	if _, err = io.ReadFull(input, header); err != nil {
+		err = fmt.Errorf("failed to read the file header: %w", err)
+		return
+	}
+	if !bytes.Equal(header[:len(evtxFileSignature)], evtxFileSignature) {
+		err = errors.New("it's not an .evtx file")
+		return
+	}
+	chunk := make([]byte, evtxChunkSize)
+	for {
+		_, err = io.ReadFull(input, chunk)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A log that was still being written can end part way through a chunk
+			return skipped, nil
+		} else if err != nil {
+			err = fmt.Errorf("failed to read a chunk: %w", err)
+			return
+		}
+		if !bytes.Equal(chunk[:len(evtxChunkSignature)], evtxChunkSignature) {
+			continue
+		}
+		var chunkSkipped int
+		chunkSkipped, err = readEvtxChunk(chunk, each)
+		skipped += chunkSkipped
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readEvtxChunk parses the records of a chunk, up to where its free space starts.
+func readEvtxChunk(chunk []byte, each func(record evtxRecord) error) (skipped int, err error) {
+	const offsetFreeSpace = 0x30
+	end := int(binary.LittleEndian.Uint32(chunk[offsetFreeSpace:]))
+	if end > len(chunk) || end < evtxChunkHeaderSize {
+		end = len(chunk)
+	}
+	parser := binXMLParser{chunk: chunk, templates: make(map[uint32]*binXMLElement)}
+	for offset := evtxChunkHeaderSize; offset+evtxRecordHeaderLen <= end; {
+		if binary.LittleEndian.Uint32(chunk[offset:]) != evtxRecordSignature {
+			return
+		}
+		size := int(binary.LittleEndian.Uint32(chunk[offset+4:]))
+		if size < evtxRecordHeaderLen+4 || offset+size > end {
+			skipped++
+			return
+		}
+		record := evtxRecord{
+			RecordID:  binary.LittleEndian.Uint64(chunk[offset+8:]),
+			Timestamp: fileTimeToTime(binary.LittleEndian.Uint64(chunk[offset+16:])),
+		}
+		root, _, parseErr := parser.parseFragment(offset+evtxRecordHeaderLen, 0)
+		if parseErr != nil || root == nil {
+			skipped++
+		} else {
+			record.Event, _ = root.jsonValue().(map[string]interface{})
+			if err = each(record); err != nil {
+				return
+			}
+		}
+		offset += size
+	}
+	return
+}
+
+// binXMLElement is an XML element of a record, or of a template before its substitutions are filled in.
+type binXMLElement struct {
+	name       string
+	attributes []binXMLAttribute
+	content    []interface{} // *binXMLElement, values and, in templates, binXMLSubstitution
+}
+
+type binXMLAttribute struct {
+	name  string
+	value []interface{}
+}
+
+// binXMLSubstitution is where a template instance's value goes in its template.
+type binXMLSubstitution struct {
+	index    int
+	optional bool // left out when the value is null
+}
+
+// binXMLParser parses the binary XML of the records of a chunk. Names and templates are referred to by their offset in
+// the chunk and are parsed where they're first used, with the templates kept for the records after.
+type binXMLParser struct {
+	chunk     []byte
+	templates map[uint32]*binXMLElement
+}
+
+func (parser *binXMLParser) need(offset int, length int) (err error) {
+	if offset < 0 || length < 0 || offset+length > len(parser.chunk) {
+		err = errEvtxTruncated
+	}
+	return
+}
+
+func (parser *binXMLParser) byteAt(offset int) (value byte, err error) {
+	if err = parser.need(offset, 1); err == nil {
+		value = parser.chunk[offset]
+	}
+	return
+}
+
+func (parser *binXMLParser) uint16At(offset int) (value uint16, err error) {
+	if err = parser.need(offset, 2); err == nil {
+		value = binary.LittleEndian.Uint16(parser.chunk[offset:])
+	}
+	return
+}
+
+func (parser *binXMLParser) uint32At(offset int) (value uint32, err error) {
+	if err = parser.need(offset, 4); err == nil {
+		value = binary.LittleEndian.Uint32(parser.chunk[offset:])
+	}
+	return
+}
+
+func (parser *binXMLParser) utf16At(offset int, characters int) (value string, err error) {
+	if err = parser.need(offset, characters*2); err == nil {
+		value = decodeUTF16(parser.chunk[offset : offset+characters*2])
+	}
+	return
+}
+
+// name reads the name at nameOffset. A name is stored where it's first used, so when nameOffset is the current offset
+// the returned offset is past it.
+func (parser *binXMLParser) name(offset int, nameOffset uint32) (name string, next int, err error) {
+	const offsetCharacters = 8
+	next = offset
+	characters, err := parser.uint16At(int(nameOffset) + 6)
+	if err != nil {
+		return
+	}
+	name, err = parser.utf16At(int(nameOffset)+offsetCharacters, int(characters))
+	if err == nil && int(nameOffset) == offset {
+		next = offset + offsetCharacters + int(characters)*2 + 2
+	}
+	return
+}
+
+// parseFragment parses a fragment: a fragment header followed by an element or a template instance.
+func (parser *binXMLParser) parseFragment(offset int, depth int) (root *binXMLElement, next int, err error) {
+	if depth > evtxMaxNesting {
+		err = errors.New("the binary XML is nested too deep")
+		return
+	}
+	token, err := parser.byteAt(offset)
+	if err != nil {
+		return
+	}
+	if token == binXMLFragmentHeader {
+		offset += 4
+		if token, err = parser.byteAt(offset); err != nil {
+			return
+		}
+	}
+	switch token &^ binXMLHasMoreFlag {
+	case binXMLTemplateInstance:
+		root, next, err = parser.parseTemplateInstance(offset, depth)
+	case binXMLOpenStartElement:
+		root, next, err = parser.parseElement(offset, depth)
+	default:
+		err = fmt.Errorf("unexpected binary XML token 0x%02x at the start of a fragment", token)
+	}
+	if err == nil {
+		if token, _ = parser.byteAt(next); token == binXMLEndOfFragment {
+			next++
+		}
+	}
+	return
+}
+
+// parseElement parses an element with its attributes and content.
+func (parser *binXMLParser) parseElement(offset int, depth int) (element *binXMLElement, next int, err error) {
+	token := parser.chunk[offset]
+	// The token, a dependency identifier and the size of the element's data
+	next = offset + 7
+	nameOffset, err := parser.uint32At(next)
+	if err != nil {
+		return
+	}
+	element = &binXMLElement{}
+	if element.name, next, err = parser.name(next+4, nameOffset); err != nil {
+		return
+	}
+	if token&binXMLHasMoreFlag != 0 {
+		// The size of the attribute list
+		next += 4
+		for {
+			if token, err = parser.byteAt(next); err != nil {
+				return
+			}
+			if token&^binXMLHasMoreFlag != binXMLAttributeToken {
+				break
+			}
+			if nameOffset, err = parser.uint32At(next + 1); err != nil {
+				return
+			}
+			var attribute binXMLAttribute
+			if attribute.name, next, err = parser.name(next+5, nameOffset); err != nil {
+				return
+			}
+			if attribute.value, next, err = parser.parseContent(next, depth, true); err != nil {
+				return
+			}
+			element.attributes = append(element.attributes, attribute)
+		}
+	}
+
+	if token, err = parser.byteAt(next); err != nil {
+		return
+	}
+	switch token {
+	case binXMLCloseEmptyElement:
+		next++
+	case binXMLCloseStartElement:
+		if element.content, next, err = parser.parseContent(next+1, depth, false); err != nil {
+			return
+		}
+		if token, err = parser.byteAt(next); err == nil && token != binXMLEndElement {
+			err = fmt.Errorf("element %s ends with the binary XML token 0x%02x", element.name, token)
+		}
+		next++
+	default:
+		err = fmt.Errorf("unexpected binary XML token 0x%02x after the start of element %s", token, element.name)
+	}
+	return
+}
+
+// parseContent parses the values, substitutions and, unless it's the value of an attribute, the child elements up to
+// the token that ends them, which is left for the caller.
+func (parser *binXMLParser) parseContent(offset int, depth int, attributeValue bool) (content []interface{}, next int, err error) {
+	next = offset
+	for {
+		var token byte
+		if token, err = parser.byteAt(next); err != nil {
+			return
+		}
+		switch token &^ binXMLHasMoreFlag {
+		case binXMLOpenStartElement:
+			if attributeValue {
+				return
+			}
+			var child *binXMLElement
+			if child, next, err = parser.parseElement(next, depth); err != nil {
+				return
+			}
+			content = append(content, child)
+		case binXMLValue:
+			var valueType byte
+			var characters uint16
+			if valueType, err = parser.byteAt(next + 1); err != nil {
+				return
+			}
+			if valueType != binXMLTypeWString {
+				err = fmt.Errorf("unexpected binary XML value of type 0x%02x", valueType)
+				return
+			}
+			if characters, err = parser.uint16At(next + 2); err != nil {
+				return
+			}
+			var value string
+			if value, err = parser.utf16At(next+4, int(characters)); err != nil {
+				return
+			}
+			content = append(content, value)
+			next += 4 + int(characters)*2
+		case binXMLNormalSubstitution, binXMLOptionalSubstitute:
+			var index uint16
+			if index, err = parser.uint16At(next + 1); err != nil {
+				return
+			}
+			content = append(content, binXMLSubstitution{index: int(index), optional: token == binXMLOptionalSubstitute})
+			next += 4
+		case binXMLCDATASection:
+			var characters uint16
+			if characters, err = parser.uint16At(next + 1); err != nil {
+				return
+			}
+			var value string
+			if value, err = parser.utf16At(next+3, int(characters)); err != nil {
+				return
+			}
+			content = append(content, value)
+			next += 3 + int(characters)*2
+		case binXMLCharRef:
+			var character uint16
+			if character, err = parser.uint16At(next + 1); err != nil {
+				return
+			}
+			content = append(content, string(rune(character)))
+			next += 3
+		case binXMLEntityRef:
+			var nameOffset uint32
+			if nameOffset, err = parser.uint32At(next + 1); err != nil {
+				return
+			}
+			var name string
+			if name, next, err = parser.name(next+5, nameOffset); err != nil {
+				return
+			}
+			content = append(content, entityValue(name))
+		case binXMLPITarget:
+			// Processing instructions don't carry anything of the event, so they're skipped
+			var nameOffset uint32
+			if nameOffset, err = parser.uint32At(next + 1); err != nil {
+				return
+			}
+			if _, next, err = parser.name(next+5, nameOffset); err != nil {
+				return
+			}
+		case binXMLPIData:
+			var characters uint16
+			if characters, err = parser.uint16At(next + 1); err != nil {
+				return
+			}
+			next += 3 + int(characters)*2
+		case binXMLTemplateInstance:
+			if attributeValue {
+				return
+			}
+			var child *binXMLElement
+			if child, next, err = parser.parseTemplateInstance(next, depth+1); err != nil {
+				return
+			}
+			content = append(content, child)
+		default:
+			return
+		}
+	}
+}
+
+func entityValue(name string) string {
+	switch name {
+	case "amp":
+		return "&"
+	case "lt":
+		return "<"
+	case "gt":
+		return ">"
+	case "quot":
+		return `"`
+	case "apos":
+		return "'"
+	}
+	return "&" + name + ";"
+}
+
+// parseTemplateInstance parses a template instance, with its template's definition when this is the first use of it,
+// and returns the template's element with the instance's values filled in.
+func (parser *binXMLParser) parseTemplateInstance(offset int, depth int) (element *binXMLElement, next int, err error) {
+	const templateHeaderLength = 24 // the offset of the next template, the template's GUID and its size
+	if depth > evtxMaxNesting {
+		err = errors.New("the binary XML is nested too deep")
+		return
+	}
+	// The token, an unknown byte and the template's identifier
+	definitionOffset, err := parser.uint32At(offset + 6)
+	if err != nil {
+		return
+	}
+	next = offset + 10
+	template, parsed := parser.templates[definitionOffset]
+	if int(definitionOffset) >= next {
+		var size uint32
+		if size, err = parser.uint32At(int(definitionOffset) + 20); err != nil {
+			return
+		}
+		if !parsed {
+			if template, _, err = parser.parseFragment(int(definitionOffset)+templateHeaderLength, depth+1); err != nil {
+				return
+			}
+			parser.templates[definitionOffset] = template
+		}
+		next = int(definitionOffset) + templateHeaderLength + int(size)
+	} else if !parsed {
+		if template, _, err = parser.parseFragment(int(definitionOffset)+templateHeaderLength, depth+1); err != nil {
+			return
+		}
+		parser.templates[definitionOffset] = template
+	}
+	if template == nil {
+		err = errors.New("a template instance refers to an empty template")
+		return
+	}
+
+	numberOfValues, err := parser.uint32At(next)
+	if err != nil {
+		return
+	}
+	next += 4
+	if err = parser.need(next, int(numberOfValues)*4); err != nil {
+		return
+	}
+	values := make([]interface{}, numberOfValues)
+	valueOffset := next + int(numberOfValues)*4
+	for index := range values {
+		size := int(binary.LittleEndian.Uint16(parser.chunk[next+index*4:]))
+		valueType := parser.chunk[next+index*4+2]
+		if err = parser.need(valueOffset, size); err != nil {
+			return
+		}
+		if valueType == binXMLTypeBinXML {
+			if size != 0 {
+				if values[index], _, err = parser.parseFragment(valueOffset, depth+1); err != nil {
+					return
+				}
+			}
+		} else {
+			values[index] = binXMLValueOf(valueType, parser.chunk[valueOffset:valueOffset+size])
+		}
+		valueOffset += size
+	}
+	next = valueOffset
+	element = template.substitute(values)
+	return
+}
+
+// substitute returns a copy of a template's element with the values filled in for its substitutions.
+func (element *binXMLElement) substitute(values []interface{}) *binXMLElement {
+	filled := &binXMLElement{name: element.name}
+	for _, attribute := range element.attributes {
+		value, present := substituteContent(attribute.value, values)
+		if present {
+			filled.attributes = append(filled.attributes, binXMLAttribute{name: attribute.name, value: value})
+		}
+	}
+	filled.content, _ = substituteContent(element.content, values)
+	return filled
+}
+
+// substituteContent fills in the substitutions of content. present is false when all there was to it were optional
+// substitutions with null values.
+func substituteContent(content []interface{}, values []interface{}) (filled []interface{}, present bool) {
+	for _, item := range content {
+		switch item := item.(type) {
+		case binXMLSubstitution:
+			var value interface{}
+			if item.index < len(values) {
+				value = values[item.index]
+			}
+			if value == nil || value == "" {
+				if !item.optional {
+					present = true
+				}
+				continue
+			}
+			filled = append(filled, value)
+		case *binXMLElement:
+			filled = append(filled, item.substitute(values))
+		default:
+			filled = append(filled, item)
+		}
+		present = true
+	}
+	return
+}
+
+// binXMLValueOf decodes a value of a template instance.
+func binXMLValueOf(valueType byte, data []byte) interface{} {
+	if valueType&binXMLTypeArray != 0 {
+		return binXMLArrayOf(valueType&^binXMLTypeArray, data)
+	}
+	if size, fixed := binXMLFixedSizes[valueType]; fixed && len(data) < size {
+		return strings.ToUpper(hex.EncodeToString(data))
+	}
+	switch valueType {
+	case binXMLTypeNull:
+		return nil
+	case binXMLTypeWString:
+		return strings.TrimRight(decodeUTF16(data), "\x00")
+	case binXMLTypeString:
+		return strings.TrimRight(string(data), "\x00")
+	case binXMLTypeInt8:
+		return int8(data[0])
+	case binXMLTypeUInt8:
+		return data[0]
+	case binXMLTypeInt16:
+		return int16(binary.LittleEndian.Uint16(data))
+	case binXMLTypeUInt16:
+		return binary.LittleEndian.Uint16(data)
+	case binXMLTypeInt32:
+		return int32(binary.LittleEndian.Uint32(data))
+	case binXMLTypeUInt32:
+		return binary.LittleEndian.Uint32(data)
+	case binXMLTypeInt64:
+		return int64(binary.LittleEndian.Uint64(data))
+	case binXMLTypeUInt64:
+		return binary.LittleEndian.Uint64(data)
+	case binXMLTypeReal32:
+		return math.Float32frombits(binary.LittleEndian.Uint32(data))
+	case binXMLTypeReal64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data))
+	case binXMLTypeBool:
+		return binary.LittleEndian.Uint32(data) != 0
+	case binXMLTypeGUID:
+		return formatGUID(data)
+	case binXMLTypeSizeT, binXMLTypeHexInt32, binXMLTypeHexInt64:
+		if len(data) == 4 {
+			return fmt.Sprintf("0x%x", binary.LittleEndian.Uint32(data))
+		} else if len(data) == 8 {
+			return fmt.Sprintf("0x%x", binary.LittleEndian.Uint64(data))
+		}
+	case binXMLTypeFileTime:
+		return fileTimeToTime(binary.LittleEndian.Uint64(data)).Format(time.RFC3339Nano)
+	case binXMLTypeSystemTime:
+		field := func(index int) int { return int(binary.LittleEndian.Uint16(data[index*2:])) }
+		return time.Date(field(0), time.Month(field(1)), field(3), field(4), field(5), field(6), field(7)*int(time.Millisecond), time.UTC).Format(time.RFC3339Nano)
+	case binXMLTypeSID:
+		if sid, ok := formatSID(data); ok {
+			return sid
+		}
+	}
+	return strings.ToUpper(hex.EncodeToString(data))
+}
+
+// binXMLArrayOf decodes an array value: strings end with a null, the other types are of a fixed size.
+func binXMLArrayOf(valueType byte, data []byte) (values []interface{}) {
+	values = make([]interface{}, 0)
+	if valueType == binXMLTypeWString {
+		for _, value := range strings.Split(strings.TrimRight(decodeUTF16(data), "\x00"), "\x00") {
+			values = append(values, value)
+		}
+		return
+	}
+	size := binXMLFixedSizes[valueType]
+	if size == 0 {
+		return append(values, strings.ToUpper(hex.EncodeToString(data)))
+	}
+	for offset := 0; offset+size <= len(data); offset += size {
+		values = append(values, binXMLValueOf(valueType, data[offset:offset+size]))
+	}
+	return
+}
+
+func decodeUTF16(data []byte) string {
+	characters := make([]uint16, len(data)/2)
+	for index := range characters {
+		characters[index] = binary.LittleEndian.Uint16(data[index*2:])
+	}
+	return string(utf16.Decode(characters))
+}
+
+// fileTimeToTime converts a FILETIME, 100 nanosecond intervals since 1601, to UTC.
+func fileTimeToTime(fileTime uint64) time.Time {
+	const intervalsTo1970 = 116444736000000000
+	if fileTime == 0 {
+		return time.Time{}
+	}
+	intervals := int64(fileTime) - intervalsTo1970
+	return time.Unix(intervals/10000000, (intervals%10000000)*100).UTC()
+}
+
+func formatGUID(data []byte) string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint16(data[4:]),
+		binary.LittleEndian.Uint16(data[6:]), data[8:10], data[10:16])
+}
+
+// formatSID formats a binary SID as S-1-5-21-....
+func formatSID(data []byte) (sid string, ok bool) {
+	if len(data) < 8 || len(data) < 8+int(data[1])*4 {
+		return
+	}
+	var authority uint64
+	for _, value := range data[2:8] {
+		authority = authority<<8 | uint64(value)
+	}
+	sid = fmt.Sprintf("S-%d-%d", data[0], authority)
+	for index := 0; index < int(data[1]); index++ {
+		sid += "-" + strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data[8+index*4:])), 10)
+	}
+	return sid, true
+}
+
+// jsonValue converts an element to what it looks like as JSON: its value when it has nothing but a value, else an
+// object of its attributes under #attributes, its child elements by name, in an array when there are several with the
+// same name, and its value under #text. A Data element with a Name attribute, as in EventData, goes under its Name.
+func (element *binXMLElement) jsonValue() interface{} {
+	if len(element.attributes) == 0 && !element.hasChildElements() {
+		return textOf(element.content)
+	}
+	object := make(map[string]interface{})
+	if len(element.attributes) != 0 {
+		attributes := make(map[string]interface{})
+		for _, attribute := range element.attributes {
+			attributes[attribute.name] = textOf(attribute.value)
+		}
+		object["#attributes"] = attributes
+	}
+	var text []interface{}
+	children := make(map[string][]interface{})
+	for _, item := range element.content {
+		child, isElement := item.(*binXMLElement)
+		if !isElement {
+			text = append(text, item)
+			continue
+		}
+		key, value := child.name, child.jsonValue()
+		if name, found := child.dataName(); found {
+			key, value = name, textOf(child.content)
+		}
+		children[key] = append(children[key], value)
+	}
+	for key, values := range children {
+		if len(values) == 1 {
+			object[key] = values[0]
+		} else {
+			object[key] = values
+		}
+	}
+	if value := textOf(text); value != nil {
+		object["#text"] = value
+	}
+	return object
+}
+
+func (element *binXMLElement) hasChildElements() bool {
+	for _, item := range element.content {
+		if _, isElement := item.(*binXMLElement); isElement {
+			return true
+		}
+	}
+	return false
+}
+
+// dataName is the Name attribute of a Data element that has nothing else to it.
+func (element *binXMLElement) dataName() (name string, found bool) {
+	if element.name != "Data" || len(element.attributes) != 1 || element.attributes[0].name != "Name" || element.hasChildElements() {
+		return
+	}
+	name, found = textOf(element.attributes[0].value).(string)
+	return
+}
+
+// textOf is a value as it goes into JSON: nil for nothing, the value itself when there's one, else the values run
+// together as a string.
+func textOf(values []interface{}) interface{} {
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		if element, isElement := values[0].(*binXMLElement); isElement {
+			return element.jsonValue()
+		}
+		return values[0]
+	}
+	var text strings.Builder
+	for _, value := range values {
+		if element, isElement := value.(*binXMLElement); isElement {
+			_, _ = fmt.Fprint(&text, element.jsonValue())
+			continue
+		}
+		_, _ = fmt.Fprint(&text, value)
+	}
+	return text.String()
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// evtxBuilder writes the binary XML of a chunk, keeping track of offsets in the chunk the way names and templates
+// refer to them.
+type evtxBuilder struct {
+	bytes.Buffer
+}
+
+func (builder *evtxBuilder) uint16(value uint16) {
+	_ = binary.Write(builder, binary.LittleEndian, value)
+}
+
+func (builder *evtxBuilder) uint32(value uint32) {
+	_ = binary.Write(builder, binary.LittleEndian, value)
+}
+
+func (builder *evtxBuilder) utf16(value string) {
+	for _, character := range utf16.Encode([]rune(value)) {
+		builder.uint16(character)
+	}
+}
+
+// name writes the offset of a name followed by the name itself, as where a name is first used.
+func (builder *evtxBuilder) name(name string) {
+	builder.uint32(uint32(builder.Len() + 4))
+	builder.uint32(0) // the offset of the next name
+	builder.uint16(0) // its hash
+	builder.uint16(uint16(len(name)))
+	builder.utf16(name)
+	builder.uint16(0)
+}
+
+// element writes an element with its attributes, each written by a function, and its content.
+func (builder *evtxBuilder) element(name string, attributes map[string]func(), content ...func()) {
+	token := byte(binXMLOpenStartElement)
+	if len(attributes) != 0 {
+		token |= binXMLHasMoreFlag
+	}
+	builder.WriteByte(token)
+	builder.uint16(0xffff) // the dependency identifier
+	builder.uint32(0)      // the size of the element's data
+	builder.name(name)
+	if len(attributes) != 0 {
+		builder.uint32(0) // the size of the attribute list
+		for attributeName, value := range attributes {
+			builder.WriteByte(binXMLAttributeToken)
+			builder.name(attributeName)
+			value()
+		}
+	}
+	if len(content) == 0 {
+		builder.WriteByte(binXMLCloseEmptyElement)
+		return
+	}
+	builder.WriteByte(binXMLCloseStartElement)
+	for _, item := range content {
+		item()
+	}
+	builder.WriteByte(binXMLEndElement)
+}
+
+func (builder *evtxBuilder) text(value string) func() {
+	return func() {
+		builder.WriteByte(binXMLValue)
+		builder.WriteByte(binXMLTypeWString)
+		builder.uint16(uint16(len(value)))
+		builder.utf16(value)
+	}
+}
+
+func (builder *evtxBuilder) substitution(index uint16, optional bool, valueType byte) func() {
+	return func() {
+		token := byte(binXMLNormalSubstitution)
+		if optional {
+			token = binXMLOptionalSubstitute
+		}
+		builder.WriteByte(token)
+		builder.uint16(index)
+		builder.WriteByte(valueType)
+	}
+}
+
+type evtxTestValue struct {
+	valueType byte
+	data      []byte
+}
+
+// templateInstance writes a template instance, with the template's definition when definition is set. It returns
+// where the definition is so later instances can refer to it.
+func (builder *evtxBuilder) templateInstance(definitionOffset uint32, definition func(), values ...evtxTestValue) uint32 {
+	builder.WriteByte(binXMLTemplateInstance)
+	builder.WriteByte(1)
+	builder.uint32(0) // the template's identifier
+	if definition != nil {
+		definitionOffset = uint32(builder.Len() + 4)
+		builder.uint32(definitionOffset)
+		builder.uint32(0)               // the offset of the next template
+		builder.Write(make([]byte, 16)) // its GUID
+		sizeOffset := builder.Len()
+		builder.uint32(0)
+		start := builder.Len()
+		builder.Write([]byte{binXMLFragmentHeader, 1, 1, 0})
+		definition()
+		builder.WriteByte(binXMLEndOfFragment)
+		binary.LittleEndian.PutUint32(builder.Bytes()[sizeOffset:], uint32(builder.Len()-start))
+	} else {
+		builder.uint32(definitionOffset)
+	}
+	builder.uint32(uint32(len(values)))
+	for _, value := range values {
+		builder.uint16(uint16(len(value.data)))
+		builder.WriteByte(value.valueType)
+		builder.WriteByte(0)
+	}
+	for _, value := range values {
+		builder.Write(value.data)
+	}
+	return definitionOffset
+}
+
+// record writes an event record around the binary XML fragment written by fragment.
+func (builder *evtxBuilder) record(recordID uint64, timestamp time.Time, fragment func()) {
+	start := builder.Len()
+	builder.uint32(evtxRecordSignature)
+	builder.uint32(0)
+	_ = binary.Write(builder, binary.LittleEndian, recordID)
+	_ = binary.Write(builder, binary.LittleEndian, uint64(timestamp.Unix())*10000000+116444736000000000)
+	builder.Write([]byte{binXMLFragmentHeader, 1, 1, 0})
+	fragment()
+	builder.WriteByte(binXMLEndOfFragment)
+	builder.uint32(uint32(builder.Len() - start + 4))
+	binary.LittleEndian.PutUint32(builder.Bytes()[start+4:], uint32(builder.Len()-start))
+}
+
+func evtxUint16(value uint16) evtxTestValue {
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, value)
+	return evtxTestValue{valueType: binXMLTypeUInt16, data: data}
+}
+
+func evtxString(value string) evtxTestValue {
+	builder := evtxBuilder{}
+	builder.utf16(value)
+	return evtxTestValue{valueType: binXMLTypeWString, data: builder.Bytes()}
+}
+
+// testEvtxFile is an .evtx file with a chunk of two logon events that share a template, followed by a chunk cut short
+// the way a log that was still being written can be.
+func testEvtxFile() []byte {
+	chunk := evtxBuilder{}
+	chunk.WriteString("ElfChnk\x00")
+	chunk.Write(make([]byte, evtxChunkHeaderSize-chunk.Len()))
+	definition := func() {
+		chunk.element("Event", nil,
+			func() {
+				chunk.element("System", nil,
+					func() {
+						chunk.element("EventID", map[string]func(){"Qualifiers": chunk.substitution(1, true, binXMLTypeUInt16)}, chunk.substitution(0, false, binXMLTypeUInt16))
+					},
+					func() { chunk.element("Computer", nil, chunk.substitution(2, false, binXMLTypeWString)) },
+					func() { chunk.element("Channel", nil, chunk.text("Security")) },
+				)
+			},
+			func() {
+				chunk.element("EventData", nil,
+					func() {
+						chunk.element("Data", map[string]func(){"Name": chunk.text("TargetUserName")}, chunk.substitution(3, false, binXMLTypeWString))
+					},
+					func() {
+						chunk.element("Data", map[string]func(){"Name": chunk.text("LogonType")}, chunk.substitution(4, false, binXMLTypeUInt16))
+					},
+				)
+			},
+		)
+	}
+	var definitionOffset uint32
+	chunk.record(1, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), func() {
+		definitionOffset = chunk.templateInstance(0, definition, evtxUint16(4624), evtxTestValue{}, evtxString("HOST"), evtxString("alice"), evtxUint16(2))
+	})
+	chunk.record(2, time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC), func() {
+		chunk.templateInstance(definitionOffset, nil, evtxUint16(4625), evtxUint16(0), evtxString("HOST"), evtxString("bob"), evtxUint16(3))
+	})
+	chunkBytes := append(chunk.Bytes(), make([]byte, evtxChunkSize-chunk.Len())...)
+	binary.LittleEndian.PutUint32(chunkBytes[0x30:], uint32(chunk.Len()))
+
+	file := append([]byte("ElfFile\x00"), make([]byte, evtxFileHeaderSize-8)...)
+	file = append(file, chunkBytes...)
+	return append(file, chunkBytes[:evtxChunkSize/2]...)
+}
+
+func TestEvtxJSONProcessor_Process(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "logon events",
+			input: testEvtxFile(),
+			want: `{"record_id":1,"timestamp":"2020-01-02T03:04:05Z","Event":{"EventData":{"LogonType":2,"TargetUserName":"alice"},"System":{"Channel":"Security","Computer":"HOST","EventID":4624}}}
+{"record_id":2,"timestamp":"2020-01-02T03:04:06Z","Event":{"EventData":{"LogonType":3,"TargetUserName":"bob"},"System":{"Channel":"Security","Computer":"HOST","EventID":{"#attributes":{"Qualifiers":0},"#text":4625}}}}
+`,
+		},
+		{name: "not an evtx", input: bytes.Repeat([]byte{'a'}, evtxFileHeaderSize), wantErr: true},
+		{name: "empty", input: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := new(bytes.Buffer)
+			err := EvtxJSONProcessor{}.Process(context.Background(), loggerOrDefault(nil), bytes.NewReader(tt.input), output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := output.String(); got != tt.want {
+				t.Errorf("Process() wrote\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvtxJSONProcessor_OutputPath(t *testing.T) {
+	tests := []struct {
+		fullPath string
+		want     string
+	}{
+		{fullPath: `c:\windows\system32\winevt\logs\Security.evtx`, want: `c:\windows\system32\winevt\logs\Security.evtx.jsonl`},
+		{fullPath: `c:\windows\system32\winevt\logs\System.EVTX`, want: `c:\windows\system32\winevt\logs\System.EVTX.jsonl`},
+		{fullPath: `c:\windows\system32\config\SYSTEM`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fullPath, func(t *testing.T) {
+			if got := (EvtxJSONProcessor{}).OutputPath(tt.fullPath); got != tt.want {
+				t.Errorf("OutputPath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_binXMLValueOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		valueType byte
+		data      []byte
+		want      interface{}
+	}{
+		{name: "sid", valueType: binXMLTypeSID, data: []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}, want: "S-1-5-18"},
+		{name: "guid", valueType: binXMLTypeGUID, data: []byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 1, 2, 3, 4, 5, 6, 7, 8}, want: "{12345678-1234-5678-0102-030405060708}"},
+		{name: "hex int64", valueType: binXMLTypeHexInt64, data: []byte{0xe7, 0x03, 0, 0, 0, 0, 0, 0}, want: "0x3e7"},
+		{name: "ansi string", valueType: binXMLTypeString, data: []byte("abc\x00"), want: "abc"},
+		{name: "short", valueType: binXMLTypeUInt32, data: []byte{1, 2}, want: "0102"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := binXMLValueOf(tt.valueType, tt.data); got != tt.want {
+				t.Errorf("binXMLValueOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	values := binXMLValueOf(binXMLTypeWString|binXMLTypeArray, evtxString("a\x00b\x00").data).([]interface{})
+	if len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Errorf("binXMLValueOf() = %v for an array of strings, want [a b]", values)
+	}
+}
+
+func Test_readEvtx_each(t *testing.T) {
+	var records []uint64
+	skipped, err := readEvtx(bytes.NewReader(testEvtxFile()), func(record evtxRecord) error {
+		records = append(records, record.RecordID)
+		return nil
+	})
+	if err != nil || skipped != 0 || len(records) != 2 {
+		t.Errorf("readEvtx() read records %v, skipped %d, error = %v, want records 1 and 2", records, skipped, err)
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractResult is what ExtractArchive found and wrote out.
+type ExtractResult struct {
+	Format       string           // zip or tar
+	Files        []string         // entries written to the output directory
+	Truncated    string           // the entry a cut off archive ends in, which is only written out up to the cut
+	Verification *TarVerification // the hash, index and signature checks, for tar archives and zips with an index
+}
+
+// ExtractArchive expands an archive written by any of this package's result writers into directory, so the files can
+// be worked on without third party tools. Zip entries compressed with a codec added through RegisterCodec are
+// decompressed with its Decompressor. Tar archives are checked with VerifyTarArchive as well, and zips that end with an
+// index with VerifyZipArchive. Entries that fail their hash are still written out but listed as corrupt in the
+// verification. A split output is extracted from its manifest, NAME.parts.json, once its parts are joined and checked
+// with JoinSplitFile.
+func ExtractArchive(path string, directory string, publicKey ed25519.PublicKey) (result ExtractResult, err error) {
+	if strings.HasSuffix(strings.ToLower(path), SplitManifestExtension) {
+		var joined string
+		joined, err = joinToTemporaryFile(path)
+		if err != nil {
+			err = fmt.Errorf("ExtractArchive() failed to join the parts of %s: %w", path, err)
+			return
+		}
+		defer os.Remove(joined)
+		path = joined
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		err = fmt.Errorf("ExtractArchive() failed to open %s: %w", path, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(directory, 0755)
+	if err != nil {
+		err = fmt.Errorf("ExtractArchive() failed to create %s: %w", directory, err)
+		return
+	}
+
+	magic := make([]byte, 4)
+	_, _ = io.ReadFull(file, magic)
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	if bytes.Equal(magic, []byte("PK\x03\x04")) || bytes.Equal(magic, []byte("PK\x05\x06")) {
+		result.Format = "zip"
+		result.Files, err = extractZip(file, info.Size(), directory)
+		if err != nil {
+			return
+		}
+		verification, verifyErr := VerifyZipArchive(file, info.Size(), publicKey)
+		if verification.IndexFound || verifyErr != nil {
+			result.Verification, err = &verification, verifyErr
+		} else if publicKey != nil {
+			err = errors.New("ExtractArchive() was given a public key for a zip without a signed index")
+		}
+		return
+	}
+
+	result.Format = "tar"
+	result.Files, result.Truncated, err = extractTar(file, directory)
+	if err != nil {
+		return
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	verification, err := VerifyTarArchive(file, publicKey)
+	result.Verification = &verification
+	return
+}
+
+func extractZip(reader io.ReaderAt, size int64, directory string) (files []string, err error) {
+	zipReader, err := zip.NewReader(reader, size)
+	if err != nil {
+		err = fmt.Errorf("ExtractArchive() failed to read the zip: %w", err)
+		return
+	}
+	for _, codec := range registeredDecompressors() {
+		zipReader.RegisterDecompressor(codec.Method, codec.Decompressor)
+	}
+	for _, entry := range zipReader.File {
+		var entryReader io.ReadCloser
+		entryReader, err = entry.Open()
+		if err != nil {
+			err = fmt.Errorf("ExtractArchive() failed to open '%s': %w", entry.Name, err)
+			return
+		}
+		err = extractEntry(directory, entry.Name, entryReader)
+		entryReader.Close()
+		if err != nil {
+			return
+		}
+		files = append(files, entry.Name)
+	}
+	return
+}
+
+func extractTar(reader io.Reader, directory string) (files []string, truncated string, err error) {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, nextErr := tarReader.Next()
+		if nextErr == io.EOF {
+			break
+		} else if nextErr != nil {
+			// A truncated stream still gets everything up to the cut, VerifyTarArchive reports the truncation
+			break
+		}
+		err = extractEntry(directory, header.Name, tarReader)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The stream ends inside this entry, what was there of it is kept
+			files = append(files, header.Name)
+			truncated = header.Name
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+		files = append(files, header.Name)
+	}
+	return
+}
+
+// extractEntry writes a single entry into directory, creating the directories in its name. Zip entries are kept under
+// their original directories and tar entries are flat, so an entry whose name could put it outside of directory didn't
+// come from this package and is refused.
+func extractEntry(directory string, name string, reader io.Reader) (err error) {
+	if !isSafeEntryName(name) {
+		err = fmt.Errorf("ExtractArchive() refused to extract '%s', entries from this package never leave the output directory", name)
+		return
+	}
+	outputPath := filepath.Join(directory, filepath.FromSlash(name))
+	err = os.MkdirAll(filepath.Dir(outputPath), 0755)
+	if err != nil {
+		err = fmt.Errorf("ExtractArchive() failed to create the directory for '%s': %w", name, err)
+		return
+	}
+	output, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		err = fmt.Errorf("ExtractArchive() failed to create '%s': %w", name, err)
+		return
+	}
+	_, err = io.Copy(output, reader)
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("ExtractArchive() failed to write '%s': %w", name, err)
+	}
+	return
+}
+
+// isSafeEntryName reports whether an entry name is a relative path made of plain file and directory names.
+func isSafeEntryName(name string) bool {
+	if name == "" || strings.ContainsAny(name, `\:`) || path.IsAbs(name) {
+		return false
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == "" || element == "." || element == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// joinToTemporaryFile joins the parts of a split output into a temporary file, returning its path.
+func joinToTemporaryFile(manifestPath string) (path string, err error) {
+	joined, err := ioutil.TempFile("", "joined-*")
+	if err != nil {
+		return
+	}
+	path = joined.Name()
+	_, err = JoinSplitFile(manifestPath, joined)
+	if closeErr := joined.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		path = ""
+	}
+	return
+}
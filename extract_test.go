@@ -0,0 +1,158 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func writeTestZip(t *testing.T) []byte {
+	_ = RegisterCodec(bestCompressionCodec)
+	output := new(bytes.Buffer)
+	zipResultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output), Codec: "store"}
+	fileReaders := make(chan fileReader, 2)
+	fileReaders <- fileReader{fullPath: `c:\stored`, reader: bytes.NewReader([]byte("stored data"))}
+	fileReaders <- fileReader{fullPath: `c:\compressed`, reader: bytes.NewReader([]byte("compressed data")), codec: "deflate-best"}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	if err := zipResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+		t.Fatalf("ZipResultWriter.ResultWriter() error = %v", err)
+	}
+	return output.Bytes()
+}
+
+func writeMaliciousZip() []byte {
+	output := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(output)
+	writer, _ := zipWriter.Create("../outside")
+	_, _ = writer.Write([]byte("escaped"))
+	zipWriter.Close()
+	return output.Bytes()
+}
+
+func TestExtractArchive(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	fullTar := writeTestTar(t, nil)
+	truncatedTar := fullTar[:bytes.Index(fullTar, []byte("regf system hive"))+len("regf sys")]
+	tests := []struct {
+		name          string
+		archive       []byte
+		publicKey     ed25519.PublicKey
+		wantFormat    string
+		wantFiles     []string
+		wantTruncated string
+		wantContents  map[string]string
+		wantVerified  bool
+		wantErr       bool
+	}{
+		{
+			name:         "signed tar",
+			archive:      writeTestTar(t, privateKey),
+			publicKey:    publicKey,
+			wantFormat:   "tar",
+			wantFiles:    []string{"c__windows_system32_config_system", "c__$mft", tarIndexFileName, tarSignatureFileName},
+			wantContents: map[string]string{"c__windows_system32_config_system": "regf system hive"},
+			wantVerified: true,
+			wantErr:      false,
+		},
+		{
+			name:          "truncated tar",
+			archive:       truncatedTar,
+			wantFormat:    "tar",
+			wantFiles:     []string{"c__windows_system32_config_system"},
+			wantTruncated: "c__windows_system32_config_system",
+			wantContents:  map[string]string{"c__windows_system32_config_system": "regf sys"},
+			wantErr:       false,
+		},
+		{
+			name:         "zip with a registered codec",
+			archive:      writeTestZip(t),
+			wantFormat:   "zip",
+			wantFiles:    []string{"c/stored", "c/compressed"},
+			wantContents: map[string]string{"c/stored": "stored data", "c/compressed": "compressed data"},
+			wantErr:      false,
+		},
+		{
+			name:       "entry outside the output directory",
+			archive:    writeMaliciousZip(),
+			wantFormat: "zip",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "gofor-extract-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(directory)
+			archivePath := filepath.Join(directory, "collection")
+			if err = ioutil.WriteFile(archivePath, tt.archive, 0644); err != nil {
+				t.Fatal(err)
+			}
+			outputDirectory := filepath.Join(directory, "output")
+
+			got, err := ExtractArchive(archivePath, outputDirectory, tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Format != tt.wantFormat {
+				t.Errorf("ExtractArchive() format = %s, want %s", got.Format, tt.wantFormat)
+			}
+			if tt.wantErr {
+				if _, statErr := os.Stat(filepath.Join(directory, "outside")); statErr == nil {
+					t.Error("ExtractArchive() wrote a file outside of the output directory")
+				}
+				return
+			}
+			if !reflect.DeepEqual(got.Files, tt.wantFiles) {
+				t.Errorf("ExtractArchive() files = %v, want %v", got.Files, tt.wantFiles)
+			}
+			if got.Truncated != tt.wantTruncated {
+				t.Errorf("ExtractArchive() truncated = %q, want %q", got.Truncated, tt.wantTruncated)
+			}
+			for name, want := range tt.wantContents {
+				data, _ := ioutil.ReadFile(filepath.Join(outputDirectory, name))
+				if !bytes.HasPrefix(data, []byte(want)) {
+					t.Errorf("ExtractArchive() wrote %q to %s, want %q", data, name, want)
+				}
+			}
+			if tt.wantVerified && (got.Verification == nil || !got.Verification.Signed || !got.Verification.Complete) {
+				t.Errorf("ExtractArchive() verification = %+v, want a complete signed archive", got.Verification)
+			}
+		})
+	}
+}
+
+func Test_isSafeEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "c/windows/system32/config/sam", want: true},
+		{name: "c__windows_system32_config_sam", want: true},
+		{name: "../outside", want: false},
+		{name: "c/../../outside", want: false},
+		{name: "/etc/passwd", want: false},
+		{name: `c:\windows\file`, want: false},
+		{name: "c//file", want: false},
+		{name: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSafeEntryName(tt.name); got != tt.want {
+				t.Errorf("isSafeEntryName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
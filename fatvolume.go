@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+const (
+	fileSystemNTFS  = "NTFS"
+	fileSystemFAT   = "FAT"
+	fileSystemFAT32 = "FAT32"
+	fileSystemExFAT = "exFAT"
+)
+
+// collectVolumeByWalking collects from a FAT or exFAT volume, such as a USB drive or an EFI system partition, which has
+// no MFT to search.
+func (collector *Collector) collectVolumeByWalking(ctx context.Context, volumeLetter string, fileSystem string, fileReaders chan fileReader, searchTerms listOfSearchTerms) (err error) {
+	collector.logger().Warnf("Volume %s is %s, collecting from it by walking its directories instead of reading an MFT.", volumeLetter, fileSystem)
+	collector.report.addWalkedVolume(volumeLetter, fileSystem)
+	root, err := volumeRoot(volumeLetter)
+	if err != nil {
+		return
+	}
+	err = collector.walkVolume(ctx, volumeLetter, root, fileReaders, searchTerms)
+	return
+}
+
+// walkVolume collects from a volume through the API, reaching its files through root. Literal paths are opened as they
+// are and regex targets are searched for by walking the directories under the literal start of their regex, or the
+// whole volume. Targets sweeping for extensions walk the directory they name. NTFS metadata files are left out since they can only be read raw.
+func (collector *Collector) walkVolume(ctx context.Context, volumeLetter string, root string, fileReaders chan fileReader, searchTerms listOfSearchTerms) (err error) {
+	name := volumeName(volumeLetter)
+	regexTerms := make(map[string]listOfSearchTerms)
+	var directories, paths []string
+	for _, term := range searchTerms {
+		if !isSearchTermOnVolume(term, volumeLetter) {
+			continue
+		}
+		if strings.HasPrefix(term.fileNameString, "$") {
+			collector.logger().Debugf("Leaving out '%s', NTFS metadata files can only be read from the raw volume.", term.fullPathString)
+			continue
+		}
+		if term.recordNumber != 0 {
+			collector.logger().Debugf("Leaving out record %d of volume %s, there are no MFT records without NTFS.", term.recordNumber, volumeLetter)
+			continue
+		}
+		if term.fullPathRegex == nil && term.extensions == nil {
+			paths = append(paths, term.fullPathString)
+			continue
+		}
+		directory := ""
+		if term.extensions != nil {
+			directory = strings.TrimPrefix(term.fullPathString+`\`, name)
+		} else if prefix, _ := term.fullPathRegex.LiteralPrefix(); strings.HasPrefix(prefix, name) {
+			directory = prefix[len(name) : strings.LastIndex(prefix, `\`)+1]
+		}
+		if _, ok := regexTerms[directory]; !ok {
+			directories = append(directories, directory)
+		}
+		regexTerms[directory] = append(regexTerms[directory], term)
+	}
+	for _, directory := range directories {
+		paths = append(paths, findInDirectoryAs(root+directory, name+directory, regexTerms[directory])...)
+	}
+	err = collector.collectPaths(ctx, volumeLetter, paths, fileReaders, searchTerms, func(path string) (reader io.Reader, method string, err error) {
+		file, err := openWithBackupSemantics(root + strings.TrimPrefix(path, name))
+		if err != nil {
+			return
+		}
+		return &closingReader{file: file}, readMethodAPI, nil
+	})
+	return
+}
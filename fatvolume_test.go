@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testBootRecord is a 512 byte volume boot record with name written at offset.
+func testBootRecord(offset int, name string) []byte {
+	bootRecord := make([]byte, 512)
+	copy(bootRecord[offset:], name)
+	return bootRecord
+}
+
+func Test_fileSystemOf(t *testing.T) {
+	tests := []struct {
+		name       string
+		bootRecord []byte
+		want       string
+	}{
+		{name: "ntfs", bootRecord: testBootRecord(0x03, "NTFS    "), want: fileSystemNTFS},
+		{name: "bitlocker", bootRecord: testBootRecord(0x03, "-FVE-FS-"), want: fileSystemBitLocker},
+		{name: "exfat", bootRecord: testBootRecord(0x03, "EXFAT   "), want: fileSystemExFAT},
+		{name: "fat32", bootRecord: testBootRecord(0x52, "FAT32   "), want: fileSystemFAT32},
+		{name: "fat16", bootRecord: testBootRecord(0x36, "FAT16   "), want: fileSystemFAT},
+		{name: "unknown", bootRecord: make([]byte, 512)},
+		{name: "truncated", bootRecord: []byte("\xeb\x52\x90NTFS    ")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fileSystemOf(tt.bootRecord); got != tt.want {
+				t.Errorf("fileSystemOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectWithReport_fatVolume(t *testing.T) {
+	bootRecord, err := ioutil.TempFile("", "fat32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(bootRecord.Name())
+	bootRecord.Write(testBootRecord(0x52, "FAT32   "))
+	bootRecord.Close()
+
+	_, err = GetVolumeHandler("e", dummyHandler{filePath: bootRecord.Name()})
+	var fileSystemError *FileSystemError
+	if !errors.As(err, &fileSystemError) || fileSystemError.FileSystem != fileSystemFAT32 {
+		t.Fatalf("GetVolumeHandler() error = %v, want a FileSystemError for FAT32", err)
+	}
+
+	defer func(original func(path string) (*os.File, error)) { openWithBackupSemantics = original }(openWithBackupSemantics)
+	openWithBackupSemantics = func(path string) (file *os.File, err error) {
+		return os.Open(bootRecord.Name())
+	}
+	collection := NewCollector(CollectOptions{})
+	collection.volumes = dummyHandler{filePath: bootRecord.Name()}
+	targets := ListOfFilesToExport{
+		{FullPath: `e:\$MFT`, FileName: `$MFT`},
+		{FullPath: `e:\evidence\notes.txt`, FileName: `notes.txt`},
+	}
+	report, err := collection.CollectWithReport(context.Background(), targets, &ZipResultWriter{ZipWriter: zip.NewWriter(new(bytes.Buffer))})
+	if err != nil {
+		t.Fatalf("Collector.CollectWithReport() error = %v", err)
+	}
+	if report.FilesCollected != 1 {
+		t.Errorf("Collector.CollectWithReport() collected %d files, want notes.txt", report.FilesCollected)
+	}
+	if len(report.Volumes) != 1 || report.Volumes[0].FileSystem != fileSystemFAT32 {
+		t.Errorf("Collector.CollectWithReport() report volumes = %+v, want e as FAT32", report.Volumes)
+	}
+}
@@ -6,14 +6,19 @@ import (
 	"errors"
 	"fmt"
 	mft "github.com/Go-Forensics/MFT-Parser"
-	log "github.com/sirupsen/logrus"
 	"io"
 	"strings"
 )
 
 type possibleMatch struct {
-	fileNameAttribute mft.FileNameAttribute
-	dataRuns          mft.DataRuns
+	fileNameAttribute  mft.FileNameAttribute
+	fileNameAttributes mft.FileNameAttributes // every long name of the file, more than one when it has hard links
+	dataRuns           mft.DataRuns
+	resident           bool        // the file's data is in its MFT record rather than in data runs
+	residentData       []byte      // the file's data when it is resident
+	stream             *ntfsStream // set when the file is sparse or compressed
+	metadata           recordMetadata
+	deleted            bool // the file was matched through a deleted MFT record
 }
 
 type possibleMatches []possibleMatch
@@ -22,8 +27,11 @@ type mftRecordVolumeOffsetTracker map[uint32]int64
 
 type mftRecordWithNonResidentAttributes struct {
 	fnAttribute             mft.FileNameAttribute
+	fnAttributes            mft.FileNameAttributes
 	dataAttribute           mft.DataAttribute
 	attributeListAttributes mft.AttributeListAttributes
+	metadata                recordMetadata
+	deleted                 bool
 }
 
 type listOfMftRecordWithNonResidentAttributes []mftRecordWithNonResidentAttributes
@@ -42,14 +50,24 @@ func checkForPossibleMatch(listOfSearchKeywords listOfSearchTerms, fileNameAttri
 	for _, attribute := range fileNameAttributes {
 		if strings.Contains(attribute.FileNamespace, "WIN32") == true || strings.Contains(attribute.FileNamespace, "POSIX") {
 			for _, value := range listOfSearchKeywords {
-				if value.fileNameRegex != nil {
-					if value.fileNameRegex.MatchString(strings.ToLower(attribute.FileName)) == true {
+				fileName := strings.ToLower(attribute.FileName)
+				if value.caseSensitive {
+					fileName = attribute.FileName
+				}
+				if value.extensions != nil {
+					if value.sweepsName(fileName) {
+						result = true
+						fileNameAttribute = attribute
+						return
+					}
+				} else if value.fileNameRegex != nil {
+					if value.fileNameRegex.MatchString(fileName) == true {
 						result = true
 						fileNameAttribute = attribute
 						return
 					}
 				} else {
-					if value.fileNameString == strings.ToLower(attribute.FileName) {
+					if value.fileNameString == fileName {
 						result = true
 						fileNameAttribute = attribute
 						return
@@ -64,13 +82,12 @@ func checkForPossibleMatch(listOfSearchKeywords listOfSearchTerms, fileNameAttri
 }
 
 func findPossibleMatches(volumeHandler *VolumeHandler, listOfSearchKeywords listOfSearchTerms) (listOfPossibleMatches possibleMatches, directoryTree mft.DirectoryTree, err error) {
-	log.Debugf("Starting to scan the MFT's dataruns to create a tree of directories and to search for the for the following search terms: %+v", listOfSearchKeywords)
+	volumeHandler.logger().Debugf("Starting to scan the MFT's dataruns to create a tree of directories and to search for the for the following search terms: %+v", listOfSearchKeywords)
 
 	// Init memory
 	unresolvedDirectorTree := make(mft.UnresolvedDirectoryTree)
-	listOfPossibleMatches = make(possibleMatches, 0)
+	search := newMftSearch(volumeHandler, listOfSearchKeywords)
 	recordOffsetTracker := make(mftRecordVolumeOffsetTracker)
-	listOfMftRecordWithNonResidentAttributes := make(listOfMftRecordWithNonResidentAttributes, 0)
 
 	for err != io.EOF {
 		buffer := mft.RawMasterFileTableRecord(make([]byte, volumeHandler.Vbr.MftRecordSize))
@@ -78,6 +95,9 @@ func findPossibleMatches(volumeHandler *VolumeHandler, listOfSearchKeywords list
 		if err == io.EOF {
 			err = nil
 			break
+		} else if err != nil {
+			err = fmt.Errorf("failed to read the mft: %w", err)
+			return
 		}
 
 		result, _ := buffer.IsThisAnMftRecord()
@@ -87,8 +107,10 @@ func findPossibleMatches(volumeHandler *VolumeHandler, listOfSearchKeywords list
 
 		result, err = buffer.IsThisADirectory()
 		if result == true {
-			unresolvedDirectory, _ := mft.ConvertRawMFTRecordToDirectory(buffer)
+			unresolvedDirectory, _ := convertRecordToDirectory(buffer)
 			unresolvedDirectorTree[unresolvedDirectory.RecordNumber] = unresolvedDirectory
+			volumeHandler.timeline.addDirectory(buffer, volumeHandler.Vbr.BytesPerCluster)
+			volumeHandler.directoryExport.addDirectory(buffer, volumeHandler.Vbr.BytesPerCluster)
 			recordOffsetTracker[unresolvedDirectory.RecordNumber] = volumeHandler.lastReadVolumeOffset
 		} else {
 			// Parse what we need out of the entry for us to copy the file
@@ -96,127 +118,330 @@ func findPossibleMatches(volumeHandler *VolumeHandler, listOfSearchKeywords list
 			recordHeader, _ := rawRecordHeader.Parse()
 			recordOffsetTracker[recordHeader.RecordNumber] = volumeHandler.lastReadVolumeOffset
 			rawAttributes, _ := buffer.GetRawAttributes(recordHeader)
-			fileNameAttributes, _, dataAttribute, attributeListAttributes, _ := rawAttributes.Parse(volumeHandler.Vbr.BytesPerCluster)
-			result, fileNameAttribute, err := checkForPossibleMatch(listOfSearchKeywords, fileNameAttributes)
-			if err != nil || result == false {
-				continue
+			fileNameAttributes, standardInformation, dataAttribute, attributeListAttributes, _ := rawAttributes.Parse(volumeHandler.Vbr.BytesPerCluster)
+			fileNameAttributes = withDecodedFileNames(buffer, recordHeader, fileNameAttributes)
+			volumeHandler.inspector.inspectRecord(recordHeader, fileNameAttributes, standardInformation, dataAttribute)
+			volumeHandler.timeline.addRecord(buffer, recordHeader, fileNameAttributes, standardInformation)
+			err = volumeHandler.cacheBuilder.addFile(buffer, fileNameAttributes)
+			if err != nil {
+				err = fmt.Errorf("failed to cache the mft: %w", err)
+				return
 			}
+			search.checkFileRecord(buffer, recordHeader, fileNameAttributes, standardInformation, dataAttribute, attributeListAttributes, volumeHandler.lastReadVolumeOffset)
+		}
+	}
 
-			if attributeListAttributes == nil {
-				log.Debugf("Found a possible match. File name is '%s' and its MFT offset is %d. Here is the MFT record hex: %x", fileNameAttribute.FileName, volumeHandler.lastReadVolumeOffset, []byte(buffer))
-				aPossibleMatch := possibleMatch{
-					fileNameAttribute: fileNameAttribute,
-					dataRuns:          dataAttribute.NonResidentDataAttribute.DataRuns,
-				}
-				listOfPossibleMatches = append(listOfPossibleMatches, aPossibleMatch)
-				continue
-			} else {
-				log.Debugf("Found a possible match which has an attribute list. File name is '%s' and its MFT offset is %d. Here is the attribute list: %+v Here is the MFT record hex: %x", fileNameAttribute.FileName, volumeHandler.lastReadVolumeOffset, attributeListAttributes, buffer)
-				trackThisForLater := mftRecordWithNonResidentAttributes{
-					fnAttribute:             fileNameAttribute,
-					dataAttribute:           dataAttribute,
-					attributeListAttributes: attributeListAttributes,
-				}
-				listOfMftRecordWithNonResidentAttributes = append(listOfMftRecordWithNonResidentAttributes, trackThisForLater)
-				continue
-			}
+	listOfPossibleMatches = search.resolveAttributeLists(recordOffsetTracker)
+
+	volumeHandler.logger().Debugf("Resolving %d directories we found to build their full paths.", len(unresolvedDirectorTree))
+	directoryTree, _ = unresolvedDirectorTree.Resolve(volumeHandler.VolumeLetter)
+	volumeHandler.logger().Debugf("Successfully resolved %d directories.", len(directoryTree))
+	volumeHandler.cacheBuilder.finish(recordOffsetTracker, directoryTree)
+	volumeHandler.recordOffsets = recordOffsetTracker
+	return
+}
+
+// mftSearch gathers the possible matches among the file records of an MFT, whether they're read from the volume or
+// from an MFTCache.
+type mftSearch struct {
+	volumeHandler                            *VolumeHandler
+	listOfSearchKeywords                     listOfSearchTerms
+	listOfPossibleMatches                    possibleMatches
+	listOfMftRecordWithNonResidentAttributes listOfMftRecordWithNonResidentAttributes
+}
+
+func newMftSearch(volumeHandler *VolumeHandler, listOfSearchKeywords listOfSearchTerms) *mftSearch {
+	return &mftSearch{
+		volumeHandler:                            volumeHandler,
+		listOfSearchKeywords:                     listOfSearchKeywords,
+		listOfPossibleMatches:                    make(possibleMatches, 0),
+		listOfMftRecordWithNonResidentAttributes: make(listOfMftRecordWithNonResidentAttributes, 0),
+	}
+}
+
+// checkFileRecord keeps a file record as a possible match if one of its names matches a search term. Records with an
+// attribute list are kept aside until resolveAttributeLists can read the records their data is spread over.
+func (search *mftSearch) checkFileRecord(buffer mft.RawMasterFileTableRecord, recordHeader mft.RecordHeader, fileNameAttributes mft.FileNameAttributes, standardInformation mft.StandardInformationAttribute, dataAttribute mft.DataAttribute, attributeListAttributes mft.AttributeListAttributes, volumeOffset int64) {
+	volumeHandler := search.volumeHandler
+	if recordHeader.Flags.FlagDeleted && !volumeHandler.recoverDeleted {
+		return
+	}
+	result, fileNameAttribute, err := checkForPossibleMatch(search.listOfSearchKeywords, fileNameAttributes)
+	if err == nil && result == false {
+		result, fileNameAttribute = search.listOfSearchKeywords.selectRecord(volumeHandler.VolumeLetter, recordHeader.RecordNumber, fileNameAttributes)
+	}
+	if err != nil || result == false {
+		return
+	}
+
+	if attributeListAttributes == nil {
+		search.volumeHandler.logger().Debugf("Found a possible match. File name is '%s' and its MFT offset is %d. Here is the MFT record hex: %x", fileNameAttribute.FileName, volumeOffset, []byte(buffer))
+		aPossibleMatch := possibleMatch{
+			fileNameAttribute:  fileNameAttribute,
+			fileNameAttributes: longFileNames(fileNameAttributes),
+			dataRuns:           dataAttribute.NonResidentDataAttribute.DataRuns,
+			metadata:           newRecordMetadata(buffer, recordHeader, standardInformation, fileNameAttribute),
+			deleted:            recordHeader.Flags.FlagDeleted,
+		}
+		if len(aPossibleMatch.dataRuns) == 0 {
+			aPossibleMatch.residentData, aPossibleMatch.resident = residentData(buffer, recordHeader.AttributesOffset)
+		} else if stream, found := metafileStream(buffer, recordHeader, aPossibleMatch.dataRuns); found {
+			aPossibleMatch.stream = &stream
+		} else if stream, _, found := parseNonResidentData(buffer, recordHeader.AttributesOffset); found && stream.needsStreamReader() {
+			aPossibleMatch.stream = &stream
 		}
+		search.listOfPossibleMatches = append(search.listOfPossibleMatches, aPossibleMatch)
+		return
+	}
+	search.volumeHandler.logger().Debugf("Found a possible match which has an attribute list. File name is '%s' and its MFT offset is %d. Here is the attribute list: %+v Here is the MFT record hex: %x", fileNameAttribute.FileName, volumeOffset, attributeListAttributes, buffer)
+	trackThisForLater := mftRecordWithNonResidentAttributes{
+		fnAttribute:             fileNameAttribute,
+		fnAttributes:            longFileNames(fileNameAttributes),
+		dataAttribute:           dataAttribute,
+		attributeListAttributes: attributeListAttributes,
+		metadata:                newRecordMetadata(buffer, recordHeader, standardInformation, fileNameAttribute),
+		deleted:                 recordHeader.Flags.FlagDeleted,
 	}
+	search.listOfMftRecordWithNonResidentAttributes = append(search.listOfMftRecordWithNonResidentAttributes, trackThisForLater)
+}
 
-	// Resolve the possible matches that had attribute lists
-	if len(listOfMftRecordWithNonResidentAttributes) != 0 {
-		newVolumeHandle, _ := volumeHandler.GetHandle(volumeHandler.VolumeLetter)
-		for _, record := range listOfMftRecordWithNonResidentAttributes {
-			attributeCounter := 0
-			sizeOfAttributeListAttributes := len(record.attributeListAttributes)
-			dataRuns := make(mft.DataRuns)
-			for attributeCounter < sizeOfAttributeListAttributes {
-				switch record.attributeListAttributes[attributeCounter].Type {
-				case 0x80:
-					nonResidentRecordNumber := record.attributeListAttributes[attributeCounter].MFTReferenceRecordNumber
-					absoluteVolumeOffset := recordOffsetTracker[nonResidentRecordNumber]
-					_, _ = newVolumeHandle.Seek(absoluteVolumeOffset, 0)
-					buffer := mft.RawMasterFileTableRecord(make([]byte, volumeHandler.Vbr.BytesPerCluster))
-					_, _ = newVolumeHandle.Read(buffer)
-					mftRecord, _ := buffer.Parse(volumeHandler.Vbr.BytesPerCluster)
-					log.Debugf("Went to absolute offset %d to get a non resident data attribute with record number %d. Parsed the record for the values %+v. Raw hex: %x", absoluteVolumeOffset, nonResidentRecordNumber, mftRecord, buffer)
-					tempDataRunCounter := 0
-					numberOfDataRuns := len(mftRecord.DataAttribute.NonResidentDataAttribute.DataRuns)
-					for tempDataRunCounter < numberOfDataRuns {
-						index := len(dataRuns)
-						dataRuns[index] = mftRecord.DataAttribute.NonResidentDataAttribute.DataRuns[tempDataRunCounter]
-						tempDataRunCounter++
-					}
-					attributeCounter++
-				default:
-					attributeCounter++
+// resolveAttributeLists reads the extension records of the possible matches that had attribute lists to piece together
+// their data runs, and returns every possible match.
+func (search *mftSearch) resolveAttributeLists(recordOffsetTracker mftRecordVolumeOffsetTracker) (listOfPossibleMatches possibleMatches) {
+	volumeHandler := search.volumeHandler
+	listOfPossibleMatches = search.listOfPossibleMatches
+	if len(search.listOfMftRecordWithNonResidentAttributes) == 0 {
+		return
+	}
+	newVolumeHandle, _ := volumeHandler.GetHandle(volumeHandler.VolumeLetter)
+	for _, record := range search.listOfMftRecordWithNonResidentAttributes {
+		attributeCounter := 0
+		sizeOfAttributeListAttributes := len(record.attributeListAttributes)
+		dataRuns := make(mft.DataRuns)
+		stream := ntfsStream{}
+		mergedRecords := make(map[uint32]bool)
+		for attributeCounter < sizeOfAttributeListAttributes {
+			switch record.attributeListAttributes[attributeCounter].Type {
+			case 0x80:
+				nonResidentRecordNumber := record.attributeListAttributes[attributeCounter].MFTReferenceRecordNumber
+				absoluteVolumeOffset := recordOffsetTracker[nonResidentRecordNumber]
+				_, _ = newVolumeHandle.Seek(absoluteVolumeOffset, 0)
+				buffer := mft.RawMasterFileTableRecord(make([]byte, volumeHandler.Vbr.MftRecordSize))
+				_, _ = newVolumeHandle.Read(buffer)
+				mftRecord, _ := buffer.Parse(volumeHandler.Vbr.BytesPerCluster)
+				search.volumeHandler.logger().Debugf("Went to absolute offset %d to get a non resident data attribute with record number %d. Parsed the record for the values %+v. Raw hex: %x", absoluteVolumeOffset, nonResidentRecordNumber, mftRecord, buffer)
+				if extension, hasSizes, found := parseNonResidentData(buffer, mftRecord.RecordHeader.AttributesOffset); found && !mergedRecords[nonResidentRecordNumber] {
+					mergedRecords[nonResidentRecordNumber] = true
+					stream.merge(extension, hasSizes)
 				}
+				tempDataRunCounter := 0
+				numberOfDataRuns := len(mftRecord.DataAttribute.NonResidentDataAttribute.DataRuns)
+				for tempDataRunCounter < numberOfDataRuns {
+					index := len(dataRuns)
+					dataRuns[index] = mftRecord.DataAttribute.NonResidentDataAttribute.DataRuns[tempDataRunCounter]
+					tempDataRunCounter++
+				}
+				attributeCounter++
+			default:
+				attributeCounter++
 			}
-			aPossibleMatch := possibleMatch{
-				fileNameAttribute: record.fnAttribute,
-				dataRuns:          dataRuns,
-			}
-			log.Debugf("Pieced together a series of non resident data attributes and got the following: %+v", aPossibleMatch)
-			listOfPossibleMatches = append(listOfPossibleMatches, aPossibleMatch)
 		}
+		aPossibleMatch := possibleMatch{
+			fileNameAttribute:  record.fnAttribute,
+			fileNameAttributes: record.fnAttributes,
+			dataRuns:           dataRuns,
+			metadata:           record.metadata,
+			deleted:            record.deleted,
+		}
+		if stream.needsStreamReader() {
+			aPossibleMatch.stream = &stream
+		}
+		search.volumeHandler.logger().Debugf("Pieced together a series of non resident data attributes and got the following: %+v", aPossibleMatch)
+		listOfPossibleMatches = append(listOfPossibleMatches, aPossibleMatch)
 	}
-
-	log.Debugf("Resolving %d directories we found to build their full paths.", len(unresolvedDirectorTree))
-	directoryTree, _ = unresolvedDirectorTree.Resolve(volumeHandler.VolumeLetter)
-	log.Debugf("Successfully resolved %d directories.", len(directoryTree))
 	return
 }
 
 type foundFile struct {
-	dataRuns mft.DataRuns
-	fullPath string
-	fileSize int64
+	dataRuns     mft.DataRuns
+	resident     bool
+	residentData []byte
+	stream       *ntfsStream
+	fullPath     string
+	links        []string // the file's other paths when it has hard links
+	fileSize     int64
+	codec        string
+	processors   ProcessorChain
+	priority     int
+	limits       fileLimits
+	target       int
+	metadata     recordMetadata
+	deleted      bool // matched through a deleted MFT record, so it's written under _deleted
+	readPolicy   ReadPolicy
+	hashes       map[string]bool // the SHA-256 hashes the file has to have one of to be collected
 }
 
 type foundFiles []foundFile
 
-func confirmFoundFiles(listOfSearchKeywords listOfSearchTerms, listOfPossibleMatches possibleMatches, directoryTree mft.DirectoryTree) (foundFilesList foundFiles) {
-	log.Debug("Determining what possible matches are true matches.")
+// totalSize returns the size of the file, falling back to the length of its data runs when the size isn't known.
+func (file foundFile) totalSize() (size int64) {
+	if file.resident {
+		size = int64(len(file.residentData))
+		return
+	}
+	if file.stream != nil {
+		size = file.stream.size
+		return
+	}
+	if file.fileSize != 0 {
+		size = file.fileSize
+		return
+	}
+	for _, dataRun := range file.dataRuns {
+		size += dataRun.Length
+	}
+	return
+}
+
+func confirmFoundFiles(logger Logger, listOfSearchKeywords listOfSearchTerms, listOfPossibleMatches possibleMatches, directoryTree mft.DirectoryTree) (foundFilesList foundFiles) {
+	logger.Debugf("Determining what possible matches are true matches.")
 	foundFilesList = make(foundFiles, 0)
+	var casedFullPaths []string
 	for _, possibleMatch := range listOfPossibleMatches {
-		// First make sure that the parent directory is in the directory tree
-		if _, ok := directoryTree[possibleMatch.fileNameAttribute.ParentDirRecordNumber]; ok {
-			// check against all the list of possible full paths
-			possibleMatchFullPath := fmt.Sprintf(`%s\%s`, strings.ToLower(directoryTree[possibleMatch.fileNameAttribute.ParentDirRecordNumber]), strings.ToLower(possibleMatch.fileNameAttribute.FileName))
-			numberOfSearchTerms := len(listOfSearchKeywords)
-			counter := 0
+		// A file with hard links has a path for each of them and a target may only match one, so check them all
+		possibleMatchFullPaths, possibleMatchCasedPaths := possibleMatch.fullPaths(directoryTree)
+		matched := false
+		for index := range possibleMatchFullPaths {
 			for _, searchTerms := range listOfSearchKeywords {
-				if searchTerms.fullPathRegex != nil {
-					if searchTerms.fullPathRegex.MatchString(possibleMatchFullPath) == true {
-						foundFile := foundFile{
-							dataRuns: possibleMatch.dataRuns,
-							fullPath: possibleMatchFullPath,
-							fileSize: int64(possibleMatch.fileNameAttribute.PhysicalFileSize),
-						}
-						log.Debugf("Found a true match: %+v", foundFile)
-						foundFilesList = append(foundFilesList, foundFile)
-						break
+				paths := possibleMatchFullPaths
+				if searchTerms.caseSensitive {
+					paths = possibleMatchCasedPaths
+				}
+				possibleMatchFullPath := paths[index]
+				if searchTerms.recordNumber != 0 {
+					if searchTerms.recordNumber != possibleMatch.metadata.recordNumber || !strings.HasPrefix(possibleMatchFullPath, searchTerms.fullPathString+`\`) {
+						continue
 					}
-				} else {
-					if searchTerms.fullPathString == possibleMatchFullPath {
-						foundFile := foundFile{
-							dataRuns: possibleMatch.dataRuns,
-							fullPath: possibleMatchFullPath,
-						}
-						log.Debugf("Found a true match: %+v", foundFile)
-						foundFilesList = append(foundFilesList, foundFile)
-						break
+				} else if searchTerms.extensions != nil {
+					if !searchTerms.sweeps(possibleMatchFullPath) {
+						continue
 					}
+				} else if searchTerms.fullPathRegex != nil {
+					if searchTerms.fullPathRegex.MatchString(possibleMatchFullPath) == false {
+						continue
+					}
+				} else if searchTerms.fullPathString != possibleMatchFullPath {
+					continue
+				}
+				if searchTerms.excludes(possibleMatchFullPaths[index]) {
+					logger.Debugf("Leaving out '%s', it's excluded by the target.", possibleMatchFullPath)
+					continue
 				}
-				counter++
-				if counter == numberOfSearchTerms {
-					log.Debugf("The file %s did not end up being a true positive", possibleMatchFullPath)
+				standardInformation := possibleMatch.metadata.standardInformation
+				if !searchTerms.inTimeWindows(standardInformation.SiModified, standardInformation.SiCreated) {
+					logger.Debugf("Leaving out '%s', it's outside the target's time window.", possibleMatchFullPath)
+					continue
 				}
+				foundFile := foundFile{
+					dataRuns:     possibleMatch.dataRuns,
+					resident:     possibleMatch.resident,
+					residentData: possibleMatch.residentData,
+					stream:       possibleMatch.stream,
+					fullPath:     possibleMatchFullPath,
+					codec:        searchTerms.codec,
+					processors:   searchTerms.processors,
+					priority:     searchTerms.priority,
+					limits:       searchTerms.limits,
+					target:       searchTerms.target,
+					metadata:     possibleMatch.metadata,
+					readPolicy:   searchTerms.readPolicy,
+					deleted:      possibleMatch.deleted,
+					hashes:       searchTerms.hashes,
+				}
+				if searchTerms.fullPathRegex != nil || searchTerms.recordNumber != 0 || searchTerms.extensions != nil {
+					foundFile.fileSize = int64(possibleMatch.fileNameAttribute.PhysicalFileSize)
+				}
+				for _, path := range paths {
+					if path != possibleMatchFullPath {
+						foundFile.links = append(foundFile.links, path)
+					}
+				}
+				logger.Debugf("Found a true match: %+v", foundFile)
+				foundFilesList = append(foundFilesList, foundFile)
+				casedFullPaths = append(casedFullPaths, possibleMatchCasedPaths[index])
+				matched = true
+				break
 			}
-		} else {
-			// continue if parent directory is not in the directory tree map
+			if matched {
+				break
+			}
+		}
+		if !matched && len(possibleMatchFullPaths) != 0 {
+			logger.Debugf("The file %s did not end up being a true positive", strings.Join(possibleMatchFullPaths, ", "))
+		}
+	}
+	keepCaseOfCollisions(logger, foundFilesList, casedFullPaths)
+	return
+}
+
+// keepCaseOfCollisions gives files the case of their paths back when different files were found at the same lowercased
+// path, which only happens in a directory made case-sensitive, such as one WSL uses. Otherwise one of them would be
+// collected in place of the other. Deleted files are left alone as a deleted Foo.txt may well share its directory with
+// a live foo.txt.
+func keepCaseOfCollisions(logger Logger, foundFilesList foundFiles, casedFullPaths []string) {
+	byPath := make(map[string][]int)
+	for index, foundFile := range foundFilesList {
+		if !foundFile.deleted {
+			byPath[strings.ToLower(foundFile.fullPath)] = append(byPath[strings.ToLower(foundFile.fullPath)], index)
+		}
+	}
+	for path, indexes := range byPath {
+		collided := false
+		for _, index := range indexes[1:] {
+			if foundFilesList[index].metadata.recordNumber != foundFilesList[indexes[0]].metadata.recordNumber && casedFullPaths[index] != casedFullPaths[indexes[0]] {
+				collided = true
+				break
+			}
+		}
+		if !collided {
 			continue
 		}
+		logger.Infof("Found different files at '%s' whose names only differ in case, the directory is case-sensitive so they're kept in their own case.", path)
+		for _, index := range indexes {
+			foundFilesList[index].fullPath = casedFullPaths[index]
+		}
+	}
+}
+
+// fullPaths returns the path of each of the file's names whose parent directory is in the directory tree, lowercased
+// and in the case they're in on the volume but for its letter.
+func (possibleMatch possibleMatch) fullPaths(directoryTree mft.DirectoryTree) (paths []string, casedPaths []string) {
+	fileNameAttributes := possibleMatch.fileNameAttributes
+	if len(fileNameAttributes) == 0 {
+		fileNameAttributes = mft.FileNameAttributes{possibleMatch.fileNameAttribute}
+	}
+	seen := make(map[string]bool)
+	for _, fileNameAttribute := range fileNameAttributes {
+		directory, ok := directoryTree[fileNameAttribute.ParentDirRecordNumber]
+		if !ok {
+			continue
+		}
+		casedPath := lowerVolume(fmt.Sprintf(`%s\%s`, directory, fileNameAttribute.FileName))
+		if !seen[casedPath] {
+			seen[casedPath] = true
+			paths = append(paths, strings.ToLower(casedPath))
+			casedPaths = append(casedPaths, casedPath)
+		}
+	}
+	return
+}
+
+// longFileNames returns the Win32 and POSIX names of a file, leaving out 8.3 names. A file has one for each hard link.
+func longFileNames(fileNameAttributes mft.FileNameAttributes) (longNames mft.FileNameAttributes) {
+	for _, attribute := range fileNameAttributes {
+		if strings.Contains(attribute.FileNamespace, "WIN32") || strings.Contains(attribute.FileNamespace, "POSIX") {
+			longNames = append(longNames, attribute)
+		}
 	}
 	return
 }
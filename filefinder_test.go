@@ -246,6 +246,8 @@ func Test_confirmFoundFiles(t *testing.T) {
 					dataRuns: nil,
 					fullPath: `c:\exactmatch`,
 					fileSize: 0,
+					codec:    "store",
+					priority: 5,
 				},
 				1: foundFile{
 					dataRuns: nil,
@@ -260,6 +262,8 @@ func Test_confirmFoundFiles(t *testing.T) {
 						fullPathRegex:  nil,
 						fileNameString: "exactmatch",
 						fileNameRegex:  nil,
+						codec:          "store",
+						priority:       5,
 					},
 					1: searchTerms{
 						fullPathString: "",
@@ -342,10 +346,155 @@ func Test_confirmFoundFiles(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "hard link",
+			wantFoundFilesList: foundFiles{
+				0: foundFile{
+					fullPath: `c:\users\linked`,
+					links:    []string{`c:\temp\linked`},
+				},
+			},
+			args: args{
+				listOfSearchKeywords: listOfSearchTerms{
+					0: searchTerms{
+						fullPathString: `c:\users\linked`,
+						fileNameString: "linked",
+					},
+				},
+				listOfPossibleMatches: possibleMatches{
+					0: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{
+							ParentDirRecordNumber: 7,
+							FileNamespace:         "WIN32",
+							FileName:              "linked",
+						},
+						fileNameAttributes: mft.FileNameAttributes{
+							{ParentDirRecordNumber: 7, FileNamespace: "WIN32", FileName: "linked"},
+							{ParentDirRecordNumber: 8, FileNamespace: "WIN32", FileName: "linked"},
+						},
+					},
+				},
+				directoryTree: mft.DirectoryTree{
+					7: `c:\temp`,
+					8: `c:\users`,
+				},
+			},
+		},
+		{
+			name: "time window",
+			wantFoundFilesList: foundFiles{
+				0: foundFile{
+					fullPath: `c:\logs\new.log`,
+					metadata: recordMetadata{standardInformation: mft.StandardInformationAttribute{SiModified: time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC)}},
+				},
+			},
+			args: args{
+				listOfSearchKeywords: listOfSearchTerms{
+					0: searchTerms{
+						fullPathRegex: regexp.MustCompile(`^c:\\logs\\.*\.log$`),
+						fileNameRegex: regexp.MustCompile(`\.log$`),
+						modified:      TimeWindow{After: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+					},
+				},
+				listOfPossibleMatches: possibleMatches{
+					0: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 9, FileNamespace: "WIN32", FileName: "old.log"},
+						metadata:          recordMetadata{standardInformation: mft.StandardInformationAttribute{SiModified: time.Date(2020, 4, 2, 0, 0, 0, 0, time.UTC)}},
+					},
+					1: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 9, FileNamespace: "WIN32", FileName: "new.log"},
+						metadata:          recordMetadata{standardInformation: mft.StandardInformationAttribute{SiModified: time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC)}},
+					},
+				},
+				directoryTree: mft.DirectoryTree{
+					9: `c:\logs`,
+				},
+			},
+		},
+		{
+			name: "exclusion",
+			wantFoundFilesList: foundFiles{
+				0: foundFile{fullPath: `c:\logs\security.evtx`},
+			},
+			args: args{
+				listOfSearchKeywords: listOfSearchTerms{
+					0: searchTerms{
+						fullPathRegex: regexp.MustCompile(`^c:\\logs\\.*\.evtx$`),
+						fileNameRegex: regexp.MustCompile(`\.evtx$`),
+						exclude:       regexp.MustCompile(`\\microsoft-windows-store.*`),
+					},
+				},
+				listOfPossibleMatches: possibleMatches{
+					0: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 9, FileNamespace: "WIN32", FileName: "microsoft-windows-store%4operational.evtx"},
+					},
+					1: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 9, FileNamespace: "WIN32", FileName: "security.evtx"},
+					},
+				},
+				directoryTree: mft.DirectoryTree{
+					9: `c:\logs`,
+				},
+			},
+		},
+		{
+			name: "case-sensitive",
+			wantFoundFilesList: foundFiles{
+				0: foundFile{fullPath: `c:\src\Makefile`, metadata: recordMetadata{recordNumber: 41}},
+			},
+			args: args{
+				listOfSearchKeywords: listOfSearchTerms{
+					0: searchTerms{fullPathString: `c:\src\Makefile`, fileNameString: "Makefile", caseSensitive: true},
+				},
+				listOfPossibleMatches: possibleMatches{
+					0: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 10, FileNamespace: "POSIX", FileName: "makefile"},
+						metadata:          recordMetadata{recordNumber: 40},
+					},
+					1: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 10, FileNamespace: "POSIX", FileName: "Makefile"},
+						metadata:          recordMetadata{recordNumber: 41},
+					},
+				},
+				directoryTree: mft.DirectoryTree{
+					10: `C:\src`,
+				},
+			},
+		},
+		{
+			name: "case-sensitive directory",
+			wantFoundFilesList: foundFiles{
+				0: foundFile{fullPath: `c:\src\makefile`, metadata: recordMetadata{recordNumber: 40}},
+				1: foundFile{fullPath: `c:\src\Makefile`, metadata: recordMetadata{recordNumber: 41}},
+				2: foundFile{fullPath: `c:\src\readme`, metadata: recordMetadata{recordNumber: 42}},
+			},
+			args: args{
+				listOfSearchKeywords: listOfSearchTerms{
+					0: searchTerms{fullPathRegex: regexp.MustCompile(`^c:\\src\\`), fileNameRegex: regexp.MustCompile(`.*`)},
+				},
+				listOfPossibleMatches: possibleMatches{
+					0: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 10, FileNamespace: "POSIX", FileName: "makefile"},
+						metadata:          recordMetadata{recordNumber: 40},
+					},
+					1: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 10, FileNamespace: "POSIX", FileName: "Makefile"},
+						metadata:          recordMetadata{recordNumber: 41},
+					},
+					2: possibleMatch{
+						fileNameAttribute: mft.FileNameAttribute{ParentDirRecordNumber: 10, FileNamespace: "POSIX", FileName: "README"},
+						metadata:          recordMetadata{recordNumber: 42},
+					},
+				},
+				directoryTree: mft.DirectoryTree{
+					10: `C:\src`,
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotFoundFilesList := confirmFoundFiles(tt.args.listOfSearchKeywords, tt.args.listOfPossibleMatches, tt.args.directoryTree)
+			gotFoundFilesList := confirmFoundFiles(loggerOrDefault(nil), tt.args.listOfSearchKeywords, tt.args.listOfPossibleMatches, tt.args.directoryTree)
 			if !reflect.DeepEqual(gotFoundFilesList, tt.wantFoundFilesList) {
 				t.Errorf("confirmFoundFiles() gotFoundFilesList = %v, want %v", gotFoundFilesList, tt.wantFoundFilesList)
 			}
@@ -431,6 +580,18 @@ func Test_findPossibleMatches(t *testing.T) {
 							Length:         4096,
 						},
 					},
+					metadata: recordMetadata{
+						recordNumber: 1,
+						standardInformation: mft.StandardInformationAttribute{
+							SiCreated:    time.Date(2018, 2, 25, 00, 10, 45, 642455000, time.UTC),
+							SiModified:   time.Date(2018, 2, 25, 00, 10, 45, 642455000, time.UTC),
+							SiAccessed:   time.Date(2018, 2, 25, 00, 10, 45, 642455000, time.UTC),
+							SiChanged:    time.Date(2018, 2, 25, 00, 10, 45, 642455000, time.UTC),
+							FlagResident: true,
+						},
+						fileAttributes: 0x06,
+						securityID:     256,
+					},
 				},
 				1: possibleMatch{
 					fileNameAttribute: mft.FileNameAttribute{
@@ -470,6 +631,18 @@ func Test_findPossibleMatches(t *testing.T) {
 						FileName:       "SOFTWARE",
 					},
 					dataRuns: mft.DataRuns{},
+					metadata: recordMetadata{
+						recordNumber: 1369960,
+						standardInformation: mft.StandardInformationAttribute{
+							SiCreated:    time.Date(2019, 3, 19, 4, 37, 22, 64292900, time.UTC),
+							SiModified:   time.Date(2019, 11, 21, 0, 25, 55, 780858200, time.UTC),
+							SiAccessed:   time.Date(2019, 11, 21, 0, 25, 55, 780858200, time.UTC),
+							SiChanged:    time.Date(2019, 11, 1, 3, 40, 35, 353568400, time.UTC),
+							FlagResident: true,
+						},
+						fileAttributes: 0x20,
+						securityID:     11487,
+					},
 				},
 			},
 		},
@@ -506,6 +679,11 @@ func Test_findPossibleMatches(t *testing.T) {
 				t.Errorf("findPossibleMatches() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// Neither file has hard links, so the name that matched is its only long name
+			for i := range tt.wantListOfPossibleMatches {
+				tt.wantListOfPossibleMatches[i].fileNameAttributes = mft.FileNameAttributes{tt.wantListOfPossibleMatches[i].fileNameAttribute}
+				tt.wantListOfPossibleMatches[i].metadata.fileName = tt.wantListOfPossibleMatches[i].fileNameAttribute
+			}
 			if !reflect.DeepEqual(gotListOfPossibleMatches, tt.wantListOfPossibleMatches) {
 				t.Errorf("findPossibleMatches() gotListOfPossibleMatches = %+v, want %+v", gotListOfPossibleMatches, tt.wantListOfPossibleMatches)
 			}
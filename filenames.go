@@ -0,0 +1,89 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"unicode/utf16"
+)
+
+// fileNameNamespaceDOS is the namespace of the 8.3 name Windows gives a file whose long name doesn't fit 8.3.
+const fileNameNamespaceDOS = 2
+
+// decodedFileName is the name of one of a record's $FILE_NAME attributes.
+type decodedFileName struct {
+	name      string
+	namespace byte
+}
+
+// decodeFileNames decodes the UTF-16 names of a record's $FILE_NAME attributes, in the order they're in the record. The
+// MFT parser makes a string of a name by dropping its zero bytes, which only works for ASCII, so names such as Иван or
+// 山田 come out of it garbled.
+func decodeFileNames(record mft.RawMasterFileTableRecord, attributesOffset uint16) (names []decodedFileName) {
+	const (
+		codeFileName     = 0x30
+		offsetValueSize  = 0x10
+		offsetValue      = 0x14
+		offsetNameLength = 0x40
+		offsetNamespace  = 0x41
+		offsetName       = 0x42
+	)
+	for _, attribute := range attributesOfType(record, attributesOffset, codeFileName) {
+		valueOffset := int(binary.LittleEndian.Uint16(attribute[offsetValue:]))
+		valueEnd := valueOffset + int(binary.LittleEndian.Uint32(attribute[offsetValueSize:]))
+		if valueEnd > len(attribute) || valueOffset+offsetName > valueEnd {
+			return nil
+		}
+		value := attribute[valueOffset:valueEnd]
+		nameLength := int(value[offsetNameLength])
+		if offsetName+nameLength*2 > len(value) {
+			return nil
+		}
+		characters := make([]uint16, nameLength)
+		for index := range characters {
+			characters[index] = binary.LittleEndian.Uint16(value[offsetName+index*2:])
+		}
+		names = append(names, decodedFileName{name: string(utf16.Decode(characters)), namespace: value[offsetNamespace]})
+	}
+	return
+}
+
+// withDecodedFileNames returns the $FILE_NAME attributes the MFT parser found in a record with their names decoded from
+// the record. They're returned as they are when the two don't find the same attributes.
+func withDecodedFileNames(record mft.RawMasterFileTableRecord, recordHeader mft.RecordHeader, fileNameAttributes mft.FileNameAttributes) mft.FileNameAttributes {
+	names := decodeFileNames(record, recordHeader.AttributesOffset)
+	if len(names) == 0 || len(names) != len(fileNameAttributes) {
+		return fileNameAttributes
+	}
+	decoded := make(mft.FileNameAttributes, len(fileNameAttributes))
+	for index, fileNameAttribute := range fileNameAttributes {
+		fileNameAttribute.FileName = names[index].name
+		decoded[index] = fileNameAttribute
+	}
+	return decoded
+}
+
+// convertRecordToDirectory is mft.ConvertRawMFTRecordToDirectory with the directory's name decoded from the record, so
+// the paths of what's under a directory such as C:\Users\Иван come out right.
+func convertRecordToDirectory(record mft.RawMasterFileTableRecord) (directory mft.UnResolvedDirectory, err error) {
+	directory, err = mft.ConvertRawMFTRecordToDirectory(record)
+	if err != nil {
+		return
+	}
+	rawRecordHeader, err := record.GetRawRecordHeader()
+	if err != nil {
+		return
+	}
+	recordHeader, err := rawRecordHeader.Parse()
+	if err != nil {
+		return
+	}
+	for _, fileName := range decodeFileNames(record, recordHeader.AttributesOffset) {
+		if fileName.namespace != fileNameNamespaceDOS {
+			directory.DirectoryName = fileName.name
+			break
+		}
+	}
+	return
+}
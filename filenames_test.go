@@ -0,0 +1,122 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// fileNameTestValue lays out the value of a $FILE_NAME attribute for a name in a namespace, with the root as its parent.
+func fileNameTestValue(name string, namespace byte) residentTestAttribute {
+	characters := utf16.Encode([]rune(name))
+	value := make([]byte, 0x42+len(characters)*2)
+	binary.LittleEndian.PutUint32(value[0x00:], 5)
+	value[0x40] = byte(len(characters))
+	value[0x41] = namespace
+	for index, character := range characters {
+		binary.LittleEndian.PutUint16(value[0x42+index*2:], character)
+	}
+	return residentTestAttribute{attributeType: 0x30, data: value}
+}
+
+func Test_decodeFileNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		record mft.RawMasterFileTableRecord
+		want   []decodedFileName
+	}{
+		{
+			name:   "cyrillic",
+			record: buildResidentTestRecord([]residentTestAttribute{fileNameTestValue("ИВАНОВ~1", 2), fileNameTestValue("Иванов Иван", 1)}, false),
+			want:   []decodedFileName{{name: "ИВАНОВ~1", namespace: 2}, {name: "Иванов Иван", namespace: 1}},
+		},
+		{
+			name:   "cjk",
+			record: buildResidentTestRecord([]residentTestAttribute{fileNameTestValue("山田太郎の報告書.docx", 3)}, false),
+			want:   []decodedFileName{{name: "山田太郎の報告書.docx", namespace: 3}},
+		},
+		{
+			name:   "outside the basic multilingual plane",
+			record: buildResidentTestRecord([]residentTestAttribute{fileNameTestValue("𝒳.txt", 0)}, false),
+			want:   []decodedFileName{{name: "𝒳.txt", namespace: 0}},
+		},
+		{
+			name:   "torn write",
+			record: buildResidentTestRecord([]residentTestAttribute{fileNameTestValue("Иван", 1)}, true),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeFileNames(tt.record, 0x38); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeFileNames() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withDecodedFileNames(t *testing.T) {
+	record := buildResidentTestRecord([]residentTestAttribute{fileNameTestValue("NTUSER~1.DAT", 2), fileNameTestValue("Отчёт 山田.dat", 1)}, false)
+	recordHeader := mft.RecordHeader{AttributesOffset: 0x38}
+	rawAttributes, _ := record.GetRawAttributes(recordHeader)
+	fileNameAttributes, _, _, _, _ := rawAttributes.Parse(4096)
+	if len(fileNameAttributes) != 2 || fileNameAttributes[1].FileName == "Отчёт 山田.dat" {
+		t.Fatalf("the MFT parser got %+v, which this test expects to have garbled the name", fileNameAttributes)
+	}
+
+	decoded := withDecodedFileNames(record, recordHeader, fileNameAttributes)
+	if decoded[0].FileName != "NTUSER~1.DAT" || decoded[1].FileName != "Отчёт 山田.dat" || decoded[1].FileNamespace != "WIN32" {
+		t.Errorf("withDecodedFileNames() = %+v, want the names decoded", decoded)
+	}
+	if fileNameAttributes[1].FileName == decoded[1].FileName {
+		t.Errorf("withDecodedFileNames() changed the attributes it was given")
+	}
+
+	searchTerms, err := setupSearchTerms(ListOfFilesToExport{{FullPath: `c:\users\отчёт 山田.dat`, FileName: "ОТЧЁТ 山田.DAT"}})
+	if err != nil {
+		t.Fatalf("setupSearchTerms() error = %v", err)
+	}
+	if matched, _, _ := checkForPossibleMatch(searchTerms, decoded); !matched {
+		t.Errorf("checkForPossibleMatch() didn't match the decoded name to a search term in another case")
+	}
+}
+
+func Test_convertRecordToDirectory(t *testing.T) {
+	record := buildResidentTestRecord([]residentTestAttribute{fileNameTestValue("ИВАНОВ~1", 2), fileNameTestValue("Иванов", 1)}, false)
+	record[0x16] = 0x03 // in use and a directory
+	binary.LittleEndian.PutUint32(record[0x2c:], 64)
+
+	directory, err := convertRecordToDirectory(record)
+	if err != nil {
+		t.Fatalf("convertRecordToDirectory() error = %v", err)
+	}
+	if directory.DirectoryName != "Иванов" || directory.RecordNumber != 64 || directory.ParentRecordNumber != 5 {
+		t.Errorf("convertRecordToDirectory() = %+v, want directory 64 named Иванов under the root", directory)
+	}
+}
+
+func Test_longPath(t *testing.T) {
+	deep := `c:\users\иван\appdata\local\` + strings.Repeat(`папка\`, 45) + "файл.txt"
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "short", path: `c:\users\иван\ntuser.dat`, want: `c:\users\иван\ntuser.dat`},
+		{name: "long", path: deep, want: `\\?\` + deep},
+		{name: "long share", path: `\\ws042\c$\` + deep[3:], want: `\\?\UNC\ws042\c$\` + deep[3:]},
+		{name: "already prefixed", path: `\\?\` + deep, want: `\\?\` + deep},
+		{name: "relative", path: deep[3:], want: deep[3:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := longPath(tt.path); got != tt.want {
+				t.Errorf("longPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
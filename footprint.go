@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FootprintReport is what the report says about how the collection kept its footprint on the host small.
+type FootprintReport struct {
+	Minimal     bool   `json:"minimal"`              // see CollectOptions.MinimalFootprint
+	ProcessName string `json:"process_name"`         // the collector's executable as it ran
+	StartedAs   string `json:"started_as,omitempty"` // the name it was started as, when it ran under another one
+}
+
+// footprintReport is the FootprintReport for the report, nil when the collection didn't try to keep its footprint small.
+func (options CollectOptions) footprintReport() *FootprintReport {
+	if !options.MinimalFootprint && options.StartedAs == "" {
+		return nil
+	}
+	footprint := &FootprintReport{Minimal: options.MinimalFootprint, StartedAs: options.StartedAs}
+	if executable, err := os.Executable(); err == nil {
+		footprint.ProcessName = filepath.Base(executable)
+	}
+	return footprint
+}
+
+// checkMinimalFootprint returns what in options a minimal footprint doesn't allow: anything that creates temp files,
+// opens files through the API or starts processes.
+func (options CollectOptions) checkMinimalFootprint() (err error) {
+	if !options.MinimalFootprint {
+		return
+	}
+	var refused []string
+	if options.ReadPolicy != "" && options.ReadPolicy != ReadRawOnly {
+		refused = append(refused, fmt.Sprintf("the %s read policy, which opens files through the API", options.ReadPolicy))
+	}
+	if options.Workers > 1 {
+		refused = append(refused, "more than one worker, which spools files")
+	}
+	if options.Deduplicate {
+		refused = append(refused, "deduplicating, which spools files")
+	}
+	if options.Verify {
+		refused = append(refused, "verifying, which reads files again through the API")
+	}
+	if options.ExportHives || options.APIFallback {
+		refused = append(refused, "exporting hives, which saves them to temp files")
+	}
+	if len(options.Commands) != 0 {
+		refused = append(refused, "commands, which start processes")
+	}
+	if len(options.Processors) != 0 && !options.ReplaceProcessed {
+		refused = append(refused, "processors alongside the files, which spools their copies")
+	}
+	if options.BitLockerRecoveryPassword != "" || options.BitLockerRecoveryKey != "" {
+		refused = append(refused, "unlocking BitLocker volumes, which changes them on the host")
+	}
+	if options.MFTCache != nil {
+		refused = append(refused, "an MFT cache, which writes the MFT to disk")
+	}
+	var eventLogs, firewallPolicy bool
+	for _, acquirer := range options.Acquirers {
+		switch acquirer.(type) {
+		case *eventLogAcquirer:
+			eventLogs = true
+		case *firewallPolicyAcquirer:
+			firewallPolicy = true
+		}
+	}
+	if eventLogs {
+		refused = append(refused, "exporting event log channels, which writes them to temp files")
+	}
+	if firewallPolicy {
+		refused = append(refused, "exporting the firewall policy, which starts netsh and writes a temp file")
+	}
+	if len(refused) != 0 {
+		err = fmt.Errorf("a minimal footprint doesn't allow %s", strings.Join(refused, ", "))
+	}
+	return
+}
+
+// footprintAcquirer is acquirer as it runs with options. With a minimal footprint a volatile acquirer gathers without
+// opening any files, e.g. the processes are listed without hashing their executables.
+func (options CollectOptions) footprintAcquirer(acquirer Acquirer) Acquirer {
+	volatile, ok := acquirer.(*volatileAcquirer)
+	if !options.MinimalFootprint || !ok || volatile.withoutFiles == nil {
+		return acquirer
+	}
+	return &volatileAcquirer{name: volatile.name, gather: volatile.withoutFiles}
+}
+
+// refuseAPIFallback is the error for a volume that would be collected through the API instead of raw, such as one the
+// collector isn't allowed to read raw, when the footprint is kept minimal. It's nil otherwise.
+func (options CollectOptions) refuseAPIFallback(volumeLetter string, why string) error {
+	if !options.MinimalFootprint {
+		return nil
+	}
+	return fmt.Errorf("volume %s %s and a minimal footprint doesn't read files through the API instead", volumeLetter, why)
+}
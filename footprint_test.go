@@ -0,0 +1,127 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCollectOptions_checkMinimalFootprint(t *testing.T) {
+	tests := []struct {
+		name    string
+		options CollectOptions
+		wantErr string
+	}{
+		{name: "not minimal", options: CollectOptions{Workers: 4, Deduplicate: true}},
+		{name: "minimal", options: CollectOptions{MinimalFootprint: true, Workers: 1, ReadPolicy: ReadRawOnly}},
+		{name: "api first", options: CollectOptions{MinimalFootprint: true, ReadPolicy: ReadAPIFirst}, wantErr: "the api_first read policy"},
+		{name: "workers", options: CollectOptions{MinimalFootprint: true, Workers: 2}, wantErr: "more than one worker"},
+		{name: "several", options: CollectOptions{MinimalFootprint: true, Deduplicate: true, Verify: true, ExportHives: true}, wantErr: "deduplicating, which spools files, verifying"},
+		{name: "commands", options: CollectOptions{MinimalFootprint: true, Commands: []Command{{Name: "ipconfig"}}}, wantErr: "commands, which start processes"},
+		{name: "processors alongside", options: CollectOptions{MinimalFootprint: true, Processors: []Processor{EvtxJSONProcessor{}}}, wantErr: "processors alongside the files"},
+		{name: "processors instead", options: CollectOptions{MinimalFootprint: true, Processors: []Processor{EvtxJSONProcessor{}}, ReplaceProcessed: true}},
+		{name: "event logs", options: CollectOptions{MinimalFootprint: true, Acquirers: []Acquirer{&eventLogAcquirer{}}}, wantErr: "exporting event log channels"},
+		{name: "bitlocker", options: CollectOptions{MinimalFootprint: true, BitLockerRecoveryPassword: "111111-222222-333333-444444-555555-666666-777777-888888"}, wantErr: "unlocking BitLocker volumes"},
+		{name: "firewall policy", options: CollectOptions{MinimalFootprint: true, Acquirers: NetworkAcquirers()}, wantErr: "exporting the firewall policy"},
+		{name: "volatile", options: CollectOptions{MinimalFootprint: true, Acquirers: VolatileAcquirers()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.checkMinimalFootprint()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkMinimalFootprint() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCollectOptions_footprintAcquirer(t *testing.T) {
+	gather := func(value string) func(context.Context, Logger) (interface{}, error) {
+		return func(context.Context, Logger) (interface{}, error) { return value, nil }
+	}
+	hashing := &volatileAcquirer{name: "volatile/processes.json", gather: gather("hashed"), withoutFiles: gather("not hashed")}
+	tests := []struct {
+		name     string
+		options  CollectOptions
+		acquirer Acquirer
+		want     string
+	}{
+		{name: "not minimal", acquirer: hashing, want: `"hashed"`},
+		{name: "minimal", options: CollectOptions{MinimalFootprint: true}, acquirer: hashing, want: `"not hashed"`},
+		{name: "opens no files", options: CollectOptions{MinimalFootprint: true}, acquirer: &volatileAcquirer{name: "volatile/users.json", gather: gather("users")}, want: `"users"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acquirer := tt.options.footprintAcquirer(tt.acquirer)
+			if acquirer.Name() != tt.acquirer.Name() {
+				t.Errorf("footprintAcquirer() is named %s, want %s", acquirer.Name(), tt.acquirer.Name())
+			}
+			reader, _, err := acquirer.Acquire(context.Background(), loggerOrDefault(nil))
+			if err != nil {
+				t.Fatalf("Acquire() error = %v", err)
+			}
+			defer reader.Close()
+			data, _ := ioutil.ReadAll(reader)
+			if string(data) != tt.want {
+				t.Errorf("Acquire() = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectOptions_readPolicyFor_minimalFootprint(t *testing.T) {
+	options := CollectOptions{MinimalFootprint: true}
+	if got := options.readPolicyFor(foundFile{readPolicy: ReadAPIOnly}); got != ReadRawOnly {
+		t.Errorf("readPolicyFor() = %s for a target that asks for api_only, want raw_only", got)
+	}
+	if err := options.refuseAPIFallback("c", "can't be read raw"); err == nil {
+		t.Error("refuseAPIFallback() error = nil, want one")
+	}
+	if err := (CollectOptions{}).refuseAPIFallback("c", "can't be read raw"); err != nil {
+		t.Errorf("refuseAPIFallback() error = %v without a minimal footprint", err)
+	}
+}
+
+func TestCollect_minimalFootprint(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	tests := []struct {
+		name          string
+		options       CollectOptions
+		wantErr       bool
+		wantFootprint *FootprintReport
+	}{
+		{name: "default"},
+		{name: "minimal", options: CollectOptions{MinimalFootprint: true}, wantFootprint: &FootprintReport{Minimal: true}},
+		{name: "started as", options: CollectOptions{StartedAs: "gofor-collector.exe"}, wantFootprint: &FootprintReport{StartedAs: "gofor-collector.exe"}},
+		{name: "refused", options: CollectOptions{MinimalFootprint: true, Deduplicate: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(new(bytes.Buffer))}
+			report, err := CollectWithReport(context.Background(), handler, exportList, &resultWriter, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CollectWithReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (report.Footprint == nil) != (tt.wantFootprint == nil) {
+				t.Fatalf("CollectWithReport() reported footprint %+v, want %+v", report.Footprint, tt.wantFootprint)
+			}
+			if tt.wantFootprint != nil && (report.Footprint.Minimal != tt.wantFootprint.Minimal || report.Footprint.StartedAs != tt.wantFootprint.StartedAs || report.Footprint.ProcessName == "") {
+				t.Errorf("CollectWithReport() reported footprint %+v, want %+v with the process name", report.Footprint, tt.wantFootprint)
+			}
+			if report.FilesCollected != 1 {
+				t.Errorf("CollectWithReport() collected %d files, want the $MFT", report.FilesCollected)
+			}
+		})
+	}
+}
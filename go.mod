@@ -9,4 +9,6 @@ require (
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/sirupsen/logrus v1.4.2
 	golang.org/x/sys v0.0.0-20191105231009-c1f44814a5cd
+	google.golang.org/grpc v1.29.1
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,245 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+var (
+	advapi32          = windows.NewLazySystemDLL("advapi32.dll")
+	procRegSaveKeyExW = advapi32.NewProc("RegSaveKeyExW")
+	procRegFlushKey   = advapi32.NewProc("RegFlushKey")
+)
+
+// regLatestFormat asks RegSaveKeyEx for the newest hive format, the same one the hive files on disk are in.
+const regLatestFormat = 2
+
+// loadedHive is where a hive file is loaded in the registry.
+type loadedHive struct {
+	root registry.Key
+	path string
+}
+
+func (hive loadedHive) String() string {
+	roots := map[registry.Key]string{
+		registry.LOCAL_MACHINE: "HKLM",
+		registry.USERS:         "HKU",
+		registry.CURRENT_USER:  "HKCU",
+	}
+	if hive.path == "" {
+		return roots[hive.root]
+	}
+	return roots[hive.root] + `\` + hive.path
+}
+
+// systemHives are the hive files in system32\config and the keys under HKLM they are loaded at.
+var systemHives = map[string]string{
+	"system":   "SYSTEM",
+	"software": "SOFTWARE",
+	"sam":      "SAM",
+	"security": "SECURITY",
+}
+
+// userProfiles returns the SID of every user profile on the host, keyed by the profile directory as ProfileList has it.
+// It's a variable so tests don't depend on the host's profiles.
+var userProfiles = func(logger Logger) (profiles map[string]string) {
+	profiles = make(map[string]string)
+	profileList, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion\ProfileList`, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		logger.Debugf("Failed to open the profile list: %v", err)
+		return
+	}
+	defer profileList.Close()
+	sids, _ := profileList.ReadSubKeyNames(-1)
+	for _, sid := range sids {
+		profile, err := registry.OpenKey(profileList, sid, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		profileDirectory, _, err := profile.GetStringValue("ProfileImagePath")
+		profile.Close()
+		if err != nil {
+			continue
+		}
+		profileDirectory, _ = registry.ExpandString(profileDirectory)
+		profiles[profileDirectory] = sid
+	}
+	return
+}
+
+// loadedHiveForPath works out where the hive file at fullPath is loaded. ok is false when fullPath isn't a hive the
+// collector knows how to export. A user hive is only found here when its profile exists, which doesn't mean it's loaded;
+// saving it will fail if the user isn't logged on.
+func loadedHiveForPath(fullPath string, profiles map[string]string) (hive loadedHive, ok bool) {
+	fullPath = strings.ToLower(fullPath)
+	directory := fullPath[:strings.LastIndex(fullPath, `\`)+1]
+	fileName := fullPath[len(directory):]
+	directory = strings.TrimSuffix(directory, `\`)
+
+	if strings.HasSuffix(directory, `\windows\system32\config`) {
+		if fileName == "default" {
+			return loadedHive{root: registry.USERS, path: ".DEFAULT"}, true
+		}
+		path, found := systemHives[fileName]
+		return loadedHive{root: registry.LOCAL_MACHINE, path: path}, found
+	}
+
+	const classesDirectory = `\appdata\local\microsoft\windows`
+	switch {
+	case fileName == "ntuser.dat":
+		if sid, found := profileSID(profiles, directory); found {
+			return loadedHive{root: registry.USERS, path: sid}, true
+		}
+	case fileName == "usrclass.dat" && strings.HasSuffix(directory, classesDirectory):
+		if sid, found := profileSID(profiles, strings.TrimSuffix(directory, classesDirectory)); found {
+			return loadedHive{root: registry.USERS, path: sid + "_Classes"}, true
+		}
+	}
+	return
+}
+
+// profileSID looks up the SID of the profile in directory, which is lowercased, ignoring the case ProfileList has.
+func profileSID(profiles map[string]string, directory string) (sid string, found bool) {
+	for profileDirectory, profileSID := range profiles {
+		if strings.ToLower(profileDirectory) == directory {
+			return profileSID, true
+		}
+	}
+	return
+}
+
+// exportHive flushes a loaded hive and saves it with RegSaveKeyEx to a temp file, returning a reader that deletes the
+// file once it has been read. Unlike a copy of the file on disk, the export has nothing pending in its transaction logs.
+// This needs the backup privilege.
+var exportHive = func(logger Logger, hive loadedHive) (reader io.Reader, err error) {
+	err = enableBackupPrivilege()
+	if err != nil {
+		logger.Debugf("Failed to enable the backup privilege, trying to export %s anyway: %v", hive, err)
+	}
+
+	key := hive.root
+	if hive.path != "" {
+		key, err = registry.OpenKey(hive.root, hive.path, registry.READ)
+		if err != nil {
+			err = fmt.Errorf("failed to open %s: %w", hive, err)
+			return
+		}
+		defer key.Close()
+	}
+	if result, _, _ := procRegFlushKey.Call(uintptr(key)); result != 0 {
+		logger.Debugf("RegFlushKey() failed on %s: %v", hive, windows.Errno(result))
+	}
+
+	tempFile, err := ioutil.TempFile("", "gofor-hive-")
+	if err != nil {
+		return
+	}
+	// RegSaveKeyEx refuses to overwrite, so only the name of the temp file is used
+	tempFileName := tempFile.Name()
+	tempFile.Close()
+	os.Remove(tempFileName)
+
+	tempFilePath, err := windows.UTF16PtrFromString(tempFileName)
+	if err != nil {
+		return
+	}
+	result, _, _ := procRegSaveKeyExW.Call(uintptr(key), uintptr(unsafe.Pointer(tempFilePath)), 0, regLatestFormat)
+	if result != 0 {
+		err = fmt.Errorf("RegSaveKeyExW() failed to save %s: %w", hive, windows.Errno(result))
+		return
+	}
+
+	tempFile, err = os.Open(tempFileName)
+	if err != nil {
+		os.Remove(tempFileName)
+		return
+	}
+	reader = &spooledFile{reader: tempFile, tempFile: tempFile}
+	return
+}
+
+// readHiveWithFallback copies a hive from its data runs and checks the copy starts with a hive header. If the copy fails
+// or isn't a hive the loaded hive is exported instead. The copy is spooled since reading it can fail part way through,
+// after which it would be too late to switch. When both fail the returned reader gives back the errors.
+func readHiveWithFallback(logger Logger, rawReader io.Reader, hive loadedHive) (reader io.Reader, method string, fallback string) {
+	header := &headerRecorder{size: len(hiveSignature)}
+	spooled, rawErr := spoolFile(io.TeeReader(rawReader, header))
+	if rawErr == nil && !bytes.Equal(header.data, []byte(hiveSignature)) {
+		spooled.discard()
+		rawErr = fmt.Errorf("the copy starts with %x instead of a hive header", header.data)
+	}
+	if rawErr == nil {
+		return spooled, readMethodRaw, ""
+	}
+
+	logger.Debugf("Reading %s from disk failed, exporting it instead: %v", hive, rawErr)
+	fallback = rawErr.Error()
+	method = readMethodHiveExport
+	reader, exportErr := exportHive(logger, hive)
+	if exportErr != nil {
+		reader = &failedReader{err: fmt.Errorf("reading the file failed with '%v' and exporting %s failed: %w", rawErr, hive, exportErr)}
+	}
+	return
+}
+
+// hiveSignature is how every hive file starts.
+const hiveSignature = "regf"
+
+// headerRecorder keeps the first bytes written to it.
+type headerRecorder struct {
+	size int
+	data []byte
+}
+
+func (recorder *headerRecorder) Write(data []byte) (numberOfBytesWritten int, err error) {
+	if missing := recorder.size - len(recorder.data); missing > 0 {
+		if missing > len(data) {
+			missing = len(data)
+		}
+		recorder.data = append(recorder.data, data[:missing]...)
+	}
+	return len(data), nil
+}
+
+// failedReader is a file that couldn't be opened any way, so the result writer records its error.
+type failedReader struct {
+	err error
+}
+
+func (failedReader *failedReader) Read([]byte) (int, error) {
+	return 0, failedReader.err
+}
+
+// enableBackupPrivilege enables SeBackupPrivilege on the process token, which RegSaveKeyEx requires. Administrators
+// and Backup Operators hold it but it is disabled by default.
+func enableBackupPrivilege() (err error) {
+	process, err := windows.GetCurrentProcess()
+	if err != nil {
+		return
+	}
+	var token windows.Token
+	err = windows.OpenProcessToken(process, windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &token)
+	if err != nil {
+		return
+	}
+	defer token.Close()
+
+	var luid windows.LUID
+	err = windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr("SeBackupPrivilege"), &luid)
+	if err != nil {
+		return
+	}
+	privileges := windows.Tokenprivileges{PrivilegeCount: 1}
+	privileges.Privileges[0] = windows.LUIDAndAttributes{Luid: luid, Attributes: windows.SE_PRIVILEGE_ENABLED}
+	err = windows.AdjustTokenPrivileges(token, false, &privileges, 0, nil, nil)
+	return
+}
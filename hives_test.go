@@ -0,0 +1,103 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"golang.org/x/sys/windows/registry"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func Test_loadedHiveForPath(t *testing.T) {
+	profiles := map[string]string{
+		`C:\Users\Analyst`: "S-1-5-21-1-2-3-1001",
+	}
+	tests := []struct {
+		name     string
+		fullPath string
+		want     loadedHive
+		wantOk   bool
+	}{
+		{name: "system", fullPath: `c:\windows\system32\config\SYSTEM`, want: loadedHive{root: registry.LOCAL_MACHINE, path: "SYSTEM"}, wantOk: true},
+		{name: "software", fullPath: `c:\windows\system32\config\software`, want: loadedHive{root: registry.LOCAL_MACHINE, path: "SOFTWARE"}, wantOk: true},
+		{name: "default", fullPath: `c:\windows\system32\config\default`, want: loadedHive{root: registry.USERS, path: ".DEFAULT"}, wantOk: true},
+		{name: "ntuser.dat", fullPath: `c:\users\analyst\ntuser.dat`, want: loadedHive{root: registry.USERS, path: "S-1-5-21-1-2-3-1001"}, wantOk: true},
+		{name: "usrclass.dat", fullPath: `c:\users\analyst\appdata\local\microsoft\windows\usrclass.dat`, want: loadedHive{root: registry.USERS, path: "S-1-5-21-1-2-3-1001_Classes"}, wantOk: true},
+		{name: "unknown profile", fullPath: `c:\users\someone\ntuser.dat`, wantOk: false},
+		{name: "not a hive", fullPath: `c:\windows\system32\config\system.log1`, wantOk: false},
+		{name: "event log", fullPath: `c:\windows\system32\winevt\logs\system.evtx`, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotOk := loadedHiveForPath(tt.fullPath, profiles)
+			if gotOk != tt.wantOk {
+				t.Errorf("loadedHiveForPath() ok = %v, want %v", gotOk, tt.wantOk)
+				return
+			}
+			if gotOk && got != tt.want {
+				t.Errorf("loadedHiveForPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_openFoundFile_exportHives(t *testing.T) {
+	savedExportHive := exportHive
+	defer func() { exportHive = savedExportHive }()
+	var exported loadedHive
+	exportHive = func(logger Logger, hive loadedHive) (io.Reader, error) {
+		exported = hive
+		return strings.NewReader("regf"), nil
+	}
+
+	file := foundFile{fullPath: `c:\windows\system32\config\system`}
+	reader, method, _ := (&Collector{Options: CollectOptions{ExportHives: true}}).openFoundFile(&VolumeHandler{}, file)
+	if method != readMethodHiveExport {
+		t.Fatalf("openFoundFile() method = %v, want %v", method, readMethodHiveExport)
+	}
+	if got, _ := ioutil.ReadAll(reader); string(got) != "regf" || exported.path != "SYSTEM" {
+		t.Errorf("openFoundFile() exported %v and read %q, want SYSTEM and \"regf\"", exported, got)
+	}
+}
+
+func Test_openFoundFile_apiFallback(t *testing.T) {
+	savedExportHive := exportHive
+	defer func() { exportHive = savedExportHive }()
+	tests := []struct {
+		name         string
+		rawData      string
+		apiFallback  bool
+		exportErr    error
+		wantMethod   string
+		wantFallback bool
+		wantData     string
+		wantErr      bool
+	}{
+		{name: "raw copy is a hive", rawData: "regf raw", apiFallback: true, wantMethod: readMethodRaw, wantData: "regf raw"},
+		{name: "raw copy isn't a hive", rawData: "\x00\x00\x00\x00", apiFallback: true, wantMethod: readMethodHiveExport, wantFallback: true, wantData: "regf exported"},
+		{name: "export fails too", rawData: "\x00\x00\x00\x00", apiFallback: true, exportErr: errors.New("access denied"), wantMethod: readMethodHiveExport, wantFallback: true, wantErr: true},
+		{name: "fallback not asked for", rawData: "\x00\x00\x00\x00", wantMethod: readMethodRaw, wantData: "\x00\x00\x00\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exportHive = func(logger Logger, hive loadedHive) (io.Reader, error) {
+				return strings.NewReader("regf exported"), tt.exportErr
+			}
+			file := foundFile{fullPath: `c:\windows\system32\config\system`, resident: true, residentData: []byte(tt.rawData)}
+			reader, method, fallback := (&Collector{Options: CollectOptions{APIFallback: tt.apiFallback}}).openFoundFile(&VolumeHandler{}, file)
+			if method != tt.wantMethod || (fallback != "") != tt.wantFallback {
+				t.Fatalf("openFoundFile() method = %v, fallback = %q, want %v and a fallback %v", method, fallback, tt.wantMethod, tt.wantFallback)
+			}
+			got, err := ioutil.ReadAll(reader)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reading the file error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.wantData {
+				t.Errorf("openFoundFile() read %q, want %q", got, tt.wantData)
+			}
+		})
+	}
+}
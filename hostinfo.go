@@ -0,0 +1,170 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// HostInfo fingerprints the host at the start of a collection, so the artifacts can be tied to it and their timestamps
+// normalized when building a timeline.
+type HostInfo struct {
+	CapturedAt          time.Time      `json:"captured_at"`
+	LocalTime           time.Time      `json:"local_time"` // CapturedAt on the host's clock, with its offset from UTC
+	TimeZone            string         `json:"time_zone"`
+	TimeZoneKeyName     string         `json:"time_zone_key_name,omitempty"`
+	UTCOffsetSeconds    int            `json:"utc_offset_seconds"`
+	RealTimeIsUniversal bool           `json:"real_time_is_universal,omitempty"` // the hardware clock keeps UTC rather than local time
+	Domain              string         `json:"domain,omitempty"`                 // the NetBIOS domain or workgroup
+	DomainJoined        bool           `json:"domain_joined"`
+	DNSDomain           string         `json:"dns_domain,omitempty"`
+	ProductName         string         `json:"product_name,omitempty"`
+	EditionID           string         `json:"edition_id,omitempty"`
+	DisplayVersion      string         `json:"display_version,omitempty"` // such as 22H2
+	Version             string         `json:"version,omitempty"`         // major.minor
+	Build               string         `json:"build,omitempty"`           // build.revision
+	InstallDate         *time.Time     `json:"install_date,omitempty"`
+	BootTime            *time.Time     `json:"boot_time,omitempty"`
+	UptimeSeconds       float64        `json:"uptime_seconds"`
+	Volumes             []VolumeSerial `json:"volumes"`
+	Errors              []string       `json:"errors,omitempty"`
+}
+
+// VolumeSerial identifies a volume the collection reads from.
+type VolumeSerial struct {
+	Letter       string `json:"letter"`
+	SerialNumber string `json:"serial_number,omitempty"` // as vol shows it, such as 1A2B-3C4D
+	FileSystem   string `json:"file_system,omitempty"`
+	Label        string `json:"label,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// captureHostInfo fingerprints the host and the volumes of interest. Failures are recorded in the returned HostInfo
+// rather than failing the collection.
+func captureHostInfo(logger Logger, volumesOfInterest []string) (host HostInfo) {
+	now := time.Now()
+	host.CapturedAt = now.UTC()
+	host.LocalTime = now
+	host.TimeZone, host.UTCOffsetSeconds = now.Zone()
+	if uptime := windows.DurationSinceBoot(); uptime > 0 {
+		host.UptimeSeconds = uptime.Seconds()
+		bootTime := now.Add(-uptime).UTC().Truncate(time.Second)
+		host.BootTime = &bootTime
+	}
+
+	timeZoneKey, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\TimeZoneInformation`, registry.QUERY_VALUE)
+	if err != nil {
+		host.Errors = append(host.Errors, fmt.Sprintf("failed to open the time zone registry key: %v", err))
+	} else {
+		host.TimeZoneKeyName, _, _ = timeZoneKey.GetStringValue("TimeZoneKeyName")
+		realTimeIsUniversal, _, _ := timeZoneKey.GetIntegerValue("RealTimeIsUniversal")
+		host.RealTimeIsUniversal = realTimeIsUniversal != 0
+		timeZoneKey.Close()
+	}
+
+	host.captureDomain()
+	host.captureVersion()
+	for _, volumeLetter := range volumesOfInterest {
+		host.Volumes = append(host.Volumes, volumeSerial(volumeLetter))
+	}
+	logger.Debugf("Captured the following host information: %+v", host)
+	return
+}
+
+// captureDomain records the domain or workgroup the host is joined to and its DNS domain.
+func (host *HostInfo) captureDomain() {
+	var name *uint16
+	var joinStatus uint32
+	if err := windows.NetGetJoinInformation(nil, &name, &joinStatus); err != nil {
+		host.Errors = append(host.Errors, fmt.Sprintf("failed to get the domain the host is joined to: %v", err))
+	} else {
+		host.Domain = utf16PointerToString(name)
+		host.DomainJoined = joinStatus == windows.NetSetupDomainName
+		_ = windows.NetApiBufferFree((*byte)(unsafe.Pointer(name)))
+	}
+
+	tcpipKey, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\Tcpip\Parameters`, registry.QUERY_VALUE)
+	if err != nil {
+		host.Errors = append(host.Errors, fmt.Sprintf("failed to open the tcpip registry key: %v", err))
+		return
+	}
+	defer tcpipKey.Close()
+	host.DNSDomain, _, _ = tcpipKey.GetStringValue("Domain")
+}
+
+// captureVersion records the OS version and build. RtlGetVersion isn't lied to by compatibility shims the way the
+// registry's version numbers can be, but only the registry has the product name and the update revision of the build.
+func (host *HostInfo) captureVersion() {
+	version := windows.RtlGetVersion()
+	host.Version = fmt.Sprintf("%d.%d", version.MajorVersion, version.MinorVersion)
+	buildNumber := version.BuildNumber
+
+	currentVersionKey, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		host.Errors = append(host.Errors, fmt.Sprintf("failed to open the current version registry key: %v", err))
+		host.Build = formatBuild(buildNumber, 0)
+		return
+	}
+	defer currentVersionKey.Close()
+	host.ProductName, _, _ = currentVersionKey.GetStringValue("ProductName")
+	host.EditionID, _, _ = currentVersionKey.GetStringValue("EditionID")
+	host.DisplayVersion, _, _ = currentVersionKey.GetStringValue("DisplayVersion")
+	if host.DisplayVersion == "" {
+		host.DisplayVersion, _, _ = currentVersionKey.GetStringValue("ReleaseId")
+	}
+	if buildNumber == 0 {
+		currentBuild, _, _ := currentVersionKey.GetStringValue("CurrentBuildNumber")
+		parsed, _ := strconv.ParseUint(currentBuild, 10, 32)
+		buildNumber = uint32(parsed)
+	}
+	updateBuildRevision, _, _ := currentVersionKey.GetIntegerValue("UBR")
+	host.Build = formatBuild(buildNumber, updateBuildRevision)
+	if installDate, _, err := currentVersionKey.GetIntegerValue("InstallDate"); err == nil && installDate != 0 {
+		installed := time.Unix(int64(installDate), 0).UTC()
+		host.InstallDate = &installed
+	}
+}
+
+// formatBuild is a build number with its update revision, such as 19045.3803, or empty when the build isn't known.
+func formatBuild(buildNumber uint32, updateBuildRevision uint64) string {
+	switch {
+	case buildNumber == 0:
+		return ""
+	case updateBuildRevision == 0:
+		return strconv.FormatUint(uint64(buildNumber), 10)
+	default:
+		return fmt.Sprintf("%d.%d", buildNumber, updateBuildRevision)
+	}
+}
+
+// volumeSerial reads a volume's serial number, file system and label.
+func volumeSerial(volumeLetter string) (volume VolumeSerial) {
+	volume.Letter = volumeLetter
+	rootPath, err := windows.UTF16PtrFromString(volumeLetter + `:\`)
+	if err != nil {
+		volume.Error = err.Error()
+		return
+	}
+	label := make([]uint16, windows.MAX_PATH+1)
+	fileSystem := make([]uint16, windows.MAX_PATH+1)
+	var serialNumber, maximumComponentLength, fileSystemFlags uint32
+	err = windows.GetVolumeInformation(rootPath, &label[0], uint32(len(label)), &serialNumber, &maximumComponentLength, &fileSystemFlags, &fileSystem[0], uint32(len(fileSystem)))
+	if err != nil {
+		volume.Error = fmt.Sprintf("failed to get the volume information: %v", err)
+		return
+	}
+	volume.SerialNumber = formatVolumeSerial(serialNumber)
+	volume.FileSystem = windows.UTF16ToString(fileSystem)
+	volume.Label = windows.UTF16ToString(label)
+	return
+}
+
+// formatVolumeSerial formats a volume serial number the way vol and dir show it.
+func formatVolumeSerial(serialNumber uint32) string {
+	return fmt.Sprintf("%04X-%04X", serialNumber>>16, serialNumber&0xffff)
+}
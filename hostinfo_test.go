@@ -0,0 +1,68 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+)
+
+func Test_formatBuild(t *testing.T) {
+	tests := []struct {
+		name                string
+		buildNumber         uint32
+		updateBuildRevision uint64
+		want                string
+	}{
+		{name: "with revision", buildNumber: 19045, updateBuildRevision: 3803, want: "19045.3803"},
+		{name: "without revision", buildNumber: 7601, want: "7601"},
+		{name: "unknown", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatBuild(tt.buildNumber, tt.updateBuildRevision); got != tt.want {
+				t.Errorf("formatBuild() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatVolumeSerial(t *testing.T) {
+	tests := []struct {
+		serialNumber uint32
+		want         string
+	}{
+		{serialNumber: 0x1a2b3c4d, want: "1A2B-3C4D"},
+		{serialNumber: 0x0000beef, want: "0000-BEEF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatVolumeSerial(tt.serialNumber); got != tt.want {
+				t.Errorf("formatVolumeSerial() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollect_host(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(new(bytes.Buffer))}
+	report, err := CollectWithReport(context.Background(), handler, exportList, &resultWriter, CollectOptions{})
+	if err != nil {
+		t.Fatalf("CollectWithReport() error = %v", err)
+	}
+	if report.Host == nil {
+		t.Fatal("CollectWithReport() didn't report the host")
+	}
+	if report.Host.CapturedAt.IsZero() || report.Host.TimeZone == "" {
+		t.Errorf("CollectWithReport() reported host %+v, want when it was captured and its time zone", report.Host)
+	}
+	if len(report.Host.Volumes) != 1 || report.Host.Volumes[0].Letter != "c" {
+		t.Errorf("CollectWithReport() reported host volumes %+v, want volume c", report.Host.Volumes)
+	}
+}
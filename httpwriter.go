@@ -0,0 +1,164 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HttpResultWriter uploads the collection as a zip to an HTTPS endpoint while it is being collected, so nothing is
+// written to the endpoint's disk. The zip is sent in chunks, each one POSTed to URL with a Content-Range header giving
+// its place in the upload and an Upload-ID header that is the same for every chunk of one collection. The last chunk's
+// Content-Range carries the total size.
+//
+// A chunk that fails is retried with an exponential backoff. Before retrying, the writer sends a HEAD request with the
+// Upload-ID and resumes from the end of the Range header in the reply, so the server only needs to keep what it has
+// received to let an upload survive a flaky link. Replying to a chunk with 308 and a Range header has the writer resend
+// the part of the chunk after it.
+type HttpResultWriter struct {
+	URL        string
+	Client     *http.Client  // defaults to http.DefaultClient
+	Header     http.Header   // extra headers sent with every request, e.g. Authorization
+	ChunkSize  int           // defaults to 8 MiB
+	MaxRetries int           // retries per chunk, defaults to 10
+	RetryWait  time.Duration // wait before the first retry, doubled for each one after up to a minute, defaults to 1s
+	Codec      string        // see ZipResultWriter
+	Logger     Logger        // see ZipResultWriter
+}
+
+// ResultWriter will upload found files as a zip. If ctx is cancelled the zip is closed out and what's left of it
+// uploaded on a best effort basis.
+func (httpResultWriter *HttpResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	randomID := make([]byte, 16)
+	_, err = rand.Read(randomID)
+	if err != nil {
+		err = fmt.Errorf("failed to generate an upload id: %w", err)
+		drainFileReaders(fileReaders)
+		return
+	}
+	header := http.Header{}
+	for name, values := range httpResultWriter.Header {
+		header[name] = values
+	}
+	header.Set("Upload-ID", hex.EncodeToString(randomID))
+	target := &rangeUploadTarget{
+		url:    httpResultWriter.URL,
+		method: http.MethodPost,
+		client: httpResultWriter.Client,
+		header: header,
+	}
+	uploader := newChunkedUploader(ctx, httpResultWriter.Logger, target, httpResultWriter.ChunkSize, httpResultWriter.MaxRetries, httpResultWriter.RetryWait)
+	err = uploadResults(ctx, fileReaders, uploader, httpResultWriter.Codec)
+	return
+}
+
+// rangeUploadTarget sends each chunk with a Content-Range header and learns how much was received from the Range header
+// in the reply. This is the HttpResultWriter protocol, and also how GCS resumable uploads work apart from asking for the
+// status with an empty PUT instead of a HEAD.
+type rangeUploadTarget struct {
+	url         string
+	method      string
+	client      *http.Client // defaults to http.DefaultClient
+	header      http.Header
+	description string // used in logs instead of url when set, for urls that work as credentials
+	gcsStatus   bool   // query the status the GCS way
+}
+
+func (target *rangeUploadTarget) String() string {
+	if target.description != "" {
+		return target.description
+	}
+	return fmt.Sprintf("%s (upload %s)", target.url, target.header.Get("Upload-ID"))
+}
+
+// send uploads part of a chunk.
+func (target *rangeUploadTarget) send(ctx context.Context, data []byte, start int64, final bool) (committed int64, err error) {
+	request, err := http.NewRequest(target.method, target.url, bytes.NewReader(data))
+	if err != nil {
+		err = permanentUploadError{err}
+		return
+	}
+	total := "*"
+	if final {
+		total = strconv.FormatInt(start+int64(len(data)), 10)
+	}
+	if len(data) == 0 {
+		request.Header.Set("Content-Range", fmt.Sprintf("bytes */%s", total))
+	} else {
+		request.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, start+int64(len(data))-1, total))
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	response, err := target.do(ctx, request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		committed = parseUploadRange(response.Header.Get("Range"), start+int64(len(data)))
+	case response.StatusCode == http.StatusPermanentRedirect:
+		committed = parseUploadRange(response.Header.Get("Range"), start)
+	default:
+		err = uploadStatusError(response)
+	}
+	return
+}
+
+// query asks the server how much of the upload it has. GCS leaves the Range header out when it has nothing yet.
+func (target *rangeUploadTarget) query(ctx context.Context) (committed int64, err error) {
+	method := http.MethodHead
+	if target.gcsStatus {
+		method = http.MethodPut
+	}
+	request, err := http.NewRequest(method, target.url, nil)
+	if err != nil {
+		return
+	}
+	if target.gcsStatus {
+		request.Header.Set("Content-Range", "bytes */*")
+	}
+	response, err := target.do(ctx, request)
+	if err != nil {
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 && response.StatusCode != http.StatusPermanentRedirect {
+		err = uploadStatusError(response)
+		return
+	}
+	if target.gcsStatus && response.StatusCode < 300 {
+		// GCS has the whole upload already, the last chunk's reply was lost
+		committed = math.MaxInt64
+		return
+	}
+	rangeHeader := response.Header.Get("Range")
+	if rangeHeader == "" && !target.gcsStatus {
+		err = fmt.Errorf("server didn't say how much of the upload to %s it has", target)
+		return
+	}
+	committed = parseUploadRange(rangeHeader, 0)
+	return
+}
+
+func (target *rangeUploadTarget) do(ctx context.Context, request *http.Request) (response *http.Response, err error) {
+	for name, values := range target.header {
+		request.Header[name] = values
+	}
+	client := target.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err = client.Do(request.WithContext(ctx))
+	return
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// uploadServer keeps what it receives per Upload-ID. When flaky it keeps only half of every other chunk and fails the
+// request, the way a dropped connection would.
+type uploadServer struct {
+	mutex    sync.Mutex
+	uploads  map[string][]byte
+	complete map[string]bool
+	flaky    bool
+	requests int
+}
+
+var contentRangePattern = regexp.MustCompile(`^bytes (?:(\d+)-(\d+)|\*)/(\d+|\*)$`)
+
+func (server *uploadServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	uploadID := request.Header.Get("Upload-ID")
+	received := server.uploads[uploadID]
+	if request.Method == http.MethodHead {
+		if len(received) > 0 {
+			writer.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(received)-1))
+		}
+		writer.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+
+	server.requests++
+	match := contentRangePattern.FindStringSubmatch(request.Header.Get("Content-Range"))
+	if match == nil {
+		http.Error(writer, "bad Content-Range", http.StatusBadRequest)
+		return
+	}
+	body, _ := ioutil.ReadAll(request.Body)
+	if match[1] != "" {
+		start, _ := strconv.Atoi(match[1])
+		if start != len(received) {
+			http.Error(writer, "out of order", http.StatusConflict)
+			return
+		}
+		if server.flaky && server.requests%2 == 1 {
+			server.uploads[uploadID] = append(received, body[:len(body)/2]...)
+			http.Error(writer, "connection dropped", http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, body...)
+		server.uploads[uploadID] = received
+	}
+	if match[3] != "*" {
+		total, _ := strconv.Atoi(match[3])
+		server.complete[uploadID] = total == len(received)
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+func TestHttpResultWriter_ResultWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		flaky bool
+	}{
+		{name: "reliable link", flaky: false},
+		{name: "flaky link", flaky: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &uploadServer{uploads: make(map[string][]byte), complete: make(map[string]bool), flaky: tt.flaky}
+			testServer := httptest.NewServer(server)
+			defer testServer.Close()
+
+			resultWriter := HttpResultWriter{
+				URL:       testServer.URL,
+				ChunkSize: 1024,
+				RetryWait: time.Millisecond,
+				Codec:     "store",
+			}
+			content := bytes.Repeat([]byte("evidence"), 1000)
+			fileReaders := make(chan fileReader, 1)
+			fileReaders <- fileReader{fullPath: `c:\evidence.bin`, reader: bytes.NewReader(content)}
+			close(fileReaders)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			if err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+				t.Fatalf("HttpResultWriter.ResultWriter() error = %v", err)
+			}
+
+			if len(server.uploads) != 1 {
+				t.Fatalf("server got %d uploads, want 1", len(server.uploads))
+			}
+			for uploadID, upload := range server.uploads {
+				if !server.complete[uploadID] {
+					t.Errorf("upload %s was never completed", uploadID)
+				}
+				zipReader, err := zip.NewReader(bytes.NewReader(upload), int64(len(upload)))
+				if err != nil {
+					t.Fatalf("zip.NewReader() error = %v", err)
+				}
+				reader, _ := zipReader.File[0].Open()
+				got, _ := ioutil.ReadAll(reader)
+				if !bytes.Equal(got[:len(content)], content) {
+					t.Errorf("uploaded file doesn't match what was collected")
+				}
+			}
+		})
+	}
+}
+
+func TestHttpResultWriter_permanentFailure(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "forbidden", http.StatusForbidden)
+	}))
+	defer testServer.Close()
+
+	resultWriter := HttpResultWriter{URL: testServer.URL, ChunkSize: 1024, RetryWait: time.Millisecond}
+	fileReaders := make(chan fileReader, 1)
+	fileReaders <- fileReader{fullPath: `c:\evidence.bin`, reader: bytes.NewReader(make([]byte, 4096))}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+	if err == nil || !isPermanentUploadError(err) {
+		t.Errorf("HttpResultWriter.ResultWriter() error = %v, want a permanent upload error", err)
+	}
+}
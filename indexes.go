@@ -0,0 +1,352 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	indexDirectory        = "i30"
+	indexRootName         = "$INDEX_ROOT"
+	indexAllocationName   = "$INDEX_ALLOCATION"
+	indexEntriesName      = "entries.json"
+	codeIndexRoot         = 0x90
+	codeIndexAllocation   = 0xa0
+	fileNameIndexName     = "$I30"
+	indexSourceRoot       = "index_root"
+	indexSourceAllocation = "index_allocation"
+)
+
+// IndexDirectory is a directory whose $I30 index is collected. Raw writes the $INDEX_ROOT and $INDEX_ALLOCATION
+// attributes as they are, Parsed lists the entries in them as JSON, including the ones left behind in the slack of the
+// index records by files that have since been deleted or renamed. Setting neither does both.
+type IndexDirectory struct {
+	Path   string `json:"path"` // e.g. C:\Windows\Prefetch or %SYSTEMDRIVE%:\Windows\Temp
+	Raw    bool   `json:"raw"`
+	Parsed bool   `json:"parsed"`
+}
+
+// IndexEntry is a file listed in a directory's $I30 index.
+type IndexEntry struct {
+	Name          string    `json:"name"`
+	RecordNumber  uint32    `json:"record_number"`
+	Sequence      uint16    `json:"sequence"`
+	Created       time.Time `json:"created"`
+	Modified      time.Time `json:"modified"`
+	Changed       time.Time `json:"changed"`
+	Accessed      time.Time `json:"accessed"`
+	Size          int64     `json:"size"`
+	AllocatedSize int64     `json:"allocated_size"`
+	Source        string    `json:"source"`          // index_root or index_allocation
+	Slack         bool      `json:"slack,omitempty"` // carved out of the unused space of an index record
+}
+
+var driveLetterPath = regexp.MustCompile(`^[a-z]:(\\|$)`)
+
+// normalizeIndexDirectories lowercases the directories' paths, puts in the system drive and checks each starts with a
+// drive letter.
+func normalizeIndexDirectories(directories []IndexDirectory) (normalized []IndexDirectory, err error) {
+	systemDrive := strings.ToLower(strings.TrimSuffix(os.Getenv("SYSTEMDRIVE"), ":"))
+	for _, directory := range directories {
+		directory.Path = strings.TrimRight(strings.ToLower(directory.Path), `\`)
+		directory.Path = strings.Replace(directory.Path, "%systemdrive%", systemDrive, 1)
+		if !driveLetterPath.MatchString(directory.Path) {
+			err = fmt.Errorf("the index directory '%s' doesn't start with a drive letter", directory.Path)
+			return nil, err
+		}
+		if !directory.Raw && !directory.Parsed {
+			directory.Raw, directory.Parsed = true, true
+		}
+		normalized = append(normalized, directory)
+	}
+	return
+}
+
+// addIndexVolumes adds the volumes of the index directories that none of the targets are on.
+func addIndexVolumes(volumesOfInterest []string, directories []IndexDirectory) []string {
+	for _, directory := range directories {
+		volume := directory.Path[:1]
+		tracked := false
+		for _, volumeOfInterest := range volumesOfInterest {
+			if volumeOfInterest == volume {
+				tracked = true
+				break
+			}
+		}
+		if !tracked {
+			volumesOfInterest = append(volumesOfInterest, volume)
+		}
+	}
+	return volumesOfInterest
+}
+
+// collectIndexes writes the $I30 indexes of the IndexDirectories on a volume into the output under i30. The directory
+// records are found with the directory tree and record offsets from the MFT search.
+func (collector *Collector) collectIndexes(ctx context.Context, volumeHandler *VolumeHandler, directoryTree mft.DirectoryTree, fileReaders chan fileReader) (err error) {
+	for _, directory := range collector.Options.IndexDirectories {
+		if !strings.HasPrefix(directory.Path, volumeHandler.VolumeLetter+":") {
+			continue
+		}
+		recordNumber, found := directoryRecordNumber(directoryTree, directory.Path)
+		if !found {
+			volumeHandler.logger().Warnf("Could not find the directory '%s' to collect its index.", directory.Path)
+			collector.report.fileFailed(directory.Path, volumeHandler.VolumeLetter, fmt.Errorf("the directory '%s' wasn't found in the mft", directory.Path))
+			continue
+		}
+		root, allocation, readErr := readDirectoryIndex(volumeHandler, volumeHandler.recordOffsets[recordNumber])
+		if readErr != nil {
+			collector.report.fileFailed(directory.Path, volumeHandler.VolumeLetter, fmt.Errorf("failed to read the index of '%s': %w", directory.Path, readErr))
+			continue
+		}
+		outputPath := indexDirectory + `\` + strings.Replace(directory.Path, ":", "", 1)
+		var outputs []fileReader
+		if directory.Raw {
+			outputs = append(outputs, fileReader{fullPath: outputPath + `\` + indexRootName, reader: bytes.NewReader(root), method: readMethodRaw})
+			if allocation != nil {
+				outputs = append(outputs, fileReader{fullPath: outputPath + `\` + indexAllocationName, reader: bytes.NewReader(allocation), method: readMethodRaw})
+			}
+		}
+		if directory.Parsed {
+			data, _ := json.MarshalIndent(parseDirectoryIndex(root, allocation), "", "  ")
+			outputs = append(outputs, fileReader{fullPath: outputPath + `\` + indexEntriesName, reader: bytes.NewReader(data), method: readMethodRaw})
+		}
+		for _, output := range outputs {
+			err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(output, volumeHandler.VolumeLetter))
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// directoryRecordNumber looks up the MFT record of a directory by its lowercased path.
+func directoryRecordNumber(directoryTree mft.DirectoryTree, path string) (recordNumber uint32, found bool) {
+	for number, directoryPath := range directoryTree {
+		if strings.ToLower(directoryPath) == path {
+			return number, true
+		}
+	}
+	return
+}
+
+// readDirectoryIndex reads a directory's MFT record and returns the value of its $I30 $INDEX_ROOT attribute and the
+// clusters of its $I30 $INDEX_ALLOCATION attribute, which small directories don't have.
+func readDirectoryIndex(volumeHandler *VolumeHandler, recordOffset int64) (root []byte, allocation []byte, err error) {
+	const (
+		offsetValueLength   = 0x10
+		offsetValueOffset   = 0x14
+		offsetRunListOffset = 0x20
+		headerLength        = 0x40
+	)
+	_, err = volumeHandler.Handle.Seek(recordOffset, 0)
+	if err != nil {
+		return
+	}
+	record := mft.RawMasterFileTableRecord(make([]byte, volumeHandler.Vbr.MftRecordSize))
+	_, err = volumeHandler.Handle.Read(record)
+	if err != nil {
+		return
+	}
+	rawRecordHeader, err := record.GetRawRecordHeader()
+	if err != nil {
+		return
+	}
+	recordHeader, err := rawRecordHeader.Parse()
+	if err != nil {
+		return
+	}
+
+	attribute, found := namedAttribute(record, recordHeader.AttributesOffset, codeIndexRoot, fileNameIndexName)
+	if !found || len(attribute) < offsetValueOffset+2 {
+		err = fmt.Errorf("the record at offset %d has no %s %s attribute", recordOffset, fileNameIndexName, indexRootName)
+		return
+	}
+	valueLength := int(binary.LittleEndian.Uint32(attribute[offsetValueLength:]))
+	valueOffset := int(binary.LittleEndian.Uint16(attribute[offsetValueOffset:]))
+	if valueOffset+valueLength > len(attribute) {
+		err = fmt.Errorf("the %s attribute at offset %d is truncated", indexRootName, recordOffset)
+		return
+	}
+	root = append([]byte(nil), attribute[valueOffset:valueOffset+valueLength]...)
+
+	attribute, found = namedAttribute(record, recordHeader.AttributesOffset, codeIndexAllocation, fileNameIndexName)
+	if !found {
+		return
+	}
+	if len(attribute) < headerLength {
+		err = fmt.Errorf("the %s attribute at offset %d is truncated", indexAllocationName, recordOffset)
+		return
+	}
+	runListOffset := int(binary.LittleEndian.Uint16(attribute[offsetRunListOffset:]))
+	if runListOffset > len(attribute) {
+		err = fmt.Errorf("the %s attribute at offset %d has a bad run list", indexAllocationName, recordOffset)
+		return
+	}
+	extents, ok := parseRunList(attribute[runListOffset:], 0)
+	if !ok {
+		err = fmt.Errorf("the %s attribute at offset %d has a bad run list", indexAllocationName, recordOffset)
+		return
+	}
+	dataRuns := make(mft.DataRuns)
+	for _, extent := range extents {
+		dataRuns[len(dataRuns)] = mft.DataRun{
+			AbsoluteOffset: extent.lcn * volumeHandler.Vbr.BytesPerCluster,
+			Length:         extent.clusters * volumeHandler.Vbr.BytesPerCluster,
+		}
+	}
+	allocation, err = ioutil.ReadAll(rawFileReader(volumeHandler, foundFile{dataRuns: dataRuns, fullPath: fmt.Sprintf("%s %s", indexAllocationName, fileNameIndexName)}))
+	return
+}
+
+// parseDirectoryIndex lists the entries of a directory's index, going through the node in $INDEX_ROOT and every INDX
+// record in $INDEX_ALLOCATION. The space past the end of each node's entries is carved for the entries it used to hold.
+func parseDirectoryIndex(root []byte, allocation []byte) (entries []IndexEntry) {
+	const (
+		offsetIndexRecordSize = 0x08
+		rootNodeHeader        = 0x10
+		recordNodeHeader      = 0x18
+		indexRecordMagic      = "INDX"
+	)
+	entries = make([]IndexEntry, 0)
+	if len(root) < rootNodeHeader+0x10 {
+		return
+	}
+	entries = append(entries, parseIndexNode(root, rootNodeHeader, indexSourceRoot)...)
+
+	indexRecordSize := int(binary.LittleEndian.Uint32(root[offsetIndexRecordSize:]))
+	if indexRecordSize <= 0 {
+		return
+	}
+	for offset := 0; offset+indexRecordSize <= len(allocation); offset += indexRecordSize {
+		record := allocation[offset : offset+indexRecordSize]
+		if string(record[:4]) != indexRecordMagic {
+			continue
+		}
+		// A record with a torn write is still worth carving, so fall back to it as it is
+		fixed, ok := applyUpdateSequence(mft.RawMasterFileTableRecord(record))
+		if !ok {
+			fixed = record
+		}
+		entries = append(entries, parseIndexNode(fixed, recordNodeHeader, indexSourceAllocation)...)
+	}
+	return
+}
+
+// parseIndexNode lists the entries of an index node whose header is at headerOffset, and carves the ones left in its
+// slack.
+func parseIndexNode(node []byte, headerOffset int, source string) (entries []IndexEntry) {
+	const (
+		offsetEntriesOffset = 0x00
+		offsetIndexLength   = 0x04
+		offsetAllocatedSize = 0x08
+		offsetEntryLength   = 0x08
+		offsetEntryFlags    = 0x0c
+		entryHeaderLength   = 0x10
+		flagLastEntry       = 0x02
+	)
+	if headerOffset+0x10 > len(node) {
+		return
+	}
+	start := headerOffset + int(binary.LittleEndian.Uint32(node[headerOffset+offsetEntriesOffset:]))
+	used := headerOffset + int(binary.LittleEndian.Uint32(node[headerOffset+offsetIndexLength:]))
+	allocated := headerOffset + int(binary.LittleEndian.Uint32(node[headerOffset+offsetAllocatedSize:]))
+	if used > len(node) {
+		used = len(node)
+	}
+	if allocated > len(node) {
+		allocated = len(node)
+	}
+
+	offset := start
+	for offset+entryHeaderLength <= used {
+		entryLength := int(binary.LittleEndian.Uint16(node[offset+offsetEntryLength:]))
+		flags := binary.LittleEndian.Uint32(node[offset+offsetEntryFlags:])
+		if flags&flagLastEntry != 0 || entryLength < entryHeaderLength {
+			break
+		}
+		if entry, ok := parseIndexEntry(node[offset:used]); ok {
+			entry.Source = source
+			entries = append(entries, entry)
+		}
+		offset += entryLength
+	}
+
+	// Entries are 8 byte aligned, so the slack is carved at every 8 bytes
+	for offset = used + (8-used%8)%8; offset+entryHeaderLength <= allocated; {
+		entry, ok := parseIndexEntry(node[offset:allocated])
+		if !ok {
+			offset += 8
+			continue
+		}
+		entry.Source, entry.Slack = source, true
+		entries = append(entries, entry)
+		offset += int(binary.LittleEndian.Uint16(node[offset+offsetEntryLength:]))
+	}
+	return
+}
+
+// parseIndexEntry parses an index entry holding a $FILE_NAME. Since it's also used to carve slack it only accepts
+// entries whose lengths, name and timestamps make sense.
+func parseIndexEntry(data []byte) (entry IndexEntry, ok bool) {
+	const (
+		offsetEntryLength   = 0x08
+		offsetContentLength = 0x0a
+		offsetContent       = 0x10
+		offsetCreated       = 0x08
+		offsetModified      = 0x10
+		offsetChanged       = 0x18
+		offsetAccessed      = 0x20
+		offsetAllocatedSize = 0x28
+		offsetRealSize      = 0x30
+		offsetNameLength    = 0x40
+		offsetNamespace     = 0x41
+		offsetName          = 0x42
+		maximumNamespace    = 3
+	)
+	if len(data) < offsetContent+offsetName {
+		return
+	}
+	entryLength := int(binary.LittleEndian.Uint16(data[offsetEntryLength:]))
+	contentLength := int(binary.LittleEndian.Uint16(data[offsetContentLength:]))
+	content := data[offsetContent:]
+	nameLength := int(content[offsetNameLength])
+	if nameLength == 0 || content[offsetNamespace] > maximumNamespace || contentLength != offsetName+nameLength*2 ||
+		entryLength < offsetContent+contentLength || entryLength > len(data) {
+		return
+	}
+	entry = IndexEntry{
+		RecordNumber:  uint32(binary.LittleEndian.Uint64(data) & 0xffffffffffff),
+		Sequence:      binary.LittleEndian.Uint16(data[6:]),
+		Created:       fromFiletime(int64(binary.LittleEndian.Uint64(content[offsetCreated:]))),
+		Modified:      fromFiletime(int64(binary.LittleEndian.Uint64(content[offsetModified:]))),
+		Changed:       fromFiletime(int64(binary.LittleEndian.Uint64(content[offsetChanged:]))),
+		Accessed:      fromFiletime(int64(binary.LittleEndian.Uint64(content[offsetAccessed:]))),
+		AllocatedSize: int64(binary.LittleEndian.Uint64(content[offsetAllocatedSize:])),
+		Size:          int64(binary.LittleEndian.Uint64(content[offsetRealSize:])),
+	}
+	for _, timestamp := range []time.Time{entry.Created, entry.Modified, entry.Changed, entry.Accessed} {
+		if timestamp.Year() < 1990 || timestamp.Year() > 2100 {
+			return entry, false
+		}
+	}
+	name := make([]uint16, nameLength)
+	for index := range name {
+		name[index] = binary.LittleEndian.Uint16(content[offsetName+index*2:])
+	}
+	entry.Name = string(utf16.Decode(name))
+	ok = true
+	return
+}
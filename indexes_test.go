@@ -0,0 +1,155 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// testIndexEntry builds an index entry for a $FILE_NAME with every timestamp set to when.
+func testIndexEntry(recordNumber uint64, name string, size int64, when time.Time) []byte {
+	encoded := utf16.Encode([]rune(name))
+	contentLength := 0x42 + len(encoded)*2
+	entryLength := (0x10 + contentLength + 7) / 8 * 8
+	entry := make([]byte, entryLength)
+	binary.LittleEndian.PutUint64(entry, recordNumber|1<<48)
+	binary.LittleEndian.PutUint16(entry[0x08:], uint16(entryLength))
+	binary.LittleEndian.PutUint16(entry[0x0a:], uint16(contentLength))
+	content := entry[0x10:]
+	binary.LittleEndian.PutUint64(content, 5)
+	filetime := uint64(when.UnixNano()/100) + 116444736000000000
+	for _, offset := range []int{0x08, 0x10, 0x18, 0x20} {
+		binary.LittleEndian.PutUint64(content[offset:], filetime)
+	}
+	binary.LittleEndian.PutUint64(content[0x28:], uint64((size+4095)/4096*4096))
+	binary.LittleEndian.PutUint64(content[0x30:], uint64(size))
+	content[0x40] = byte(len(encoded))
+	content[0x41] = 1
+	for index, character := range encoded {
+		binary.LittleEndian.PutUint16(content[0x42+index*2:], character)
+	}
+	return entry
+}
+
+// testIndexNode lays out entries and a last entry after a node header at headerOffset, followed by slack holding the
+// slack bytes.
+func testIndexNode(headerOffset int, entries [][]byte, slack []byte, allocated int) []byte {
+	node := make([]byte, headerOffset+allocated)
+	offset := headerOffset + 0x10
+	for _, entry := range entries {
+		offset += copy(node[offset:], entry)
+	}
+	binary.LittleEndian.PutUint16(node[offset+0x08:], 0x10)
+	binary.LittleEndian.PutUint32(node[offset+0x0c:], 0x02)
+	offset += 0x10
+	binary.LittleEndian.PutUint32(node[headerOffset:], 0x10)
+	binary.LittleEndian.PutUint32(node[headerOffset+0x04:], uint32(offset-headerOffset))
+	binary.LittleEndian.PutUint32(node[headerOffset+0x08:], uint32(allocated))
+	copy(node[offset:], slack)
+	return node
+}
+
+func Test_parseDirectoryIndex(t *testing.T) {
+	when := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	root := testIndexNode(0x10, [][]byte{testIndexEntry(40, "notepad.exe-d8414f97.pf", 10000, when)}, nil, 0x100)
+	binary.LittleEndian.PutUint32(root[0x08:], 1024)
+
+	// The deleted entry is a few bytes into the slack, past the remains of a longer entry
+	slack := append(make([]byte, 24), testIndexEntry(41, "mimikatz.exe-5f3a12bc.pf", 2048, when)...)
+	record := testIndexNode(0x18, [][]byte{testIndexEntry(42, "cmd.exe-0bd30981.pf", 5000, when)}, slack, 1024-0x18)
+	copy(record, "INDX")
+	allocation := append(record, make([]byte, 1024)...)
+
+	want := []IndexEntry{
+		{Name: "notepad.exe-d8414f97.pf", RecordNumber: 40, Sequence: 1, Size: 10000, AllocatedSize: 12288, Source: indexSourceRoot},
+		{Name: "cmd.exe-0bd30981.pf", RecordNumber: 42, Sequence: 1, Size: 5000, AllocatedSize: 8192, Source: indexSourceAllocation},
+		{Name: "mimikatz.exe-5f3a12bc.pf", RecordNumber: 41, Sequence: 1, Size: 2048, AllocatedSize: 4096, Source: indexSourceAllocation, Slack: true},
+	}
+	for index := range want {
+		want[index].Created, want[index].Modified, want[index].Changed, want[index].Accessed = when, when, when, when
+	}
+	got := parseDirectoryIndex(root, allocation)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDirectoryIndex() = %+v, want %+v", got, want)
+	}
+
+	if got := parseDirectoryIndex(nil, nil); len(got) != 0 {
+		t.Errorf("parseDirectoryIndex() of nothing = %+v, want no entries", got)
+	}
+}
+
+func Test_parseIndexEntry(t *testing.T) {
+	when := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	badNamespace := testIndexEntry(40, "a.txt", 1, when)
+	badNamespace[0x10+0x41] = 9
+	badLength := testIndexEntry(40, "a.txt", 1, when)
+	binary.LittleEndian.PutUint16(badLength[0x0a:], 0x50)
+	badTime := testIndexEntry(40, "a.txt", 1, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name   string
+		data   []byte
+		wantOk bool
+	}{
+		{name: "entry", data: testIndexEntry(40, "a.txt", 1, when), wantOk: true},
+		{name: "zeros", data: make([]byte, 0x100)},
+		{name: "truncated", data: testIndexEntry(40, "a.txt", 1, when)[:0x40]},
+		{name: "bad namespace", data: badNamespace},
+		{name: "bad content length", data: badLength},
+		{name: "implausible timestamps", data: badTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, ok := parseIndexEntry(tt.data)
+			if ok != tt.wantOk {
+				t.Errorf("parseIndexEntry() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if ok && entry.Name != "a.txt" {
+				t.Errorf("parseIndexEntry() name = %s, want a.txt", entry.Name)
+			}
+		})
+	}
+}
+
+func Test_normalizeIndexDirectories(t *testing.T) {
+	tests := []struct {
+		name        string
+		directories []IndexDirectory
+		want        []IndexDirectory
+		wantErr     bool
+	}{
+		{
+			name:        "both by default",
+			directories: []IndexDirectory{{Path: `C:\Windows\Prefetch\`}},
+			want:        []IndexDirectory{{Path: `c:\windows\prefetch`, Raw: true, Parsed: true}},
+		},
+		{
+			name:        "parsed",
+			directories: []IndexDirectory{{Path: `D:\`, Parsed: true}},
+			want:        []IndexDirectory{{Path: `d:`, Parsed: true}},
+		},
+		{
+			name:        "no drive letter",
+			directories: []IndexDirectory{{Path: `\Windows\Prefetch`}},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeIndexDirectories(tt.directories)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("normalizeIndexDirectories() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeIndexDirectories() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if got := addIndexVolumes([]string{"c"}, []IndexDirectory{{Path: `c:\windows`}, {Path: `e:\data`}}); !reflect.DeepEqual(got, []string{"c", "e"}) {
+		t.Errorf("addIndexVolumes() = %v, want [c e]", got)
+	}
+}
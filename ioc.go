@@ -0,0 +1,388 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// IOC is a file indicator of compromise from threat intel: a file name, found anywhere on the system drive, a full path
+// or a SHA-256. A name or path can come with the SHA-256 the file has to have too.
+type IOC struct {
+	FileName string
+	FullPath string
+	SHA256   string
+}
+
+// iocVariables are the environment variables IOC paths often start with, as the paths they stand for on most machines.
+// Everything in a user profile goes through %user% so it matches every profile.
+var iocVariables = map[string]string{
+	"systemdrive":       `C:`,
+	"systemroot":        `C:\Windows`,
+	"windir":            `C:\Windows`,
+	"programfiles":      `C:\Program Files`,
+	"programfiles(x86)": `C:\Program Files (x86)`,
+	"programdata":       `C:\ProgramData`,
+	"allusersprofile":   `C:\ProgramData`,
+	"public":            `C:\Users\Public`,
+	"userprofile":       `C:\Users\%user%`,
+	"appdata":           `C:\Users\%user%\AppData\Roaming`,
+	"localappdata":      `C:\Users\%user%\AppData\Local`,
+	"temp":              `C:\Users\%user%\AppData\Local\Temp`,
+	"tmp":               `C:\Users\%user%\AppData\Local\Temp`,
+}
+
+var (
+	iocVariablePattern = regexp.MustCompile(`^%([^%]+)%`)
+	iocHexPattern      = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	// stixComparisonPattern matches the comparisons of a STIX 2 pattern that select files: file:name,
+	// file:parent_directory_ref.path and file:hashes.
+	stixComparisonPattern = regexp.MustCompile(`(?i)file:(name|parent_directory_ref\.path|hashes\.(?:'[^']+'|"[^"]+"|[a-z0-9-]+))\s*(=|!=|MATCHES|LIKE|IN)\s*'((?:\\.|[^'\\])*)'`)
+)
+
+// LoadIOCs reads a file of IOCs, in STIX 2 JSON or CSV, and turns them into targets with IOCTargets. Non-STIX patterns,
+// CSV rows without a type and value, and hashes other than SHA-256 are returned in skipped with the reason.
+func LoadIOCs(path string, hashCandidates string) (exportList ListOfFilesToExport, skipped []SkippedTarget, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("LoadIOCs() failed to read %s: %w", path, err)
+		return
+	}
+	iocs, skipped, err := ParseIOCs(data)
+	if err != nil {
+		return
+	}
+	exportList, skippedTargets := IOCTargets(iocs, hashCandidates)
+	skipped = append(skipped, skippedTargets...)
+	return
+}
+
+// ParseIOCs parses IOCs in STIX 2 JSON, a bundle or a single object, or in CSV. In STIX the file comparisons of
+// indicators' patterns and file observables are used. A CSV file either has a header with type and value columns,
+// such as a MISP export, or rows of TYPE,VALUE, or just one value a row whose type is guessed. The types are
+// filename, path, sha256 and MISP's filename|sha256.
+func ParseIOCs(data []byte) (iocs []IOC, skipped []SkippedTarget, err error) {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case len(trimmed) == 0:
+		err = errors.New("ParseIOCs() found no IOCs")
+	case trimmed[0] == '{':
+		iocs, skipped, err = parseSTIXIOCs(trimmed)
+	case trimmed[0] == '<':
+		err = errors.New("ParseIOCs() only reads STIX 2 JSON, not STIX 1 XML")
+	default:
+		iocs, skipped, err = parseCSVIOCs(trimmed)
+	}
+	return
+}
+
+type stixObject struct {
+	Type               string            `json:"type"`
+	ID                 string            `json:"id"`
+	Pattern            string            `json:"pattern"`
+	PatternType        string            `json:"pattern_type"`
+	Name               string            `json:"name"`
+	Path               string            `json:"path"`
+	Hashes             map[string]string `json:"hashes"`
+	ParentDirectoryRef string            `json:"parent_directory_ref"`
+	Objects            []stixObject      `json:"objects"`
+}
+
+func parseSTIXIOCs(data []byte) (iocs []IOC, skipped []SkippedTarget, err error) {
+	var root stixObject
+	if err = json.Unmarshal(data, &root); err != nil {
+		err = fmt.Errorf("ParseIOCs() failed to parse the STIX bundle: %w", err)
+		return
+	}
+	objects := root.Objects
+	if root.Type != "bundle" {
+		objects = []stixObject{root}
+	}
+	directories := make(map[string]string)
+	for _, object := range objects {
+		if object.Type == "directory" {
+			directories[object.ID] = object.Path
+		}
+	}
+	for _, object := range objects {
+		switch object.Type {
+		case "indicator":
+			if object.PatternType != "" && object.PatternType != "stix" {
+				skipped = append(skipped, SkippedTarget{Target: object.ID, Reason: fmt.Sprintf("its pattern is %s, not STIX", object.PatternType)})
+				continue
+			}
+			patternIOCs, patternSkipped := parseSTIXPattern(object.Pattern)
+			iocs = append(iocs, patternIOCs...)
+			skipped = append(skipped, patternSkipped...)
+		case "file":
+			ioc := IOC{FileName: object.Name}
+			if directory, found := directories[object.ParentDirectoryRef]; found && object.Name != "" {
+				ioc.FullPath = strings.TrimRight(directory, `\/`) + `\` + object.Name
+			}
+			for algorithm, hash := range object.Hashes {
+				if normalizeHashName(algorithm) == "sha256" {
+					ioc.SHA256 = hash
+				}
+			}
+			if ioc == (IOC{}) {
+				skipped = append(skipped, SkippedTarget{Target: object.ID, Reason: "the file has no name or SHA-256"})
+				continue
+			}
+			iocs = append(iocs, ioc)
+		}
+	}
+	return
+}
+
+// parseSTIXPattern turns the file comparisons of a pattern into IOCs. Comparisons joined by AND within an observation
+// describe one file, any others separate ones. Comparisons of anything but files are ignored, which can only make the
+// IOCs match more files than the pattern does.
+func parseSTIXPattern(pattern string) (iocs []IOC, skipped []SkippedTarget) {
+	var ioc IOC
+	var unsupported string
+	end := 0
+	flush := func() {
+		if ioc != (IOC{}) {
+			iocs = append(iocs, ioc)
+		} else if unsupported != "" {
+			skipped = append(skipped, SkippedTarget{Target: pattern, Reason: unsupported})
+		}
+		ioc, unsupported = IOC{}, ""
+	}
+	for _, match := range stixComparisonPattern.FindAllStringSubmatchIndex(pattern, -1) {
+		if strings.ToUpper(strings.TrimSpace(pattern[end:match[0]])) != "AND" {
+			flush()
+		}
+		end = match[1]
+		field, operator := strings.ToLower(pattern[match[2]:match[3]]), strings.ToUpper(pattern[match[4]:match[5]])
+		value := strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(pattern[match[6]:match[7]])
+		if operator != "=" {
+			unsupported = fmt.Sprintf("only = comparisons can be searched for, not %s", operator)
+			continue
+		}
+		switch {
+		case field == "name":
+			ioc.FileName = value
+		case field == "parent_directory_ref.path":
+			ioc.FullPath = strings.TrimRight(value, `\/`)
+		case normalizeHashName(strings.TrimPrefix(field, "hashes.")) == "sha256":
+			ioc.SHA256 = value
+		default:
+			unsupported = "only SHA-256 hashes can be matched"
+		}
+	}
+	flush()
+	if len(iocs) == 0 && len(skipped) == 0 {
+		skipped = append(skipped, SkippedTarget{Target: pattern, Reason: "the pattern has no file name, path or SHA-256"})
+	}
+	for index, ioc := range iocs {
+		if ioc.FullPath != "" && ioc.FileName != "" {
+			iocs[index].FullPath = ioc.FullPath + `\` + ioc.FileName
+		} else if ioc.FullPath != "" {
+			// A directory without a name would collect everything in it
+			iocs[index].FullPath = ""
+		}
+	}
+	return
+}
+
+// normalizeHashName turns the names hashes go by, e.g. SHA-256 or 'SHA256', into sha256.
+func normalizeHashName(name string) string {
+	return strings.ToLower(strings.NewReplacer("'", "", `"`, "", "-", "", "_", "").Replace(name))
+}
+
+func parseCSVIOCs(data []byte) (iocs []IOC, skipped []SkippedTarget, err error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	typeColumn, valueColumn := 0, 1
+	for row := 0; ; row++ {
+		var record []string
+		record, err = reader.Read()
+		if err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			err = fmt.Errorf("ParseIOCs() failed to parse the CSV: %w", err)
+			return
+		}
+		if row == 0 {
+			if header, found := csvIOCHeader(record); found {
+				typeColumn, valueColumn = header[0], header[1]
+				continue
+			}
+		}
+		var iocType, value string
+		switch {
+		case len(record) == 1:
+			value = strings.TrimSpace(record[0])
+			iocType = guessIOCType(value)
+		case typeColumn < len(record) && valueColumn < len(record):
+			iocType, value = strings.ToLower(strings.TrimSpace(record[typeColumn])), strings.TrimSpace(record[valueColumn])
+		default:
+			skipped = append(skipped, SkippedTarget{Target: strings.Join(record, ","), Reason: "the row has no type and value"})
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		ioc, reason := iocOfType(iocType, value)
+		if reason != "" {
+			skipped = append(skipped, SkippedTarget{Target: value, Reason: reason})
+			continue
+		}
+		iocs = append(iocs, ioc)
+	}
+}
+
+// csvIOCHeader finds the type and value columns of a header.
+func csvIOCHeader(record []string) (columns [2]int, found bool) {
+	columns = [2]int{-1, -1}
+	for index, name := range record {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "type", "indicator_type", "ioc_type":
+			columns[0] = index
+		case "value", "indicator", "ioc":
+			columns[1] = index
+		}
+	}
+	return columns, columns[0] != -1 && columns[1] != -1
+}
+
+// guessIOCType is the type of a value without one: a hash by its length, a path by its backslashes, else a file name.
+func guessIOCType(value string) string {
+	switch {
+	case iocHexPattern.MatchString(value) && len(value) == 64:
+		return "sha256"
+	case iocHexPattern.MatchString(value) && (len(value) == 32 || len(value) == 40):
+		return "md5 or sha1"
+	case strings.ContainsAny(value, `\/`):
+		return "path"
+	}
+	return "filename"
+}
+
+// iocOfType makes an IOC of a value, or gives the reason it can't.
+func iocOfType(iocType string, value string) (ioc IOC, reason string) {
+	switch iocType {
+	case "filename", "file_name", "file name", "file", "name":
+		ioc.FileName = value
+	case "path", "filepath", "file_path", "file path", "full_path", "fullpath":
+		ioc.FullPath = value
+	case "sha256", "sha-256", "hash", "filehash-sha256":
+		ioc.SHA256 = value
+	case "filename|sha256":
+		parts := strings.SplitN(value, "|", 2)
+		if len(parts) != 2 {
+			return ioc, "the value isn't a file name and a SHA-256 separated by |"
+		}
+		ioc.FileName, ioc.SHA256 = parts[0], parts[1]
+	case "md5", "sha1", "sha-1", "md5 or sha1", "filename|md5", "filename|sha1":
+		return ioc, "only SHA-256 hashes can be matched"
+	default:
+		return ioc, fmt.Sprintf("%s isn't a file name, path or SHA-256", iocType)
+	}
+	return
+}
+
+// IOCTargets turns IOCs into targets. A file name is looked for anywhere on the system drive and a path where it is,
+// with environment variables such as %APPDATA% standing for their directory in every user profile. The files of
+// SHA-256s are looked for among hashCandidates, a full path regex such as %USERPROFILE%\\.*, since every one of them
+// has to be read to hash it. The targets have a priority of 100 so a ByteBudget keeps them over others.
+func IOCTargets(iocs []IOC, hashCandidates string) (exportList ListOfFilesToExport, skipped []SkippedTarget) {
+	seen := make(map[FileToExport]bool)
+	var hashes []string
+	for _, ioc := range iocs {
+		ioc.SHA256 = strings.ToLower(strings.TrimSpace(ioc.SHA256))
+		if ioc.SHA256 != "" && (len(ioc.SHA256) != 64 || !iocHexPattern.MatchString(ioc.SHA256)) {
+			skipped = append(skipped, SkippedTarget{Target: ioc.SHA256, Reason: "not a SHA-256"})
+			continue
+		}
+		var fileToExport FileToExport
+		switch {
+		case ioc.FullPath != "":
+			var err error
+			if fileToExport, err = iocPathToFileToExport(ioc.FullPath); err != nil {
+				skipped = append(skipped, SkippedTarget{Target: ioc.FullPath, Reason: err.Error()})
+				continue
+			}
+		case ioc.FileName != "":
+			if strings.ContainsAny(ioc.FileName, `\/:`) {
+				skipped = append(skipped, SkippedTarget{Target: ioc.FileName, Reason: "not a file name"})
+				continue
+			}
+			fileToExport = FileToExport{
+				FullPath:        `%SYSTEMDRIVE%:(\\[^\\]+)*\\` + regexp.QuoteMeta(ioc.FileName) + `$`,
+				IsFullPathRegex: true,
+				FileName:        ioc.FileName,
+			}
+		case ioc.SHA256 != "":
+			if !seen[FileToExport{SHA256: ioc.SHA256}] {
+				seen[FileToExport{SHA256: ioc.SHA256}] = true
+				hashes = append(hashes, ioc.SHA256)
+			}
+			continue
+		default:
+			continue
+		}
+		fileToExport.SHA256 = ioc.SHA256
+		fileToExport.Priority = 100
+		if !seen[fileToExport] {
+			seen[fileToExport] = true
+			exportList = append(exportList, fileToExport)
+		}
+	}
+	if len(hashes) != 0 {
+		exportList = append(exportList, FileToExport{
+			FullPath:        hashCandidates,
+			IsFullPathRegex: true,
+			FileName:        `.*`,
+			IsFileNameRegex: true,
+			SHA256:          strings.Join(hashes, ","),
+			Priority:        100,
+		})
+	}
+	return
+}
+
+// iocPathToFileToExport converts the full path of an IOC, which may start with an environment variable. Wildcards in it
+// work the way they do in KAPE targets.
+func iocPathToFileToExport(fullPath string) (fileToExport FileToExport, err error) {
+	fullPath = strings.ReplaceAll(strings.TrimSpace(fullPath), "/", `\`)
+	drive := ""
+	if variable := iocVariablePattern.FindStringSubmatch(fullPath); variable != nil {
+		value, found := iocVariables[strings.ToLower(variable[1])]
+		if !found {
+			err = fmt.Errorf("the path uses %s, which can't be resolved from the MFT", variable[0])
+			return
+		}
+		fullPath = value + fullPath[len(variable[0]):]
+	} else if kapeDrivePattern.MatchString(fullPath) {
+		drive = strings.ToUpper(fullPath[:2])
+	} else {
+		err = errors.New("the path doesn't start with a drive letter or an environment variable")
+		return
+	}
+	separator := strings.LastIndex(fullPath, `\`)
+	if separator < 0 || separator == len(fullPath)-1 {
+		err = errors.New("the path has no file name")
+		return
+	}
+	if fileToExport, err = globToFileToExport(fullPath[:separator], fullPath[separator+1:], false); err != nil {
+		return
+	}
+	// The path names the drive, unlike a KAPE target's that's relative to the system drive
+	if drive != "" && strings.HasPrefix(fileToExport.FullPath, `%SYSTEMDRIVE%:`) {
+		fileToExport.FullPath = drive + strings.TrimPrefix(fileToExport.FullPath, `%SYSTEMDRIVE%:`)
+	}
+	return
+}
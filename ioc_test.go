@@ -0,0 +1,132 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestParseIOCs(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		want        []IOC
+		wantSkipped int
+		wantErr     bool
+	}{
+		{
+			name: "stix bundle",
+			data: `{"type": "bundle", "objects": [
+				{"type": "indicator", "id": "indicator--1", "pattern_type": "stix", "pattern": "[file:name = 'evil.exe' OR file:hashes.'SHA-256' = '` + testSHA256 + `']"},
+				{"type": "indicator", "id": "indicator--2", "pattern": "[file:parent_directory_ref.path = 'C:\\\\Windows\\\\Temp' AND file:name = 'drop.dll']"},
+				{"type": "indicator", "id": "indicator--3", "pattern": "[ipv4-addr:value = '10.0.0.1']"},
+				{"type": "indicator", "id": "indicator--4", "pattern": "[file:hashes.MD5 = 'd41d8cd98f00b204e9800998ecf8427e']"},
+				{"type": "indicator", "id": "indicator--5", "pattern_type": "yara", "pattern": "rule a { condition: true }"},
+				{"type": "directory", "id": "directory--1", "path": "C:\\Users\\Public"},
+				{"type": "file", "id": "file--1", "name": "note.txt", "parent_directory_ref": "directory--1"}
+			]}`,
+			want: []IOC{
+				{FileName: "evil.exe"},
+				{SHA256: testSHA256},
+				{FileName: "drop.dll", FullPath: `C:\Windows\Temp\drop.dll`},
+				{FileName: "note.txt", FullPath: `C:\Users\Public\note.txt`},
+			},
+			wantSkipped: 3,
+		},
+		{
+			name: "stix indicator",
+			data: `{"type": "indicator", "pattern": "[file:name = 'it\\'s.exe'] AND [file:name = 'other.exe']"}`,
+			want: []IOC{{FileName: "it's.exe"}, {FileName: "other.exe"}},
+		},
+		{
+			name:        "csv with a header",
+			data:        "uuid,category,type,value\n1,Payload delivery,filename,evil.exe\n2,Payload delivery,filename|sha256,drop.dll|" + testSHA256 + "\n3,Network activity,ip-dst,10.0.0.1\n",
+			want:        []IOC{{FileName: "evil.exe"}, {FileName: "drop.dll", SHA256: testSHA256}},
+			wantSkipped: 1,
+		},
+		{
+			name:        "csv of types and values",
+			data:        "# from the report\npath,%APPDATA%\\evil.exe\nsha256," + testSHA256 + "\nmd5,d41d8cd98f00b204e9800998ecf8427e\n",
+			want:        []IOC{{FullPath: `%APPDATA%\evil.exe`}, {SHA256: testSHA256}},
+			wantSkipped: 1,
+		},
+		{
+			name: "list of values",
+			data: "evil.exe\nC:\\Windows\\Temp\\drop.dll\n" + testSHA256 + "\n",
+			want: []IOC{{FileName: "evil.exe"}, {FullPath: `C:\Windows\Temp\drop.dll`}, {SHA256: testSHA256}},
+		},
+		{name: "stix 1", data: `<stix:STIX_Package/>`, wantErr: true},
+		{name: "invalid json", data: `{"type": "bundle"`, wantErr: true},
+		{name: "empty", data: " \n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, skipped, err := ParseIOCs([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIOCs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseIOCs() = %+v, want %+v", got, tt.want)
+			}
+			if len(skipped) != tt.wantSkipped {
+				t.Errorf("ParseIOCs() skipped %+v, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestIOCTargets(t *testing.T) {
+	iocs := []IOC{
+		{FileName: "evil.exe"},
+		{FileName: "evil.exe"},
+		{FullPath: `D:\Tools\drop.dll`, SHA256: strings.ToUpper(testSHA256)},
+		{FullPath: `%APPDATA%\evil.exe`},
+		{FullPath: `%COMPUTERNAME%\evil.exe`},
+		{FullPath: `Temp\evil.exe`},
+		{FileName: `Temp\evil.exe`},
+		{SHA256: testSHA256},
+		{SHA256: "d41d8cd98f00b204e9800998ecf8427e"},
+	}
+	want := ListOfFilesToExport{
+		{FullPath: `%SYSTEMDRIVE%:(\\[^\\]+)*\\evil\.exe$`, IsFullPathRegex: true, FileName: "evil.exe", Priority: 100},
+		{FullPath: `D:\Tools\drop.dll`, FileName: "drop.dll", SHA256: testSHA256, Priority: 100},
+		{FullPath: `%USERPROFILE%\AppData\Roaming\evil.exe`, FileName: "evil.exe", Priority: 100},
+		{FullPath: `%USERPROFILE%\\.*`, IsFullPathRegex: true, FileName: `.*`, IsFileNameRegex: true, SHA256: testSHA256, Priority: 100},
+	}
+	got, skipped := IOCTargets(iocs, `%USERPROFILE%\\.*`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("IOCTargets() = %+v, want %+v", got, want)
+	}
+	if len(skipped) != 4 {
+		t.Errorf("IOCTargets() skipped %+v, want 4", skipped)
+	}
+	for _, target := range got {
+		if _, err := compileSearchTerms(target); err != nil {
+			t.Errorf("compileSearchTerms(%+v) error = %v", target, err)
+		}
+	}
+}
+
+func TestLoadIOCs(t *testing.T) {
+	directory, err := ioutil.TempDir("", "iocs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "iocs.csv")
+	_ = ioutil.WriteFile(path, []byte("type,value\nfilename,evil.exe\nip-dst,10.0.0.1\n"), 0600)
+	exportList, skipped, err := LoadIOCs(path, `%USERPROFILE%\\.*`)
+	if err != nil || len(exportList) != 1 || len(skipped) != 1 {
+		t.Errorf("LoadIOCs() = %+v, skipped %+v, error = %v, want a target and a skipped IOC", exportList, skipped, err)
+	}
+	if _, _, err = LoadIOCs(filepath.Join(directory, "missing.csv"), ""); err == nil {
+		t.Error("LoadIOCs() error = nil for a missing file")
+	}
+}
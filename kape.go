@@ -0,0 +1,239 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// KapeTarget is a KAPE .tkape target definition.
+type KapeTarget struct {
+	Description string            `yaml:"Description"`
+	Author      string            `yaml:"Author"`
+	Version     string            `yaml:"Version"`
+	ID          string            `yaml:"Id"`
+	Targets     []KapeTargetEntry `yaml:"Targets"`
+}
+
+// KapeTargetEntry is one entry of a .tkape file. An entry whose Path names another .tkape file pulls in that file's
+// targets, which is how KAPE builds compound targets.
+type KapeTargetEntry struct {
+	Name      string `yaml:"Name"`
+	Category  string `yaml:"Category"`
+	Path      string `yaml:"Path"`
+	FileMask  string `yaml:"FileMask"`
+	Recursive bool   `yaml:"Recursive"`
+	Comment   string `yaml:"Comment"`
+}
+
+var (
+	kapeDrivePattern    = regexp.MustCompile(`^[a-zA-Z]:`)
+	kapeVariablePattern = regexp.MustCompile(`%[^%]+%`)
+)
+
+// ParseKapeTarget parses the contents of a .tkape file.
+func ParseKapeTarget(data []byte) (target KapeTarget, err error) {
+	err = yaml.Unmarshal(data, &target)
+	if err != nil {
+		err = fmt.Errorf("ParseKapeTarget() failed to parse the target: %w", err)
+		return
+	}
+	if len(target.Targets) == 0 {
+		err = fmt.Errorf("ParseKapeTarget() found no targets in '%s'", target.Description)
+	}
+	return
+}
+
+// LoadKapeTargets reads every .tkape file under directory and converts their targets into a ListOfFilesToExport, with
+// compound targets resolved against the other files in directory the way KAPE does. Drive letters are replaced with
+// %SYSTEMDRIVE% since KAPE paths are relative to the drive being collected from, and %user% matches every user profile.
+// Files that don't parse, references to missing .tkape files, and paths without a drive letter, with an alternate data
+// stream or with a variable other than %user% are returned in skipped.
+func LoadKapeTargets(directory string) (exportList ListOfFilesToExport, skipped []SkippedTarget, err error) {
+	targets := make(map[string]KapeTarget)
+	var names []string
+	err = filepath.Walk(directory, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".tkape") {
+			return nil
+		}
+		data, readErr := ioutil.ReadFile(path)
+		if readErr != nil {
+			return readErr
+		}
+		target, parseErr := ParseKapeTarget(data)
+		if parseErr != nil {
+			skipped = append(skipped, SkippedTarget{Target: info.Name(), Reason: parseErr.Error()})
+			return nil
+		}
+		name := strings.ToLower(info.Name())
+		if _, found := targets[name]; !found {
+			names = append(names, name)
+		}
+		targets[name] = target
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("LoadKapeTargets() failed to read %s: %w", directory, err)
+		return
+	}
+
+	seen := make(map[FileToExport]bool)
+	for _, name := range names {
+		skipped = append(skipped, expandKapeTarget(name, targets, make(map[string]bool), seen, &exportList)...)
+	}
+	return
+}
+
+// expandKapeTarget adds a target's entries to exportList, following compound targets. resolving holds the targets
+// being expanded further up so a cycle is skipped instead of recursing forever, and seen drops entries that more than
+// one target pulls in.
+func expandKapeTarget(name string, targets map[string]KapeTarget, resolving map[string]bool, seen map[FileToExport]bool, exportList *ListOfFilesToExport) (skipped []SkippedTarget) {
+	resolving[name] = true
+	defer delete(resolving, name)
+	for _, entry := range targets[name].Targets {
+		if strings.HasSuffix(strings.ToLower(entry.Path), ".tkape") {
+			reference := strings.ToLower(entry.Path)
+			if _, found := targets[reference]; !found {
+				skipped = append(skipped, SkippedTarget{Target: entry.Path, Reason: fmt.Sprintf("referenced by %s but not found", name)})
+			} else if resolving[reference] {
+				skipped = append(skipped, SkippedTarget{Target: entry.Path, Reason: fmt.Sprintf("references itself through %s", name)})
+			} else {
+				skipped = append(skipped, expandKapeTarget(reference, targets, resolving, seen, exportList)...)
+			}
+			continue
+		}
+
+		fileToExport, convertErr := kapeEntryToFileToExport(entry)
+		if convertErr != nil {
+			skipped = append(skipped, SkippedTarget{Target: fmt.Sprintf("%s (%s)", entry.Name, name), Reason: convertErr.Error()})
+			continue
+		}
+		if !seen[fileToExport] {
+			seen[fileToExport] = true
+			*exportList = append(*exportList, fileToExport)
+		}
+	}
+	return
+}
+
+// kapeEntryToFileToExport converts a single KAPE entry.
+func kapeEntryToFileToExport(entry KapeTargetEntry) (fileToExport FileToExport, err error) {
+	return globToFileToExport(entry.Path, entry.FileMask, entry.Recursive)
+}
+
+// globToFileToExport converts a directory with a drive letter and a file mask in it into a FileToExport. Paths and masks
+// without wildcards become literal search terms, all others regexes. A file mask starting with "regex:" is used as a
+// regex as it is, and a %user% directory matches every user profile, with Users\%user% going through
+// UserProfilePlaceholder so profiles outside C:\Users are found too. recursive also matches the file mask in every
+// directory under it.
+func globToFileToExport(directory string, fileMask string, recursive bool) (fileToExport FileToExport, err error) {
+	path := strings.TrimRight(strings.ReplaceAll(directory, "/", `\`), `\`)
+	if !kapeDrivePattern.MatchString(path) {
+		err = fmt.Errorf("path '%s' doesn't start with a drive letter", directory)
+		return
+	}
+	path = path[2:]
+	if strings.Contains(path, ":") {
+		err = fmt.Errorf("path '%s' is an alternate data stream", directory)
+		return
+	}
+
+	if fileMask == "" {
+		fileMask = "*"
+	}
+	isMaskRegex := strings.HasPrefix(strings.ToLower(fileMask), "regex:")
+	if strings.Contains(fileMask, ":") && !isMaskRegex {
+		err = fmt.Errorf("file mask '%s' is an alternate data stream", fileMask)
+		return
+	}
+	var fileNameRegex string
+	isFileNameRegex := true
+	switch {
+	case isMaskRegex:
+		fileNameRegex = strings.TrimPrefix(fileMask[len("regex:"):], "^")
+		fileNameRegex = `(?:` + strings.TrimSuffix(fileNameRegex, "$") + `)`
+	case strings.ContainsAny(fileMask, "*?"):
+		fileNameRegex = kapeGlobToRegex(fileMask)
+	default:
+		isFileNameRegex = false
+		fileNameRegex = regexp.QuoteMeta(fileMask)
+	}
+
+	// A directory in every user's profile is looked for wherever the profiles are, not just under C:\Users
+	prefix, segments := `%SYSTEMDRIVE%:`, strings.Split(path, `\`)[1:]
+	if len(segments) >= 2 && strings.EqualFold(segments[0], "users") && strings.EqualFold(segments[1], "%user%") {
+		prefix, segments = UserProfilePlaceholder, segments[2:]
+	}
+	isPathRegex := isFileNameRegex || recursive
+	var directoryRegex []string
+	for _, segment := range segments {
+		switch {
+		case strings.EqualFold(segment, "%user%"):
+			isPathRegex = true
+			directoryRegex = append(directoryRegex, `[^\\]+`)
+		case kapeVariablePattern.MatchString(segment):
+			err = fmt.Errorf("path '%s' uses a variable other than %%user%%", directory)
+			return
+		case strings.ContainsAny(segment, "*?"):
+			isPathRegex = true
+			directoryRegex = append(directoryRegex, kapeGlobToRegex(segment))
+		default:
+			directoryRegex = append(directoryRegex, regexp.QuoteMeta(segment))
+		}
+	}
+
+	if !isPathRegex {
+		fullPath := prefix
+		for _, segment := range segments {
+			fullPath += `\` + segment
+		}
+		fileToExport = FileToExport{
+			FullPath: fullPath + `\` + fileMask,
+			FileName: fileMask,
+		}
+		return
+	}
+	fullPath := prefix
+	for _, segment := range directoryRegex {
+		fullPath += `\\` + segment
+	}
+	if recursive {
+		fullPath += `(\\[^\\]+)*`
+	}
+	fileToExport = FileToExport{
+		FullPath:        fullPath + `\\` + fileNameRegex + `$`,
+		IsFullPathRegex: true,
+		FileName:        fileMask,
+		IsFileNameRegex: false,
+	}
+	if isFileNameRegex {
+		fileToExport.FileName = `^` + fileNameRegex + `$`
+		fileToExport.IsFileNameRegex = true
+	}
+	return
+}
+
+// kapeGlobToRegex turns a KAPE wildcard pattern into a regex matching a single path segment.
+func kapeGlobToRegex(glob string) string {
+	var builder strings.Builder
+	for _, character := range glob {
+		switch character {
+		case '*':
+			builder.WriteString(`[^\\]*`)
+		case '?':
+			builder.WriteString(`[^\\]`)
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(character)))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_kapeEntryToFileToExport(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     KapeTargetEntry
+		want      FileToExport
+		wantMatch []string
+		wantMiss  []string
+		wantErr   bool
+	}{
+		{
+			name:    "literal file",
+			entry:   KapeTargetEntry{Name: "$MFT", Path: `C:\`, FileMask: "$MFT"},
+			want:    FileToExport{FullPath: `%SYSTEMDRIVE%:\$MFT`, FileName: "$MFT"},
+			wantErr: false,
+		},
+		{
+			name:      "file mask",
+			entry:     KapeTargetEntry{Name: "Event logs", Path: `C:\Windows\System32\winevt\Logs\`, FileMask: "*.evtx"},
+			wantMatch: []string{`c:\windows\system32\winevt\logs\security.evtx`},
+			wantMiss:  []string{`c:\windows\system32\winevt\logs\old\security.evtx`, `c:\windows\system32\winevt\logs\security.evtx.bak`},
+			wantErr:   false,
+		},
+		{
+			name:      "user profiles",
+			entry:     KapeTargetEntry{Name: "NTUSER", Path: `C:\Users\%user%`, FileMask: "NTUSER.DAT"},
+			wantMatch: []string{`c:\users\alice\ntuser.dat`},
+			wantMiss:  []string{`c:\users\alice\documents\ntuser.dat`},
+			wantErr:   false,
+		},
+		{
+			name:      "recursive with no file mask",
+			entry:     KapeTargetEntry{Name: "Prefetch", Path: `C:\Windows\Prefetch`, Recursive: true},
+			wantMatch: []string{`c:\windows\prefetch\cmd.exe-0bd30981.pf`, `c:\windows\prefetch\readyboot\trace1.fx`},
+			wantMiss:  []string{`c:\windows\system32\cmd.exe`},
+			wantErr:   false,
+		},
+		{
+			name:      "regex file mask",
+			entry:     KapeTargetEntry{Name: "Jump lists", Path: `C:\Users\*\AppData\Roaming\Microsoft\Windows\Recent\AutomaticDestinations`, FileMask: `regex:^[0-9a-f]{16}\.automaticDestinations-ms$`},
+			wantMatch: []string{`c:\users\bob\appdata\roaming\microsoft\windows\recent\automaticdestinations\5f7b5f1e01b83767.automaticdestinations-ms`},
+			wantMiss:  []string{`c:\users\bob\appdata\roaming\microsoft\windows\recent\automaticdestinations\notes.txt`},
+			wantErr:   false,
+		},
+		{
+			name:    "alternate data stream",
+			entry:   KapeTargetEntry{Name: "$J", Path: `C:\$Extend`, FileMask: "$UsnJrnl:$J"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported variable",
+			entry:   KapeTargetEntry{Name: "Temp", Path: `C:\%temp%`},
+			wantErr: true,
+		},
+		{
+			name:    "no drive letter",
+			entry:   KapeTargetEntry{Name: "Relative", Path: `Windows\Temp`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kapeEntryToFileToExport(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("kapeEntryToFileToExport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want != (FileToExport{}) && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kapeEntryToFileToExport() = %+v, want %+v", got, tt.want)
+			}
+			got = ExpandUserProfiles(ListOfFilesToExport{got}, nil)[0]
+			got.FullPath = strings.Replace(got.FullPath, "%SYSTEMDRIVE%", "c", 1)
+			terms, err := compileSearchTerms(got)
+			if err != nil {
+				t.Fatalf("compileSearchTerms() error = %v for %+v", err, got)
+			}
+			for _, path := range tt.wantMatch {
+				if !kapeTermMatches(terms, path) {
+					t.Errorf("%+v doesn't match %s", got, path)
+				}
+			}
+			for _, path := range tt.wantMiss {
+				if kapeTermMatches(terms, path) {
+					t.Errorf("%+v matches %s", got, path)
+				}
+			}
+		})
+	}
+}
+
+func kapeTermMatches(terms searchTerms, path string) bool {
+	fileName := path[strings.LastIndex(path, `\`)+1:]
+	if terms.fileNameRegex != nil && !terms.fileNameRegex.MatchString(fileName) {
+		return false
+	} else if terms.fileNameRegex == nil && terms.fileNameString != fileName {
+		return false
+	}
+	if terms.fullPathRegex == nil {
+		return terms.fullPathString == path
+	}
+	return terms.fullPathRegex.MatchString(path)
+}
+
+func TestLoadKapeTargets(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-kape-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	files := map[string]string{
+		"Compound/Triage.tkape": `Description: Triage
+Version: 1.0
+Targets:
+    -
+        Name: Registry
+        Path: RegistryHives.tkape
+    -
+        Name: Missing
+        Path: DoesNotExist.tkape
+    -
+        Name: Loop
+        Path: Triage.tkape
+`,
+		"Windows/RegistryHives.tkape": `Description: Registry hives
+Version: 1.1
+Targets:
+    -
+        Name: SYSTEM
+        Category: Registry
+        Path: C:\Windows\System32\config\
+        FileMask: SYSTEM
+    -
+        Name: NTUSER
+        Category: Registry
+        Path: C:\Users\%user%\
+        FileMask: NTUSER.DAT
+`,
+		"Windows/Broken.tkape": "Description: [",
+	}
+	for name, content := range files {
+		path := filepath.Join(directory, filepath.FromSlash(name))
+		_ = os.MkdirAll(filepath.Dir(path), 0755)
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exportList, skipped, err := LoadKapeTargets(directory)
+	if err != nil {
+		t.Fatalf("LoadKapeTargets() error = %v", err)
+	}
+	wantPaths := []string{`%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`, `%USERPROFILE%\NTUSER.DAT`}
+	var gotPaths []string
+	for _, fileToExport := range exportList {
+		gotPaths = append(gotPaths, fileToExport.FullPath)
+	}
+	if !reflect.DeepEqual(gotPaths, wantPaths) {
+		t.Errorf("LoadKapeTargets() paths = %v, want %v", gotPaths, wantPaths)
+	}
+	wantSkipped := regexp.MustCompile(`Broken\.tkape|DoesNotExist\.tkape|Triage\.tkape`)
+	if len(skipped) != 3 {
+		t.Errorf("LoadKapeTargets() skipped = %+v, want the broken file, the missing reference and the loop", skipped)
+	}
+	for _, skip := range skipped {
+		if !wantSkipped.MatchString(skip.Target) {
+			t.Errorf("LoadKapeTargets() skipped %+v", skip)
+		}
+	}
+}
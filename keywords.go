@@ -7,14 +7,68 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // FileToExport is the file that you want to export.
 type FileToExport struct {
-	FullPath        string
-	IsFullPathRegex bool
-	FileName        string
-	IsFileNameRegex bool
+	FullPath        string     `yaml:"full_path"`
+	IsFullPathRegex bool       `yaml:"full_path_regex,omitempty"`
+	FileName        string     `yaml:"file_name"`
+	IsFileNameRegex bool       `yaml:"file_name_regex,omitempty"`
+	Codec           string     `yaml:"codec,omitempty"`          // name of a registered Codec to compress this file with, overriding the result writer's
+	Priority        int        `yaml:"priority,omitempty"`       // how valuable the file is when a ByteBudget forces a choice, higher goes first
+	MaxFileSize     int64      `yaml:"max_file_size,omitempty"`  // files bigger than this, going by the MFT, are skipped. Zero means no limit
+	MaxTotalSize    int64      `yaml:"max_total_size,omitempty"` // matching files that would take the target's total over this many bytes are skipped. Zero means no limit
+	MaxMatches      int        `yaml:"max_matches,omitempty"`    // matching files after this many are skipped. Zero means no limit
+	Modified        TimeWindow `yaml:"modified,omitempty"`       // when set, only files last modified in this window are collected
+	Created         TimeWindow `yaml:"created,omitempty"`        // when set, only files created in this window are collected
+	Exclude         string     `yaml:"exclude,omitempty"`        // regex for full paths to leave out even though they match, e.g. .*\\microsoft-windows-store.*
+	ReadPolicy      ReadPolicy `yaml:"read_policy,omitempty"`    // how the files are read, overriding CollectOptions.ReadPolicy
+	RecordNumber    uint32     `yaml:"record_number,omitempty"`  // selects the file by its MFT record number instead, on the volume FullPath names, e.g. C:
+	SHA256          string     `yaml:"sha256,omitempty"`         // only matching files with this SHA-256, or one of several separated by commas, are collected
+	CaseSensitive   bool       `yaml:"case_sensitive,omitempty"` // the path and name only match files in the same case, for directories WSL made case-sensitive
+	Processors      string     `yaml:"processors,omitempty"`     // names of registered processors to run the files through one after the other, separated by commas, before CollectOptions.Processors
+	Extensions      string     `yaml:"extensions,omitempty"`     // instead of a file name, extensions separated by commas, e.g. ps1,lnk,hta, to collect every file with anywhere under FullPath, a volume such as C: or a directory
+}
+
+// TimeWindow narrows a target down to the files whose $STANDARD_INFORMATION timestamp falls in it, such as only the
+// event logs modified in the last 30 days. A zero After or Before leaves that end open.
+type TimeWindow struct {
+	After  time.Time     `yaml:"after,omitempty"`
+	Before time.Time     `yaml:"before,omitempty"`
+	Within time.Duration `yaml:"within,omitempty"` // instead of After, how long before the collection starts, e.g. 720h
+}
+
+// resolve turns Within into After, counting back from now.
+func (window TimeWindow) resolve(now time.Time) (resolved TimeWindow, err error) {
+	switch {
+	case window.Within < 0:
+		err = errors.New("the time window's within is negative")
+	case window.Within != 0 && !window.After.IsZero():
+		err = errors.New("the time window has both after and within")
+	case !window.After.IsZero() && !window.Before.IsZero() && !window.After.Before(window.Before):
+		err = errors.New("the time window's after isn't before its before")
+	}
+	if err != nil {
+		return
+	}
+	resolved = TimeWindow{After: window.After, Before: window.Before}
+	if window.Within != 0 {
+		resolved.After = now.Add(-window.Within)
+	}
+	return
+}
+
+// contains reports whether a timestamp is in the window. The ends are inclusive.
+func (window TimeWindow) contains(timestamp time.Time) bool {
+	if !window.After.IsZero() && timestamp.Before(window.After) {
+		return false
+	}
+	if !window.Before.IsZero() && timestamp.After(window.Before) {
+		return false
+	}
+	return true
 }
 
 // ListOfFilesToExport is a slice of files that you want to export.
@@ -25,54 +79,166 @@ type searchTerms struct {
 	fullPathRegex  *regexp.Regexp
 	fileNameString string
 	fileNameRegex  *regexp.Regexp
+	codec          string
+	processors     ProcessorChain
+	priority       int
+	limits         fileLimits
+	modified       TimeWindow
+	created        TimeWindow
+	exclude        *regexp.Regexp
+	readPolicy     ReadPolicy
+	share          string // the share the full path is on, if it isn't on a local volume
+	target         int    // the index of the FileToExport in the export list, which its limits are counted by
+	recordNumber   uint32
+	hashes         map[string]bool
+	extensions     map[string]bool
+	caseSensitive  bool
 }
 
 type listOfSearchTerms []searchTerms
 
 func setupSearchTerms(exportList ListOfFilesToExport) (listOfSearchKeywords listOfSearchTerms, err error) {
-	for _, value := range exportList {
-		// Sanity checking inputs
-		if value.FileName == "" {
-			err = errors.New("received empty filename string")
-			return
-		} else if value.FullPath == "" {
-			err = errors.New("received empty filepath string")
+	for index, value := range exportList {
+		var searchKeywords searchTerms
+		searchKeywords, err = compileSearchTerms(value)
+		if err != nil {
 			return
 		}
+		searchKeywords.target = index
+		listOfSearchKeywords = append(listOfSearchKeywords, searchKeywords)
+	}
+
+	return
+}
+
+// compileSearchTerms validates a single file to export and compiles it into the search terms used during the MFT walk.
+func compileSearchTerms(value FileToExport) (searchKeywords searchTerms, err error) {
+	// Sanity checking inputs
+	if value.FileName == "" && value.RecordNumber == 0 && value.Extensions == "" {
+		err = errors.New("received empty filename string")
+		return
+	} else if value.FullPath == "" {
+		err = errors.New("received empty filepath string")
+		return
+	}
 
-		// Normalize everything
+	// Normalize everything, or only the volume when the case matters
+	if value.CaseSensitive {
+		value.FullPath = lowerVolume(value.FullPath)
+	} else {
 		value.FullPath = strings.ToLower(value.FullPath)
 		value.FileName = strings.ToLower(value.FileName)
+	}
 
-		if value.IsFullPathRegex == false && strings.HasSuffix(value.FullPath, `\`) == true {
-			err = fmt.Errorf("file path '%s' has a trailing '\\'", value.FullPath)
-			return
-		} else if value.IsFullPathRegex == true && strings.HasSuffix(value.FullPath, `\`) == true {
-			err = fmt.Errorf("file path '%s' has missing a trailing '\\\\'", value.FullPath)
+	if value.IsFullPathRegex == false && strings.HasSuffix(value.FullPath, `\`) == true {
+		err = fmt.Errorf("file path '%s' has a trailing '\\'", value.FullPath)
+		return
+	} else if value.IsFullPathRegex == true && strings.HasSuffix(value.FullPath, `\`) == true {
+		err = fmt.Errorf("file path '%s' has missing a trailing '\\\\'", value.FullPath)
+		return
+	}
+
+	if value.Codec != "" {
+		_, err = LookupCodec(value.Codec)
+		if err != nil {
+			err = fmt.Errorf("file path '%s' asked for an unknown codec: %w", value.FullPath, err)
 			return
 		}
+	}
 
-		searchKeywords := searchTerms{}
-		switch value.IsFullPathRegex {
-		case false:
-			searchKeywords.fullPathString = value.FullPath
-			searchKeywords.fullPathRegex = nil
-		case true:
-			searchKeywords.fullPathString = ""
-			searchKeywords.fullPathRegex = regexp.MustCompile(value.FullPath)
+	processors, err := lookupProcessorChain(value.Processors)
+	if err != nil {
+		err = fmt.Errorf("file path '%s' asked for an unknown processor: %w", value.FullPath, err)
+		return
+	}
+
+	if err = value.ReadPolicy.validate(); err != nil {
+		err = fmt.Errorf("file path '%s' has an invalid read policy: %w", value.FullPath, err)
+		return
+	}
+
+	limits := fileLimits{maxFileSize: value.MaxFileSize, maxTotalSize: value.MaxTotalSize, maxMatches: value.MaxMatches}
+	if err = limits.validate(); err != nil {
+		err = fmt.Errorf("file path '%s' has invalid limits: %w", value.FullPath, err)
+		return
+	}
+
+	searchKeywords = searchTerms{codec: value.Codec, processors: processors, priority: value.Priority, limits: limits, readPolicy: value.ReadPolicy, share: shareOf(value.FullPath, value.IsFullPathRegex), caseSensitive: value.CaseSensitive}
+	now := time.Now()
+	if searchKeywords.modified, err = value.Modified.resolve(now); err != nil {
+		err = fmt.Errorf("file path '%s' has an invalid modified time window: %w", value.FullPath, err)
+		return
+	}
+	if searchKeywords.created, err = value.Created.resolve(now); err != nil {
+		err = fmt.Errorf("file path '%s' has an invalid created time window: %w", value.FullPath, err)
+		return
+	}
+	if err = compileSelectors(value, &searchKeywords); err != nil {
+		err = fmt.Errorf("file path '%s' has an invalid selector: %w", value.FullPath, err)
+		return
+	}
+	if value.Exclude != "" {
+		searchKeywords.exclude, err = regexp.Compile(strings.ToLower(value.Exclude))
+		if err != nil {
+			err = fmt.Errorf("file path '%s' has an exclusion '%s' that is not a valid regex: %w", value.FullPath, value.Exclude, err)
+			return
+		}
+	}
+	switch value.IsFullPathRegex {
+	case false:
+		searchKeywords.fullPathString = value.FullPath
+		searchKeywords.fullPathRegex = nil
+	case true:
+		searchKeywords.fullPathString = ""
+		searchKeywords.fullPathRegex, err = regexp.Compile(value.FullPath)
+		if err != nil {
+			err = fmt.Errorf("file path '%s' is not a valid regex: %w", value.FullPath, err)
+			return
 		}
+	}
 
-		switch value.IsFileNameRegex {
-		case false:
-			searchKeywords.fileNameString = value.FileName
-			searchKeywords.fileNameRegex = nil
-		case true:
-			searchKeywords.fileNameString = ""
-			searchKeywords.fileNameRegex = regexp.MustCompile(value.FileName)
+	switch value.IsFileNameRegex {
+	case false:
+		searchKeywords.fileNameString = value.FileName
+		searchKeywords.fileNameRegex = nil
+	case true:
+		searchKeywords.fileNameString = ""
+		searchKeywords.fileNameRegex, err = regexp.Compile(value.FileName)
+		if err != nil {
+			err = fmt.Errorf("file name '%s' is not a valid regex: %w", value.FileName, err)
+			return
 		}
+	}
+	return
+}
 
-		listOfSearchKeywords = append(listOfSearchKeywords, searchKeywords)
+// lowerVolume lowercases the volume a full path starts with, up to its first backslash, e.g. C:\Users\Foo becomes
+// c:\Users\Foo, since volumes are matched lowercased whatever the case of the paths on them.
+func lowerVolume(fullPath string) string {
+	end := strings.Index(fullPath, `\`)
+	if strings.HasPrefix(fullPath, `\\`) {
+		// A share's volume is the host and the share, e.g. \\WS042\C$
+		end = strings.Index(fullPath[2:], `\`)
+		if end != -1 {
+			if next := strings.Index(fullPath[2+end+1:], `\`); next != -1 {
+				end = 2 + end + 1 + next
+			} else {
+				end = -1
+			}
+		}
+	}
+	if end == -1 {
+		return strings.ToLower(fullPath)
 	}
+	return strings.ToLower(fullPath[:end]) + fullPath[end:]
+}
 
-	return
+// inTimeWindows reports whether a file's $STANDARD_INFORMATION timestamps are in the term's time windows.
+func (terms searchTerms) inTimeWindows(modified time.Time, created time.Time) bool {
+	return terms.modified.contains(modified) && terms.created.contains(created)
+}
+
+// excludes reports whether the term's exclusion matches a full path.
+func (terms searchTerms) excludes(fullPath string) bool {
+	return terms.exclude != nil && terms.exclude.MatchString(fullPath)
 }
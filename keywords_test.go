@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func Test_setupSearchTerms(t *testing.T) {
@@ -47,6 +48,7 @@ func Test_setupSearchTerms(t *testing.T) {
 					fullPathRegex:  regexp.MustCompile(`c:\\windows\\.*`),
 					fileNameString: "",
 					fileNameRegex:  regexp.MustCompile(`.*\.evtx`),
+					target:         1,
 				},
 			},
 		},
@@ -102,6 +104,120 @@ func Test_setupSearchTerms(t *testing.T) {
 			wantErr:                  true,
 			wantListOfSearchKeywords: nil,
 		},
+		{
+			name: "limits",
+			args: args{exportList: ListOfFilesToExport{
+				0: FileToExport{
+					FullPath: `C:\hiberfil.sys`,
+					FileName: "hiberfil.sys",
+				},
+				1: FileToExport{
+					FullPath:     `C:\pagefile.sys`,
+					FileName:     "pagefile.sys",
+					MaxFileSize:  1024,
+					MaxTotalSize: 4096,
+					MaxMatches:   2,
+				},
+			}},
+			wantErr: false,
+			wantListOfSearchKeywords: listOfSearchTerms{
+				0: searchTerms{
+					fullPathString: `c:\hiberfil.sys`,
+					fileNameString: "hiberfil.sys",
+				},
+				1: searchTerms{
+					fullPathString: `c:\pagefile.sys`,
+					fileNameString: "pagefile.sys",
+					limits:         fileLimits{maxFileSize: 1024, maxTotalSize: 4096, maxMatches: 2},
+					target:         1,
+				},
+			},
+		},
+		{
+			name: "negative max matches",
+			args: args{exportList: ListOfFilesToExport{
+				0: FileToExport{
+					FullPath:   `C:\pagefile.sys`,
+					FileName:   "pagefile.sys",
+					MaxMatches: -1,
+				},
+			}},
+			wantErr:                  true,
+			wantListOfSearchKeywords: nil,
+		},
+		{
+			name: "time windows",
+			args: args{exportList: ListOfFilesToExport{
+				0: FileToExport{
+					FullPath: `C:\hiberfil.sys`,
+					FileName: "hiberfil.sys",
+					Modified: TimeWindow{After: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+					Created:  TimeWindow{Before: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+				},
+			}},
+			wantErr: false,
+			wantListOfSearchKeywords: listOfSearchTerms{
+				0: searchTerms{
+					fullPathString: `c:\hiberfil.sys`,
+					fileNameString: "hiberfil.sys",
+					modified:       TimeWindow{After: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+					created:        TimeWindow{Before: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+				},
+			},
+		},
+		{
+			name: "exclusions",
+			args: args{exportList: ListOfFilesToExport{
+				0: FileToExport{
+					FullPath:        `C:\\Windows\\System32\\winevt\\Logs\\.*`,
+					IsFullPathRegex: true,
+					FileName:        `.*\.evtx`,
+					IsFileNameRegex: true,
+					Exclude:         `.*\\Microsoft-Windows-Store.*`,
+				},
+			}},
+			wantErr: false,
+			wantListOfSearchKeywords: listOfSearchTerms{
+				0: searchTerms{
+					fullPathRegex: regexp.MustCompile(`c:\\windows\\system32\\winevt\\logs\\.*`),
+					fileNameRegex: regexp.MustCompile(`.*\.evtx`),
+					exclude:       regexp.MustCompile(`.*\\microsoft-windows-store.*`),
+				},
+			},
+		},
+		{
+			name: "bad exclusion",
+			args: args{exportList: ListOfFilesToExport{
+				0: FileToExport{
+					FullPath: `C:\hiberfil.sys`,
+					FileName: "hiberfil.sys",
+					Exclude:  `(unclosed`,
+				},
+			}},
+			wantErr:                  true,
+			wantListOfSearchKeywords: nil,
+		},
+		{
+			name: "case-sensitive",
+			args: args{exportList: ListOfFilesToExport{
+				0: FileToExport{FullPath: `C:\Users\alice\src\Makefile`, FileName: "Makefile", CaseSensitive: true},
+			}},
+			wantListOfSearchKeywords: listOfSearchTerms{
+				0: searchTerms{fullPathString: `c:\Users\alice\src\Makefile`, fileNameString: "Makefile", caseSensitive: true},
+			},
+		},
+		{
+			name: "backwards time window",
+			args: args{exportList: ListOfFilesToExport{
+				0: FileToExport{
+					FullPath: `C:\hiberfil.sys`,
+					FileName: "hiberfil.sys",
+					Modified: TimeWindow{After: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), Before: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+				},
+			}},
+			wantErr:                  true,
+			wantListOfSearchKeywords: nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -116,3 +232,71 @@ func Test_setupSearchTerms(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeWindow_resolve(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		window  TimeWindow
+		want    TimeWindow
+		wantErr bool
+	}{
+		{name: "open", window: TimeWindow{}, want: TimeWindow{}},
+		{name: "within", window: TimeWindow{Within: 720 * time.Hour}, want: TimeWindow{After: time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC)}},
+		{name: "within and after", window: TimeWindow{After: now, Within: time.Hour}, wantErr: true},
+		{name: "negative within", window: TimeWindow{Within: -time.Hour}, wantErr: true},
+		{name: "after equals before", window: TimeWindow{After: now, Before: now}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.window.resolve(now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.After.Equal(tt.want.After) || !got.Before.Equal(tt.want.Before) || got.Within != 0 {
+				t.Errorf("resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindow_contains(t *testing.T) {
+	window := TimeWindow{After: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Before: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		timestamp time.Time
+		want      bool
+	}{
+		{timestamp: window.After, want: true},
+		{timestamp: window.Before, want: true},
+		{timestamp: time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC), want: true},
+		{timestamp: time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC), want: false},
+		{timestamp: time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC), want: false},
+	}
+	for _, tt := range tests {
+		if got := window.contains(tt.timestamp); got != tt.want {
+			t.Errorf("contains(%v) = %v, want %v", tt.timestamp, got, tt.want)
+		}
+	}
+	if !(TimeWindow{}).contains(time.Time{}) {
+		t.Error("an open window should contain every timestamp")
+	}
+}
+
+func Test_lowerVolume(t *testing.T) {
+	tests := []struct {
+		name     string
+		fullPath string
+		want     string
+	}{
+		{name: "drive", fullPath: `C:\Users\Alice\NTUSER.DAT`, want: `c:\Users\Alice\NTUSER.DAT`},
+		{name: "share", fullPath: `\\WS042\C$\Users\Alice`, want: `\\ws042\c$\Users\Alice`},
+		{name: "volume only", fullPath: `C:`, want: `c:`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lowerVolume(tt.fullPath); got != tt.want {
+				t.Errorf("lowerVolume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// fileLimits are the MaxFileSize, MaxTotalSize and MaxMatches of a target or of a whole collection. Zero means no
+// limit.
+type fileLimits struct {
+	maxFileSize  int64
+	maxTotalSize int64
+	maxMatches   int
+}
+
+func (limits fileLimits) validate() error {
+	if limits.maxFileSize < 0 || limits.maxTotalSize < 0 || limits.maxMatches < 0 {
+		return errors.New("the max file size, max total size and max matches can't be negative")
+	}
+	return nil
+}
+
+// limitUsage is how much of its limits a target or a collection has used up.
+type limitUsage struct {
+	matches int
+	bytes   int64
+}
+
+// check returns why a file of the given size would go over the limits of what, a target or the collection, or an
+// empty string when it fits.
+func (limits fileLimits) check(usage limitUsage, size int64, what string) string {
+	switch {
+	case limits.maxFileSize != 0 && size > limits.maxFileSize:
+		return fmt.Sprintf("%d bytes is over the %s's max file size of %d", size, what, limits.maxFileSize)
+	case limits.maxMatches != 0 && usage.matches >= limits.maxMatches:
+		return fmt.Sprintf("the %s already matched its max of %d files", what, limits.maxMatches)
+	case limits.maxTotalSize != 0 && usage.bytes+size > limits.maxTotalSize:
+		return fmt.Sprintf("%d bytes would take the %s over its max total size of %d", size, what, limits.maxTotalSize)
+	}
+	return ""
+}
+
+// limitTracker counts the files admitted for each target and for the collection across every volume, in the order
+// they are found, so a runaway regex or an enormous file can't blow up the output. Like the reportBuilder its methods
+// do nothing when it's nil.
+type limitTracker struct {
+	mutex      sync.Mutex
+	limits     fileLimits
+	collection limitUsage
+	targets    map[int]*limitUsage
+}
+
+func newLimitTracker(limits fileLimits) *limitTracker {
+	return &limitTracker{limits: limits, targets: make(map[int]*limitUsage)}
+}
+
+// admit counts a file against its target's limits and the collection's. When it would go over either, nothing is
+// counted and the reason is returned instead.
+func (tracker *limitTracker) admit(file foundFile) (reason string) {
+	if tracker == nil {
+		return
+	}
+	size := file.totalSize()
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	usage := tracker.targets[file.target]
+	if usage == nil {
+		usage = &limitUsage{}
+		tracker.targets[file.target] = usage
+	}
+	reason = file.limits.check(*usage, size, "target")
+	if reason == "" {
+		reason = tracker.limits.check(tracker.collection, size, "collection")
+	}
+	if reason != "" {
+		return
+	}
+	usage.matches++
+	usage.bytes += size
+	tracker.collection.matches++
+	tracker.collection.bytes += size
+	return
+}
+
+// applyLimits returns the files that are within their limits. The rest are listed in the report as skipped, along
+// with what the MFT holds about them when they were found there.
+func (collector *Collector) applyLimits(volumeLetter string, files foundFiles, fromMFT bool) (within foundFiles) {
+	within = make(foundFiles, 0, len(files))
+	for _, file := range files {
+		reason := collector.limits.admit(file)
+		if reason == "" {
+			within = append(within, file)
+			continue
+		}
+		collector.logger().Warnf("Skipping %s: %s.", file.fullPath, reason)
+		var metadata *FileMetadata
+		if fromMFT {
+			fileMetadata := file.fileMetadata(volumeLetter)
+			metadata = &fileMetadata
+		}
+		collector.report.fileSkipped(file.fullPath, volumeLetter, file.totalSize(), metadata, reason)
+	}
+	return
+}
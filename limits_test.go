@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_limitTracker_admit(t *testing.T) {
+	tests := []struct {
+		name         string
+		collection   fileLimits
+		files        foundFiles
+		wantAdmitted []string
+	}{
+		{
+			name: "target max file size",
+			files: foundFiles{
+				{fullPath: `c:\pagefile.sys`, fileSize: 4096, limits: fileLimits{maxFileSize: 1024}},
+				{fullPath: `c:\hiberfil.sys`, fileSize: 1024, limits: fileLimits{maxFileSize: 1024}},
+				{fullPath: `c:\swapfile.sys`, fileSize: 4096, target: 1},
+			},
+			wantAdmitted: []string{`c:\hiberfil.sys`, `c:\swapfile.sys`},
+		},
+		{
+			name: "target max matches",
+			files: foundFiles{
+				{fullPath: `c:\a.log`, fileSize: 1, limits: fileLimits{maxMatches: 2}},
+				{fullPath: `c:\b.log`, fileSize: 1, limits: fileLimits{maxMatches: 2}},
+				{fullPath: `c:\c.log`, fileSize: 1, limits: fileLimits{maxMatches: 2}},
+				{fullPath: `c:\d.evtx`, fileSize: 1, limits: fileLimits{maxMatches: 2}, target: 1},
+			},
+			wantAdmitted: []string{`c:\a.log`, `c:\b.log`, `c:\d.evtx`},
+		},
+		{
+			name: "target max total size",
+			files: foundFiles{
+				{fullPath: `c:\a.log`, fileSize: 600, limits: fileLimits{maxTotalSize: 1000}},
+				{fullPath: `c:\b.log`, fileSize: 600, limits: fileLimits{maxTotalSize: 1000}},
+				{fullPath: `c:\c.log`, fileSize: 400, limits: fileLimits{maxTotalSize: 1000}},
+			},
+			wantAdmitted: []string{`c:\a.log`, `c:\c.log`},
+		},
+		{
+			name:       "collection limits",
+			collection: fileLimits{maxFileSize: 1000, maxMatches: 2},
+			files: foundFiles{
+				{fullPath: `c:\pagefile.sys`, fileSize: 4096},
+				{fullPath: `c:\a.log`, fileSize: 1},
+				{fullPath: `c:\b.evtx`, fileSize: 1, target: 1},
+				{fullPath: `c:\c.evtx`, fileSize: 1, target: 1},
+			},
+			wantAdmitted: []string{`c:\a.log`, `c:\b.evtx`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newLimitTracker(tt.collection)
+			var admitted []string
+			for _, file := range tt.files {
+				if reason := tracker.admit(file); reason == "" {
+					admitted = append(admitted, file.fullPath)
+				}
+			}
+			if !reflect.DeepEqual(admitted, tt.wantAdmitted) {
+				t.Errorf("limitTracker.admit() admitted %v, want %v", admitted, tt.wantAdmitted)
+			}
+		})
+	}
+
+	var tracker *limitTracker
+	if reason := tracker.admit(foundFile{fileSize: 4096, limits: fileLimits{maxFileSize: 1}}); reason != "" {
+		t.Errorf("admit() on a nil limitTracker = %q, want nothing skipped", reason)
+	}
+}
+
+func Test_applyLimits(t *testing.T) {
+	collector := &Collector{report: newReportBuilder(), limits: newLimitTracker(fileLimits{maxTotalSize: 1024})}
+	files := foundFiles{
+		{fullPath: `c:\pagefile.sys`, fileSize: 4096, metadata: recordMetadata{recordNumber: 42}},
+		{fullPath: `c:\hiberfil.sys`, fileSize: 1024},
+	}
+	within := collector.applyLimits("c", files, true)
+	if !reflect.DeepEqual(within, files[1:]) {
+		t.Errorf("applyLimits() = %+v, want only the hiberfil", within)
+	}
+	report := collector.report.snapshot()
+	if len(report.Files) != 1 {
+		t.Fatalf("snapshot() files = %+v, want the pagefile skipped", report.Files)
+	}
+	skipped := report.Files[0]
+	if skipped.Path != `c:\pagefile.sys` || skipped.Status != "skipped" || skipped.Size != 4096 || skipped.Metadata == nil || skipped.Metadata.RecordNumber != 42 {
+		t.Errorf("snapshot() skipped file = %+v, want the pagefile with its size and metadata", skipped)
+	}
+}
+
+func Test_fileLimits_validate(t *testing.T) {
+	if err := (fileLimits{maxFileSize: 1, maxTotalSize: 2, maxMatches: 3}).validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+	if err := (fileLimits{maxTotalSize: -1}).validate(); err == nil {
+		t.Error("validate() should reject a negative max total size")
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Logger is what the collector logs through. A *logrus.Logger or a *logrus.Entry satisfies it, so programs embedding
+// the collector can route its logs their way, or tag every line of a collection with fields such as a request ID.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// loggerOrDefault returns logger, or logrus' standard logger when it's nil.
+func loggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return log.StandardLogger()
+	}
+	return logger
+}
+
+// logger returns the collection's Logger.
+func (options CollectOptions) logger() Logger {
+	return loggerOrDefault(options.Logger)
+}
+
+// logger returns the collection's Logger.
+func (collector *Collector) logger() Logger {
+	return collector.Options.logger()
+}
+
+// logger returns the Logger of the collection the volume is being read for.
+func (volume *VolumeHandler) logger() Logger {
+	return loggerOrDefault(volume.Logger)
+}
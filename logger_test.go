@@ -0,0 +1,71 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger keeps every line logged through it.
+type recordingLogger struct {
+	lines []string
+}
+
+func (logger *recordingLogger) record(level string, format string, args ...interface{}) {
+	logger.lines = append(logger.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (logger *recordingLogger) Debugf(format string, args ...interface{}) {
+	logger.record("debug", format, args...)
+}
+
+func (logger *recordingLogger) Infof(format string, args ...interface{}) {
+	logger.record("info", format, args...)
+}
+
+func (logger *recordingLogger) Warnf(format string, args ...interface{}) {
+	logger.record("warn", format, args...)
+}
+
+func (logger *recordingLogger) Errorf(format string, args ...interface{}) {
+	logger.record("error", format, args...)
+}
+
+func TestZipResultWriter_Logger(t *testing.T) {
+	logger := &recordingLogger{}
+	zipResultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(ioutil.Discard), Logger: logger}
+	fileReaders := make(chan fileReader, 1)
+	fileReaders <- fileReader{fullPath: `c:\broken`, reader: failingReader{}}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	_ = zipResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+	if len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], `debug Failed to collect 'c:\broken'`) {
+		t.Errorf("the zip result writer logged %q, want the failed file through its Logger", logger.lines)
+	}
+}
+
+func TestCollectOptions_Logger(t *testing.T) {
+	logger := &recordingLogger{}
+	collector := &Collector{Options: CollectOptions{Logger: logger}, report: newReportBuilder(), dedup: newDeduplicator(true)}
+	collector.spoolUnlessDuplicate(failingReader{}, `c:\broken`, "c")
+	if len(logger.lines) != 1 || !strings.HasPrefix(logger.lines[0], `debug Failed to collect 'c:\broken'`) {
+		t.Errorf("the collection logged %q, want the failed file through its Logger", logger.lines)
+	}
+
+	volumeHandler := &VolumeHandler{}
+	if volumeHandler.logger() != log.StandardLogger() {
+		t.Error("VolumeHandler.logger() without a Logger should be logrus' standard logger")
+	}
+	volumeHandler.Logger = logger
+	if volumeHandler.logger() != logger {
+		t.Error("VolumeHandler.logger() should be its Logger")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// lznt1ChunkSize is how much uncompressed data each LZNT1 chunk holds.
+const lznt1ChunkSize = 4096
+
+// decompressLZNT1 decompresses a compression unit of an NTFS compressed file. The compressed data is a series of chunks,
+// each of which stands for 4096 bytes of output, and a chunk that decompresses to less than that is followed by zeros.
+// unitSize is the size of the uncompressed compression unit, the output is never bigger than that.
+func decompressLZNT1(compressed []byte, unitSize int) (decompressed []byte, err error) {
+	const (
+		flagCompressed = 0x8000
+		sizeMask       = 0x0fff
+	)
+	decompressed = make([]byte, unitSize)
+	offset := 0
+	for chunk := 0; offset+2 <= len(compressed) && chunk*lznt1ChunkSize < unitSize; chunk++ {
+		header := binary.LittleEndian.Uint16(compressed[offset:])
+		if header == 0 {
+			break
+		}
+		dataLength := int(header&sizeMask) + 1
+		offset += 2
+		if offset+dataLength > len(compressed) {
+			err = fmt.Errorf("decompressLZNT1() found chunk %d running past the end of the compressed data", chunk)
+			return
+		}
+		data := compressed[offset : offset+dataLength]
+		offset += dataLength
+
+		output := decompressed[chunk*lznt1ChunkSize:]
+		if len(output) > lznt1ChunkSize {
+			output = output[:lznt1ChunkSize]
+		}
+		if header&flagCompressed == 0 {
+			copy(output, data)
+			continue
+		}
+		err = decompressLZNT1Chunk(data, output)
+		if err != nil {
+			err = fmt.Errorf("decompressLZNT1() failed on chunk %d: %w", chunk, err)
+			return
+		}
+	}
+	return
+}
+
+// decompressLZNT1Chunk decompresses a single compressed chunk into output. Every flag byte is followed by eight tokens,
+// each either a literal byte or a two byte back reference. How the bits of a back reference are split between its
+// offset and length depends on how much of the chunk has been written so far.
+func decompressLZNT1Chunk(data []byte, output []byte) (err error) {
+	position := 0
+	index := 0
+	for index < len(data) && position < len(output) {
+		flags := data[index]
+		index++
+		for bit := uint(0); bit < 8 && index < len(data) && position < len(output); bit++ {
+			if flags&(1<<bit) == 0 {
+				output[position] = data[index]
+				position++
+				index++
+				continue
+			}
+			if index+2 > len(data) {
+				return errors.New("back reference cut short")
+			}
+			token := binary.LittleEndian.Uint16(data[index:])
+			index += 2
+
+			offsetBits := uint(4)
+			for i := position - 1; i >= 0x10; i >>= 1 {
+				offsetBits++
+			}
+			length := int(token&(0xffff>>offsetBits)) + 3
+			backOffset := int(token>>(16-offsetBits)) + 1
+			if backOffset > position {
+				return fmt.Errorf("back reference to %d bytes before position %d", backOffset, position)
+			}
+			for i := 0; i < length && position < len(output); i++ {
+				output[position] = output[position-backOffset]
+				position++
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_decompressLZNT1(t *testing.T) {
+	repeated := bytes.Repeat([]byte("abc"), 4)
+	uncompressedChunk := bytes.Repeat([]byte{0x5a}, lznt1ChunkSize)
+	tests := []struct {
+		name       string
+		compressed []byte
+		unitSize   int
+		want       []byte
+		wantErr    bool
+	}{
+		{
+			name: "literals and a back reference",
+			// Three literals, then a back reference 3 bytes back for 9 bytes
+			compressed: []byte{0x05, 0xb0, 0x08, 'a', 'b', 'c', 0x06, 0x20},
+			unitSize:   lznt1ChunkSize,
+			want:       append(repeated, make([]byte, lznt1ChunkSize-len(repeated))...),
+		},
+		{
+			name:       "uncompressed chunk followed by a compressed one",
+			compressed: append(append([]byte{0xff, 0x3f}, uncompressedChunk...), 0x05, 0xb0, 0x08, 'a', 'b', 'c', 0x06, 0x20, 0x00, 0x00),
+			unitSize:   2 * lznt1ChunkSize,
+			want:       append(append(append([]byte(nil), uncompressedChunk...), repeated...), make([]byte, lznt1ChunkSize-len(repeated))...),
+		},
+		{
+			name:       "back reference before the start",
+			compressed: []byte{0x02, 0xb0, 0x01, 0x06, 0x20},
+			unitSize:   lznt1ChunkSize,
+			wantErr:    true,
+		},
+		{
+			name:       "chunk cut short",
+			compressed: []byte{0x05, 0xb0, 0x08, 'a'},
+			unitSize:   lznt1ChunkSize,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decompressLZNT1(tt.compressed, tt.unitSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decompressLZNT1() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("decompressLZNT1() = %q, want %q", got[:32], tt.want[:32])
+			}
+		})
+	}
+}
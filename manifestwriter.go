@@ -0,0 +1,70 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ManifestResultWriter writes the SHA-256 hash of each file into Output as a line of sha256sum, with the name the file
+// gets in a zip, tar or directory, e.g. c/windows/system32/config/sam, so `sha256sum -c` can check an extracted
+// output. It's meant to go along with another writer in a MultiResultWriter. Files that can't be read to their end are
+// left out. Output is closed once the collection is done if it's an io.Closer, and completed if it's a PartialFile.
+type ManifestResultWriter struct {
+	Output io.Writer
+	Logger Logger // see ZipResultWriter
+
+	entryNames map[string]bool
+}
+
+// ResultWriter hashes each file as it's read. If ctx is cancelled the manifest ends with the files hashed so far.
+func (manifestResultWriter *ManifestResultWriter) ResultWriter(ctx context.Context, fileReaders chan CollectedFile, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	logger := loggerOrDefault(manifestResultWriter.Logger)
+	complete := false
+	defer func() {
+		if closer, ok := manifestResultWriter.Output.(io.Closer); ok {
+			if closeErr := closeOutput(closer, complete); closeErr != nil && err == nil {
+				err = fmt.Errorf("failed to close the hash manifest: %w", closeErr)
+			}
+		}
+	}()
+	if manifestResultWriter.entryNames == nil {
+		manifestResultWriter.entryNames = make(map[string]bool)
+	}
+
+	for {
+		var file CollectedFile
+		var open bool
+		select {
+		case file, open = <-fileReaders:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		if !open {
+			if err = ctx.Err(); err == nil {
+				complete = true
+			}
+			return
+		}
+		entryName := uniqueEntryName(manifestResultWriter.entryNames, treeEntryName(file.fullPath))
+		entryHash := sha256.New()
+		if _, readErr := io.Copy(entryHash, file.reader); readErr != nil {
+			logger.Warnf("Leaving '%s' out of the hash manifest, it couldn't be read: %v", file.fullPath, readErr)
+			continue
+		}
+		_, err = fmt.Fprintf(manifestResultWriter.Output, "%s  %s\n", hex.EncodeToString(entryHash.Sum(nil)), entryName)
+		if err != nil {
+			file.Failed(err)
+			err = fmt.Errorf("resultWriter failed to write '%s' to the hash manifest: %w", file.fullPath, err)
+			return
+		}
+		file.Written()
+	}
+}
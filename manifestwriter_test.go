@@ -0,0 +1,49 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+// closingBuffer is an output that records whether it was closed.
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (buffer *closingBuffer) Close() error {
+	buffer.closed = true
+	return nil
+}
+
+func TestManifestResultWriter_ResultWriter(t *testing.T) {
+	output := new(closingBuffer)
+	resultWriter := ManifestResultWriter{Output: output}
+	fileReaders := make(chan CollectedFile, 4)
+	fileReaders <- fileReader{fullPath: `c:\windows\system32\config\sam`, reader: strings.NewReader("regf")}
+	fileReaders <- fileReader{fullPath: `c:\unreadable`, reader: iotest.TimeoutReader(strings.NewReader("x"))}
+	fileReaders <- fileReader{fullPath: `C:\Windows\System32\config\SAM`, reader: strings.NewReader("")}
+	fileReaders <- fileReader{fullPath: reportFileName, reader: strings.NewReader("{}")}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	if err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+		t.Fatalf("ManifestResultWriter.ResultWriter() error = %v", err)
+	}
+
+	want := "7323e77d1f51ee69b36ef874d2ee6f85f322e12a46f6281f9bd29c8dbb829460  c/windows/system32/config/sam\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  C/Windows/System32/config/SAM (2)\n" +
+		"44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a  " + reportFileName + "\n"
+	if got := output.String(); got != want {
+		t.Errorf("ManifestResultWriter.ResultWriter() wrote\n%s\nwant\n%s", got, want)
+	}
+	if !output.closed {
+		t.Error("ManifestResultWriter.ResultWriter() didn't close its output")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows/registry"
+	"io"
+	"os"
+	"sort"
+)
+
+const physicalMemoryFileName = "memory/physical_memory.raw"
+
+// MemoryRange is a range of physical addresses backed by RAM.
+type MemoryRange struct {
+	Start  int64 `json:"start"`
+	Length int64 `json:"length"`
+}
+
+func (memoryRange MemoryRange) end() int64 {
+	return memoryRange.Start + memoryRange.Length
+}
+
+// PhysicalMemoryAcquirer captures physical memory through a driver that exposes it as a device, such as WinPmem's
+// \\.\pmem once the driver is loaded and set to read physical memory. Reading the device at an offset has to return
+// the memory at that physical address. Windows can't do this on its own, so the driver has to be loaded first.
+//
+// The image is in the raw format, where offsets are physical addresses. The gaps between ranges, such as where devices
+// are mapped below 4 GiB, are written as zeros rather than read, since reading device memory can hang the machine.
+type PhysicalMemoryAcquirer struct {
+	DevicePath string
+	Ranges     []MemoryRange // the ranges to read, empty reads the RAM Windows found at boot
+}
+
+// Name returns where the image goes in the output.
+func (acquirer *PhysicalMemoryAcquirer) Name() string {
+	return physicalMemoryFileName
+}
+
+// Acquire opens the device and returns a reader over the image.
+func (acquirer *PhysicalMemoryAcquirer) Acquire(ctx context.Context, logger Logger) (reader io.ReadCloser, size int64, err error) {
+	ranges := append([]MemoryRange(nil), acquirer.Ranges...)
+	if len(ranges) == 0 {
+		ranges, err = physicalMemoryRanges()
+		if err != nil {
+			err = fmt.Errorf("failed to get the physical memory ranges: %w", err)
+			return
+		}
+	}
+	if len(ranges) == 0 {
+		err = errors.New("there are no physical memory ranges to read")
+		return
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	for index, memoryRange := range ranges {
+		if memoryRange.Start < 0 || memoryRange.Length <= 0 || (index > 0 && memoryRange.Start < ranges[index-1].end()) {
+			err = fmt.Errorf("the physical memory range %#x-%#x is invalid or overlaps another", memoryRange.Start, memoryRange.end())
+			return
+		}
+	}
+	device, err := os.Open(acquirer.DevicePath)
+	if err != nil {
+		err = fmt.Errorf("failed to open the physical memory device %s: %w", acquirer.DevicePath, err)
+		return
+	}
+	size = ranges[len(ranges)-1].end()
+	logger.Debugf("Reading %d physical memory ranges up to %#x from %s.", len(ranges), size, acquirer.DevicePath)
+	reader = newPhysicalMemoryReader(device, ranges)
+	return
+}
+
+// physicalMemoryDevice is what physical memory is read from, the driver's device outside of tests.
+type physicalMemoryDevice interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// physicalMemoryReader reads the ranges from the device in large page aligned chunks, whatever size the caller reads
+// in, since drivers generally only read whole pages.
+type physicalMemoryReader struct {
+	device       physicalMemoryDevice
+	ranges       []MemoryRange
+	currentRange int
+	offset       int64
+	buffer       []byte
+	bufferStart  int64
+	bufferLength int
+}
+
+func newPhysicalMemoryReader(device physicalMemoryDevice, ranges []MemoryRange) *physicalMemoryReader {
+	return &physicalMemoryReader{
+		device: device,
+		ranges: ranges,
+		buffer: make([]byte, 1024*1024),
+	}
+}
+
+func (reader *physicalMemoryReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	for reader.currentRange < len(reader.ranges) && reader.offset >= reader.ranges[reader.currentRange].end() {
+		reader.currentRange++
+	}
+	if reader.currentRange == len(reader.ranges) {
+		err = io.EOF
+		return
+	}
+	memoryRange := reader.ranges[reader.currentRange]
+
+	// A gap between ranges
+	if reader.offset < memoryRange.Start {
+		numberOfBytesRead = len(byteSliceToPopulate)
+		if gap := memoryRange.Start - reader.offset; gap < int64(numberOfBytesRead) {
+			numberOfBytesRead = int(gap)
+		}
+		for index := range byteSliceToPopulate[:numberOfBytesRead] {
+			byteSliceToPopulate[index] = 0
+		}
+		reader.offset += int64(numberOfBytesRead)
+		return
+	}
+
+	if reader.offset < reader.bufferStart || reader.offset >= reader.bufferStart+int64(reader.bufferLength) {
+		length := len(reader.buffer)
+		if remaining := memoryRange.end() - reader.offset; remaining < int64(length) {
+			length = int(remaining)
+		}
+		reader.bufferStart = reader.offset
+		reader.bufferLength, err = reader.device.ReadAt(reader.buffer[:length], reader.offset)
+		if reader.bufferLength < length {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			err = fmt.Errorf("failed to read physical memory at %#x: %w", reader.offset, err)
+			reader.bufferLength = 0
+			return
+		}
+		err = nil
+	}
+	numberOfBytesRead = copy(byteSliceToPopulate, reader.buffer[reader.offset-reader.bufferStart:reader.bufferLength])
+	reader.offset += int64(numberOfBytesRead)
+	return
+}
+
+// Close closes the device.
+func (reader *physicalMemoryReader) Close() error {
+	return reader.device.Close()
+}
+
+// physicalMemoryRanges returns the RAM Windows found at boot, from the resource list it keeps in the registry. It's a
+// variable so tests don't depend on the host.
+var physicalMemoryRanges = func() (ranges []MemoryRange, err error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\RESOURCEMAP\System Resources\Physical Memory`, registry.QUERY_VALUE)
+	if err != nil {
+		err = fmt.Errorf("failed to open the physical memory resource map: %w", err)
+		return
+	}
+	defer key.Close()
+	data := make([]byte, 4096)
+	size, valueType, err := key.GetValue(".Translated", data)
+	if err == registry.ErrShortBuffer {
+		data = make([]byte, size)
+		size, valueType, err = key.GetValue(".Translated", data)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read the physical memory resource list: %w", err)
+		return
+	}
+	if valueType != registry.RESOURCE_LIST {
+		err = fmt.Errorf("the physical memory resource list has the unexpected type %d", valueType)
+		return
+	}
+	ranges, err = parseResourceList(data[:size])
+	return
+}
+
+// parseResourceList parses the memory ranges out of a CM_RESOURCE_LIST. Its structures are packed to 4 bytes, so the
+// partial descriptors are 20 bytes on both 32 and 64 bit Windows.
+func parseResourceList(data []byte) (ranges []MemoryRange, err error) {
+	const (
+		sizeFullDescriptorHeader = 16
+		sizePartialDescriptor    = 20
+		resourceTypeMemory       = 3
+		resourceTypeMemoryLarge  = 7
+		resourceMemoryLarge40    = 0x200
+		resourceMemoryLarge48    = 0x400
+		resourceMemoryLarge64    = 0x800
+		offsetPartialCount       = 12
+		offsetPartialFlags       = 2
+		offsetPartialStart       = 4
+		offsetPartialLength      = 12
+	)
+	if len(data) < 4 {
+		err = errors.New("the resource list is too short")
+		return
+	}
+	numberOfFullDescriptors := binary.LittleEndian.Uint32(data)
+	offset := 4
+	for full := uint32(0); full < numberOfFullDescriptors; full++ {
+		if offset+sizeFullDescriptorHeader > len(data) {
+			err = errors.New("the resource list is cut short")
+			return
+		}
+		numberOfPartialDescriptors := int(binary.LittleEndian.Uint32(data[offset+offsetPartialCount:]))
+		offset += sizeFullDescriptorHeader
+		for partial := 0; partial < numberOfPartialDescriptors; partial++ {
+			if offset+sizePartialDescriptor > len(data) {
+				err = errors.New("the resource list is cut short")
+				return
+			}
+			descriptor := data[offset : offset+sizePartialDescriptor]
+			offset += sizePartialDescriptor
+			length := int64(binary.LittleEndian.Uint32(descriptor[offsetPartialLength:]))
+			switch descriptor[0] {
+			case resourceTypeMemory:
+			case resourceTypeMemoryLarge:
+				// Large ranges store their length shifted right to fit in 32 bits
+				flags := binary.LittleEndian.Uint16(descriptor[offsetPartialFlags:])
+				switch {
+				case flags&resourceMemoryLarge40 != 0:
+					length <<= 8
+				case flags&resourceMemoryLarge48 != 0:
+					length <<= 16
+				case flags&resourceMemoryLarge64 != 0:
+					length <<= 32
+				}
+			default:
+				continue
+			}
+			ranges = append(ranges, MemoryRange{
+				Start:  int64(binary.LittleEndian.Uint64(descriptor[offsetPartialStart:])),
+				Length: length,
+			})
+		}
+	}
+	return
+}
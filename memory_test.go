@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_parseResourceList(t *testing.T) {
+	partialDescriptor := func(resourceType byte, flags uint16, start uint64, length uint32) []byte {
+		descriptor := make([]byte, 20)
+		descriptor[0] = resourceType
+		binary.LittleEndian.PutUint16(descriptor[2:], flags)
+		binary.LittleEndian.PutUint64(descriptor[4:], start)
+		binary.LittleEndian.PutUint32(descriptor[12:], length)
+		return descriptor
+	}
+	data := make([]byte, 4+16)
+	binary.LittleEndian.PutUint32(data, 1)
+	binary.LittleEndian.PutUint32(data[4+12:], 4)
+	data = append(data, partialDescriptor(3, 0, 0x1000, 0x9e000)...)
+	data = append(data, partialDescriptor(1, 0, 0x3f8, 8)...) // a port, not memory
+	data = append(data, partialDescriptor(3, 0, 0x100000, 0xbfe00000)...)
+	data = append(data, partialDescriptor(7, 0x200, 0x100000000, 0x400000)...) // 1 GiB shifted right by 8
+
+	got, err := parseResourceList(data)
+	if err != nil {
+		t.Fatalf("parseResourceList() error = %v", err)
+	}
+	want := []MemoryRange{
+		{Start: 0x1000, Length: 0x9e000},
+		{Start: 0x100000, Length: 0xbfe00000},
+		{Start: 0x100000000, Length: 0x40000000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseResourceList() = %+v, want %+v", got, want)
+	}
+
+	if _, err = parseResourceList(data[:len(data)-1]); err == nil {
+		t.Error("parseResourceList() of a cut short list should fail")
+	}
+}
+
+func TestPhysicalMemoryAcquirer(t *testing.T) {
+	memory := make([]byte, 0x6000)
+	for index := range memory {
+		memory[index] = byte(index/0x1000) + 1
+	}
+	device, err := ioutil.TempFile("", "gofor-memory-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(device.Name())
+	_, _ = device.Write(memory)
+	device.Close()
+
+	acquirer := &PhysicalMemoryAcquirer{
+		DevicePath: device.Name(),
+		Ranges:     []MemoryRange{{Start: 0x4000, Length: 0x2000}, {Start: 0x1000, Length: 0x1000}},
+	}
+	reader, size, err := acquirer.Acquire(context.Background(), loggerOrDefault(nil))
+	if err != nil {
+		t.Fatalf("PhysicalMemoryAcquirer.Acquire() error = %v", err)
+	}
+	defer reader.Close()
+	// Read a byte at a time to check reads that don't line up with pages are served from the buffer
+	got, err := ioutil.ReadAll(iotest.OneByteReader(reader))
+	if err != nil {
+		t.Fatalf("reading the image failed: %v", err)
+	}
+	want := make([]byte, 0x6000)
+	copy(want[0x1000:0x2000], memory[0x1000:0x2000])
+	copy(want[0x4000:0x6000], memory[0x4000:0x6000])
+	if size != 0x6000 || !bytes.Equal(got, want) {
+		t.Errorf("PhysicalMemoryAcquirer image is %d bytes of size %d, want the ranges at their physical offsets with the gaps zeroed", len(got), size)
+	}
+
+	// A range past the end of the device fails rather than coming back short
+	acquirer.Ranges = []MemoryRange{{Start: 0x5000, Length: 0x2000}}
+	reader, _, err = acquirer.Acquire(context.Background(), loggerOrDefault(nil))
+	if err != nil {
+		t.Fatalf("PhysicalMemoryAcquirer.Acquire() error = %v", err)
+	}
+	defer reader.Close()
+	if _, err = ioutil.ReadAll(reader); err == nil {
+		t.Error("reading a range past the end of the device should fail")
+	}
+
+	acquirer.Ranges = []MemoryRange{{Start: 0x1000, Length: 0x2000}, {Start: 0x2000, Length: 0x1000}}
+	if _, _, err = acquirer.Acquire(context.Background(), loggerOrDefault(nil)); err == nil {
+		t.Error("PhysicalMemoryAcquirer.Acquire() with overlapping ranges should fail")
+	}
+}
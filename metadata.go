@@ -0,0 +1,222 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"golang.org/x/sys/windows"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const fileMetadataFileName = "file_metadata.jsonl"
+
+// FileMetadata is what the MFT knows about a collected file. The output formats only keep one modification time per
+// file, so the original timestamps are written to file_metadata.jsonl, one of these per line.
+type FileMetadata struct {
+	Path          string               `json:"path"`
+	Volume        string               `json:"volume"`
+	RecordNumber  uint32               `json:"record_number"`
+	Size          int64                `json:"size"`
+	Attributes    []string             `json:"attributes"`
+	SecurityID    uint32               `json:"security_id"`     // the file's entry in $Secure
+	Owner         string               `json:"owner,omitempty"` // the owner's SID, when the file's security descriptor could be read
+	Group         string               `json:"group,omitempty"` // the primary group's SID
+	DACL          []AccessControlEntry `json:"dacl,omitempty"`
+	DACLProtected bool                 `json:"dacl_protected,omitempty"` // the DACL doesn't inherit from the parent directory
+	SiCreated     time.Time            `json:"si_created"`
+	SiModified    time.Time            `json:"si_modified"`
+	SiAccessed    time.Time            `json:"si_accessed"`
+	SiChanged     time.Time            `json:"si_changed"`
+	FnCreated     time.Time            `json:"fn_created"`
+	FnModified    time.Time            `json:"fn_modified"`
+	FnAccessed    time.Time            `json:"fn_accessed"`
+	FnChanged     time.Time            `json:"fn_changed"`
+}
+
+// fileAttributeNames are the names of the file attribute flags in $STANDARD_INFORMATION, in the order they are listed.
+var fileAttributeNames = []struct {
+	flag uint32
+	name string
+}{
+	{0x00000001, "readonly"},
+	{0x00000002, "hidden"},
+	{0x00000004, "system"},
+	{0x00000020, "archive"},
+	{0x00000040, "device"},
+	{0x00000080, "normal"},
+	{0x00000100, "temporary"},
+	{0x00000200, "sparse"},
+	{0x00000400, "reparse_point"},
+	{0x00000800, "compressed"},
+	{0x00001000, "offline"},
+	{0x00002000, "not_content_indexed"},
+	{0x00004000, "encrypted"},
+	{0x00008000, "integrity_stream"},
+	{0x00020000, "no_scrub_data"},
+	{0x00040000, "recall_on_open"},
+	{0x00080000, "pinned"},
+	{0x00100000, "unpinned"},
+	{0x00400000, "recall_on_data_access"},
+}
+
+// recordMetadata is what a file's MFT record holds for its FileMetadata, kept from the MFT walk until the file is
+// collected.
+type recordMetadata struct {
+	recordNumber        uint32
+	standardInformation mft.StandardInformationAttribute
+	fileName            mft.FileNameAttribute
+	fileAttributes      uint32
+	securityID          uint32
+}
+
+// newRecordMetadata gathers a file record's metadata. The MFT parser leaves the file attributes and security ID out of
+// $STANDARD_INFORMATION, so they are read from the attribute itself.
+func newRecordMetadata(record mft.RawMasterFileTableRecord, recordHeader mft.RecordHeader, standardInformation mft.StandardInformationAttribute, fileName mft.FileNameAttribute) (metadata recordMetadata) {
+	metadata = recordMetadata{
+		recordNumber:        recordHeader.RecordNumber,
+		standardInformation: standardInformation,
+		fileName:            fileName,
+	}
+	metadata.fileAttributes, metadata.securityID, _ = standardInformationDetails(record, recordHeader.AttributesOffset)
+	return
+}
+
+// standardInformationDetails reads the file attribute flags and security ID out of a record's $STANDARD_INFORMATION.
+// Volumes formatted before NTFS 3.0 have no security ID, it comes back as zero.
+func standardInformationDetails(record mft.RawMasterFileTableRecord, attributesOffset uint16) (fileAttributes uint32, securityID uint32, found bool) {
+	const (
+		codeStandardInformation = 0x10
+		offsetContentLength     = 0x10
+		offsetContentOffset     = 0x14
+		offsetFileAttributes    = 0x20
+		offsetSecurityID        = 0x34
+	)
+	attribute, found := unnamedAttribute(record, attributesOffset, codeStandardInformation)
+	if !found {
+		return
+	}
+	contentLength := int(binary.LittleEndian.Uint32(attribute[offsetContentLength:]))
+	contentOffset := int(binary.LittleEndian.Uint16(attribute[offsetContentOffset:]))
+	if contentOffset+contentLength > len(attribute) || contentLength < offsetFileAttributes+4 {
+		found = false
+		return
+	}
+	content := attribute[contentOffset : contentOffset+contentLength]
+	fileAttributes = binary.LittleEndian.Uint32(content[offsetFileAttributes:])
+	if contentLength >= offsetSecurityID+4 {
+		securityID = binary.LittleEndian.Uint32(content[offsetSecurityID:])
+	}
+	return
+}
+
+// fileMetadata turns the metadata of a found file into its FileMetadata.
+func (file foundFile) fileMetadata(volumeLetter string) (metadata FileMetadata) {
+	metadata = FileMetadata{
+		Path:         file.fullPath,
+		Volume:       volumeLetter,
+		RecordNumber: file.metadata.recordNumber,
+		Size:         file.totalSize(),
+		Attributes:   attributeNames(file.metadata.fileAttributes),
+		SecurityID:   file.metadata.securityID,
+		SiCreated:    file.metadata.standardInformation.SiCreated,
+		SiModified:   file.metadata.standardInformation.SiModified,
+		SiAccessed:   file.metadata.standardInformation.SiAccessed,
+		SiChanged:    file.metadata.standardInformation.SiChanged,
+		FnCreated:    file.metadata.fileName.FnCreated,
+		FnModified:   file.metadata.fileName.FnModified,
+		FnAccessed:   file.metadata.fileName.FnAccessed,
+		FnChanged:    file.metadata.fileName.FnChanged,
+	}
+	return
+}
+
+// times returns the file's timestamps for its entry in the output.
+func (metadata recordMetadata) times() fileTimes {
+	return fileTimes{
+		created:  metadata.standardInformation.SiCreated,
+		modified: metadata.standardInformation.SiModified,
+		accessed: metadata.standardInformation.SiAccessed,
+	}
+}
+
+func attributeNames(fileAttributes uint32) (names []string) {
+	names = make([]string, 0)
+	for _, attribute := range fileAttributeNames {
+		if fileAttributes&attribute.flag != 0 {
+			names = append(names, attribute.name)
+		}
+	}
+	return
+}
+
+// fileSecurityDescriptor returns a file's self-relative security descriptor with its owner, group and DACL. It's a
+// variable so tests don't depend on the host's files.
+var fileSecurityDescriptor = func(path string) (descriptor []byte, err error) {
+	securityDescriptor, err := windows.GetNamedSecurityInfo(longPath(path), windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		err = fmt.Errorf("GetNamedSecurityInfo failed on '%s': %w", path, err)
+		return
+	}
+	length := securityDescriptor.Length()
+	descriptor = make([]byte, length)
+	copy(descriptor, (*[1 << 30]byte)(unsafe.Pointer(securityDescriptor))[:length:length])
+	return
+}
+
+// metadataCollector gathers the FileMetadata of every collected file. Like the reportBuilder its methods do nothing
+// when it's nil.
+type metadataCollector struct {
+	mutex  sync.Mutex
+	logger Logger
+	files  []FileMetadata
+}
+
+func newMetadataCollector(enabled bool, logger Logger) *metadataCollector {
+	if !enabled {
+		return nil
+	}
+	return &metadataCollector{logger: logger, files: make([]FileMetadata, 0)}
+}
+
+// add records a collected file, looking up its owner, group and DACL through the API.
+func (collector *metadataCollector) add(metadata FileMetadata) {
+	if collector == nil {
+		return
+	}
+	data, err := fileSecurityDescriptor(metadata.Path)
+	if err != nil {
+		collector.logger.Debugf("Failed to get the security descriptor of '%s': %v", metadata.Path, err)
+	} else if descriptor, err := parseSecurityDescriptor(data); err != nil {
+		collector.logger.Debugf("Failed to parse the security descriptor of '%s': %v", metadata.Path, err)
+	} else {
+		metadata.Owner, metadata.Group = descriptor.owner, descriptor.group
+		metadata.DACL, metadata.DACLProtected = descriptor.dacl, descriptor.daclProtected
+	}
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.files = append(collector.files, metadata)
+}
+
+// reader returns the metadata as JSON lines.
+func (collector *metadataCollector) reader() (reader io.Reader, err error) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	for _, metadata := range collector.files {
+		err = encoder.Encode(metadata)
+		if err != nil {
+			err = fmt.Errorf("failed to serialize the metadata of '%s': %w", metadata.Path, err)
+			return
+		}
+	}
+	reader = &buffer
+	return
+}
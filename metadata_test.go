@@ -0,0 +1,172 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_standardInformationDetails(t *testing.T) {
+	standardInformation := func(length int, fileAttributes uint32, securityID uint32) []byte {
+		content := make([]byte, length)
+		binary.LittleEndian.PutUint32(content[0x20:], fileAttributes)
+		if length >= 0x38 {
+			binary.LittleEndian.PutUint32(content[0x34:], securityID)
+		}
+		return content
+	}
+	tests := []struct {
+		name               string
+		record             mft.RawMasterFileTableRecord
+		wantFileAttributes uint32
+		wantSecurityID     uint32
+		wantFound          bool
+	}{
+		{
+			name: "ntfs 3.0",
+			record: buildResidentTestRecord([]residentTestAttribute{
+				{attributeType: 0x10, data: standardInformation(0x48, 0x26, 0x2cdf)},
+				{attributeType: 0x80, data: []byte("data")},
+			}, false),
+			wantFileAttributes: 0x26,
+			wantSecurityID:     0x2cdf,
+			wantFound:          true,
+		},
+		{
+			name: "before ntfs 3.0",
+			record: buildResidentTestRecord([]residentTestAttribute{
+				{attributeType: 0x10, data: standardInformation(0x30, 0x01, 0)},
+			}, false),
+			wantFileAttributes: 0x01,
+			wantFound:          true,
+		},
+		{
+			name:      "no standard information",
+			record:    buildResidentTestRecord([]residentTestAttribute{{attributeType: 0x80, data: []byte("data")}}, false),
+			wantFound: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotFileAttributes, gotSecurityID, gotFound := standardInformationDetails(tt.record, 0x38)
+			if gotFileAttributes != tt.wantFileAttributes || gotSecurityID != tt.wantSecurityID || gotFound != tt.wantFound {
+				t.Errorf("standardInformationDetails() = %#x, %d, %v, want %#x, %d, %v", gotFileAttributes, gotSecurityID, gotFound, tt.wantFileAttributes, tt.wantSecurityID, tt.wantFound)
+			}
+		})
+	}
+}
+
+func Test_attributeNames(t *testing.T) {
+	tests := []struct {
+		name           string
+		fileAttributes uint32
+		want           []string
+	}{
+		{name: "none", fileAttributes: 0, want: []string{}},
+		{name: "hidden system archive", fileAttributes: 0x26, want: []string{"hidden", "system", "archive"}},
+		{name: "unknown flags are left out", fileAttributes: 0x80000800, want: []string{"compressed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attributeNames(tt.fileAttributes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("attributeNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_metadataCollector(t *testing.T) {
+	defer func(original func(string) ([]byte, error)) { fileSecurityDescriptor = original }(fileSecurityDescriptor)
+	fileSecurityDescriptor = func(path string) ([]byte, error) {
+		switch path {
+		case `c:\locked`:
+			return nil, errors.New("access denied")
+		case `c:\corrupt`:
+			return []byte{1, 0}, nil
+		}
+		return testSecurityDescriptor(securityDaclPresent|securityDaclProtected, testSID(5, 18), testSID(5, 32, 544), testACE(0x00, 0x00, 0x001f01ff, testSID(5, 18))), nil
+	}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := foundFiles{
+		{
+			fullPath:     `c:\windows\system32\config\software`,
+			resident:     true,
+			residentData: []byte("hive"),
+			metadata: recordMetadata{
+				recordNumber:        42,
+				standardInformation: mft.StandardInformationAttribute{SiCreated: created},
+				fileName:            mft.FileNameAttribute{FnCreated: created.Add(time.Hour)},
+				fileAttributes:      0x20,
+				securityID:          256,
+			},
+		},
+		{fullPath: `c:\locked`},
+		{fullPath: `c:\corrupt`},
+	}
+	want := []FileMetadata{
+		{
+			Path:         `c:\windows\system32\config\software`,
+			Volume:       "c",
+			RecordNumber: 42,
+			Size:         4,
+			Attributes:   []string{"archive"},
+			SecurityID:   256,
+			Owner:        "S-1-5-18",
+			Group:        "S-1-5-32-544",
+			DACL: []AccessControlEntry{
+				{Type: "allow", Mask: 0x001f01ff, Rights: []string{"full_control"}, SID: "S-1-5-18"},
+			},
+			DACLProtected: true,
+			SiCreated:     created,
+			FnCreated:     created.Add(time.Hour),
+		},
+		{
+			Path:       `c:\locked`,
+			Volume:     "c",
+			Attributes: []string{},
+		},
+		{
+			Path:       `c:\corrupt`,
+			Volume:     "c",
+			Attributes: []string{},
+		},
+	}
+
+	collector := newMetadataCollector(true, loggerOrDefault(nil))
+	for _, file := range files {
+		collector.add(file.fileMetadata("c"))
+	}
+	reader, err := collector.reader()
+	if err != nil {
+		t.Fatalf("metadataCollector.reader() error = %v", err)
+	}
+	output, _ := ioutil.ReadAll(reader)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("metadataCollector.reader() returned %d lines, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		var got FileMetadata
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d isn't JSON: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("line %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	// A collector that wasn't asked for does nothing
+	var disabled *metadataCollector
+	disabled.add(files[0].fileMetadata("c"))
+	if newMetadataCollector(false, loggerOrDefault(nil)) != nil {
+		t.Error("newMetadataCollector(false) != nil")
+	}
+}
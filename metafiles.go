@@ -0,0 +1,38 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	mft "github.com/Go-Forensics/MFT-Parser"
+)
+
+const (
+	mftRecordNumber    = 0
+	secureRecordNumber = 9
+	// The file records NTFS reserves for its metadata files, such as $Bitmap, $Boot and $Secure, come before this one
+	firstUserRecordNumber = 16
+)
+
+// metafileStreams are the $DATA attributes of the NTFS metadata files whose data isn't in their unnamed $DATA
+// attribute. $Secure keeps its security descriptors in $SDS, which is what collecting it reads.
+var metafileStreams = map[uint32]string{
+	secureRecordNumber: "$SDS",
+}
+
+// metafileStream returns the data of an NTFS metadata file as a stream when its data runs alone would read it wrong.
+// The MFT parser keeps whichever $DATA attribute comes last in the record, which for $Secure happens to be $SDS, and
+// the size in a metadata file's $FILE_NAME is rarely up to date, so $Bitmap or $Secure:$SDS read by their data runs
+// would be padded out to the clusters holding them. The $MFT is left to be read the way it always has been.
+func metafileStream(record mft.RawMasterFileTableRecord, recordHeader mft.RecordHeader, dataRuns mft.DataRuns) (stream ntfsStream, found bool) {
+	if recordHeader.RecordNumber == mftRecordNumber || recordHeader.RecordNumber >= firstUserRecordNumber {
+		return
+	}
+	name := metafileStreams[recordHeader.RecordNumber]
+	stream, hasSizes, found := parseNamedNonResidentData(record, recordHeader.AttributesOffset, name)
+	var allocated int64
+	for _, dataRun := range dataRuns {
+		allocated += dataRun.Length
+	}
+	found = found && hasSizes && (name != "" || stream.size != allocated)
+	return
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"testing"
+)
+
+// buildMetafileTestRecord lays out an MFT record with a single non-resident $DATA attribute of one run of 4 clusters.
+func buildMetafileTestRecord(name string, size uint64) mft.RawMasterFileTableRecord {
+	record := make([]byte, 1024)
+	copy(record, "FILE")
+	binary.LittleEndian.PutUint16(record[0x04:], 0x30)
+	binary.LittleEndian.PutUint16(record[0x06:], 1)
+	attribute := record[0x38:]
+	runListOffset := 0x40 + (len(name)*2+7)&^7
+	binary.LittleEndian.PutUint32(attribute[0x00:], 0x80)
+	binary.LittleEndian.PutUint32(attribute[0x04:], uint32(runListOffset+8))
+	attribute[0x08] = 1
+	attribute[0x09] = byte(len(name))
+	binary.LittleEndian.PutUint16(attribute[0x0a:], 0x40)
+	binary.LittleEndian.PutUint16(attribute[0x20:], uint16(runListOffset))
+	binary.LittleEndian.PutUint64(attribute[0x30:], size)
+	binary.LittleEndian.PutUint64(attribute[0x38:], size)
+	for i, character := range name {
+		attribute[0x40+i*2] = byte(character)
+	}
+	copy(attribute[runListOffset:], []byte{0x11, 0x04, 0x20})
+	binary.LittleEndian.PutUint32(attribute[runListOffset+8:], 0xffffffff)
+	return record
+}
+
+func Test_metafileStream(t *testing.T) {
+	allocated := mft.DataRuns{0: {AbsoluteOffset: 0x20 * 4096, Length: 4 * 4096}}
+	tests := []struct {
+		name         string
+		recordNumber uint32
+		record       mft.RawMasterFileTableRecord
+		wantFound    bool
+		wantSize     int64
+	}{
+		{name: "$Secure:$SDS", recordNumber: secureRecordNumber, record: buildMetafileTestRecord("$SDS", 10000), wantFound: true, wantSize: 10000},
+		{name: "$Secure without $SDS", recordNumber: secureRecordNumber, record: buildMetafileTestRecord("$SII", 10000)},
+		{name: "$Bitmap smaller than its clusters", recordNumber: bitmapRecordNumber, record: buildMetafileTestRecord("", 12345), wantFound: true, wantSize: 12345},
+		{name: "$Boot filling its clusters", recordNumber: 7, record: buildMetafileTestRecord("", 4*4096)},
+		{name: "$MFT", recordNumber: mftRecordNumber, record: buildMetafileTestRecord("", 12345)},
+		{name: "a user's file", recordNumber: firstUserRecordNumber, record: buildMetafileTestRecord("", 12345)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, found := metafileStream(tt.record, mft.RecordHeader{RecordNumber: tt.recordNumber, AttributesOffset: 0x38}, allocated)
+			if found != tt.wantFound {
+				t.Fatalf("metafileStream() found = %v, want %v", found, tt.wantFound)
+			}
+			if found && (stream.size != tt.wantSize || len(stream.extents) != 1 || stream.extents[0].lcn != 0x20) {
+				t.Errorf("metafileStream() = %+v, want %d bytes in cluster 0x20 on", stream, tt.wantSize)
+			}
+		})
+	}
+}
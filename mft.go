@@ -3,13 +3,81 @@
 package windowscollector
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	mft "github.com/Go-Forensics/MFT-Parser"
-	log "github.com/sirupsen/logrus"
 )
 
+// errMFTNotFound is returned by locateMFT when neither the MFT's record 0 nor its copy in $MFTMirr could be read.
+var errMFTNotFound = errors.New("the mft couldn't be found from its record 0 or from $MFTMirr")
+
+// mftFallbackMirror is recorded for a volume whose MFT was found through $MFTMirr and mftFallbackAPI for one that was
+// collected through the API because the MFT couldn't be found at all.
+const (
+	mftFallbackMirror = "mft_mirror"
+	mftFallbackAPI    = "api"
+)
+
+// vbrMftMirrorClusterOffset is where the volume boot record keeps the cluster $MFTMirr starts at.
+const vbrMftMirrorClusterOffset = 0x38
+
+// locateMFT parses the MFT's record 0 to get its data runs. When that fails, as with a damaged MFT or a volume boot
+// record that points somewhere odd, the copy of record 0 in $MFTMirr is used instead and the volume's MFT offset is
+// moved to where that copy says the MFT starts.
+func locateMFT(volume *VolumeHandler) (mftRecord0 mft.MasterFileTableRecord, usedMirror bool, err error) {
+	mftRecord0, err = parseMFTRecord0(volume)
+	if err == nil && len(mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns) == 0 {
+		err = errors.New("the mft's mft record has no data runs")
+	}
+	if err == nil {
+		return
+	}
+	volume.logger().Warnf("Failed to parse the MFT's record 0 on volume %s, trying its copy in $MFTMirr: %v", volume.VolumeLetter, err)
+
+	mftRecord0, mirrorErr := parseMFTMirrorRecord0(volume)
+	if mirrorErr != nil {
+		err = fmt.Errorf("%w: %v; $MFTMirr: %v", errMFTNotFound, err, mirrorErr)
+		return
+	}
+	volume.Vbr.MftByteOffset = mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns[0].AbsoluteOffset
+	volume.logger().Warnf("Found the MFT of volume %s at offset %d through $MFTMirr.", volume.VolumeLetter, volume.Vbr.MftByteOffset)
+	usedMirror, err = true, nil
+	return
+}
+
+// parseMFTMirrorRecord0 parses the copy of the MFT's record 0 that $MFTMirr starts with.
+func parseMFTMirrorRecord0(volume *VolumeHandler) (mftRecord0 mft.MasterFileTableRecord, err error) {
+	vbr, err := readVolumeBootRecord(volume.Handle)
+	if err != nil {
+		err = fmt.Errorf("failed to read the volume boot record: %w", err)
+		return
+	}
+	mirrorCluster := int64(binary.LittleEndian.Uint64(vbr[vbrMftMirrorClusterOffset:]))
+	if mirrorCluster <= 0 || volume.Vbr.BytesPerCluster <= 0 {
+		err = fmt.Errorf("the volume boot record doesn't point at $MFTMirr (cluster %d)", mirrorCluster)
+		return
+	}
+
+	mftRecord0, err = readMFTRecord0(volume, mirrorCluster*volume.Vbr.BytesPerCluster)
+	if err != nil {
+		return
+	}
+	if mftRecord0.RecordHeader.RecordNumber != mftRecordNumber || len(mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns) == 0 {
+		err = fmt.Errorf("the first record in $MFTMirr is record %d, not the mft's", mftRecord0.RecordHeader.RecordNumber)
+		return
+	}
+	return
+}
+
 func parseMFTRecord0(volume *VolumeHandler) (mftRecord0 mft.MasterFileTableRecord, err error) {
+	mftRecord0, err = readMFTRecord0(volume, volume.Vbr.MftByteOffset)
+	return
+}
+
+// readMFTRecord0 parses the MFT record at offset, which is the MFT's own record either where the MFT starts or in
+// $MFTMirr.
+func readMFTRecord0(volume *VolumeHandler, offset int64) (mftRecord0 mft.MasterFileTableRecord, err error) {
 	// Move handle pointer back to beginning of volume
 	_, err = volume.Handle.Seek(0x00, 0)
 	if err != nil {
@@ -18,7 +86,7 @@ func parseMFTRecord0(volume *VolumeHandler) (mftRecord0 mft.MasterFileTableRecor
 	}
 
 	// Seek to the offset where the MFT starts. If it errors, bomb.
-	_, err = volume.Handle.Seek(volume.Vbr.MftByteOffset, 0)
+	_, err = volume.Handle.Seek(offset, 0)
 	if err != nil {
 		err = fmt.Errorf("failed to seek to mft: %w", err)
 		return
@@ -36,6 +104,7 @@ func parseMFTRecord0(volume *VolumeHandler) (mftRecord0 mft.MasterFileTableRecor
 	result, err := mft.RawMasterFileTableRecord(buffer).IsThisAnMftRecord()
 	if err != nil {
 		err = fmt.Errorf("IsThisAnMftRecord() returned an error: %v", err)
+		return
 	} else if result == false {
 		err = errors.New("VolumeHandler.parseMFTRecord0() received an invalid mft record")
 		return
@@ -48,7 +117,7 @@ func parseMFTRecord0(volume *VolumeHandler) (mftRecord0 mft.MasterFileTableRecor
 		err = fmt.Errorf("VolumeHandler.parseMFTRecord0() failed to parse the mft's mft record: %w", err)
 		return
 	}
-	log.Debugf("Identified the following data runs for the MFT itself: %+v", mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns)
+	volume.logger().Debugf("Identified the following data runs for the MFT itself: %+v", mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns)
 
 	return
 }
@@ -3,9 +3,12 @@
 package windowscollector
 
 import (
+	"errors"
 	mft "github.com/Go-Forensics/MFT-Parser"
 	vbr "github.com/Go-Forensics/VBR-Parser"
+	"io/ioutil"
 	"log"
+	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -125,3 +128,56 @@ func Test_parseMFTRecord0(t *testing.T) {
 		})
 	}
 }
+
+func Test_locateMFT(t *testing.T) {
+	volume, err := ioutil.ReadFile(`test\testdata\dummyntfs`)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile() error = %v", err)
+	}
+	// The dummy volume's MFT starts at cluster 1 and its volume boot record puts $MFTMirr at cluster 2
+	damaged := func(mirrored bool) []byte {
+		image := append([]byte(nil), volume...)
+		if mirrored {
+			copy(image[8192:9216], volume[4096:5120])
+		}
+		copy(image[4096:], "JUNK")
+		return image
+	}
+	tests := []struct {
+		name           string
+		image          []byte
+		wantUsedMirror bool
+		wantErr        error
+	}{
+		{name: "record 0", image: volume},
+		{name: "from $MFTMirr", image: damaged(true), wantUsedMirror: true},
+		{name: "$MFTMirr doesn't start with record 0", image: damaged(false), wantErr: errMFTNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageFile, err := ioutil.TempFile("", "dummyntfs")
+			if err != nil {
+				t.Fatalf("ioutil.TempFile() error = %v", err)
+			}
+			defer os.Remove(imageFile.Name())
+			_, _ = imageFile.Write(tt.image)
+			imageFile.Close()
+			volumeHandler, err := GetVolumeHandler("c", dummyHandler{filePath: imageFile.Name()})
+			if err != nil {
+				t.Fatalf("GetVolumeHandler() error = %v", err)
+			}
+			defer volumeHandler.Handle.Close()
+
+			mftRecord0, usedMirror, err := locateMFT(&volumeHandler)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("locateMFT() error = %v, want %v", err, tt.wantErr)
+			}
+			if usedMirror != tt.wantUsedMirror {
+				t.Errorf("locateMFT() usedMirror = %v, want %v", usedMirror, tt.wantUsedMirror)
+			}
+			if tt.wantErr == nil && (len(mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns) == 0 || volumeHandler.Vbr.MftByteOffset != 4096) {
+				t.Errorf("locateMFT() found the mft at offset %d with data runs %+v", volumeHandler.Vbr.MftByteOffset, mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MFTCache keeps the MFT of every volume a collection walks, so later collections given the same cache, such as those
+// of a long running agent, search it without reading the MFT from disk again. The file records are spooled to a temp
+// file under Directory, the system's temp directory when it's empty, and only their names and the directory tree are
+// held in memory. Search terms for an exact file name are then a lookup, and regular expressions only run over the
+// names rather than every record.
+//
+// Files are created, deleted and moved all the time, so a cached MFT is only used until it's older than MaxAge or the
+// MFT has changed size, after which the next collection reads the MFT again. Collections that copy the $MFT, look
+// for anti-forensics or select files by record number always read it. The temp files hold the records as they are on disk, including small files whose
+// data is resident, so Close the cache once it's no longer needed to remove them.
+type MFTCache struct {
+	MaxAge    time.Duration
+	Directory string
+
+	mutex   sync.Mutex
+	volumes map[string]*cachedMFT
+}
+
+// NewMFTCache returns a cache whose MFTs are read again once they're older than maxAge.
+func NewMFTCache(maxAge time.Duration) *MFTCache {
+	return &MFTCache{MaxAge: maxAge}
+}
+
+// Close removes every cached MFT.
+func (cache *MFTCache) Close() (err error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for volumeLetter, cached := range cache.volumes {
+		if closeErr := cached.close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to remove the cached mft of volume %s: %w", volumeLetter, closeErr)
+		}
+		delete(cache.volumes, volumeLetter)
+	}
+	return
+}
+
+// cachedMFT is one volume's MFT, with its file records in records and an index of their names.
+type cachedMFT struct {
+	mutex         sync.RWMutex
+	closed        bool
+	created       time.Time
+	mftSize       int64
+	recordSize    int64
+	records       *os.File
+	names         map[string][]int64 // lowercase long file names to the offsets of their records in records
+	recordOffsets mftRecordVolumeOffsetTracker
+	directoryTree mft.DirectoryTree
+}
+
+func (cached *cachedMFT) close() (err error) {
+	cached.mutex.Lock()
+	defer cached.mutex.Unlock()
+	if cached.closed {
+		return
+	}
+	cached.closed = true
+	_ = cached.records.Close()
+	err = os.Remove(cached.records.Name())
+	return
+}
+
+// lookup returns the volume's cached MFT if it's still fresh, with its read lock held. The caller has to release it.
+func (cache *MFTCache) lookup(logger Logger, volumeLetter string, mftSize int64) (cached *cachedMFT) {
+	if cache == nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cached = cache.volumes[volumeLetter]
+	if cached == nil {
+		return
+	}
+	if time.Since(cached.created) > cache.MaxAge || cached.mftSize != mftSize {
+		logger.Debugf("The cached MFT of volume %s is out of date, it will be read again.", volumeLetter)
+		_ = cached.close()
+		delete(cache.volumes, volumeLetter)
+		cached = nil
+		return
+	}
+	cached.mutex.RLock()
+	return
+}
+
+// store replaces the volume's cached MFT.
+func (cache *MFTCache) store(volumeLetter string, cached *cachedMFT) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.volumes == nil {
+		cache.volumes = make(map[string]*cachedMFT)
+	}
+	if previous := cache.volumes[volumeLetter]; previous != nil {
+		_ = previous.close()
+	}
+	cache.volumes[volumeLetter] = cached
+}
+
+// search looks up the file records whose names match the search terms and checks them the same way
+// findPossibleMatches checks the records it reads from the volume. The read lock taken by lookup is released.
+func (cached *cachedMFT) search(volumeHandler *VolumeHandler, listOfSearchKeywords listOfSearchTerms) (listOfPossibleMatches possibleMatches, directoryTree mft.DirectoryTree, err error) {
+	defer cached.mutex.RUnlock()
+	search := newMftSearch(volumeHandler, listOfSearchKeywords)
+	offsets := cached.candidates(listOfSearchKeywords)
+	volumeHandler.logger().Debugf("Found %d records in the cached MFT of volume %s with names matching the search terms.", len(offsets), volumeHandler.VolumeLetter)
+	for _, offset := range offsets {
+		buffer := mft.RawMasterFileTableRecord(make([]byte, cached.recordSize))
+		_, err = cached.records.ReadAt(buffer, offset)
+		if err != nil {
+			err = fmt.Errorf("failed to read the cached mft: %w", err)
+			return
+		}
+		rawRecordHeader, _ := buffer.GetRawRecordHeader()
+		recordHeader, _ := rawRecordHeader.Parse()
+		rawAttributes, _ := buffer.GetRawAttributes(recordHeader)
+		fileNameAttributes, standardInformation, dataAttribute, attributeListAttributes, _ := rawAttributes.Parse(volumeHandler.Vbr.BytesPerCluster)
+		fileNameAttributes = withDecodedFileNames(buffer, recordHeader, fileNameAttributes)
+		search.checkFileRecord(buffer, recordHeader, fileNameAttributes, standardInformation, dataAttribute, attributeListAttributes, cached.recordOffsets[recordHeader.RecordNumber])
+	}
+	listOfPossibleMatches = search.resolveAttributeLists(cached.recordOffsets)
+	directoryTree = cached.directoryTree
+	volumeHandler.recordOffsets = cached.recordOffsets
+	return
+}
+
+// candidates returns the offsets of the records with a name matching one of the search terms, in MFT order.
+func (cached *cachedMFT) candidates(listOfSearchKeywords listOfSearchTerms) (offsets []int64) {
+	found := make(map[int64]bool)
+	for _, value := range listOfSearchKeywords {
+		if value.extensions != nil {
+			for name, nameOffsets := range cached.names {
+				if value.sweepsName(name) {
+					for _, offset := range nameOffsets {
+						found[offset] = true
+					}
+				}
+			}
+			continue
+		}
+		if value.fileNameRegex == nil {
+			for _, offset := range cached.names[value.fileNameString] {
+				found[offset] = true
+			}
+			continue
+		}
+		for name, nameOffsets := range cached.names {
+			if value.fileNameRegex.MatchString(name) {
+				for _, offset := range nameOffsets {
+					found[offset] = true
+				}
+			}
+		}
+	}
+	offsets = make([]int64, 0, len(found))
+	for offset := range found {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return
+}
+
+// mftCacheBuilder fills a cachedMFT as findPossibleMatches walks the MFT. Like the mftInspector its methods do nothing
+// when it's nil.
+type mftCacheBuilder struct {
+	cache        *MFTCache
+	volumeLetter string
+	logger       Logger
+	cached       *cachedMFT
+	nextOffset   int64
+	finished     bool
+}
+
+// newBuilder starts caching a volume's MFT, or returns nil when there's no cache.
+func (cache *MFTCache) newBuilder(logger Logger, volumeLetter string, mftSize int64, recordSize int64) (builder *mftCacheBuilder, err error) {
+	if cache == nil {
+		return
+	}
+	records, err := ioutil.TempFile(cache.Directory, "gofor-mft-")
+	if err != nil {
+		err = fmt.Errorf("failed to create a temp file to cache the mft of volume %s: %w", volumeLetter, err)
+		return
+	}
+	builder = &mftCacheBuilder{
+		cache:        cache,
+		volumeLetter: volumeLetter,
+		logger:       logger,
+		cached: &cachedMFT{
+			created:    time.Now(),
+			mftSize:    mftSize,
+			recordSize: recordSize,
+			records:    records,
+			names:      make(map[string][]int64),
+		},
+	}
+	return
+}
+
+// addFile caches a file record under each of its long names.
+func (builder *mftCacheBuilder) addFile(buffer mft.RawMasterFileTableRecord, fileNameAttributes mft.FileNameAttributes) (err error) {
+	if builder == nil {
+		return
+	}
+	names := longFileNames(fileNameAttributes)
+	if len(names) == 0 {
+		return
+	}
+	_, err = builder.cached.records.Write(buffer)
+	if err != nil {
+		return
+	}
+	for _, attribute := range names {
+		name := strings.ToLower(attribute.FileName)
+		builder.cached.names[name] = append(builder.cached.names[name], builder.nextOffset)
+	}
+	builder.nextOffset += int64(len(buffer))
+	return
+}
+
+// finish stores the cached MFT once the whole MFT has been walked.
+func (builder *mftCacheBuilder) finish(recordOffsets mftRecordVolumeOffsetTracker, directoryTree mft.DirectoryTree) {
+	if builder == nil {
+		return
+	}
+	builder.cached.recordOffsets = recordOffsets
+	builder.cached.directoryTree = directoryTree
+	builder.finished = true
+	builder.cache.store(builder.volumeLetter, builder.cached)
+	builder.logger.Debugf("Cached the MFT of volume %s with %d file names.", builder.volumeLetter, len(builder.cached.names))
+}
+
+// discard removes the temp file of an MFT walk that didn't finish.
+func (builder *mftCacheBuilder) discard() {
+	if builder == nil || builder.finished {
+		return
+	}
+	_ = builder.cached.close()
+}
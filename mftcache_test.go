@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// walkTestMFT searches the dummy volume's MFT, caching it in cache.
+func walkTestMFT(t *testing.T, cache *MFTCache, listOfSearchKeywords listOfSearchTerms) (volumeHandler VolumeHandler, listOfPossibleMatches possibleMatches) {
+	volumeHandler, err := GetVolumeHandler("c", dummyHandler{filePath: `test\testdata\dummyntfs`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mftRecord0, _ := parseMFTRecord0(&volumeHandler)
+	_, _ = volumeHandler.Handle.Seek(volumeHandler.Vbr.MftByteOffset, 0)
+	mftFile := foundFile{dataRuns: mftRecord0.DataAttribute.NonResidentDataAttribute.DataRuns, fullPath: "$mft"}
+	volumeHandler.mftReader = rawFileReader(&volumeHandler, mftFile)
+	volumeHandler.cacheBuilder, err = cache.newBuilder(loggerOrDefault(nil), "c", mftFile.totalSize(), volumeHandler.Vbr.MftRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer volumeHandler.cacheBuilder.discard()
+	listOfPossibleMatches, _, err = findPossibleMatches(&volumeHandler, listOfSearchKeywords)
+	if err != nil {
+		t.Fatalf("findPossibleMatches() error = %v", err)
+	}
+	return
+}
+
+func TestMFTCache(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-mftcache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	cache := NewMFTCache(time.Hour)
+	cache.Directory = directory
+
+	volumeHandler, _ := walkTestMFT(t, cache, listOfSearchTerms{{fileNameString: "$mftmirr"}})
+	defer volumeHandler.Handle.Close()
+	mftSize := cache.volumes["c"].mftSize
+
+	tests := []struct {
+		name                 string
+		listOfSearchKeywords listOfSearchTerms
+	}{
+		{
+			name:                 "exact names",
+			listOfSearchKeywords: listOfSearchTerms{{fileNameString: "$mftmirr"}, {fileNameString: "software"}},
+		},
+		{
+			name:                 "regex",
+			listOfSearchKeywords: listOfSearchTerms{{fileNameRegex: regexp.MustCompile(`^(\$logfile|soft.*)$`)}},
+		},
+		{
+			name:                 "no matches",
+			listOfSearchKeywords: listOfSearchTerms{{fileNameString: "ntuser.dat"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otherVolumeHandler, want := walkTestMFT(t, nil, tt.listOfSearchKeywords)
+			defer otherVolumeHandler.Handle.Close()
+
+			cached := cache.lookup(loggerOrDefault(nil), "c", mftSize)
+			if cached == nil {
+				t.Fatal("MFTCache.lookup() didn't find the cached MFT")
+			}
+			got, gotDirectoryTree, err := cached.search(&volumeHandler, tt.listOfSearchKeywords)
+			if err != nil {
+				t.Fatalf("cachedMFT.search() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("cachedMFT.search() = %+v, want what reading the MFT found, %+v", got, want)
+			}
+			if len(gotDirectoryTree) == 0 {
+				t.Error("cachedMFT.search() returned no directory tree")
+			}
+		})
+	}
+
+	// A changed MFT size means the MFT has to be read again
+	if cache.lookup(loggerOrDefault(nil), "c", mftSize+1024) != nil {
+		t.Error("MFTCache.lookup() returned a cached MFT of a different size")
+	}
+	if files, _ := ioutil.ReadDir(directory); len(files) != 0 {
+		t.Errorf("MFTCache.lookup() left %d temp files behind for an out of date MFT", len(files))
+	}
+
+	refresh := func() {
+		refreshedVolumeHandler, _ := walkTestMFT(t, cache, listOfSearchTerms{{fileNameString: "$mftmirr"}})
+		refreshedVolumeHandler.Handle.Close()
+	}
+	refresh()
+	cache.MaxAge = 0
+	if cache.lookup(loggerOrDefault(nil), "c", mftSize) != nil {
+		t.Error("MFTCache.lookup() returned a cached MFT older than MaxAge")
+	}
+
+	refresh()
+	if err = cache.Close(); err != nil {
+		t.Errorf("MFTCache.Close() error = %v", err)
+	}
+	if files, _ := ioutil.ReadDir(directory); len(files) != 0 {
+		t.Errorf("MFTCache.Close() left %d temp files behind", len(files))
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// errResultWriterStopped is what's left of a file for a result writer that stopped part way through it.
+var errResultWriterStopped = errors.New("the result writer stopped")
+
+// MultiResultWriter fans the files of a collection out to several result writers at once, such as a local zip, an
+// upload and a hash manifest, reading each file only once. A writer that fails is left out of the rest of the
+// collection while the others carry on, and its error is returned once they're done. It only fails right away when
+// every writer has. A file only counts as Written once every writer it went to has written it.
+type MultiResultWriter struct {
+	Writers []ResultWriter
+	Logger  Logger // see ZipResultWriter
+}
+
+// fanOutWriter is one of the result writers of a MultiResultWriter.
+type fanOutWriter struct {
+	index   int
+	files   chan CollectedFile
+	stopped chan struct{} // closed when the writer returns
+	err     error
+
+	mutex   sync.Mutex
+	current *io.PipeReader // the file the writer is reading
+	done    bool
+}
+
+// start runs the writer. When it returns, whatever it was reading is closed so the fan out isn't left blocked on it.
+func (writer *fanOutWriter) start(ctx context.Context, logger Logger, resultWriter ResultWriter, waitForWriters *sync.WaitGroup) {
+	go func() {
+		writer.err = resultWriter.ResultWriter(ctx, writer.files, waitForWriters)
+		if writer.err != nil && ctx.Err() == nil {
+			logger.Errorf("Result writer %d failed, carrying on with the others: %v", writer.index, writer.err)
+		}
+		writer.mutex.Lock()
+		writer.done = true
+		if writer.current != nil {
+			_ = writer.current.CloseWithError(errResultWriterStopped)
+		}
+		writer.mutex.Unlock()
+		close(writer.stopped)
+	}()
+}
+
+// send hands the writer its copy of a file, returning the end of the pipe to write the file to, or nil if the writer
+// has stopped.
+func (writer *fanOutWriter) send(file CollectedFile) (pipeWriter *io.PipeWriter) {
+	pipeReader, pipeWriter := io.Pipe()
+	writer.mutex.Lock()
+	if writer.done {
+		writer.mutex.Unlock()
+		return nil
+	}
+	writer.current = pipeReader
+	writer.mutex.Unlock()
+	file.reader = pipeReader
+	select {
+	case writer.files <- file:
+		return pipeWriter
+	case <-writer.stopped:
+		return nil
+	}
+}
+
+// ResultWriter tees each file to every writer. If ctx is cancelled the writers close out their outputs themselves.
+func (multiResultWriter *MultiResultWriter) ResultWriter(ctx context.Context, fileReaders chan CollectedFile, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	logger := loggerOrDefault(multiResultWriter.Logger)
+	if len(multiResultWriter.Writers) == 0 {
+		err = errors.New("MultiResultWriter has no result writers")
+		return
+	}
+
+	writers := make([]*fanOutWriter, len(multiResultWriter.Writers))
+	waitForWriters := sync.WaitGroup{}
+	waitForWriters.Add(len(writers))
+	for index, resultWriter := range multiResultWriter.Writers {
+		writers[index] = &fanOutWriter{index: index, files: make(chan CollectedFile), stopped: make(chan struct{})}
+		writers[index].start(ctx, logger, resultWriter, &waitForWriters)
+	}
+	defer func() {
+		for _, writer := range writers {
+			close(writer.files)
+		}
+		waitForWriters.Wait()
+		failed := 0
+		var firstErr error
+		for _, writer := range writers {
+			<-writer.stopped
+			if writer.err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("result writer %d failed: %w", writer.index, writer.err)
+				}
+			}
+		}
+		if err == nil && firstErr != nil {
+			err = fmt.Errorf("%d of %d result writers failed, the first: %w", failed, len(writers), firstErr)
+		}
+	}()
+
+	buffer := make([]byte, 1024*1024)
+	for {
+		var file CollectedFile
+		var open bool
+		select {
+		case file, open = <-fileReaders:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		if !open {
+			return
+		}
+
+		countdown := &writtenCountdown{remaining: len(writers), written: file.written}
+		file.written = countdown.done
+		pipes := make(map[*fanOutWriter]*io.PipeWriter)
+		for _, writer := range writers {
+			if pipeWriter := writer.send(file); pipeWriter != nil {
+				pipes[writer] = pipeWriter
+			}
+		}
+		if len(pipes) == 0 {
+			err = errors.New("every result writer has failed")
+			return
+		}
+		countdown.add(len(pipes) - len(writers))
+
+		var readErr error
+		for readErr == nil {
+			var numberOfBytesRead int
+			numberOfBytesRead, readErr = file.reader.Read(buffer)
+			if numberOfBytesRead == 0 {
+				continue
+			}
+			for writer, pipeWriter := range pipes {
+				if _, writeErr := pipeWriter.Write(buffer[:numberOfBytesRead]); writeErr != nil {
+					logger.Debugf("Result writer %d stopped reading '%s': %v", writer.index, file.fullPath, writeErr)
+					delete(pipes, writer)
+				}
+			}
+		}
+		if readErr == io.EOF {
+			readErr = nil
+		}
+		for _, pipeWriter := range pipes {
+			_ = pipeWriter.CloseWithError(readErr)
+		}
+	}
+}
+
+// writtenCountdown tells the collection a file was written once every writer it was sent to has written it.
+type writtenCountdown struct {
+	mutex     sync.Mutex
+	remaining int
+	written   func()
+}
+
+func (countdown *writtenCountdown) add(delta int) {
+	countdown.mutex.Lock()
+	countdown.remaining += delta
+	finished := countdown.remaining == 0 && delta != 0
+	countdown.mutex.Unlock()
+	if finished && countdown.written != nil {
+		countdown.written()
+	}
+}
+
+func (countdown *writtenCountdown) done() {
+	countdown.add(-1)
+}
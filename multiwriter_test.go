@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMultiResultWriter_ResultWriter(t *testing.T) {
+	// Bigger than the fan out's buffer so it's handed over in several reads
+	big := bytes.Repeat([]byte("0123456789abcdef"), 200000)
+	bigHash := sha256.Sum256(big)
+	want := hex.EncodeToString(bigHash[:]) + "  c/$mft\n" +
+		"7323e77d1f51ee69b36ef874d2ee6f85f322e12a46f6281f9bd29c8dbb829460  c/windows/system32/config/sam\n"
+
+	tests := []struct {
+		name    string
+		writers int
+		failing bool
+		wantErr string
+	}{
+		{name: "two manifests", writers: 2},
+		{name: "one fails", writers: 2, failing: true, wantErr: "1 of 3 result writers failed"},
+		{name: "all fail", failing: true, wantErr: "every result writer has failed"},
+		{name: "no writers", wantErr: "has no result writers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outputs []*closingBuffer
+			multiResultWriter := MultiResultWriter{}
+			if tt.failing {
+				multiResultWriter.Writers = append(multiResultWriter.Writers, failingResultWriter{})
+			}
+			for index := 0; index < tt.writers; index++ {
+				output := new(closingBuffer)
+				outputs = append(outputs, output)
+				multiResultWriter.Writers = append(multiResultWriter.Writers, &ManifestResultWriter{Output: output})
+			}
+			fileReaders := make(chan CollectedFile, 2)
+			fileReaders <- fileReader{fullPath: `c:\$mft`, reader: bytes.NewReader(big)}
+			fileReaders <- fileReader{fullPath: `c:\windows\system32\config\sam`, reader: strings.NewReader("regf")}
+			close(fileReaders)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			err := multiResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("MultiResultWriter.ResultWriter() error = %v, want %q", err, tt.wantErr)
+			}
+			for index, output := range outputs {
+				if got := output.String(); got != want || !output.closed {
+					t.Errorf("result writer %d wrote %q (closed %v), want %q", index, got, output.closed, want)
+				}
+			}
+		})
+	}
+}
+
+// drainingResultWriter reads every file without saying it was written, as an upload does.
+type drainingResultWriter struct{}
+
+func (drainingResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	for file := range fileReaders {
+		_, _ = io.Copy(ioutil.Discard, file.reader)
+	}
+	return
+}
+
+func TestMultiResultWriter_written(t *testing.T) {
+	tests := []struct {
+		name        string
+		writers     []ResultWriter
+		wantWritten bool
+	}{
+		{name: "every writer wrote it", writers: []ResultWriter{&ManifestResultWriter{Output: new(closingBuffer)}, &ManifestResultWriter{Output: new(closingBuffer)}}, wantWritten: true},
+		{name: "one writer can't tell", writers: []ResultWriter{&ManifestResultWriter{Output: new(closingBuffer)}, drainingResultWriter{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			written := false
+			fileReaders := make(chan CollectedFile, 1)
+			fileReaders <- fileReader{fullPath: `c:\windows\system32\config\sam`, reader: strings.NewReader("regf"), written: func() { written = true }}
+			close(fileReaders)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			multiResultWriter := MultiResultWriter{Writers: tt.writers}
+			_ = multiResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+			if written != tt.wantWritten {
+				t.Errorf("the file was written: %v, want %v", written, tt.wantWritten)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"unsafe"
+)
+
+var (
+	procGetIpNetTable        = iphlpapi.NewProc("GetIpNetTable")
+	dnsapi                   = windows.NewLazySystemDLL("dnsapi.dll")
+	procDnsGetCacheDataTable = dnsapi.NewProc("DnsGetCacheDataTable")
+	procDnsQueryW            = dnsapi.NewProc("DnsQuery_W")
+	procDnsRecordListFree    = dnsapi.NewProc("DnsRecordListFree")
+	procDnsFree              = dnsapi.NewProc("DnsFree")
+)
+
+// ARPEntry is an entry of the IPv4 ARP table, which links an address on the local network to a MAC address.
+type ARPEntry struct {
+	InterfaceIndex  uint32 `json:"interface_index"`
+	IPAddress       string `json:"ip_address"`
+	PhysicalAddress string `json:"physical_address,omitempty"`
+	Type            string `json:"type"` // dynamic, static, invalid or other
+}
+
+// DNSCacheEntry is a name the DNS client has cached, with the records it resolved to.
+type DNSCacheEntry struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Records []DNSRecord `json:"records,omitempty"`
+	Error   string      `json:"error,omitempty"` // why the records are missing, e.g. they expired since the table was read
+}
+
+// DNSRecord is one of the records a cached name resolved to.
+type DNSRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data,omitempty"` // the address or name the record points to, for the types that have one
+}
+
+// NetworkRegistryKeys cover the firewall policy and its rules, the networks the host has connected to with when it
+// first and last did, and the configuration of each network interface.
+var NetworkRegistryKeys = []RegistryKey{
+	{Name: "firewall_policy", Path: `HKLM\SYSTEM\CurrentControlSet\Services\SharedAccess\Parameters\FirewallPolicy`, Depth: 2},
+	{Name: "network_list", Path: `HKLM\SOFTWARE\Microsoft\Windows NT\CurrentVersion\NetworkList`, Depth: 3},
+	{Name: "tcpip_interfaces", Path: `HKLM\SYSTEM\CurrentControlSet\Services\Tcpip\Parameters\Interfaces`, Depth: 1},
+}
+
+// NetworkAcquirers returns Acquirers that capture the host's network state into the output under network/: the DNS
+// client cache with what each name resolved to, the ARP table, and the firewall policy as netsh advfirewall exports it,
+// which can be imported into another host to look at. The registry keys behind them are in NetworkRegistryKeys.
+func NetworkAcquirers() []Acquirer {
+	return []Acquirer{
+		&volatileAcquirer{name: "network/dns_cache.json", gather: func(context.Context, Logger) (interface{}, error) { return listDNSCache() }},
+		&volatileAcquirer{name: "network/arp_table.json", gather: func(context.Context, Logger) (interface{}, error) { return listARPTable() }},
+		&firewallPolicyAcquirer{},
+	}
+}
+
+// listARPTable returns the IPv4 ARP table.
+func listARPTable() (entries []ARPEntry, err error) {
+	size := uint32(16 * 1024)
+	for {
+		data := make([]byte, size)
+		result, _, _ := procGetIpNetTable.Call(uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&size)), 1)
+		if result == errorInsufficient {
+			continue
+		}
+		if result == uintptr(windows.ERROR_NO_DATA) {
+			return
+		}
+		if result != 0 {
+			err = fmt.Errorf("failed to get the ARP table: %w", windows.Errno(result))
+			return
+		}
+		entries = parseARPTable(data[:size])
+		return
+	}
+}
+
+var arpTypes = map[uint32]string{1: "other", 2: "invalid", 3: "dynamic", 4: "static"}
+
+// parseARPTable parses a MIB_IPNETTABLE. The address is in network byte order.
+func parseARPTable(data []byte) (entries []ARPEntry) {
+	const rowSize = 24
+	if len(data) < 4 {
+		return
+	}
+	numberOfEntries := int(binary.LittleEndian.Uint32(data))
+	for index := 0; index < numberOfEntries; index++ {
+		offset := 4 + index*rowSize
+		if offset+rowSize > len(data) {
+			break
+		}
+		row := data[offset : offset+rowSize]
+		entry := ARPEntry{
+			InterfaceIndex: binary.LittleEndian.Uint32(row),
+			IPAddress:      net.IP(row[16:20]).String(),
+			Type:           arpTypes[binary.LittleEndian.Uint32(row[20:])],
+		}
+		if length := binary.LittleEndian.Uint32(row[4:]); length > 0 && length <= 8 {
+			entry.PhysicalAddress = net.HardwareAddr(row[8 : 8+length]).String()
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// dnsCacheEntry is a DNS_CACHE_ENTRY from DnsGetCacheDataTable.
+type dnsCacheEntry struct {
+	next       *dnsCacheEntry
+	name       *uint16
+	recordType uint16
+	dataLength uint16
+	flags      uint32
+}
+
+// dnsRecordHeader is the start of a DNS_RECORD, followed by its data.
+type dnsRecordHeader struct {
+	next       *dnsRecordHeader
+	name       *uint16
+	recordType uint16
+	dataLength uint16
+	flags      uint32
+	ttl        uint32
+	reserved   uint32
+	data       [16]byte
+}
+
+const (
+	dnsQueryNoWireQuery = 0x10
+	dnsFreeFlat         = 0
+	dnsFreeRecordList   = 1
+)
+
+var dnsTypes = map[uint16]string{
+	1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT", 28: "AAAA", 33: "SRV", 65: "HTTPS",
+}
+
+// listDNSCache returns the names in the DNS client cache. DnsGetCacheDataTable only lists the names, so each is looked
+// up in the cache again, without going to the wire, for its records.
+func listDNSCache() (entries []DNSCacheEntry, err error) {
+	var table *dnsCacheEntry
+	if result, _, callErr := procDnsGetCacheDataTable.Call(uintptr(unsafe.Pointer(&table))); result == 0 {
+		err = fmt.Errorf("DnsGetCacheDataTable() failed: %w", callErr)
+		return
+	}
+	for entry := table; entry != nil; {
+		cached := DNSCacheEntry{Name: utf16PointerToString(entry.name), Type: dnsTypeName(entry.recordType)}
+		cached.Records, err = cachedDNSRecords(entry.name, entry.recordType)
+		if err != nil {
+			cached.Error = err.Error()
+			err = nil
+		}
+		entries = append(entries, cached)
+		next := entry.next
+		_, _, _ = procDnsFree.Call(uintptr(unsafe.Pointer(entry.name)), dnsFreeFlat)
+		_, _, _ = procDnsFree.Call(uintptr(unsafe.Pointer(entry)), dnsFreeFlat)
+		entry = next
+	}
+	return
+}
+
+// cachedDNSRecords looks a name up in the DNS client cache only.
+func cachedDNSRecords(name *uint16, recordType uint16) (records []DNSRecord, err error) {
+	var results *dnsRecordHeader
+	result, _, _ := procDnsQueryW.Call(uintptr(unsafe.Pointer(name)), uintptr(recordType), dnsQueryNoWireQuery, 0, uintptr(unsafe.Pointer(&results)), 0)
+	if result != 0 {
+		err = fmt.Errorf("DnsQuery_W() failed: %w", windows.Errno(result))
+		return
+	}
+	defer procDnsRecordListFree.Call(uintptr(unsafe.Pointer(results)), dnsFreeRecordList)
+	for record := results; record != nil; record = record.next {
+		records = append(records, DNSRecord{
+			Name: utf16PointerToString(record.name),
+			Type: dnsTypeName(record.recordType),
+			TTL:  record.ttl,
+			Data: dnsRecordData(record),
+		})
+	}
+	return
+}
+
+// dnsRecordData is what a record points to: the address of an A or AAAA record, or the name of a CNAME, PTR, NS, MX
+// or SRV record.
+func dnsRecordData(record *dnsRecordHeader) string {
+	switch record.recordType {
+	case 1:
+		return net.IP(record.data[:4]).String()
+	case 28:
+		return net.IP(record.data[:16]).String()
+	case 2, 5, 12, 15, 33:
+		// DNS_PTR_DATA, DNS_MX_DATA and DNS_SRV_DATA all start with the name
+		return utf16PointerToString(*(**uint16)(unsafe.Pointer(&record.data[0])))
+	}
+	return ""
+}
+
+func dnsTypeName(recordType uint16) string {
+	if name, ok := dnsTypes[recordType]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", recordType)
+}
+
+// firewallPolicyAcquirer exports the firewall policy with netsh advfirewall export, as a .wfw file.
+type firewallPolicyAcquirer struct{}
+
+func (acquirer *firewallPolicyAcquirer) Name() string {
+	return "network/firewall_policy.wfw"
+}
+
+// Acquire exports the policy to a temp file, which is removed once it has been read.
+func (acquirer *firewallPolicyAcquirer) Acquire(ctx context.Context, logger Logger) (reader io.ReadCloser, size int64, err error) {
+	tempFile, err := ioutil.TempFile("", "gofor-firewall-*.wfw")
+	if err != nil {
+		return
+	}
+	// netsh won't export over an existing file, so only the name of the temp file is used
+	tempFileName := tempFile.Name()
+	tempFile.Close()
+	os.Remove(tempFileName)
+
+	err = exportFirewallPolicy(ctx, tempFileName)
+	if err != nil {
+		os.Remove(tempFileName)
+		err = fmt.Errorf("failed to export the firewall policy: %w", err)
+		return
+	}
+	tempFile, err = os.Open(tempFileName)
+	if err != nil {
+		os.Remove(tempFileName)
+		return
+	}
+	if info, statErr := tempFile.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	reader = &spooledFile{reader: tempFile, tempFile: tempFile}
+	return
+}
+
+// exportFirewallPolicy runs netsh advfirewall export. It's a variable so tests don't depend on the host's firewall.
+var exportFirewallPolicy = func(ctx context.Context, targetPath string) (err error) {
+	output, err := exec.CommandContext(ctx, "netsh", "advfirewall", "export", targetPath).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return
+}
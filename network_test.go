@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+	"unsafe"
+)
+
+func Test_parseARPTable(t *testing.T) {
+	row := func(index uint32, mac string, ip net.IP, arpType uint32) []byte {
+		data := make([]byte, 24)
+		binary.LittleEndian.PutUint32(data, index)
+		hardware, _ := net.ParseMAC(mac)
+		binary.LittleEndian.PutUint32(data[4:], uint32(len(hardware)))
+		copy(data[8:16], hardware)
+		copy(data[16:20], ip.To4())
+		binary.LittleEndian.PutUint32(data[20:], arpType)
+		return data
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, 3)
+	data = append(data, row(12, "00:15:5d:01:02:03", net.IPv4(10, 0, 0, 1), 3)...)
+	data = append(data, row(12, "", net.IPv4(10, 0, 0, 9), 2)...)
+	data = append(data, row(7, "ff:ff:ff:ff:ff:ff", net.IPv4(255, 255, 255, 255), 4)...)
+
+	want := []ARPEntry{
+		{InterfaceIndex: 12, IPAddress: "10.0.0.1", PhysicalAddress: "00:15:5d:01:02:03", Type: "dynamic"},
+		{InterfaceIndex: 12, IPAddress: "10.0.0.9", Type: "invalid"},
+		{InterfaceIndex: 7, IPAddress: "255.255.255.255", PhysicalAddress: "ff:ff:ff:ff:ff:ff", Type: "static"},
+	}
+	if got := parseARPTable(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseARPTable() = %+v, want %+v", got, want)
+	}
+	if got := parseARPTable(data[:40]); len(got) != 1 {
+		t.Errorf("parseARPTable() of a truncated table = %+v, want only the whole row", got)
+	}
+}
+
+func Test_dnsRecordData(t *testing.T) {
+	name := append(utf16.Encode([]rune("edge.example.com")), 0)
+	withName := dnsRecordHeader{recordType: 5}
+	*(**uint16)(unsafe.Pointer(&withName.data[0])) = &name[0]
+	tests := []struct {
+		name   string
+		record dnsRecordHeader
+		want   string
+	}{
+		{name: "A", record: dnsRecordHeader{recordType: 1, data: [16]byte{93, 184, 216, 34}}, want: "93.184.216.34"},
+		{name: "AAAA", record: dnsRecordHeader{recordType: 28, data: [16]byte{0x26, 0x06, 0x28, 0x00, 0x02, 0x20, 0, 1, 0x2, 0x48, 0x18, 0x93, 0x25, 0xc8, 0x19, 0x46}}, want: "2606:2800:220:1:248:1893:25c8:1946"},
+		{name: "CNAME", record: withName, want: "edge.example.com"},
+		{name: "TXT", record: dnsRecordHeader{recordType: 16}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dnsRecordData(&tt.record); got != tt.want {
+				t.Errorf("dnsRecordData() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := dnsTypeName(99); got != "TYPE99" {
+		t.Errorf("dnsTypeName(99) = %q, want TYPE99", got)
+	}
+}
+
+func Test_firewallPolicyAcquirer(t *testing.T) {
+	defer func(original func(context.Context, string) error) { exportFirewallPolicy = original }(exportFirewallPolicy)
+	var exported string
+	exportFirewallPolicy = func(ctx context.Context, targetPath string) error {
+		exported = targetPath
+		return ioutil.WriteFile(targetPath, []byte("policy"), 0600)
+	}
+	acquirer := &firewallPolicyAcquirer{}
+	reader, size, err := acquirer.Acquire(context.Background(), loggerOrDefault(nil))
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(data) != "policy" || size != int64(len(data)) {
+		t.Errorf("Acquire() = %q, %d, want the exported policy", data, size)
+	}
+	if _, err := os.Stat(exported); !os.IsNotExist(err) {
+		t.Errorf("the export %s wasn't removed once read", exported)
+	}
+
+	exportFirewallPolicy = func(ctx context.Context, targetPath string) error {
+		return errors.New("access denied")
+	}
+	if _, _, err := acquirer.Acquire(context.Background(), loggerOrDefault(nil)); err == nil {
+		t.Error("Acquire() error = nil when netsh failed")
+	}
+}
+
+func TestNetworkAcquirers(t *testing.T) {
+	var names []string
+	for _, acquirer := range NetworkAcquirers() {
+		names = append(names, acquirer.Name())
+	}
+	want := []string{"network/dns_cache.json", "network/arp_table.json", "network/firewall_policy.wfw"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("NetworkAcquirers() = %v, want %v", names, want)
+	}
+	if _, err := RegistryAcquirers(append(append([]RegistryKey(nil), DefaultRegistryKeys...), NetworkRegistryKeys...)); err != nil {
+		t.Errorf("NetworkRegistryKeys can't be read with the default keys: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io"
+	"sort"
+)
+
+// dataExtent is a data run along with where in the file it goes.
+type dataExtent struct {
+	vcn      int64 // the file's first cluster in the run
+	clusters int64
+	lcn      int64 // the volume's first cluster in the run, unused when the run is sparse
+	sparse   bool
+}
+
+// ntfsStream is a non-resident $DATA attribute that is sparse or compressed, which the data runs from the MFT parser
+// can't describe: they give sparse runs the offset of the run before them and have nothing on compression.
+type ntfsStream struct {
+	extents         []dataExtent
+	compressed      bool
+	compressionUnit int64 // clusters per compression unit
+	size            int64
+	initializedSize int64 // everything past this reads as zeros
+}
+
+// needsStreamReader reports whether the stream has to be read with an ntfsStreamReader rather than a DataRunsReader.
+func (stream ntfsStream) needsStreamReader() bool {
+	if stream.compressed {
+		return true
+	}
+	for _, extent := range stream.extents {
+		if extent.sparse {
+			return true
+		}
+	}
+	return false
+}
+
+// merge adds the data runs of an attribute from another MFT record, for files big or fragmented enough to need an
+// attribute list. Only the attribute starting at the beginning of the file has the sizes in it.
+func (stream *ntfsStream) merge(other ntfsStream, hasSizes bool) {
+	stream.extents = append(stream.extents, other.extents...)
+	sort.Slice(stream.extents, func(i, j int) bool { return stream.extents[i].vcn < stream.extents[j].vcn })
+	if other.compressed {
+		stream.compressed = true
+		stream.compressionUnit = other.compressionUnit
+	}
+	if hasSizes {
+		stream.size = other.size
+		stream.initializedSize = other.initializedSize
+	}
+}
+
+// parseNonResidentData parses the unnamed $DATA attribute of a record when it is non-resident. hasSizes is false for
+// the attributes in extension records that continue a file part way through.
+func parseNonResidentData(record mft.RawMasterFileTableRecord, attributesOffset uint16) (stream ntfsStream, hasSizes bool, found bool) {
+	return parseNamedNonResidentData(record, attributesOffset, "")
+}
+
+// parseNamedNonResidentData parses a record's $DATA attribute with the given name when it is non-resident, such as the
+// $SDS stream of $Secure.
+func parseNamedNonResidentData(record mft.RawMasterFileTableRecord, attributesOffset uint16, name string) (stream ntfsStream, hasSizes bool, found bool) {
+	const (
+		codeData                = 0x80
+		offsetNonResFlag        = 0x08
+		offsetFlags             = 0x0c
+		offsetStartingVcn       = 0x10
+		offsetRunListOffset     = 0x20
+		offsetCompressionUnit   = 0x22
+		offsetRealSize          = 0x30
+		offsetInitializedSize   = 0x38
+		headerLength            = 0x40
+		flagCompressed          = 0x0001
+		compressionUnitDisabled = 0
+	)
+	attribute, found := namedAttribute(record, attributesOffset, codeData, name)
+	if !found || attribute[offsetNonResFlag] == 0 || len(attribute) < headerLength {
+		found = false
+		return
+	}
+	startingVcn := int64(binary.LittleEndian.Uint64(attribute[offsetStartingVcn:]))
+	compressionUnit := binary.LittleEndian.Uint16(attribute[offsetCompressionUnit:])
+	if binary.LittleEndian.Uint16(attribute[offsetFlags:])&flagCompressed != 0 && compressionUnit != compressionUnitDisabled {
+		stream.compressed = true
+		stream.compressionUnit = 1 << compressionUnit
+	}
+	hasSizes = startingVcn == 0
+	if hasSizes {
+		stream.size = int64(binary.LittleEndian.Uint64(attribute[offsetRealSize:]))
+		stream.initializedSize = int64(binary.LittleEndian.Uint64(attribute[offsetInitializedSize:]))
+	}
+	runListOffset := int(binary.LittleEndian.Uint16(attribute[offsetRunListOffset:]))
+	if runListOffset > len(attribute) {
+		found = false
+		return
+	}
+	stream.extents, found = parseRunList(attribute[runListOffset:], startingVcn)
+	return
+}
+
+// parseRunList decodes a run list. Each run starts with a byte whose low nibble is the size of the run's length and
+// high nibble the size of its offset, which is signed and relative to the run before it. A run without an offset is
+// sparse.
+func parseRunList(runList []byte, startingVcn int64) (extents []dataExtent, ok bool) {
+	vcn := startingVcn
+	lcn := int64(0)
+	for index := 0; index < len(runList) && runList[index] != 0; {
+		lengthSize := int(runList[index] & 0x0f)
+		offsetSize := int(runList[index] >> 4)
+		index++
+		if lengthSize == 0 || lengthSize > 8 || offsetSize > 8 || index+lengthSize+offsetSize > len(runList) {
+			return nil, false
+		}
+		clusters := littleEndianSigned(runList[index:index+lengthSize], false)
+		index += lengthSize
+		extent := dataExtent{vcn: vcn, clusters: clusters, sparse: offsetSize == 0}
+		if !extent.sparse {
+			lcn += littleEndianSigned(runList[index:index+offsetSize], true)
+			extent.lcn = lcn
+		}
+		index += offsetSize
+		extents = append(extents, extent)
+		vcn += clusters
+	}
+	return extents, true
+}
+
+func littleEndianSigned(data []byte, signed bool) (value int64) {
+	for i := len(data) - 1; i >= 0; i-- {
+		value = value<<8 | int64(data[i])
+	}
+	if signed && len(data) < 8 && data[len(data)-1]&0x80 != 0 {
+		value -= 1 << (uint(len(data)) * 8)
+	}
+	return
+}
+
+// ntfsStreamReader reads a sparse or compressed file one compression unit at a time. Sparse clusters read as zeros. A
+// unit of a compressed file whose clusters are all allocated is stored as it is, one that is partly sparse holds LZNT1
+// compressed data in its allocated clusters, and one that is entirely sparse is zeros.
+type ntfsStreamReader struct {
+	volume          io.ReadSeeker
+	bytesPerCluster int64
+	stream          ntfsStream
+	unit            int64 // the next compression unit to read
+	pending         []byte
+	position        int64
+}
+
+func newNtfsStreamReader(volume io.ReadSeeker, bytesPerCluster int64, stream ntfsStream) *ntfsStreamReader {
+	if !stream.compressed {
+		// Uncompressed files are read in units too, just so there is a limit on how much is read at once
+		stream.compressionUnit = 16
+	}
+	return &ntfsStreamReader{volume: volume, bytesPerCluster: bytesPerCluster, stream: stream}
+}
+
+func (reader *ntfsStreamReader) Read(buffer []byte) (numberOfBytesRead int, err error) {
+	if len(reader.pending) == 0 {
+		if reader.position >= reader.stream.size {
+			err = io.EOF
+			return
+		}
+		reader.pending, err = reader.readUnit(reader.unit)
+		if err != nil {
+			return
+		}
+		reader.unit++
+	}
+	numberOfBytesRead = copy(buffer, reader.pending)
+	reader.pending = reader.pending[numberOfBytesRead:]
+	reader.position += int64(numberOfBytesRead)
+	return
+}
+
+// readUnit returns a unit's data, cut short at the end of the file.
+func (reader *ntfsStreamReader) readUnit(unit int64) (data []byte, err error) {
+	unitSize := reader.stream.compressionUnit * reader.bytesPerCluster
+	start := unit * unitSize
+	firstVcn := unit * reader.stream.compressionUnit
+	pieces := reader.piecesOf(firstVcn, reader.stream.compressionUnit)
+
+	allocated := int64(0)
+	for _, piece := range pieces {
+		if !piece.sparse {
+			allocated += piece.clusters
+		}
+	}
+	switch {
+	case allocated == 0:
+		data = make([]byte, unitSize)
+	case reader.stream.compressed && allocated < reader.stream.compressionUnit:
+		var compressed []byte
+		compressed, err = reader.readPieces(pieces)
+		if err != nil {
+			return
+		}
+		data, err = decompressLZNT1(compressed, int(unitSize))
+		if err != nil {
+			err = fmt.Errorf("failed to decompress the data at offset %d: %w", start, err)
+			return
+		}
+	default:
+		data, err = reader.readPieces(pieces)
+		if err != nil {
+			return
+		}
+	}
+
+	if start+int64(len(data)) > reader.stream.size {
+		data = data[:reader.stream.size-start]
+	}
+	if reader.stream.initializedSize < reader.stream.size && start+int64(len(data)) > reader.stream.initializedSize {
+		from := reader.stream.initializedSize - start
+		if from < 0 {
+			from = 0
+		}
+		for i := from; i < int64(len(data)); i++ {
+			data[i] = 0
+		}
+	}
+	return
+}
+
+// piecesOf splits the clusters from firstVcn onwards across the extents they are in. Clusters that no extent covers
+// come back as sparse.
+func (reader *ntfsStreamReader) piecesOf(firstVcn int64, clusters int64) (pieces []dataExtent) {
+	vcn := firstVcn
+	end := firstVcn + clusters
+	for _, extent := range reader.stream.extents {
+		if vcn >= end {
+			break
+		}
+		extentEnd := extent.vcn + extent.clusters
+		if extentEnd <= vcn {
+			continue
+		}
+		if extent.vcn > vcn {
+			gap := extent.vcn - vcn
+			if vcn+gap > end {
+				gap = end - vcn
+			}
+			pieces = append(pieces, dataExtent{vcn: vcn, clusters: gap, sparse: true})
+			vcn += gap
+			if vcn >= end {
+				break
+			}
+		}
+		count := extentEnd - vcn
+		if vcn+count > end {
+			count = end - vcn
+		}
+		piece := dataExtent{vcn: vcn, clusters: count, sparse: extent.sparse}
+		if !extent.sparse {
+			piece.lcn = extent.lcn + (vcn - extent.vcn)
+		}
+		pieces = append(pieces, piece)
+		vcn += count
+	}
+	if vcn < end {
+		pieces = append(pieces, dataExtent{vcn: vcn, clusters: end - vcn, sparse: true})
+	}
+	return
+}
+
+// readPieces reads the pieces of a unit one after the other, with zeros for the sparse ones.
+func (reader *ntfsStreamReader) readPieces(pieces []dataExtent) (data []byte, err error) {
+	for _, piece := range pieces {
+		buffer := make([]byte, piece.clusters*reader.bytesPerCluster)
+		if !piece.sparse {
+			_, err = reader.volume.Seek(piece.lcn*reader.bytesPerCluster, io.SeekStart)
+			if err != nil {
+				err = fmt.Errorf("failed to seek to cluster %d: %w", piece.lcn, err)
+				return
+			}
+			_, err = io.ReadFull(reader.volume, buffer)
+			if err != nil {
+				err = fmt.Errorf("failed to read %d clusters at cluster %d: %w", piece.clusters, piece.lcn, err)
+				return
+			}
+		}
+		data = append(data, buffer...)
+	}
+	return
+}
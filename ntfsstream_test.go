@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func Test_parseRunList(t *testing.T) {
+	tests := []struct {
+		name    string
+		runList []byte
+		want    []dataExtent
+		wantOk  bool
+	}{
+		{
+			name: "allocated, sparse and a run before the one before it",
+			runList: []byte{
+				0x21, 0x04, 0x34, 0x56, // 4 clusters at 0x5634
+				0x01, 0x02, // 2 sparse clusters
+				0x11, 0x03, 0xfe, // 3 clusters 2 before the last allocated run
+				0x00,
+			},
+			want: []dataExtent{
+				{vcn: 0, clusters: 4, lcn: 0x5634},
+				{vcn: 4, clusters: 2, sparse: true},
+				{vcn: 6, clusters: 3, lcn: 0x5632},
+			},
+			wantOk: true,
+		},
+		{
+			name:    "cut short",
+			runList: []byte{0x21, 0x04},
+			wantOk:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRunList(tt.runList, 0)
+			if ok != tt.wantOk {
+				t.Fatalf("parseRunList() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRunList() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseNonResidentData(t *testing.T) {
+	record := make([]byte, 1024)
+	copy(record, "FILE")
+	binary.LittleEndian.PutUint16(record[0x04:], 0x30)
+	binary.LittleEndian.PutUint16(record[0x06:], 1)
+	attribute := record[0x38:]
+	binary.LittleEndian.PutUint32(attribute[0x00:], 0x80)
+	binary.LittleEndian.PutUint32(attribute[0x04:], 0x48)
+	attribute[0x08] = 1
+	binary.LittleEndian.PutUint16(attribute[0x0c:], 0x0001) // compressed
+	binary.LittleEndian.PutUint16(attribute[0x20:], 0x40)
+	binary.LittleEndian.PutUint16(attribute[0x22:], 4)
+	binary.LittleEndian.PutUint64(attribute[0x30:], 70000)
+	binary.LittleEndian.PutUint64(attribute[0x38:], 65536)
+	copy(attribute[0x40:], []byte{0x11, 0x03, 0x20, 0x01, 0x0d, 0x00})
+	binary.LittleEndian.PutUint32(record[0x38+0x48:], 0xffffffff)
+
+	stream, hasSizes, found := parseNonResidentData(record, 0x38)
+	want := ntfsStream{
+		extents:         []dataExtent{{vcn: 0, clusters: 3, lcn: 0x20}, {vcn: 3, clusters: 13, sparse: true}},
+		compressed:      true,
+		compressionUnit: 16,
+		size:            70000,
+		initializedSize: 65536,
+	}
+	if !found || !hasSizes {
+		t.Fatalf("parseNonResidentData() found = %v, hasSizes = %v", found, hasSizes)
+	}
+	if !reflect.DeepEqual(stream, want) {
+		t.Errorf("parseNonResidentData() = %+v, want %+v", stream, want)
+	}
+	if !stream.needsStreamReader() {
+		t.Error("needsStreamReader() = false for a compressed stream")
+	}
+}
+
+func Test_ntfsStreamReader(t *testing.T) {
+	const bytesPerCluster = 1024
+	volume := make([]byte, 16*bytesPerCluster)
+	for cluster := 0; cluster < 16; cluster++ {
+		for i := 0; i < bytesPerCluster; i++ {
+			volume[cluster*bytesPerCluster+i] = byte(cluster + 1)
+		}
+	}
+	compressedUnit := []byte{0x05, 0xb0, 0x08, 'a', 'b', 'c', 0x06, 0x20, 0x00, 0x00}
+	copy(volume[3*bytesPerCluster:], append(compressedUnit, make([]byte, bytesPerCluster-len(compressedUnit))...))
+	cluster := func(number int) []byte { return volume[number*bytesPerCluster : (number+1)*bytesPerCluster] }
+	zeros := func(size int) []byte { return make([]byte, size) }
+	join := func(parts ...[]byte) (joined []byte) {
+		for _, part := range parts {
+			joined = append(joined, part...)
+		}
+		return
+	}
+	decompressedUnit := append(bytes.Repeat([]byte("abc"), 4), zeros(4*bytesPerCluster-12)...)
+
+	tests := []struct {
+		name   string
+		stream ntfsStream
+		want   []byte
+	}{
+		{
+			name: "sparse",
+			stream: ntfsStream{
+				extents:         []dataExtent{{vcn: 0, clusters: 2, lcn: 1}, {vcn: 2, clusters: 2, sparse: true}, {vcn: 4, clusters: 1, lcn: 5}},
+				size:            4*bytesPerCluster + 100,
+				initializedSize: 4*bytesPerCluster + 100,
+			},
+			want: join(cluster(1), cluster(2), zeros(2*bytesPerCluster), cluster(5)[:100]),
+		},
+		{
+			name: "compressed",
+			stream: ntfsStream{
+				extents: []dataExtent{
+					{vcn: 0, clusters: 1, lcn: 3},       // compressed unit
+					{vcn: 1, clusters: 3, sparse: true}, // the rest of the compressed unit
+					{vcn: 4, clusters: 4, lcn: 6},       // unit stored as it is
+					{vcn: 8, clusters: 4, sparse: true}, // unit of zeros
+				},
+				compressed:      true,
+				compressionUnit: 4,
+				size:            10 * bytesPerCluster,
+				initializedSize: 10 * bytesPerCluster,
+			},
+			want: join(decompressedUnit, cluster(6), cluster(7), cluster(8), cluster(9), zeros(2*bytesPerCluster)),
+		},
+		{
+			name: "past the initialized size",
+			stream: ntfsStream{
+				extents:         []dataExtent{{vcn: 0, clusters: 2, lcn: 1}, {vcn: 2, clusters: 1, sparse: true}},
+				size:            2 * bytesPerCluster,
+				initializedSize: bytesPerCluster + 10,
+			},
+			want: join(cluster(1), cluster(2)[:10], zeros(bytesPerCluster-10)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ioutil.ReadAll(newNtfsStreamReader(bytes.NewReader(volume), bytesPerCluster, tt.stream))
+			if err != nil {
+				t.Fatalf("ntfsStreamReader.Read() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ntfsStreamReader.Read() returned %d bytes, want %d, or the contents differ", len(got), len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// partialSuffix is added to the name of an output file while it's being written.
+const partialSuffix = ".partial"
+
+// PartialFile is an output file that is written as NAME.partial and only renamed to NAME once the result writer has
+// completed it, so a collection that fails, is cancelled or crashes never leaves behind a file that looks whole. What
+// it leaves under the .partial name can still be looked at, and a zip is closed out with its central directory.
+type PartialFile struct {
+	*os.File
+	name string
+}
+
+// CreatePartialFile creates NAME.partial to write the output NAME into, replacing one an earlier run left behind.
+func CreatePartialFile(name string) (file *PartialFile, err error) {
+	output, err := os.Create(name + partialSuffix)
+	if err != nil {
+		return
+	}
+	file = &PartialFile{File: output, name: name}
+	return
+}
+
+// Complete flushes the file to disk, closes it and renames it to its final name, replacing whatever had that name.
+func (file *PartialFile) Complete() (err error) {
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to flush %s: %w", file.Name(), err)
+		return
+	}
+	err = os.Rename(file.Name(), file.name)
+	return
+}
+
+// completer is an output that only gets its final name once it's completed, rather than just closed.
+type completer interface {
+	Complete() error
+}
+
+// closeOutput closes what a result writer wrote to, completing it instead when everything was written.
+func closeOutput(output io.Closer, complete bool) error {
+	if completer, ok := output.(completer); ok && complete {
+		return completer.Complete()
+	}
+	return output.Close()
+}
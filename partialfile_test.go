@@ -0,0 +1,69 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPartialFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-partial-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	tests := []struct {
+		name        string
+		cancel      bool
+		wantName    string
+		wantMissing string
+	}{
+		{name: "complete", wantName: "complete.zip", wantMissing: "complete.zip.partial"},
+		{name: "cancelled", cancel: true, wantName: "cancelled.zip.partial", wantMissing: "cancelled.zip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := CreatePartialFile(filepath.Join(directory, tt.name+".zip"))
+			if err != nil {
+				t.Fatalf("CreatePartialFile() error = %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			zipResultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(file), FileHandle: file}
+			fileReaders := make(chan fileReader)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			go func() {
+				fileReaders <- fileReader{fullPath: `c:\file`, reader: bytes.NewReader([]byte("regf"))}
+				if tt.cancel {
+					cancel()
+				}
+				close(fileReaders)
+			}()
+			err = zipResultWriter.ResultWriter(ctx, fileReaders, &waitForFileCopying)
+			if (err != nil) != tt.cancel {
+				t.Errorf("ZipResultWriter.ResultWriter() error = %v, want one: %v", err, tt.cancel)
+			}
+
+			if _, statErr := os.Stat(filepath.Join(directory, tt.wantMissing)); !os.IsNotExist(statErr) {
+				t.Errorf("%s was left behind", tt.wantMissing)
+			}
+			reader, openErr := zip.OpenReader(filepath.Join(directory, tt.wantName))
+			if openErr != nil {
+				t.Fatalf("%s can't be opened as a zip: %v", tt.wantName, openErr)
+			}
+			defer reader.Close()
+			if len(reader.File) != 1 || reader.File[0].Name != "c/file" {
+				t.Errorf("%s holds %d files, want c/file", tt.wantName, len(reader.File))
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// defaultPendingFiles is how many files can wait for the result writer when CollectOptions.PendingFiles isn't set.
+const defaultPendingFiles = 100
+
+// With a minimal footprint, the files and bytes of them that can wait for the result writer when
+// CollectOptions.PendingFiles and PendingBytes aren't set, so the collector's memory stays small.
+const (
+	minimalFootprintPendingFiles = 8
+	minimalFootprintPendingBytes = 16 * 1024 * 1024
+)
+
+// errResultWriterReturned is what the files are sent to once the result writer has returned without an error before
+// the collection was done with it.
+var errResultWriterReturned = errors.New("the result writer returned before the collection was done")
+
+// filePipeline is what's between the files being read and the result writer. A file is pending from when it's sent
+// until the result writer has read it to its end or failed to, and sending waits while too many files, or too many
+// bytes of them held in memory, are pending. Once the result writer returns, sending fails with its error rather than
+// blocking on a channel nobody drains.
+type filePipeline struct {
+	files    chan fileReader
+	maxFiles int
+	maxBytes int64 // zero means no limit
+
+	mutex        sync.Mutex
+	pending      int
+	pendingBytes int64
+	released     chan struct{} // closed and replaced whenever a file stops being pending
+
+	stopped   chan struct{} // closed when the result writer returns
+	writerErr error
+}
+
+func newFilePipeline(options CollectOptions) *filePipeline {
+	maxFiles, maxBytes := options.PendingFiles, options.PendingBytes
+	if maxFiles <= 0 {
+		maxFiles = defaultPendingFiles
+		if options.MinimalFootprint {
+			maxFiles = minimalFootprintPendingFiles
+		}
+	}
+	if maxBytes <= 0 && options.MinimalFootprint {
+		maxBytes = minimalFootprintPendingBytes
+	}
+	return &filePipeline{
+		files:    make(chan fileReader, maxFiles),
+		maxFiles: maxFiles,
+		maxBytes: maxBytes,
+		released: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// stop records that the result writer has returned, with its error.
+func (pipeline *filePipeline) stop(writerErr error) {
+	pipeline.mutex.Lock()
+	pipeline.writerErr = writerErr
+	pipeline.mutex.Unlock()
+	close(pipeline.stopped)
+}
+
+// failure is why nothing more can be sent, or nil while the result writer is still running.
+func (pipeline *filePipeline) failure() (err error) {
+	select {
+	case <-pipeline.stopped:
+	default:
+		return nil
+	}
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+	if pipeline.writerErr != nil {
+		return fmt.Errorf("the result writer failed: %w", pipeline.writerErr)
+	}
+	return errResultWriterReturned
+}
+
+// acquire waits until a file of size bytes in memory can be pending. A file bigger than the limit on its own is let
+// through once nothing else is pending, so it doesn't wait forever.
+func (pipeline *filePipeline) acquire(ctx context.Context, size int64) (err error) {
+	for {
+		pipeline.mutex.Lock()
+		fits := pipeline.pending < pipeline.maxFiles && (pipeline.maxBytes == 0 || pipeline.pendingBytes+size <= pipeline.maxBytes || pipeline.pending == 0)
+		if fits {
+			pipeline.pending++
+			pipeline.pendingBytes += size
+			pipeline.mutex.Unlock()
+			return
+		}
+		released := pipeline.released
+		pipeline.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-pipeline.stopped:
+			return pipeline.failure()
+		case <-ctx.Done():
+			if err = pipeline.failure(); err == nil {
+				err = ctx.Err()
+			}
+			return
+		}
+	}
+}
+
+func (pipeline *filePipeline) release(size int64) {
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+	pipeline.pending--
+	pipeline.pendingBytes -= size
+	close(pipeline.released)
+	pipeline.released = make(chan struct{})
+}
+
+// send hands a file to the result writer once it can be pending.
+func (pipeline *filePipeline) send(ctx context.Context, fileReaders chan fileReader, file fileReader) (err error) {
+	if err = pipeline.acquire(ctx, file.pendingBytes); err != nil {
+		return
+	}
+	file.reader = &pendingReader{reader: file.reader, release: func() { pipeline.release(file.pendingBytes) }}
+	select {
+	case fileReaders <- file:
+		return
+	case <-pipeline.stopped:
+		err = pipeline.failure()
+	case <-ctx.Done():
+		if err = pipeline.failure(); err == nil {
+			err = ctx.Err()
+		}
+	}
+	pipeline.release(file.pendingBytes)
+	return
+}
+
+// pendingReader releases its file from the pipeline once it has been read to its end or failed to read.
+type pendingReader struct {
+	reader  io.Reader
+	release func()
+	once    sync.Once
+}
+
+func (pending *pendingReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = pending.reader.Read(byteSliceToPopulate)
+	if err != nil {
+		pending.once.Do(pending.release)
+	}
+	return
+}
+
+// Close releases the file when the result writer gives up on it, and closes what it reads if it can be closed.
+func (pending *pendingReader) Close() (err error) {
+	pending.once.Do(pending.release)
+	if closer, ok := pending.reader.(io.Closer); ok {
+		err = closer.Close()
+	}
+	return
+}
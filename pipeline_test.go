@@ -0,0 +1,144 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendsWithin reports whether sending file gets through the pipeline before the timeout.
+func sendsWithin(ctx context.Context, pipeline *filePipeline, file fileReader) (sent chan error) {
+	sent = make(chan error, 1)
+	go func() {
+		sent <- (&Collector{pipeline: pipeline}).sendFileReader(ctx, pipeline.files, file)
+	}()
+	return
+}
+
+func Test_filePipeline_flowControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		options CollectOptions
+		first   fileReader
+		second  fileReader
+		waits   bool
+	}{
+		{name: "room for both", options: CollectOptions{PendingFiles: 2}, first: fileReader{pendingBytes: 10}, second: fileReader{pendingBytes: 10}},
+		{name: "one file at a time", options: CollectOptions{PendingFiles: 1}, first: fileReader{}, second: fileReader{}, waits: true},
+		{name: "too many bytes", options: CollectOptions{PendingBytes: 15}, first: fileReader{pendingBytes: 10}, second: fileReader{pendingBytes: 10}, waits: true},
+		{name: "minimal footprint", options: CollectOptions{MinimalFootprint: true}, first: fileReader{pendingBytes: 10 << 20}, second: fileReader{pendingBytes: 10 << 20}, waits: true},
+		{name: "bigger than the limit on its own", options: CollectOptions{PendingBytes: 15}, first: fileReader{pendingBytes: 20}, second: fileReader{pendingBytes: 1}, waits: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := newFilePipeline(tt.options)
+			tt.first.reader = strings.NewReader("first")
+			tt.second.reader = strings.NewReader("second")
+			if err := <-sendsWithin(context.Background(), pipeline, tt.first); err != nil {
+				t.Fatalf("sending the first file failed: %v", err)
+			}
+			sent := sendsWithin(context.Background(), pipeline, tt.second)
+			select {
+			case err := <-sent:
+				if tt.waits || err != nil {
+					t.Fatalf("sending the second file = %v before the first was written, want it to wait: %v", err, tt.waits)
+				}
+				return
+			case <-time.After(50 * time.Millisecond):
+				if !tt.waits {
+					t.Fatal("sending the second file waited for the first to be written")
+				}
+			}
+			data, _ := ioutil.ReadAll((<-pipeline.files).reader)
+			if string(data) != "first" {
+				t.Errorf("the result writer read %q, want the first file", data)
+			}
+			if err := <-sent; err != nil {
+				t.Errorf("sending the second file once the first was written failed: %v", err)
+			}
+		})
+	}
+}
+
+func Test_newFilePipeline(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      CollectOptions
+		wantMaxFiles int
+		wantMaxBytes int64
+	}{
+		{name: "defaults", wantMaxFiles: defaultPendingFiles},
+		{name: "minimal footprint", options: CollectOptions{MinimalFootprint: true}, wantMaxFiles: minimalFootprintPendingFiles, wantMaxBytes: minimalFootprintPendingBytes},
+		{name: "minimal footprint with limits", options: CollectOptions{MinimalFootprint: true, PendingFiles: 2, PendingBytes: 1024}, wantMaxFiles: 2, wantMaxBytes: 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := newFilePipeline(tt.options)
+			if pipeline.maxFiles != tt.wantMaxFiles || pipeline.maxBytes != tt.wantMaxBytes || cap(pipeline.files) != tt.wantMaxFiles {
+				t.Errorf("newFilePipeline() holds %d files and %d bytes, want %d and %d", pipeline.maxFiles, pipeline.maxBytes, tt.wantMaxFiles, tt.wantMaxBytes)
+			}
+		})
+	}
+}
+
+func Test_filePipeline_writerStopped(t *testing.T) {
+	tests := []struct {
+		name      string
+		writerErr error
+		wantErr   string
+	}{
+		{name: "failed", writerErr: errors.New("the bucket is gone"), wantErr: "the result writer failed: the bucket is gone"},
+		{name: "returned early", wantErr: errResultWriterReturned.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := newFilePipeline(CollectOptions{PendingFiles: 1})
+			if err := <-sendsWithin(context.Background(), pipeline, fileReader{reader: strings.NewReader("x")}); err != nil {
+				t.Fatalf("sending the first file failed: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sent := sendsWithin(ctx, pipeline, fileReader{reader: strings.NewReader("y")})
+			pipeline.stop(tt.writerErr)
+			cancel()
+			if err := <-sent; err == nil || err.Error() != tt.wantErr {
+				t.Errorf("sendFileReader() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// earlyResultWriter returns without an error as soon as it has the first file.
+type earlyResultWriter struct{}
+
+func (earlyResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	<-fileReaders
+	return
+}
+
+func TestCollect_resultWriterReturnsEarly(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	finished := make(chan error, 1)
+	go func() {
+		_, err := CollectWithReport(context.Background(), handler, exportList, earlyResultWriter{}, CollectOptions{PendingFiles: 1})
+		finished <- err
+	}()
+	select {
+	case err := <-finished:
+		if err == nil || !strings.Contains(err.Error(), errResultWriterReturned.Error()) {
+			t.Errorf("CollectWithReport() error = %v, want %v", err, errResultWriterReturned)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("CollectWithReport() is still waiting on a result writer that has returned")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"sync"
+)
+
+// plannedEntryOverhead is roughly what a zip or tar adds to the output for each file, going by the tar's 512 byte
+// header, its record of the file's hash and the padding after the file's data.
+const plannedEntryOverhead = 1536
+
+// CollectionPlan is what a dry run found would be collected, so a set of targets can be checked before a long
+// collection is started.
+type CollectionPlan struct {
+	Files      []PlannedFile `json:"files"`
+	TotalFiles int           `json:"total_files"`
+	TotalBytes int64         `json:"total_bytes"` // going by the sizes the MFT, or the API without one, gives
+	// EstimatedOutputBytes is TotalBytes with what the archive adds for each file. Compression isn't predicted, so a
+	// compressed zip usually comes out smaller.
+	EstimatedOutputBytes int64          `json:"estimated_output_bytes"`
+	Skipped              []FileReport   `json:"skipped"`            // matched files the limits leave out or that can't be read
+	Deferred             []DeferredFile `json:"deferred,omitempty"` // left out to stay within the ByteBudget
+	Volumes              []VolumeReport `json:"volumes"`
+}
+
+// PlannedFile is a file a dry run found would be collected.
+type PlannedFile struct {
+	Path    string `json:"path"` // where it would be written in the output
+	Volume  string `json:"volume"`
+	Size    int64  `json:"size"`
+	Deleted bool   `json:"deleted,omitempty"` // recovered from a deleted MFT record
+}
+
+// filePlanner records the files a dry run would collect instead of reading them. It's nil unless Plan is running, and
+// its methods do nothing when it's nil.
+type filePlanner struct {
+	mutex    sync.Mutex
+	files    []PlannedFile
+	deferred []DeferredFile
+}
+
+// planned records files and reports whether it did, in which case they mustn't be read.
+func (planner *filePlanner) planned(volumeLetter string, files foundFiles) bool {
+	if planner == nil {
+		return false
+	}
+	planner.mutex.Lock()
+	defer planner.mutex.Unlock()
+	for _, file := range files {
+		planner.files = append(planner.files, PlannedFile{
+			Path:    file.outputPath(),
+			Volume:  volumeLetter,
+			Size:    file.totalSize(),
+			Deleted: file.deleted,
+		})
+	}
+	return true
+}
+
+// setBudget keeps what the budget left out once every volume has been searched.
+func (planner *filePlanner) setBudget(budget *budgetPlanner) {
+	if budget == nil {
+		return
+	}
+	planner.mutex.Lock()
+	defer planner.mutex.Unlock()
+	planner.deferred = budget.snapshot().Deferred
+}
+
+// plan puts together the CollectionPlan from the files recorded and what the report holds.
+func (planner *filePlanner) plan(report CollectionReport) (plan CollectionPlan) {
+	planner.mutex.Lock()
+	defer planner.mutex.Unlock()
+	plan = CollectionPlan{
+		Files:    append(make([]PlannedFile, 0, len(planner.files)), planner.files...),
+		Skipped:  report.Files,
+		Deferred: planner.deferred,
+		Volumes:  report.Volumes,
+	}
+	for _, file := range plan.Files {
+		plan.TotalBytes += file.Size
+		plan.EstimatedOutputBytes += file.Size + plannedEntryOverhead
+	}
+	plan.TotalFiles = len(plan.Files)
+	return
+}
+
+// Plan searches the volumes for the targets the way Collect does, applying the limits, the budget and the read
+// policies, and returns what would be collected without reading any of it or writing anything. Acquirers, commands,
+// $I30 indexes, volume ranges and boot records aren't run or planned. Like CollectWithReport the plan is returned even
+// when some of the volumes fail, along with their errors as CollectionErrors.
+func Plan(ctx context.Context, injectedHandlerDependency handler, exportList ListOfFilesToExport, options CollectOptions) (plan CollectionPlan, err error) {
+	collector := &Collector{Options: options, volumes: injectedHandlerDependency}
+	return collector.Plan(ctx, exportList)
+}
+
+// Plan returns what the targets would collect, the way the Plan function does.
+func (collector *Collector) Plan(ctx context.Context, targets ListOfFilesToExport) (plan CollectionPlan, err error) {
+	run := collector.newRun()
+	run.planner = &filePlanner{}
+	run.report = newReportBuilder()
+	err = run.collect(ctx, targets, planResultWriter{})
+	plan = run.planner.plan(run.report.snapshot())
+	return
+}
+
+// planResultWriter stands in for a result writer during a dry run, which sends it nothing.
+type planResultWriter struct{}
+
+func (planResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	for range fileReaders {
+	}
+	return
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	targets := ListOfFilesToExport{{FullPath: `c:\$MFT`, FileName: `$MFT`}}
+	tests := []struct {
+		name         string
+		options      CollectOptions
+		wantFiles    int
+		wantSkipped  int
+		wantDeferred int
+	}{
+		{name: "planned", wantFiles: 1},
+		{name: "too big", options: CollectOptions{MaxFileSize: 1}, wantSkipped: 1},
+		{name: "over the budget", options: CollectOptions{ByteBudget: 1}, wantDeferred: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection := NewCollector(tt.options)
+			collection.volumes = dummyHandler{filePath: `test\testdata\dummyntfs`}
+			plan, err := collection.Plan(context.Background(), targets)
+			if err != nil {
+				t.Fatalf("Collector.Plan() error = %v", err)
+			}
+			if plan.TotalFiles != tt.wantFiles || len(plan.Files) != tt.wantFiles || len(plan.Skipped) != tt.wantSkipped || len(plan.Deferred) != tt.wantDeferred {
+				t.Fatalf("Collector.Plan() = %+v, want %d files, %d skipped and %d deferred", plan, tt.wantFiles, tt.wantSkipped, tt.wantDeferred)
+			}
+			if tt.wantFiles == 0 {
+				return
+			}
+			if plan.Files[0].Path != `c:\$mft` || plan.Files[0].Size == 0 || plan.TotalBytes != plan.Files[0].Size {
+				t.Errorf("Collector.Plan() planned %+v with %d bytes, want c:\\$mft", plan.Files[0], plan.TotalBytes)
+			}
+			if plan.EstimatedOutputBytes != plan.TotalBytes+plannedEntryOverhead {
+				t.Errorf("Collector.Plan() estimated %d bytes of output for %d bytes of files", plan.EstimatedOutputBytes, plan.TotalBytes)
+			}
+		})
+	}
+}
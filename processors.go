@@ -0,0 +1,536 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Processor turns the collected files it handles into other files in the output as they're written, such as event
+// logs parsed into JSON lines a SIEM can ingest right away.
+type Processor interface {
+	// OutputPath is where the processed copy of the file at fullPath goes in the output, or empty for a file the
+	// processor leaves alone.
+	OutputPath(fullPath string) string
+
+	// Process reads the file and writes its processed copy, logging through the collection's logger.
+	Process(ctx context.Context, logger Logger, input io.Reader, output io.Writer) error
+}
+
+var (
+	processorRegistryLock sync.RWMutex
+	processorRegistry     = map[string]Processor{
+		"evtx_json": EvtxJSONProcessor{},
+		"sha256":    SHA256Processor{},
+		"gzip":      GzipProcessor{},
+	}
+)
+
+// RegisterProcessor makes a processor available by name so targets can run their files through it, the way
+// RegisterCodec does for codecs. This is how embedding applications add processors such as YARA scanning without this
+// package depending on them.
+func RegisterProcessor(name string, processor Processor) (err error) {
+	name = strings.ToLower(name)
+	if name == "" || processor == nil {
+		err = errors.New("RegisterProcessor() received a processor without a name")
+		return
+	}
+	processorRegistryLock.Lock()
+	defer processorRegistryLock.Unlock()
+	processorRegistry[name] = processor
+	return
+}
+
+// LookupProcessor returns the registered processor with the given name.
+func LookupProcessor(name string) (processor Processor, err error) {
+	processorRegistryLock.RLock()
+	defer processorRegistryLock.RUnlock()
+	processor, ok := processorRegistry[strings.ToLower(name)]
+	if !ok {
+		err = fmt.Errorf("no processor named '%s' has been registered", name)
+	}
+	return
+}
+
+// RegisteredProcessors returns the names of every registered processor.
+func RegisteredProcessors() (names []string) {
+	processorRegistryLock.RLock()
+	defer processorRegistryLock.RUnlock()
+	for name := range processorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// lookupProcessorChain returns the chain of the registered processors named in a list separated by commas, or nil when
+// there are none.
+func lookupProcessorChain(names string) (chain ProcessorChain, err error) {
+	if strings.TrimSpace(names) == "" {
+		return
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		var processor Processor
+		if processor, err = LookupProcessor(name); err != nil {
+			return nil, err
+		}
+		chain = append(chain, processor)
+	}
+	return
+}
+
+// ProcessorChain runs a file through processors one after the other, each processing what the one before it wrote,
+// such as event logs parsed into JSON lines and then compressed. It only handles the files all of them handle, and its
+// copy goes where each of them in turn would put the one before's, e.g. Security.evtx.jsonl.gz.
+type ProcessorChain []Processor
+
+// OutputPath is where the last processor puts its copy, or empty when one of them leaves the file alone.
+func (chain ProcessorChain) OutputPath(fullPath string) string {
+	if len(chain) == 0 {
+		return ""
+	}
+	for _, processor := range chain {
+		if fullPath = processor.OutputPath(fullPath); fullPath == "" {
+			return ""
+		}
+	}
+	return fullPath
+}
+
+// Process runs the processors at the same time, each reading what the one before it writes through a pipe.
+func (chain ProcessorChain) Process(ctx context.Context, logger Logger, input io.Reader, output io.Writer) (err error) {
+	if len(chain) == 0 {
+		_, err = io.Copy(output, input)
+		return
+	}
+	errs := make(chan error, len(chain)-1)
+	var pipeReaders []*io.PipeReader
+	for _, processor := range chain[:len(chain)-1] {
+		pipeReader, pipeWriter := io.Pipe()
+		go func(processor Processor, input io.Reader) {
+			processErr := processor.Process(ctx, logger, input, pipeWriter)
+			_ = pipeWriter.CloseWithError(processErr)
+			errs <- processErr
+		}(processor, input)
+		pipeReaders = append(pipeReaders, pipeReader)
+		input = pipeReader
+	}
+	err = chain[len(chain)-1].Process(ctx, logger, input, output)
+	// Whatever a processor stopped reading mustn't leave the ones before it waiting to write
+	for _, pipeReader := range pipeReaders {
+		_ = pipeReader.CloseWithError(errProcessorStopped)
+	}
+	for range pipeReaders {
+		// A processor that failed makes the ones after it fail with its error, so that's the one to return
+		processErr := <-errs
+		if processErr != nil && !errors.Is(processErr, errProcessorStopped) && (err == nil || errors.Is(err, errProcessorStopped)) {
+			err = processErr
+		}
+	}
+	return
+}
+
+// SHA256Processor writes the SHA-256 of a file, in hex, into a .sha256 file next to it.
+type SHA256Processor struct{}
+
+// OutputPath is the file's path with .sha256 added.
+func (processor SHA256Processor) OutputPath(fullPath string) string {
+	return fullPath + ".sha256"
+}
+
+// Process hashes the file.
+func (processor SHA256Processor) Process(ctx context.Context, logger Logger, input io.Reader, output io.Writer) (err error) {
+	hash := sha256.New()
+	if _, err = io.Copy(hash, input); err != nil {
+		return
+	}
+	_, err = fmt.Fprintln(output, hex.EncodeToString(hash.Sum(nil)))
+	return
+}
+
+// GzipProcessor compresses a file with gzip, as a .gz file next to it. It's meant to go at the end of a chain after a
+// processor whose copy compresses well, such as an EvtxJSONProcessor, rather than to replace a Codec.
+type GzipProcessor struct{}
+
+// OutputPath is the file's path with .gz added.
+func (processor GzipProcessor) OutputPath(fullPath string) string {
+	return fullPath + ".gz"
+}
+
+// Process compresses the file.
+func (processor GzipProcessor) Process(ctx context.Context, logger Logger, input io.Reader, output io.Writer) (err error) {
+	compressor := gzip.NewWriter(output)
+	if _, err = io.Copy(compressor, input); err != nil {
+		return
+	}
+	err = compressor.Close()
+	return
+}
+
+// EvtxJSONProcessor parses .evtx files into JSON lines, an object for each event record with its record_id, timestamp
+// and Event. An element of the event is its value when it has nothing but a value, else an object of its attributes
+// under #attributes and its child elements by name, so the Data elements of EventData go under their Name, e.g.
+// {"record_id":1,"timestamp":"...","Event":{"System":{"EventID":4624,...},"EventData":{"LogonType":2,...}}}.
+type EvtxJSONProcessor struct{}
+
+// OutputPath is the file's path with .jsonl added.
+func (processor EvtxJSONProcessor) OutputPath(fullPath string) string {
+	if !strings.HasSuffix(strings.ToLower(fullPath), ".evtx") {
+		return ""
+	}
+	return fullPath + ".jsonl"
+}
+
+// Process writes a line for each event record. Records that can't be parsed, such as those a log that was still being
+// written cut short, are skipped.
+func (processor EvtxJSONProcessor) Process(ctx context.Context, logger Logger, input io.Reader, output io.Writer) (err error) {
+	buffered := bufio.NewWriter(output)
+	encoder := json.NewEncoder(buffered)
+	encoder.SetEscapeHTML(false)
+	skipped, err := readEvtx(input, func(record evtxRecord) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return
+	}
+	if skipped != 0 {
+		logger.Debugf("Skipped %d event records that couldn't be parsed.", skipped)
+	}
+	err = buffered.Flush()
+	return
+}
+
+// processingResultWriter runs the files of a collection through the Processors, or the processor chain of the target
+// they matched, on their way to the result writer. A processed copy goes in the file's place when replace is set.
+// Otherwise it goes after the file, spooled while the file is written, and processing that fails is reported as a
+// failed file of its own.
+type processingResultWriter struct {
+	writer     ResultWriter
+	processors []Processor
+	replace    bool
+	report     *reportBuilder
+	logger     Logger
+	stopped    chan struct{} // closed when the result writer returns
+}
+
+// withProcessors wraps the result writer with the processors, if there are any, either in the options or in a target.
+func (collector *Collector) withProcessors(resultWriter ResultWriter, exportList ListOfFilesToExport) ResultWriter {
+	if len(collector.Options.Processors) == 0 && !exportList.processed() {
+		return resultWriter
+	}
+	return &processingResultWriter{writer: resultWriter, processors: collector.Options.Processors, replace: collector.Options.ReplaceProcessed, report: collector.report, logger: collector.logger()}
+}
+
+// processed reports whether any of the targets has a processor chain.
+func (exportList ListOfFilesToExport) processed() bool {
+	for _, fileToExport := range exportList {
+		if fileToExport.Processors != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// processedCopy is a processor that handles a file and where its processed copy goes.
+type processedCopy struct {
+	processor  Processor
+	outputPath string
+}
+
+// copiesOf returns the processors that handle the file: the processor chain of the target it matched, then the
+// Processors.
+func (processing *processingResultWriter) copiesOf(file CollectedFile) (copies []processedCopy) {
+	processors := processing.processors
+	if file.processors != nil {
+		processors = append([]Processor{file.processors}, processors...)
+	}
+	for _, processor := range processors {
+		if outputPath := processor.OutputPath(file.fullPath); outputPath != "" {
+			copies = append(copies, processedCopy{processor: processor, outputPath: outputPath})
+		}
+	}
+	return
+}
+
+func (processing *processingResultWriter) ResultWriter(ctx context.Context, fileReaders chan CollectedFile, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	files := make(chan CollectedFile)
+	processing.stopped = make(chan struct{})
+	waitForWriter := sync.WaitGroup{}
+	waitForWriter.Add(1)
+	var writerErr error
+	go func() {
+		writerErr = processing.writer.ResultWriter(ctx, files, &waitForWriter)
+		close(processing.stopped)
+	}()
+	defer func() {
+		close(files)
+		waitForWriter.Wait()
+		<-processing.stopped
+		if writerErr != nil {
+			err = writerErr
+		}
+	}()
+
+	for {
+		var file CollectedFile
+		var open bool
+		select {
+		case file, open = <-fileReaders:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		if !open {
+			return
+		}
+		if copies := processing.copiesOf(file); len(copies) != 0 {
+			err = processing.sendProcessed(ctx, files, file, copies)
+		} else {
+			err = processing.send(ctx, files, file)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (processing *processingResultWriter) send(ctx context.Context, files chan CollectedFile, file CollectedFile) (err error) {
+	select {
+	case files <- file:
+	case <-processing.stopped:
+		err = errResultWriterStopped
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+type spoolResult struct {
+	spooled *spooledFile
+	err     error
+}
+
+// sendProcessed hands the result writer the file, or the first processed copy in its place when replace is set, and
+// then the other processed copies. They're processed from the file as it's read and spooled until it's written.
+// Processed copies that are empty, such as those of a YaraProcessor for files no rule matched, are left out.
+func (processing *processingResultWriter) sendProcessed(ctx context.Context, files chan CollectedFile, file CollectedFile, copies []processedCopy) (err error) {
+	ctx = contextWithProcessedFile(ctx, processedFile{fullPath: file.fullPath, report: processing.report})
+	var replacement *processedCopy
+	if processing.replace {
+		replacement, copies = &copies[0], copies[1:]
+	}
+	tee := &teeToProcessors{reader: file.reader}
+	spools := make([]chan spoolResult, len(copies))
+	for index, processed := range copies {
+		spools[index] = spoolProcessed(ctx, processing.logger, processed.processor, tee.add())
+	}
+	stop := func(err error) {
+		_ = tee.stop(err)
+		for _, spooled := range spools {
+			(<-spooled).spooled.discard()
+		}
+	}
+
+	file.reader = tee
+	send := true
+	if replacement != nil {
+		file, send, err = processing.replaced(ctx, file, *replacement)
+		if err != nil {
+			processing.report.fileFailed(replacement.outputPath, "", fmt.Errorf("failed to process %s: %w", file.fullPath, err))
+			err = nil
+			send = false
+		}
+	}
+	if send {
+		if err = processing.send(ctx, files, file); err != nil {
+			stop(err)
+			return
+		}
+	}
+
+	for index, processed := range copies {
+		var result spoolResult
+		select {
+		case result = <-spools[index]:
+		case <-processing.stopped:
+			spools = spools[index:]
+			stop(errResultWriterStopped)
+			return errResultWriterStopped
+		case <-ctx.Done():
+			spools = spools[index:]
+			stop(ctx.Err())
+			return ctx.Err()
+		}
+		if result.err != nil {
+			if !errors.Is(result.err, errResultWriterStopped) {
+				processing.report.fileFailed(processed.outputPath, "", fmt.Errorf("failed to process %s: %w", file.fullPath, result.err))
+			}
+			continue
+		}
+		if result.spooled.tempFile == nil && result.spooled.inMemory == 0 {
+			continue
+		}
+		copied := fileReader{
+			fullPath:     processed.outputPath,
+			reader:       result.spooled,
+			codec:        file.codec,
+			pendingBytes: result.spooled.inMemory,
+		}
+		if err = processing.send(ctx, files, processing.report.trackFile(copied, "")); err != nil {
+			result.spooled.discard()
+			spools = spools[index+1:]
+			stop(err)
+			return
+		}
+	}
+	return
+}
+
+// replaced returns the file with its processed copy in its place, processed as it's read, or send false when the copy
+// is empty.
+func (processing *processingResultWriter) replaced(ctx context.Context, file CollectedFile, replacement processedCopy) (replaced CollectedFile, send bool, err error) {
+	pipeReader, pipeWriter := io.Pipe()
+	original := file.reader
+	go func() {
+		_ = pipeWriter.CloseWithError(replacement.processor.Process(ctx, processing.logger, original, pipeWriter))
+		// The other processors read the file through the same tee, so they get whatever this one didn't read
+		_, _ = io.Copy(ioutil.Discard, original)
+		_ = closeReader(original)
+	}()
+	// Wait for the start of the copy to find out whether there is one
+	buffered := bufio.NewReader(pipeReader)
+	if _, err = buffered.Peek(1); err == io.EOF {
+		return file, false, nil
+	} else if err != nil {
+		_ = pipeReader.CloseWithError(err)
+		return file, false, err
+	}
+	replaced = file
+	replaced.fullPath = replacement.outputPath
+	replaced.reader = &processedReader{Reader: buffered, pipe: pipeReader, original: original}
+	return replaced, true, nil
+}
+
+// spoolProcessed spools the processed copy of what's written to input.
+func spoolProcessed(ctx context.Context, logger Logger, processor Processor, input *io.PipeReader) chan spoolResult {
+	outputReader, outputWriter := io.Pipe()
+	go func() {
+		processErr := processor.Process(ctx, logger, input, outputWriter)
+		_ = outputWriter.CloseWithError(processErr)
+		// Whatever the processor didn't read is still written, without it
+		_ = input.CloseWithError(errProcessorStopped)
+	}()
+	spooled := make(chan spoolResult, 1)
+	go func() {
+		var result spoolResult
+		result.spooled, result.err = spoolFile(outputReader)
+		_ = outputReader.CloseWithError(errProcessorStopped)
+		spooled <- result
+	}()
+	return spooled
+}
+
+// errProcessorStopped is what's left of a file for a processor that stopped part way through it.
+var errProcessorStopped = errors.New("the processor stopped")
+
+// teeToProcessors writes what's read of a file to its processors too. A processor stopping doesn't stop the file being
+// read.
+type teeToProcessors struct {
+	reader     io.Reader
+	processors []*io.PipeWriter
+	stopped    []bool
+}
+
+// add returns what a processor reads the file from.
+func (tee *teeToProcessors) add() *io.PipeReader {
+	pipeReader, pipeWriter := io.Pipe()
+	tee.processors = append(tee.processors, pipeWriter)
+	tee.stopped = append(tee.stopped, false)
+	return pipeReader
+}
+
+func (tee *teeToProcessors) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = tee.reader.Read(byteSliceToPopulate)
+	for index, processor := range tee.processors {
+		if numberOfBytesRead > 0 && !tee.stopped[index] {
+			if _, writeErr := processor.Write(byteSliceToPopulate[:numberOfBytesRead]); writeErr != nil {
+				tee.stopped[index] = true
+			}
+		}
+		if err == io.EOF {
+			_ = processor.Close()
+		} else if err != nil {
+			_ = processor.CloseWithError(err)
+		}
+	}
+	return
+}
+
+// stop stops the processors with err.
+func (tee *teeToProcessors) stop(err error) error {
+	for _, processor := range tee.processors {
+		_ = processor.CloseWithError(err)
+	}
+	return closeReader(tee.reader)
+}
+
+// Close stops the processors when the result writer gives up on the file.
+func (tee *teeToProcessors) Close() error {
+	return tee.stop(errResultWriterStopped)
+}
+
+// processedReader reads a processed copy, and closes the file it's made from when the result writer gives up on it.
+type processedReader struct {
+	io.Reader
+	pipe     *io.PipeReader
+	original io.Reader
+}
+
+func (processed *processedReader) Close() error {
+	_ = processed.pipe.CloseWithError(errResultWriterStopped)
+	return closeReader(processed.original)
+}
+
+type processedFileKey struct{}
+
+// processedFile is the file processors are processing, for those that report what they find in it.
+type processedFile struct {
+	fullPath string
+	report   *reportBuilder
+}
+
+func contextWithProcessedFile(ctx context.Context, file processedFile) context.Context {
+	return context.WithValue(ctx, processedFileKey{}, file)
+}
+
+// processedFileFromContext returns the file being processed, empty when it isn't known, such as when a processor is
+// run on its own.
+func processedFileFromContext(ctx context.Context) processedFile {
+	file, _ := ctx.Value(processedFileKey{}).(processedFile)
+	return file
+}
+
+// closeReader closes a reader that can be closed, such as the pendingReader that releases a file from the pipeline.
+func closeReader(reader io.Reader) (err error) {
+	if closer, ok := reader.(io.Closer); ok {
+		err = closer.Close()
+	}
+	return
+}
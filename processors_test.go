@@ -0,0 +1,188 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// byteCountProcessor writes how many bytes a file has, or fails once it's read them when fail is set.
+type byteCountProcessor struct {
+	fail bool
+}
+
+func (processor byteCountProcessor) OutputPath(fullPath string) string {
+	if !strings.HasSuffix(strings.ToLower(fullPath), `$mft`) {
+		return ""
+	}
+	return fullPath + ".size"
+}
+
+func (processor byteCountProcessor) Process(ctx context.Context, logger Logger, input io.Reader, output io.Writer) (err error) {
+	size, err := io.Copy(ioutil.Discard, input)
+	if err != nil {
+		return
+	}
+	if processor.fail {
+		return errors.New("the file can't be processed")
+	}
+	_, err = fmt.Fprint(output, size)
+	return
+}
+
+func TestCollect_processors(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	mftRules, err := ParseYaraRules(`rule mft { condition: uint32(0) == 0x454c4946 }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRules, err := ParseYaraRules(`rule other { strings: $a = "not in the mft" condition: $a }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		exportList ListOfFilesToExport
+		options    CollectOptions
+		wantFiles  []string
+		wantErr    bool
+	}{
+		{name: "none", wantFiles: []string{"c/$mft"}},
+		{name: "alongside", options: CollectOptions{Processors: []Processor{byteCountProcessor{}}}, wantFiles: []string{"c/$mft", "c/$mft.size"}},
+		{name: "instead", options: CollectOptions{Processors: []Processor{byteCountProcessor{}}, ReplaceProcessed: true}, wantFiles: []string{"c/$mft.size"}},
+		{name: "failed", options: CollectOptions{Processors: []Processor{byteCountProcessor{fail: true}}}, wantFiles: []string{"c/$mft"}, wantErr: true},
+		{name: "no match", options: CollectOptions{Processors: []Processor{EvtxJSONProcessor{}}}, wantFiles: []string{"c/$mft"}},
+		{name: "target chain", exportList: ListOfFilesToExport{{FullPath: `c:\$MFT`, FileName: `$MFT`, Processors: "sha256"}}, options: CollectOptions{Processors: []Processor{byteCountProcessor{}}}, wantFiles: []string{"c/$mft", "c/$mft.sha256", "c/$mft.size"}},
+		{name: "yara match", options: CollectOptions{Processors: []Processor{YaraProcessor{Rules: otherRules}, YaraProcessor{Rules: mftRules}}}, wantFiles: []string{"c/$mft", "c/$mft.yara.json"}},
+		{name: "yara sweep", options: CollectOptions{Processors: []Processor{YaraProcessor{Rules: otherRules}}, ReplaceProcessed: true}, wantFiles: []string{}},
+		{name: "unknown processor", exportList: ListOfFilesToExport{{FullPath: `c:\$MFT`, FileName: `$MFT`, Processors: "yara"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := new(bytes.Buffer)
+			resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+			if tt.exportList == nil {
+				tt.exportList = exportList
+			}
+			_, err := CollectWithReport(context.Background(), handler, tt.exportList, &resultWriter, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CollectWithReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantFiles == nil {
+				return
+			}
+			reader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			sizes := make(map[string]uint64)
+			for _, file := range reader.File {
+				if strings.HasPrefix(file.Name, "c/") {
+					files = append(files, file.Name)
+					sizes[file.Name] = file.UncompressedSize64
+				}
+			}
+			if strings.Join(files, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Fatalf("the zip holds %v, want %v", files, tt.wantFiles)
+			}
+			if size, ok := sizes["c/$mft.size"]; ok && size == 0 {
+				t.Error("the processed copy is empty")
+			}
+		})
+	}
+}
+
+func TestProcessorChain(t *testing.T) {
+	evtxJSON := new(bytes.Buffer)
+	if err := (EvtxJSONProcessor{}).Process(context.Background(), loggerOrDefault(nil), bytes.NewReader(testEvtxFile()), evtxJSON); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		chain          ProcessorChain
+		fullPath       string
+		wantOutputPath string
+		want           string
+		wantErr        bool
+	}{
+		{name: "evtx json gzipped", chain: ProcessorChain{EvtxJSONProcessor{}, GzipProcessor{}}, fullPath: `c:\logs\security.evtx`, wantOutputPath: `c:\logs\security.evtx.jsonl.gz`, want: evtxJSON.String()},
+		{name: "not an evtx", chain: ProcessorChain{EvtxJSONProcessor{}, GzipProcessor{}}, fullPath: `c:\logs\security.txt`},
+		{name: "empty", fullPath: `c:\logs\security.evtx`},
+		{name: "first fails", chain: ProcessorChain{byteCountProcessor{fail: true}, GzipProcessor{}}, fullPath: `c:\$MFT`, wantOutputPath: `c:\$MFT.size.gz`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.chain.OutputPath(tt.fullPath); got != tt.wantOutputPath {
+				t.Fatalf("OutputPath() = %s, want %s", got, tt.wantOutputPath)
+			}
+			if tt.wantOutputPath == "" {
+				return
+			}
+			output := new(bytes.Buffer)
+			err := tt.chain.Process(context.Background(), loggerOrDefault(nil), bytes.NewReader(testEvtxFile()), output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if err.Error() != "the file can't be processed" {
+					t.Errorf("Process() error = %v, want the first processor's", err)
+				}
+				return
+			}
+			decompressor, err := gzip.NewReader(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := ioutil.ReadAll(decompressor)
+			if string(got) != tt.want {
+				t.Errorf("Process() wrote %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupProcessor(t *testing.T) {
+	if err := RegisterProcessor("", byteCountProcessor{}); err == nil {
+		t.Error("RegisterProcessor() error = nil for a processor without a name")
+	}
+	if err := RegisterProcessor("Byte_Count", byteCountProcessor{}); err != nil {
+		t.Fatalf("RegisterProcessor() error = %v", err)
+	}
+	tests := []struct {
+		names   string
+		want    int
+		wantErr bool
+	}{
+		{names: "", want: 0},
+		{names: "evtx_json, GZIP", want: 2},
+		{names: "byte_count", want: 1},
+		{names: "sha256,yara", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.names, func(t *testing.T) {
+			chain, err := lookupProcessorChain(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupProcessorChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(chain) != tt.want {
+				t.Errorf("lookupProcessorChain() = %v, want %d processors", chain, tt.want)
+			}
+		})
+	}
+	if got := strings.Join(RegisteredProcessors(), ","); got != "byte_count,evtx_json,gzip,sha256" {
+		t.Errorf("RegisteredProcessors() = %s", got)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	"io"
+)
+
+// Stage identifies which phase of a collection is currently running.
+type Stage int
+
+// Collection stages reported through a ProgressFunc.
+const (
+	StageMFTParse Stage = iota
+	StageSearch
+	StageCopy
+	StageDone
+)
+
+// String returns a human readable name for the stage.
+func (stage Stage) String() string {
+	switch stage {
+	case StageMFTParse:
+		return "mft parse"
+	case StageSearch:
+		return "search"
+	case StageCopy:
+		return "copy"
+	case StageDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText lets a Stage be serialized by name in JSON progress events.
+func (stage Stage) MarshalText() (text []byte, err error) {
+	text = []byte(stage.String())
+	return
+}
+
+// UnmarshalText reads a Stage back from its name, such as in the JSON progress events gofor-collector prints.
+func (stage *Stage) UnmarshalText(text []byte) (err error) {
+	for candidate := StageMFTParse; candidate <= StageDone; candidate++ {
+		if candidate.String() == string(text) {
+			*stage = candidate
+			return
+		}
+	}
+	err = fmt.Errorf("'%s' isn't a collection stage", text)
+	return
+}
+
+// Progress is a snapshot of how far along a collection is. BytesRead and TotalBytes are for the file named in FileName.
+type Progress struct {
+	Stage        Stage  `json:"stage"`
+	VolumeLetter string `json:"volume"`
+	FileName     string `json:"file,omitempty"`
+	BytesRead    int64  `json:"bytes_read"`
+	TotalBytes   int64  `json:"total_bytes"`
+}
+
+// ProgressFunc receives progress updates during a collection. It may be called from more than one goroutine.
+type ProgressFunc func(Progress)
+
+// progressReportInterval is how many bytes a progressReader lets through between reports.
+const progressReportInterval = 1024 * 1024
+
+func (progress ProgressFunc) report(update Progress) {
+	if progress != nil {
+		progress(update)
+	}
+}
+
+// progressReader wraps an io.Reader and reports how many bytes have gone through it.
+type progressReader struct {
+	reader         io.Reader
+	progress       ProgressFunc
+	update         Progress
+	lastReportedAt int64
+}
+
+func newProgressReader(reader io.Reader, progress ProgressFunc, update Progress) io.Reader {
+	if progress == nil {
+		return reader
+	}
+	return &progressReader{
+		reader:   reader,
+		progress: progress,
+		update:   update,
+	}
+}
+
+func (progressReader *progressReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = progressReader.reader.Read(byteSliceToPopulate)
+	progressReader.update.BytesRead += int64(numberOfBytesRead)
+
+	// Only report every so often, otherwise a large file would flood the callback
+	if err != nil || progressReader.update.BytesRead-progressReader.lastReportedAt >= progressReportInterval {
+		progressReader.lastReportedAt = progressReader.update.BytesRead
+		progressReader.progress(progressReader.update)
+	}
+	return
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStage_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		stage Stage
+		want  string
+	}{
+		{name: "mft parse", stage: StageMFTParse, want: "mft parse"},
+		{name: "search", stage: StageSearch, want: "search"},
+		{name: "copy", stage: StageCopy, want: "copy"},
+		{name: "done", stage: StageDone, want: "done"},
+		{name: "unknown", stage: Stage(99), want: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stage.String(); got != tt.want {
+				t.Errorf("Stage.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStage_UnmarshalText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    Stage
+		wantErr bool
+	}{
+		{name: "mft parse", text: "mft parse", want: StageMFTParse},
+		{name: "copy", text: "copy", want: StageCopy},
+		{name: "done", text: "done", want: StageDone},
+		{name: "unknown", text: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Stage
+			err := got.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Stage.UnmarshalText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Stage.UnmarshalText() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_progressReader_Read(t *testing.T) {
+	tests := []struct {
+		name            string
+		dataSize        int
+		wantReports     int
+		wantLastReadEnd int64
+	}{
+		{
+			name:            "small file reports once at eof",
+			dataSize:        1024,
+			wantReports:     1,
+			wantLastReadEnd: 1024,
+		},
+		{
+			name:            "large file reports every interval",
+			dataSize:        progressReportInterval * 3,
+			wantReports:     4,
+			wantLastReadEnd: progressReportInterval * 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reports []Progress
+			progress := ProgressFunc(func(update Progress) {
+				reports = append(reports, update)
+			})
+			reader := newProgressReader(bytes.NewReader(make([]byte, tt.dataSize)), progress, Progress{
+				Stage:      StageCopy,
+				FileName:   "test",
+				TotalBytes: int64(tt.dataSize),
+			})
+			_, err := io.Copy(ioutil.Discard, reader)
+			if err != nil {
+				t.Errorf("progressReader.Read() error = %v", err)
+				return
+			}
+			if len(reports) != tt.wantReports {
+				t.Errorf("progressReader.Read() reports = %d, want %d", len(reports), tt.wantReports)
+				return
+			}
+			if got := reports[len(reports)-1].BytesRead; got != tt.wantLastReadEnd {
+				t.Errorf("progressReader.Read() last BytesRead = %d, want %d", got, tt.wantLastReadEnd)
+			}
+		})
+	}
+}
+
+func Test_newProgressReader_nilProgress(t *testing.T) {
+	reader := bytes.NewReader([]byte{0x00})
+	if got := newProgressReader(reader, nil, Progress{}); got != reader {
+		t.Errorf("newProgressReader() with a nil ProgressFunc should return the original reader")
+	}
+}
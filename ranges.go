@@ -0,0 +1,186 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	rangesDirectory      = "ranges"
+	maxRangeReadSize     = 1024 * 1024
+	fallbackSectorSize   = 512
+	rangeOutputExtension = ".bin"
+)
+
+// VolumeRange is a range of a volume read raw into the output under ranges, such as its boot sector, the slack after a
+// file or a region another tool pointed at. Offset and Length are in clusters when Clusters is set, else in bytes, and
+// the range is written as ranges\<volume>\<byte offset>-<byte length>.bin.
+type VolumeRange struct {
+	Volume   string `json:"volume"` // e.g. C, or %SYSTEMDRIVE%
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Clusters bool   `json:"clusters,omitempty"`
+}
+
+var driveLetter = regexp.MustCompile(`^[a-z]$`)
+
+// normalizeVolumeRanges lowercases the ranges' volumes, puts in the system drive and checks the ranges make sense.
+func normalizeVolumeRanges(ranges []VolumeRange) (normalized []VolumeRange, err error) {
+	systemDrive := strings.ToLower(strings.TrimSuffix(os.Getenv("SYSTEMDRIVE"), ":"))
+	for _, volumeRange := range ranges {
+		volumeRange.Volume = strings.TrimSuffix(strings.ToLower(volumeRange.Volume), ":")
+		volumeRange.Volume = strings.Replace(volumeRange.Volume, "%systemdrive%", systemDrive, 1)
+		if !driveLetter.MatchString(volumeRange.Volume) {
+			err = fmt.Errorf("the range's volume '%s' isn't a drive letter", volumeRange.Volume)
+			return nil, err
+		}
+		if volumeRange.Offset < 0 || volumeRange.Length <= 0 {
+			err = fmt.Errorf("the range of %d at %d on volume %s is invalid", volumeRange.Length, volumeRange.Offset, volumeRange.Volume)
+			return nil, err
+		}
+		normalized = append(normalized, volumeRange)
+	}
+	return
+}
+
+// addRangeVolumes adds the volumes of the ranges that nothing else is collected from.
+func addRangeVolumes(volumesOfInterest []string, ranges []VolumeRange) []string {
+	for _, volumeRange := range ranges {
+		tracked := false
+		for _, volumeOfInterest := range volumesOfInterest {
+			if volumeOfInterest == volumeRange.Volume {
+				tracked = true
+				break
+			}
+		}
+		if !tracked {
+			volumesOfInterest = append(volumesOfInterest, volumeRange.Volume)
+		}
+	}
+	return volumesOfInterest
+}
+
+// collectRanges writes the Ranges on a volume into the output, each read through its own handle to the volume.
+func (collector *Collector) collectRanges(ctx context.Context, volumeHandler *VolumeHandler, fileReaders chan fileReader) (err error) {
+	for _, volumeRange := range collector.Options.Ranges {
+		if volumeRange.Volume != strings.ToLower(volumeHandler.VolumeLetter) {
+			continue
+		}
+		offset, length := volumeRange.Offset, volumeRange.Length
+		if volumeRange.Clusters {
+			offset, length = offset*volumeHandler.Vbr.BytesPerCluster, length*volumeHandler.Vbr.BytesPerCluster
+		}
+		outputPath := fmt.Sprintf(`%s\%s\%d-%d%s`, rangesDirectory, volumeRange.Volume, offset, length, rangeOutputExtension)
+		rangeHandler, handleErr := volumeHandler.duplicate()
+		if handleErr != nil {
+			collector.report.fileFailed(outputPath, volumeHandler.VolumeLetter, fmt.Errorf("failed to open the volume to read the range: %w", handleErr))
+			continue
+		}
+		volumeHandler.logger().Debugf("Reading %d bytes at offset %d of volume %s into '%s'.", length, offset, volumeHandler.VolumeLetter, outputPath)
+		reader := &closingReader{file: newVolumeRangeReader(rangeHandler.Handle, offset, length, volumeHandler.Vbr.BytesPerSector)}
+		collector.report.addMatches(volumeHandler.VolumeLetter, 1)
+		err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(fileReader{
+			fullPath: outputPath,
+			method:   readMethodRaw,
+			reader: collector.instrumentReader(ctx, reader, Progress{
+				Stage:        StageCopy,
+				VolumeLetter: volumeHandler.VolumeLetter,
+				FileName:     outputPath,
+				TotalBytes:   length,
+			}),
+		}, volumeHandler.VolumeLetter))
+		if err != nil {
+			_ = rangeHandler.Handle.Close()
+			return
+		}
+	}
+	return
+}
+
+// rangesFailed reports the ranges on a volume that can't be read raw as failed.
+func (collector *Collector) rangesFailed(volumeLetter string, reason error) {
+	for _, volumeRange := range collector.Options.Ranges {
+		if volumeRange.Volume == strings.ToLower(volumeLetter) && collector.planner == nil {
+			outputPath := fmt.Sprintf(`%s\%s\%d-%d%s`, rangesDirectory, volumeRange.Volume, volumeRange.Offset, volumeRange.Length, rangeOutputExtension)
+			collector.report.fileFailed(outputPath, volumeLetter, reason)
+		}
+	}
+}
+
+// hasFilesToFind reports whether the MFT of a volume needs searching, which it doesn't when only ranges are collected.
+func hasFilesToFind(volumeLetter string, searchTerms listOfSearchTerms, options CollectOptions) bool {
+	if len(searchTerms) != 0 {
+		return true
+	}
+	for _, directory := range options.IndexDirectories {
+		if strings.HasPrefix(strings.ToLower(directory.Path), volumeLetter+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeRangeReader reads a range of a raw volume, which only takes reads of whole sectors at sector boundaries, by
+// reading the sectors the range covers and handing out the part that was asked for.
+type volumeRangeReader struct {
+	volume     *os.File
+	offset     int64 // of the next byte to return
+	end        int64
+	sectorSize int64
+}
+
+func newVolumeRangeReader(volume *os.File, offset int64, length int64, sectorSize int64) *volumeRangeReader {
+	if sectorSize <= 0 {
+		sectorSize = fallbackSectorSize
+	}
+	return &volumeRangeReader{volume: volume, offset: offset, end: offset + length, sectorSize: sectorSize}
+}
+
+func (reader *volumeRangeReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	if reader.offset >= reader.end {
+		return 0, io.EOF
+	}
+	wanted := int64(len(byteSliceToPopulate))
+	if wanted > reader.end-reader.offset {
+		wanted = reader.end - reader.offset
+	}
+	if wanted > maxRangeReadSize {
+		wanted = maxRangeReadSize
+	}
+	start := reader.offset - reader.offset%reader.sectorSize
+	stop := reader.offset + wanted
+	if remainder := stop % reader.sectorSize; remainder != 0 {
+		stop += reader.sectorSize - remainder
+	}
+	buffer := make([]byte, stop-start)
+	read, readErr := reader.volume.ReadAt(buffer, start)
+	skip := reader.offset - start
+	available := int64(read) - skip
+	if available <= 0 {
+		if readErr == nil || errors.Is(readErr, io.EOF) {
+			readErr = io.ErrUnexpectedEOF
+		}
+		err = fmt.Errorf("failed to read the volume at offset %d: %w", reader.offset, readErr)
+		return
+	}
+	if available > wanted {
+		available = wanted
+	}
+	numberOfBytesRead = copy(byteSliceToPopulate, buffer[skip:skip+available])
+	reader.offset += int64(numberOfBytesRead)
+	if reader.offset >= reader.end {
+		err = io.EOF
+	}
+	return
+}
+
+func (reader *volumeRangeReader) Close() error {
+	return reader.volume.Close()
+}
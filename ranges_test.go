@@ -0,0 +1,124 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_normalizeVolumeRanges(t *testing.T) {
+	defer os.Setenv("SYSTEMDRIVE", os.Getenv("SYSTEMDRIVE"))
+	os.Setenv("SYSTEMDRIVE", "C:")
+	tests := []struct {
+		name    string
+		ranges  []VolumeRange
+		want    []VolumeRange
+		wantErr bool
+	}{
+		{
+			name:   "drive letters",
+			ranges: []VolumeRange{{Volume: "C:", Offset: 0, Length: 512}, {Volume: "%SYSTEMDRIVE%", Offset: 100, Length: 8, Clusters: true}},
+			want:   []VolumeRange{{Volume: "c", Offset: 0, Length: 512}, {Volume: "c", Offset: 100, Length: 8, Clusters: true}},
+		},
+		{
+			name:    "not a drive letter",
+			ranges:  []VolumeRange{{Volume: `\\server\share`, Offset: 0, Length: 512}},
+			wantErr: true,
+		},
+		{
+			name:    "negative offset",
+			ranges:  []VolumeRange{{Volume: "c", Offset: -1, Length: 512}},
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			ranges:  []VolumeRange{{Volume: "c", Offset: 0, Length: 0}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeVolumeRanges(tt.ranges)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeVolumeRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeVolumeRanges() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_volumeRangeReader(t *testing.T) {
+	volume, err := ioutil.ReadFile(`test\testdata\dummyntfs`)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		offset  int64
+		length  int64
+		wantErr bool
+	}{
+		{name: "sector", offset: 0, length: 512},
+		{name: "unaligned", offset: 3, length: 8},
+		{name: "across sectors", offset: 500, length: 1100},
+		{name: "past the end", offset: int64(len(volume)) - 10, length: 20, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, err := os.Open(`test\testdata\dummyntfs`)
+			if err != nil {
+				t.Fatalf("os.Open() error = %v", err)
+			}
+			reader := newVolumeRangeReader(handle, tt.offset, tt.length, 512)
+			defer reader.Close()
+			got, err := ioutil.ReadAll(reader)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("volumeRangeReader.Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !bytes.Equal(got, volume[tt.offset:tt.offset+tt.length]) {
+				t.Errorf("volumeRangeReader.Read() = %d bytes that don't match the volume at %d", len(got), tt.offset)
+			}
+		})
+	}
+}
+
+func Test_collectRanges(t *testing.T) {
+	volumeHandler, err := GetVolumeHandler("c", dummyHandler{filePath: `test\testdata\dummyntfs`})
+	if err != nil {
+		t.Fatalf("GetVolumeHandler() error = %v", err)
+	}
+	defer volumeHandler.Handle.Close()
+	collector := &Collector{
+		Options: CollectOptions{Ranges: []VolumeRange{{Volume: "c", Offset: 3, Length: 8}, {Volume: "c", Offset: 1, Length: 1, Clusters: true}, {Volume: "d", Offset: 0, Length: 512}}},
+		report:  newReportBuilder(),
+	}
+	fileReaders := make(chan fileReader, 10)
+
+	err = collector.collectRanges(context.Background(), &volumeHandler, fileReaders)
+	close(fileReaders)
+	if err != nil {
+		t.Fatalf("collectRanges() error = %v", err)
+	}
+	got := make(map[string]int)
+	for file := range fileReaders {
+		data, _ := ioutil.ReadAll(file.reader)
+		got[file.fullPath] = len(data)
+		if file.fullPath == `ranges\c\3-8.bin` && string(data) != "NTFS    " {
+			t.Errorf("collectRanges() read %q at offset 3, want the NTFS signature", data)
+		}
+	}
+	want := map[string]int{`ranges\c\3-8.bin`: 8, `ranges\c\4096-4096.bin`: 4096}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectRanges() = %v, want %v", got, want)
+	}
+}
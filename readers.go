@@ -3,10 +3,24 @@
 package windowscollector
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	mft "github.com/Go-Forensics/MFT-Parser"
-	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
 	"io"
 	"os"
+	"strings"
+	"sync"
+)
+
+// How a file was read, as recorded in the collection report.
+const (
+	readMethodAPI        = "api"
+	readMethodRaw        = "raw"
+	readMethodHiveExport = "hive_export"
+	readMethodAcquired   = "acquired"
 )
 
 // DataRunsReader contains all the information needed to support the data runs reader function
@@ -27,7 +41,7 @@ func (dataRunReader *DataRunsReader) Read(byteSliceToPopulate []byte) (numberOfB
 	// Sanity checking
 	if len(dataRunReader.DataRuns) == 0 {
 		err = io.ErrUnexpectedEOF
-		log.Warnf("failed to read %s, received: %v", dataRunReader.fileName, err)
+		dataRunReader.VolumeHandler.logger().Warnf("failed to read %s, received: %v", dataRunReader.fileName, err)
 		return
 	}
 
@@ -45,18 +59,16 @@ func (dataRunReader *DataRunsReader) Read(byteSliceToPopulate []byte) (numberOfB
 		dataRunReader.initialized = true
 
 		// These are for debug purposes
-		if log.GetLevel() == log.DebugLevel {
-			totalSize := int64(0)
-			for _, dataRun := range dataRunReader.DataRuns {
-				totalSize += dataRun.Length
-			}
-			log.Debugf("Reading data run number 1 of %d for file '%s' which has a length of %d bytes at absolute offset %d",
-				len(dataRunReader.DataRuns),
-				dataRunReader.fileName,
-				totalSize,
-				dataRunReader.DataRuns[0].AbsoluteOffset,
-			)
+		totalSize := int64(0)
+		for _, dataRun := range dataRunReader.DataRuns {
+			totalSize += dataRun.Length
 		}
+		dataRunReader.VolumeHandler.logger().Debugf("Reading data run number 1 of %d for file '%s' which has a length of %d bytes at absolute offset %d",
+			len(dataRunReader.DataRuns),
+			dataRunReader.fileName,
+			totalSize,
+			dataRunReader.DataRuns[0].AbsoluteOffset,
+		)
 
 	}
 
@@ -76,7 +88,12 @@ func (dataRunReader *DataRunsReader) Read(byteSliceToPopulate []byte) (numberOfB
 	}
 	buffer := make([]byte, bufferSize)
 	dataRunReader.VolumeHandler.lastReadVolumeOffset += bufferSize
-	numberOfBytesRead, _ = dataRunReader.VolumeHandler.Handle.Read(buffer)
+	numberOfBytesRead, readErr := dataRunReader.VolumeHandler.readRetrying(buffer, dataRunReader.VolumeHandler.lastReadVolumeOffset)
+	if readErr != nil && !errors.Is(readErr, io.EOF) {
+		err = fmt.Errorf("failed to read %s at volume offset %d: %w", dataRunReader.fileName, dataRunReader.VolumeHandler.lastReadVolumeOffset, readErr)
+		numberOfBytesRead = 0
+		return
+	}
 	copy(byteSliceToPopulate, buffer)
 	dataRunReader.totalByesRead += bufferSize
 	if dataRunReader.totalFileSize == dataRunReader.totalByesRead {
@@ -88,6 +105,12 @@ func (dataRunReader *DataRunsReader) Read(byteSliceToPopulate []byte) (numberOfB
 	if dataRunReader.dataRunBytesLeftToReadTracker == 0 {
 		// Increment our tracker
 		dataRunReader.dataRunTracker++
+		if dataRunReader.dataRunTracker >= len(dataRunReader.DataRuns) {
+			// The data runs are shorter than the file size says, don't read past them
+			err = io.ErrUnexpectedEOF
+			dataRunReader.VolumeHandler.logger().Warnf("failed to read %s, its data runs end before the file does", dataRunReader.fileName)
+			return
+		}
 
 		// Get the size of the next datarun
 		dataRunReader.dataRunBytesLeftToReadTracker = dataRunReader.DataRuns[dataRunReader.dataRunTracker].Length
@@ -96,7 +119,7 @@ func (dataRunReader *DataRunsReader) Read(byteSliceToPopulate []byte) (numberOfB
 		dataRunReader.VolumeHandler.lastReadVolumeOffset, _ = dataRunReader.VolumeHandler.Handle.Seek(dataRunReader.DataRuns[dataRunReader.dataRunTracker].AbsoluteOffset, 0)
 		dataRunReader.VolumeHandler.lastReadVolumeOffset -= bufferSize
 
-		log.Debugf("Reading data run number %d of %d for file '%s' which has a length of %d bytes at absolute offset %d",
+		dataRunReader.VolumeHandler.logger().Debugf("Reading data run number %d of %d for file '%s' which has a length of %d bytes at absolute offset %d",
 			dataRunReader.dataRunTracker+1,
 			len(dataRunReader.DataRuns),
 			dataRunReader.fileName,
@@ -108,12 +131,74 @@ func (dataRunReader *DataRunsReader) Read(byteSliceToPopulate []byte) (numberOfB
 	return
 }
 
+// apiFileReader opens a file through the API with backup semantics, so files only their owner or SYSTEM may read, such
+// as other users' ntuser.dat or what's under System Volume Information, don't have to be read raw.
 func apiFileReader(file foundFile) (reader io.Reader, err error) {
-	reader, err = os.Open(file.fullPath)
+	reader, err = openWithBackupSemantics(file.fullPath)
+	return
+}
+
+// backupPrivilege enables the backup privilege the first time a file is opened with backup semantics.
+var backupPrivilege sync.Once
+
+// openWithBackupSemantics opens a file for reading with FILE_FLAG_BACKUP_SEMANTICS, which lets the backup privilege
+// get past the file's security, and shares it with whoever else has it open. Without the privilege it opens the file
+// like any other, going by the user's own rights. It's a variable so tests don't need a FAT volume.
+var openWithBackupSemantics = func(path string) (file *os.File, err error) {
+	const (
+		genericRead             = 0x80000000
+		fileShareAll            = 0x01 | 0x02 | 0x04 // FILE_SHARE_READ, FILE_SHARE_WRITE and FILE_SHARE_DELETE
+		openExisting            = 0x03
+		fileFlagBackupSemantics = 0x02000000
+	)
+	backupPrivilege.Do(func() {
+		_ = enableBackupPrivilege()
+	})
+	name, err := windows.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return
+	}
+	handle, err := windows.CreateFile(name, genericRead, fileShareAll, nil, openExisting, fileFlagBackupSemantics, 0)
+	if err != nil {
+		err = fmt.Errorf("failed to open '%s': %w", path, err)
+		return
+	}
+	file = os.NewFile(uintptr(handle), path)
+	return
+}
+
+// rawFirstReason explains why a file is read raw without trying the API first, or is empty when the API should be
+// tried. These are files Windows keeps open without sharing them, so opening them through the API only ever fails.
+func rawFirstReason(file foundFile) (reason string) {
+	fullPath := strings.ToLower(file.fullPath)
+	separator := strings.Index(fullPath, `\`)
+	if separator == -1 {
+		return
+	}
+	pathOnVolume := fullPath[separator+1:]
+	switch {
+	case strings.HasPrefix(pathOnVolume, "$"):
+		reason = "it's an NTFS metadata file"
+	case pathOnVolume == "pagefile.sys" || pathOnVolume == "swapfile.sys" || pathOnVolume == "hiberfil.sys":
+		reason = "Windows keeps it open while running"
+	default:
+		if _, ok := loadedHiveForPath(fullPath, nil); ok {
+			reason = "it's a loaded registry hive"
+		}
+	}
 	return
 }
 
+// rawFileReader reads a file from its data runs, or straight out of its MFT record when its data is resident.
 func rawFileReader(handler *VolumeHandler, file foundFile) (reader io.Reader) {
+	if file.resident {
+		reader = bytes.NewReader(file.residentData)
+		return
+	}
+	if file.stream != nil {
+		reader = newNtfsStreamReader(&retryingVolume{volume: handler}, handler.Vbr.BytesPerCluster, *file.stream)
+		return
+	}
 	reader = &DataRunsReader{
 		VolumeHandler:                 handler,
 		DataRuns:                      file.dataRuns,
@@ -125,3 +210,25 @@ func rawFileReader(handler *VolumeHandler, file foundFile) (reader io.Reader) {
 	}
 	return
 }
+
+// contextReader stops reading from the underlying reader once its context has been cancelled.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func newContextReader(ctx context.Context, reader io.Reader) io.Reader {
+	return &contextReader{
+		ctx:    ctx,
+		reader: reader,
+	}
+}
+
+func (contextReader *contextReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	err = contextReader.ctx.Err()
+	if err != nil {
+		return
+	}
+	numberOfBytesRead, err = contextReader.reader.Read(byteSliceToPopulate)
+	return
+}
@@ -3,6 +3,8 @@
 package windowscollector
 
 import (
+	"bytes"
+	"context"
 	mft "github.com/Go-Forensics/MFT-Parser"
 	vbr "github.com/Go-Forensics/VBR-Parser"
 	log "github.com/sirupsen/logrus"
@@ -137,3 +139,59 @@ func Test_apiFileReader(t *testing.T) {
 		})
 	}
 }
+
+func Test_rawFirstReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		fullPath string
+		want     bool
+	}{
+		{name: "mft", fullPath: `C:\$MFT`, want: true},
+		{name: "usn journal", fullPath: `c:\$Extend\$UsnJrnl:$J`, want: true},
+		{name: "pagefile", fullPath: `c:\pagefile.sys`, want: true},
+		{name: "system hive", fullPath: `c:\Windows\System32\config\SYSTEM`, want: true},
+		{name: "other user's ntuser.dat", fullPath: `c:\users\bob\ntuser.dat`},
+		{name: "pagefile in a directory", fullPath: `c:\backup\pagefile.sys`},
+		{name: "system volume information", fullPath: `c:\System Volume Information\Syscache.hve`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rawFirstReason(foundFile{fullPath: tt.fullPath}); (got != "") != tt.want {
+				t.Errorf("rawFirstReason() = %q, want a reason %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_contextReader_Read(t *testing.T) {
+	tests := []struct {
+		name    string
+		cancel  bool
+		wantErr error
+	}{
+		{
+			name:    "live context",
+			cancel:  false,
+			wantErr: nil,
+		},
+		{
+			name:    "cancelled context",
+			cancel:  true,
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			reader := newContextReader(ctx, bytes.NewReader([]byte{0x00, 0x01}))
+			_, err := reader.Read(make([]byte, 2))
+			if err != tt.wantErr {
+				t.Errorf("contextReader.Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"fmt"
+	"io"
+)
+
+// ReadPolicy is how the files of a target are read: through the API, raw from the volume, or one with the other to
+// fall back on. Reading raw doesn't update access times or go past the minifilter drivers of endpoint products, while
+// the API gets what's in the cache for files that are being written to.
+type ReadPolicy string
+
+// The read policies. The $MFT is always read raw since the search needs it, and volumes that can't be read raw, such as
+// FAT volumes and shares, are only read through the API.
+const (
+	ReadAPIFirst ReadPolicy = "api_first" // the default, raw when the API can't open the file
+	ReadRawFirst ReadPolicy = "raw_first" // through the API when the raw read fails, which spools each raw copy to find out
+	ReadRawOnly  ReadPolicy = "raw_only"  // files that can't be read raw fail
+	ReadAPIOnly  ReadPolicy = "api_only"  // files the API can't open fail
+)
+
+// validate checks a read policy is one of the read policies. Empty is left for the default.
+func (policy ReadPolicy) validate() (err error) {
+	switch policy {
+	case "", ReadAPIFirst, ReadRawFirst, ReadRawOnly, ReadAPIOnly:
+		return
+	}
+	err = fmt.Errorf("unknown read policy '%s'", policy)
+	return
+}
+
+// readPolicyFor is the read policy for a file: raw only with a minimal footprint, else its target's, else the
+// collection's, else API first.
+func (options CollectOptions) readPolicyFor(file foundFile) ReadPolicy {
+	if options.MinimalFootprint {
+		return ReadRawOnly
+	}
+	if file.readPolicy != "" {
+		return file.readPolicy
+	}
+	if options.ReadPolicy != "" {
+		return options.ReadPolicy
+	}
+	return ReadAPIFirst
+}
+
+// openAPIOnly opens a file through the API, failing it when that doesn't work.
+func openAPIOnly(volumeHandler *VolumeHandler, file foundFile) (reader io.Reader, method string, fallback string) {
+	reader, apiErr := apiFileReader(file)
+	if apiErr != nil {
+		volumeHandler.logger().Debugf("Failed to open '%s' through the API and its read policy doesn't allow reading it raw: %v", file.fullPath, apiErr)
+		return &failedReader{err: apiErr}, readMethodAPI, ""
+	}
+	return reader, readMethodAPI, ""
+}
+
+// openRawFirst reads a file raw, spooling it to find out whether that worked, and opens it through the API instead
+// when it didn't. fallback is why the raw read failed.
+func (collector *Collector) openRawFirst(volumeHandler *VolumeHandler, file foundFile) (reader io.Reader, method string, fallback string) {
+	if collector.Options.APIFallback {
+		if hive, ok := loadedHiveForPath(file.fullPath, collector.userProfiles); ok {
+			return readHiveWithFallback(volumeHandler.logger(), rawFileReader(volumeHandler, file), hive)
+		}
+	}
+	// Resident data is already in memory, there's nothing that can fail reading it
+	if file.resident {
+		return rawFileReader(volumeHandler, file), readMethodRaw, ""
+	}
+	spooled, rawErr := spoolFile(rawFileReader(volumeHandler, file))
+	if rawErr == nil {
+		return spooled, readMethodRaw, ""
+	}
+
+	volumeHandler.logger().Debugf("Reading '%s' raw failed, opening it through the API instead: %v", file.fullPath, rawErr)
+	fallback = rawErr.Error()
+	method = readMethodAPI
+	reader, apiErr := apiFileReader(file)
+	if apiErr != nil {
+		reader = &failedReader{err: fmt.Errorf("reading the file raw failed with '%v' and opening it through the API failed: %w", rawErr, apiErr)}
+	}
+	return
+}
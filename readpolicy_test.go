@@ -0,0 +1,85 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadPolicy_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ReadPolicy
+		wantErr bool
+	}{
+		{name: "default", policy: ""},
+		{name: "raw only", policy: ReadRawOnly},
+		{name: "api only", policy: ReadAPIOnly},
+		{name: "unknown", policy: "raw_sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ReadPolicy.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_openFoundFile_readPolicy(t *testing.T) {
+	defer func(original func(path string) (*os.File, error)) { openWithBackupSemantics = original }(openWithBackupSemantics)
+	resident := foundFile{fullPath: `c:\users\bob\notes.txt`, resident: true, residentData: []byte("raw")}
+	// Without data runs the raw read fails straight away
+	unreadable := foundFile{fullPath: `c:\users\bob\notes.txt`}
+	deleted := resident
+	deleted.deleted = true
+	targetAPIFirst := resident
+	targetAPIFirst.readPolicy = ReadAPIFirst
+	tests := []struct {
+		name         string
+		file         foundFile
+		policy       ReadPolicy
+		openErr      error
+		wantOpened   bool
+		wantMethod   string
+		wantFallback bool
+		wantErr      bool
+	}{
+		{name: "api first", file: resident, wantOpened: true, wantMethod: readMethodAPI},
+		{name: "raw only", file: resident, policy: ReadRawOnly, wantMethod: readMethodRaw},
+		{name: "raw first", file: resident, policy: ReadRawFirst, wantMethod: readMethodRaw},
+		{name: "raw first falls back on the api", file: unreadable, policy: ReadRawFirst, wantOpened: true, wantMethod: readMethodAPI, wantFallback: true},
+		{name: "raw first and the api fails", file: unreadable, policy: ReadRawFirst, openErr: errors.New("access denied"), wantOpened: true, wantMethod: readMethodAPI, wantFallback: true, wantErr: true},
+		{name: "api only", file: resident, policy: ReadAPIOnly, wantOpened: true, wantMethod: readMethodAPI},
+		{name: "api only and the api fails", file: resident, policy: ReadAPIOnly, openErr: errors.New("sharing violation"), wantOpened: true, wantMethod: readMethodAPI, wantErr: true},
+		{name: "api only deleted file", file: deleted, policy: ReadAPIOnly, wantMethod: readMethodAPI, wantErr: true},
+		{name: "target overrides the collection", file: targetAPIFirst, policy: ReadRawOnly, wantOpened: true, wantMethod: readMethodAPI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened := false
+			openWithBackupSemantics = func(path string) (file *os.File, err error) {
+				opened = true
+				if tt.openErr != nil {
+					return nil, tt.openErr
+				}
+				return os.Open(`test\testdata\dummyntfs`)
+			}
+			reader, method, fallback := (&Collector{Options: CollectOptions{ReadPolicy: tt.policy}}).openFoundFile(&VolumeHandler{}, tt.file)
+			_, err := ioutil.ReadAll(reader)
+			if closer, ok := reader.(io.Closer); ok {
+				closer.Close()
+			}
+			if opened != tt.wantOpened || method != tt.wantMethod || (fallback != "") != tt.wantFallback {
+				t.Errorf("openFoundFile() opened through the api %v with method %v and fallback %q, want %v, %v and a fallback %v", opened, method, fallback, tt.wantOpened, tt.wantMethod, tt.wantFallback)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("reading the file error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows/registry"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// RegistryKey is a registry key read live through the registry API and written into the output as
+// registry/<Name>.json, a JSON array of the key and its subkeys with their values.
+type RegistryKey struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`  // e.g. HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Run, where a * matches every subkey
+	Depth int    `json:"depth"` // how many levels of subkeys to read under the key, zero for only the key itself
+}
+
+// ExportedRegistryKey is a key as it was read from the registry.
+type ExportedRegistryKey struct {
+	Path          string          `json:"path"`
+	LastWriteTime time.Time       `json:"last_write_time"`
+	Values        []RegistryValue `json:"values"`
+}
+
+// RegistryValue is one of a key's values. Strings, multi-strings and integers are decoded, anything else is left as
+// base64.
+type RegistryValue struct {
+	Name string      `json:"name"` // empty for the key's default value
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// DefaultRegistryKeys cover autoruns, services and what users typed and plugged in.
+var DefaultRegistryKeys = []RegistryKey{
+	{Name: "run", Path: `HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Run`},
+	{Name: "run_once", Path: `HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\RunOnce`},
+	{Name: "run_wow64", Path: `HKLM\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Run`},
+	{Name: "user_run", Path: `HKU\*\Software\Microsoft\Windows\CurrentVersion\Run`},
+	{Name: "user_run_once", Path: `HKU\*\Software\Microsoft\Windows\CurrentVersion\RunOnce`},
+	{Name: "winlogon", Path: `HKLM\SOFTWARE\Microsoft\Windows NT\CurrentVersion\Winlogon`},
+	{Name: "services", Path: `HKLM\SYSTEM\CurrentControlSet\Services`, Depth: 2},
+	{Name: "typed_paths", Path: `HKU\*\Software\Microsoft\Windows\CurrentVersion\Explorer\TypedPaths`},
+	{Name: "usbstor", Path: `HKLM\SYSTEM\CurrentControlSet\Enum\USBSTOR`, Depth: 2},
+	{Name: "usb", Path: `HKLM\SYSTEM\CurrentControlSet\Enum\USB`, Depth: 2},
+	{Name: "mounted_devices", Path: `HKLM\SYSTEM\MountedDevices`},
+}
+
+// RegistryAcquirers returns an Acquirer for each key. Unlike copying the hive files, this gets what is in the registry
+// right now, including volatile keys and what hasn't been flushed to disk.
+func RegistryAcquirers(keys []RegistryKey) (acquirers []Acquirer, err error) {
+	names := make(map[string]bool)
+	for _, key := range keys {
+		name := strings.ToLower(key.Name)
+		switch {
+		case !isOutputName(name):
+			err = fmt.Errorf("the registry key name '%s' can't be used as a file name", key.Name)
+		case names[name]:
+			err = fmt.Errorf("more than one registry key is named '%s'", key.Name)
+		case key.Depth < 0:
+			err = fmt.Errorf("the registry key '%s' has a negative depth", key.Name)
+		}
+		if err == nil {
+			_, _, err = splitRegistryPath(key.Path)
+		}
+		if err != nil {
+			acquirers = nil
+			return
+		}
+		names[name] = true
+		key := key
+		acquirers = append(acquirers, &volatileAcquirer{
+			name: fmt.Sprintf("registry/%s.json", key.Name),
+			gather: func(ctx context.Context, logger Logger) (interface{}, error) {
+				return exportRegistryKey(logger, liveRegistry{logger: logger}, key.Path, key.Depth)
+			},
+		})
+	}
+	return
+}
+
+// registryRoots are the names a registry path can start with.
+var registryRoots = map[string]registry.Key{
+	"HKLM":               registry.LOCAL_MACHINE,
+	"HKEY_LOCAL_MACHINE": registry.LOCAL_MACHINE,
+	"HKU":                registry.USERS,
+	"HKEY_USERS":         registry.USERS,
+	"HKCU":               registry.CURRENT_USER,
+	"HKEY_CURRENT_USER":  registry.CURRENT_USER,
+	"HKCR":               registry.CLASSES_ROOT,
+	"HKEY_CLASSES_ROOT":  registry.CLASSES_ROOT,
+}
+
+// splitRegistryPath splits a path such as HKLM\SOFTWARE into its root key and the path under it.
+func splitRegistryPath(path string) (root registry.Key, subPath string, err error) {
+	rootName := path
+	if index := strings.Index(path, `\`); index >= 0 {
+		rootName, subPath = path[:index], strings.Trim(path[index+1:], `\`)
+	}
+	root, found := registryRoots[strings.ToUpper(rootName)]
+	if !found {
+		err = fmt.Errorf("the registry path '%s' doesn't start with HKLM, HKU, HKCU or HKCR", path)
+	}
+	return
+}
+
+// registryTree reads keys by their full path. It's an interface so tests don't depend on the host's registry.
+type registryTree interface {
+	subKeyNames(path string) (names []string, err error)
+	readKey(path string) (key ExportedRegistryKey, err error)
+}
+
+// exportRegistryKey reads the key at path, expanding any * in it, and its subkeys down to depth. Keys that don't
+// exist are left out. A subkey that can't be read is skipped, but not being able to read a key that was asked for is
+// an error.
+func exportRegistryKey(logger Logger, tree registryTree, path string, depth int) (keys []ExportedRegistryKey, err error) {
+	paths := []string{""}
+	for _, component := range strings.Split(strings.Trim(path, `\`), `\`) {
+		var expanded []string
+		for _, parent := range paths {
+			if component != "*" {
+				expanded = append(expanded, strings.TrimPrefix(parent+`\`+component, `\`))
+				continue
+			}
+			names, namesErr := tree.subKeyNames(parent)
+			if errors.Is(namesErr, registry.ErrNotExist) {
+				continue
+			} else if namesErr != nil {
+				err = fmt.Errorf("failed to list the subkeys of %s: %w", parent, namesErr)
+				return
+			}
+			for _, name := range names {
+				expanded = append(expanded, parent+`\`+name)
+			}
+		}
+		paths = expanded
+	}
+
+	keys = make([]ExportedRegistryKey, 0)
+	for _, keyPath := range paths {
+		key, readErr := tree.readKey(keyPath)
+		if errors.Is(readErr, registry.ErrNotExist) {
+			continue
+		} else if readErr != nil {
+			err = fmt.Errorf("failed to read %s: %w", keyPath, readErr)
+			return
+		}
+		keys = append(keys, key)
+		keys = append(keys, exportSubKeys(logger, tree, keyPath, depth)...)
+	}
+	return
+}
+
+// exportSubKeys reads the subkeys under path down to depth, skipping any that can't be read.
+func exportSubKeys(logger Logger, tree registryTree, path string, depth int) (keys []ExportedRegistryKey) {
+	if depth == 0 {
+		return
+	}
+	names, err := tree.subKeyNames(path)
+	if err != nil {
+		logger.Debugf("Failed to list the subkeys of %s: %v", path, err)
+		return
+	}
+	for _, name := range names {
+		subKeyPath := path + `\` + name
+		key, err := tree.readKey(subKeyPath)
+		if err != nil {
+			logger.Debugf("Failed to read %s: %v", subKeyPath, err)
+			continue
+		}
+		keys = append(keys, key)
+		keys = append(keys, exportSubKeys(logger, tree, subKeyPath, depth-1)...)
+	}
+	return
+}
+
+// liveRegistry reads the host's registry.
+type liveRegistry struct {
+	logger Logger
+}
+
+func (liveRegistry) open(path string, access uint32) (key registry.Key, err error) {
+	root, subPath, err := splitRegistryPath(path)
+	if err != nil {
+		return
+	}
+	return registry.OpenKey(root, subPath, access)
+}
+
+func (tree liveRegistry) subKeyNames(path string) (names []string, err error) {
+	key, err := tree.open(path, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return
+	}
+	defer key.Close()
+	return key.ReadSubKeyNames(-1)
+}
+
+func (tree liveRegistry) readKey(path string) (exported ExportedRegistryKey, err error) {
+	key, err := tree.open(path, registry.QUERY_VALUE)
+	if err != nil {
+		return
+	}
+	defer key.Close()
+	exported = ExportedRegistryKey{Path: path, Values: make([]RegistryValue, 0)}
+	if info, statErr := key.Stat(); statErr == nil {
+		exported.LastWriteTime = info.ModTime().UTC()
+	}
+	valueNames, err := key.ReadValueNames(-1)
+	if err != nil {
+		return
+	}
+	data := make([]byte, 1024)
+	for _, valueName := range valueNames {
+		size, valueType, valueErr := key.GetValue(valueName, data)
+		if valueErr == registry.ErrShortBuffer {
+			data = make([]byte, size)
+			size, valueType, valueErr = key.GetValue(valueName, data)
+		}
+		if valueErr != nil {
+			tree.logger.Debugf("Failed to read the value '%s' of %s: %v", valueName, path, valueErr)
+			continue
+		}
+		typeName, value := registryValueData(valueType, data[:size])
+		exported.Values = append(exported.Values, RegistryValue{Name: valueName, Type: typeName, Data: value})
+	}
+	return
+}
+
+// registryValueTypes are the names of the registry value types from winnt.h.
+var registryValueTypes = map[uint32]string{
+	registry.NONE:                       "REG_NONE",
+	registry.SZ:                         "REG_SZ",
+	registry.EXPAND_SZ:                  "REG_EXPAND_SZ",
+	registry.BINARY:                     "REG_BINARY",
+	registry.DWORD:                      "REG_DWORD",
+	registry.DWORD_BIG_ENDIAN:           "REG_DWORD_BIG_ENDIAN",
+	registry.LINK:                       "REG_LINK",
+	registry.MULTI_SZ:                   "REG_MULTI_SZ",
+	registry.RESOURCE_LIST:              "REG_RESOURCE_LIST",
+	registry.FULL_RESOURCE_DESCRIPTOR:   "REG_FULL_RESOURCE_DESCRIPTOR",
+	registry.RESOURCE_REQUIREMENTS_LIST: "REG_RESOURCE_REQUIREMENTS_LIST",
+	registry.QWORD:                      "REG_QWORD",
+}
+
+// registryValueData decodes a value's data by its type. Data that doesn't fit its type, which the registry allows, is
+// left as bytes.
+func registryValueData(valueType uint32, data []byte) (typeName string, value interface{}) {
+	typeName, found := registryValueTypes[valueType]
+	if !found {
+		typeName = fmt.Sprintf("REG_UNKNOWN_%d", valueType)
+	}
+	utf16Data := func() []uint16 {
+		characters := make([]uint16, len(data)/2)
+		for index := range characters {
+			characters[index] = binary.LittleEndian.Uint16(data[index*2:])
+		}
+		return characters
+	}
+	switch {
+	case (valueType == registry.SZ || valueType == registry.EXPAND_SZ || valueType == registry.LINK) && len(data)%2 == 0:
+		return typeName, strings.TrimRight(string(utf16.Decode(utf16Data())), "\x00")
+	case valueType == registry.MULTI_SZ && len(data)%2 == 0:
+		values := make([]string, 0)
+		for _, characters := range splitUTF16Strings(utf16Data()) {
+			values = append(values, string(utf16.Decode(characters)))
+		}
+		return typeName, values
+	case valueType == registry.DWORD && len(data) == 4:
+		return typeName, binary.LittleEndian.Uint32(data)
+	case valueType == registry.DWORD_BIG_ENDIAN && len(data) == 4:
+		return typeName, binary.BigEndian.Uint32(data)
+	case valueType == registry.QWORD && len(data) == 8:
+		return typeName, binary.LittleEndian.Uint64(data)
+	}
+	return typeName, data
+}
+
+// splitUTF16Strings splits a REG_MULTI_SZ into its strings, which end at the first empty one.
+func splitUTF16Strings(characters []uint16) (values [][]uint16) {
+	start := 0
+	for index, character := range characters {
+		if character != 0 {
+			continue
+		}
+		if index == start {
+			return
+		}
+		values = append(values, characters[start:index])
+		start = index + 1
+	}
+	if start < len(characters) {
+		values = append(values, characters[start:])
+	}
+	return
+}
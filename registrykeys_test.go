@@ -0,0 +1,223 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"golang.org/x/sys/windows/registry"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry is a registry of key paths, where a key listed as denied can't be read.
+type fakeRegistry struct {
+	keys   map[string][]RegistryValue
+	denied map[string]bool
+}
+
+func (tree fakeRegistry) subKeyNames(path string) (names []string, err error) {
+	if _, found := tree.keys[path]; !found && path != "" {
+		err = registry.ErrNotExist
+		return
+	}
+	for keyPath := range tree.keys {
+		if strings.HasPrefix(keyPath, path+`\`) && !strings.Contains(keyPath[len(path)+1:], `\`) {
+			names = append(names, keyPath[len(path)+1:])
+		} else if path == "" && !strings.Contains(keyPath, `\`) {
+			names = append(names, keyPath)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+func (tree fakeRegistry) readKey(path string) (key ExportedRegistryKey, err error) {
+	values, found := tree.keys[path]
+	switch {
+	case tree.denied[path]:
+		err = errors.New("access denied")
+	case !found:
+		err = registry.ErrNotExist
+	default:
+		key = ExportedRegistryKey{Path: path, Values: values}
+	}
+	return
+}
+
+func Test_exportRegistryKey(t *testing.T) {
+	tree := fakeRegistry{
+		keys: map[string][]RegistryValue{
+			`HKU`:                          nil,
+			`HKU\S-1-5-21-1`:               nil,
+			`HKU\S-1-5-21-1\Software`:      nil,
+			`HKU\S-1-5-21-1\Software\Run`:  {{Name: "updater", Type: "REG_SZ", Data: `C:\updater.exe`}},
+			`HKU\S-1-5-21-1_Classes`:       nil,
+			`HKU\S-1-5-21-2`:               nil,
+			`HKU\S-1-5-21-2\Software`:      nil,
+			`HKU\S-1-5-21-2\Software\Run`:  {{Name: "chat", Type: "REG_SZ", Data: `C:\chat.exe`}},
+			`HKLM`:                         nil,
+			`HKLM\Services`:                nil,
+			`HKLM\Services\evil`:           {{Name: "ImagePath", Type: "REG_EXPAND_SZ", Data: `C:\evil.sys`}},
+			`HKLM\Services\evil\Params`:    {{Name: "ServiceDll", Type: "REG_EXPAND_SZ", Data: `C:\evil.dll`}},
+			`HKLM\Services\evil\Params\x`:  nil,
+			`HKLM\Services\locked`:         nil,
+			`HKLM\Services\locked\Params`:  nil,
+			`HKLM\Services\Tcpip`:          nil,
+			`HKLM\Services\Tcpip\Linkage`:  nil,
+			`HKLM\Services\Tcpip\Linkage2`: nil,
+		},
+		denied: map[string]bool{`HKLM\Services\locked`: true, `HKLM\Denied`: true},
+	}
+	paths := func(keys []ExportedRegistryKey) (paths []string) {
+		for _, key := range keys {
+			paths = append(paths, key.Path)
+		}
+		return
+	}
+	tests := []struct {
+		name    string
+		path    string
+		depth   int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "wildcard",
+			path: `HKU\*\Software\Run`,
+			want: []string{`HKU\S-1-5-21-1\Software\Run`, `HKU\S-1-5-21-2\Software\Run`},
+		},
+		{
+			name:  "depth",
+			path:  `HKLM\Services`,
+			depth: 2,
+			want:  []string{`HKLM\Services`, `HKLM\Services\Tcpip`, `HKLM\Services\Tcpip\Linkage`, `HKLM\Services\Tcpip\Linkage2`, `HKLM\Services\evil`, `HKLM\Services\evil\Params`},
+		},
+		{
+			name: "missing",
+			path: `HKLM\Missing\*`,
+		},
+		{
+			name:    "denied",
+			path:    `HKLM\Denied`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := exportRegistryKey(loggerOrDefault(nil), tree, tt.path, tt.depth)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("exportRegistryKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotPaths := paths(got); !reflect.DeepEqual(gotPaths, tt.want) {
+				t.Errorf("exportRegistryKey() = %v, want %v", gotPaths, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryAcquirers(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []RegistryKey
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name:      "defaults",
+			keys:      DefaultRegistryKeys[:2],
+			wantNames: []string{"registry/run.json", "registry/run_once.json"},
+		},
+		{
+			name:    "bad root",
+			keys:    []RegistryKey{{Name: "run", Path: `HKEY_PERFORMANCE_DATA\Run`}},
+			wantErr: true,
+		},
+		{
+			name:    "bad name",
+			keys:    []RegistryKey{{Name: "run/once", Path: `HKLM\SOFTWARE`}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			keys:    []RegistryKey{{Name: "Run", Path: `HKLM\SOFTWARE`}, {Name: "run", Path: `HKCU\Software`}},
+			wantErr: true,
+		},
+		{
+			name:    "negative depth",
+			keys:    []RegistryKey{{Name: "run", Path: `HKLM\SOFTWARE`, Depth: -1}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acquirers, err := RegistryAcquirers(tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RegistryAcquirers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, acquirer := range acquirers {
+				names = append(names, acquirer.Name())
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("RegistryAcquirers() names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func Test_splitRegistryPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		wantRoot    registry.Key
+		wantSubPath string
+		wantErr     bool
+	}{
+		{path: `HKLM\SOFTWARE\Microsoft\`, wantRoot: registry.LOCAL_MACHINE, wantSubPath: `SOFTWARE\Microsoft`},
+		{path: `hkey_users\S-1-5-18`, wantRoot: registry.USERS, wantSubPath: `S-1-5-18`},
+		{path: `HKCU`, wantRoot: registry.CURRENT_USER},
+		{path: `SOFTWARE\Microsoft`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			root, subPath, err := splitRegistryPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitRegistryPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (root != tt.wantRoot || subPath != tt.wantSubPath) {
+				t.Errorf("splitRegistryPath() = %v, %q, want %v, %q", root, subPath, tt.wantRoot, tt.wantSubPath)
+			}
+		})
+	}
+}
+
+func Test_registryValueData(t *testing.T) {
+	tests := []struct {
+		name      string
+		valueType uint32
+		data      []byte
+		wantType  string
+		want      interface{}
+	}{
+		{name: "string", valueType: registry.SZ, data: []byte{'C', 0, ':', 0, 0, 0}, wantType: "REG_SZ", want: "C:"},
+		{name: "expand string", valueType: registry.EXPAND_SZ, data: []byte{'%', 0, 'x', 0, '%', 0}, wantType: "REG_EXPAND_SZ", want: "%x%"},
+		{name: "odd length string", valueType: registry.SZ, data: []byte{'C', 0, ':'}, wantType: "REG_SZ", want: []byte{'C', 0, ':'}},
+		{name: "multi string", valueType: registry.MULTI_SZ, data: []byte{'a', 0, 0, 0, 'b', 0, 'c', 0, 0, 0, 0, 0}, wantType: "REG_MULTI_SZ", want: []string{"a", "bc"}},
+		{name: "empty multi string", valueType: registry.MULTI_SZ, data: []byte{0, 0}, wantType: "REG_MULTI_SZ", want: []string{}},
+		{name: "dword", valueType: registry.DWORD, data: []byte{2, 1, 0, 0}, wantType: "REG_DWORD", want: uint32(258)},
+		{name: "big endian dword", valueType: registry.DWORD_BIG_ENDIAN, data: []byte{0, 0, 1, 2}, wantType: "REG_DWORD_BIG_ENDIAN", want: uint32(258)},
+		{name: "qword", valueType: registry.QWORD, data: []byte{1, 0, 0, 0, 0, 0, 0, 1}, wantType: "REG_QWORD", want: uint64(0x0100000000000001)},
+		{name: "short dword", valueType: registry.DWORD, data: []byte{1}, wantType: "REG_DWORD", want: []byte{1}},
+		{name: "binary", valueType: registry.BINARY, data: []byte{0xde, 0xad}, wantType: "REG_BINARY", want: []byte{0xde, 0xad}},
+		{name: "unknown", valueType: 0x42, data: []byte{1}, wantType: "REG_UNKNOWN_66", want: []byte{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, got := registryValueData(tt.valueType, tt.data)
+			if gotType != tt.wantType || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("registryValueData() = %s %#v, want %s %#v", gotType, got, tt.wantType, tt.want)
+			}
+		})
+	}
+}
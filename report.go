@@ -0,0 +1,508 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Version is the collector's version as recorded in collection reports. Release builds set it with
+// -ldflags "-X github.com/Go-Forensics/Windows-Collector.Version=v1.2.3".
+var Version = "dev"
+
+const reportFileName = "report.json"
+
+// FileReport is what happened to a single matched file.
+type FileReport struct {
+	Path        string        `json:"path"`
+	Links       []string      `json:"links,omitempty"` // the file's other paths when it has hard links
+	Volume      string        `json:"volume"`
+	BytesRead   int64         `json:"bytes_read"`
+	Collected   bool          `json:"collected"`
+	Method      string        `json:"method,omitempty"`   // api, raw, hive_export or acquired
+	Fallback    string        `json:"fallback,omitempty"` // why reading the file raw failed, when a loaded hive was exported instead
+	Status      string        `json:"status"`             // collected, partial, failed, skipped, duplicate or not_read
+	Error       string        `json:"error,omitempty"`
+	Skipped     string        `json:"skipped,omitempty"`      // why a matched file was deliberately left out
+	Size        int64         `json:"size,omitempty"`         // the size of a skipped file
+	Metadata    *FileMetadata `json:"metadata,omitempty"`     // what the MFT holds about a skipped file
+	DuplicateOf string        `json:"duplicate_of,omitempty"` // the collected file with the same content, when this one was left out
+	Verified    string        `json:"verified,omitempty"`     // how the file compared with reading it again, when verifying
+}
+
+// VolumeReport describes a volume that was searched.
+type VolumeReport struct {
+	Letter              string          `json:"letter"`
+	FileSystem          string          `json:"file_system,omitempty"`
+	BitLocker           string          `json:"bitlocker,omitempty"` // off, unlocked, locked, unlocked_for_collection or unknown
+	BytesPerSector      int64           `json:"bytes_per_sector"`
+	BytesPerCluster     int64           `json:"bytes_per_cluster"`
+	MftByteOffset       int64           `json:"mft_byte_offset"`
+	MftRecordSize       int64           `json:"mft_record_size"`
+	FilesMatched        int             `json:"files_matched"`
+	Error               string          `json:"error,omitempty"`
+	USNJournal          *USNJournalMark `json:"usn_journal,omitempty"`          // where the change journal was before the MFT was read
+	FilesUnchanged      int             `json:"files_unchanged,omitempty"`      // matched files an incremental collection left out
+	FilesResumed        int             `json:"files_resumed,omitempty"`        // matched files an earlier run of a resumed collection wrote
+	IncrementalFallback string          `json:"incremental_fallback,omitempty"` // why an incremental collection collected every file
+	MFTFallback         string          `json:"mft_fallback,omitempty"`         // mft_mirror or api when the MFT's record 0 couldn't be parsed
+}
+
+// CollectionReport is a machine readable summary of a collection.
+type CollectionReport struct {
+	ToolVersion     string           `json:"tool_version"`
+	ToolSHA256      string           `json:"tool_sha256,omitempty"` // of the collector's executable
+	Hostname        string           `json:"hostname"`
+	Privileged      bool             `json:"privileged"`
+	Partial         bool             `json:"partial"`
+	StartTime       time.Time        `json:"start_time"`
+	EndTime         time.Time        `json:"end_time"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	FilesMatched    int              `json:"files_matched"`
+	FilesCollected  int              `json:"files_collected"`
+	BytesRead       int64            `json:"bytes_read"`
+	FilesDeferred   int              `json:"files_deferred,omitempty"` // left out to stay within the ByteBudget
+	Warnings        int              `json:"warnings,omitempty"`       // anti-forensic indicators listed in warnings.json
+	Mismatches      int              `json:"mismatches,omitempty"`     // collected files that didn't match reading them again, listed in verification.json
+	Volumes         []VolumeReport   `json:"volumes"`
+	Files           []FileReport     `json:"files"`
+	YaraMatches     []YaraMatch      `json:"yara_matches,omitempty"` // the YARA rules collected files matched
+	Host            *HostInfo        `json:"host,omitempty"`
+	Clock           *ClockInfo       `json:"clock,omitempty"`
+	Run             int              `json:"run,omitempty"` // which run of a resumed collection this was
+	Footprint       *FootprintReport `json:"footprint,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
+// reportBuilder gathers a CollectionReport while a collection runs. Its methods are safe to call from several
+// goroutines and do nothing on a nil reportBuilder, so callers don't need to check whether a report was asked for.
+type reportBuilder struct {
+	mutex  sync.Mutex
+	report CollectionReport
+	errors CollectionErrors
+	audit  *auditLog    // also gets what happens to each volume and file, when an audit log was asked for
+	resume *ResumeState // also gets the files that were written, when the collection can be resumed
+}
+
+func newReportBuilder() *reportBuilder {
+	hostname, _ := os.Hostname()
+	return &reportBuilder{
+		report: CollectionReport{
+			ToolVersion: Version,
+			ToolSHA256:  currentTool().SHA256,
+			Hostname:    hostname,
+			StartTime:   time.Now().UTC(),
+			Volumes:     make([]VolumeReport, 0),
+			Files:       make([]FileReport, 0),
+		},
+	}
+}
+
+func (builder *reportBuilder) addVolume(volume VolumeHandler) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Volumes = append(builder.report.Volumes, VolumeReport{
+		Letter:          volume.VolumeLetter,
+		FileSystem:      fileSystemNTFS,
+		BytesPerSector:  volume.Vbr.BytesPerSector,
+		BytesPerCluster: volume.Vbr.BytesPerCluster,
+		MftByteOffset:   volume.Vbr.MftByteOffset,
+		MftRecordSize:   volume.Vbr.MftRecordSize,
+	})
+	builder.audit.record(AuditVolumeOpened, volume.VolumeLetter, "", fmt.Sprintf("opened for raw reads, %d bytes per sector, %d per cluster, the MFT at offset %d", volume.Vbr.BytesPerSector, volume.Vbr.BytesPerCluster, volume.Vbr.MftByteOffset))
+}
+
+// addWalkedVolume adds a volume that has no MFT, so its files were found by walking its directories.
+func (builder *reportBuilder) addWalkedVolume(volumeLetter string, fileSystem string) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Volumes = append(builder.report.Volumes, VolumeReport{Letter: volumeLetter, FileSystem: fileSystem})
+	builder.audit.record(AuditVolumeFallback, volumeLetter, "", fmt.Sprintf("a %s volume has no MFT, walking its directories through the API", fileSystem))
+}
+
+func (builder *reportBuilder) addMatches(volumeLetter string, numberOfMatches int) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.FilesMatched += numberOfMatches
+	for index := range builder.report.Volumes {
+		if builder.report.Volumes[index].Letter == volumeLetter {
+			builder.report.Volumes[index].FilesMatched += numberOfMatches
+		}
+	}
+}
+
+func (builder *reportBuilder) setUSNJournal(volumeLetter string, mark USNJournalMark) {
+	builder.updateVolume(volumeLetter, func(volume *VolumeReport) { volume.USNJournal = &mark })
+}
+
+func (builder *reportBuilder) addUnchanged(volumeLetter string, numberOfFiles int) {
+	builder.updateVolume(volumeLetter, func(volume *VolumeReport) { volume.FilesUnchanged += numberOfFiles })
+}
+
+func (builder *reportBuilder) addResumed(volumeLetter string, numberOfFiles int) {
+	if numberOfFiles != 0 {
+		builder.updateVolume(volumeLetter, func(volume *VolumeReport) { volume.FilesResumed += numberOfFiles })
+	}
+}
+
+func (builder *reportBuilder) setIncrementalFallback(volumeLetter string, reason string) {
+	builder.updateVolume(volumeLetter, func(volume *VolumeReport) { volume.IncrementalFallback = reason })
+}
+
+func (builder *reportBuilder) setMFTFallback(volumeLetter string, fallback string) {
+	builder.updateVolume(volumeLetter, func(volume *VolumeReport) { volume.MFTFallback = fallback })
+	if builder != nil {
+		builder.audit.record(AuditVolumeFallback, volumeLetter, "", "the MFT's record 0 couldn't be parsed, falling back on "+fallback)
+	}
+}
+
+// updateVolume changes the report of a volume that addVolume has added.
+func (builder *reportBuilder) updateVolume(volumeLetter string, update func(volume *VolumeReport)) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	for index := range builder.report.Volumes {
+		if builder.report.Volumes[index].Letter == volumeLetter {
+			update(&builder.report.Volumes[index])
+		}
+	}
+}
+
+// setResume has the files that are written recorded in the resume state, and the report say which run this is.
+func (builder *reportBuilder) setResume(resume *ResumeState) {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.resume = resume
+	builder.report.Run = resume.Run()
+}
+
+func (builder *reportBuilder) setFootprint(footprint *FootprintReport) {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Footprint = footprint
+}
+
+func (builder *reportBuilder) addYaraMatches(matches []YaraMatch) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.YaraMatches = append(builder.report.YaraMatches, matches...)
+}
+
+func (builder *reportBuilder) setHost(host HostInfo) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Host = &host
+}
+
+func (builder *reportBuilder) setClock(clock ClockInfo) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Clock = &clock
+}
+
+// setPrivileges records whether the collector ran elevated and whether any volume had to be collected from through
+// the API, leaving the collection partial.
+func (builder *reportBuilder) setPrivileges(privileged bool, partial bool) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Privileged = privileged
+	builder.report.Partial = partial
+}
+
+func (builder *reportBuilder) setDeferred(numberOfFiles int) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.FilesDeferred = numberOfFiles
+}
+
+func (builder *reportBuilder) setWarnings(numberOfWarnings int) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Warnings = numberOfWarnings
+}
+
+func (builder *reportBuilder) setMismatches(numberOfFiles int) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Mismatches = numberOfFiles
+}
+
+// setVerification records how a collected file compared with reading it again.
+func (builder *reportBuilder) setVerification(fullPath string, volumeLetter string, status string) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	for index := range builder.report.Files {
+		file := &builder.report.Files[index]
+		if file.Path == fullPath && file.Volume == volumeLetter && file.Collected {
+			file.Verified = status
+		}
+	}
+}
+
+// trackFile adds a file to the report and wraps its reader so the report follows how much of it the result writer
+// read and whether it got to the end.
+func (builder *reportBuilder) trackFile(file fileReader, volumeLetter string) fileReader {
+	if builder == nil {
+		return file
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Files = append(builder.report.Files, FileReport{
+		Path:     file.fullPath,
+		Links:    file.links,
+		Volume:   volumeLetter,
+		Method:   file.method,
+		Fallback: file.fallback,
+	})
+	index := len(builder.report.Files) - 1
+	file.reader = &trackingReader{
+		reader:  file.reader,
+		builder: builder,
+		index:   index,
+	}
+	file.failed = func(err error) { builder.fileWriteFailed(index, err) }
+	file.written = func() { builder.fileWritten(index) }
+	return file
+}
+
+// fileWritten records a file the result writer has safely in its output in the resume state, if it was collected.
+func (builder *reportBuilder) fileWritten(index int) {
+	builder.mutex.Lock()
+	fileReport := builder.report.Files[index]
+	resume := builder.resume
+	builder.mutex.Unlock()
+	if fileReport.Collected && fileReport.Error == "" && fileReport.Volume != "" {
+		resume.fileWritten(fileReport.Volume, fileReport.Path, fileReport.BytesRead)
+	}
+}
+
+// fileWriteFailed records that the result writer couldn't write a file it was reading, so it isn't collected however
+// much of it was read.
+func (builder *reportBuilder) fileWriteFailed(index int, err error) {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	fileReport := &builder.report.Files[index]
+	if fileReport.Error != "" {
+		return
+	}
+	writeError := &WriteError{Err: err}
+	fileReport.Collected = false
+	fileReport.Error = writeError.Error()
+	builder.errors = append(builder.errors, &FileError{Path: fileReport.Path, Volume: fileReport.Volume, Err: writeError})
+	builder.audit.record(AuditFileFailed, fileReport.Volume, fileReport.Path, fmt.Sprintf("after %d bytes were read: %v", fileReport.BytesRead, writeError))
+}
+
+// fileSkipped records a matched file that was deliberately left out. Unlike a failed file it isn't an error.
+func (builder *reportBuilder) fileSkipped(fullPath string, volumeLetter string, size int64, metadata *FileMetadata, reason string) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Files = append(builder.report.Files, FileReport{
+		Path:     fullPath,
+		Volume:   volumeLetter,
+		Skipped:  reason,
+		Size:     size,
+		Metadata: metadata,
+	})
+	builder.audit.record(AuditFileSkipped, volumeLetter, fullPath, reason)
+}
+
+// fileDuplicate records a matched file that was left out since a file with the same content was already collected.
+func (builder *reportBuilder) fileDuplicate(fullPath string, volumeLetter string, original string) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Files = append(builder.report.Files, FileReport{
+		Path:        fullPath,
+		Volume:      volumeLetter,
+		DuplicateOf: original,
+	})
+	builder.audit.record(AuditFileSkipped, volumeLetter, fullPath, "a duplicate of "+original)
+}
+
+// fileFailed records a matched file that couldn't be read at all.
+func (builder *reportBuilder) fileFailed(fullPath string, volumeLetter string, err error) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.report.Files = append(builder.report.Files, FileReport{
+		Path:   fullPath,
+		Volume: volumeLetter,
+		Error:  err.Error(),
+	})
+	builder.errors = append(builder.errors, &FileError{Path: fullPath, Volume: volumeLetter, Err: err})
+	builder.audit.record(AuditFileFailed, volumeLetter, fullPath, err.Error())
+}
+
+// volumeFailed records a volume that couldn't be searched, or that failed part way through.
+func (builder *reportBuilder) volumeFailed(volumeLetter string, err error) {
+	if builder == nil {
+		return
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	builder.errors = append(builder.errors, &VolumeError{Volume: volumeLetter, Err: err})
+	builder.audit.record(AuditVolumeFailed, volumeLetter, "", err.Error())
+	var volume *VolumeReport
+	for index := range builder.report.Volumes {
+		if builder.report.Volumes[index].Letter == volumeLetter {
+			volume = &builder.report.Volumes[index]
+			break
+		}
+	}
+	if volume == nil {
+		builder.report.Volumes = append(builder.report.Volumes, VolumeReport{Letter: volumeLetter})
+		volume = &builder.report.Volumes[len(builder.report.Volumes)-1]
+	}
+	volume.Error = err.Error()
+	var lockedVolumeError *LockedVolumeError
+	if errors.As(err, &lockedVolumeError) {
+		volume.BitLocker = BitLockerLocked
+	}
+}
+
+// err returns every file and volume failure recorded so far as CollectionErrors, or nil if there weren't any.
+func (builder *reportBuilder) err() error {
+	if builder == nil {
+		return nil
+	}
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	if len(builder.errors) == 0 {
+		return nil
+	}
+	return append(CollectionErrors(nil), builder.errors...)
+}
+
+// snapshot returns a copy of the report with its totals and end time filled in as of now.
+func (builder *reportBuilder) snapshot() (report CollectionReport) {
+	builder.mutex.Lock()
+	defer builder.mutex.Unlock()
+	report = builder.report
+	report.Volumes = append([]VolumeReport(nil), builder.report.Volumes...)
+	report.Files = append([]FileReport(nil), builder.report.Files...)
+	report.YaraMatches = append([]YaraMatch(nil), builder.report.YaraMatches...)
+	report.FilesCollected = 0
+	report.BytesRead = 0
+	for index, file := range report.Files {
+		if file.Collected {
+			report.FilesCollected++
+		}
+		report.BytesRead += file.BytesRead
+		report.Files[index].Status = fileStatus(file)
+	}
+	report.EndTime = time.Now().UTC()
+	report.DurationSeconds = report.EndTime.Sub(report.StartTime).Seconds()
+	return
+}
+
+func fileStatus(file FileReport) string {
+	switch {
+	case file.Collected:
+		return "collected"
+	case file.Error != "" && file.BytesRead > 0:
+		return "partial"
+	case file.Error != "":
+		return "failed"
+	case file.Skipped != "":
+		return "skipped"
+	case file.DuplicateOf != "":
+		return "duplicate"
+	default:
+		return "not_read"
+	}
+}
+
+// reader returns an io.Reader that serializes the report when it is first read. Handing this to the result writer
+// last means the report covers every file written before it.
+func (builder *reportBuilder) reader() io.Reader {
+	return &lazyReader{open: func() io.Reader {
+		data, _ := json.MarshalIndent(builder.snapshot(), "", "  ")
+		return bytes.NewReader(data)
+	}}
+}
+
+type trackingReader struct {
+	reader  io.Reader
+	builder *reportBuilder
+	index   int
+}
+
+func (trackingReader *trackingReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = trackingReader.reader.Read(byteSliceToPopulate)
+	trackingReader.builder.mutex.Lock()
+	defer trackingReader.builder.mutex.Unlock()
+	fileReport := &trackingReader.builder.report.Files[trackingReader.index]
+	fileReport.BytesRead += int64(numberOfBytesRead)
+	if err == io.EOF && !fileReport.Collected && fileReport.Error == "" {
+		fileReport.Collected = true
+		trackingReader.builder.audit.record(AuditFileCollected, fileReport.Volume, fileReport.Path, collectedDetail(fileReport.BytesRead, fileReport.Method))
+	} else if err != nil && err != io.EOF && fileReport.Error == "" {
+		fileReport.Error = err.Error()
+		trackingReader.builder.errors = append(trackingReader.builder.errors, &FileError{Path: fileReport.Path, Volume: fileReport.Volume, Err: err})
+		trackingReader.builder.audit.record(AuditFileFailed, fileReport.Volume, fileReport.Path, fmt.Sprintf("after %d bytes: %v", fileReport.BytesRead, err))
+	}
+	return
+}
+
+// lazyReader defers building its content until the first read.
+type lazyReader struct {
+	open   func() io.Reader
+	reader io.Reader
+}
+
+func (lazyReader *lazyReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	if lazyReader.reader == nil {
+		lazyReader.reader = lazyReader.open()
+	}
+	numberOfBytesRead, err = lazyReader.reader.Read(byteSliceToPopulate)
+	return
+}
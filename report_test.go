@@ -0,0 +1,158 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_reportBuilder_trackFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		reader        io.Reader
+		wantCollected bool
+		wantBytesRead int64
+		wantStatus    string
+		wantError     bool
+	}{
+		{
+			name:          "read to the end",
+			reader:        bytes.NewReader(make([]byte, 10)),
+			wantCollected: true,
+			wantBytesRead: 10,
+			wantStatus:    "collected",
+			wantError:     false,
+		},
+		{
+			name:          "read error",
+			reader:        iotest.TimeoutReader(bytes.NewReader(make([]byte, 10))),
+			wantCollected: false,
+			wantBytesRead: 10,
+			wantStatus:    "partial",
+			wantError:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := newReportBuilder()
+			file := builder.trackFile(fileReader{fullPath: "test", reader: tt.reader}, "c")
+			_, _ = io.Copy(ioutil.Discard, file.reader)
+			report := builder.snapshot()
+			if len(report.Files) != 1 {
+				t.Fatalf("snapshot() has %d files, want 1", len(report.Files))
+			}
+			gotFile := report.Files[0]
+			if gotFile.Collected != tt.wantCollected {
+				t.Errorf("snapshot() Collected = %v, want %v", gotFile.Collected, tt.wantCollected)
+			}
+			if gotFile.BytesRead != tt.wantBytesRead {
+				t.Errorf("snapshot() BytesRead = %v, want %v", gotFile.BytesRead, tt.wantBytesRead)
+			}
+			if gotFile.Status != tt.wantStatus {
+				t.Errorf("snapshot() Status = %v, want %v", gotFile.Status, tt.wantStatus)
+			}
+			if (gotFile.Error != "") != tt.wantError {
+				t.Errorf("snapshot() Error = %v, wantError %v", gotFile.Error, tt.wantError)
+			}
+			if (builder.err() != nil) != tt.wantError {
+				t.Errorf("err() = %v, wantError %v", builder.err(), tt.wantError)
+			}
+		})
+	}
+}
+
+func Test_reportBuilder_nil(t *testing.T) {
+	var builder *reportBuilder
+	file := fileReader{fullPath: "test", reader: bytes.NewReader(nil)}
+	if got := builder.trackFile(file, "c"); !reflect.DeepEqual(got, file) {
+		t.Errorf("trackFile() on a nil reportBuilder should return the file untouched")
+	}
+	builder.addMatches("c", 1)
+	builder.fileFailed("test", "c", errors.New("test"))
+	builder.fileSkipped("test", "c", 0, nil, "test")
+	builder.volumeFailed("c", errors.New("test"))
+	if err := builder.err(); err != nil {
+		t.Errorf("err() on a nil reportBuilder = %v, want nil", err)
+	}
+}
+
+func Test_reportBuilder_volumeFailed(t *testing.T) {
+	builder := newReportBuilder()
+	builder.addVolume(VolumeHandler{VolumeLetter: "c"})
+	builder.volumeFailed("c", errors.New("bad mft"))
+	builder.volumeFailed("d", errors.New("no such volume"))
+	report := builder.snapshot()
+	if len(report.Volumes) != 2 || report.Volumes[0].Error != "bad mft" || report.Volumes[1].Error != "no such volume" {
+		t.Errorf("snapshot() volumes = %+v, want c and d with their errors", report.Volumes)
+	}
+	collectionErrors, ok := builder.err().(CollectionErrors)
+	if !ok || len(collectionErrors) != 2 {
+		t.Errorf("err() = %v, want CollectionErrors with 2 errors", builder.err())
+	}
+}
+
+func Test_reportBuilder_fileSkipped(t *testing.T) {
+	builder := newReportBuilder()
+	builder.fileSkipped(`c:\pagefile.sys`, "c", 4096, &FileMetadata{Path: `c:\pagefile.sys`, Size: 4096}, "too big")
+	report := builder.snapshot()
+	if len(report.Files) != 1 || report.Files[0].Status != "skipped" || report.Files[0].Skipped != "too big" || report.Files[0].Size != 4096 || report.Files[0].Metadata == nil {
+		t.Errorf("snapshot() files = %+v, want the pagefile skipped", report.Files)
+	}
+	if err := builder.err(); err != nil {
+		t.Errorf("err() after a skipped file = %v, want nil", err)
+	}
+}
+
+func TestCollectWithReport(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		0: {
+			FullPath:        `c:\$MFT`,
+			IsFullPathRegex: false,
+			FileName:        `$MFT`,
+			IsFileNameRegex: false,
+		},
+	}
+	output := new(bytes.Buffer)
+	resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	gotReport, err := CollectWithReport(context.Background(), handler, exportList, &resultWriter, CollectOptions{})
+	if err != nil {
+		t.Fatalf("CollectWithReport() error = %v", err)
+	}
+	if gotReport.FilesMatched != 1 || gotReport.FilesCollected != 1 {
+		t.Errorf("CollectWithReport() matched %d and collected %d files, want 1 and 1", gotReport.FilesMatched, gotReport.FilesCollected)
+	}
+	if len(gotReport.Volumes) != 1 || gotReport.Volumes[0].Letter != "c" {
+		t.Errorf("CollectWithReport() volumes = %+v, want just c", gotReport.Volumes)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	for _, file := range zipReader.File {
+		if file.Name != reportFileName {
+			continue
+		}
+		reader, _ := file.Open()
+		defer reader.Close()
+		embeddedReport := CollectionReport{}
+		if err := json.NewDecoder(reader).Decode(&embeddedReport); err != nil {
+			t.Fatalf("failed to decode the embedded report: %v", err)
+		}
+		if embeddedReport.FilesCollected != 1 {
+			t.Errorf("embedded report collected %d files, want 1", embeddedReport.FilesCollected)
+		}
+		return
+	}
+	t.Errorf("CollectWithReport() did not write %s into the output", reportFileName)
+}
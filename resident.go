@@ -0,0 +1,139 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"unicode/utf16"
+)
+
+// residentData returns the contents of a record's unnamed $DATA attribute when it is resident, that is when the file is
+// small enough for NTFS to keep it in the MFT record itself instead of in data runs. The MFT parser doesn't apply the
+// record's update sequence array and keeps the padding after the data, so this reads the attribute itself. Named $DATA
+// attributes are alternate data streams and are left alone.
+func residentData(record mft.RawMasterFileTableRecord, attributesOffset uint16) (data []byte, resident bool) {
+	const (
+		offsetNonResFlag = 0x08
+		offsetDataLength = 0x10
+		offsetDataOffset = 0x14
+	)
+	attribute, found := unnamedDataAttribute(record, attributesOffset)
+	if !found || attribute[offsetNonResFlag] != 0 || len(attribute) < offsetDataOffset+2 {
+		return
+	}
+	dataLength := int(binary.LittleEndian.Uint32(attribute[offsetDataLength:]))
+	dataOffset := int(binary.LittleEndian.Uint16(attribute[offsetDataOffset:]))
+	if dataOffset+dataLength > len(attribute) {
+		return
+	}
+	data = append(make([]byte, 0, dataLength), attribute[dataOffset:dataOffset+dataLength]...)
+	resident = true
+	return
+}
+
+// unnamedDataAttribute returns the raw unnamed $DATA attribute of a record, after applying its update sequence array.
+func unnamedDataAttribute(record mft.RawMasterFileTableRecord, attributesOffset uint16) (attribute []byte, found bool) {
+	const codeData = 0x80
+	return unnamedAttribute(record, attributesOffset, codeData)
+}
+
+// unnamedAttribute returns the first raw attribute of a type in a record that has no name, after applying the record's
+// update sequence array.
+func unnamedAttribute(record mft.RawMasterFileTableRecord, attributesOffset uint16, attributeCode uint32) (attribute []byte, found bool) {
+	return namedAttribute(record, attributesOffset, attributeCode, "")
+}
+
+// namedAttribute returns the first raw attribute of a type in a record with the given name, such as the $I30 index of
+// a directory, after applying the record's update sequence array. An empty name finds an unnamed attribute.
+func namedAttribute(record mft.RawMasterFileTableRecord, attributesOffset uint16, attributeCode uint32, name string) (attribute []byte, found bool) {
+	const (
+		offsetNameLength = 0x09
+		offsetNameOffset = 0x0a
+	)
+	wantName := utf16.Encode([]rune(name))
+	for _, current := range attributesOfType(record, attributesOffset, attributeCode) {
+		if attributeNameIs(current, int(current[offsetNameLength]), int(binary.LittleEndian.Uint16(current[offsetNameOffset:])), wantName) {
+			return current, true
+		}
+	}
+	return
+}
+
+// attributesOfType returns every raw attribute of a type in a record, in the order they're in, after applying the
+// record's update sequence array.
+func attributesOfType(record mft.RawMasterFileTableRecord, attributesOffset uint16, attributeCode uint32) (attributes [][]byte) {
+	const (
+		codeEndOfRecord = 0xffffffff
+		offsetLength    = 0x04
+		minimumLength   = 0x18
+	)
+	fixed, ok := applyUpdateSequence(record)
+	if !ok {
+		return
+	}
+	offset := int(attributesOffset)
+	for offset+minimumLength <= len(fixed) {
+		attributeType := binary.LittleEndian.Uint32(fixed[offset:])
+		attributeLength := int(binary.LittleEndian.Uint32(fixed[offset+offsetLength:]))
+		if attributeType == codeEndOfRecord || attributeLength < minimumLength || offset+attributeLength > len(fixed) {
+			return
+		}
+		if attributeType == attributeCode {
+			attributes = append(attributes, fixed[offset:offset+attributeLength])
+		}
+		offset += attributeLength
+	}
+	return
+}
+
+// attributeNameIs reports whether the UTF-16 name of an attribute, nameLength characters at nameOffset, is name.
+func attributeNameIs(attribute []byte, nameLength int, nameOffset int, name []uint16) bool {
+	if nameLength != len(name) {
+		return false
+	}
+	if nameOffset+nameLength*2 > len(attribute) {
+		return false
+	}
+	for index, character := range name {
+		if binary.LittleEndian.Uint16(attribute[nameOffset+index*2:]) != character {
+			return false
+		}
+	}
+	return true
+}
+
+// updateSequenceStride is how far apart the bytes the update sequence array protects are. It's 512 bytes whatever the
+// sector size, so a 4096 byte record on a 4K native disk has eight of them.
+const updateSequenceStride = 512
+
+// applyUpdateSequence returns a copy of an MFT record with the last two bytes of each 512 byte block put back from the
+// update sequence array. NTFS swaps them out on disk so a torn write can be detected, and a record whose blocks don't
+// end in the update sequence number is reported as not ok.
+func applyUpdateSequence(record mft.RawMasterFileTableRecord) (fixed []byte, ok bool) {
+	const (
+		offsetUpdateSequenceOffset = 0x04
+		offsetUpdateSequenceCount  = 0x06
+	)
+	if len(record) < offsetUpdateSequenceCount+2 {
+		return
+	}
+	sequenceOffset := int(binary.LittleEndian.Uint16(record[offsetUpdateSequenceOffset:]))
+	sequenceCount := int(binary.LittleEndian.Uint16(record[offsetUpdateSequenceCount:]))
+	if sequenceCount == 0 || sequenceOffset+sequenceCount*2 > len(record) {
+		return
+	}
+	fixed = append([]byte(nil), record...)
+	for block := 1; block < sequenceCount; block++ {
+		end := block * updateSequenceStride
+		if end > len(fixed) {
+			break
+		}
+		if fixed[end-2] != record[sequenceOffset] || fixed[end-1] != record[sequenceOffset+1] {
+			return nil, false
+		}
+		copy(fixed[end-2:end], record[sequenceOffset+block*2:sequenceOffset+block*2+2])
+	}
+	ok = true
+	return
+}
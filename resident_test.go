@@ -0,0 +1,190 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/binary"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io/ioutil"
+	"testing"
+)
+
+// residentTestAttribute is a resident attribute for buildResidentTestRecord.
+type residentTestAttribute struct {
+	attributeType uint32
+	name          string
+	nonResident   bool
+	data          []byte
+}
+
+// buildResidentTestRecord lays out a 1024 byte MFT record with its attributes starting at 0x38 and its update sequence
+// applied the way NTFS writes it to disk.
+func buildResidentTestRecord(attributes []residentTestAttribute, tornWrite bool) mft.RawMasterFileTableRecord {
+	record := make([]byte, 1024)
+	copy(record, "FILE")
+	binary.LittleEndian.PutUint16(record[0x04:], 0x30) // update sequence offset
+	binary.LittleEndian.PutUint16(record[0x06:], 3)    // update sequence count, one plus the number of sectors
+	binary.LittleEndian.PutUint16(record[0x14:], 0x38) // attributes offset
+	offset := 0x38
+	for _, attribute := range attributes {
+		dataOffset := 0x18 + len(attribute.name)*2
+		length := (dataOffset + len(attribute.data) + 7) &^ 7
+		binary.LittleEndian.PutUint32(record[offset:], attribute.attributeType)
+		binary.LittleEndian.PutUint32(record[offset+0x04:], uint32(length))
+		if attribute.nonResident {
+			record[offset+0x08] = 1
+		}
+		record[offset+0x09] = byte(len(attribute.name))
+		binary.LittleEndian.PutUint16(record[offset+0x0a:], 0x18)
+		binary.LittleEndian.PutUint32(record[offset+0x10:], uint32(len(attribute.data)))
+		binary.LittleEndian.PutUint16(record[offset+0x14:], uint16(dataOffset))
+		for i, character := range attribute.name {
+			record[offset+0x18+i*2] = byte(character)
+		}
+		copy(record[offset+dataOffset:], attribute.data)
+		offset += length
+	}
+	binary.LittleEndian.PutUint32(record[offset:], 0xffffffff)
+
+	sequenceNumber := []byte{0x07, 0x00}
+	copy(record[0x30:], sequenceNumber)
+	for sector := 1; sector <= 2; sector++ {
+		end := sector * 512
+		copy(record[0x30+sector*2:], record[end-2:end])
+		if !(tornWrite && sector == 2) {
+			copy(record[end-2:end], sequenceNumber)
+		}
+	}
+	return record
+}
+
+func Test_applyUpdateSequence_4KRecord(t *testing.T) {
+	// A 4096 byte record, as on a 4K native disk, still has its update sequence every 512 bytes
+	record := make([]byte, 4096)
+	copy(record, "FILE")
+	binary.LittleEndian.PutUint16(record[0x04:], 0x30)
+	binary.LittleEndian.PutUint16(record[0x06:], 9)
+	copy(record[0x30:], []byte{0x07, 0x00})
+	for block := 1; block <= 8; block++ {
+		end := block * 512
+		record[0x30+block*2] = byte(block)
+		copy(record[end-2:end], []byte{0x07, 0x00})
+	}
+
+	fixed, ok := applyUpdateSequence(record)
+	if !ok {
+		t.Fatalf("applyUpdateSequence() ok = false, want true")
+	}
+	for block := 1; block <= 8; block++ {
+		if fixed[block*512-2] != byte(block) {
+			t.Errorf("applyUpdateSequence() left block %d ending in %#x, want %#x", block, fixed[block*512-2], block)
+		}
+	}
+}
+
+func Test_namedAttribute(t *testing.T) {
+	record := buildResidentTestRecord([]residentTestAttribute{
+		{attributeType: codeIndexRoot, name: "$SII", data: []byte("security")},
+		{attributeType: codeIndexRoot, name: "$I30", data: []byte("files")},
+		{attributeType: codeIndexRoot, data: []byte("unnamed")},
+	}, false)
+	tests := []struct {
+		name      string
+		attribute string
+		want      string
+		wantFound bool
+	}{
+		{name: "named", attribute: "$I30", want: "files", wantFound: true},
+		{name: "unnamed", attribute: "", want: "unnamed", wantFound: true},
+		{name: "missing", attribute: "$O"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attribute, found := namedAttribute(record, 0x38, codeIndexRoot, tt.attribute)
+			if found != tt.wantFound {
+				t.Fatalf("namedAttribute() found = %v, want %v", found, tt.wantFound)
+			}
+			if found && !bytes.HasSuffix(bytes.TrimRight(attribute, "\x00"), []byte(tt.want)) {
+				t.Errorf("namedAttribute() = %q, want the attribute holding %q", attribute, tt.want)
+			}
+		})
+	}
+}
+
+func Test_residentData(t *testing.T) {
+	// Long enough to run over the end of the first sector, where the update sequence number sits on disk
+	shortcut := bytes.Repeat([]byte("LNK data "), 60)
+	tests := []struct {
+		name         string
+		record       mft.RawMasterFileTableRecord
+		want         []byte
+		wantResident bool
+	}{
+		{
+			name: "resident data across a sector boundary",
+			record: buildResidentTestRecord([]residentTestAttribute{
+				{attributeType: 0x10, data: make([]byte, 0x48)},
+				{attributeType: 0x80, name: "Zone.Identifier", data: []byte("[ZoneTransfer]\r\nZoneId=3")},
+				{attributeType: 0x80, data: shortcut},
+			}, false),
+			want:         shortcut,
+			wantResident: true,
+		},
+		{
+			name: "empty file",
+			record: buildResidentTestRecord([]residentTestAttribute{
+				{attributeType: 0x10, data: make([]byte, 0x48)},
+				{attributeType: 0x80, data: nil},
+			}, false),
+			want:         []byte{},
+			wantResident: true,
+		},
+		{
+			name: "non resident data",
+			record: buildResidentTestRecord([]residentTestAttribute{
+				{attributeType: 0x80, nonResident: true, data: make([]byte, 0x28)},
+			}, false),
+			wantResident: false,
+		},
+		{
+			name: "only an alternate data stream",
+			record: buildResidentTestRecord([]residentTestAttribute{
+				{attributeType: 0x80, name: "Zone.Identifier", data: []byte("ZoneId=3")},
+			}, false),
+			wantResident: false,
+		},
+		{
+			name: "torn write",
+			record: buildResidentTestRecord([]residentTestAttribute{
+				{attributeType: 0x80, data: shortcut},
+			}, true),
+			wantResident: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotResident := residentData(tt.record, 0x38)
+			if gotResident != tt.wantResident {
+				t.Fatalf("residentData() resident = %v, want %v", gotResident, tt.wantResident)
+			}
+			if tt.wantResident && !bytes.Equal(got, tt.want) {
+				t.Errorf("residentData() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rawFileReader_resident(t *testing.T) {
+	file := foundFile{fullPath: `c:\users\alice\desktop\notes.txt`, resident: true, residentData: []byte("small but important")}
+	got, err := ioutil.ReadAll(rawFileReader(&VolumeHandler{}, file))
+	if err != nil {
+		t.Fatalf("rawFileReader() error = %v", err)
+	}
+	if string(got) != "small but important" {
+		t.Errorf("rawFileReader() = %q", got)
+	}
+	if file.totalSize() != int64(len(got)) {
+		t.Errorf("totalSize() = %d, want %d", file.totalSize(), len(got))
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The events recorded in a resume state file.
+const (
+	ResumeRunStarted  = "run_started"
+	ResumeFileWritten = "file_written"
+)
+
+// ResumeRecord is a line of a resume state file: a run of the collection starting, or a file it wrote.
+type ResumeRecord struct {
+	Event  string    `json:"event"`
+	Run    int       `json:"run"`
+	Time   time.Time `json:"time"`
+	Output string    `json:"output,omitempty"` // what the run wrote to, for run_started
+	Volume string    `json:"volume,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+}
+
+// ResumeState is the state file of a collection that can be resumed. Each run of the collection appends the files it
+// wrote to it, so a rerun after the collection was interrupted, such as by a dropped link or a reboot, leaves out the
+// matched files an earlier run already has safely in its output. A file only counts as written once the result writer
+// says so with Written, and its record is written to the file right away, so a crash loses at most a line, which is
+// dropped. Files aren't collected again even if they changed since the run that wrote them. Like the reportBuilder
+// its methods do nothing when it's nil.
+type ResumeState struct {
+	mutex        sync.Mutex
+	path         string
+	file         *os.File
+	run          int
+	outputs      []string                // what each earlier run wrote to
+	written      map[string]ResumeRecord // by lower case path
+	bytesWritten int64
+	started      bool
+	err          error // the first record that couldn't be added
+}
+
+// OpenResumeState loads the state file at path, if an earlier run left one, and opens it to add this run's records to.
+func OpenResumeState(path string) (state *ResumeState, err error) {
+	state = &ResumeState{path: path, run: 1, written: make(map[string]ResumeRecord)}
+	data, err := readResumeState(path)
+	if err != nil {
+		state = nil
+		return
+	}
+	// A last line without its newline was cut off by a crash while it was being added, and is dropped
+	end := bytes.LastIndexByte(data, '\n') + 1
+	for index, line := range bytes.Split(data[:end], []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record ResumeRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			err = fmt.Errorf("OpenResumeState() failed to parse line %d of %s: %w", index+1, path, err)
+			state = nil
+			return
+		}
+		state.add(record)
+	}
+	state.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		err = fmt.Errorf("OpenResumeState() failed to open %s: %w", path, err)
+		state = nil
+		return
+	}
+	if end != len(data) {
+		if err = state.file.Truncate(int64(end)); err != nil {
+			err = fmt.Errorf("OpenResumeState() failed to drop the cut off line of %s: %w", path, err)
+			_ = state.file.Close()
+			state = nil
+		}
+	}
+	return
+}
+
+// readResumeState reads the state file, which is empty for the first run.
+func readResumeState(path string) (data []byte, err error) {
+	data, err = ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		err = fmt.Errorf("OpenResumeState() failed to read %s: %w", path, err)
+	}
+	return
+}
+
+// add takes in an earlier run's record.
+func (state *ResumeState) add(record ResumeRecord) {
+	switch record.Event {
+	case ResumeRunStarted:
+		state.outputs = append(state.outputs, record.Output)
+		if record.Run >= state.run {
+			state.run = record.Run + 1
+		}
+	case ResumeFileWritten:
+		key := strings.ToLower(record.Path)
+		if _, ok := state.written[key]; !ok {
+			state.bytesWritten += record.Bytes
+		}
+		state.written[key] = record
+	}
+}
+
+// Run is the number of this run of the collection, 1 when no earlier run left a state file.
+func (state *ResumeState) Run() int {
+	if state == nil {
+		return 0
+	}
+	return state.run
+}
+
+// Outputs are what the earlier runs wrote to, in the order they ran, so what they collected can be found.
+func (state *ResumeState) Outputs() []string {
+	if state == nil {
+		return nil
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return append([]string(nil), state.outputs...)
+}
+
+// Written is how many files every run so far has written and their bytes.
+func (state *ResumeState) Written() (numberOfFiles int, numberOfBytes int64) {
+	if state == nil {
+		return
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return len(state.written), state.bytesWritten
+}
+
+// Begin records that this run has started writing to output, such as the name of its zip. Collect begins a run that
+// hasn't been with an empty output.
+func (state *ResumeState) Begin(output string) (err error) {
+	if state == nil {
+		return
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.started {
+		return
+	}
+	state.started = true
+	err = state.append(ResumeRecord{Event: ResumeRunStarted, Output: output})
+	return
+}
+
+// Close closes the state file, returning the first record that couldn't be added to it.
+func (state *ResumeState) Close() (err error) {
+	if state == nil {
+		return
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	err = state.file.Sync()
+	if closeErr := state.file.Close(); err == nil {
+		err = closeErr
+	}
+	if state.err != nil {
+		err = state.err
+	}
+	return
+}
+
+// append adds a record of this run to the state file.
+func (state *ResumeState) append(record ResumeRecord) (err error) {
+	record.Run = state.run
+	record.Time = time.Now().UTC()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	_, err = state.file.Write(append(data, '\n'))
+	if err != nil {
+		err = fmt.Errorf("failed to add to the resume state %s: %w", state.path, err)
+	}
+	return
+}
+
+// fileWritten records a file this run wrote.
+func (state *ResumeState) fileWritten(volumeLetter string, fullPath string, numberOfBytes int64) {
+	if state == nil {
+		return
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	key := strings.ToLower(fullPath)
+	if _, ok := state.written[key]; ok {
+		return
+	}
+	record := ResumeRecord{Event: ResumeFileWritten, Volume: volumeLetter, Path: fullPath, Bytes: numberOfBytes}
+	if err := state.append(record); err != nil {
+		if state.err == nil {
+			state.err = err
+		}
+		return
+	}
+	state.written[key] = record
+	state.bytesWritten += numberOfBytes
+}
+
+// filterFiles returns the files no run has written yet and how many were left out. A nil ResumeState keeps every file.
+func (state *ResumeState) filterFiles(files foundFiles) (remaining foundFiles, numberWritten int) {
+	if state == nil {
+		return files, 0
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	for _, file := range files {
+		if _, ok := state.written[strings.ToLower(file.fullPath)]; ok {
+			numberWritten++
+		} else {
+			remaining = append(remaining, file)
+		}
+	}
+	return
+}
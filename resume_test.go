@@ -0,0 +1,138 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeState(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-resume-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "state.jsonl")
+
+	state, err := OpenResumeState(path)
+	if err != nil {
+		t.Fatalf("OpenResumeState() error = %v", err)
+	}
+	if state.Run() != 1 {
+		t.Errorf("ResumeState.Run() = %d for a new state file, want 1", state.Run())
+	}
+	if err = state.Begin("host.zip"); err != nil {
+		t.Fatalf("ResumeState.Begin() error = %v", err)
+	}
+	state.fileWritten("c", `c:\$MFT`, 100)
+	state.fileWritten("c", `c:\windows\system32\config\SAM`, 20)
+	state.fileWritten("c", `c:\windows\system32\config\sam`, 20)
+	if err = state.Close(); err != nil {
+		t.Fatalf("ResumeState.Close() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cutOff  string // appended to the state file as if a crash cut off the line being added
+		wantRun int
+	}{
+		{name: "resumed", wantRun: 2},
+		{name: "cut off line", cutOff: `{"event":"file_written","run":2,"path":"c:\\window`, wantRun: 3},
+		{name: "after a cut off line", wantRun: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cutOff != "" {
+				stateFile, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, _ = stateFile.WriteString(tt.cutOff)
+				_ = stateFile.Close()
+			}
+			state, err := OpenResumeState(path)
+			if err != nil {
+				t.Fatalf("OpenResumeState() error = %v", err)
+			}
+			defer state.Close()
+			if err = state.Begin(fmt.Sprintf("host.run%d.zip", tt.wantRun)); err != nil {
+				t.Fatalf("ResumeState.Begin() error = %v", err)
+			}
+			if state.Run() != tt.wantRun {
+				t.Errorf("ResumeState.Run() = %d, want %d", state.Run(), tt.wantRun)
+			}
+			if files, numberOfBytes := state.Written(); files != 2 || numberOfBytes != 120 {
+				t.Errorf("ResumeState.Written() = %d files, %d bytes, want 2 and 120", files, numberOfBytes)
+			}
+			if outputs := state.Outputs(); len(outputs) != tt.wantRun-1 || outputs[0] != "host.zip" {
+				t.Errorf("ResumeState.Outputs() = %v, want host.zip first of %d", outputs, tt.wantRun-1)
+			}
+			remaining, numberWritten := state.filterFiles(foundFiles{
+				{fullPath: `C:\$MFT`},
+				{fullPath: `c:\windows\system32\config\software`},
+			})
+			if numberWritten != 1 || len(remaining) != 1 || remaining[0].fullPath != `c:\windows\system32\config\software` {
+				t.Errorf("ResumeState.filterFiles() left %+v and %d out, want the software hive and 1", remaining, numberWritten)
+			}
+		})
+	}
+}
+
+func TestCollect_resume(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-resume-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+
+	tests := []struct {
+		name        string
+		wantMFT     bool
+		wantResumed int
+	}{
+		{name: "first run", wantMFT: true},
+		{name: "resumed run", wantResumed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := OpenResumeState(filepath.Join(directory, "state.jsonl"))
+			if err != nil {
+				t.Fatalf("OpenResumeState() error = %v", err)
+			}
+			output := new(bytes.Buffer)
+			resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+			report, err := CollectWithReport(context.Background(), handler, exportList, &resultWriter, CollectOptions{Resume: state})
+			if closeErr := state.Close(); closeErr != nil {
+				t.Errorf("ResumeState.Close() error = %v", closeErr)
+			}
+			if err != nil {
+				t.Fatalf("CollectWithReport() error = %v", err)
+			}
+			reader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotMFT := false
+			for _, file := range reader.File {
+				gotMFT = gotMFT || file.Name == "c/$mft"
+			}
+			if gotMFT != tt.wantMFT {
+				t.Errorf("the zip holds the $MFT: %v, want %v", gotMFT, tt.wantMFT)
+			}
+			if len(report.Volumes) != 1 || report.Volumes[0].FilesResumed != tt.wantResumed {
+				t.Errorf("CollectWithReport() reported volumes %+v, want %d files resumed", report.Volumes, tt.wantResumed)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// defaultReadRetryDelay is how long to wait before the first retry of a failed raw read when ReadRetryDelay isn't set.
+const defaultReadRetryDelay = 100 * time.Millisecond
+
+// readRetry is how failed raw reads of a volume, such as with a busy device or a CRC error part way through a file,
+// are retried. The zero value doesn't retry.
+type readRetry struct {
+	attempts int
+	delay    time.Duration // before the first retry, doubling for each one after it
+}
+
+func newReadRetry(attempts int, delay time.Duration) readRetry {
+	if delay <= 0 {
+		delay = defaultReadRetryDelay
+	}
+	return readRetry{attempts: attempts, delay: delay}
+}
+
+// sleepBeforeRetry waits out the backoff before a read is retried. It's a variable so tests don't have to wait.
+var sleepBeforeRetry = time.Sleep
+
+// readRetrying reads from the volume at its handle's position, which is offset. A failed read is tried again after a
+// backoff with a new handle to the volume, seeked back to offset, until it works or the retries run out. Reading past
+// the end of the volume isn't retried.
+func (volume *VolumeHandler) readRetrying(buffer []byte, offset int64) (numberOfBytesRead int, err error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			_, err = volume.Handle.Seek(offset, io.SeekStart)
+		}
+		if err == nil {
+			numberOfBytesRead, err = volume.readAligned(buffer, offset)
+		}
+		if err == nil || errors.Is(err, io.EOF) || attempt >= volume.retry.attempts {
+			return
+		}
+		delay := volume.retry.delay << uint(attempt)
+		volume.logger().Warnf("Reading %d bytes at offset %d of volume %s failed, trying again in %v: %v", len(buffer), offset, volume.VolumeLetter, delay, err)
+		sleepBeforeRetry(delay)
+		if reopenErr := volume.reopen(); reopenErr != nil {
+			volume.logger().Debugf("Could not reopen volume %s to retry the read, retrying with the old handle: %v", volume.VolumeLetter, reopenErr)
+		} else {
+			volume.audit.record(AuditVolumeReopened, volume.VolumeLetter, "", fmt.Sprintf("reading %d bytes at offset %d failed: %v", len(buffer), offset, err))
+		}
+	}
+}
+
+// reopen replaces the volume's handle with a new one, in case the old one is what's gone bad.
+func (volume *VolumeHandler) reopen() (err error) {
+	if volume.handler == nil {
+		err = errors.New("reopen() was called on a volume handler that wasn't made by GetVolumeHandler()")
+		return
+	}
+	handle, err := volume.handler.GetHandle(volume.VolumeLetter)
+	if err != nil {
+		err = fmt.Errorf("reopen() failed to get a new handle to volume %s: %w", volume.VolumeLetter, err)
+		return
+	}
+	_ = volume.Handle.Close()
+	volume.Handle = handle
+	return
+}
+
+// retryingVolume reads a volume through readRetrying, for readers that seek around the volume on their own.
+type retryingVolume struct {
+	volume *VolumeHandler
+	offset int64
+}
+
+func (reader *retryingVolume) Seek(offset int64, whence int) (position int64, err error) {
+	position, err = reader.volume.Handle.Seek(offset, whence)
+	if err == nil {
+		reader.offset = position
+	}
+	return
+}
+
+func (reader *retryingVolume) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = reader.volume.readRetrying(byteSliceToPopulate, reader.offset)
+	reader.offset += int64(numberOfBytesRead)
+	return
+}
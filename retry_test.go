@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_readRetrying(t *testing.T) {
+	defer func(original func(time.Duration)) { sleepBeforeRetry = original }(sleepBeforeRetry)
+	volume, err := ioutil.ReadFile(`test\testdata\dummyntfs`)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		attempts   int
+		handler    handler
+		wantErr    bool
+		wantSleeps []time.Duration
+	}{
+		{name: "reopened", attempts: 3, handler: dummyHandler{filePath: `test\testdata\dummyntfs`}, wantSleeps: []time.Duration{defaultReadRetryDelay}},
+		{name: "no retries", attempts: 0, handler: dummyHandler{filePath: `test\testdata\dummyntfs`}, wantErr: true},
+		{name: "can't be reopened", attempts: 2, handler: dummyHandler{filePath: `test\testdata\missing`}, wantErr: true, wantSleeps: []time.Duration{defaultReadRetryDelay, 2 * defaultReadRetryDelay}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sleeps []time.Duration
+			sleepBeforeRetry = func(delay time.Duration) { sleeps = append(sleeps, delay) }
+			// A closed handle fails every read, like one to a device that has gone away
+			handle, err := os.Open(`test\testdata\dummyntfs`)
+			if err != nil {
+				t.Fatalf("os.Open() error = %v", err)
+			}
+			handle.Close()
+			volumeHandler := &VolumeHandler{Handle: handle, VolumeLetter: "c", handler: tt.handler, retry: newReadRetry(tt.attempts, 0)}
+
+			buffer := make([]byte, 512)
+			numberOfBytesRead, err := volumeHandler.readRetrying(buffer, 512)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readRetrying() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (numberOfBytesRead != 512 || string(buffer) != string(volume[512:1024])) {
+				t.Errorf("readRetrying() read %d bytes that aren't the second sector of the volume", numberOfBytesRead)
+			}
+			if len(sleeps) != len(tt.wantSleeps) || (len(sleeps) != 0 && sleeps[len(sleeps)-1] != tt.wantSleeps[len(tt.wantSleeps)-1]) {
+				t.Errorf("readRetrying() waited %v, want %v", sleeps, tt.wantSleeps)
+			}
+			if !tt.wantErr {
+				volumeHandler.Handle.Close()
+			}
+		})
+	}
+}
+
+func TestDataRunsReader_Read_failedRead(t *testing.T) {
+	handle, err := os.Open(`test\testdata\dummyntfs`)
+	if err != nil {
+		t.Fatalf("os.Open() error = %v", err)
+	}
+	handle.Close()
+	reader := &DataRunsReader{
+		VolumeHandler: &VolumeHandler{Handle: handle, VolumeLetter: "c"},
+		DataRuns:      mft.DataRuns{0: {AbsoluteOffset: 0, Length: 4096}},
+		fileName:      `c:\windows\system32\config\sam`,
+	}
+	_, err = io.Copy(ioutil.Discard, reader)
+	if err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("DataRunsReader.Read() error = %v, want the failed read", err)
+	}
+}
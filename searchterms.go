@@ -0,0 +1,267 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+)
+
+// SearchTerm builds a single FileToExport one field at a time. Nothing is validated until Build is called, so a UI can
+// fill it in as the analyst types.
+type SearchTerm struct {
+	fileToExport FileToExport
+}
+
+// NewSearchTerm starts a search term for a file with a literal full path.
+func NewSearchTerm(fullPath string) *SearchTerm {
+	return &SearchTerm{fileToExport: FileToExport{FullPath: fullPath}}
+}
+
+// NewSearchTermRegex starts a search term whose full path is a regex.
+func NewSearchTermRegex(fullPathRegex string) *SearchTerm {
+	return &SearchTerm{fileToExport: FileToExport{FullPath: fullPathRegex, IsFullPathRegex: true}}
+}
+
+// NewSearchTermRecord starts a search term for the file with an MFT record number on a volume, e.g. C:.
+func NewSearchTermRecord(volume string, recordNumber uint32) *SearchTerm {
+	return &SearchTerm{fileToExport: FileToExport{FullPath: volume, RecordNumber: recordNumber}}
+}
+
+// NewSearchTermExtensions starts a search term for every file with one of the extensions, e.g. ps1 or lnk, anywhere
+// under a volume, e.g. C:, or a directory.
+func NewSearchTermExtensions(directory string, extensions ...string) *SearchTerm {
+	return &SearchTerm{fileToExport: FileToExport{FullPath: directory, Extensions: strings.Join(extensions, ",")}}
+}
+
+// FileName sets a literal file name for the term.
+func (term *SearchTerm) FileName(fileName string) *SearchTerm {
+	term.fileToExport.FileName = fileName
+	term.fileToExport.IsFileNameRegex = false
+	return term
+}
+
+// FileNameRegex sets a regex the file name has to match.
+func (term *SearchTerm) FileNameRegex(fileNameRegex string) *SearchTerm {
+	term.fileToExport.FileName = fileNameRegex
+	term.fileToExport.IsFileNameRegex = true
+	return term
+}
+
+// Codec sets the registered codec to compress files matching this term with.
+func (term *SearchTerm) Codec(codec string) *SearchTerm {
+	term.fileToExport.Codec = codec
+	return term
+}
+
+// Processors sets the registered processors to run files matching this term through, one after the other.
+func (term *SearchTerm) Processors(names ...string) *SearchTerm {
+	term.fileToExport.Processors = strings.Join(names, ",")
+	return term
+}
+
+// Priority sets how valuable files matching this term are when a ByteBudget means not everything can be collected.
+func (term *SearchTerm) Priority(priority int) *SearchTerm {
+	term.fileToExport.Priority = priority
+	return term
+}
+
+// Modified narrows the term down to files last modified in a time window.
+func (term *SearchTerm) Modified(window TimeWindow) *SearchTerm {
+	term.fileToExport.Modified = window
+	return term
+}
+
+// Created narrows the term down to files created in a time window.
+func (term *SearchTerm) Created(window TimeWindow) *SearchTerm {
+	term.fileToExport.Created = window
+	return term
+}
+
+// SHA256 narrows the term down to files with one of the hashes.
+func (term *SearchTerm) SHA256(hashes ...string) *SearchTerm {
+	term.fileToExport.SHA256 = strings.Join(hashes, ",")
+	return term
+}
+
+// Exclude leaves out files whose full paths match a regex, even though they match the term.
+func (term *SearchTerm) Exclude(pattern string) *SearchTerm {
+	term.fileToExport.Exclude = pattern
+	return term
+}
+
+// Build validates the term, compiling its regexes, and returns it as a FileToExport. If no file name was given and the
+// full path is literal, the file name is taken from the end of the path.
+func (term *SearchTerm) Build() (fileToExport FileToExport, err error) {
+	fileToExport = term.fileToExport
+	if fileToExport.FileName == "" && !fileToExport.IsFullPathRegex && fileToExport.RecordNumber == 0 {
+		fileToExport.FileName = fileToExport.FullPath[strings.LastIndex(fileToExport.FullPath, `\`)+1:]
+	}
+	_, err = compileSearchTerms(fileToExport)
+	if err != nil {
+		err = fmt.Errorf("invalid search term: %w", err)
+		fileToExport = FileToExport{}
+	}
+	return
+}
+
+// SearchTermConflict describes a problem found between, or within, the terms of a SearchTermSet.
+type SearchTermConflict struct {
+	Index   int // index of the term with the problem
+	Other   int // index of the term it conflicts with, or -1 when the problem is with the term alone
+	Message string
+}
+
+func (conflict SearchTermConflict) String() string {
+	if conflict.Other < 0 {
+		return fmt.Sprintf("term %d: %s", conflict.Index, conflict.Message)
+	}
+	return fmt.Sprintf("term %d and term %d: %s", conflict.Index, conflict.Other, conflict.Message)
+}
+
+// SearchTermSet is a validated collection of targets that can be checked for duplicates and conflicts and written out
+// in the YAML target format.
+type SearchTermSet struct {
+	terms    ListOfFilesToExport
+	compiled listOfSearchTerms
+}
+
+// targetFile is the layout of a YAML target file.
+type targetFile struct {
+	Targets ListOfFilesToExport `yaml:"targets"`
+}
+
+// Add validates a term and adds it to the set. Invalid terms are rejected and leave the set unchanged.
+func (set *SearchTermSet) Add(fileToExport FileToExport) (err error) {
+	compiled, err := compileSearchTerms(fileToExport)
+	if err != nil {
+		err = fmt.Errorf("invalid search term: %w", err)
+		return
+	}
+	set.terms = append(set.terms, fileToExport)
+	set.compiled = append(set.compiled, compiled)
+	return
+}
+
+// AddTerm builds a SearchTerm and adds it to the set.
+func (set *SearchTermSet) AddTerm(term *SearchTerm) (err error) {
+	fileToExport, err := term.Build()
+	if err != nil {
+		return
+	}
+	err = set.Add(fileToExport)
+	return
+}
+
+// Remove drops the term at index from the set.
+func (set *SearchTermSet) Remove(index int) (err error) {
+	if index < 0 || index >= len(set.terms) {
+		err = fmt.Errorf("no search term at index %d", index)
+		return
+	}
+	set.terms = append(set.terms[:index], set.terms[index+1:]...)
+	set.compiled = append(set.compiled[:index], set.compiled[index+1:]...)
+	return
+}
+
+// Terms returns the terms in the set, ready to hand to Collect.
+func (set *SearchTermSet) Terms() ListOfFilesToExport {
+	return append(ListOfFilesToExport(nil), set.terms...)
+}
+
+// Conflicts reports duplicate terms, terms that say different things about the same path, and terms whose file name
+// can never match their literal full path.
+func (set *SearchTermSet) Conflicts() (conflicts []SearchTermConflict) {
+	for index, compiled := range set.compiled {
+		// A literal path ends with the file name, so the file name part of the term has to agree with it
+		if compiled.fullPathString != "" && compiled.recordNumber == 0 && compiled.extensions == nil {
+			baseName := compiled.fullPathString[strings.LastIndex(compiled.fullPathString, `\`)+1:]
+			if compiled.fileNameRegex != nil && !compiled.fileNameRegex.MatchString(baseName) {
+				conflicts = append(conflicts, SearchTermConflict{Index: index, Other: -1, Message: fmt.Sprintf("file name regex '%s' never matches '%s'", compiled.fileNameRegex, baseName)})
+			} else if compiled.fileNameRegex == nil && compiled.fileNameString != baseName {
+				conflicts = append(conflicts, SearchTermConflict{Index: index, Other: -1, Message: fmt.Sprintf("file name '%s' never matches '%s'", compiled.fileNameString, baseName)})
+			}
+		}
+
+		for otherIndex := 0; otherIndex < index; otherIndex++ {
+			other := set.compiled[otherIndex]
+			if pathKey(compiled) != pathKey(other) {
+				continue
+			}
+			if nameKey(compiled) == nameKey(other) && compiled.codec == other.codec {
+				conflicts = append(conflicts, SearchTermConflict{Index: index, Other: otherIndex, Message: "duplicate term"})
+			} else {
+				conflicts = append(conflicts, SearchTermConflict{Index: index, Other: otherIndex, Message: "same full path with different settings"})
+			}
+		}
+	}
+	return
+}
+
+func pathKey(compiled searchTerms) string {
+	if compiled.recordNumber != 0 {
+		return fmt.Sprintf("record:%s%d", compiled.fullPathString, compiled.recordNumber)
+	}
+	if compiled.extensions != nil {
+		return "extensions:" + compiled.fullPathString
+	}
+	if compiled.fullPathRegex != nil {
+		return "regex:" + compiled.fullPathRegex.String()
+	}
+	return "string:" + compiled.fullPathString
+}
+
+func nameKey(compiled searchTerms) string {
+	if compiled.extensions != nil {
+		extensions := make([]string, 0, len(compiled.extensions))
+		for extension := range compiled.extensions {
+			extensions = append(extensions, extension)
+		}
+		sort.Strings(extensions)
+		return "extensions:" + strings.Join(extensions, ",")
+	}
+	if compiled.fileNameRegex != nil {
+		return "regex:" + compiled.fileNameRegex.String()
+	}
+	return "string:" + compiled.fileNameString
+}
+
+// MarshalYAML writes the set in the YAML target format.
+func (set *SearchTermSet) MarshalYAML() (interface{}, error) {
+	return targetFile{Targets: set.terms}, nil
+}
+
+// UnmarshalYAML reads a set from the YAML target format, validating every term.
+func (set *SearchTermSet) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	targets := targetFile{}
+	err = unmarshal(&targets)
+	if err != nil {
+		return
+	}
+	loaded := SearchTermSet{}
+	for index, fileToExport := range targets.Targets {
+		err = loaded.Add(fileToExport)
+		if err != nil {
+			err = fmt.Errorf("target %d: %w", index, err)
+			return
+		}
+	}
+	*set = loaded
+	return
+}
+
+// LoadTargetsYAML parses a YAML target file into a validated SearchTermSet.
+func LoadTargetsYAML(data []byte) (set SearchTermSet, err error) {
+	if len(data) == 0 {
+		err = errors.New("LoadTargetsYAML() received an empty target file")
+		return
+	}
+	err = yaml.Unmarshal(data, &set)
+	if err != nil {
+		err = fmt.Errorf("LoadTargetsYAML() failed to parse the target file: %w", err)
+	}
+	return
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"testing"
+)
+
+func TestSearchTerm_Build(t *testing.T) {
+	tests := []struct {
+		name    string
+		term    *SearchTerm
+		want    FileToExport
+		wantErr bool
+	}{
+		{
+			name: "file name from path",
+			term: NewSearchTerm(`%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`),
+			want: FileToExport{
+				FullPath: `%SYSTEMDRIVE%:\Windows\System32\config\SYSTEM`,
+				FileName: `SYSTEM`,
+			},
+			wantErr: false,
+		},
+		{
+			name: "regex path and name",
+			term: NewSearchTermRegex(`%SYSTEMDRIVE%:\\Windows\\System32\\winevt\\Logs\\.*\.evtx$`).FileNameRegex(`.*\.evtx$`),
+			want: FileToExport{
+				FullPath:        `%SYSTEMDRIVE%:\\Windows\\System32\\winevt\\Logs\\.*\.evtx$`,
+				IsFullPathRegex: true,
+				FileName:        `.*\.evtx$`,
+				IsFileNameRegex: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "bad regex",
+			term:    NewSearchTermRegex(`c:\\(unclosed`).FileName("unclosed"),
+			wantErr: true,
+		},
+		{
+			name:    "regex path without a file name",
+			term:    NewSearchTermRegex(`c:\\.*`),
+			wantErr: true,
+		},
+		{
+			name: "exclusions",
+			term: NewSearchTermRegex(`c:\\windows\\system32\\winevt\\logs\\.*`).FileNameRegex(`.*\.evtx$`).Exclude(`.*\\microsoft-windows-store.*`),
+			want: FileToExport{
+				FullPath:        `c:\\windows\\system32\\winevt\\logs\\.*`,
+				IsFullPathRegex: true,
+				FileName:        `.*\.evtx$`,
+				IsFileNameRegex: true,
+				Exclude:         `.*\\microsoft-windows-store.*`,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "bad exclusion",
+			term:    NewSearchTerm(`c:\test`).Exclude(`(unclosed`),
+			wantErr: true,
+		},
+		{
+			name:    "unknown codec",
+			term:    NewSearchTerm(`c:\test`).Codec("nope"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.term.Build()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SearchTerm.Build() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchTerm.Build() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchTermSet_Conflicts(t *testing.T) {
+	tests := []struct {
+		name          string
+		terms         ListOfFilesToExport
+		wantConflicts []SearchTermConflict
+	}{
+		{
+			name: "no conflicts",
+			terms: ListOfFilesToExport{
+				{FullPath: `c:\$MFT`, FileName: `$MFT`},
+				{FullPath: `c:\windows\system32\config\SYSTEM`, FileName: `SYSTEM`},
+			},
+			wantConflicts: nil,
+		},
+		{
+			name: "duplicate",
+			terms: ListOfFilesToExport{
+				{FullPath: `c:\$MFT`, FileName: `$MFT`},
+				{FullPath: `C:\$mft`, FileName: `$mft`},
+			},
+			wantConflicts: []SearchTermConflict{
+				{Index: 1, Other: 0, Message: "duplicate term"},
+			},
+		},
+		{
+			name: "duplicate sweep",
+			terms: ListOfFilesToExport{
+				{FullPath: `c:`, Extensions: "ps1,lnk"},
+				{FullPath: `C:`, Extensions: "LNK, ps1"},
+				{FullPath: `c:\users`, Extensions: "ps1"},
+			},
+			wantConflicts: []SearchTermConflict{
+				{Index: 1, Other: 0, Message: "duplicate term"},
+			},
+		},
+		{
+			name: "same path different settings",
+			terms: ListOfFilesToExport{
+				{FullPath: `c:\$MFT`, FileName: `$MFT`},
+				{FullPath: `c:\$MFT`, FileName: `$MFT`, Codec: "store"},
+			},
+			wantConflicts: []SearchTermConflict{
+				{Index: 1, Other: 0, Message: "same full path with different settings"},
+			},
+		},
+		{
+			name: "file name never matches",
+			terms: ListOfFilesToExport{
+				{FullPath: `c:\windows\system32\config\SYSTEM`, FileName: `SOFTWARE`},
+			},
+			wantConflicts: []SearchTermConflict{
+				{Index: 0, Other: -1, Message: "file name 'software' never matches 'system'"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := SearchTermSet{}
+			for _, term := range tt.terms {
+				if err := set.Add(term); err != nil {
+					t.Fatalf("SearchTermSet.Add() error = %v", err)
+				}
+			}
+			if got := set.Conflicts(); !reflect.DeepEqual(got, tt.wantConflicts) {
+				t.Errorf("SearchTermSet.Conflicts() = %+v, want %+v", got, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestLoadTargetsYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    ListOfFilesToExport
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: "targets:\n- full_path: '%SYSTEMDRIVE%:\\$MFT'\n  file_name: $MFT\n- full_path: '%SYSTEMDRIVE%:\\\\users\\\\([^\\\\]+)\\\\ntuser.dat'\n  full_path_regex: true\n  file_name: ntuser.dat\n",
+			want: ListOfFilesToExport{
+				{FullPath: `%SYSTEMDRIVE%:\$MFT`, FileName: `$MFT`},
+				{FullPath: `%SYSTEMDRIVE%:\\users\\([^\\]+)\\ntuser.dat`, IsFullPathRegex: true, FileName: `ntuser.dat`},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid term",
+			data:    "targets:\n- full_path: 'c:\\test'\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			data:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadTargetsYAML([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadTargetsYAML() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got.Terms(), tt.want) {
+				t.Errorf("LoadTargetsYAML() = %+v, want %+v", got.Terms(), tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// Writing the set back out should give the same targets
+			data, err := yaml.Marshal(&got)
+			if err != nil {
+				t.Errorf("yaml.Marshal() error = %v", err)
+				return
+			}
+			roundTripped, err := LoadTargetsYAML(data)
+			if err != nil || !reflect.DeepEqual(roundTripped.Terms(), tt.want) {
+				t.Errorf("round trip = %+v, %v, want %+v", roundTripped.Terms(), err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// AccessControlEntry is an entry of a file's discretionary access control list, who is allowed or denied what.
+type AccessControlEntry struct {
+	Type   string   `json:"type"`             // allow, deny, or another ACE type such as allow_callback
+	Flags  []string `json:"flags,omitempty"`  // such as inherited, or object_inherit and container_inherit on a directory
+	Mask   uint32   `json:"mask"`             // the access mask
+	Rights []string `json:"rights,omitempty"` // the access mask as the file rights it grants or denies, e.g. modify
+	SID    string   `json:"sid,omitempty"`    // the trustee
+}
+
+// securityDescriptor is what the metadata keeps of a file's self-relative security descriptor.
+type securityDescriptor struct {
+	owner         string
+	group         string
+	dacl          []AccessControlEntry
+	daclProtected bool // the DACL doesn't inherit entries from the parent directory
+}
+
+// The security descriptor control flags that are used.
+const (
+	securityDaclPresent   = 0x0004
+	securityDaclProtected = 0x1000
+	securitySelfRelative  = 0x8000
+)
+
+var aceTypeNames = map[byte]string{
+	0x00: "allow",
+	0x01: "deny",
+	0x02: "audit",
+	0x03: "alarm",
+	0x05: "allow_object",
+	0x06: "deny_object",
+	0x07: "audit_object",
+	0x08: "alarm_object",
+	0x09: "allow_callback",
+	0x0a: "deny_callback",
+	0x0b: "allow_callback_object",
+	0x0c: "deny_callback_object",
+	0x0d: "audit_callback",
+	0x0f: "audit_callback_object",
+	0x11: "mandatory_label",
+}
+
+// aceFlagNames are the names of the ACE flags, in the order they are listed.
+var aceFlagNames = []struct {
+	flag byte
+	name string
+}{
+	{0x01, "object_inherit"},
+	{0x02, "container_inherit"},
+	{0x04, "no_propagate_inherit"},
+	{0x08, "inherit_only"},
+	{0x10, "inherited"},
+	{0x40, "successful_access"},
+	{0x80, "failed_access"},
+}
+
+// fileRightNames are the names of the file access rights, the combinations Explorer shows first so a mask reads the way
+// it does on the Security tab.
+var fileRightNames = []struct {
+	mask uint32
+	name string
+}{
+	{0x001f01ff, "full_control"},
+	{0x001301bf, "modify"},
+	{0x001200a9, "read_execute"},
+	{0x00120089, "read"},
+	{0x00100116, "write"},
+	{0x00000001, "read_data"},
+	{0x00000002, "write_data"},
+	{0x00000004, "append_data"},
+	{0x00000008, "read_ea"},
+	{0x00000010, "write_ea"},
+	{0x00000020, "execute"},
+	{0x00000040, "delete_child"},
+	{0x00000080, "read_attributes"},
+	{0x00000100, "write_attributes"},
+	{0x00010000, "delete"},
+	{0x00020000, "read_control"},
+	{0x00040000, "write_dac"},
+	{0x00080000, "write_owner"},
+	{0x00100000, "synchronize"},
+	{0x01000000, "access_system_security"},
+	{0x10000000, "generic_all"},
+	{0x20000000, "generic_execute"},
+	{0x40000000, "generic_write"},
+	{0x80000000, "generic_read"},
+}
+
+// parseSecurityDescriptor parses a self-relative security descriptor, as GetNamedSecurityInfo returns it and $Secure
+// keeps it in $SDS.
+func parseSecurityDescriptor(data []byte) (descriptor securityDescriptor, err error) {
+	const headerSize = 20
+	if len(data) < headerSize {
+		err = fmt.Errorf("the security descriptor is %d bytes, too short for its header", len(data))
+		return
+	}
+	control := binary.LittleEndian.Uint16(data[2:])
+	if control&securitySelfRelative == 0 {
+		err = errors.New("the security descriptor isn't self-relative")
+		return
+	}
+	offsetOwner := binary.LittleEndian.Uint32(data[4:])
+	offsetGroup := binary.LittleEndian.Uint32(data[8:])
+	offsetDacl := binary.LittleEndian.Uint32(data[16:])
+	if descriptor.owner, err = securityDescriptorSID(data, offsetOwner); err != nil {
+		err = fmt.Errorf("failed to parse the owner: %w", err)
+		return
+	}
+	if descriptor.group, err = securityDescriptorSID(data, offsetGroup); err != nil {
+		err = fmt.Errorf("failed to parse the group: %w", err)
+		return
+	}
+	descriptor.daclProtected = control&securityDaclProtected != 0
+	if control&securityDaclPresent != 0 && offsetDacl != 0 {
+		if descriptor.dacl, err = parseACL(data, offsetDacl); err != nil {
+			err = fmt.Errorf("failed to parse the DACL: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// securityDescriptorSID formats the SID at an offset of the security descriptor, empty when the offset is zero.
+func securityDescriptorSID(data []byte, offset uint32) (sid string, err error) {
+	if offset == 0 {
+		return
+	}
+	if uint64(offset) >= uint64(len(data)) {
+		err = fmt.Errorf("the SID at offset %d is past the end of the security descriptor", offset)
+		return
+	}
+	sid, ok := formatSID(data[offset:])
+	if !ok {
+		err = fmt.Errorf("the SID at offset %d is truncated", offset)
+	}
+	return
+}
+
+// parseACL parses the access control entries of the ACL at an offset of the security descriptor.
+func parseACL(data []byte, offset uint32) (entries []AccessControlEntry, err error) {
+	const (
+		aclHeaderSize = 8
+		aceHeaderSize = 4
+	)
+	if uint64(offset)+aclHeaderSize > uint64(len(data)) {
+		err = fmt.Errorf("the ACL at offset %d is past the end of the security descriptor", offset)
+		return
+	}
+	acl := data[offset:]
+	aclSize := int(binary.LittleEndian.Uint16(acl[2:]))
+	aceCount := int(binary.LittleEndian.Uint16(acl[4:]))
+	if aclSize < aclHeaderSize || aclSize > len(acl) {
+		err = fmt.Errorf("the ACL's size of %d bytes doesn't fit in the security descriptor", aclSize)
+		return
+	}
+	acl = acl[:aclSize]
+	entries = make([]AccessControlEntry, 0, aceCount)
+	position := aclHeaderSize
+	for index := 0; index < aceCount; index++ {
+		if position+aceHeaderSize > len(acl) {
+			err = fmt.Errorf("ACE %d is past the end of the ACL", index)
+			return
+		}
+		aceType, aceFlags := acl[position], acl[position+1]
+		aceSize := int(binary.LittleEndian.Uint16(acl[position+2:]))
+		if aceSize < aceHeaderSize+4 || position+aceSize > len(acl) {
+			err = fmt.Errorf("ACE %d's size of %d bytes doesn't fit in the ACL", index, aceSize)
+			return
+		}
+		ace := acl[position : position+aceSize]
+		entry := AccessControlEntry{Type: aceTypeNames[aceType], Mask: binary.LittleEndian.Uint32(ace[4:])}
+		if entry.Type == "" {
+			entry.Type = fmt.Sprintf("0x%02x", aceType)
+		}
+		for _, flag := range aceFlagNames {
+			if aceFlags&flag.flag != 0 {
+				entry.Flags = append(entry.Flags, flag.name)
+			}
+		}
+		entry.Rights = fileRights(entry.Mask)
+		// The object ACE types have their object GUIDs before the SID, and aren't used on files
+		switch aceType {
+		case 0x00, 0x01, 0x02, 0x03, 0x09, 0x0a, 0x0d, 0x11:
+			if sid, ok := formatSID(ace[8:]); ok {
+				entry.SID = sid
+			}
+		}
+		entries = append(entries, entry)
+		position += aceSize
+	}
+	return
+}
+
+// fileRights names the rights of an access mask, the combinations first and then the rights that are left over.
+func fileRights(mask uint32) (rights []string) {
+	const synchronize = 0x00100000
+	remaining := mask
+	for _, right := range fileRightNames {
+		// Synchronize is part of every combination, so it doesn't count toward whether one is left over
+		if mask&right.mask == right.mask && remaining&(right.mask&^synchronize) == right.mask&^synchronize && (right.mask != synchronize || remaining&synchronize != 0) {
+			rights = append(rights, right.name)
+			remaining &^= right.mask
+		}
+	}
+	return
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// testSID builds a binary SID.
+func testSID(authority byte, subAuthorities ...uint32) (sid []byte) {
+	sid = make([]byte, 8+4*len(subAuthorities))
+	sid[0], sid[1], sid[7] = 1, byte(len(subAuthorities)), authority
+	for index, subAuthority := range subAuthorities {
+		binary.LittleEndian.PutUint32(sid[8+4*index:], subAuthority)
+	}
+	return
+}
+
+// testACE builds an ACE with an access mask and a SID.
+func testACE(aceType, flags byte, mask uint32, sid []byte) (ace []byte) {
+	ace = make([]byte, 8, 8+len(sid))
+	ace[0], ace[1] = aceType, flags
+	binary.LittleEndian.PutUint16(ace[2:], uint16(8+len(sid)))
+	binary.LittleEndian.PutUint32(ace[4:], mask)
+	return append(ace, sid...)
+}
+
+// testSecurityDescriptor builds a self-relative security descriptor with an owner, a group and a DACL of the ACEs.
+func testSecurityDescriptor(control uint16, owner, group []byte, aces ...[]byte) (descriptor []byte) {
+	descriptor = make([]byte, 20)
+	descriptor[0] = 1
+	binary.LittleEndian.PutUint16(descriptor[2:], control|securitySelfRelative)
+	binary.LittleEndian.PutUint32(descriptor[4:], uint32(len(descriptor)))
+	descriptor = append(descriptor, owner...)
+	binary.LittleEndian.PutUint32(descriptor[8:], uint32(len(descriptor)))
+	descriptor = append(descriptor, group...)
+	if control&securityDaclPresent == 0 {
+		return
+	}
+	binary.LittleEndian.PutUint32(descriptor[16:], uint32(len(descriptor)))
+	acl := []byte{2, 0, 0, 0, byte(len(aces)), 0, 0, 0}
+	for _, ace := range aces {
+		acl = append(acl, ace...)
+	}
+	binary.LittleEndian.PutUint16(acl[2:], uint16(len(acl)))
+	return append(descriptor, acl...)
+}
+
+func Test_parseSecurityDescriptor(t *testing.T) {
+	system, administrators, users := testSID(5, 18), testSID(5, 32, 544), testSID(5, 32, 545)
+	tests := []struct {
+		name       string
+		descriptor []byte
+		want       securityDescriptor
+		wantErr    bool
+	}{
+		{
+			name: "inherited DACL",
+			descriptor: testSecurityDescriptor(securityDaclPresent, administrators, system,
+				testACE(0x00, 0x10, 0x001f01ff, system),
+				testACE(0x00, 0x13, 0x001200a9, users),
+				testACE(0x01, 0x00, 0x00010000, users),
+			),
+			want: securityDescriptor{
+				owner: "S-1-5-32-544",
+				group: "S-1-5-18",
+				dacl: []AccessControlEntry{
+					{Type: "allow", Flags: []string{"inherited"}, Mask: 0x001f01ff, Rights: []string{"full_control"}, SID: "S-1-5-18"},
+					{Type: "allow", Flags: []string{"object_inherit", "container_inherit", "inherited"}, Mask: 0x001200a9, Rights: []string{"read_execute"}, SID: "S-1-5-32-545"},
+					{Type: "deny", Mask: 0x00010000, Rights: []string{"delete"}, SID: "S-1-5-32-545"},
+				},
+			},
+		},
+		{
+			name:       "protected DACL",
+			descriptor: testSecurityDescriptor(securityDaclPresent|securityDaclProtected, users, users, testACE(0x00, 0x00, 0x001201bf, users)),
+			want: securityDescriptor{
+				owner:         "S-1-5-32-545",
+				group:         "S-1-5-32-545",
+				dacl:          []AccessControlEntry{{Type: "allow", Mask: 0x001201bf, Rights: []string{"read_execute", "write"}, SID: "S-1-5-32-545"}},
+				daclProtected: true,
+			},
+		},
+		{
+			name:       "no DACL",
+			descriptor: testSecurityDescriptor(0, system, system),
+			want:       securityDescriptor{owner: "S-1-5-18", group: "S-1-5-18"},
+		},
+		{
+			name:       "unknown ACE type",
+			descriptor: testSecurityDescriptor(securityDaclPresent, system, system, testACE(0x14, 0x00, 0x00000001, system)),
+			want:       securityDescriptor{owner: "S-1-5-18", group: "S-1-5-18", dacl: []AccessControlEntry{{Type: "0x14", Mask: 1, Rights: []string{"read_data"}}}},
+		},
+		{name: "too short", descriptor: make([]byte, 10), wantErr: true},
+		{name: "absolute", descriptor: make([]byte, 20), wantErr: true},
+		{name: "truncated ACL", descriptor: testSecurityDescriptor(securityDaclPresent, system, system, testACE(0x00, 0x00, 1, system))[:60], wantErr: true},
+		{name: "truncated owner", descriptor: testSecurityDescriptor(0, system, system)[:24], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSecurityDescriptor(tt.descriptor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSecurityDescriptor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSecurityDescriptor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fileRights(t *testing.T) {
+	tests := []struct {
+		mask uint32
+		want []string
+	}{
+		{mask: 0x001f01ff, want: []string{"full_control"}},
+		{mask: 0x001301bf, want: []string{"modify"}},
+		{mask: 0x00120089, want: []string{"read"}},
+		{mask: 0x00100116, want: []string{"write"}},
+		{mask: 0x10000000, want: []string{"generic_all"}},
+		{mask: 0x000c0000, want: []string{"write_dac", "write_owner"}},
+		{mask: 0x00100020, want: []string{"execute", "synchronize"}},
+		{mask: 0},
+	}
+	for _, tt := range tests {
+		if got := fileRights(tt.mask); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fileRights(%#x) = %v, want %v", tt.mask, got, tt.want)
+		}
+	}
+}
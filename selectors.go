@@ -0,0 +1,171 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// recordVolume is the full path of a target selecting a file by its MFT record number, which only names the volume.
+var recordVolume = regexp.MustCompile(`^(%systemdrive%|[a-z]):$`)
+
+// compileSelectors checks a target's record number and hashes, which an EDR alert often gives instead of a path, and
+// its extensions, and adds them to its search terms.
+func compileSelectors(value FileToExport, searchKeywords *searchTerms) (err error) {
+	if err = compileExtensions(value, searchKeywords); err != nil {
+		return
+	}
+	if value.RecordNumber != 0 {
+		if value.IsFullPathRegex || !recordVolume.MatchString(value.FullPath) {
+			err = fmt.Errorf("selecting MFT record %d needs the full path to be just its volume, e.g. C:", value.RecordNumber)
+			return
+		}
+		searchKeywords.recordNumber = value.RecordNumber
+	}
+	if value.SHA256 == "" {
+		return
+	}
+	searchKeywords.hashes = make(map[string]bool)
+	for _, hash := range strings.Split(value.SHA256, ",") {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if decoded, decodeErr := hex.DecodeString(hash); decodeErr != nil || len(decoded) != sha256.Size {
+			err = fmt.Errorf("'%s' is not a SHA-256 hash", hash)
+			return
+		}
+		searchKeywords.hashes[hash] = true
+	}
+	return
+}
+
+// compileExtensions checks the extensions of a target sweeping a volume or directory for them, which can be written as
+// ps1, .ps1 or *.ps1.
+func compileExtensions(value FileToExport, searchKeywords *searchTerms) (err error) {
+	if value.Extensions == "" {
+		return
+	}
+	if value.FileName != "" || value.RecordNumber != 0 {
+		err = errors.New("a target selects files by file name, record number or extensions, not more than one")
+		return
+	}
+	if value.IsFullPathRegex {
+		err = errors.New("extensions need the full path to be the volume or directory to look under, not a regex")
+		return
+	}
+	searchKeywords.extensions = make(map[string]bool)
+	for _, extension := range strings.Split(value.Extensions, ",") {
+		extension = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(extension)), "*"), ".")
+		if extension == "" || strings.ContainsAny(extension, `\/*?`) {
+			err = fmt.Errorf("'%s' is not an extension", value.Extensions)
+			return
+		}
+		searchKeywords.extensions[extension] = true
+	}
+	return
+}
+
+// fileExtension returns the lowercased extension of a file name or path, without its dot.
+func fileExtension(path string) string {
+	name := path[strings.LastIndex(path, `\`)+1:]
+	dot := strings.LastIndex(name, ".")
+	if dot == -1 {
+		return ""
+	}
+	return strings.ToLower(name[dot+1:])
+}
+
+// sweepsName reports whether the term's extensions select a file by its name, before its path is known.
+func (terms searchTerms) sweepsName(fileName string) bool {
+	return terms.extensions[fileExtension(fileName)]
+}
+
+// sweeps reports whether the term's extensions select the file at path, which has to be under the term's full path.
+func (terms searchTerms) sweeps(path string) bool {
+	return terms.extensions != nil && strings.HasPrefix(path, terms.fullPathString+`\`) && terms.sweepsName(path)
+}
+
+// selectsRecord reports whether the term selects a file record on a volume by its number.
+func (terms searchTerms) selectsRecord(volumeLetter string, recordNumber uint32) bool {
+	return terms.recordNumber != 0 && terms.recordNumber == recordNumber && terms.fullPathString == strings.ToLower(volumeLetter)+":"
+}
+
+// selectRecord returns a long name of a file record one of the terms selects by its number.
+func (listOfSearchKeywords listOfSearchTerms) selectRecord(volumeLetter string, recordNumber uint32, fileNameAttributes mft.FileNameAttributes) (result bool, fileNameAttribute mft.FileNameAttribute) {
+	for _, terms := range listOfSearchKeywords {
+		if !terms.selectsRecord(volumeLetter, recordNumber) {
+			continue
+		}
+		if names := longFileNames(fileNameAttributes); len(names) != 0 {
+			result, fileNameAttribute = true, names[0]
+		}
+		return
+	}
+	return
+}
+
+// selectsRecords reports whether any of the terms selects a file by its record number, which a cached MFT can't look up.
+func (listOfSearchKeywords listOfSearchTerms) selectsRecords() bool {
+	for _, terms := range listOfSearchKeywords {
+		if terms.recordNumber != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// keepHashMatches reads the files whose targets select them by hash and leaves out the ones with other hashes. A dry
+// run doesn't read any files, so it keeps them all.
+func (collector *Collector) keepHashMatches(ctx context.Context, volumeLetter string, files foundFiles, open func(file foundFile) (io.Reader, error)) (kept foundFiles) {
+	for _, file := range files {
+		if file.hashes == nil || collector.planner != nil {
+			kept = append(kept, file)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		sum, err := collector.hashFoundFile(ctx, file, open)
+		if err != nil {
+			collector.report.fileFailed(file.fullPath, volumeLetter, fmt.Errorf("failed to hash it: %w", err))
+			continue
+		}
+		if !file.hashes[sum] {
+			collector.logger().Debugf("Leaving out '%s', its SHA-256 %s isn't one the target is after.", file.fullPath, sum)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return
+}
+
+// foundFileOpener opens files on a volume the way they would be collected.
+func (collector *Collector) foundFileOpener(volumeHandler *VolumeHandler) func(file foundFile) (io.Reader, error) {
+	return func(file foundFile) (io.Reader, error) {
+		reader, _, _ := collector.openFoundFile(volumeHandler, file)
+		return reader, nil
+	}
+}
+
+func (collector *Collector) hashFoundFile(ctx context.Context, file foundFile, open func(file foundFile) (io.Reader, error)) (sum string, err error) {
+	reader, err := open(file)
+	if err != nil {
+		return
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, collector.limitReader(ctx, reader))
+	if err != nil {
+		return
+	}
+	sum = hex.EncodeToString(hash.Sum(nil))
+	return
+}
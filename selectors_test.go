@@ -0,0 +1,186 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io"
+	"reflect"
+	"testing"
+)
+
+const (
+	helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" // hello
+	worldSHA256 = "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7" // world
+)
+
+func Test_compileSelectors(t *testing.T) {
+	tests := []struct {
+		name           string
+		value          FileToExport
+		wantRecord     uint32
+		wantHashes     map[string]bool
+		wantExtensions map[string]bool
+		wantErr        bool
+	}{
+		{
+			name:  "neither",
+			value: FileToExport{FullPath: `c:\windows\system32\config\sam`, FileName: "sam"},
+		},
+		{
+			name:       "record number",
+			value:      FileToExport{FullPath: `c:`, RecordNumber: 91234},
+			wantRecord: 91234,
+		},
+		{
+			name:    "record number with a path",
+			value:   FileToExport{FullPath: `c:\windows`, RecordNumber: 91234},
+			wantErr: true,
+		},
+		{
+			name:    "record number with a regex",
+			value:   FileToExport{FullPath: `c:`, IsFullPathRegex: true, RecordNumber: 91234},
+			wantErr: true,
+		},
+		{
+			name:       "hashes",
+			value:      FileToExport{FullPath: `c:\\users\\.*`, IsFullPathRegex: true, FileName: `\.exe$`, IsFileNameRegex: true, SHA256: "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824, " + worldSHA256},
+			wantHashes: map[string]bool{helloSHA256: true, worldSHA256: true},
+		},
+		{
+			name:    "not a hash",
+			value:   FileToExport{FullPath: `c:\\users\\.*`, IsFullPathRegex: true, FileName: `\.exe$`, IsFileNameRegex: true, SHA256: "d41d8cd98f00b204e9800998ecf8427e"},
+			wantErr: true,
+		},
+		{
+			name:           "extensions",
+			value:          FileToExport{FullPath: `c:`, Extensions: "ps1, .LNK,*.hta"},
+			wantExtensions: map[string]bool{"ps1": true, "lnk": true, "hta": true},
+		},
+		{
+			name:    "extensions with a file name",
+			value:   FileToExport{FullPath: `c:\users`, FileName: "a.ps1", Extensions: "ps1"},
+			wantErr: true,
+		},
+		{
+			name:    "extensions with a regex",
+			value:   FileToExport{FullPath: `c:\\users\\.*`, IsFullPathRegex: true, Extensions: "ps1"},
+			wantErr: true,
+		},
+		{
+			name:    "empty extension",
+			value:   FileToExport{FullPath: `c:`, Extensions: "ps1,,lnk"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var terms searchTerms
+			err := compileSelectors(tt.value, &terms)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileSelectors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if terms.recordNumber != tt.wantRecord || !reflect.DeepEqual(terms.hashes, tt.wantHashes) {
+				t.Errorf("compileSelectors() = record %d and hashes %v, want record %d and hashes %v", terms.recordNumber, terms.hashes, tt.wantRecord, tt.wantHashes)
+			}
+			if !reflect.DeepEqual(terms.extensions, tt.wantExtensions) {
+				t.Errorf("compileSelectors() = extensions %v, want %v", terms.extensions, tt.wantExtensions)
+			}
+		})
+	}
+}
+
+func Test_confirmFoundFiles_recordNumber(t *testing.T) {
+	terms, err := compileSearchTerms(FileToExport{FullPath: `C:`, RecordNumber: 91234})
+	if err != nil {
+		t.Fatalf("compileSearchTerms() error = %v", err)
+	}
+	matches := possibleMatches{
+		{fileNameAttribute: mft.FileNameAttribute{FileName: "payload.exe", FileNamespace: "WIN32", ParentDirRecordNumber: 5}, metadata: recordMetadata{recordNumber: 91234}},
+		{fileNameAttribute: mft.FileNameAttribute{FileName: "other.exe", FileNamespace: "WIN32", ParentDirRecordNumber: 5}, metadata: recordMetadata{recordNumber: 91235}},
+	}
+	directoryTree := mft.DirectoryTree{5: `c:\users\bob\downloads`}
+
+	got := confirmFoundFiles(loggerOrDefault(nil), listOfSearchTerms{terms}, matches, directoryTree)
+	if len(got) != 1 || got[0].fullPath != `c:\users\bob\downloads\payload.exe` {
+		t.Errorf("confirmFoundFiles() = %+v, want only payload.exe", got)
+	}
+	if got := confirmFoundFiles(loggerOrDefault(nil), listOfSearchTerms{terms}, matches, mft.DirectoryTree{5: `d:\data`}); len(got) != 0 {
+		t.Errorf("confirmFoundFiles() = %+v on volume d, want nothing", got)
+	}
+
+	if ok, name := (listOfSearchTerms{terms}).selectRecord("C", 91234, mft.FileNameAttributes{{FileName: "PAYLOA~1.EXE", FileNamespace: "DOS"}, {FileName: "payload.exe", FileNamespace: "WIN32"}}); !ok || name.FileName != "payload.exe" {
+		t.Errorf("selectRecord() = %v, %q, want true, payload.exe", ok, name.FileName)
+	}
+	if ok, _ := (listOfSearchTerms{terms}).selectRecord("D", 91234, mft.FileNameAttributes{{FileName: "payload.exe", FileNamespace: "WIN32"}}); ok {
+		t.Errorf("selectRecord() selected record 91234 of volume D")
+	}
+}
+
+func Test_confirmFoundFiles_extensions(t *testing.T) {
+	terms, err := compileSearchTerms(FileToExport{FullPath: `C:\Users`, Extensions: "ps1,lnk"})
+	if err != nil {
+		t.Fatalf("compileSearchTerms() error = %v", err)
+	}
+	names := map[string]bool{"run.PS1": true, "report.lnk": true, "notes.txt": false, "ps1": false, "archive.ps1.bak": false}
+	for name, want := range names {
+		if got, _, _ := checkForPossibleMatch(listOfSearchTerms{terms}, mft.FileNameAttributes{{FileName: name, FileNamespace: "WIN32"}}); got != want {
+			t.Errorf("checkForPossibleMatch() = %v for %s, want %v", got, name, want)
+		}
+	}
+
+	matches := possibleMatches{
+		{fileNameAttribute: mft.FileNameAttribute{FileName: "run.ps1", FileNamespace: "WIN32", ParentDirRecordNumber: 5}},
+		{fileNameAttribute: mft.FileNameAttribute{FileName: "run.ps1", FileNamespace: "WIN32", ParentDirRecordNumber: 6}},
+		{fileNameAttribute: mft.FileNameAttribute{FileName: "run.ps1", FileNamespace: "WIN32", ParentDirRecordNumber: 7}},
+	}
+	directoryTree := mft.DirectoryTree{5: `c:\users\bob\downloads`, 6: `c:\windows\temp`, 7: `c:\users2`}
+	got := confirmFoundFiles(loggerOrDefault(nil), listOfSearchTerms{terms}, matches, directoryTree)
+	if len(got) != 1 || got[0].fullPath != `c:\users\bob\downloads\run.ps1` {
+		t.Errorf("confirmFoundFiles() = %+v, want only the script under c:\\users", got)
+	}
+}
+
+func Test_keepHashMatches(t *testing.T) {
+	contents := map[string]string{`c:\a.exe`: "hello", `c:\b.exe`: "world", `c:\c.exe`: "other"}
+	open := func(file foundFile) (io.Reader, error) {
+		content, ok := contents[file.fullPath]
+		if !ok {
+			return nil, errors.New("access denied")
+		}
+		return bytes.NewReader([]byte(content)), nil
+	}
+	hashes := map[string]bool{helloSHA256: true, worldSHA256: true}
+	files := foundFiles{
+		{fullPath: `c:\a.exe`, hashes: hashes},
+		{fullPath: `c:\b.exe`, hashes: hashes},
+		{fullPath: `c:\c.exe`, hashes: hashes},
+		{fullPath: `c:\locked.exe`, hashes: hashes},
+		{fullPath: `c:\windows\system32\config\sam`},
+	}
+	collector := &Collector{report: newReportBuilder()}
+
+	got := collector.keepHashMatches(context.Background(), "c", files, open)
+	var gotPaths []string
+	for _, file := range got {
+		gotPaths = append(gotPaths, file.fullPath)
+	}
+	wantPaths := []string{`c:\a.exe`, `c:\b.exe`, `c:\windows\system32\config\sam`}
+	if !reflect.DeepEqual(gotPaths, wantPaths) {
+		t.Errorf("keepHashMatches() = %v, want %v", gotPaths, wantPaths)
+	}
+	if report := collector.report.snapshot(); len(report.Files) != 1 || report.Files[0].Path != `c:\locked.exe` {
+		t.Errorf("keepHashMatches() reported %+v, want only c:\\locked.exe failing", report.Files)
+	}
+
+	collector.planner = &filePlanner{}
+	if got := collector.keepHashMatches(context.Background(), "c", files, open); len(got) != len(files) {
+		t.Errorf("keepHashMatches() kept %d files in a dry run, want all %d", len(got), len(files))
+	}
+}
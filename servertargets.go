@@ -0,0 +1,212 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"golang.org/x/sys/windows/registry"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// serverInstalls is where the server roles with logs worth collecting for server-compromise triage are installed and
+// log to, as this machine's registry and configuration say.
+type serverInstalls struct {
+	iisConfig               string   // applicationHost.config, which also sets where the sites log
+	iisLogDirectories       []string // each site's and the central log directories, parents of the W3SVC<id> directories
+	exchangeDirectories     []string // the install directory of each version of Exchange
+	sqlServerLogDirectories []string // the directory of each instance's ERRORLOG
+}
+
+// defaultIISLogDirectory is where IIS logs when applicationHost.config doesn't say.
+const defaultIISLogDirectory = `%SystemDrive%\inetpub\logs\LogFiles`
+
+// ServerLogTargets returns targets for the logs of the server roles installed on this machine: the logs of every IIS
+// site, Exchange's transport logs and the logs of its services, which include what its IIS front end proxied, and the
+// ERRORLOG of every SQL Server instance with the ones it rolled over. The install and log directories are read from the
+// registry and IIS's configuration rather than assumed, so roles installed or logging somewhere else are found too, and
+// there are no targets for roles that aren't installed.
+func ServerLogTargets(logger Logger) ListOfFilesToExport {
+	return serverLogTargets(discoverServerInstalls(loggerOrDefault(logger)))
+}
+
+func serverLogTargets(installs serverInstalls) (exportList ListOfFilesToExport) {
+	if installs.iisConfig != "" {
+		if target, ok := fileTarget(installs.iisConfig, 30); ok {
+			exportList = append(exportList, target)
+		}
+	}
+	for _, directory := range installs.iisLogDirectories {
+		exportList = appendDirectoryTarget(exportList, directory, `W3SVC[0-9]+\\[^\\]+\.log$`, `.*\.log$`, 25)
+	}
+	for _, directory := range installs.exchangeDirectories {
+		exportList = appendDirectoryTarget(exportList, directory, `TransportRoles\\Logs\\.+\.log$`, `.*\.log$`, 20)
+		exportList = appendDirectoryTarget(exportList, directory, `Logging\\.+\.log$`, `.*\.log$`, 10)
+	}
+	for _, directory := range installs.sqlServerLogDirectories {
+		exportList = appendDirectoryTarget(exportList, directory, `ERRORLOG(\.[0-9]+)?$`, `^ERRORLOG(\.[0-9]+)?$`, 25)
+	}
+	return
+}
+
+// appendDirectoryTarget appends a regex target for the files under a directory that match rest. Directories that aren't
+// on a volume with a letter, such as shares, are left out.
+func appendDirectoryTarget(exportList ListOfFilesToExport, directory string, rest string, fileName string, priority int) ListOfFilesToExport {
+	directory = strings.TrimRight(strings.ToLower(directory), `\`)
+	if len(directory) < 2 || directory[1] != ':' {
+		return exportList
+	}
+	return append(exportList, FileToExport{
+		FullPath:        regexp.QuoteMeta(directory) + `\\` + rest,
+		IsFullPathRegex: true,
+		FileName:        fileName,
+		IsFileNameRegex: true,
+		Priority:        priority,
+	})
+}
+
+// fileTarget is a literal target for a file.
+func fileTarget(path string, priority int) (target FileToExport, ok bool) {
+	path = strings.ToLower(path)
+	if len(path) < 3 || path[1] != ':' {
+		return
+	}
+	return FileToExport{
+		FullPath:        path,
+		IsFullPathRegex: false,
+		FileName:        path[strings.LastIndex(path, `\`)+1:],
+		IsFileNameRegex: false,
+		Priority:        priority,
+	}, true
+}
+
+// discoverServerInstalls reads where IIS, Exchange and SQL Server are installed from the registry. It's a variable so
+// tests don't depend on what's installed on the host.
+var discoverServerInstalls = func(logger Logger) (installs serverInstalls) {
+	if installPath := registryString(logger, `SOFTWARE\Microsoft\InetStp`, "InstallPath"); installPath != "" {
+		installs.iisConfig = filepath.Join(installPath, "config", "applicationHost.config")
+		config, err := ioutil.ReadFile(installs.iisConfig)
+		if err != nil {
+			logger.Debugf("Failed to read the IIS configuration %s: %v", installs.iisConfig, err)
+		}
+		for _, directory := range iisLogDirectories(config) {
+			installs.iisLogDirectories = append(installs.iisLogDirectories, expandRegistryString(directory))
+		}
+	}
+	for _, version := range []string{"v15", "v14"} {
+		if installPath := registryString(logger, `SOFTWARE\Microsoft\ExchangeServer\`+version+`\Setup`, "MsiInstallPath"); installPath != "" {
+			installs.exchangeDirectories = append(installs.exchangeDirectories, installPath)
+		}
+	}
+	instanceNames, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Microsoft SQL Server\Instance Names\SQL`, registry.QUERY_VALUE)
+	if err != nil {
+		logger.Debugf("No SQL Server instances are listed: %v", err)
+		return
+	}
+	defer instanceNames.Close()
+	names, _ := instanceNames.ReadValueNames(-1)
+	for _, name := range names {
+		instance, _, err := instanceNames.GetStringValue(name)
+		if err != nil {
+			continue
+		}
+		if directory := sqlServerLogDirectory(sqlServerStartupParameters(logger, instance)); directory != "" {
+			installs.sqlServerLogDirectories = append(installs.sqlServerLogDirectories, directory)
+		} else {
+			logger.Debugf("The startup parameters of the SQL Server instance %s don't say where its ERRORLOG is.", name)
+		}
+	}
+	return
+}
+
+// sqlServerStartupParameters reads an instance's startup parameters, SQLArg0, SQLArg1 and so on.
+func sqlServerStartupParameters(logger Logger, instance string) (args []string) {
+	path := `SOFTWARE\Microsoft\Microsoft SQL Server\` + instance + `\MSSQLServer\Parameters`
+	parameters, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		logger.Debugf("Failed to open %s: %v", path, err)
+		return
+	}
+	defer parameters.Close()
+	for index := 0; ; index++ {
+		arg, _, err := parameters.GetStringValue(fmt.Sprintf("SQLArg%d", index))
+		if err != nil {
+			return
+		}
+		args = append(args, expandRegistryString(arg))
+	}
+}
+
+// registryString reads a string value under HKLM, or returns "" when there isn't one.
+func registryString(logger Logger, path string, name string) (value string) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		logger.Debugf("Failed to open %s: %v", path, err)
+		return
+	}
+	defer key.Close()
+	value, _, err = key.GetStringValue(name)
+	if err != nil {
+		return ""
+	}
+	return expandRegistryString(value)
+}
+
+// expandRegistryString expands the environment variables in a path, such as %SystemDrive%.
+func expandRegistryString(value string) string {
+	if expanded, err := registry.ExpandString(value); err == nil {
+		return expanded
+	}
+	return value
+}
+
+// iisLogDirectories returns the directories applicationHost.config has the sites log to: the default every site
+// inherits, those sites set for themselves and the central log files'. IIS's own default is always among them, since
+// the sites may have logged there before the configuration was changed.
+func iisLogDirectories(config []byte) (directories []string) {
+	seen := make(map[string]bool)
+	add := func(directory string) {
+		if directory != "" && !seen[strings.ToLower(directory)] {
+			seen[strings.ToLower(directory)] = true
+			directories = append(directories, directory)
+		}
+	}
+	add(defaultIISLogDirectory)
+	decoder := xml.NewDecoder(bytes.NewReader(config))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch element.Name.Local {
+		case "logFile", "centralW3CLogFile", "centralBinaryLogFile":
+			for _, attribute := range element.Attr {
+				if attribute.Name.Local == "directory" {
+					add(attribute.Value)
+				}
+			}
+		}
+	}
+	return
+}
+
+// sqlServerLogDirectory returns the directory of the ERRORLOG an instance's startup parameters give with -e.
+func sqlServerLogDirectory(args []string) string {
+	for _, arg := range args {
+		if len(arg) > 2 && strings.EqualFold(arg[:2], "-e") {
+			path := strings.TrimSpace(arg[2:])
+			if index := strings.LastIndex(path, `\`); index > 0 {
+				return path[:index]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func Test_iisLogDirectories(t *testing.T) {
+	config := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<configuration>
+  <system.applicationHost>
+    <log centralLogFileMode="Site">
+      <centralW3CLogFile enabled="true" directory="%SystemDrive%\inetpub\logs\LogFiles" />
+    </log>
+    <sites>
+      <site name="Default Web Site" id="1">
+        <logFile directory="D:\IISLogs" />
+      </site>
+      <siteDefaults>
+        <logFile logFormat="W3C" directory="%SystemDrive%\INETPUB\logs\LogFiles" />
+      </siteDefaults>
+    </sites>
+  </system.applicationHost>
+</configuration>`)
+	want := []string{defaultIISLogDirectory, `D:\IISLogs`}
+	if got := iisLogDirectories(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("iisLogDirectories() = %v, want %v", got, want)
+	}
+	if got := iisLogDirectories([]byte("not xml <")); !reflect.DeepEqual(got, []string{defaultIISLogDirectory}) {
+		t.Errorf("iisLogDirectories() of a broken config = %v, want only the default", got)
+	}
+}
+
+func Test_sqlServerLogDirectory(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "default instance",
+			args: []string{`-dC:\Program Files\Microsoft SQL Server\MSSQL15.MSSQLSERVER\MSSQL\DATA\master.mdf`, `-eC:\Program Files\Microsoft SQL Server\MSSQL15.MSSQLSERVER\MSSQL\Log\ERRORLOG`, `-lC:\Program Files\Microsoft SQL Server\MSSQL15.MSSQLSERVER\MSSQL\DATA\mastlog.ldf`},
+			want: `C:\Program Files\Microsoft SQL Server\MSSQL15.MSSQLSERVER\MSSQL\Log`,
+		},
+		{name: "upper case switch", args: []string{`-E E:\SQLLogs\ERRORLOG`}, want: `E:\SQLLogs`},
+		{name: "no errorlog", args: []string{`-dC:\master.mdf`}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqlServerLogDirectory(tt.args); got != tt.want {
+				t.Errorf("sqlServerLogDirectory() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_serverLogTargets(t *testing.T) {
+	targets := serverLogTargets(serverInstalls{
+		iisConfig:               `C:\Windows\system32\inetsrv\config\applicationHost.config`,
+		iisLogDirectories:       []string{`C:\inetpub\logs\LogFiles`, `\\logserver\iis`},
+		exchangeDirectories:     []string{`D:\Program Files\Microsoft\Exchange Server\V15\`},
+		sqlServerLogDirectories: []string{`C:\Program Files\Microsoft SQL Server\MSSQL15.MSSQLSERVER\MSSQL\Log`},
+	})
+	tests := []struct {
+		path    string
+		matched bool
+	}{
+		{`c:\windows\system32\inetsrv\config\applicationhost.config`, true},
+		{`c:\inetpub\logs\logfiles\w3svc1\u_ex201005.log`, true},
+		{`c:\inetpub\logs\logfiles\httperr\httperr1.log`, false},
+		{`d:\program files\microsoft\exchange server\v15\transportroles\logs\messagetracking\msgtrk2020100501-1.log`, true},
+		{`d:\program files\microsoft\exchange server\v15\logging\httpproxy\owa\httpproxy_2020100501-1.log`, true},
+		{`d:\program files\microsoft\exchange server\v15\bin\exsetup.exe`, false},
+		{`c:\program files\microsoft sql server\mssql15.mssqlserver\mssql\log\errorlog`, true},
+		{`c:\program files\microsoft sql server\mssql15.mssqlserver\mssql\log\errorlog.3`, true},
+		{`c:\program files\microsoft sql server\mssql15.mssqlserver\mssql\log\sqlagent.out`, false},
+	}
+	for _, tt := range tests {
+		matched := false
+		for _, target := range targets {
+			if target.IsFullPathRegex {
+				matched = matched || regexp.MustCompile(`(?i)^`+target.FullPath).MatchString(tt.path)
+			} else {
+				matched = matched || target.FullPath == tt.path
+			}
+		}
+		if matched != tt.matched {
+			t.Errorf("%s matched = %v, want %v", tt.path, matched, tt.matched)
+		}
+	}
+	if len(targets) != 5 {
+		t.Errorf("serverLogTargets() = %d targets, want 5 with the share left out: %+v", len(targets), targets)
+	}
+	if got := serverLogTargets(serverInstalls{}); len(got) != 0 {
+		t.Errorf("serverLogTargets() without any roles installed = %+v, want none", got)
+	}
+}
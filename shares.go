@@ -0,0 +1,46 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// isShare reports whether a volume is a share on another machine, such as \\host\c$, rather than a local volume.
+func isShare(volumeLetter string) bool {
+	return strings.HasPrefix(volumeLetter, `\\`)
+}
+
+// shareOf returns the lowercased share a full path is on, e.g. \\host\c$ for \\HOST\C$\Windows\System32\config\SAM, or
+// nothing for a path on a local volume. A regex full path has to spell out its host and share literally, e.g.
+// \\\\host\\c\$\\Users\\.*.
+func shareOf(fullPath string, isRegex bool) string {
+	fullPath = strings.ToLower(fullPath)
+	if isRegex {
+		compiled, err := regexp.Compile(fullPath)
+		if err != nil {
+			return ""
+		}
+		fullPath, _ = compiled.LiteralPrefix()
+	}
+	if !strings.HasPrefix(fullPath, `\\`) {
+		return ""
+	}
+	components := strings.SplitN(fullPath[2:], `\`, 3)
+	if len(components) != 3 || components[0] == "" || components[1] == "" {
+		return ""
+	}
+	return `\\` + components[0] + `\` + components[1]
+}
+
+// collectShare collects from a share on another machine, usually an administrative share such as \\host\c$, so triage
+// artifacts can be pulled from machines an agent can't be deployed to. Shares can't be read raw, so the files are opened
+// with backup semantics as the user the collector runs as, and regex targets are searched for by walking the share.
+func (collector *Collector) collectShare(ctx context.Context, share string, fileReaders chan fileReader, searchTerms listOfSearchTerms) (err error) {
+	collector.logger().Infof("Collecting from the share %s through the API.", share)
+	collector.report.addWalkedVolume(share, "")
+	err = collector.walkVolume(ctx, share, volumeName(share), fileReaders, searchTerms)
+	return
+}
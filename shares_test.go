@@ -0,0 +1,82 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_shareOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		fullPath string
+		isRegex  bool
+		want     string
+	}{
+		{name: "administrative share", fullPath: `\\WS042\C$\Windows\System32\config\SAM`, want: `\\ws042\c$`},
+		{name: "regex", fullPath: `\\\\ws042\\c\$\\Users\\([^\\]+)\\ntuser.dat`, isRegex: true, want: `\\ws042\c$`},
+		{name: "regex with a dotted host", fullPath: `\\\\ws042\.corp\.local\\d\$\\.*\.log$`, isRegex: true, want: `\\ws042.corp.local\d$`},
+		{name: "regex across hosts", fullPath: `\\\\ws[0-9]+\\c\$\\.*`, isRegex: true},
+		{name: "local volume", fullPath: `C:\Windows\System32\config\SAM`},
+		{name: "just the share", fullPath: `\\WS042\C$`},
+		{name: "no host", fullPath: `\\\C$\Windows`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shareOf(tt.fullPath, tt.isRegex); got != tt.want {
+				t.Errorf("shareOf() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectWithReport_share(t *testing.T) {
+	file, err := ioutil.TempFile("", "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("notes")
+	file.Close()
+
+	var opened []string
+	defer func(original func(path string) (*os.File, error)) { openWithBackupSemantics = original }(openWithBackupSemantics)
+	openWithBackupSemantics = func(path string) (*os.File, error) {
+		opened = append(opened, path)
+		return os.Open(file.Name())
+	}
+	collection := NewCollector(CollectOptions{})
+	collection.volumes = missingVolumeHandler{missingVolume: `\\ws042\c$`}
+	targets := ListOfFilesToExport{
+		{FullPath: `\\WS042\C$\$MFT`, FileName: `$MFT`},
+		{FullPath: `\\WS042\C$\Windows\notes.txt`, FileName: `notes.txt`},
+		{FullPath: `\\WS042\D$\Data\notes.txt`, FileName: `notes.txt`},
+	}
+	output := new(bytes.Buffer)
+	report, err := collection.CollectWithReport(context.Background(), targets, &ZipResultWriter{ZipWriter: zip.NewWriter(output)})
+	if err != nil {
+		t.Fatalf("Collector.CollectWithReport() error = %v", err)
+	}
+	if len(report.Volumes) != 2 || report.Volumes[0].Letter != `\\ws042\c$` || report.Volumes[1].Letter != `\\ws042\d$` || report.FilesCollected != 2 {
+		t.Errorf("Collector.CollectWithReport() report = %+v, want notes.txt from both shares", report)
+	}
+	if len(opened) != 2 || opened[0] != `\\ws042\c$\windows\notes.txt` || opened[1] != `\\ws042\d$\data\notes.txt` {
+		t.Errorf("the collection opened %q, want both notes.txt through their shares", opened)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, file := range archive.File {
+		found = found || file.Name == "ws042/c$/windows/notes.txt"
+	}
+	if !found {
+		t.Error("the zip has no ws042/c$/windows/notes.txt")
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// archiveSignatureExtension is added to the name of an archive for its detached signature, e.g. host.zip.sig.
+const archiveSignatureExtension = ".sig"
+
+// ToolInfo identifies the collector that wrote a collection, so it can be shown which build gathered the evidence.
+type ToolInfo struct {
+	Version string `json:"version"`
+	SHA256  string `json:"sha256,omitempty"` // of the executable the collection ran from, empty when it couldn't be read
+}
+
+var (
+	toolOnce sync.Once
+	tool     ToolInfo
+)
+
+// currentTool hashes the running executable the first time it's called.
+func currentTool() ToolInfo {
+	toolOnce.Do(func() {
+		tool.Version = Version
+		executable, err := os.Executable()
+		if err != nil {
+			return
+		}
+		file, err := os.Open(executable)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		hash := sha256.New()
+		if _, err = io.Copy(hash, file); err == nil {
+			tool.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		}
+	})
+	return tool
+}
+
+// ArchiveSignature is the detached signature SignArchive writes next to an archive. Signature is the ed25519 signature
+// of the JSON of everything else in it.
+type ArchiveSignature struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Tool      ToolInfo  `json:"tool"`
+	SignedAt  time.Time `json:"signed_at"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// signedData is what the signature covers.
+func (signature ArchiveSignature) signedData() ([]byte, error) {
+	signature.Signature = nil
+	return json.Marshal(signature)
+}
+
+// SignArchive hashes a finished zip or tar and writes its signature, along with the tool that wrote it, to the archive's
+// name with .sig added. Unlike the signed index inside a tar, it covers the archive as a whole, so the file can be shown
+// to be the one that was collected after it has been moved around. VerifyArchiveSignature checks it.
+func SignArchive(path string, signingKey ed25519.PrivateKey) (err error) {
+	signature := ArchiveSignature{Tool: currentTool(), SignedAt: time.Now().UTC()}
+	signature.Name, signature.Size, signature.SHA256, err = hashArchive(path)
+	if err != nil {
+		return
+	}
+	data, err := signature.signedData()
+	if err != nil {
+		return
+	}
+	signature.Signature = ed25519.Sign(signingKey, data)
+	data, err = json.MarshalIndent(signature, "", "  ")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(path+archiveSignatureExtension, data, 0644)
+	if err != nil {
+		err = fmt.Errorf("SignArchive() failed to write the signature: %w", err)
+	}
+	return
+}
+
+// VerifyArchiveSignature checks an archive against the signature SignArchive wrote next to it, and returns the signature
+// for the tool and time it names.
+func VerifyArchiveSignature(path string, publicKey ed25519.PublicKey) (signature ArchiveSignature, err error) {
+	data, err := ioutil.ReadFile(path + archiveSignatureExtension)
+	if err != nil {
+		err = fmt.Errorf("VerifyArchiveSignature() failed to read the signature: %w", err)
+		return
+	}
+	err = json.Unmarshal(data, &signature)
+	if err != nil {
+		err = fmt.Errorf("VerifyArchiveSignature() failed to parse the signature: %w", err)
+		return
+	}
+	signedData, err := signature.signedData()
+	if err != nil {
+		return
+	}
+	if !ed25519.Verify(publicKey, signedData, signature.Signature) {
+		err = errors.New("VerifyArchiveSignature() found a signature that doesn't match the public key")
+		return
+	}
+	_, size, sha256, err := hashArchive(path)
+	if err != nil {
+		return
+	}
+	if size != signature.Size || sha256 != signature.SHA256 {
+		err = fmt.Errorf("VerifyArchiveSignature() found the archive has changed since it was signed, its SHA-256 is %s instead of %s", sha256, signature.SHA256)
+	}
+	return
+}
+
+func hashArchive(path string) (name string, size int64, sha256Hex string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		err = fmt.Errorf("failed to open the archive: %w", err)
+		return
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err = io.Copy(hash, file)
+	if err != nil {
+		err = fmt.Errorf("failed to read the archive: %w", err)
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	name, sha256Hex = info.Name(), hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+// VerifyZipArchive checks the entries of a zip a ZipResultWriter with a SigningKey wrote against the index at its end,
+// and the index against its signature when publicKey is set. A zip without an index has IndexFound false.
+func VerifyZipArchive(reader io.ReaderAt, size int64, publicKey ed25519.PublicKey) (verification TarVerification, err error) {
+	zipReader, err := zip.NewReader(reader, size)
+	if err != nil {
+		err = fmt.Errorf("VerifyZipArchive() failed to read the zip: %w", err)
+		return
+	}
+	for _, codec := range registeredDecompressors() {
+		zipReader.RegisterDecompressor(codec.Method, codec.Decompressor)
+	}
+	hashes := make(map[string]string)
+	var indexData, signature []byte
+	for _, entry := range zipReader.File {
+		entryReader, openErr := entry.Open()
+		if openErr != nil {
+			verification.Corrupt = append(verification.Corrupt, entry.Name)
+			continue
+		}
+		hash := sha256.New()
+		var data []byte
+		if entry.Name == tarIndexFileName || entry.Name == tarSignatureFileName {
+			data, openErr = ioutil.ReadAll(entryReader)
+		} else {
+			_, openErr = io.Copy(hash, entryReader)
+		}
+		entryReader.Close()
+		switch {
+		case openErr != nil:
+			verification.Corrupt = append(verification.Corrupt, entry.Name)
+		case entry.Name == tarIndexFileName:
+			indexData = data
+		case entry.Name == tarSignatureFileName:
+			signature = data
+		default:
+			hashes[entry.Name] = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+	if indexData == nil {
+		return
+	}
+	verification.IndexFound = true
+	if publicKey != nil {
+		if !ed25519.Verify(publicKey, indexData, signature) {
+			err = errors.New("VerifyZipArchive() found an index that doesn't match its signature")
+			return
+		}
+		verification.Signed = true
+	}
+
+	index := TarIndex{}
+	err = json.Unmarshal(indexData, &index)
+	if err != nil {
+		err = fmt.Errorf("VerifyZipArchive() failed to parse the index: %w", err)
+		return
+	}
+	verification.Complete = index.Complete
+	for _, entry := range index.Entries {
+		if entry.Error != "" {
+			continue
+		}
+		if hash, found := hashes[entry.Name]; found && hash == entry.SHA256 {
+			verification.Entries = append(verification.Entries, entry.Name)
+		} else {
+			verification.Corrupt = append(verification.Corrupt, entry.Name)
+		}
+	}
+	return
+}
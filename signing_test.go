@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestSignArchive(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	tests := []struct {
+		name      string
+		publicKey ed25519.PublicKey
+		tamper    bool
+		wantErr   bool
+	}{
+		{name: "valid", publicKey: publicKey},
+		{name: "wrong key", publicKey: otherKey, wantErr: true},
+		{name: "archive changed", publicKey: publicKey, tamper: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := ioutil.TempFile("", "signed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(archive.Name())
+			defer os.Remove(archive.Name() + archiveSignatureExtension)
+			archive.WriteString("collection")
+			archive.Close()
+
+			if err = SignArchive(archive.Name(), privateKey); err != nil {
+				t.Fatalf("SignArchive() error = %v", err)
+			}
+			if tt.tamper {
+				ioutil.WriteFile(archive.Name(), []byte("collectioN"), 0644)
+			}
+			signature, err := VerifyArchiveSignature(archive.Name(), tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyArchiveSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (signature.Size != 10 || signature.Tool.Version != Version) {
+				t.Errorf("VerifyArchiveSignature() = %+v, want 10 bytes signed by version %s", signature, Version)
+			}
+		})
+	}
+}
+
+func TestVerifyZipArchive(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	writeZip := func(signingKey ed25519.PrivateKey) []byte {
+		output := new(bytes.Buffer)
+		zipResultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output), SigningKey: signingKey}
+		fileReaders := make(chan fileReader, 1)
+		fileReaders <- fileReader{fullPath: `c:\windows\system32\config\sam`, reader: bytes.NewReader([]byte("regf"))}
+		close(fileReaders)
+		waitForFileCopying := sync.WaitGroup{}
+		waitForFileCopying.Add(1)
+		if err := zipResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+			t.Fatalf("ZipResultWriter.ResultWriter() error = %v", err)
+		}
+		return output.Bytes()
+	}
+	tests := []struct {
+		name        string
+		zip         []byte
+		publicKey   ed25519.PublicKey
+		wantIndex   bool
+		wantSigned  bool
+		wantEntries int
+		wantErr     bool
+	}{
+		{name: "signed", zip: writeZip(privateKey), publicKey: publicKey, wantIndex: true, wantSigned: true, wantEntries: 1},
+		{name: "index without a public key", zip: writeZip(privateKey), wantIndex: true, wantEntries: 1},
+		{name: "wrong key", zip: writeZip(privateKey), publicKey: otherKey, wantIndex: true, wantErr: true},
+		{name: "no index", zip: writeZip(nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyZipArchive(bytes.NewReader(tt.zip), int64(len(tt.zip)), tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyZipArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.IndexFound != tt.wantIndex || got.Signed != tt.wantSigned || len(got.Entries) != tt.wantEntries || len(got.Corrupt) != 0 {
+				t.Errorf("VerifyZipArchive() = %+v, want an index %v, signed %v and %d entries", got, tt.wantIndex, tt.wantSigned, tt.wantEntries)
+			}
+			if tt.wantEntries != 0 && !got.Complete {
+				t.Errorf("VerifyZipArchive() found an incomplete index")
+			}
+		})
+	}
+}
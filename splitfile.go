@@ -0,0 +1,232 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SplitManifestExtension is added to the name of a split output for its manifest, e.g. host.zip.parts.json.
+const SplitManifestExtension = ".parts.json"
+
+// SplitManifest lists the parts of a split output in order, with the hash of each and of the whole they add up to.
+type SplitManifest struct {
+	Name     string      `json:"name"` // what joining the parts gives, e.g. host.zip
+	Size     int64       `json:"size"`
+	SHA256   string      `json:"sha256"`
+	PartSize int64       `json:"part_size"`
+	Parts    []SplitPart `json:"parts"`
+	Tool     ToolInfo    `json:"tool"`
+}
+
+// SplitPart is one part of a split output, e.g. host.zip.001.
+type SplitPart struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// SplitFile is an output file written as numbered parts of at most a part size each, NAME.001, NAME.002 and so on, for
+// when the output has to go through something that limits the size of a file, such as a FAT32 USB stick or an email
+// gateway. Like a PartialFile the parts are written under a .partial name and only renamed once the output is
+// complete, and then NAME.parts.json lists them with their hashes. Joining the parts in order gives the zip or tar,
+// e.g. with `copy /b` or JoinSplitFile.
+type SplitFile struct {
+	name     string
+	partSize int64
+
+	current  *os.File
+	written  int64 // into the current part
+	partHash hash.Hash
+	whole    hash.Hash
+	manifest SplitManifest
+}
+
+// CreateSplitFile creates the first part of the output NAME split into parts of partSize bytes.
+func CreateSplitFile(name string, partSize int64) (file *SplitFile, err error) {
+	if partSize <= 0 {
+		err = fmt.Errorf("CreateSplitFile() received part size %d, which isn't above 0", partSize)
+		return
+	}
+	file = &SplitFile{
+		name:     name,
+		partSize: partSize,
+		whole:    sha256.New(),
+		manifest: SplitManifest{Name: filepath.Base(name), PartSize: partSize, Parts: make([]SplitPart, 0), Tool: currentTool()},
+	}
+	err = file.nextPart()
+	if err != nil {
+		file = nil
+	}
+	return
+}
+
+// partName is the name of a part, numbered from 1.
+func (file *SplitFile) partName(number int) string {
+	return fmt.Sprintf("%s.%03d", file.name, number)
+}
+
+// nextPart starts writing the next part.
+func (file *SplitFile) nextPart() (err error) {
+	name := file.partName(len(file.manifest.Parts)+1) + partialSuffix
+	file.current, err = os.Create(name)
+	if err != nil {
+		err = fmt.Errorf("failed to create part %s: %w", name, err)
+		return
+	}
+	file.written = 0
+	file.partHash = sha256.New()
+	return
+}
+
+// finishPart flushes the current part to disk and closes it, adding it to the manifest.
+func (file *SplitFile) finishPart() (err error) {
+	err = file.current.Sync()
+	if closeErr := file.current.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to flush %s: %w", file.current.Name(), err)
+		return
+	}
+	file.current = nil
+	file.manifest.Parts = append(file.manifest.Parts, SplitPart{
+		Name:   filepath.Base(file.partName(len(file.manifest.Parts) + 1)),
+		Size:   file.written,
+		SHA256: hex.EncodeToString(file.partHash.Sum(nil)),
+	})
+	return
+}
+
+// Write writes data into the current part, moving on to the next one whenever a part is full.
+func (file *SplitFile) Write(data []byte) (numberOfBytesWritten int, err error) {
+	for len(data) > 0 {
+		if file.current == nil {
+			return numberOfBytesWritten, errors.New("the split file is closed")
+		}
+		if file.written == file.partSize {
+			if err = file.finishPart(); err != nil {
+				return
+			}
+			if err = file.nextPart(); err != nil {
+				return
+			}
+		}
+		chunk := data
+		if room := file.partSize - file.written; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		var written int
+		written, err = file.current.Write(chunk)
+		_, _ = file.partHash.Write(chunk[:written])
+		_, _ = file.whole.Write(chunk[:written])
+		file.written += int64(written)
+		file.manifest.Size += int64(written)
+		numberOfBytesWritten += written
+		if err != nil {
+			return
+		}
+		data = data[written:]
+	}
+	return
+}
+
+// Close closes the current part, leaving every part under its .partial name.
+func (file *SplitFile) Close() (err error) {
+	if file.current == nil {
+		return
+	}
+	err = file.current.Close()
+	file.current = nil
+	return
+}
+
+// Complete flushes the last part, renames every part to its final name and writes the manifest.
+func (file *SplitFile) Complete() (err error) {
+	if file.current == nil {
+		err = errors.New("the split file is closed")
+		return
+	}
+	err = file.finishPart()
+	if err != nil {
+		return
+	}
+	for number := 1; number <= len(file.manifest.Parts); number++ {
+		err = os.Rename(file.partName(number)+partialSuffix, file.partName(number))
+		if err != nil {
+			return
+		}
+	}
+	file.manifest.SHA256 = hex.EncodeToString(file.whole.Sum(nil))
+	data, err := json.MarshalIndent(file.manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(file.name+SplitManifestExtension, data, 0644)
+	if err != nil {
+		err = fmt.Errorf("failed to write the manifest of %s: %w", file.name, err)
+	}
+	return
+}
+
+// JoinSplitFile writes the parts listed in a split output's manifest, NAME.parts.json, into output in order, checking
+// the size and hash of each part and of the whole. The parts are looked for next to the manifest.
+func JoinSplitFile(manifestPath string, output io.Writer) (manifest SplitManifest, err error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		err = fmt.Errorf("JoinSplitFile() failed to read the manifest: %w", err)
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&manifest)
+	if err != nil {
+		err = fmt.Errorf("JoinSplitFile() failed to parse the manifest %s: %w", manifestPath, err)
+		return
+	}
+	whole := sha256.New()
+	for _, part := range manifest.Parts {
+		if filepath.Base(part.Name) != part.Name {
+			err = fmt.Errorf("JoinSplitFile() found part '%s' outside the manifest's directory", part.Name)
+			return
+		}
+		err = joinPart(filepath.Join(filepath.Dir(manifestPath), part.Name), part, io.MultiWriter(output, whole))
+		if err != nil {
+			return
+		}
+	}
+	if got := hex.EncodeToString(whole.Sum(nil)); got != manifest.SHA256 {
+		err = fmt.Errorf("JoinSplitFile() joined %s with SHA-256 %s, the manifest lists %s", manifest.Name, got, manifest.SHA256)
+	}
+	return
+}
+
+// joinPart copies a part into output, checking it against the manifest.
+func joinPart(path string, part SplitPart, output io.Writer) (err error) {
+	partFile, err := os.Open(path)
+	if err != nil {
+		err = fmt.Errorf("JoinSplitFile() is missing part %s: %w", part.Name, err)
+		return
+	}
+	defer partFile.Close()
+	partHash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(output, partHash), partFile)
+	if err != nil {
+		err = fmt.Errorf("JoinSplitFile() failed to copy part %s: %w", part.Name, err)
+		return
+	}
+	if got := hex.EncodeToString(partHash.Sum(nil)); size != part.Size || got != part.SHA256 {
+		err = fmt.Errorf("JoinSplitFile() found part %s is corrupt, it has %d bytes with SHA-256 %s, the manifest lists %d bytes with %s", part.Name, size, got, part.Size, part.SHA256)
+	}
+	return
+}
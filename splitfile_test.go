@@ -0,0 +1,182 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-split-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	data := []byte("0123456789")
+
+	tests := []struct {
+		name      string
+		partSize  int64
+		writes    int // how many writes data is split into
+		wantSizes []int64
+	}{
+		{name: "uneven parts", partSize: 4, writes: 1, wantSizes: []int64{4, 4, 2}},
+		{name: "even parts", partSize: 5, writes: 2, wantSizes: []int64{5, 5}},
+		{name: "one part", partSize: 100, writes: 3, wantSizes: []int64{10}},
+		{name: "byte at a time", partSize: 3, writes: 10, wantSizes: []int64{3, 3, 3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(directory, tt.name+".zip")
+			file, err := CreateSplitFile(name, tt.partSize)
+			if err != nil {
+				t.Fatalf("CreateSplitFile() error = %v", err)
+			}
+			chunk := (len(data) + tt.writes - 1) / tt.writes
+			for start := 0; start < len(data); start += chunk {
+				end := start + chunk
+				if end > len(data) {
+					end = len(data)
+				}
+				if _, err = file.Write(data[start:end]); err != nil {
+					t.Fatalf("SplitFile.Write() error = %v", err)
+				}
+			}
+			if err = file.Complete(); err != nil {
+				t.Fatalf("SplitFile.Complete() error = %v", err)
+			}
+
+			var joined bytes.Buffer
+			manifest, err := JoinSplitFile(name+SplitManifestExtension, &joined)
+			if err != nil {
+				t.Fatalf("JoinSplitFile() error = %v", err)
+			}
+			if !bytes.Equal(joined.Bytes(), data) {
+				t.Errorf("JoinSplitFile() joined %q, want %q", joined.Bytes(), data)
+			}
+			if len(manifest.Parts) != len(tt.wantSizes) {
+				t.Fatalf("the manifest lists %d parts, want %d", len(manifest.Parts), len(tt.wantSizes))
+			}
+			for index, part := range manifest.Parts {
+				if part.Size != tt.wantSizes[index] {
+					t.Errorf("part %s has %d bytes, want %d", part.Name, part.Size, tt.wantSizes[index])
+				}
+				if _, statErr := os.Stat(filepath.Join(directory, part.Name+partialSuffix)); !os.IsNotExist(statErr) {
+					t.Errorf("%s was left behind", part.Name+partialSuffix)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitFile_notCompleted(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-split-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	name := filepath.Join(directory, "cancelled.zip")
+	file, err := CreateSplitFile(name, 4)
+	if err != nil {
+		t.Fatalf("CreateSplitFile() error = %v", err)
+	}
+	if _, err = file.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("SplitFile.Write() error = %v", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("SplitFile.Close() error = %v", err)
+	}
+	for _, partName := range []string{"cancelled.zip.001.partial", "cancelled.zip.002.partial", "cancelled.zip.003.partial"} {
+		if _, statErr := os.Stat(filepath.Join(directory, partName)); statErr != nil {
+			t.Errorf("%s is missing: %v", partName, statErr)
+		}
+	}
+	for _, missing := range []string{"cancelled.zip.001", "cancelled.zip" + SplitManifestExtension} {
+		if _, statErr := os.Stat(filepath.Join(directory, missing)); !os.IsNotExist(statErr) {
+			t.Errorf("%s was written for an output that wasn't completed", missing)
+		}
+	}
+}
+
+func TestJoinSplitFile_damaged(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(directory string) error
+	}{
+		{name: "missing part", damage: func(directory string) error {
+			return os.Remove(filepath.Join(directory, "output.zip.002"))
+		}},
+		{name: "corrupt part", damage: func(directory string) error {
+			return ioutil.WriteFile(filepath.Join(directory, "output.zip.002"), []byte("4x67"), 0644)
+		}},
+		{name: "truncated part", damage: func(directory string) error {
+			return ioutil.WriteFile(filepath.Join(directory, "output.zip.003"), []byte("8"), 0644)
+		}},
+		{name: "part outside the directory", damage: func(directory string) error {
+			manifest := []byte(`{"name":"output.zip","parts":[{"name":"../output.zip.001"}]}`)
+			return ioutil.WriteFile(filepath.Join(directory, "output.zip"+SplitManifestExtension), manifest, 0644)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "gofor-split-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(directory)
+			file, err := CreateSplitFile(filepath.Join(directory, "output.zip"), 4)
+			if err != nil {
+				t.Fatalf("CreateSplitFile() error = %v", err)
+			}
+			_, _ = file.Write([]byte("0123456789"))
+			if err = file.Complete(); err != nil {
+				t.Fatalf("SplitFile.Complete() error = %v", err)
+			}
+			if err = tt.damage(directory); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = JoinSplitFile(filepath.Join(directory, "output.zip"+SplitManifestExtension), ioutil.Discard); err == nil {
+				t.Error("JoinSplitFile() error = nil, want one")
+			}
+		})
+	}
+}
+
+func TestExtractArchive_split(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-split-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	resultWriter, err := NewResultWriter("zip", filepath.Join(directory, "split.zip"), ResultWriterSettings{SplitSize: 64})
+	if err != nil {
+		t.Fatalf("NewResultWriter() error = %v", err)
+	}
+	zipResultWriter := resultWriter.(*ZipResultWriter)
+	entry, err := zipResultWriter.ZipWriter.Create("c/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = entry.Write(bytes.Repeat([]byte("regf"), 100))
+	if err = zipResultWriter.ZipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = closeOutput(zipResultWriter.FileHandle, true); err != nil {
+		t.Fatalf("closeOutput() error = %v", err)
+	}
+
+	result, err := ExtractArchive(filepath.Join(directory, "split.zip"+SplitManifestExtension), filepath.Join(directory, "extracted"), nil)
+	if err != nil {
+		t.Fatalf("ExtractArchive() error = %v", err)
+	}
+	if result.Format != "zip" || len(result.Files) != 1 {
+		t.Errorf("ExtractArchive() = %+v, want the one file of a zip", result)
+	}
+	if _, statErr := os.Stat(filepath.Join(directory, "split.zip.002")); statErr != nil {
+		t.Errorf("the zip wasn't split: %v", statErr)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Tokens targets can use so they work on any machine. %ALLUSERS% is the ProgramData directory and {hostname} the
+// name of the machine being collected from. A target with {user} or {sid} is expanded once for every user profile,
+// with {user} as the name of the profile's directory and {sid} as its owner's SID, e.g.
+// %SYSTEMDRIVE%:\$Recycle.Bin\{sid}. %SYSTEMDRIVE% can also be written without its colon, like the variable,
+// e.g. %SYSTEMDRIVE%\Windows.
+const (
+	AllUsersToken = "%ALLUSERS%"
+	HostnameToken = "{hostname}"
+	UserToken     = "{user}"
+	SIDToken      = "{sid}"
+)
+
+var (
+	targetTokenPattern      = regexp.MustCompile(`(?i)%allusers%|\{hostname\}|\{user\}|\{sid\}`)
+	userTokenPattern        = regexp.MustCompile(`(?i)\{user\}|\{sid\}`)
+	systemDriveWithoutColon = regexp.MustCompile(`(?i)^%systemdrive%([^:]|$)`)
+)
+
+// tokenValue is what a token stands for. When it isn't known, such as a user's name when the profiles can't be listed,
+// it's a regex matching whatever it could be.
+type tokenValue struct {
+	literal string
+	regex   string
+}
+
+func (value tokenValue) known() bool {
+	return value.regex == ""
+}
+
+func (value tokenValue) pattern() string {
+	if value.known() {
+		return regexp.QuoteMeta(value.literal)
+	}
+	return value.regex
+}
+
+// ExpandTargetTokens replaces the tokens in the targets' paths and file names with what they stand for on this
+// machine, making a target for each of profiles when it has {user} or {sid}. Without any profiles those match any
+// user's directory or SID instead, and a literal target becomes a regex. The values keep their case, which only matters
+// to case-sensitive targets since compileSearchTerms lowercases the rest.
+func ExpandTargetTokens(exportList ListOfFilesToExport, hostname string, profiles []UserProfile) (expanded ListOfFilesToExport) {
+	values := map[string]tokenValue{
+		strings.ToLower(AllUsersToken): allUsersDirectory(),
+		HostnameToken:                  {literal: hostname},
+		UserToken:                      {regex: `[^\\]+`},
+		SIDToken:                       {regex: `S-1-[0-9-]+`},
+	}
+	if hostname == "" {
+		values[HostnameToken] = tokenValue{regex: `[^\\]+`}
+	}
+	for _, target := range exportList {
+		target.FullPath = systemDriveWithoutColon.ReplaceAllString(target.FullPath, `%SYSTEMDRIVE%:$1`)
+		if len(profiles) == 0 || !userTokenPattern.MatchString(target.FullPath+target.FileName) {
+			expanded = append(expanded, expandTargetTokens(target, values))
+			continue
+		}
+		for _, profile := range profiles {
+			perUser := make(map[string]tokenValue, len(values))
+			for token, value := range values {
+				perUser[token] = value
+			}
+			perUser[UserToken] = tokenValue{literal: profile.Directory[strings.LastIndex(profile.Directory, `\`)+1:]}
+			perUser[SIDToken] = tokenValue{literal: profile.SID}
+			expanded = append(expanded, expandTargetTokens(target, perUser))
+		}
+	}
+	return
+}
+
+// allUsersDirectory is what %ALLUSERS% stands for, going by the ALLUSERSPROFILE variable.
+func allUsersDirectory() tokenValue {
+	directory := os.Getenv("ALLUSERSPROFILE")
+	if len(directory) < 2 || directory[1] != ':' {
+		directory = `%SYSTEMDRIVE%:\ProgramData`
+	}
+	return tokenValue{literal: directory}
+}
+
+func expandTargetTokens(target FileToExport, values map[string]tokenValue) FileToExport {
+	target.FullPath, target.IsFullPathRegex = expandTokens(target.FullPath, target.IsFullPathRegex, values, false)
+	target.FileName, target.IsFileNameRegex = expandTokens(target.FileName, target.IsFileNameRegex, values, true)
+	return target
+}
+
+// expandTokens replaces the tokens in a path or file name. A literal one becomes a regex if any of its tokens are only
+// known as a regex, anchored at its end, and at its start too when it's a file name.
+func expandTokens(value string, isRegex bool, values map[string]tokenValue, isFileName bool) (expanded string, expandedIsRegex bool) {
+	matches := targetTokenPattern.FindAllStringIndex(value, -1)
+	if len(matches) == 0 {
+		return value, isRegex
+	}
+	expandedIsRegex = isRegex
+	for _, match := range matches {
+		if !values[strings.ToLower(value[match[0]:match[1]])].known() {
+			expandedIsRegex = true
+		}
+	}
+	quote := func(text string) string {
+		if !isRegex && expandedIsRegex {
+			return regexp.QuoteMeta(text)
+		}
+		return text
+	}
+
+	var builder strings.Builder
+	if !isRegex && expandedIsRegex && isFileName {
+		builder.WriteString(`^`)
+	}
+	last := 0
+	for _, match := range matches {
+		builder.WriteString(quote(value[last:match[0]]))
+		token := values[strings.ToLower(value[match[0]:match[1]])]
+		if expandedIsRegex {
+			builder.WriteString(token.pattern())
+		} else {
+			builder.WriteString(token.literal)
+		}
+		last = match[1]
+	}
+	builder.WriteString(quote(value[last:]))
+	if !isRegex && expandedIsRegex {
+		builder.WriteString(`$`)
+	}
+	expanded = builder.String()
+	return
+}
+
+// usesTargetTokens reports whether any of the targets has a token to expand or %SYSTEMDRIVE% without its colon.
+func usesTargetTokens(exportList ListOfFilesToExport) bool {
+	for _, target := range exportList {
+		if targetTokenPattern.MatchString(target.FullPath+target.FileName) || systemDriveWithoutColon.MatchString(target.FullPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExpandTargetTokens(t *testing.T) {
+	defer os.Setenv("ALLUSERSPROFILE", os.Getenv("ALLUSERSPROFILE"))
+	os.Setenv("ALLUSERSPROFILE", `C:\ProgramData`)
+	profiles := []UserProfile{{Directory: `C:\Users\Alice`, SID: "S-1-5-21-1-2-3-1001"}, {Directory: `D:\Profiles\Bob`, SID: "S-1-5-21-1-2-3-1002"}}
+	tests := []struct {
+		name     string
+		target   FileToExport
+		hostname string
+		profiles []UserProfile
+		want     ListOfFilesToExport
+	}{
+		{
+			name:     "no tokens",
+			target:   FileToExport{FullPath: `%SYSTEMDRIVE%:\$MFT`, FileName: "$MFT"},
+			hostname: "ws042",
+			profiles: profiles,
+			want:     ListOfFilesToExport{{FullPath: `%SYSTEMDRIVE%:\$MFT`, FileName: "$MFT"}},
+		},
+		{
+			name:     "system drive without its colon",
+			target:   FileToExport{FullPath: `%SystemDrive%\Windows\System32\config\SAM`, FileName: "SAM"},
+			hostname: "ws042",
+			want:     ListOfFilesToExport{{FullPath: `%SYSTEMDRIVE%:\Windows\System32\config\SAM`, FileName: "SAM"}},
+		},
+		{
+			name:     "all users and hostname",
+			target:   FileToExport{FullPath: `%ALLUSERS%\Vendor\{hostname}.log`, FileName: "{hostname}.log"},
+			hostname: "WS042",
+			want:     ListOfFilesToExport{{FullPath: `C:\ProgramData\Vendor\WS042.log`, FileName: "WS042.log"}},
+		},
+		{
+			name:     "regex",
+			target:   FileToExport{FullPath: `%ALLUSERS%\\Vendor\\{hostname}\\.*\.log$`, IsFullPathRegex: true, FileName: `\.log$`, IsFileNameRegex: true},
+			hostname: "ws042.corp",
+			want:     ListOfFilesToExport{{FullPath: `C:\\ProgramData\\Vendor\\ws042\.corp\\.*\.log$`, IsFullPathRegex: true, FileName: `\.log$`, IsFileNameRegex: true}},
+		},
+		{
+			name:     "per user",
+			target:   FileToExport{FullPath: `%SYSTEMDRIVE%:\$Recycle.Bin\{sid}\{user}.txt`, FileName: "{user}.txt"},
+			hostname: "ws042",
+			profiles: profiles,
+			want: ListOfFilesToExport{
+				{FullPath: `%SYSTEMDRIVE%:\$Recycle.Bin\S-1-5-21-1-2-3-1001\Alice.txt`, FileName: "Alice.txt"},
+				{FullPath: `%SYSTEMDRIVE%:\$Recycle.Bin\S-1-5-21-1-2-3-1002\Bob.txt`, FileName: "Bob.txt"},
+			},
+		},
+		{
+			name:     "case sensitive",
+			target:   FileToExport{FullPath: `C:\Users\{user}\src\{hostname}.mk`, FileName: "{hostname}.mk", CaseSensitive: true},
+			hostname: "WS042",
+			profiles: profiles[:1],
+			want:     ListOfFilesToExport{{FullPath: `C:\Users\Alice\src\WS042.mk`, FileName: "WS042.mk", CaseSensitive: true}},
+		},
+		{
+			name:     "per user without profiles",
+			target:   FileToExport{FullPath: `%SYSTEMDRIVE%:\$Recycle.Bin\{sid}\{user}.txt`, FileName: "{user}.txt"},
+			hostname: "ws042",
+			want: ListOfFilesToExport{
+				{FullPath: `%SYSTEMDRIVE%:\\\$Recycle\.Bin\\S-1-[0-9-]+\\[^\\]+\.txt$`, IsFullPathRegex: true, FileName: `^[^\\]+\.txt$`, IsFileNameRegex: true},
+			},
+		},
+		{
+			name:   "unknown hostname",
+			target: FileToExport{FullPath: `C:\Logs\{hostname}.log`, FileName: "{hostname}.log"},
+			want: ListOfFilesToExport{
+				{FullPath: `C:\\Logs\\[^\\]+\.log$`, IsFullPathRegex: true, FileName: `^[^\\]+\.log$`, IsFileNameRegex: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExpandTargetTokens(ListOfFilesToExport{tt.target}, tt.hostname, tt.profiles)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandTargetTokens() = %+v, want %+v", got, tt.want)
+			}
+			for _, target := range got {
+				if _, err := compileSearchTerms(target); err != nil {
+					t.Errorf("compileSearchTerms() error = %v for %+v", err, target)
+				}
+			}
+		})
+	}
+}
+
+func Test_usesTargetTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		target FileToExport
+		want   bool
+	}{
+		{name: "none", target: FileToExport{FullPath: `%SYSTEMDRIVE%:\$MFT`, FileName: "$MFT"}, want: false},
+		{name: "system drive without its colon", target: FileToExport{FullPath: `%SYSTEMDRIVE%\$MFT`, FileName: "$MFT"}, want: true},
+		{name: "in the file name", target: FileToExport{FullPath: `C:\Logs\{HOSTNAME}.log`, FileName: "{HOSTNAME}.log"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usesTargetTokens(ListOfFilesToExport{tt.target}); got != tt.want {
+				t.Errorf("usesTargetTokens() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+const (
+	tarIndexFileName     = "gofor-index.json"
+	tarSignatureFileName = "gofor-index.json.sig"
+	tarHashRecord        = "GOFOR.sha256"
+)
+
+// TarResultWriter writes the collection as a tar stream, for destinations such as uploads and network streams where
+// the writer can't seek back to finish a zip's central directory. Every entry carries its SHA-256 in a PAX record, so
+// the entries in a truncated upload can still be checked and used. The stream ends with an index of every entry and,
+// if SigningKey is set, an ed25519 signature of the index, so a truncated or tampered stream can be told apart from a
+// complete one with VerifyTarArchive.
+//
+// Output is closed once the collection is done if it's an io.Closer. Codecs don't apply, compress the stream as a whole
+// instead if needed.
+type TarResultWriter struct {
+	Output     io.Writer
+	SigningKey ed25519.PrivateKey
+	Logger     Logger // see ZipResultWriter
+
+	output *countingWriter
+	index  TarIndex
+}
+
+// TarIndex is the trailing index of a TarResultWriter stream. Complete is false if the collection was cancelled.
+type TarIndex struct {
+	Entries  []TarIndexEntry `json:"entries"`
+	Complete bool            `json:"complete"`
+	Tool     ToolInfo        `json:"tool"` // the collector that wrote the stream
+}
+
+// TarIndexEntry is one file in a TarResultWriter stream. Offset is where its tar header starts in the stream. Files
+// that couldn't be read have an Error and no entry in the stream.
+type TarIndexEntry struct {
+	Name   string   `json:"name"`
+	Links  []string `json:"links,omitempty"` // the file's other paths when it has hard links
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256,omitempty"`
+	Offset int64    `json:"offset"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// ResultWriter will write found files into the tar stream, followed by the index. If ctx is cancelled the index is
+// still written, marked incomplete.
+func (tarResultWriter *TarResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+	defer waitForFileCopying.Done()
+	logger := loggerOrDefault(tarResultWriter.Logger)
+	tarResultWriter.output = &countingWriter{writer: tarResultWriter.Output}
+	tarResultWriter.index = TarIndex{Entries: make([]TarIndexEntry, 0), Tool: currentTool()}
+	tarWriter := tar.NewWriter(tarResultWriter.output)
+	complete := false
+	defer func() {
+		if closer, ok := tarResultWriter.Output.(io.Closer); ok {
+			if closeErr := closeOutput(closer, complete); closeErr != nil && err == nil {
+				err = fmt.Errorf("resultWriter failed to close the output tar: %w", closeErr)
+			}
+		}
+	}()
+
+	for {
+		var fileReader fileReader
+		var openChannel bool
+		select {
+		case fileReader, openChannel = <-fileReaders:
+		case <-ctx.Done():
+			logger.Debugf("Collection was cancelled, closing the tar stream: %v", ctx.Err())
+			_ = tarResultWriter.finish(tarWriter, false)
+			err = ctx.Err()
+			return
+		}
+		if !openChannel {
+			break
+		}
+		err = tarResultWriter.writeEntry(logger, tarWriter, fileReader)
+		if err != nil {
+			fileReader.Failed(err)
+			err = fmt.Errorf("resultWriter failed to add a file to the output tar: %w", err)
+			return
+		}
+		fileReader.Written()
+	}
+	// A collection that failed is cancelled before the files run out
+	if ctx.Err() != nil {
+		_ = tarResultWriter.finish(tarWriter, false)
+		err = ctx.Err()
+		return
+	}
+	err = tarResultWriter.finish(tarWriter, true)
+	if err != nil {
+		err = fmt.Errorf("resultWriter failed to finish the output tar: %w", err)
+		return
+	}
+	complete = true
+	return
+}
+
+// writeEntry spools a file to learn its size and hash, since both go in the tar header ahead of the content.
+func (tarResultWriter *TarResultWriter) writeEntry(logger Logger, tarWriter *tar.Writer, fileReader fileReader) (err error) {
+	entry := TarIndexEntry{
+		Name:   normalizeFilePath(fileReader.fullPath),
+		Links:  fileReader.links,
+		Offset: tarResultWriter.output.count,
+	}
+	hash := sha256.New()
+	size := &countingWriter{writer: ioutil.Discard}
+	spooled, readErr := spoolFile(io.TeeReader(fileReader.reader, io.MultiWriter(hash, size)))
+	if readErr != nil {
+		logger.Debugf("Failed to collect '%s' due to %v", fileReader.fullPath, readErr)
+		entry.Error = readErr.Error()
+		tarResultWriter.index.Entries = append(tarResultWriter.index.Entries, entry)
+		return
+	}
+	defer spooled.discard()
+	entry.Size = size.count
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name:       entry.Name,
+		Size:       entry.Size,
+		Mode:       0644,
+		ModTime:    time.Now().UTC(),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{tarHashRecord: entry.SHA256},
+	})
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(tarWriter, spooled)
+	if err != nil {
+		return
+	}
+	// Flush the padding so the next entry's offset is right
+	err = tarWriter.Flush()
+	if err != nil {
+		return
+	}
+	tarResultWriter.index.Entries = append(tarResultWriter.index.Entries, entry)
+	logger.Debugf("Successfully collected '%s'", fileReader.fullPath)
+	return
+}
+
+// finish writes the index, and its signature when there's a signing key, then closes the tar stream.
+func (tarResultWriter *TarResultWriter) finish(tarWriter *tar.Writer, complete bool) (err error) {
+	tarResultWriter.index.Complete = complete
+	indexData, err := json.MarshalIndent(tarResultWriter.index, "", "  ")
+	if err != nil {
+		return
+	}
+	err = writeTarFile(tarWriter, tarIndexFileName, indexData)
+	if err != nil {
+		return
+	}
+	if tarResultWriter.SigningKey != nil {
+		err = writeTarFile(tarWriter, tarSignatureFileName, ed25519.Sign(tarResultWriter.SigningKey, indexData))
+		if err != nil {
+			return
+		}
+	}
+	err = tarWriter.Close()
+	return
+}
+
+func writeTarFile(tarWriter *tar.Writer, name string, data []byte) (err error) {
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name:    name,
+		Size:    int64(len(data)),
+		Mode:    0644,
+		ModTime: time.Now().UTC(),
+		Format:  tar.FormatPAX,
+	})
+	if err != nil {
+		return
+	}
+	_, err = tarWriter.Write(data)
+	return
+}
+
+// TarVerification is what VerifyTarArchive found in a TarResultWriter stream.
+type TarVerification struct {
+	Entries    []string // entries whose content matches their hash
+	Corrupt    []string // entries whose content doesn't match their hash or the index
+	IndexFound bool     // false when the stream was cut off before the index
+	Signed     bool     // the index signature was checked against the public key
+	Complete   bool     // the index says the collection wasn't cancelled
+	Truncated  bool     // the stream ended early, so only Entries can be trusted
+}
+
+// VerifyTarArchive reads a stream written by TarResultWriter and checks every entry against its hash and the trailing
+// index. If publicKey is set the index has to carry a valid signature from the matching private key. A stream that was
+// cut off is reported as Truncated rather than as an error, with whatever entries made it through intact.
+func VerifyTarArchive(reader io.Reader, publicKey ed25519.PublicKey) (verification TarVerification, err error) {
+	tarReader := tar.NewReader(reader)
+	hashes := make(map[string]string)
+	corrupt := make(map[string]bool)
+	var indexData, signature []byte
+	for {
+		header, nextErr := tarReader.Next()
+		if nextErr == io.EOF {
+			break
+		} else if nextErr != nil {
+			verification.Truncated = true
+			break
+		}
+
+		if header.Name == tarIndexFileName || header.Name == tarSignatureFileName {
+			data, readErr := ioutil.ReadAll(tarReader)
+			if readErr != nil {
+				verification.Truncated = true
+				break
+			}
+			if header.Name == tarIndexFileName {
+				indexData = data
+			} else {
+				signature = data
+			}
+			continue
+		}
+
+		hash := sha256.New()
+		_, copyErr := io.Copy(hash, tarReader)
+		if copyErr != nil {
+			verification.Truncated = true
+			break
+		}
+		hashes[header.Name] = hex.EncodeToString(hash.Sum(nil))
+		if hashes[header.Name] == header.PAXRecords[tarHashRecord] {
+			verification.Entries = append(verification.Entries, header.Name)
+		} else {
+			corrupt[header.Name] = true
+			verification.Corrupt = append(verification.Corrupt, header.Name)
+		}
+	}
+
+	if indexData == nil {
+		verification.Truncated = true
+		return
+	}
+	verification.IndexFound = true
+	if publicKey != nil {
+		if !ed25519.Verify(publicKey, indexData, signature) {
+			err = errors.New("VerifyTarArchive() found an index that doesn't match its signature")
+			return
+		}
+		verification.Signed = true
+	}
+
+	index := TarIndex{}
+	err = json.Unmarshal(indexData, &index)
+	if err != nil {
+		err = fmt.Errorf("VerifyTarArchive() failed to parse the index: %w", err)
+		return
+	}
+	verification.Complete = index.Complete
+	for _, entry := range index.Entries {
+		if entry.Error != "" {
+			continue
+		}
+		hash, found := hashes[entry.Name]
+		if (!found || hash != entry.SHA256) && !corrupt[entry.Name] {
+			corrupt[entry.Name] = true
+			verification.Corrupt = append(verification.Corrupt, entry.Name)
+		}
+	}
+	return
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (countingWriter *countingWriter) Write(data []byte) (numberOfBytesWritten int, err error) {
+	numberOfBytesWritten, err = countingWriter.writer.Write(data)
+	countingWriter.count += int64(numberOfBytesWritten)
+	return
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+func writeTestTar(t *testing.T, signingKey ed25519.PrivateKey) []byte {
+	output := new(bytes.Buffer)
+	resultWriter := TarResultWriter{Output: output, SigningKey: signingKey}
+	fileReaders := make(chan fileReader, 3)
+	fileReaders <- fileReader{fullPath: `c:\windows\system32\config\system`, reader: strings.NewReader("regf system hive")}
+	fileReaders <- fileReader{fullPath: `c:\unreadable`, reader: iotest.TimeoutReader(strings.NewReader("x"))}
+	fileReaders <- fileReader{fullPath: `c:\$mft`, reader: bytes.NewReader(make([]byte, 4096))}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	if err := resultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+		t.Fatalf("TarResultWriter.ResultWriter() error = %v", err)
+	}
+	return output.Bytes()
+}
+
+func TestVerifyTarArchive(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	otherPublicKey, _, _ := ed25519.GenerateKey(nil)
+	archive := writeTestTar(t, privateKey)
+	tampered := bytes.Replace(archive, []byte("regf system hive"), []byte("regf system hivE"), 1)
+
+	tests := []struct {
+		name      string
+		archive   []byte
+		publicKey ed25519.PublicKey
+		want      TarVerification
+		wantErr   bool
+	}{
+		{
+			name:      "complete and signed",
+			archive:   archive,
+			publicKey: publicKey,
+			want: TarVerification{
+				Entries:    []string{"c__windows_system32_config_system", "c__$mft"},
+				IndexFound: true,
+				Signed:     true,
+				Complete:   true,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "truncated",
+			archive:   archive[:3000],
+			publicKey: publicKey,
+			want: TarVerification{
+				Entries:   []string{"c__windows_system32_config_system"},
+				Truncated: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "tampered",
+			archive:   tampered,
+			publicKey: nil,
+			want: TarVerification{
+				Entries:    []string{"c__$mft"},
+				Corrupt:    []string{"c__windows_system32_config_system"},
+				IndexFound: true,
+				Complete:   true,
+			},
+			wantErr: false,
+		},
+		{
+			name:      "wrong key",
+			archive:   archive,
+			publicKey: otherPublicKey,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyTarArchive(bytes.NewReader(tt.archive), tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyTarArchive() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VerifyTarArchive() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that allows up to bytesPerSecond through, with bursts of up to one second's worth. It is
+// safe to share between goroutines so several readers can be held to one combined rate.
+type rateLimiter struct {
+	mutex          sync.Mutex
+	bytesPerSecond float64
+	allowance      float64
+	lastRefill     time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		bytesPerSecond: float64(bytesPerSecond),
+		allowance:      float64(bytesPerSecond),
+		lastRefill:     time.Now(),
+	}
+}
+
+// wait blocks until numberOfBytes can go through without exceeding the rate. A nil rateLimiter never blocks.
+func (limiter *rateLimiter) wait(numberOfBytes int) {
+	if limiter == nil || numberOfBytes <= 0 {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	limiter.allowance += now.Sub(limiter.lastRefill).Seconds() * limiter.bytesPerSecond
+	if limiter.allowance > limiter.bytesPerSecond {
+		limiter.allowance = limiter.bytesPerSecond
+	}
+	limiter.lastRefill = now
+
+	limiter.allowance -= float64(numberOfBytes)
+	if limiter.allowance < 0 {
+		time.Sleep(time.Duration(-limiter.allowance / limiter.bytesPerSecond * float64(time.Second)))
+	}
+}
+
+// throttledReader holds reads from the underlying reader to the rate of its limiter.
+type throttledReader struct {
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func newThrottledReader(reader io.Reader, limiter *rateLimiter) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+	return &throttledReader{
+		reader:  reader,
+		limiter: limiter,
+	}
+}
+
+func (throttledReader *throttledReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = throttledReader.reader.Read(byteSliceToPopulate)
+	throttledReader.limiter.wait(numberOfBytesRead)
+	return
+}
+
+// throttledWriter holds writes to the underlying writer to the rate of its limiter.
+type throttledWriter struct {
+	writer  io.Writer
+	limiter *rateLimiter
+}
+
+// NewThrottledWriter wraps a writer so no more than bytesPerSecond are written to it. Wrap the output file or network
+// connection a zip.Writer writes to with this to limit the result writer. A rate of zero or less returns writer as is.
+func NewThrottledWriter(writer io.Writer, bytesPerSecond int64) io.Writer {
+	limiter := newRateLimiter(bytesPerSecond)
+	if limiter == nil {
+		return writer
+	}
+	return &throttledWriter{
+		writer:  writer,
+		limiter: limiter,
+	}
+}
+
+func (throttledWriter *throttledWriter) Write(data []byte) (numberOfBytesWritten int, err error) {
+	throttledWriter.limiter.wait(len(data))
+	numberOfBytesWritten, err = throttledWriter.writer.Write(data)
+	return
+}
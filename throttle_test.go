@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func Test_throttledReader_Read(t *testing.T) {
+	tests := []struct {
+		name           string
+		bytesPerSecond int64
+		dataSize       int
+		wantAtLeast    time.Duration
+		wantAtMost     time.Duration
+	}{
+		{
+			name:           "unlimited",
+			bytesPerSecond: 0,
+			dataSize:       1024 * 1024,
+			wantAtLeast:    0,
+			wantAtMost:     100 * time.Millisecond,
+		},
+		{
+			name:           "burst then throttled",
+			bytesPerSecond: 100 * 1024,
+			dataSize:       150 * 1024,
+			wantAtLeast:    400 * time.Millisecond,
+			wantAtMost:     2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newThrottledReader(bytes.NewReader(make([]byte, tt.dataSize)), newRateLimiter(tt.bytesPerSecond))
+			start := time.Now()
+			_, err := io.Copy(ioutil.Discard, reader)
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Errorf("throttledReader.Read() error = %v", err)
+				return
+			}
+			if elapsed < tt.wantAtLeast || elapsed > tt.wantAtMost {
+				t.Errorf("throttledReader.Read() took %v, want between %v and %v", elapsed, tt.wantAtLeast, tt.wantAtMost)
+			}
+		})
+	}
+}
+
+func TestNewThrottledWriter(t *testing.T) {
+	output := new(bytes.Buffer)
+	writer := NewThrottledWriter(output, 0)
+	if writer != output {
+		t.Errorf("NewThrottledWriter() with no limit should return the original writer")
+	}
+
+	writer = NewThrottledWriter(output, 64*1024)
+	start := time.Now()
+	_, err := writer.Write(make([]byte, 96*1024))
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Errorf("throttledWriter.Write() error = %v", err)
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("throttledWriter.Write() took %v, it should have been throttled", elapsed)
+	}
+	if output.Len() != 96*1024 {
+		t.Errorf("throttledWriter.Write() wrote %d bytes, want %d", output.Len(), 96*1024)
+	}
+}
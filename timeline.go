@@ -0,0 +1,295 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimelineFormat is the format of the filesystem timeline written from each volume's MFT.
+type TimelineFormat string
+
+// The timeline formats.
+const (
+	TimelineBodyfile TimelineFormat = "bodyfile" // what mactime and other timeline tools read, a line for each file's $STANDARD_INFORMATION and $FILE_NAME timestamps
+	TimelineCSV      TimelineFormat = "csv"      // the MFT parser's CSV, a row for each file with both sets of timestamps
+)
+
+const timelineDirectory = "timeline"
+
+// The flags of a timelineRecord.
+const (
+	timelineDirectoryFlag = 1 << iota
+	timelineDeletedFlag
+	timelineSystemFlag
+	timelineHiddenFlag
+	timelineReadOnlyFlag
+)
+
+// validate checks a timeline format is one of the timeline formats. Empty leaves the timeline out.
+func (format TimelineFormat) validate() (err error) {
+	switch format {
+	case "", TimelineBodyfile, TimelineCSV:
+		return
+	}
+	err = fmt.Errorf("unknown timeline format '%s'", format)
+	return
+}
+
+// timelineRecord is what the timeline keeps of a file record until the directory tree is resolved and its path known.
+// There's one for every record in the MFT, so the timestamps are kept as unix nanoseconds rather than time.Time.
+type timelineRecord struct {
+	recordNumber uint32
+	parent       uint32
+	name         string
+	flags        uint8
+	size         uint64
+	siTimes      [4]int64 // created, modified, accessed and changed
+	fnTimes      [4]int64
+}
+
+// mftTimeline gathers a timeline of every file record during the MFT walk. Like the mftInspector its methods do
+// nothing when it's nil, so the walk doesn't need to check whether a timeline was asked for.
+type mftTimeline struct {
+	volumeLetter string
+	format       TimelineFormat
+	records      []timelineRecord
+}
+
+func newMftTimeline(volumeLetter string, format TimelineFormat) *mftTimeline {
+	if format == "" {
+		return nil
+	}
+	return &mftTimeline{volumeLetter: volumeLetter, format: format}
+}
+
+// outputPath is where the volume's timeline goes in the output.
+func (timeline *mftTimeline) outputPath() string {
+	if timeline.format == TimelineCSV {
+		return fmt.Sprintf(`%s\%s_mft.csv`, timelineDirectory, timeline.volumeLetter)
+	}
+	return fmt.Sprintf(`%s\%s.body`, timelineDirectory, timeline.volumeLetter)
+}
+
+// addDirectory adds a directory record, which the walk doesn't otherwise parse.
+func (timeline *mftTimeline) addDirectory(buffer mft.RawMasterFileTableRecord, bytesPerCluster int64) {
+	if timeline == nil {
+		return
+	}
+	if record, found := directoryTimelineRecord(buffer, bytesPerCluster); found {
+		timeline.records = append(timeline.records, record)
+	}
+}
+
+// directoryTimelineRecord parses the timelineRecord of a directory record.
+func directoryTimelineRecord(buffer mft.RawMasterFileTableRecord, bytesPerCluster int64) (record timelineRecord, found bool) {
+	rawRecordHeader, err := buffer.GetRawRecordHeader()
+	if err != nil {
+		return
+	}
+	recordHeader, err := rawRecordHeader.Parse()
+	if err != nil {
+		return
+	}
+	rawAttributes, _ := buffer.GetRawAttributes(recordHeader)
+	fileNameAttributes, standardInformation, _, _, _ := rawAttributes.Parse(bytesPerCluster)
+	fileNameAttributes = withDecodedFileNames(buffer, recordHeader, fileNameAttributes)
+	record, found = newTimelineRecord(buffer, recordHeader, fileNameAttributes, standardInformation)
+	return
+}
+
+// addRecord adds a file record. Extension records, which have no name of their own, are left out.
+func (timeline *mftTimeline) addRecord(buffer mft.RawMasterFileTableRecord, recordHeader mft.RecordHeader, fileNameAttributes mft.FileNameAttributes, standardInformation mft.StandardInformationAttribute) {
+	if timeline == nil {
+		return
+	}
+	if record, found := newTimelineRecord(buffer, recordHeader, fileNameAttributes, standardInformation); found {
+		timeline.records = append(timeline.records, record)
+	}
+}
+
+// newTimelineRecord is the timelineRecord of a file record, not found for an extension record.
+func newTimelineRecord(buffer mft.RawMasterFileTableRecord, recordHeader mft.RecordHeader, fileNameAttributes mft.FileNameAttributes, standardInformation mft.StandardInformationAttribute) (record timelineRecord, found bool) {
+	fileName, found := longFileName(fileNameAttributes)
+	if !found {
+		return
+	}
+	record = timelineRecord{
+		recordNumber: recordHeader.RecordNumber,
+		parent:       fileName.ParentDirRecordNumber,
+		name:         fileName.FileName,
+		siTimes:      timelineTimes(standardInformation.SiCreated, standardInformation.SiModified, standardInformation.SiAccessed, standardInformation.SiChanged),
+		fnTimes:      timelineTimes(fileName.FnCreated, fileName.FnModified, fileName.FnAccessed, fileName.FnChanged),
+	}
+	flags := []bool{recordHeader.Flags.FlagDirectory, recordHeader.Flags.FlagDeleted, fileName.FileNameFlags.System, fileName.FileNameFlags.Hidden, fileName.FileNameFlags.ReadOnly}
+	for index, set := range flags {
+		if set {
+			record.flags |= 1 << index
+		}
+	}
+	if !recordHeader.Flags.FlagDirectory {
+		record.size = recordSize(buffer, recordHeader, fileName)
+	}
+	return
+}
+
+// recordSize is the size of a file's unnamed data stream, falling back on the size in its $FILE_NAME attribute, which
+// NTFS doesn't always keep up to date.
+func recordSize(buffer mft.RawMasterFileTableRecord, recordHeader mft.RecordHeader, fileName mft.FileNameAttribute) uint64 {
+	if stream, hasSizes, found := parseNonResidentData(buffer, recordHeader.AttributesOffset); found && hasSizes {
+		return uint64(stream.size)
+	}
+	if data, resident := residentData(buffer, recordHeader.AttributesOffset); resident {
+		return uint64(len(data))
+	}
+	return fileName.LogicalFileSize
+}
+
+func timelineTimes(times ...time.Time) (unixNanos [4]int64) {
+	for index, timestamp := range times {
+		if !timestamp.IsZero() {
+			unixNanos[index] = timestamp.UnixNano()
+		}
+	}
+	return
+}
+
+// pathOf is a record's full path, under $ORPHANFILE when its parent directory isn't in the directory tree.
+func (timeline *mftTimeline) pathOf(record timelineRecord, directoryTree mft.DirectoryTree) string {
+	if record.flags&timelineDirectoryFlag != 0 {
+		if path, ok := directoryTree[record.recordNumber]; ok {
+			return lowerVolume(path)
+		}
+	}
+	directory, ok := directoryTree[record.parent]
+	if !ok {
+		directory = fmt.Sprintf(`%s:\$ORPHANFILE`, timeline.volumeLetter)
+	}
+	return lowerVolume(strings.TrimSuffix(directory, `\`) + `\` + record.name)
+}
+
+// write writes the timeline once the directory tree has been resolved.
+func (timeline *mftTimeline) write(writer io.Writer, directoryTree mft.DirectoryTree) (err error) {
+	buffered := bufio.NewWriter(writer)
+	if timeline.format == TimelineCSV {
+		timeline.writeCSV(buffered, directoryTree)
+	} else {
+		timeline.writeBodyfile(buffered, directoryTree)
+	}
+	err = buffered.Flush()
+	return
+}
+
+// writeBodyfile writes the records in the bodyfile format of the Sleuth Kit, as fls does: a line for the
+// $STANDARD_INFORMATION timestamps and one for the $FILE_NAME timestamps, with deleted files marked as such.
+func (timeline *mftTimeline) writeBodyfile(writer io.Writer, directoryTree mft.DirectoryTree) {
+	for _, record := range timeline.records {
+		path := timeline.pathOf(record, directoryTree)
+		if record.flags&timelineDeletedFlag != 0 {
+			path += " (deleted)"
+		}
+		mode := "r/rrwxrwxrwx"
+		if record.flags&timelineDirectoryFlag != 0 {
+			mode = "d/drwxrwxrwx"
+		}
+		// MD5|name|inode|mode_as_string|UID|GID|size|atime|mtime|ctime|crtime
+		for _, line := range []struct {
+			suffix string
+			times  [4]int64
+		}{
+			{times: record.siTimes},
+			{suffix: " ($FILE_NAME)", times: record.fnTimes},
+		} {
+			_, _ = fmt.Fprintf(writer, "0|%s%s|%d|%s|0|0|%d|%d|%d|%d|%d\n", path, line.suffix, record.recordNumber, mode, record.size,
+				line.times[2]/int64(time.Second), line.times[1]/int64(time.Second), line.times[3]/int64(time.Second), line.times[0]/int64(time.Second))
+		}
+	}
+}
+
+// writeCSV writes the records with the MFT parser's CSV writer.
+func (timeline *mftTimeline) writeCSV(writer io.Writer, directoryTree mft.DirectoryTree) {
+	rows := make(chan mft.UsefulMftFields, 100)
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(1)
+	go (&mft.CsvResultWriter{}).ResultWriter(writer, &rows, &waitGroup)
+	for _, record := range timeline.records {
+		path := timeline.pathOf(record, directoryTree)
+		row := mft.UsefulMftFields{
+			RecordNumber:     record.recordNumber,
+			FilePath:         path[:strings.LastIndex(path, `\`)+1],
+			FullPath:         path,
+			FileName:         record.name,
+			DirectoryFlag:    record.flags&timelineDirectoryFlag != 0,
+			DeletedFlag:      record.flags&timelineDeletedFlag != 0,
+			SystemFlag:       record.flags&timelineSystemFlag != 0,
+			HiddenFlag:       record.flags&timelineHiddenFlag != 0,
+			ReadOnlyFlag:     record.flags&timelineReadOnlyFlag != 0,
+			SiCreated:        timelineTime(record.siTimes[0]),
+			SiModified:       timelineTime(record.siTimes[1]),
+			SiAccessed:       timelineTime(record.siTimes[2]),
+			SiChanged:        timelineTime(record.siTimes[3]),
+			FnCreated:        timelineTime(record.fnTimes[0]),
+			FnModified:       timelineTime(record.fnTimes[1]),
+			FnAccessed:       timelineTime(record.fnTimes[2]),
+			FnChanged:        timelineTime(record.fnTimes[3]),
+			PhysicalFileSize: record.size,
+		}
+		rows <- row
+	}
+	close(rows)
+	waitGroup.Wait()
+}
+
+func timelineTime(unixNanos int64) time.Time {
+	if unixNanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, unixNanos).UTC()
+}
+
+// sendTimeline hands the volume's timeline to the result writer.
+func (collector *Collector) sendTimeline(ctx context.Context, fileReaders chan fileReader, timeline *mftTimeline, directoryTree mft.DirectoryTree) (err error) {
+	if timeline == nil {
+		return
+	}
+	err = collector.sendGenerated(ctx, fileReaders, timeline.outputPath(), timeline.volumeLetter, func(writer io.Writer) error {
+		return timeline.write(writer, directoryTree)
+	})
+	return
+}
+
+// sendGenerated hands a file the collector writes itself to the result writer, writing it through a pipe as it's read
+// so it isn't held in memory a second time.
+func (collector *Collector) sendGenerated(ctx context.Context, fileReaders chan fileReader, outputPath, volumeLetter string, write func(writer io.Writer) error) (err error) {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		// If the collection is cancelled the result writer stops reading, so close the pipe to unblock the writing
+		written := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = pipeReader.CloseWithError(ctx.Err())
+			case <-written:
+			}
+		}()
+		_ = pipeWriter.CloseWithError(write(pipeWriter))
+		close(written)
+	}()
+	fileReader := fileReader{
+		fullPath: outputPath,
+		reader:   pipeReader,
+		method:   readMethodRaw,
+	}
+	err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(fileReader, volumeLetter))
+	if err != nil {
+		_ = pipeReader.CloseWithError(err)
+	}
+	return
+}
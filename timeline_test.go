@@ -0,0 +1,132 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	mft "github.com/Go-Forensics/MFT-Parser"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimelineFormat_validate(t *testing.T) {
+	tests := []struct {
+		format  TimelineFormat
+		wantErr bool
+	}{
+		{format: ""},
+		{format: TimelineBodyfile},
+		{format: TimelineCSV},
+		{format: "json", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			if err := tt.format.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMftTimeline_write(t *testing.T) {
+	created := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	modified := created.Add(time.Hour)
+	directoryTree := mft.DirectoryTree{5: `c:\`, 40: `c:\Windows`}
+	records := []timelineRecord{
+		{recordNumber: 40, parent: 5, name: "Windows", flags: timelineDirectoryFlag, siTimes: timelineTimes(created, modified, modified, modified)},
+		{recordNumber: 41, parent: 40, name: "notepad.exe", size: 1024, siTimes: timelineTimes(created, modified, modified, modified), fnTimes: timelineTimes(created, created, created, created)},
+		{recordNumber: 42, parent: 99, name: "gone.txt", flags: timelineDeletedFlag},
+	}
+	tests := []struct {
+		name      string
+		format    TimelineFormat
+		wantLines []string
+	}{
+		{
+			name:   "bodyfile",
+			format: TimelineBodyfile,
+			wantLines: []string{
+				`0|c:\Windows|40|d/drwxrwxrwx|0|0|0|1583067600|1583067600|1583067600|1583064000`,
+				`0|c:\Windows\notepad.exe|41|r/rrwxrwxrwx|0|0|1024|1583067600|1583067600|1583067600|1583064000`,
+				`0|c:\Windows\notepad.exe ($FILE_NAME)|41|r/rrwxrwxrwx|0|0|1024|1583064000|1583064000|1583064000|1583064000`,
+				`0|c:\$ORPHANFILE\gone.txt (deleted)|42|r/rrwxrwxrwx|0|0|0|0|0|0|0`,
+			},
+		},
+		{
+			name:   "csv",
+			format: TimelineCSV,
+			wantLines: []string{
+				`41|false|false|false|false|false|c:\Windows\|notepad.exe|1024|2020-03-01T12:00:00Z|2020-03-01T13:00:00Z|2020-03-01T13:00:00Z|2020-03-01T13:00:00Z|2020-03-01T12:00:00Z|`,
+				`42|false|false|false|false|true|c:\$ORPHANFILE\|gone.txt|0|`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeline := newMftTimeline("c", tt.format)
+			timeline.records = records
+			output := new(bytes.Buffer)
+			if err := timeline.write(output, directoryTree); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+			for _, wantLine := range tt.wantLines {
+				if !strings.Contains(output.String(), wantLine) {
+					t.Errorf("write() wrote %q, want a line with %q", output.String(), wantLine)
+				}
+			}
+		})
+	}
+}
+
+func TestCollect_timeline(t *testing.T) {
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	handler := dummyHandler{filePath: `test\testdata\dummyntfs`}
+	tests := []struct {
+		name     string
+		format   TimelineFormat
+		wantName string
+		wantLine string
+	}{
+		{name: "none"},
+		{name: "bodyfile", format: TimelineBodyfile, wantName: "timeline/c.body", wantLine: `0|c:\$MFTMirr|1|r/rrwxrwxrwx|0|0|4096|`},
+		{name: "csv", format: TimelineCSV, wantName: "timeline/c_mft.csv", wantLine: `1|false|true|true|false|false|c:\|$MFTMirr|4096|`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := new(bytes.Buffer)
+			resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+			_, err := CollectWithReport(context.Background(), handler, exportList, &resultWriter, CollectOptions{Timeline: tt.format})
+			if err != nil {
+				t.Fatalf("CollectWithReport() error = %v", err)
+			}
+			reader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var timeline []byte
+			for _, file := range reader.File {
+				if !strings.HasPrefix(file.Name, "timeline/") {
+					continue
+				}
+				if file.Name != tt.wantName {
+					t.Fatalf("the zip holds %s, want %q", file.Name, tt.wantName)
+				}
+				fileReader, err := file.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				timeline, _ = ioutil.ReadAll(fileReader)
+				fileReader.Close()
+			}
+			if !strings.Contains(string(timeline), tt.wantLine) {
+				t.Errorf("the timeline doesn't have a line with %q", tt.wantLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const partialCollectionFileName = "partial_collection.json"
+
+// PartialCollection is written into the output as partial_collection.json whenever a volume couldn't be opened for raw
+// reads, which is what happens when the collector runs without administrator rights. It explains what was collected
+// through the API instead and what had to be left out.
+type PartialCollection struct {
+	Privileged     bool            `json:"privileged"`
+	APIOnlyVolumes []string        `json:"api_only_volumes"`
+	SkippedTargets []SkippedTarget `json:"skipped_targets"`
+}
+
+// SkippedTarget is a target or file that a partial collection could not get.
+type SkippedTarget struct {
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// partialCollectionTracker gathers a PartialCollection from every volume that falls back to API reads. Like the
+// reportBuilder its methods do nothing when it's nil.
+type partialCollectionTracker struct {
+	mutex   sync.Mutex
+	logger  Logger
+	partial PartialCollection
+}
+
+func newPartialCollectionTracker(privileged bool, logger Logger) *partialCollectionTracker {
+	return &partialCollectionTracker{
+		logger: logger,
+		partial: PartialCollection{
+			Privileged:     privileged,
+			APIOnlyVolumes: make([]string, 0),
+			SkippedTargets: make([]SkippedTarget, 0),
+		},
+	}
+}
+
+func (tracker *partialCollectionTracker) addVolume(volumeLetter string) {
+	if tracker == nil {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.partial.APIOnlyVolumes = append(tracker.partial.APIOnlyVolumes, volumeLetter)
+}
+
+func (tracker *partialCollectionTracker) skip(target string, reason string) {
+	if tracker == nil {
+		return
+	}
+	tracker.logger.Warnf("Skipping '%s' in a partial collection: %s", target, reason)
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.partial.SkippedTargets = append(tracker.partial.SkippedTargets, SkippedTarget{Target: target, Reason: reason})
+}
+
+// isPartial reports whether any volume had to fall back to API reads.
+func (tracker *partialCollectionTracker) isPartial() bool {
+	if tracker == nil {
+		return false
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return len(tracker.partial.APIOnlyVolumes) > 0
+}
+
+func (tracker *partialCollectionTracker) snapshot() (partial PartialCollection) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	partial = tracker.partial
+	partial.APIOnlyVolumes = append([]string(nil), tracker.partial.APIOnlyVolumes...)
+	partial.SkippedTargets = append([]SkippedTarget(nil), tracker.partial.SkippedTargets...)
+	return
+}
+
+// processIsElevated reports whether the collector is running with an elevated token. It's a variable so tests can
+// pretend either way.
+var processIsElevated = func() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+// isVolumeAccessDenied reports whether a volume couldn't be opened because the collector lacks the rights to, as
+// opposed to the volume not existing or not being NTFS.
+func isVolumeAccessDenied(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
+
+// collectVolumeViaAPI collects what it can from a volume that couldn't be opened for raw reads or whose MFT couldn't be
+// found. Literal paths are opened through the API, regex targets are only searched for in the current user's profile,
+// and NTFS metadata files are skipped since they can only be read raw. The current user's own ntuser.dat is locked while they are logged on, so it
+// is exported with RegSaveKeyEx instead.
+func (collector *Collector) collectVolumeViaAPI(ctx context.Context, volumeLetter string, fileReaders chan fileReader, searchTerms listOfSearchTerms) (err error) {
+	collector.logger().Warnf("Could not read volume %s raw, collecting what is reachable through the API instead.", volumeLetter)
+	collector.audit.record(AuditVolumeFallback, volumeLetter, "", "the volume couldn't be read raw, collecting what the API can open")
+	collector.partial.addVolume(volumeLetter)
+	profileDirectory := strings.ToLower(os.Getenv("USERPROFILE"))
+
+	var regexTerms listOfSearchTerms
+	var paths []string
+	for _, term := range searchTerms {
+		if !isSearchTermOnVolume(term, volumeLetter) {
+			continue
+		}
+		if strings.HasPrefix(term.fileNameString, "$") {
+			collector.partial.skip(term.fullPathString, "NTFS metadata files can only be read from the raw volume")
+			continue
+		}
+		if term.recordNumber != 0 {
+			collector.partial.skip(fmt.Sprintf("%s record %d", term.fullPathString, term.recordNumber), "files can only be selected by MFT record number on the raw volume")
+			continue
+		}
+		if term.fullPathRegex == nil && term.extensions == nil {
+			paths = append(paths, term.fullPathString)
+			continue
+		}
+		if !strings.HasPrefix(profileDirectory, volumeLetter+":") {
+			collector.partial.skip(term.pattern(), "only the current user's profile is searched without administrator rights")
+			continue
+		}
+		regexTerms = append(regexTerms, term)
+	}
+	if len(regexTerms) != 0 {
+		paths = append(paths, findInDirectory(profileDirectory, regexTerms)...)
+	}
+	err = collector.collectPaths(ctx, volumeLetter, paths, fileReaders, searchTerms, func(path string) (reader io.Reader, method string, err error) {
+		reader, method, err = openWithoutPrivileges(collector.logger(), path, profileDirectory)
+		if err != nil {
+			collector.partial.skip(path, err.Error())
+		}
+		return
+	})
+	return
+}
+
+// collectPaths collects the files at paths found without the MFT, opening each with open. Each file is checked against
+// the search term that covers it, and the budget and limits go by the sizes the API gives.
+func (collector *Collector) collectPaths(ctx context.Context, volumeLetter string, paths []string, fileReaders chan fileReader, searchTerms listOfSearchTerms, open func(path string) (reader io.Reader, method string, err error)) (err error) {
+	var files foundFiles
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		term, found := searchTermForPath(path, searchTerms)
+		if !found {
+			collector.logger().Debugf("Leaving out '%s', it's excluded by the target.", path)
+			continue
+		}
+		file := foundFile{fullPath: path, codec: term.codec, processors: term.processors, priority: term.priority, limits: term.limits, target: term.target, readPolicy: term.readPolicy, hashes: term.hashes}
+		if info, statErr := os.Stat(path); statErr == nil {
+			file.fileSize = info.Size()
+			// Without the MFT only the modified time window can be checked
+			if !term.modified.contains(info.ModTime()) {
+				collector.logger().Debugf("Leaving out '%s', it's outside the target's time window.", path)
+				continue
+			}
+		}
+		files = append(files, file)
+	}
+
+	files = collector.keepHashMatches(ctx, volumeLetter, files, func(file foundFile) (io.Reader, error) {
+		reader, _, err := open(file.fullPath)
+		return reader, err
+	})
+	files, numberResumed := collector.Options.Resume.filterFiles(files)
+	collector.report.addResumed(volumeLetter, numberResumed)
+	files = collector.applyLimits(volumeLetter, files, false)
+	for _, file := range collector.budget.planFiles(volumeLetter, files) {
+		if collector.Options.readPolicyFor(file) == ReadRawOnly {
+			collector.report.fileFailed(file.fullPath, volumeLetter, errors.New("the volume can only be read through the API and the file's read policy is raw_only"))
+			continue
+		}
+		if collector.planner.planned(volumeLetter, foundFiles{file}) {
+			continue
+		}
+		reader, method, openErr := open(file.fullPath)
+		if openErr != nil {
+			collector.report.fileFailed(file.fullPath, volumeLetter, openErr)
+			continue
+		}
+		collector.report.addMatches(volumeLetter, 1)
+		reader = collector.instrumentReader(ctx, reader, Progress{
+			Stage:        StageCopy,
+			VolumeLetter: volumeLetter,
+			FileName:     file.fullPath,
+			TotalBytes:   file.fileSize,
+		})
+		if collector.dedup != nil {
+			spooled := collector.spoolUnlessDuplicate(reader, file.fullPath, volumeLetter)
+			if spooled == nil {
+				continue
+			}
+			reader = spooled
+		}
+		err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(fileReader{
+			fullPath:   file.fullPath,
+			codec:      file.codec,
+			processors: file.processors,
+			method:     method,
+			reader:     reader,
+		}, volumeLetter))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// pattern is how a search term's full path is shown: its regex, its literal path, or the directory its extensions are
+// swept for under.
+func (terms searchTerms) pattern() string {
+	if terms.fullPathRegex != nil {
+		return terms.fullPathRegex.String()
+	}
+	return terms.fullPathString
+}
+
+// isSearchTermOnVolume reports whether a search term, literal or regex, is for a path on the given volume.
+func isSearchTermOnVolume(term searchTerms, volumeLetter string) bool {
+	if isShare(volumeLetter) || term.share != "" {
+		return term.share == volumeLetter
+	}
+	if term.fullPathRegex != nil {
+		return strings.HasPrefix(term.fullPathRegex.String(), volumeLetter+":")
+	}
+	return strings.HasPrefix(term.fullPathString, volumeLetter+":")
+}
+
+// findInDirectory walks a directory tree through the API and returns the lowercased paths of the files matching any of
+// the regex search terms. Directories that can't be listed are skipped.
+func findInDirectory(directory string, regexTerms listOfSearchTerms) (matches []string) {
+	return findInDirectoryAs(directory, directory, regexTerms)
+}
+
+// findInDirectoryAs is findInDirectory for a directory the search terms know by another name, such as a volume GUID
+// path that they know by its volume's name. The paths are matched and returned under that name.
+func findInDirectoryAs(directory string, name string, regexTerms listOfSearchTerms) (matches []string) {
+	_ = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		path = strings.ToLower(name + strings.TrimPrefix(path, directory))
+		fileName := strings.ToLower(info.Name())
+		for _, term := range regexTerms {
+			if term.extensions != nil {
+				if !term.sweeps(path) {
+					continue
+				}
+			} else if !term.fullPathRegex.MatchString(path) {
+				continue
+			} else if term.fileNameRegex != nil && !term.fileNameRegex.MatchString(fileName) {
+				continue
+			} else if term.fileNameRegex == nil && term.fileNameString != fileName {
+				continue
+			}
+			if term.excludes(path) {
+				continue
+			}
+			matches = append(matches, path)
+			break
+		}
+		return nil
+	})
+	return
+}
+
+// searchTermForPath returns the first search term that covers path without excluding it.
+func searchTermForPath(path string, listOfSearchKeywords listOfSearchTerms) (term searchTerms, found bool) {
+	for _, candidate := range listOfSearchKeywords {
+		if candidate.excludes(path) {
+			continue
+		}
+		if (candidate.fullPathString == path && candidate.extensions == nil) || (candidate.fullPathRegex != nil && candidate.fullPathRegex.MatchString(path)) || candidate.sweeps(path) {
+			term, found = candidate, true
+			return
+		}
+	}
+	return
+}
+
+// openWithoutPrivileges opens a file through the API, with backup semantics so a member of Backup Operators can read
+// past the file's security. The current user's ntuser.dat can't be opened while they are logged on, so it gets exported
+// with RegSaveKeyEx instead.
+func openWithoutPrivileges(logger Logger, path string, profileDirectory string) (reader io.Reader, method string, err error) {
+	file, err := openWithBackupSemantics(path)
+	if err == nil {
+		reader = &closingReader{file: file}
+		method = readMethodAPI
+		return
+	}
+	if profileDirectory == "" || path != profileDirectory+`\ntuser.dat` {
+		return
+	}
+	logger.Debugf("'%s' is locked, exporting the current user's hive with RegSaveKeyEx instead.", path)
+	method = readMethodHiveExport
+	reader, err = saveCurrentUserHive(logger)
+	if err != nil {
+		err = fmt.Errorf("failed to export the current user's hive: %w", err)
+	}
+	return
+}
+
+// closingReader closes its file once it has been read to the end or fails, since result writers don't close readers.
+type closingReader struct {
+	file io.ReadCloser
+}
+
+func (closingReader *closingReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = closingReader.file.Read(byteSliceToPopulate)
+	if err != nil {
+		closingReader.file.Close()
+	}
+	return
+}
+
+// saveCurrentUserHive exports HKEY_CURRENT_USER. RegSaveKeyEx needs the backup privilege, which Backup Operators hold
+// without being administrators; for anyone else this returns the access denied error.
+var saveCurrentUserHive = func(logger Logger) (reader io.Reader, err error) {
+	reader, err = exportHive(logger, loadedHive{root: registry.CURRENT_USER})
+	return
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type accessDeniedHandler struct{}
+
+func (accessDeniedHandler) GetHandle(volumeLetter string) (handle *os.File, err error) {
+	err = fmt.Errorf("getHandle() failed to get handle to volume %s: %w", volumeLetter, os.ErrPermission)
+	return
+}
+
+func TestCollectWithReport_accessDenied(t *testing.T) {
+	output := new(bytes.Buffer)
+	resultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+	exportList := ListOfFilesToExport{
+		{FullPath: `c:\$MFT`, FileName: `$MFT`},
+	}
+	report, err := CollectWithReport(context.Background(), accessDeniedHandler{}, exportList, &resultWriter, CollectOptions{})
+	if err != nil {
+		t.Fatalf("CollectWithReport() error = %v", err)
+	}
+	if !report.Partial {
+		t.Errorf("CollectWithReport() report.Partial = false, want true")
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	var gotPartial PartialCollection
+	for _, file := range zipReader.File {
+		if file.Name != partialCollectionFileName {
+			continue
+		}
+		reader, _ := file.Open()
+		err = json.NewDecoder(reader).Decode(&gotPartial)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", partialCollectionFileName, err)
+		}
+	}
+	wantPartial := PartialCollection{
+		Privileged:     processIsElevated(),
+		APIOnlyVolumes: []string{"c"},
+		SkippedTargets: []SkippedTarget{
+			{Target: `c:\$mft`, Reason: "NTFS metadata files can only be read from the raw volume"},
+		},
+	}
+	if !reflect.DeepEqual(gotPartial, wantPartial) {
+		t.Errorf("%s = %+v, want %+v", partialCollectionFileName, gotPartial, wantPartial)
+	}
+}
+
+func Test_isVolumeAccessDenied(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "wrapped permission error", err: fmt.Errorf("GetVolumeHandler() failed: %w", os.ErrPermission), want: true},
+		{name: "other error", err: errors.New("not ntfs"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isVolumeAccessDenied(tt.err); got != tt.want {
+				t.Errorf("isVolumeAccessDenied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isSearchTermOnVolume(t *testing.T) {
+	terms, _ := setupSearchTerms(ListOfFilesToExport{
+		{FullPath: `c:\windows\system32\config\SYSTEM`, FileName: `SYSTEM`},
+		{FullPath: `d:\\users\\([^\\]+)\\ntuser.dat`, IsFullPathRegex: true, FileName: `ntuser.dat`},
+		{FullPath: `\\ws042\c$\windows\system32\config\SYSTEM`, FileName: `SYSTEM`},
+	})
+	tests := []struct {
+		name         string
+		term         searchTerms
+		volumeLetter string
+		want         bool
+	}{
+		{name: "literal on volume", term: terms[0], volumeLetter: "c", want: true},
+		{name: "literal on other volume", term: terms[0], volumeLetter: "d", want: false},
+		{name: "regex on volume", term: terms[1], volumeLetter: "d", want: true},
+		{name: "regex on other volume", term: terms[1], volumeLetter: "c", want: false},
+		{name: "share", term: terms[2], volumeLetter: `\\ws042\c$`, want: true},
+		{name: "share on a local volume", term: terms[2], volumeLetter: "c", want: false},
+		{name: "local volume on a share", term: terms[0], volumeLetter: `\\ws042\c$`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSearchTermOnVolume(tt.term, tt.volumeLetter); got != tt.want {
+				t.Errorf("isSearchTermOnVolume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findInDirectory(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-profile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	_ = os.MkdirAll(filepath.Join(directory, "AppData", "Local"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(directory, "AppData", "Local", "WebCacheV01.dat"), []byte("webcache"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(directory, "notes.txt"), []byte("notes"), 0644)
+
+	_ = os.MkdirAll(filepath.Join(directory, "AppData", "Roaming"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(directory, "AppData", "Roaming", "WebCacheV01.dat"), []byte("webcache"), 0644)
+
+	terms, _ := setupSearchTerms(ListOfFilesToExport{
+		{FullPath: `.*webcachev01\.dat$`, IsFullPathRegex: true, FileName: `WebCacheV01.dat`, Exclude: `roaming`},
+	})
+	got := findInDirectory(directory, terms)
+	want := []string{strings.ToLower(filepath.Join(directory, "AppData", "Local", "WebCacheV01.dat"))}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findInDirectory() = %v, want %v", got, want)
+	}
+}
+
+func Test_openWithoutPrivileges(t *testing.T) {
+	savedHive := saveCurrentUserHive
+	defer func() { saveCurrentUserHive = savedHive }()
+	saveCurrentUserHive = func(Logger) (io.Reader, error) {
+		return strings.NewReader("regf"), nil
+	}
+
+	tests := []struct {
+		name             string
+		path             string
+		profileDirectory string
+		want             string
+		wantErr          bool
+	}{
+		{name: "locked ntuser.dat", path: `c:\users\missing\ntuser.dat`, profileDirectory: `c:\users\missing`, want: "regf", wantErr: false},
+		{name: "missing file", path: `c:\users\missing\other.dat`, profileDirectory: `c:\users\missing`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, _, err := openWithoutPrivileges(loggerOrDefault(nil), tt.path, tt.profileDirectory)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("openWithoutPrivileges() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _ := ioutil.ReadAll(reader)
+			if string(got) != tt.want {
+				t.Errorf("openWithoutPrivileges() read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUploadChunkSize  = 8 * 1024 * 1024
+	defaultUploadMaxRetries = 10
+	defaultUploadRetryWait  = time.Second
+	maxUploadRetryWait      = time.Minute
+)
+
+// uploadTarget is somewhere a collection can be uploaded to a chunk at a time. The chunkedUploader takes care of
+// buffering and retries, a target only knows how to talk to its destination.
+type uploadTarget interface {
+	// send uploads data starting at start and returns how far into the upload the destination has received. final is
+	// set for the last chunk, which may be empty.
+	send(ctx context.Context, data []byte, start int64, final bool) (committed int64, err error)
+	// query asks the destination how much of the upload it has, so a failed chunk can be resumed.
+	query(ctx context.Context) (committed int64, err error)
+	// String describes the destination for logs without giving away any credentials in it.
+	String() string
+}
+
+// uploadResults zips the found files into uploader, then uploads whatever is left of the zip. It is the ResultWriter
+// of every result writer built on a chunkedUploader.
+func uploadResults(ctx context.Context, fileReaders chan fileReader, uploader *chunkedUploader, codec string) (err error) {
+	zipResultWriter := ZipResultWriter{
+		ZipWriter: zip.NewWriter(uploader),
+		Codec:     codec,
+		Logger:    uploader.logger,
+	}
+	waitForZip := sync.WaitGroup{}
+	waitForZip.Add(1)
+	err = zipResultWriter.ResultWriter(ctx, fileReaders, &waitForZip)
+	closeErr := uploader.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		uploader.logger.Errorf("Upload to %s failed: %v", uploader.target, err)
+	}
+	return
+}
+
+// drainFileReaders empties the channel so Collect doesn't block on a writer that failed before it started reading.
+func drainFileReaders(fileReaders chan fileReader) {
+	for range fileReaders {
+	}
+}
+
+// chunkedUploader buffers what is written to it and uploads it to its target a chunk at a time.
+type chunkedUploader struct {
+	ctx        context.Context
+	logger     Logger
+	target     uploadTarget
+	maxRetries int
+	retryWait  time.Duration
+
+	buffer []byte
+	offset int64 // how much of the upload the target has confirmed
+	err    error // once an upload fails every later write fails too
+}
+
+// newChunkedUploader fills in the defaults for anything left at zero.
+func newChunkedUploader(ctx context.Context, logger Logger, target uploadTarget, chunkSize int, maxRetries int, retryWait time.Duration) (uploader *chunkedUploader) {
+	if chunkSize <= 0 {
+		chunkSize = defaultUploadChunkSize
+	}
+	if maxRetries <= 0 {
+		maxRetries = defaultUploadMaxRetries
+	}
+	if retryWait <= 0 {
+		retryWait = defaultUploadRetryWait
+	}
+	uploader = &chunkedUploader{
+		ctx:        ctx,
+		logger:     loggerOrDefault(logger),
+		target:     target,
+		maxRetries: maxRetries,
+		retryWait:  retryWait,
+		buffer:     make([]byte, 0, chunkSize),
+	}
+	return
+}
+
+func (uploader *chunkedUploader) Write(data []byte) (numberOfBytesWritten int, err error) {
+	if uploader.err != nil {
+		err = uploader.err
+		return
+	}
+	for len(data) > 0 {
+		space := cap(uploader.buffer) - len(uploader.buffer)
+		if space > len(data) {
+			space = len(data)
+		}
+		uploader.buffer = append(uploader.buffer, data[:space]...)
+		data = data[space:]
+		numberOfBytesWritten += space
+		if len(uploader.buffer) == cap(uploader.buffer) {
+			err = uploader.upload(false)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Close uploads whatever is still buffered as the final chunk.
+func (uploader *chunkedUploader) Close() (err error) {
+	if uploader.err != nil {
+		err = uploader.err
+		return
+	}
+	err = uploader.upload(true)
+	return
+}
+
+// upload sends the buffered chunk, retrying and resuming until the target has all of it.
+func (uploader *chunkedUploader) upload(final bool) (err error) {
+	chunk := uploader.buffer
+	sent := 0
+	wait := uploader.retryWait
+	for attempt := 0; ; attempt++ {
+		var committed int64
+		committed, err = uploader.target.send(uploader.ctx, chunk[sent:], uploader.offset+int64(sent), final)
+		if err == nil {
+			sent = clampSent(committed-uploader.offset, len(chunk))
+			if sent == len(chunk) {
+				break
+			}
+			err = fmt.Errorf("server only has the upload up to byte %d", committed)
+		}
+		if attempt >= uploader.maxRetries || isPermanentUploadError(err) {
+			uploader.err = fmt.Errorf("giving up on the upload at byte %d after %d attempts: %w", uploader.offset+int64(sent), attempt+1, err)
+			err = uploader.err
+			return
+		}
+
+		uploader.logger.Warnf("Uploading bytes %d to %d failed, retrying in %v: %v", uploader.offset, uploader.offset+int64(len(chunk)), wait, err)
+		select {
+		case <-time.After(wait):
+		case <-uploader.ctx.Done():
+			uploader.err = uploader.ctx.Err()
+			err = uploader.err
+			return
+		}
+		wait *= 2
+		if wait > maxUploadRetryWait {
+			wait = maxUploadRetryWait
+		}
+
+		// Pick up from wherever the target got to before the failure
+		committed, queryErr := uploader.target.query(uploader.ctx)
+		if queryErr == nil {
+			sent = clampSent(committed-uploader.offset, len(chunk))
+		}
+	}
+	uploader.offset += int64(len(chunk))
+	uploader.buffer = uploader.buffer[:0]
+	return
+}
+
+// parseUploadRange reads a "bytes=0-1234" Range header and returns the offset after it. An empty or malformed header
+// gives defaultCommitted.
+func parseUploadRange(rangeHeader string, defaultCommitted int64) int64 {
+	if !strings.HasPrefix(rangeHeader, "bytes=") {
+		return defaultCommitted
+	}
+	end := rangeHeader[strings.LastIndex(rangeHeader, "-")+1:]
+	lastByte, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return defaultCommitted
+	}
+	return lastByte + 1
+}
+
+func clampSent(sent int64, chunkLength int) int {
+	if sent < 0 {
+		return 0
+	} else if sent > int64(chunkLength) {
+		return chunkLength
+	}
+	return int(sent)
+}
+
+// permanentUploadError is an upload failure that retrying won't fix.
+type permanentUploadError struct {
+	err error
+}
+
+func (permanentError permanentUploadError) Error() string {
+	return permanentError.err.Error()
+}
+
+func (permanentError permanentUploadError) Unwrap() error {
+	return permanentError.err
+}
+
+func isPermanentUploadError(err error) bool {
+	var permanentError permanentUploadError
+	return errors.As(err, &permanentError)
+}
+
+// uploadStatusError turns an unexpected response into an error. Client errors other than timeouts and rate limiting are
+// permanent.
+func uploadStatusError(response *http.Response) (err error) {
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	err = fmt.Errorf("server replied %s: %s", response.Status, strings.TrimSpace(string(body)))
+	if response.StatusCode >= 400 && response.StatusCode < 500 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+		err = permanentUploadError{err}
+	}
+	return
+}
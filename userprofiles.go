@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// UserProfilePlaceholder starts the FullPath of a target that's in every user's profile, such as
+// %USERPROFILE%\ntuser.dat, or %USERPROFILE%\\AppData\\Local\\.*\.db$ as a regex. The profiles are the ones the
+// ProfileList key lists, so roaming and redirected profiles and profiles on other volumes than the system's are found
+// too, not only the directories under C:\Users.
+const UserProfilePlaceholder = "%USERPROFILE%"
+
+// profilesFallback is what UserProfilePlaceholder stands for when the profiles can't be listed.
+const profilesFallback = `%SYSTEMDRIVE%:\\Users\\[^\\]+`
+
+// serviceProfileSIDs are the profiles of LocalSystem, LocalService and NetworkService, which are left out since they
+// aren't anyone's.
+var serviceProfileSIDs = map[string]bool{"S-1-5-18": true, "S-1-5-19": true, "S-1-5-20": true}
+
+// UserProfile is a user's profile as the ProfileList key lists it.
+type UserProfile struct {
+	Directory string // as ProfileList has it, e.g. C:\Users\Alice
+	SID       string
+}
+
+// listUserProfiles returns the users' profiles sorted by directory, or nil when they couldn't be listed.
+func listUserProfiles(logger Logger) (profiles []UserProfile) {
+	for directory, sid := range userProfiles(logger) {
+		if !serviceProfileSIDs[sid] {
+			profiles = append(profiles, UserProfile{Directory: directory, SID: sid})
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Directory < profiles[j].Directory })
+	return
+}
+
+// profileDirectories returns the directories of profiles.
+func profileDirectories(profiles []UserProfile) (directories []string) {
+	for _, profile := range profiles {
+		directories = append(directories, profile.Directory)
+	}
+	return
+}
+
+// ExpandUserProfiles replaces UserProfilePlaceholder in the targets with the profile directories it stands for. The
+// profiles on each volume are folded into a single target, a literal one when there's only one profile on the volume
+// and the target is literal. Without any profileDirectories the placeholder matches every directory under
+// %SYSTEMDRIVE%:\Users instead, which is also how targets for another machine's shares are expanded.
+func ExpandUserProfiles(exportList ListOfFilesToExport, profileDirectories []string) (expanded ListOfFilesToExport) {
+	for _, target := range exportList {
+		if !strings.HasPrefix(strings.ToUpper(target.FullPath), UserProfilePlaceholder) {
+			expanded = append(expanded, target)
+			continue
+		}
+		rest := target.FullPath[len(UserProfilePlaceholder):]
+		restRegex := rest
+		if !target.IsFullPathRegex {
+			restRegex = regexp.QuoteMeta(rest) + `$`
+		}
+		if len(profileDirectories) == 0 {
+			target.FullPath, target.IsFullPathRegex = profilesFallback+restRegex, true
+			expanded = append(expanded, target)
+			continue
+		}
+
+		// A target's volume comes from the start of its path, so the profiles are grouped by volume
+		var volumes []string
+		profilesOnVolume := make(map[string][]string)
+		for _, directory := range profileDirectories {
+			if len(directory) < 2 || directory[1] != ':' {
+				continue
+			}
+			volume := strings.ToLower(directory[:2])
+			if _, found := profilesOnVolume[volume]; !found {
+				volumes = append(volumes, volume)
+			}
+			profilesOnVolume[volume] = append(profilesOnVolume[volume], strings.TrimRight(directory[2:], `\`))
+		}
+		for _, volume := range volumes {
+			profiles := profilesOnVolume[volume]
+			perVolume := target
+			if len(profiles) == 1 && !target.IsFullPathRegex {
+				perVolume.FullPath = volume + profiles[0] + rest
+				expanded = append(expanded, perVolume)
+				continue
+			}
+			quoted := make([]string, 0, len(profiles))
+			for _, profile := range profiles {
+				quoted = append(quoted, regexp.QuoteMeta(profile))
+			}
+			perVolume.FullPath = volume + `(?:` + strings.Join(quoted, "|") + `)` + restRegex
+			perVolume.IsFullPathRegex = true
+			expanded = append(expanded, perVolume)
+		}
+	}
+	return
+}
+
+// usesUserProfiles reports whether any of the targets has UserProfilePlaceholder to expand.
+func usesUserProfiles(exportList ListOfFilesToExport) bool {
+	for _, target := range exportList {
+		if strings.HasPrefix(strings.ToUpper(target.FullPath), UserProfilePlaceholder) {
+			return true
+		}
+	}
+	return false
+}
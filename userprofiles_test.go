@@ -0,0 +1,89 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandUserProfiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      FileToExport
+		directories []string
+		want        ListOfFilesToExport
+	}{
+		{
+			name:        "not in a profile",
+			target:      FileToExport{FullPath: `%SYSTEMDRIVE%:\$MFT`, FileName: "$MFT"},
+			directories: []string{`c:\users\alice`},
+			want:        ListOfFilesToExport{{FullPath: `%SYSTEMDRIVE%:\$MFT`, FileName: "$MFT"}},
+		},
+		{
+			name:        "one profile",
+			target:      FileToExport{FullPath: `%USERPROFILE%\ntuser.dat`, FileName: "ntuser.dat"},
+			directories: []string{`c:\users\alice`},
+			want:        ListOfFilesToExport{{FullPath: `c:\users\alice\ntuser.dat`, FileName: "ntuser.dat"}},
+		},
+		{
+			name:        "profiles on one volume",
+			target:      FileToExport{FullPath: `%USERPROFILE%\ntuser.dat`, FileName: "ntuser.dat"},
+			directories: []string{`c:\users\alice`, `c:\users\bob`},
+			want: ListOfFilesToExport{
+				{FullPath: `c:(?:\\users\\alice|\\users\\bob)\\ntuser\.dat$`, IsFullPathRegex: true, FileName: "ntuser.dat"},
+			},
+		},
+		{
+			name:        "profiles on two volumes",
+			target:      FileToExport{FullPath: `%USERPROFILE%\ntuser.dat`, FileName: "ntuser.dat"},
+			directories: []string{`c:\users\alice`, `d:\profiles\bob`},
+			want: ListOfFilesToExport{
+				{FullPath: `c:\users\alice\ntuser.dat`, FileName: "ntuser.dat"},
+				{FullPath: `d:\profiles\bob\ntuser.dat`, FileName: "ntuser.dat"},
+			},
+		},
+		{
+			name:        "regex",
+			target:      FileToExport{FullPath: `%USERPROFILE%\\AppData\\Local\\[^\\]+\.db$`, IsFullPathRegex: true, FileName: `\.db$`, IsFileNameRegex: true},
+			directories: []string{`c:\users\alice`},
+			want: ListOfFilesToExport{
+				{FullPath: `c:(?:\\users\\alice)\\AppData\\Local\\[^\\]+\.db$`, IsFullPathRegex: true, FileName: `\.db$`, IsFileNameRegex: true},
+			},
+		},
+		{
+			name:   "no profiles",
+			target: FileToExport{FullPath: `%USERPROFILE%\ntuser.dat`, FileName: "ntuser.dat"},
+			want: ListOfFilesToExport{
+				{FullPath: `%SYSTEMDRIVE%:\\Users\\[^\\]+\\ntuser\.dat$`, IsFullPathRegex: true, FileName: "ntuser.dat"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExpandUserProfiles(ListOfFilesToExport{tt.target}, tt.directories)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandUserProfiles() = %+v, want %+v", got, tt.want)
+			}
+			if _, err := compileSearchTerms(got[0]); err != nil {
+				t.Errorf("compileSearchTerms() error = %v for %+v", err, got[0])
+			}
+		})
+	}
+}
+
+func Test_listUserProfiles(t *testing.T) {
+	defer func(original func(logger Logger) map[string]string) { userProfiles = original }(userProfiles)
+	userProfiles = func(logger Logger) map[string]string {
+		return map[string]string{
+			`c:\windows\system32\config\systemprofile`: "S-1-5-18",
+			`d:\profiles\bob`:                          "S-1-5-21-1-2-3-1002",
+			`c:\users\alice`:                           "S-1-5-21-1-2-3-1001",
+		}
+	}
+	got := listUserProfiles(nil)
+	want := []UserProfile{{Directory: `c:\users\alice`, SID: "S-1-5-21-1-2-3-1001"}, {Directory: `d:\profiles\bob`, SID: "S-1-5-21-1-2-3-1002"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listUserProfiles() = %v, want %v", got, want)
+	}
+}
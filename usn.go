@@ -0,0 +1,234 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+const (
+	fsctlQueryUsnJournal = 0x000900f4
+	fsctlReadUsnJournal  = 0x000900bb
+)
+
+// USNJournalMark is how far a volume's USN change journal had got when the volume was collected. Passing the marks
+// from a collection's report as CollectOptions.ChangedSince collects only the files that changed after it.
+type USNJournalMark struct {
+	JournalID uint64 `json:"journal_id"`
+	NextUSN   int64  `json:"next_usn"`
+}
+
+// USNJournalMarks returns the change journal marks of the report's volumes by volume letter, for the ChangedSince of
+// the next collection.
+func (report CollectionReport) USNJournalMarks() (marks map[string]USNJournalMark) {
+	marks = make(map[string]USNJournalMark)
+	for _, volume := range report.Volumes {
+		if volume.USNJournal != nil {
+			marks[volume.Letter] = *volume.USNJournal
+		}
+	}
+	return
+}
+
+// usnJournal is the USN_JOURNAL_DATA_V0 returned by FSCTL_QUERY_USN_JOURNAL.
+type usnJournal struct {
+	UsnJournalID    uint64
+	FirstUsn        int64
+	NextUsn         int64
+	LowestValidUsn  int64
+	MaxUsn          int64
+	MaximumSize     uint64
+	AllocationDelta uint64
+}
+
+// readUsnJournalData is the READ_USN_JOURNAL_DATA_V0 passed to FSCTL_READ_USN_JOURNAL.
+type readUsnJournalData struct {
+	StartUsn          int64
+	ReasonMask        uint32
+	ReturnOnlyOnClose uint32
+	Timeout           uint64
+	BytesToWaitFor    uint64
+	UsnJournalID      uint64
+}
+
+// usnRecord is what an incremental collection needs from a change journal record.
+type usnRecord struct {
+	recordNumber uint32
+	usn          int64
+	timestamp    time.Time
+}
+
+// queryUSNJournal asks the file system where the volume's change journal is. It's a variable so tests don't depend on
+// the host's volumes.
+var queryUSNJournal = func(volumeHandler *VolumeHandler) (journal usnJournal, err error) {
+	var bytesReturned uint32
+	err = windows.DeviceIoControl(windows.Handle(volumeHandler.Handle.Fd()), fsctlQueryUsnJournal, nil, 0, (*byte)(unsafe.Pointer(&journal)), uint32(unsafe.Sizeof(journal)), &bytesReturned, nil)
+	if err != nil {
+		err = fmt.Errorf("FSCTL_QUERY_USN_JOURNAL failed on volume %s: %w", volumeHandler.VolumeLetter, err)
+	}
+	return
+}
+
+// readUSNJournal returns the change journal's records from startUSN up to where the journal was when it was queried.
+// It's a variable so tests don't depend on the host's volumes.
+var readUSNJournal = func(volumeHandler *VolumeHandler, journal usnJournal, startUSN int64) (records []usnRecord, err error) {
+	buffer := make([]byte, 64*1024)
+	for startUSN < journal.NextUsn {
+		request := readUsnJournalData{
+			StartUsn:     startUSN,
+			ReasonMask:   0xffffffff,
+			UsnJournalID: journal.UsnJournalID,
+		}
+		var bytesReturned uint32
+		err = windows.DeviceIoControl(windows.Handle(volumeHandler.Handle.Fd()), fsctlReadUsnJournal, (*byte)(unsafe.Pointer(&request)), uint32(unsafe.Sizeof(request)), &buffer[0], uint32(len(buffer)), &bytesReturned, nil)
+		if err != nil {
+			err = fmt.Errorf("FSCTL_READ_USN_JOURNAL failed on volume %s at USN %d: %w", volumeHandler.VolumeLetter, startUSN, err)
+			return
+		}
+		nextUSN, batch := parseUSNRecords(buffer[:bytesReturned])
+		records = append(records, batch...)
+		if nextUSN <= startUSN {
+			break
+		}
+		startUSN = nextUSN
+	}
+	return
+}
+
+// parseUSNRecords parses the output of FSCTL_READ_USN_JOURNAL, the USN to continue from followed by version 2 or 3
+// USN records. Records of other versions are skipped.
+func parseUSNRecords(buffer []byte) (nextUSN int64, records []usnRecord) {
+	const (
+		offsetMajorVersion = 0x04
+		offsetReference    = 0x08
+		sizeRecordV2Header = 0x3c
+		sizeRecordV3Header = 0x4c
+	)
+	if len(buffer) < 8 {
+		return
+	}
+	nextUSN = int64(binary.LittleEndian.Uint64(buffer))
+	offset := 8
+	for offset+offsetReference <= len(buffer) {
+		recordLength := int(binary.LittleEndian.Uint32(buffer[offset:]))
+		if recordLength < sizeRecordV2Header || offset+recordLength > len(buffer) {
+			break
+		}
+		record := buffer[offset : offset+recordLength]
+		offset += recordLength
+
+		// Version 3 records have 128 bit file references, which push the rest of the fields back by 16 bytes
+		var offsetUSN int
+		switch binary.LittleEndian.Uint16(record[offsetMajorVersion:]) {
+		case 2:
+			offsetUSN = 0x18
+		case 3:
+			if recordLength < sizeRecordV3Header {
+				continue
+			}
+			offsetUSN = 0x28
+		default:
+			continue
+		}
+		records = append(records, usnRecord{
+			recordNumber: uint32(binary.LittleEndian.Uint64(record[offsetReference:]) & 0xffffffffffff),
+			usn:          int64(binary.LittleEndian.Uint64(record[offsetUSN:])),
+			timestamp:    fromFiletime(int64(binary.LittleEndian.Uint64(record[offsetUSN+8:]))),
+		})
+	}
+	return
+}
+
+// fromFiletime converts a number of 100 nanosecond intervals since January 1, 1601 into a time.
+func fromFiletime(filetime int64) time.Time {
+	const (
+		secondsFrom1601To1970 = 11644473600
+		intervalsPerSecond    = 10000000
+	)
+	return time.Unix(filetime/intervalsPerSecond-secondsFrom1601To1970, filetime%intervalsPerSecond*100).UTC()
+}
+
+// incremental is whether only files that changed since an earlier collection are collected.
+func (options CollectOptions) incremental() bool {
+	return len(options.ChangedSince) != 0 || !options.ChangedAfter.IsZero()
+}
+
+// changedSince returns the volume's mark in ChangedSince.
+func (options CollectOptions) changedSince(volumeLetter string) (mark USNJournalMark, found bool) {
+	for letter, volumeMark := range options.ChangedSince {
+		if strings.EqualFold(letter, volumeLetter) {
+			return volumeMark, true
+		}
+	}
+	return
+}
+
+// changeFilter is the set of files on a volume that the change journal shows were changed, by MFT record number.
+type changeFilter struct {
+	changed map[uint32]bool
+}
+
+// newChangeFilter reads the volume's change journal for an incremental collection. When the journal can't tell what
+// changed it returns nil, so every file is collected, along with why.
+func newChangeFilter(volumeHandler *VolumeHandler, journal usnJournal, journalErr error, options CollectOptions) (filter *changeFilter, fallback string) {
+	if journalErr != nil {
+		fallback = fmt.Sprintf("the change journal couldn't be queried: %v", journalErr)
+		return
+	}
+	mark, found := options.changedSince(volumeHandler.VolumeLetter)
+	startUSN := journal.FirstUsn
+	switch {
+	case found && mark.JournalID != journal.UsnJournalID:
+		fallback = "the change journal was recreated since the mark"
+		return
+	case found && mark.NextUSN < journal.LowestValidUsn:
+		fallback = "the change journal has been trimmed past the mark"
+		return
+	case found && mark.NextUSN > journal.NextUsn:
+		fallback = "the mark is ahead of the change journal"
+		return
+	case found:
+		startUSN = mark.NextUSN
+	case options.ChangedAfter.IsZero():
+		fallback = "there's no mark for the volume"
+		return
+	}
+
+	records, err := readUSNJournal(volumeHandler, journal, startUSN)
+	if err != nil {
+		fallback = fmt.Sprintf("the change journal couldn't be read: %v", err)
+		return
+	}
+	if !found && (len(records) == 0 || records[0].timestamp.After(options.ChangedAfter)) {
+		fallback = fmt.Sprintf("the change journal doesn't go back to %s", options.ChangedAfter.UTC().Format(time.RFC3339))
+		return
+	}
+	filter = &changeFilter{changed: make(map[uint32]bool)}
+	for _, record := range records {
+		if found || !record.timestamp.Before(options.ChangedAfter) {
+			filter.changed[record.recordNumber] = true
+		}
+	}
+	volumeHandler.logger().Debugf("The change journal of volume %s shows %d files changed since USN %d.", volumeHandler.VolumeLetter, len(filter.changed), startUSN)
+	return
+}
+
+// filterFiles returns the files that were changed and how many were left out. A nil changeFilter keeps every file.
+func (filter *changeFilter) filterFiles(files foundFiles) (changed foundFiles, numberOfUnchanged int) {
+	if filter == nil {
+		return files, 0
+	}
+	for _, file := range files {
+		if filter.changed[file.metadata.recordNumber] {
+			changed = append(changed, file)
+		} else {
+			numberOfUnchanged++
+		}
+	}
+	return
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseUSNRecords(t *testing.T) {
+	timestamp := time.Date(2020, 3, 4, 5, 6, 7, 800000000, time.UTC)
+	record := func(majorVersion uint16, reference uint64, usn int64) []byte {
+		offsetUSN, length := 0x18, 0x3c+8
+		if majorVersion == 3 {
+			offsetUSN, length = 0x28, 0x4c+8
+		}
+		data := make([]byte, length)
+		binary.LittleEndian.PutUint32(data, uint32(length))
+		binary.LittleEndian.PutUint16(data[0x04:], majorVersion)
+		binary.LittleEndian.PutUint64(data[0x08:], reference)
+		binary.LittleEndian.PutUint64(data[offsetUSN:], uint64(usn))
+		binary.LittleEndian.PutUint64(data[offsetUSN+8:], toFiletime(timestamp))
+		return data
+	}
+	buffer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buffer, 4096)
+	buffer = append(buffer, record(2, 0x0005000000001234, 1024)...) // sequence number 5, record 0x1234
+	buffer = append(buffer, record(4, 0x99, 2048)...)
+	buffer = append(buffer, record(3, 0x42, 3072)...)
+	buffer = append(buffer, 0x50, 0x00) // cut short
+
+	nextUSN, records := parseUSNRecords(buffer)
+	want := []usnRecord{
+		{recordNumber: 0x1234, usn: 1024, timestamp: timestamp},
+		{recordNumber: 0x42, usn: 3072, timestamp: timestamp},
+	}
+	if nextUSN != 4096 {
+		t.Errorf("parseUSNRecords() nextUSN = %d, want 4096", nextUSN)
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("parseUSNRecords() records = %+v, want %+v", records, want)
+	}
+}
+
+func Test_newChangeFilter(t *testing.T) {
+	defer func(original func(*VolumeHandler, usnJournal, int64) ([]usnRecord, error)) { readUSNJournal = original }(readUSNJournal)
+	lastWeek := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	journalRecords := []usnRecord{
+		{recordNumber: 40, usn: 100, timestamp: lastWeek.Add(-time.Hour)},
+		{recordNumber: 41, usn: 200, timestamp: lastWeek.Add(time.Hour)},
+		{recordNumber: 42, usn: 300, timestamp: lastWeek.Add(2 * time.Hour)},
+	}
+	readUSNJournal = func(volumeHandler *VolumeHandler, journal usnJournal, startUSN int64) (records []usnRecord, err error) {
+		if journal.UsnJournalID == 0xbad {
+			err = errors.New("access denied")
+			return
+		}
+		for _, record := range journalRecords {
+			if record.usn >= startUSN {
+				records = append(records, record)
+			}
+		}
+		return
+	}
+	journal := usnJournal{UsnJournalID: 7, FirstUsn: 100, NextUsn: 400, LowestValidUsn: 100}
+	tests := []struct {
+		name         string
+		journal      usnJournal
+		journalErr   error
+		options      CollectOptions
+		wantChanged  map[uint32]bool
+		wantFallback string
+	}{
+		{
+			name:        "since a mark",
+			journal:     journal,
+			options:     CollectOptions{ChangedSince: map[string]USNJournalMark{"C": {JournalID: 7, NextUSN: 200}}},
+			wantChanged: map[uint32]bool{41: true, 42: true},
+		},
+		{
+			name:        "after a time",
+			journal:     journal,
+			options:     CollectOptions{ChangedAfter: lastWeek},
+			wantChanged: map[uint32]bool{41: true, 42: true},
+		},
+		{
+			name:         "journal doesn't go back far enough",
+			journal:      journal,
+			options:      CollectOptions{ChangedAfter: lastWeek.Add(-24 * time.Hour)},
+			wantFallback: "the change journal doesn't go back to 2020-02-29T00:00:00Z",
+		},
+		{
+			name:         "no mark for the volume",
+			journal:      journal,
+			options:      CollectOptions{ChangedSince: map[string]USNJournalMark{"d": {JournalID: 7, NextUSN: 200}}},
+			wantFallback: "there's no mark for the volume",
+		},
+		{
+			name:         "recreated journal",
+			journal:      journal,
+			options:      CollectOptions{ChangedSince: map[string]USNJournalMark{"c": {JournalID: 6, NextUSN: 200}}},
+			wantFallback: "the change journal was recreated since the mark",
+		},
+		{
+			name:         "trimmed journal",
+			journal:      usnJournal{UsnJournalID: 7, FirstUsn: 250, NextUsn: 400, LowestValidUsn: 250},
+			options:      CollectOptions{ChangedSince: map[string]USNJournalMark{"c": {JournalID: 7, NextUSN: 200}}},
+			wantFallback: "the change journal has been trimmed past the mark",
+		},
+		{
+			name:         "no journal",
+			journalErr:   errors.New("journal not active"),
+			options:      CollectOptions{ChangedAfter: lastWeek},
+			wantFallback: "the change journal couldn't be queried: journal not active",
+		},
+		{
+			name:         "unreadable journal",
+			journal:      usnJournal{UsnJournalID: 0xbad},
+			options:      CollectOptions{ChangedAfter: lastWeek},
+			wantFallback: "the change journal couldn't be read: access denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, fallback := newChangeFilter(&VolumeHandler{VolumeLetter: "c"}, tt.journal, tt.journalErr, tt.options)
+			if fallback != tt.wantFallback {
+				t.Errorf("newChangeFilter() fallback = %q, want %q", fallback, tt.wantFallback)
+			}
+			if (filter == nil) != (tt.wantChanged == nil) || (filter != nil && !reflect.DeepEqual(filter.changed, tt.wantChanged)) {
+				t.Errorf("newChangeFilter() = %+v, want the changed records %v", filter, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func Test_changeFilter_filterFiles(t *testing.T) {
+	files := foundFiles{
+		{fullPath: `c:\changed`, metadata: recordMetadata{recordNumber: 41}},
+		{fullPath: `c:\unchanged`, metadata: recordMetadata{recordNumber: 43}},
+	}
+	filter := &changeFilter{changed: map[uint32]bool{41: true}}
+	changed, numberOfUnchanged := filter.filterFiles(files)
+	if !reflect.DeepEqual(changed, files[:1]) || numberOfUnchanged != 1 {
+		t.Errorf("changeFilter.filterFiles() = %+v, %d, want only the changed file", changed, numberOfUnchanged)
+	}
+
+	// Without a filter every file is collected
+	var noFilter *changeFilter
+	if changed, numberOfUnchanged = noFilter.filterFiles(files); !reflect.DeepEqual(changed, files) || numberOfUnchanged != 0 {
+		t.Errorf("changeFilter.filterFiles() on a nil filter = %+v, %d, want every file", changed, numberOfUnchanged)
+	}
+}
+
+func TestCollectionReport_USNJournalMarks(t *testing.T) {
+	report := CollectionReport{Volumes: []VolumeReport{
+		{Letter: "c", USNJournal: &USNJournalMark{JournalID: 7, NextUSN: 400}},
+		{Letter: "d"},
+	}}
+	want := map[string]USNJournalMark{"c": {JournalID: 7, NextUSN: 400}}
+	if got := report.USNJournalMarks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CollectionReport.USNJournalMarks() = %+v, want %+v", got, want)
+	}
+}
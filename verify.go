@@ -0,0 +1,233 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+const verificationFileName = "verification.json"
+
+// How a collected file compared with reading it again, as listed in verification.json and the report.
+const (
+	VerificationMatched         = "matched"
+	VerificationSizeMismatch    = "size_mismatch" // usually a copy cut short by data runs that were parsed wrong
+	VerificationContentMismatch = "content_mismatch"
+	VerificationFailed          = "failed" // the file couldn't be read again
+)
+
+// Verification is what the verification pass found, written into the output as verification.json.
+type Verification struct {
+	FilesVerified int                `json:"files_verified"`
+	Mismatches    int                `json:"mismatches"`
+	Files         []FileVerification `json:"files"` // the files that didn't match or couldn't be read again
+}
+
+// FileVerification is how a collected file compared with reading it again.
+type FileVerification struct {
+	Path         string `json:"path"`
+	Volume       string `json:"volume"`
+	Method       string `json:"method"`        // how the file was collected
+	RereadMethod string `json:"reread_method"` // how it was read again, the other way when it can be
+	Size         int64  `json:"size"`
+	RereadSize   int64  `json:"reread_size"`
+	SHA256       string `json:"sha256"`
+	RereadSHA256 string `json:"reread_sha256,omitempty"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// verifiedFile is a collected file that's read again once the result writer has written it.
+type verifiedFile struct {
+	file         foundFile
+	outputPath   string
+	volumeLetter string
+	method       string
+	sha256       string
+	size         int64
+	written      bool // the result writer read it to the end
+}
+
+// fileVerifier hashes the files read from volumes as the result writer reads them, and reads them again once they are
+// written to compare. Like the deduplicator it is nil when verification wasn't asked for.
+type fileVerifier struct {
+	handler handler
+	mutex   sync.Mutex
+	files   []*verifiedFile
+}
+
+func newFileVerifier(enabled bool, handler handler) *fileVerifier {
+	if !enabled {
+		return nil
+	}
+	return &fileVerifier{handler: handler}
+}
+
+// track wraps the reader of a file read raw or through the API so it's hashed as the result writer reads it. Exported
+// hives are left alone since saving a hive twice doesn't give the same bytes.
+func (verifier *fileVerifier) track(reader fileReader, file foundFile, volumeLetter string) fileReader {
+	if verifier == nil || (reader.method != readMethodRaw && reader.method != readMethodAPI) {
+		return reader
+	}
+	verified := &verifiedFile{file: file, outputPath: reader.fullPath, volumeLetter: volumeLetter, method: reader.method}
+	verifier.mutex.Lock()
+	verifier.files = append(verifier.files, verified)
+	verifier.mutex.Unlock()
+	reader.reader = &hashingReader{reader: reader.reader, hash: sha256.New(), done: func(sum string, size int64) {
+		verifier.mutex.Lock()
+		defer verifier.mutex.Unlock()
+		verified.sha256, verified.size, verified.written = sum, size, true
+	}}
+	return reader
+}
+
+// reader reads every written file again when it's first read, which is once the result writer has written everything
+// sent before it, and gives back verification.json.
+func (verifier *fileVerifier) reader(ctx context.Context, collector *Collector) io.Reader {
+	return &lazyReader{open: func() io.Reader {
+		verification := verifier.verify(ctx, collector)
+		collector.report.setMismatches(verification.Mismatches)
+		data, _ := json.MarshalIndent(verification, "", "  ")
+		return bytes.NewReader(data)
+	}}
+}
+
+// verify reads the written files again, a volume at a time, and compares them with what was written.
+func (verifier *fileVerifier) verify(ctx context.Context, collector *Collector) (verification Verification) {
+	verifier.mutex.Lock()
+	files := append([]*verifiedFile(nil), verifier.files...)
+	verifier.mutex.Unlock()
+
+	verification.Files = make([]FileVerification, 0)
+	volumes := make(map[string]*VolumeHandler)
+	defer func() {
+		for _, volumeHandler := range volumes {
+			if volumeHandler != nil {
+				volumeHandler.Handle.Close()
+			}
+		}
+	}()
+	for _, verified := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		if !verified.written {
+			continue
+		}
+		volumeHandler, found := volumes[verified.volumeLetter]
+		if !found {
+			handler, err := GetVolumeHandler(verified.volumeLetter, verifier.handler)
+			if err != nil {
+				collector.logger().Errorf("Failed to open volume %s again to verify its files: %v", verified.volumeLetter, err)
+			} else {
+				handler.Logger = collector.Options.Logger
+				volumeHandler = &handler
+			}
+			volumes[verified.volumeLetter] = volumeHandler
+		}
+
+		result := verifier.verifyFile(ctx, volumeHandler, verified, collector)
+		verification.FilesVerified++
+		collector.report.setVerification(verified.outputPath, verified.volumeLetter, result.Status)
+		if result.Status == VerificationMatched {
+			continue
+		}
+		collector.logger().Warnf("Verifying '%s' found %s: %s", result.Path, result.Status, result.Error)
+		if result.Status != VerificationFailed {
+			verification.Mismatches++
+		}
+		verification.Files = append(verification.Files, result)
+	}
+	return
+}
+
+// verifyFile reads a file again and compares it with what was written.
+func (verifier *fileVerifier) verifyFile(ctx context.Context, volumeHandler *VolumeHandler, verified *verifiedFile, collector *Collector) (result FileVerification) {
+	result = FileVerification{
+		Path:   verified.outputPath,
+		Volume: verified.volumeLetter,
+		Method: verified.method,
+		Size:   verified.size,
+		SHA256: verified.sha256,
+		Status: VerificationFailed,
+	}
+	reader, method, err := rereadFile(volumeHandler, verified, collector.Options)
+	result.RereadMethod = method
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, collector.limitReader(ctx, reader))
+	if err != nil {
+		result.Error = fmt.Sprintf("reading it again failed: %v", err)
+		return
+	}
+	result.RereadSize, result.RereadSHA256 = size, hex.EncodeToString(hash.Sum(nil))
+	switch {
+	case result.RereadSize != result.Size:
+		result.Status = VerificationSizeMismatch
+		result.Error = fmt.Sprintf("%d bytes were collected but %d were read again", result.Size, result.RereadSize)
+	case result.RereadSHA256 != result.SHA256:
+		result.Status = VerificationContentMismatch
+		result.Error = "the content differs"
+	default:
+		result.Status = VerificationMatched
+	}
+	return
+}
+
+// rereadFile opens a collected file again, the other way from how it was collected when its read policy allows and
+// that works, else the same way again, which still catches reads that don't give the same bytes twice.
+func rereadFile(volumeHandler *VolumeHandler, verified *verifiedFile, options CollectOptions) (reader io.Reader, method string, err error) {
+	policy := options.readPolicyFor(verified.file)
+	canReadRaw := volumeHandler != nil && policy != ReadAPIOnly
+	canReadAPI := policy != ReadRawOnly && !verified.file.deleted
+	switch {
+	case verified.method == readMethodRaw && canReadAPI:
+		if reader, err = apiFileReader(verified.file); err == nil {
+			return reader, readMethodAPI, nil
+		}
+	case verified.method == readMethodAPI && canReadRaw:
+		return rawFileReader(volumeHandler, verified.file), readMethodRaw, nil
+	}
+	if verified.method == readMethodAPI {
+		reader, err = apiFileReader(verified.file)
+		return reader, readMethodAPI, err
+	}
+	if volumeHandler == nil {
+		return nil, readMethodRaw, errors.New("the volume couldn't be opened again")
+	}
+	return rawFileReader(volumeHandler, verified.file), readMethodRaw, nil
+}
+
+// hashingReader hashes what's read through it and hands the hash and size to done once it's read to the end.
+type hashingReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+	done   func(sum string, size int64)
+}
+
+func (hashingReader *hashingReader) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = hashingReader.reader.Read(byteSliceToPopulate)
+	hashingReader.hash.Write(byteSliceToPopulate[:numberOfBytesRead])
+	hashingReader.size += int64(numberOfBytesRead)
+	if err == io.EOF {
+		hashingReader.done(hex.EncodeToString(hashingReader.hash.Sum(nil)), hashingReader.size)
+	}
+	return
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_fileVerifier_reader(t *testing.T) {
+	defer func(original func(path string) (*os.File, error)) { openWithBackupSemantics = original }(openWithBackupSemantics)
+	tests := []struct {
+		name           string
+		method         string
+		collected      string
+		residentData   string
+		apiData        string
+		apiErr         error
+		policy         ReadPolicy
+		wantReread     string
+		wantStatus     string
+		wantMismatches int
+	}{
+		{name: "raw matches the api", method: readMethodRaw, collected: "regf", apiData: "regf", wantReread: readMethodAPI, wantStatus: VerificationMatched},
+		{name: "raw copy cut short", method: readMethodRaw, collected: "re", apiData: "regf", wantReread: readMethodAPI, wantStatus: VerificationSizeMismatch, wantMismatches: 1},
+		{name: "raw copy differs", method: readMethodRaw, collected: "regx", apiData: "regf", wantReread: readMethodAPI, wantStatus: VerificationContentMismatch, wantMismatches: 1},
+		{name: "locked file read raw again", method: readMethodRaw, collected: "regf", residentData: "regf", apiErr: errors.New("sharing violation"), wantReread: readMethodRaw, wantStatus: VerificationMatched},
+		{name: "api matches raw", method: readMethodAPI, collected: "regf", residentData: "regf", wantReread: readMethodRaw, wantStatus: VerificationMatched},
+		{name: "raw only", method: readMethodRaw, collected: "regf", residentData: "regf", apiData: "other", policy: ReadRawOnly, wantReread: readMethodRaw, wantStatus: VerificationMatched},
+		{name: "api only and the api fails", method: readMethodAPI, collected: "regf", apiErr: errors.New("access denied"), policy: ReadAPIOnly, wantReread: readMethodAPI, wantStatus: VerificationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiFile, err := ioutil.TempFile("", "verify")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(apiFile.Name())
+			apiFile.WriteString(tt.apiData)
+			apiFile.Close()
+			openWithBackupSemantics = func(path string) (*os.File, error) {
+				if tt.apiErr != nil {
+					return nil, tt.apiErr
+				}
+				return os.Open(apiFile.Name())
+			}
+
+			collector := &Collector{Options: CollectOptions{ReadPolicy: tt.policy}, report: newReportBuilder()}
+			verifier := newFileVerifier(true, dummyHandler{filePath: `test\testdata\dummyntfs`})
+			file := foundFile{fullPath: `c:\windows\system32\config\system`, resident: true, residentData: []byte(tt.residentData)}
+			tracked := collector.report.trackFile(verifier.track(fileReader{fullPath: file.fullPath, method: tt.method, reader: strings.NewReader(tt.collected)}, file, "c"), "c")
+			ioutil.ReadAll(tracked.reader)
+
+			var got Verification
+			if err = json.NewDecoder(verifier.reader(context.Background(), collector)).Decode(&got); err != nil {
+				t.Fatalf("decoding %s error = %v", verificationFileName, err)
+			}
+			report := collector.report.snapshot()
+			if got.FilesVerified != 1 || got.Mismatches != tt.wantMismatches || report.Mismatches != tt.wantMismatches {
+				t.Errorf("fileVerifier.reader() verified %d with %d mismatches and the report %d, want 1 with %d", got.FilesVerified, got.Mismatches, report.Mismatches, tt.wantMismatches)
+			}
+			if report.Files[0].Verified != tt.wantStatus {
+				t.Errorf("the report has the file %q, want %q", report.Files[0].Verified, tt.wantStatus)
+			}
+			if tt.wantStatus != VerificationMatched && (len(got.Files) != 1 || got.Files[0].Status != tt.wantStatus || got.Files[0].RereadMethod != tt.wantReread) {
+				t.Errorf("fileVerifier.reader() listed %+v, want %s read again %s", got.Files, tt.wantStatus, tt.wantReread)
+			}
+		})
+	}
+}
+
+func Test_fileVerifier_track_exportedHive(t *testing.T) {
+	verifier := newFileVerifier(true, nil)
+	reader := strings.NewReader("regf")
+	tracked := verifier.track(fileReader{fullPath: `c:\windows\system32\config\system`, method: readMethodHiveExport, reader: reader}, foundFile{}, "c")
+	if tracked.reader != reader || len(verifier.files) != 0 {
+		t.Errorf("fileVerifier.track() tracked an exported hive")
+	}
+}
@@ -0,0 +1,480 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	kernel32                       = windows.NewLazySystemDLL("kernel32.dll")
+	procQueryFullProcessImageNameW = kernel32.NewProc("QueryFullProcessImageNameW")
+	ntdll                          = windows.NewLazySystemDLL("ntdll.dll")
+	procNtQueryInformationProcess  = ntdll.NewProc("NtQueryInformationProcess")
+	iphlpapi                       = windows.NewLazySystemDLL("iphlpapi.dll")
+	procGetExtendedTcpTable        = iphlpapi.NewProc("GetExtendedTcpTable")
+	procGetExtendedUdpTable        = iphlpapi.NewProc("GetExtendedUdpTable")
+	wtsapi32                       = windows.NewLazySystemDLL("wtsapi32.dll")
+	procWTSQuerySessionInformation = wtsapi32.NewProc("WTSQuerySessionInformationW")
+)
+
+// VolatileProcess is a process that was running when the collection started.
+type VolatileProcess struct {
+	PID         uint32 `json:"pid"`
+	ParentPID   uint32 `json:"parent_pid"`
+	Name        string `json:"name"`
+	Path        string `json:"path,omitempty"`
+	CommandLine string `json:"command_line,omitempty"`
+	SHA256      string `json:"sha256,omitempty"` // of the executable on disk
+	Error       string `json:"error,omitempty"`  // why the path, command line or hash are missing
+}
+
+// NetworkConnection is a TCP connection or listener, or a UDP endpoint.
+type NetworkConnection struct {
+	Protocol      string `json:"protocol"` // tcp, tcp6, udp or udp6
+	LocalAddress  string `json:"local_address"`
+	LocalPort     uint16 `json:"local_port"`
+	RemoteAddress string `json:"remote_address,omitempty"`
+	RemotePort    uint16 `json:"remote_port,omitempty"`
+	State         string `json:"state,omitempty"` // the TCP state, LISTEN for listening ports
+	PID           uint32 `json:"pid"`
+}
+
+// LoggedOnUser is a Remote Desktop Services session, which includes the console.
+type LoggedOnUser struct {
+	SessionID     uint32 `json:"session_id"`
+	WindowStation string `json:"window_station"`
+	State         string `json:"state"`
+	User          string `json:"user,omitempty"`
+	Domain        string `json:"domain,omitempty"`
+}
+
+// VolatileService is a service or driver known to the service control manager.
+type VolatileService struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Type        uint32 `json:"type"`
+	State       string `json:"state"`
+	StartType   string `json:"start_type,omitempty"`
+	PID         uint32 `json:"pid,omitempty"`
+	BinaryPath  string `json:"binary_path,omitempty"`
+	Account     string `json:"account,omitempty"`
+}
+
+// VolatileAcquirers returns Acquirers that capture the live state of the host into the output as JSON, under volatile/:
+// the running processes with their command lines and the SHA256 of their executables, the network connections and
+// listening ports with the processes that own them, the logged on users, and the services and drivers. With a minimal
+// footprint the executables aren't hashed, since that opens them through the API.
+func VolatileAcquirers() []Acquirer {
+	return []Acquirer{
+		&volatileAcquirer{
+			name:         "volatile/processes.json",
+			gather:       func(context.Context, Logger) (interface{}, error) { return listProcesses(true) },
+			withoutFiles: func(context.Context, Logger) (interface{}, error) { return listProcesses(false) },
+		},
+		&volatileAcquirer{name: "volatile/network_connections.json", gather: func(context.Context, Logger) (interface{}, error) { return listNetworkConnections() }},
+		&volatileAcquirer{name: "volatile/logged_on_users.json", gather: func(context.Context, Logger) (interface{}, error) { return listLoggedOnUsers() }},
+		&volatileAcquirer{name: "volatile/services.json", gather: func(context.Context, Logger) (interface{}, error) { return listServices(windows.SERVICE_WIN32) }},
+		&volatileAcquirer{name: "volatile/drivers.json", gather: func(context.Context, Logger) (interface{}, error) { return listServices(windows.SERVICE_DRIVER) }},
+	}
+}
+
+// volatileAcquirer writes what gather returns as JSON. withoutFiles gathers the same without opening any files, for a
+// minimal footprint, and is nil when gather doesn't open any.
+type volatileAcquirer struct {
+	name         string
+	gather       func(ctx context.Context, logger Logger) (interface{}, error)
+	withoutFiles func(ctx context.Context, logger Logger) (interface{}, error)
+}
+
+func (acquirer *volatileAcquirer) Name() string {
+	return acquirer.name
+}
+
+func (acquirer *volatileAcquirer) Acquire(ctx context.Context, logger Logger) (reader io.ReadCloser, size int64, err error) {
+	value, err := acquirer.gather(ctx, logger)
+	if err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("failed to serialize %s: %w", acquirer.name, err)
+		return
+	}
+	reader = ioutil.NopCloser(bytes.NewReader(data))
+	size = int64(len(data))
+	return
+}
+
+// listProcesses snapshots the running processes. Each executable is only hashed once however many processes run it,
+// and none are when hashImages is false.
+func listProcesses(hashImages bool) (processes []VolatileProcess, err error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		err = fmt.Errorf("failed to snapshot the processes: %w", err)
+		return
+	}
+	defer windows.CloseHandle(snapshot)
+	entry := windows.ProcessEntry32{Size: uint32(unsafe.Sizeof(windows.ProcessEntry32{}))}
+	var hashes map[string]string
+	if hashImages {
+		hashes = make(map[string]string)
+	}
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		process := VolatileProcess{
+			PID:       entry.ProcessID,
+			ParentPID: entry.ParentProcessID,
+			Name:      windows.UTF16ToString(entry.ExeFile[:]),
+		}
+		// The idle and system processes have no executable or command line
+		if process.PID > 4 {
+			inspectProcess(&process, hashes)
+		}
+		processes = append(processes, process)
+	}
+	if !errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		err = fmt.Errorf("failed to walk the process snapshot: %w", err)
+		return
+	}
+	err = nil
+	return
+}
+
+// inspectProcess fills in the process's path, command line and hash, recording what couldn't be read. The executable
+// isn't hashed when hashes is nil.
+func inspectProcess(process *VolatileProcess, hashes map[string]string) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, process.PID)
+	if err != nil {
+		process.Error = fmt.Sprintf("failed to open the process: %v", err)
+		return
+	}
+	defer windows.CloseHandle(handle)
+	var problems []string
+	process.Path, err = processImagePath(handle)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	process.CommandLine, err = processCommandLine(handle)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if process.Path != "" && hashes != nil {
+		hash, found := hashes[process.Path]
+		if !found {
+			hash, err = hashFile(process.Path)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("failed to hash the executable: %v", err))
+			}
+			hashes[process.Path] = hash
+		}
+		process.SHA256 = hash
+	}
+	if len(problems) != 0 {
+		process.Error = strings.Join(problems, "; ")
+	}
+}
+
+func processImagePath(handle windows.Handle) (path string, err error) {
+	buffer := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buffer))
+	result, _, callErr := procQueryFullProcessImageNameW.Call(uintptr(handle), 0, uintptr(unsafe.Pointer(&buffer[0])), uintptr(unsafe.Pointer(&size)))
+	if result == 0 {
+		err = fmt.Errorf("failed to get the image path: %w", callErr)
+		return
+	}
+	path = string(utf16.Decode(buffer[:size]))
+	return
+}
+
+// processCommandLine reads the command line with ProcessCommandLineInformation, which only needs
+// PROCESS_QUERY_LIMITED_INFORMATION but is only there from Windows 8.1.
+func processCommandLine(handle windows.Handle) (commandLine string, err error) {
+	const processCommandLineInformation = 60
+	type unicodeString struct {
+		Length        uint16
+		MaximumLength uint16
+		Buffer        *uint16
+	}
+	var returnLength uint32
+	buffer := make([]byte, 4096)
+	for {
+		status, _, _ := procNtQueryInformationProcess.Call(uintptr(handle), processCommandLineInformation, uintptr(unsafe.Pointer(&buffer[0])), uintptr(len(buffer)), uintptr(unsafe.Pointer(&returnLength)))
+		const statusInfoLengthMismatch = 0xc0000004
+		if status == statusInfoLengthMismatch && int(returnLength) > len(buffer) {
+			buffer = make([]byte, returnLength)
+			continue
+		}
+		if status != 0 {
+			err = fmt.Errorf("failed to get the command line: NTSTATUS %#x", status)
+			return
+		}
+		break
+	}
+	commandLineString := (*unicodeString)(unsafe.Pointer(&buffer[0]))
+	if commandLineString.Buffer == nil || commandLineString.Length == 0 {
+		return
+	}
+	characters := (*[1 << 15]uint16)(unsafe.Pointer(commandLineString.Buffer))[: commandLineString.Length/2 : commandLineString.Length/2]
+	commandLine = string(utf16.Decode(characters))
+	return
+}
+
+// The address families and table classes of GetExtendedTcpTable and GetExtendedUdpTable.
+const (
+	afInet            = 2
+	afInet6           = 23
+	tcpTableOwnerPID  = 5
+	udpTableOwnerPID  = 1
+	errorInsufficient = 122
+)
+
+// listNetworkConnections returns the TCP connections and listeners and the UDP endpoints, over IPv4 and IPv6.
+func listNetworkConnections() (connections []NetworkConnection, err error) {
+	tables := []struct {
+		proc          *windows.LazyProc
+		addressFamily uintptr
+		tableClass    uintptr
+		protocol      string
+	}{
+		{procGetExtendedTcpTable, afInet, tcpTableOwnerPID, "tcp"},
+		{procGetExtendedTcpTable, afInet6, tcpTableOwnerPID, "tcp6"},
+		{procGetExtendedUdpTable, afInet, udpTableOwnerPID, "udp"},
+		{procGetExtendedUdpTable, afInet6, udpTableOwnerPID, "udp6"},
+	}
+	for _, table := range tables {
+		var data []byte
+		data, err = extendedTable(table.proc, table.addressFamily, table.tableClass)
+		if err != nil {
+			err = fmt.Errorf("failed to get the %s table: %w", table.protocol, err)
+			return
+		}
+		connections = append(connections, parseConnectionTable(table.protocol, data)...)
+	}
+	return
+}
+
+// extendedTable calls GetExtendedTcpTable or GetExtendedUdpTable, growing the buffer until the table fits.
+func extendedTable(proc *windows.LazyProc, addressFamily uintptr, tableClass uintptr) (data []byte, err error) {
+	size := uint32(16 * 1024)
+	for {
+		data = make([]byte, size)
+		result, _, _ := proc.Call(uintptr(unsafe.Pointer(&data[0])), uintptr(unsafe.Pointer(&size)), 0, addressFamily, tableClass, 0)
+		if result == errorInsufficient {
+			continue
+		}
+		if result != 0 {
+			err = windows.Errno(result)
+			return
+		}
+		data = data[:size]
+		return
+	}
+}
+
+var tcpStates = map[uint32]string{
+	1:  "CLOSED",
+	2:  "LISTEN",
+	3:  "SYN_SENT",
+	4:  "SYN_RCVD",
+	5:  "ESTABLISHED",
+	6:  "FIN_WAIT1",
+	7:  "FIN_WAIT2",
+	8:  "CLOSE_WAIT",
+	9:  "CLOSING",
+	10: "LAST_ACK",
+	11: "TIME_WAIT",
+	12: "DELETE_TCB",
+}
+
+// parseConnectionTable parses a MIB_TCPTABLE_OWNER_PID, MIB_TCP6TABLE_OWNER_PID, MIB_UDPTABLE_OWNER_PID or
+// MIB_UDP6TABLE_OWNER_PID. Addresses and ports are in network byte order.
+func parseConnectionTable(protocol string, data []byte) (connections []NetworkConnection) {
+	if len(data) < 4 {
+		return
+	}
+	numberOfEntries := int(binary.LittleEndian.Uint32(data))
+	port := func(field []byte) uint16 { return binary.BigEndian.Uint16(field) }
+	rowSize := map[string]int{"tcp": 24, "tcp6": 56, "udp": 12, "udp6": 28}[protocol]
+	for index := 0; index < numberOfEntries; index++ {
+		offset := 4 + index*rowSize
+		if offset+rowSize > len(data) {
+			break
+		}
+		row := data[offset : offset+rowSize]
+		connection := NetworkConnection{Protocol: protocol}
+		switch protocol {
+		case "tcp":
+			connection.State = tcpStates[binary.LittleEndian.Uint32(row)]
+			connection.LocalAddress = net.IP(row[4:8]).String()
+			connection.LocalPort = port(row[8:])
+			connection.RemoteAddress = net.IP(row[12:16]).String()
+			connection.RemotePort = port(row[16:])
+			connection.PID = binary.LittleEndian.Uint32(row[20:])
+		case "tcp6":
+			connection.LocalAddress = net.IP(row[0:16]).String()
+			connection.LocalPort = port(row[20:])
+			connection.RemoteAddress = net.IP(row[24:40]).String()
+			connection.RemotePort = port(row[44:])
+			connection.State = tcpStates[binary.LittleEndian.Uint32(row[48:])]
+			connection.PID = binary.LittleEndian.Uint32(row[52:])
+		case "udp":
+			connection.LocalAddress = net.IP(row[0:4]).String()
+			connection.LocalPort = port(row[4:])
+			connection.PID = binary.LittleEndian.Uint32(row[8:])
+		case "udp6":
+			connection.LocalAddress = net.IP(row[0:16]).String()
+			connection.LocalPort = port(row[20:])
+			connection.PID = binary.LittleEndian.Uint32(row[24:])
+		}
+		if connection.State == "LISTEN" {
+			connection.RemoteAddress, connection.RemotePort = "", 0
+		}
+		connections = append(connections, connection)
+	}
+	return
+}
+
+var sessionStates = []string{"active", "connected", "connect_query", "shadow", "disconnected", "idle", "listen", "reset", "down", "init"}
+
+// listLoggedOnUsers returns the Remote Desktop Services sessions, with who is logged on to them.
+func listLoggedOnUsers() (users []LoggedOnUser, err error) {
+	const (
+		wtsUserName   = 5
+		wtsDomainName = 7
+	)
+	var sessions *windows.WTS_SESSION_INFO
+	var count uint32
+	err = windows.WTSEnumerateSessions(0, 0, 1, &sessions, &count)
+	if err != nil {
+		err = fmt.Errorf("failed to enumerate the sessions: %w", err)
+		return
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(sessions)))
+	for _, session := range (*[1 << 16]windows.WTS_SESSION_INFO)(unsafe.Pointer(sessions))[:count:count] {
+		user := LoggedOnUser{
+			SessionID:     session.SessionID,
+			WindowStation: utf16PointerToString(session.WindowStationName),
+			State:         fmt.Sprint(session.State),
+		}
+		if int(session.State) < len(sessionStates) {
+			user.State = sessionStates[session.State]
+		}
+		user.User = sessionInformation(session.SessionID, wtsUserName)
+		user.Domain = sessionInformation(session.SessionID, wtsDomainName)
+		users = append(users, user)
+	}
+	return
+}
+
+// sessionInformation returns a string about a session from WTSQuerySessionInformation, empty if it can't be read.
+func sessionInformation(sessionID uint32, infoClass uintptr) (value string) {
+	var buffer *uint16
+	var size uint32
+	result, _, _ := procWTSQuerySessionInformation.Call(0, uintptr(sessionID), infoClass, uintptr(unsafe.Pointer(&buffer)), uintptr(unsafe.Pointer(&size)))
+	if result == 0 || buffer == nil {
+		return
+	}
+	value = utf16PointerToString(buffer)
+	windows.WTSFreeMemory(uintptr(unsafe.Pointer(buffer)))
+	return
+}
+
+var (
+	serviceStates     = []string{"", "stopped", "start_pending", "stop_pending", "running", "continue_pending", "pause_pending", "paused"}
+	serviceStartTypes = []string{"boot", "system", "auto", "demand", "disabled"}
+)
+
+// listServices returns the services or drivers of the given service type, with their configuration.
+func listServices(serviceType uint32) (services []VolatileService, err error) {
+	manager, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_ENUMERATE_SERVICE)
+	if err != nil {
+		err = fmt.Errorf("failed to open the service control manager: %w", err)
+		return
+	}
+	defer windows.CloseServiceHandle(manager)
+	buffer := make([]byte, 64*1024)
+	var bytesNeeded, servicesReturned, resumeHandle uint32
+	for {
+		err = windows.EnumServicesStatusEx(manager, windows.SC_ENUM_PROCESS_INFO, serviceType, windows.SERVICE_STATE_ALL, &buffer[0], uint32(len(buffer)), &bytesNeeded, &servicesReturned, &resumeHandle, nil)
+		if err != nil && !errors.Is(err, windows.ERROR_MORE_DATA) {
+			err = fmt.Errorf("failed to enumerate the services: %w", err)
+			return
+		}
+		moreData := err != nil
+		err = nil
+		if servicesReturned != 0 {
+			entries := (*[1 << 16]windows.ENUM_SERVICE_STATUS_PROCESS)(unsafe.Pointer(&buffer[0]))[:servicesReturned:servicesReturned]
+			for _, entry := range entries {
+				service := VolatileService{
+					Name:        utf16PointerToString(entry.ServiceName),
+					DisplayName: utf16PointerToString(entry.DisplayName),
+					Type:        entry.ServiceStatusProcess.ServiceType,
+					PID:         entry.ServiceStatusProcess.ProcessId,
+				}
+				if state := entry.ServiceStatusProcess.CurrentState; int(state) < len(serviceStates) {
+					service.State = serviceStates[state]
+				}
+				serviceConfig(manager, entry.ServiceName, &service)
+				services = append(services, service)
+			}
+		}
+		if !moreData {
+			return
+		}
+		if int(bytesNeeded) > len(buffer) {
+			buffer = make([]byte, bytesNeeded)
+		}
+	}
+}
+
+// serviceConfig fills in the service's start type, binary path and account, leaving them empty if they can't be read.
+func serviceConfig(manager windows.Handle, name *uint16, service *VolatileService) {
+	handle, err := windows.OpenService(manager, name, windows.SERVICE_QUERY_CONFIG)
+	if err != nil {
+		return
+	}
+	defer windows.CloseServiceHandle(handle)
+	var bytesNeeded uint32
+	_ = windows.QueryServiceConfig(handle, nil, 0, &bytesNeeded)
+	if bytesNeeded == 0 {
+		return
+	}
+	buffer := make([]byte, bytesNeeded)
+	config := (*windows.QUERY_SERVICE_CONFIG)(unsafe.Pointer(&buffer[0]))
+	if err = windows.QueryServiceConfig(handle, config, bytesNeeded, &bytesNeeded); err != nil {
+		return
+	}
+	if int(config.StartType) < len(serviceStartTypes) {
+		service.StartType = serviceStartTypes[config.StartType]
+	}
+	service.BinaryPath = utf16PointerToString(config.BinaryPathName)
+	service.Account = utf16PointerToString(config.ServiceStartName)
+}
+
+// utf16PointerToString converts a null terminated UTF-16 string returned by the API.
+func utf16PointerToString(pointer *uint16) string {
+	if pointer == nil {
+		return ""
+	}
+	var characters []uint16
+	for offset := uintptr(0); ; offset += 2 {
+		character := *(*uint16)(unsafe.Pointer(uintptr(unsafe.Pointer(pointer)) + offset))
+		if character == 0 {
+			break
+		}
+		characters = append(characters, character)
+	}
+	return string(utf16.Decode(characters))
+}
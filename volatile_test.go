@@ -0,0 +1,127 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+func Test_parseConnectionTable(t *testing.T) {
+	table := func(rows ...[]byte) []byte {
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, uint32(len(rows)))
+		for _, row := range rows {
+			data = append(data, row...)
+		}
+		return data
+	}
+	port := func(value uint16) []byte {
+		field := make([]byte, 4)
+		binary.BigEndian.PutUint16(field, value)
+		return field
+	}
+	dword := func(value uint32) []byte {
+		field := make([]byte, 4)
+		binary.LittleEndian.PutUint32(field, value)
+		return field
+	}
+	join := func(fields ...[]byte) (row []byte) {
+		for _, field := range fields {
+			row = append(row, field...)
+		}
+		return
+	}
+	tests := []struct {
+		name     string
+		protocol string
+		data     []byte
+		want     []NetworkConnection
+	}{
+		{
+			name:     "tcp",
+			protocol: "tcp",
+			data: table(
+				join(dword(5), net.IPv4(10, 0, 0, 5).To4(), port(49700), net.IPv4(93, 184, 216, 34).To4(), port(443), dword(1234)),
+				join(dword(2), net.IPv4(0, 0, 0, 0).To4(), port(445), net.IPv4(0, 0, 0, 0).To4(), port(0), dword(4)),
+			),
+			want: []NetworkConnection{
+				{Protocol: "tcp", LocalAddress: "10.0.0.5", LocalPort: 49700, RemoteAddress: "93.184.216.34", RemotePort: 443, State: "ESTABLISHED", PID: 1234},
+				{Protocol: "tcp", LocalAddress: "0.0.0.0", LocalPort: 445, State: "LISTEN", PID: 4},
+			},
+		},
+		{
+			name:     "tcp6",
+			protocol: "tcp6",
+			data:     table(join(net.IPv6loopback, dword(0), port(5985), net.IPv6loopback, dword(0), port(50000), dword(5), dword(88))),
+			want: []NetworkConnection{
+				{Protocol: "tcp6", LocalAddress: "::1", LocalPort: 5985, RemoteAddress: "::1", RemotePort: 50000, State: "ESTABLISHED", PID: 88},
+			},
+		},
+		{
+			name:     "udp",
+			protocol: "udp",
+			data:     table(join(net.IPv4(127, 0, 0, 1).To4(), port(53), dword(700))),
+			want:     []NetworkConnection{{Protocol: "udp", LocalAddress: "127.0.0.1", LocalPort: 53, PID: 700}},
+		},
+		{
+			name:     "udp6 cut short",
+			protocol: "udp6",
+			data:     table(join(net.IPv6unspecified, dword(0), port(5353), dword(900)))[:20],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseConnectionTable(tt.protocol, tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConnectionTable() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_volatileAcquirer(t *testing.T) {
+	acquirer := &volatileAcquirer{name: "volatile/test.json", gather: func(context.Context, Logger) (interface{}, error) {
+		return []LoggedOnUser{{SessionID: 1, WindowStation: "Console", State: "active", User: "alice"}}, nil
+	}}
+	reader, size, err := acquirer.Acquire(context.Background(), loggerOrDefault(nil))
+	if err != nil {
+		t.Fatalf("volatileAcquirer.Acquire() error = %v", err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	want := "[\n  {\n    \"session_id\": 1,\n    \"window_station\": \"Console\",\n    \"state\": \"active\",\n    \"user\": \"alice\"\n  }\n]"
+	if string(data) != want || size != int64(len(data)) {
+		t.Errorf("volatileAcquirer.Acquire() = %q of size %d, want %q", data, size, want)
+	}
+
+	acquirer.gather = func(context.Context, Logger) (interface{}, error) { return nil, errors.New("access denied") }
+	if _, _, err = acquirer.Acquire(context.Background(), loggerOrDefault(nil)); err == nil {
+		t.Error("volatileAcquirer.Acquire() should fail when gathering fails")
+	}
+}
+
+func TestVolatileAcquirers(t *testing.T) {
+	var names []string
+	for _, acquirer := range VolatileAcquirers() {
+		names = append(names, acquirer.Name())
+	}
+	want := []string{"volatile/processes.json", "volatile/network_connections.json", "volatile/logged_on_users.json", "volatile/services.json", "volatile/drivers.json"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("VolatileAcquirers() names = %v, want %v", names, want)
+	}
+}
+
+func Test_utf16PointerToString(t *testing.T) {
+	characters := append(utf16.Encode([]rune("Bürokratie")), 0)
+	if got := utf16PointerToString(&characters[0]); got != "Bürokratie" {
+		t.Errorf("utf16PointerToString() = %q, want %q", got, "Bürokratie")
+	}
+	if got := utf16PointerToString(nil); got != "" {
+		t.Errorf("utf16PointerToString(nil) = %q, want an empty string", got)
+	}
+}
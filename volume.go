@@ -3,16 +3,17 @@
 package windowscollector
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	vbr "github.com/Go-Forensics/VBR-Parser"
-	log "github.com/sirupsen/logrus"
 	syscall "golang.org/x/sys/windows"
 	"io"
 	"os"
 	"regexp"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 )
 
 type handler interface {
@@ -24,8 +25,18 @@ type VolumeHandler struct {
 	Handle               *os.File
 	VolumeLetter         string
 	Vbr                  vbr.VolumeBootRecord
+	Logger               Logger // nil logs through logrus' standard logger
 	mftReader            io.Reader
+	inspector            *mftInspector
+	timeline             *mftTimeline
+	directoryExport      *directoryTreeExport
+	cacheBuilder         *mftCacheBuilder
+	recoverDeleted       bool
+	recordOffsets        mftRecordVolumeOffsetTracker
 	lastReadVolumeOffset int64
+	handler              handler
+	retry                readRetry
+	audit                *auditLog
 }
 
 // GetHandle will get a file handle to the underlying NTFS volume. We need this in order to bypass file locks.
@@ -35,7 +46,17 @@ func (volume VolumeHandler) GetHandle(volumeLetter string) (handle *os.File, err
 	dwCreationDisposition := uint32(0x03)
 	dwFlagsAndAttributes := uint32(0x00)
 
-	volumePath, _ := syscall.UTF16PtrFromString(fmt.Sprintf("\\\\.\\%s:", volumeLetter))
+	devicePath := fmt.Sprintf("\\\\.\\%s:", volumeLetter)
+	if volumeLetter == espVolume {
+		devicePath, err = volumeRoot(volumeLetter)
+		if err != nil {
+			err = fmt.Errorf("getHandle() failed to get handle to volume %s: %w", volumeLetter, err)
+			return
+		}
+		// The trailing backslash would open the partition's root directory instead of the partition
+		devicePath = strings.TrimSuffix(devicePath, `\`)
+	}
+	volumePath, _ := syscall.UTF16PtrFromString(devicePath)
 	syscallHandle, err := syscall.CreateFile(volumePath, dwDesiredAccess, dwShareMode, nil, dwCreationDisposition, dwFlagsAndAttributes, 0)
 	if err != nil {
 		err = fmt.Errorf("getHandle() failed to get handle to volume %s: %w", volumeLetter, err)
@@ -47,8 +68,8 @@ func (volume VolumeHandler) GetHandle(volumeLetter string) (handle *os.File, err
 
 // GetVolumeHandler gets a file handle to the specified volume and parses its volume boot record.
 func GetVolumeHandler(volumeLetter string, handler handler) (volume VolumeHandler, err error) {
-	const volumeBootRecordSize = 512
 	volume.VolumeLetter = volumeLetter
+	volume.handler = handler
 	volume.Handle, err = handler.GetHandle(volumeLetter)
 	if err != nil {
 		err = fmt.Errorf("GetVolumeHandler() failed to get handle to volume %s: %w", volumeLetter, err)
@@ -56,18 +77,248 @@ func GetVolumeHandler(volumeLetter string, handler handler) (volume VolumeHandle
 	}
 
 	// Parse the VBR to get details we need about the volume.
-	volumeBootRecord := make([]byte, volumeBootRecordSize)
-	_, err = volume.Handle.Read(volumeBootRecord)
+	volumeBootRecord, err := readVolumeBootRecord(volume.Handle)
 	if err != nil {
 		err = fmt.Errorf("GetVolumeHandler() failed to read the volume boot record on volume %v: %w", volumeLetter, err)
 		return
 	}
-	volume.Vbr, err = vbr.RawVolumeBootRecord(volumeBootRecord).Parse()
+	if fileSystem := fileSystemOf(volumeBootRecord); fileSystem == fileSystemBitLocker {
+		volume.Handle.Close()
+		err = &LockedVolumeError{Volume: volumeLetter}
+		return
+	} else if fileSystem != "" && fileSystem != fileSystemNTFS {
+		volume.Handle.Close()
+		err = &FileSystemError{Volume: volumeLetter, FileSystem: fileSystem}
+		return
+	}
+	volume.Vbr, err = parseVolumeBootRecord(volumeBootRecord)
 	if err != nil {
 		err = fmt.Errorf("GetVolumeHandler() failed to parse vbr from volume letter %s: %w", volumeLetter, err)
 		return
 	}
-	log.Debugf("Successfully got a file handle to volume %v and read its volume boot record.", volumeLetter)
+	return
+}
+
+const (
+	volumeBootRecordSize = 512
+	// Reads of a volume have to be whole sectors, so the volume boot record is read as one of the biggest sector size
+	// there is, which is also a whole number of sectors of every smaller one.
+	maxBytesPerSector = 4096
+	// NTFS clusters go up to 2M since Windows 10 1709
+	maxBytesPerCluster = 2 * 1024 * 1024
+)
+
+// readVolumeBootRecord reads the first sector of a volume.
+func readVolumeBootRecord(handle *os.File) (volumeBootRecord []byte, err error) {
+	_, err = handle.Seek(0x00, io.SeekStart)
+	if err != nil {
+		return
+	}
+	volumeBootRecord = make([]byte, maxBytesPerSector)
+	numberOfBytesRead, err := handle.Read(volumeBootRecord)
+	if err != nil {
+		return
+	}
+	if numberOfBytesRead < volumeBootRecordSize {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	volumeBootRecord = volumeBootRecord[:numberOfBytesRead]
+	return
+}
+
+// parseVolumeBootRecord parses an NTFS volume boot record. The VBR parser takes the sectors per cluster and the size of
+// an MFT record as they are stored, which is only right for clusters of up to 64K and clusters no smaller than a
+// record, so they are decoded here for 4K native disks, clusters of up to 2M and clusters of 512 bytes alike.
+func parseVolumeBootRecord(volumeBootRecord []byte) (volume vbr.VolumeBootRecord, err error) {
+	const (
+		offsetBytesPerSector         = 0x0b
+		offsetSectorsPerCluster      = 0x0d
+		offsetMftCluster             = 0x30
+		offsetClustersPerMFTRecord   = 0x40
+		offsetClustersPerIndexRecord = 0x44
+	)
+	if len(volumeBootRecord) < volumeBootRecordSize {
+		err = errors.New("parseVolumeBootRecord() received less than 512 bytes")
+		return
+	}
+	if fileSystemOf(volumeBootRecord) != fileSystemNTFS {
+		err = errors.New("parseVolumeBootRecord() received a volume boot record without the 'NTFS' magic number")
+		return
+	}
+
+	bytesPerSector := int64(binary.LittleEndian.Uint16(volumeBootRecord[offsetBytesPerSector:]))
+	if bytesPerSector < 256 || bytesPerSector > maxBytesPerSector || bytesPerSector&(bytesPerSector-1) != 0 {
+		err = fmt.Errorf("parseVolumeBootRecord() found %d bytes per sector, which isn't a sector size", bytesPerSector)
+		return
+	}
+	sectorsPerCluster := decodeSectorsPerCluster(volumeBootRecord[offsetSectorsPerCluster])
+	bytesPerCluster := sectorsPerCluster * bytesPerSector
+	if bytesPerCluster < bytesPerSector || bytesPerCluster > maxBytesPerCluster {
+		err = fmt.Errorf("parseVolumeBootRecord() found clusters of %d bytes", bytesPerCluster)
+		return
+	}
+	mftRecordSize := decodeRecordSize(volumeBootRecord[offsetClustersPerMFTRecord], bytesPerCluster)
+	if mftRecordSize < 256 || mftRecordSize > 64*1024 {
+		err = fmt.Errorf("parseVolumeBootRecord() found mft records of %d bytes", mftRecordSize)
+		return
+	}
+	mftCluster := int64(binary.LittleEndian.Uint64(volumeBootRecord[offsetMftCluster:]))
+	if mftCluster <= 0 {
+		err = fmt.Errorf("parseVolumeBootRecord() found the mft at cluster %d", mftCluster)
+		return
+	}
+
+	volume = vbr.VolumeBootRecord{
+		BytesPerSector:         bytesPerSector,
+		SectorsPerCluster:      sectorsPerCluster,
+		BytesPerCluster:        bytesPerCluster,
+		MftByteOffset:          mftCluster * bytesPerCluster,
+		MftRecordSize:          mftRecordSize,
+		ClustersPerIndexRecord: int64(volumeBootRecord[offsetClustersPerIndexRecord]),
+	}
+	return
+}
+
+// decodeSectorsPerCluster decodes the sectors per cluster of a volume boot record. Values past 0x80 are the negative
+// power of two of the sectors per cluster, which is how clusters bigger than 64K are given on 512 byte sectors.
+func decodeSectorsPerCluster(value byte) int64 {
+	if value <= 0x80 {
+		return int64(value)
+	}
+	return int64(1) << uint(256-int(value))
+}
+
+// decodeRecordSize decodes the size of an MFT or index record from a volume boot record. A positive value is a number of
+// clusters and a negative one is the power of two of the size in bytes, which is what's used when a record is smaller
+// than a cluster.
+func decodeRecordSize(value byte, bytesPerCluster int64) int64 {
+	if signed := int8(value); signed < 0 {
+		return int64(1) << uint(-int(signed))
+	}
+	return int64(value) * bytesPerCluster
+}
+
+// volumeName returns how the paths on a volume start, e.g. c:\, esp:\ or \\host\c$\.
+func volumeName(volumeLetter string) string {
+	if isShare(volumeLetter) {
+		return volumeLetter + `\`
+	}
+	return volumeLetter + `:\`
+}
+
+// volumeRoot returns the directory a volume's files are reached through: its name, or the volume GUID path of the EFI
+// system partition, which has no drive letter.
+func volumeRoot(volumeLetter string) (root string, err error) {
+	if volumeLetter != espVolume {
+		root = volumeName(volumeLetter)
+		return
+	}
+	root, err = systemPartitionPath()
+	if err != nil {
+		err = fmt.Errorf("failed to find the EFI system partition: %w", err)
+	}
+	return
+}
+
+// longPath returns a path with the \\?\ prefix that lets the API open it when it's longer than MAX_PATH, which
+// deeply nested profiles, browser caches and paths with many non-ASCII characters run into. Relative paths and paths
+// that already have a prefix are returned as they are.
+func longPath(path string) string {
+	// CreateDirectory's limit is 12 characters under MAX_PATH, so prefix from there like the os package does
+	const maxShortPath = 248
+	switch {
+	case len(utf16.Encode([]rune(path))) < maxShortPath:
+		return path
+	case strings.HasPrefix(path, `\\?\`), strings.HasPrefix(path, `\\.\`):
+		return path
+	case strings.HasPrefix(path, `\\`):
+		return `\\?\UNC\` + strings.ReplaceAll(path[2:], "/", `\`)
+	case len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/'):
+		return `\\?\` + strings.ReplaceAll(path, "/", `\`)
+	}
+	return path
+}
+
+// fileSystemOf names the file system a volume boot record is for by its OEM ID, or the file system type FAT volumes
+// keep further in. It's empty for a file system it doesn't know.
+func fileSystemOf(volumeBootRecord []byte) string {
+	const (
+		offsetOEMID          = 0x03
+		offsetFAT32Type      = 0x52
+		offsetFATType        = 0x36
+		lengthFileSystemName = 8
+	)
+	if len(volumeBootRecord) < offsetFAT32Type+lengthFileSystemName {
+		return ""
+	}
+	switch {
+	case string(volumeBootRecord[offsetOEMID:offsetOEMID+lengthFileSystemName]) == "NTFS    ":
+		return fileSystemNTFS
+	case string(volumeBootRecord[offsetOEMID:offsetOEMID+lengthFileSystemName]) == "-FVE-FS-":
+		return fileSystemBitLocker
+	case string(volumeBootRecord[offsetOEMID:offsetOEMID+lengthFileSystemName]) == "EXFAT   ":
+		return fileSystemExFAT
+	case string(volumeBootRecord[offsetFAT32Type:offsetFAT32Type+lengthFileSystemName]) == "FAT32   ":
+		return fileSystemFAT32
+	case strings.HasPrefix(string(volumeBootRecord[offsetFATType:offsetFATType+lengthFileSystemName]), "FAT1"):
+		return fileSystemFAT
+	}
+	return ""
+}
+
+// duplicate returns a copy of the volume handler with its own file handle, so it can seek and read independently of the
+// original. The caller is responsible for closing the new handle.
+func (volume *VolumeHandler) duplicate() (duplicate VolumeHandler, err error) {
+	if volume.handler == nil {
+		err = errors.New("duplicate() was called on a volume handler that wasn't made by GetVolumeHandler()")
+		return
+	}
+	duplicate = *volume
+	duplicate.mftReader = nil
+	duplicate.inspector = nil
+	duplicate.timeline = nil
+	duplicate.directoryExport = nil
+	duplicate.cacheBuilder = nil
+	duplicate.lastReadVolumeOffset = 0
+	duplicate.Handle, err = volume.handler.GetHandle(volume.VolumeLetter)
+	if err != nil {
+		err = fmt.Errorf("duplicate() failed to get another handle to volume %s: %w", volume.VolumeLetter, err)
+		return
+	}
+	return
+}
+
+// readAligned reads from the volume at its handle's position, which is offset. Raw reads of a volume have to start and
+// end on a sector boundary, which the end of a file rarely does and reads of 512 bytes on a 4K native disk don't either,
+// so those read the sectors around what's wanted instead and leave the handle just past what was read.
+func (volume *VolumeHandler) readAligned(buffer []byte, offset int64) (numberOfBytesRead int, err error) {
+	sectorSize := volume.Vbr.BytesPerSector
+	if sectorSize <= 0 || (offset%sectorSize == 0 && int64(len(buffer))%sectorSize == 0) {
+		numberOfBytesRead, err = volume.Handle.Read(buffer)
+		return
+	}
+	start := offset - offset%sectorSize
+	end := offset + int64(len(buffer))
+	if remainder := end % sectorSize; remainder != 0 {
+		end += sectorSize - remainder
+	}
+	_, err = volume.Handle.Seek(start, io.SeekStart)
+	if err != nil {
+		return
+	}
+	sectors := make([]byte, end-start)
+	read, err := volume.Handle.Read(sectors)
+	if skip := offset - start; int64(read) > skip {
+		numberOfBytesRead = copy(buffer, sectors[skip:read])
+	}
+	if err != nil {
+		return
+	}
+	_, err = volume.Handle.Seek(offset+int64(numberOfBytesRead), io.SeekStart)
+	if err == nil && numberOfBytesRead == 0 && len(buffer) != 0 {
+		err = io.EOF
+	}
 	return
 }
 
@@ -92,15 +343,29 @@ func isLetter(s string) (result bool, err error) {
 	return
 }
 
+// replaceVolumeToken replaces a token such as %systemdrive% a target's path starts with by the volume it stands for. A
+// case-sensitive target keeps the case of the rest of its path.
+func replaceVolumeToken(fileToExport FileToExport, token string, volume string) string {
+	if fileToExport.CaseSensitive {
+		return volume + fileToExport.FullPath[len(token):]
+	}
+	return strings.Replace(strings.ToLower(fileToExport.FullPath), token, volume, -1)
+}
+
 func identifyVolumesOfInterest(exportList *ListOfFilesToExport) (volumesOfInterest []string, err error) {
 	volumesOfInterest = make([]string, 0)
 	re := regexp.MustCompile(`[^:]+`)
 	for index, fileToExport := range *exportList {
 		volume := re.FindString(strings.ToLower(fileToExport.FullPath))
-		if volume == "%systemdrive%" {
+		if share := shareOf(fileToExport.FullPath, fileToExport.IsFullPathRegex); share != "" {
+			volume = share
+		} else if volume == "%systemdrive%" {
 			systemDrive := os.Getenv("SYSTEMDRIVE")
 			volume = re.FindString(systemDrive)
-			(*exportList)[index].FullPath = strings.Replace(strings.ToLower(fileToExport.FullPath), "%systemdrive%", volume, -1)
+			(*exportList)[index].FullPath = replaceVolumeToken(fileToExport, "%systemdrive%", volume)
+		} else if volume == "%esp%" {
+			volume = espVolume
+			(*exportList)[index].FullPath = replaceVolumeToken(fileToExport, "%esp%", volume)
 		} else {
 			var result bool
 			result, err = isLetter(volume)
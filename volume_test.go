@@ -3,9 +3,12 @@
 package windowscollector
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	vbr "github.com/Go-Forensics/VBR-Parser"
 	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
@@ -84,6 +87,102 @@ func TestGetVolumeHandler(t *testing.T) {
 	}
 }
 
+func Test_parseVolumeBootRecord(t *testing.T) {
+	volumeBootRecord := func(bytesPerSector uint16, sectorsPerCluster byte, clustersPerMFTRecord byte, mftCluster uint64) []byte {
+		data := make([]byte, 512)
+		copy(data[0x03:], "NTFS    ")
+		binary.LittleEndian.PutUint16(data[0x0b:], bytesPerSector)
+		data[0x0d] = sectorsPerCluster
+		binary.LittleEndian.PutUint64(data[0x30:], mftCluster)
+		data[0x40] = clustersPerMFTRecord
+		data[0x44] = 1
+		return data
+	}
+	tests := []struct {
+		name             string
+		volumeBootRecord []byte
+		want             vbr.VolumeBootRecord
+		wantErr          bool
+	}{
+		{
+			name:             "512 byte sectors",
+			volumeBootRecord: volumeBootRecord(512, 8, 0xf6, 786432),
+			want:             vbr.VolumeBootRecord{BytesPerSector: 512, SectorsPerCluster: 8, BytesPerCluster: 4096, MftByteOffset: 786432 * 4096, MftRecordSize: 1024, ClustersPerIndexRecord: 1},
+		},
+		{
+			name:             "4K native with 64K clusters",
+			volumeBootRecord: volumeBootRecord(4096, 16, 0xf4, 12288),
+			want:             vbr.VolumeBootRecord{BytesPerSector: 4096, SectorsPerCluster: 16, BytesPerCluster: 65536, MftByteOffset: 12288 * 65536, MftRecordSize: 4096, ClustersPerIndexRecord: 1},
+		},
+		{
+			name:             "2M clusters on 512 byte sectors",
+			volumeBootRecord: volumeBootRecord(512, 0xf4, 0xf6, 3),
+			want:             vbr.VolumeBootRecord{BytesPerSector: 512, SectorsPerCluster: 4096, BytesPerCluster: 2 * 1024 * 1024, MftByteOffset: 3 * 2 * 1024 * 1024, MftRecordSize: 1024, ClustersPerIndexRecord: 1},
+		},
+		{
+			name:             "512 byte clusters with records of 2 clusters",
+			volumeBootRecord: volumeBootRecord(512, 1, 2, 32),
+			want:             vbr.VolumeBootRecord{BytesPerSector: 512, SectorsPerCluster: 1, BytesPerCluster: 512, MftByteOffset: 32 * 512, MftRecordSize: 1024, ClustersPerIndexRecord: 1},
+		},
+		{name: "sector size", volumeBootRecord: volumeBootRecord(1000, 8, 0xf6, 4), wantErr: true},
+		{name: "no sectors per cluster", volumeBootRecord: volumeBootRecord(512, 0, 0xf6, 4), wantErr: true},
+		{name: "no mft", volumeBootRecord: volumeBootRecord(512, 8, 0xf6, 0), wantErr: true},
+		{name: "short", volumeBootRecord: volumeBootRecord(512, 8, 0xf6, 4)[:256], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVolumeBootRecord(tt.volumeBootRecord)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVolumeBootRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVolumeBootRecord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeHandler_readAligned(t *testing.T) {
+	volume, err := ioutil.ReadFile(`test\testdata\dummyntfs`)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile() error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		offset int64
+		length int
+		want   []byte
+	}{
+		{name: "whole sectors", offset: 4096, length: 4096, want: volume[4096:8192]},
+		{name: "a record inside a 4K sector", offset: 5120, length: 1024, want: volume[5120:6144]},
+		{name: "the end of a file", offset: 8192, length: 100, want: volume[8192:8292]},
+		{name: "past the end of the volume", offset: int64(len(volume)) - 10, length: 100, want: volume[len(volume)-10:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle, err := os.Open(`test\testdata\dummyntfs`)
+			if err != nil {
+				t.Fatalf("os.Open() error = %v", err)
+			}
+			defer handle.Close()
+			volumeHandler := &VolumeHandler{Handle: handle, Vbr: vbr.VolumeBootRecord{BytesPerSector: 4096}}
+			_, _ = handle.Seek(tt.offset, io.SeekStart)
+
+			buffer := make([]byte, tt.length)
+			numberOfBytesRead, err := volumeHandler.readAligned(buffer, tt.offset)
+			if err != nil {
+				t.Fatalf("readAligned() error = %v", err)
+			}
+			if !bytes.Equal(buffer[:numberOfBytesRead], tt.want) {
+				t.Errorf("readAligned() read %d bytes that aren't the ones at offset %d", numberOfBytesRead, tt.offset)
+			}
+			if position, _ := handle.Seek(0, io.SeekCurrent); position != tt.offset+int64(numberOfBytesRead) {
+				t.Errorf("readAligned() left the handle at %d, want %d", position, tt.offset+int64(numberOfBytesRead))
+			}
+		})
+	}
+}
+
 type dummyHandler struct {
 	Handle               *os.File
 	VolumeLetter         string
@@ -174,6 +273,38 @@ func Test_identifyVolumesOfInterest(t *testing.T) {
 			wantVolumesOfInterest: []string{"C", "d"},
 			wantErr:               false,
 		},
+		{
+			name: "efi system partition",
+			args: args{exportList: &ListOfFilesToExport{
+				0: FileToExport{
+					FullPath:        `%ESP%:\EFI\Microsoft\Boot\BCD`,
+					IsFullPathRegex: false,
+					FileName:        "BCD",
+					IsFileNameRegex: false,
+				},
+			}},
+			wantVolumesOfInterest: []string{"esp"},
+			wantErr:               false,
+		},
+		{
+			name: "administrative share",
+			args: args{exportList: &ListOfFilesToExport{
+				0: FileToExport{
+					FullPath:        `\\WS042\C$\Windows\System32\config\SAM`,
+					IsFullPathRegex: false,
+					FileName:        "SAM",
+					IsFileNameRegex: false,
+				},
+				1: FileToExport{
+					FullPath:        `\\\\ws042\\c\$\\Users\\([^\\]+)\\ntuser.dat`,
+					IsFullPathRegex: true,
+					FileName:        "ntuser.dat",
+					IsFileNameRegex: false,
+				},
+			}},
+			wantVolumesOfInterest: []string{`\\ws042\c$`},
+			wantErr:               false,
+		},
 		{
 			name: "not a real volume",
 			args: args{exportList: &ListOfFilesToExport{
@@ -0,0 +1,507 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// WMIQuery is a WQL query whose results are written into the output as wmi/<Name>.json, a JSON array with an object of
+// property names to values for each instance.
+type WMIQuery struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"` // defaults to root\cimv2
+	Query     string `json:"query"`
+}
+
+// DefaultWMIQueries cover what runs and what is set to run: processes, services, startup commands, scheduled jobs,
+// installed updates and shadow copies, and the event subscriptions used for WMI persistence.
+var DefaultWMIQueries = []WMIQuery{
+	{Name: "processes", Query: "SELECT * FROM Win32_Process"},
+	{Name: "services", Query: "SELECT * FROM Win32_Service"},
+	{Name: "startup_commands", Query: "SELECT * FROM Win32_StartupCommand"},
+	{Name: "scheduled_jobs", Query: "SELECT * FROM Win32_ScheduledJob"},
+	{Name: "hotfixes", Query: "SELECT * FROM Win32_QuickFixEngineering"},
+	{Name: "shadow_copies", Query: "SELECT * FROM Win32_ShadowCopy"},
+	{Name: "event_filters", Namespace: `root\subscription`, Query: "SELECT * FROM __EventFilter"},
+	{Name: "event_consumers", Namespace: `root\subscription`, Query: "SELECT * FROM __EventConsumer"},
+	{Name: "filter_to_consumer_bindings", Namespace: `root\subscription`, Query: "SELECT * FROM __FilterToConsumerBinding"},
+}
+
+// WMIAcquirers returns an Acquirer for each query. WMI is queried through COM, so nothing but the collector has to be
+// on the endpoint.
+func WMIAcquirers(queries []WMIQuery) (acquirers []Acquirer, err error) {
+	names := make(map[string]bool)
+	for _, query := range queries {
+		name := strings.ToLower(query.Name)
+		switch {
+		case query.Query == "":
+			err = fmt.Errorf("the wmi query '%s' has no query", query.Name)
+		case !isOutputName(name):
+			err = fmt.Errorf("the wmi query name '%s' can't be used as a file name", query.Name)
+		case names[name]:
+			err = fmt.Errorf("more than one wmi query is named '%s'", query.Name)
+		}
+		if err != nil {
+			acquirers = nil
+			return
+		}
+		names[name] = true
+		if query.Namespace == "" {
+			query.Namespace = `root\cimv2`
+		}
+		acquirers = append(acquirers, &wmiAcquirer{query: query})
+	}
+	return
+}
+
+type wmiAcquirer struct {
+	query WMIQuery
+}
+
+func (acquirer *wmiAcquirer) Name() string {
+	return fmt.Sprintf("wmi/%s.json", acquirer.query.Name)
+}
+
+func (acquirer *wmiAcquirer) Acquire(ctx context.Context, logger Logger) (reader io.ReadCloser, size int64, err error) {
+	instances, err := queryWMI(acquirer.query.Namespace, acquirer.query.Query)
+	if err != nil {
+		err = fmt.Errorf("the wmi query '%s' failed: %w", acquirer.query.Query, err)
+		return
+	}
+	data, err := json.MarshalIndent(instances, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("failed to serialize the results of the wmi query '%s': %w", acquirer.query.Query, err)
+		return
+	}
+	reader = ioutil.NopCloser(bytes.NewReader(data))
+	size = int64(len(data))
+	return
+}
+
+var (
+	ole32                    = windows.NewLazySystemDLL("ole32.dll")
+	procCoInitializeEx       = ole32.NewProc("CoInitializeEx")
+	procCoUninitialize       = ole32.NewProc("CoUninitialize")
+	procCoInitializeSecurity = ole32.NewProc("CoInitializeSecurity")
+	procCoCreateInstance     = ole32.NewProc("CoCreateInstance")
+	procCoSetProxyBlanket    = ole32.NewProc("CoSetProxyBlanket")
+	oleaut32                 = windows.NewLazySystemDLL("oleaut32.dll")
+	procSysAllocString       = oleaut32.NewProc("SysAllocString")
+	procSysFreeString        = oleaut32.NewProc("SysFreeString")
+	procVariantClear         = oleaut32.NewProc("VariantClear")
+	procSafeArrayGetLBound   = oleaut32.NewProc("SafeArrayGetLBound")
+	procSafeArrayGetUBound   = oleaut32.NewProc("SafeArrayGetUBound")
+	procSafeArrayGetElement  = oleaut32.NewProc("SafeArrayGetElement")
+
+	clsidWbemLocator = windows.GUID{Data1: 0x4590f811, Data2: 0x1d3a, Data3: 0x11d0, Data4: [8]byte{0x89, 0x1f, 0x00, 0xaa, 0x00, 0x4b, 0x2e, 0x24}}
+	iidIWbemLocator  = windows.GUID{Data1: 0xdc12a687, Data2: 0x737f, Data3: 0x11cf, Data4: [8]byte{0x88, 0x4d, 0x00, 0xaa, 0x00, 0x4b, 0xdf, 0x24}}
+)
+
+// COM and WMI constants from objbase.h, rpcdce.h and wbemcli.h.
+const (
+	coinitMultithreaded        = 0
+	rpcEChangedMode            = 0x80010106
+	clsctxInprocServer         = 1
+	rpcCAuthnWinnt             = 10
+	rpcCAuthnLevelDefault      = 0
+	rpcCAuthnLevelCall         = 3
+	rpcCImpLevelImpersonate    = 3
+	wbemFlagReturnImmediately  = 0x10
+	wbemFlagForwardOnly        = 0x20
+	wbemFlagNonsystemOnly      = 0x40
+	wbemInfinite               = 0xffffffff
+	wbemSNoMoreData            = 0x40005
+	iwbemLocatorConnectServer  = 3
+	iwbemServicesGetObject     = 6
+	iwbemServicesExecQuery     = 20
+	iwbemServicesExecMethod    = 24
+	iwbemClassObjectGet        = 4
+	iwbemClassObjectPut        = 5
+	ienumWbemClassObjectNext   = 4
+	iwbemClassObjectBeginEnum  = 8
+	iwbemClassObjectNext       = 9
+	iwbemClassObjectEndEnum    = 10
+	iwbemClassObjectSpawn      = 15
+	iwbemClassObjectGetMethod  = 19
+	iunknownRelease            = 2
+	maximumComMethodArguments  = 9
+	comObjectMethodTableLength = 32
+)
+
+// comObject is a COM interface pointer, which points at the interface's method table.
+type comObject struct {
+	methods *[comObjectMethodTableLength]uintptr
+}
+
+// call calls one of the object's methods by its index in the method table, passing the object as the first argument.
+func (object *comObject) call(method int, args ...uintptr) (hresult uintptr) {
+	var callArgs [maximumComMethodArguments]uintptr
+	callArgs[0] = uintptr(unsafe.Pointer(object))
+	copy(callArgs[1:], args)
+	hresult, _, _ = syscall.Syscall9(object.methods[method], uintptr(len(args)+1), callArgs[0], callArgs[1], callArgs[2], callArgs[3], callArgs[4], callArgs[5], callArgs[6], callArgs[7], callArgs[8])
+	return
+}
+
+func (object *comObject) release() {
+	if object != nil {
+		object.call(iunknownRelease)
+	}
+}
+
+// hresultError returns an error when an HRESULT is a failure.
+func hresultError(operation string, hresult uintptr) error {
+	if int32(hresult) < 0 {
+		return fmt.Errorf("%s failed with HRESULT %#x", operation, uint32(hresult))
+	}
+	return nil
+}
+
+// withWMI connects to a namespace and hands use its IWbemServices. The thread is locked for use since COM is
+// initialized per thread.
+func withWMI(namespace string, use func(services *comObject) error) (err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	hresult, _, _ := procCoInitializeEx.Call(0, coinitMultithreaded)
+	if hresult != rpcEChangedMode {
+		if err = hresultError("CoInitializeEx", hresult); err != nil {
+			return
+		}
+		defer procCoUninitialize.Call()
+	}
+	// This fails if the process has already set its COM security, which is fine
+	_, _, _ = procCoInitializeSecurity.Call(0, ^uintptr(0), 0, 0, rpcCAuthnLevelDefault, rpcCImpLevelImpersonate, 0, 0, 0)
+
+	var locator *comObject
+	hresult, _, _ = procCoCreateInstance.Call(uintptr(unsafe.Pointer(&clsidWbemLocator)), 0, clsctxInprocServer, uintptr(unsafe.Pointer(&iidIWbemLocator)), uintptr(unsafe.Pointer(&locator)))
+	if err = hresultError("creating the WbemLocator", hresult); err != nil {
+		return
+	}
+	defer locator.release()
+
+	namespaceString := allocateBSTR(namespace)
+	defer freeBSTR(namespaceString)
+	var services *comObject
+	hresult = locator.call(iwbemLocatorConnectServer, namespaceString, 0, 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&services)))
+	if err = hresultError(fmt.Sprintf("connecting to %s", namespace), hresult); err != nil {
+		return
+	}
+	defer services.release()
+	hresult, _, _ = procCoSetProxyBlanket.Call(uintptr(unsafe.Pointer(services)), rpcCAuthnWinnt, 0, 0, rpcCAuthnLevelCall, rpcCImpLevelImpersonate, 0, 0)
+	if err = hresultError("CoSetProxyBlanket", hresult); err != nil {
+		return
+	}
+	err = use(services)
+	return
+}
+
+// execQuery runs a WQL query and returns the enumerator of the instances it finds.
+func execQuery(services *comObject, query string) (enumerator *comObject, err error) {
+	language := allocateBSTR("WQL")
+	defer freeBSTR(language)
+	queryString := allocateBSTR(query)
+	defer freeBSTR(queryString)
+	hresult := services.call(iwbemServicesExecQuery, language, queryString, wbemFlagForwardOnly|wbemFlagReturnImmediately, 0, uintptr(unsafe.Pointer(&enumerator)))
+	err = hresultError("ExecQuery", hresult)
+	return
+}
+
+// nextInstance returns the next instance of an enumerator, or nil when there are no more.
+func nextInstance(enumerator *comObject) (instance *comObject, err error) {
+	var returned uint32
+	hresult := enumerator.call(ienumWbemClassObjectNext, wbemInfinite, 1, uintptr(unsafe.Pointer(&instance)), uintptr(unsafe.Pointer(&returned)))
+	if err = hresultError("getting the next instance", hresult); err != nil || returned == 0 {
+		instance = nil
+	}
+	return
+}
+
+// queryWMI runs a WQL query in a namespace and returns the non-system properties of each instance. It's a variable so
+// tests don't depend on the host.
+var queryWMI = func(namespace string, query string) (instances []map[string]interface{}, err error) {
+	err = withWMI(namespace, func(services *comObject) (err error) {
+		enumerator, err := execQuery(services, query)
+		if err != nil {
+			return
+		}
+		defer enumerator.release()
+		instances = make([]map[string]interface{}, 0)
+		for {
+			instance, nextErr := nextInstance(enumerator)
+			if nextErr != nil || instance == nil {
+				return nextErr
+			}
+			properties, propertiesErr := instanceProperties(instance)
+			instance.release()
+			if propertiesErr != nil {
+				return propertiesErr
+			}
+			instances = append(instances, properties)
+		}
+	})
+	return
+}
+
+// instancePath returns the __PATH of the first instance a WQL query finds, which its methods are called on, or empty
+// when it finds none.
+func instancePath(services *comObject, query string) (path string, err error) {
+	enumerator, err := execQuery(services, query)
+	if err != nil {
+		return
+	}
+	defer enumerator.release()
+	instance, err := nextInstance(enumerator)
+	if err != nil || instance == nil {
+		return
+	}
+	defer instance.release()
+	value, err := property(instance, "__PATH")
+	if err != nil {
+		return
+	}
+	defer value.clear()
+	path, _ = value.value().(string)
+	return
+}
+
+// execMethod calls a method of the instance of class at path, with inputs as its parameters, and returns its output
+// parameters. The methods of the Win32 classes return how they failed as a ReturnValue, which is returned as the error.
+func execMethod(services *comObject, class string, path string, method string, inputs map[string]*variant) (outputs *comObject, err error) {
+	classString := allocateBSTR(class)
+	defer freeBSTR(classString)
+	var classObject *comObject
+	hresult := services.call(iwbemServicesGetObject, classString, 0, 0, uintptr(unsafe.Pointer(&classObject)), 0)
+	if err = hresultError(fmt.Sprintf("getting the class %s", class), hresult); err != nil {
+		return
+	}
+	defer classObject.release()
+
+	methodName, err := windows.UTF16PtrFromString(method)
+	if err != nil {
+		return
+	}
+	var signature, parameters *comObject
+	hresult = classObject.call(iwbemClassObjectGetMethod, uintptr(unsafe.Pointer(methodName)), 0, uintptr(unsafe.Pointer(&signature)), 0)
+	if err = hresultError(fmt.Sprintf("getting the method %s", method), hresult); err != nil {
+		return
+	}
+	if signature != nil {
+		defer signature.release()
+		hresult = signature.call(iwbemClassObjectSpawn, 0, uintptr(unsafe.Pointer(&parameters)))
+		if err = hresultError("creating the input parameters", hresult); err != nil {
+			return
+		}
+		defer parameters.release()
+		for name, value := range inputs {
+			nameString, nameErr := windows.UTF16PtrFromString(name)
+			if nameErr != nil {
+				err = nameErr
+				return
+			}
+			hresult = parameters.call(iwbemClassObjectPut, uintptr(unsafe.Pointer(nameString)), 0, uintptr(unsafe.Pointer(value)), 0)
+			if err = hresultError(fmt.Sprintf("setting the parameter %s", name), hresult); err != nil {
+				return
+			}
+		}
+	}
+
+	pathString := allocateBSTR(path)
+	defer freeBSTR(pathString)
+	methodString := allocateBSTR(method)
+	defer freeBSTR(methodString)
+	hresult = services.call(iwbemServicesExecMethod, pathString, methodString, 0, 0, uintptr(unsafe.Pointer(parameters)), uintptr(unsafe.Pointer(&outputs)), 0)
+	if err = hresultError(method, hresult); err != nil {
+		return
+	}
+	returnValue, err := property(outputs, "ReturnValue")
+	if err != nil {
+		outputs.release()
+		outputs = nil
+		return
+	}
+	defer returnValue.clear()
+	var code uint32
+	switch value := returnValue.value().(type) {
+	case int32:
+		code = uint32(value)
+	case uint32:
+		code = value
+	}
+	if code != 0 {
+		outputs.release()
+		outputs = nil
+		err = fmt.Errorf("%s returned %#x", method, code)
+	}
+	return
+}
+
+// property returns a property of an object, which the caller clears.
+func property(object *comObject, name string) (value variant, err error) {
+	nameString, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return
+	}
+	hresult := object.call(iwbemClassObjectGet, uintptr(unsafe.Pointer(nameString)), 0, uintptr(unsafe.Pointer(&value)), 0, 0)
+	err = hresultError(fmt.Sprintf("getting the property %s", name), hresult)
+	return
+}
+
+// instanceProperties returns the non-system properties of an IWbemClassObject.
+func instanceProperties(instance *comObject) (properties map[string]interface{}, err error) {
+	hresult := instance.call(iwbemClassObjectBeginEnum, wbemFlagNonsystemOnly)
+	if err = hresultError("BeginEnumeration", hresult); err != nil {
+		return
+	}
+	defer instance.call(iwbemClassObjectEndEnum)
+	properties = make(map[string]interface{})
+	for {
+		var name *uint16
+		var value variant
+		hresult = instance.call(iwbemClassObjectNext, 0, uintptr(unsafe.Pointer(&name)), uintptr(unsafe.Pointer(&value)), 0, 0)
+		if hresult == wbemSNoMoreData {
+			return
+		}
+		if err = hresultError("getting the next property", hresult); err != nil {
+			return
+		}
+		properties[bstrToString(name)] = value.value()
+		freeBSTR(uintptr(unsafe.Pointer(name)))
+		value.clear()
+	}
+}
+
+// Variant types from wtypes.h.
+const (
+	vtEmpty   = 0
+	vtNull    = 1
+	vtI2      = 2
+	vtI4      = 3
+	vtR4      = 4
+	vtR8      = 5
+	vtBSTR    = 8
+	vtBool    = 11
+	vtI1      = 16
+	vtUI1     = 17
+	vtUI2     = 18
+	vtUI4     = 19
+	vtI8      = 20
+	vtUI8     = 21
+	vtInt     = 22
+	vtUint    = 23
+	vtArray   = 0x2000
+	vtTypeMax = 0x0fff
+)
+
+// variant is a VARIANT, 16 bytes on 32 bit Windows and 24 on 64 bit.
+type variant struct {
+	vt       uint16
+	reserved [3]uint16
+	data     uintptr
+	extra    uintptr
+}
+
+// stringVariant returns a BSTR variant, which is cleared once it has been used.
+func stringVariant(value string) variant {
+	return variant{vt: vtBSTR, data: allocateBSTR(value)}
+}
+
+// clear frees whatever the variant holds.
+func (value *variant) clear() {
+	_, _, _ = procVariantClear.Call(uintptr(unsafe.Pointer(value)))
+}
+
+// value converts the variant into a value for JSON. WMI returns 64 bit integers and datetimes as strings.
+func (value *variant) value() interface{} {
+	data := unsafe.Pointer(&value.data)
+	switch value.vt {
+	case vtEmpty, vtNull:
+		return nil
+	case vtI1:
+		return *(*int8)(data)
+	case vtUI1:
+		return *(*uint8)(data)
+	case vtI2:
+		return *(*int16)(data)
+	case vtUI2:
+		return *(*uint16)(data)
+	case vtI4, vtInt:
+		return *(*int32)(data)
+	case vtUI4, vtUint:
+		return *(*uint32)(data)
+	case vtI8:
+		return *(*int64)(data)
+	case vtUI8:
+		return *(*uint64)(data)
+	case vtR4:
+		return *(*float32)(data)
+	case vtR8:
+		return *(*float64)(data)
+	case vtBool:
+		return *(*int16)(data) != 0
+	case vtBSTR:
+		return bstrToString(*(**uint16)(data))
+	}
+	if value.vt&vtArray != 0 {
+		return safeArrayValues(*(*uintptr)(data), value.vt&vtTypeMax)
+	}
+	return fmt.Sprintf("unsupported variant type %#x", value.vt)
+}
+
+// safeArrayValues converts a one dimensional SAFEARRAY of the given variant type.
+func safeArrayValues(array uintptr, elementType uint16) (values []interface{}) {
+	if array == 0 {
+		return
+	}
+	var lowerBound, upperBound int32
+	_, _, _ = procSafeArrayGetLBound.Call(array, 1, uintptr(unsafe.Pointer(&lowerBound)))
+	_, _, _ = procSafeArrayGetUBound.Call(array, 1, uintptr(unsafe.Pointer(&upperBound)))
+	for index := lowerBound; index <= upperBound; index++ {
+		element := variant{vt: elementType}
+		hresult, _, _ := procSafeArrayGetElement.Call(array, uintptr(unsafe.Pointer(&index)), uintptr(unsafe.Pointer(&element.data)))
+		if int32(hresult) < 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, element.value())
+		if elementType == vtBSTR {
+			freeBSTR(element.data)
+		}
+	}
+	return
+}
+
+func allocateBSTR(value string) uintptr {
+	characters := utf16.Encode([]rune(value + "\x00"))
+	bstr, _, _ := procSysAllocString.Call(uintptr(unsafe.Pointer(&characters[0])))
+	return bstr
+}
+
+func freeBSTR(bstr uintptr) {
+	if bstr != 0 {
+		_, _, _ = procSysFreeString.Call(bstr)
+	}
+}
+
+// bstrToString converts a BSTR, whose length in bytes is in the 4 bytes before it.
+func bstrToString(bstr *uint16) string {
+	if bstr == nil {
+		return ""
+	}
+	length := *(*uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(bstr)) - 4)) / 2
+	if length == 0 {
+		return ""
+	}
+	characters := (*[1 << 28]uint16)(unsafe.Pointer(bstr))[:length:length]
+	return string(utf16.Decode(characters))
+}
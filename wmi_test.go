@@ -0,0 +1,136 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+	"unsafe"
+)
+
+func TestWMIAcquirers(t *testing.T) {
+	tests := []struct {
+		name      string
+		queries   []WMIQuery
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name:      "defaults",
+			queries:   DefaultWMIQueries[:2],
+			wantNames: []string{"wmi/processes.json", "wmi/services.json"},
+		},
+		{
+			name:    "no query",
+			queries: []WMIQuery{{Name: "processes"}},
+			wantErr: true,
+		},
+		{
+			name:    "bad name",
+			queries: []WMIQuery{{Name: `..\processes`, Query: "SELECT * FROM Win32_Process"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			queries: []WMIQuery{{Name: "Processes", Query: "SELECT * FROM Win32_Process"}, {Name: "processes", Query: "SELECT Name FROM Win32_Process"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acquirers, err := WMIAcquirers(tt.queries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WMIAcquirers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, acquirer := range acquirers {
+				names = append(names, acquirer.Name())
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("WMIAcquirers() names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func Test_wmiAcquirer(t *testing.T) {
+	original := queryWMI
+	defer func() { queryWMI = original }()
+	var gotNamespace string
+	queryWMI = func(namespace string, query string) ([]map[string]interface{}, error) {
+		gotNamespace = namespace
+		return []map[string]interface{}{{"Command": `C:\evil.exe`, "Name": "evil"}}, nil
+	}
+	acquirers, err := WMIAcquirers([]WMIQuery{{Name: "startup_commands", Query: "SELECT * FROM Win32_StartupCommand"}})
+	if err != nil {
+		t.Fatalf("WMIAcquirers() error = %v", err)
+	}
+	reader, size, err := acquirers[0].Acquire(context.Background(), loggerOrDefault(nil))
+	if err != nil {
+		t.Fatalf("wmiAcquirer.Acquire() error = %v", err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	want := "[\n  {\n    \"Command\": \"C:\\\\evil.exe\",\n    \"Name\": \"evil\"\n  }\n]"
+	if string(data) != want || size != int64(len(data)) {
+		t.Errorf("wmiAcquirer.Acquire() = %q of size %d, want %q", data, size, want)
+	}
+	if gotNamespace != `root\cimv2` {
+		t.Errorf("wmiAcquirer.Acquire() queried the namespace %q, want root\\cimv2", gotNamespace)
+	}
+
+	queryWMI = func(namespace string, query string) ([]map[string]interface{}, error) {
+		return nil, errors.New("invalid class")
+	}
+	if _, _, err = acquirers[0].Acquire(context.Background(), loggerOrDefault(nil)); err == nil {
+		t.Error("wmiAcquirer.Acquire() should fail when the query fails")
+	}
+}
+
+func Test_variant_value(t *testing.T) {
+	withData := func(vt uint16, data uint64) *variant {
+		value := &variant{vt: vt}
+		*(*uint64)(unsafe.Pointer(&value.data)) = data
+		return value
+	}
+	tests := []struct {
+		name  string
+		value *variant
+		want  interface{}
+	}{
+		{name: "empty", value: &variant{vt: vtEmpty}, want: nil},
+		{name: "null", value: &variant{vt: vtNull}, want: nil},
+		{name: "uint8", value: withData(vtUI1, 200), want: uint8(200)},
+		{name: "int16", value: withData(vtI2, 0xffff), want: int16(-1)},
+		{name: "int32", value: withData(vtI4, 0xfffffffe), want: int32(-2)},
+		{name: "uint32", value: withData(vtUI4, 4000000000), want: uint32(4000000000)},
+		{name: "float64", value: withData(vtR8, math.Float64bits(1.5)), want: 1.5},
+		{name: "true", value: withData(vtBool, 0xffff), want: true},
+		{name: "false", value: withData(vtBool, 0), want: false},
+		{name: "unsupported", value: &variant{vt: 0x24}, want: "unsupported variant type 0x24"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.value.value(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("variant.value() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_bstrToString(t *testing.T) {
+	// A BSTR points just past its length in bytes
+	characters := utf16.Encode([]rune("Win32_Process"))
+	bstr := append([]uint16{uint16(len(characters) * 2), 0}, characters...)
+	bstr = append(bstr, 0)
+	if got := bstrToString(&bstr[2]); got != "Win32_Process" {
+		t.Errorf("bstrToString() = %q, want %q", got, "Win32_Process")
+	}
+	if got := bstrToString(nil); got != "" {
+		t.Errorf("bstrToString(nil) = %q, want an empty string", got)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// spoolMemoryLimit is how much of a file a worker keeps in memory before spilling the rest to a temp file.
+const spoolMemoryLimit = 32 * 1024 * 1024
+
+// collectInParallel reads the found files with a pool of workers. Each worker has its own handle to the volume so raw
+// reads don't fight over the seek position, and spools each file so the result writer can drain them one at a time.
+func (collector *Collector) collectInParallel(ctx context.Context, volumeHandler *VolumeHandler, fileReaders chan fileReader, filesToCollect foundFiles) (err error) {
+	jobs := make(chan foundFile)
+	workerErrors := make(chan error, collector.Options.Workers)
+	waitForWorkers := sync.WaitGroup{}
+	for i := 0; i < collector.Options.Workers; i++ {
+		waitForWorkers.Add(1)
+		go func() {
+			defer waitForWorkers.Done()
+			workerErrors <- collector.collectionWorker(ctx, volumeHandler, jobs, fileReaders)
+		}()
+	}
+	volumeHandler.logger().Debugf("Started %d workers to collect %d files from volume %s.", collector.Options.Workers, len(filesToCollect), volumeHandler.VolumeLetter)
+
+	func() {
+		defer close(jobs)
+		for _, file := range filesToCollect {
+			select {
+			case jobs <- file:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	waitForWorkers.Wait()
+	close(workerErrors)
+
+	for workerErr := range workerErrors {
+		if workerErr != nil && err == nil {
+			err = workerErr
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return
+}
+
+func (collector *Collector) collectionWorker(ctx context.Context, volumeHandler *VolumeHandler, jobs chan foundFile, fileReaders chan fileReader) (err error) {
+	workerVolume, err := volumeHandler.duplicate()
+	if err != nil {
+		err = fmt.Errorf("collectionWorker() could not get its own volume handle: %w", err)
+		// Keep draining so the other workers still get all the jobs
+		for range jobs {
+		}
+		return
+	}
+	// A retried read may have swapped the handle for a new one
+	defer func() { workerVolume.Handle.Close() }()
+
+	for file := range jobs {
+		collector.metadata.add(file.fileMetadata(volumeHandler.VolumeLetter))
+		reader, method, fallback := collector.openFoundFile(&workerVolume, file)
+		spooled := collector.spoolUnlessDuplicate(collector.instrumentReader(ctx, reader, Progress{
+			Stage:        StageCopy,
+			VolumeLetter: volumeHandler.VolumeLetter,
+			FileName:     file.fullPath,
+			TotalBytes:   file.totalSize(),
+		}), file.outputPath(), volumeHandler.VolumeLetter)
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		if spooled == nil {
+			continue
+		}
+
+		err = collector.sendFileReader(ctx, fileReaders, collector.report.trackFile(collector.verifier.track(fileReader{
+			fullPath:   file.outputPath(),
+			reader:     spooled,
+			codec:      file.codec,
+			processors: file.processors,
+			method:     method,
+			fallback:   fallback,
+			links:      file.links,
+			times:      file.metadata.times(),
+
+			pendingBytes: spooled.inMemory,
+		}, file, volumeHandler.VolumeLetter), volumeHandler.VolumeLetter))
+		if err != nil {
+			spooled.discard()
+			for range jobs {
+			}
+			return
+		}
+	}
+	return
+}
+
+// spooledFile is a fully read copy of a file, kept in memory or, when it's too big, in a temp file that is removed once
+// it has been read back.
+type spooledFile struct {
+	reader   io.Reader
+	tempFile *os.File
+	inMemory int64
+}
+
+func spoolFile(reader io.Reader) (spooled *spooledFile, err error) {
+	buffer := new(bytes.Buffer)
+	_, err = io.CopyN(buffer, reader, spoolMemoryLimit)
+	if err == io.EOF {
+		err = nil
+		spooled = &spooledFile{reader: buffer, inMemory: int64(buffer.Len())}
+		return
+	} else if err != nil {
+		return
+	}
+
+	// The file is bigger than we want to hold in memory, spill what's left to disk
+	tempFile, err := ioutil.TempFile("", "gofor-spool-")
+	if err != nil {
+		err = fmt.Errorf("failed to create a spool file: %w", err)
+		return
+	}
+	spooled = &spooledFile{tempFile: tempFile, inMemory: int64(buffer.Len())}
+	_, err = io.Copy(tempFile, reader)
+	if err == nil {
+		_, err = tempFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.discard()
+		spooled = nil
+		return
+	}
+	spooled.reader = io.MultiReader(buffer, tempFile)
+	return
+}
+
+func (spooled *spooledFile) Read(byteSliceToPopulate []byte) (numberOfBytesRead int, err error) {
+	numberOfBytesRead, err = spooled.reader.Read(byteSliceToPopulate)
+	if err != nil {
+		spooled.discard()
+	}
+	return
+}
+
+// Close removes the temp file when the copy wasn't read to the end.
+func (spooled *spooledFile) Close() error {
+	spooled.discard()
+	return nil
+}
+
+func (spooled *spooledFile) discard() {
+	if spooled != nil && spooled.tempFile != nil {
+		spooled.tempFile.Close()
+		os.Remove(spooled.tempFile.Name())
+		spooled.tempFile = nil
+	}
+}
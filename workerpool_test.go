@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_spoolFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		dataSize     int
+		wantTempFile bool
+	}{
+		{
+			name:         "fits in memory",
+			dataSize:     1024,
+			wantTempFile: false,
+		},
+		{
+			name:         "spills to disk",
+			dataSize:     spoolMemoryLimit + 1024,
+			wantTempFile: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.dataSize)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			spooled, err := spoolFile(bytes.NewReader(data))
+			if err != nil {
+				t.Errorf("spoolFile() error = %v", err)
+				return
+			}
+			if gotTempFile := spooled.tempFile != nil; gotTempFile != tt.wantTempFile {
+				t.Errorf("spoolFile() used a temp file = %v, want %v", gotTempFile, tt.wantTempFile)
+			}
+			var tempFileName string
+			if spooled.tempFile != nil {
+				tempFileName = spooled.tempFile.Name()
+			}
+			got, err := ioutil.ReadAll(spooled)
+			if err != nil {
+				t.Errorf("spooledFile.Read() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, data) {
+				t.Errorf("spooledFile.Read() returned %d bytes that don't match the %d spooled", len(got), len(data))
+			}
+			if tempFileName != "" {
+				if _, err := os.Stat(tempFileName); !os.IsNotExist(err) {
+					t.Errorf("spooledFile.Read() did not remove the temp file %s after reading it", tempFileName)
+				}
+			}
+		})
+	}
+}
+
+func Test_spooledFile_Close(t *testing.T) {
+	spooled, err := spoolFile(bytes.NewReader(make([]byte, spoolMemoryLimit+1024)))
+	if err != nil {
+		t.Fatalf("spoolFile() error = %v", err)
+	}
+	tempFileName := spooled.tempFile.Name()
+	if err = spooled.Close(); err != nil {
+		t.Errorf("spooledFile.Close() error = %v", err)
+	}
+	if _, err = os.Stat(tempFileName); !os.IsNotExist(err) {
+		t.Errorf("spooledFile.Close() did not remove the temp file %s", tempFileName)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ResultWriterSettings are what NewResultWriter hands the factory of a registered result writer, which uses whichever
+// of them apply to it.
+type ResultWriterSettings struct {
+	Codec               string             // see ZipResultWriter
+	SigningKey          ed25519.PrivateKey // see ZipResultWriter
+	Header              http.Header        // see HttpResultWriter
+	WriteBytesPerSecond int64              // limits writing to a local file, 0 means unlimited
+	SplitSize           int64              // splits a local zip, tar or manifest into parts of this many bytes, see SplitFile
+}
+
+// ResultWriterFactory makes a result writer for a destination, such as the path of a zip or the URL to upload to.
+type ResultWriterFactory func(destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error)
+
+var (
+	resultWriterRegistryLock sync.RWMutex
+	resultWriterRegistry     = map[string]ResultWriterFactory{
+		"zip":       newZipResultWriter,
+		"tar":       newTarResultWriter,
+		"directory": newDirectoryResultWriter,
+		"manifest":  newManifestResultWriter,
+		"http":      newHttpResultWriter,
+		"azure":     newAzureBlobResultWriter,
+	}
+)
+
+// RegisterResultWriter makes a result writer available by name, so it can be picked along with the built in ones,
+// e.g. with --output. This is how embedding applications add destinations such as S3 without this package depending
+// on their SDKs.
+func RegisterResultWriter(name string, factory ResultWriterFactory) (err error) {
+	name = strings.ToLower(name)
+	if name == "" {
+		err = errors.New("RegisterResultWriter() received a result writer without a name")
+		return
+	}
+	if factory == nil {
+		err = fmt.Errorf("RegisterResultWriter() received result writer '%s' without a factory", name)
+		return
+	}
+	resultWriterRegistryLock.Lock()
+	defer resultWriterRegistryLock.Unlock()
+	resultWriterRegistry[name] = factory
+	return
+}
+
+// NewResultWriter makes a result writer of the registered kind for a destination.
+func NewResultWriter(name string, destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error) {
+	resultWriterRegistryLock.RLock()
+	factory, ok := resultWriterRegistry[strings.ToLower(name)]
+	resultWriterRegistryLock.RUnlock()
+	if !ok {
+		err = fmt.Errorf("no result writer named '%s' has been registered", name)
+		return
+	}
+	resultWriter, err = factory(destination, settings)
+	if err != nil {
+		err = fmt.Errorf("failed to make a %s result writer for '%s': %w", name, destination, err)
+	}
+	return
+}
+
+// RegisteredResultWriters returns the names of every registered result writer.
+func RegisteredResultWriters() (names []string) {
+	resultWriterRegistryLock.RLock()
+	defer resultWriterRegistryLock.RUnlock()
+	for name := range resultWriterRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// throttledOutput is a local file written at no more than a rate, closed along with it.
+type throttledOutput struct {
+	io.Writer
+	io.Closer
+}
+
+// Complete completes the file under the output.
+func (output throttledOutput) Complete() error {
+	return closeOutput(output.Closer, true)
+}
+
+// createOutput creates a local file to write an output to, which only gets its name once it's complete.
+func createOutput(destination string, settings ResultWriterSettings) (output throttledOutput, err error) {
+	var file io.WriteCloser
+	if settings.SplitSize > 0 {
+		file, err = CreateSplitFile(destination, settings.SplitSize)
+	} else {
+		file, err = CreatePartialFile(destination)
+	}
+	if err != nil {
+		return
+	}
+	output = throttledOutput{Writer: NewThrottledWriter(file, settings.WriteBytesPerSecond), Closer: file}
+	return
+}
+
+func newZipResultWriter(destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error) {
+	output, err := createOutput(destination, settings)
+	if err != nil {
+		return
+	}
+	resultWriter = &ZipResultWriter{ZipWriter: zip.NewWriter(output), FileHandle: output, Codec: settings.Codec, SigningKey: settings.SigningKey}
+	return
+}
+
+func newTarResultWriter(destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error) {
+	output, err := createOutput(destination, settings)
+	if err != nil {
+		return
+	}
+	resultWriter = &TarResultWriter{Output: output, SigningKey: settings.SigningKey}
+	return
+}
+
+func newDirectoryResultWriter(destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error) {
+	resultWriter = &DirectoryResultWriter{Directory: destination, SigningKey: settings.SigningKey}
+	return
+}
+
+func newManifestResultWriter(destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error) {
+	output, err := createOutput(destination, settings)
+	if err != nil {
+		return
+	}
+	resultWriter = &ManifestResultWriter{Output: output}
+	return
+}
+
+func newHttpResultWriter(destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error) {
+	resultWriter = &HttpResultWriter{URL: destination, Header: settings.Header, Codec: settings.Codec}
+	return
+}
+
+func newAzureBlobResultWriter(destination string, settings ResultWriterSettings) (resultWriter ResultWriter, err error) {
+	resultWriter = &AzureBlobResultWriter{BlobURL: destination, Codec: settings.Codec}
+	return
+}
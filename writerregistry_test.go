@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterResultWriter(t *testing.T) {
+	defer func() {
+		resultWriterRegistryLock.Lock()
+		delete(resultWriterRegistry, "s3")
+		resultWriterRegistryLock.Unlock()
+	}()
+	var gotDestination string
+	factory := func(destination string, settings ResultWriterSettings) (ResultWriter, error) {
+		gotDestination = destination
+		return failingResultWriter{}, nil
+	}
+
+	tests := []struct {
+		name    string
+		factory ResultWriterFactory
+		wantErr bool
+	}{
+		{name: "S3", factory: factory},
+		{name: "", factory: factory, wantErr: true},
+		{name: "nothing", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := RegisterResultWriter(tt.name, tt.factory); (err != nil) != tt.wantErr {
+			t.Errorf("RegisterResultWriter(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	resultWriter, err := NewResultWriter("s3", "s3://bucket/collection.zip", ResultWriterSettings{})
+	if err != nil || resultWriter != (failingResultWriter{}) || gotDestination != "s3://bucket/collection.zip" {
+		t.Errorf("NewResultWriter() = %v, %v and gave the factory %q, want the registered result writer", resultWriter, err, gotDestination)
+	}
+	want := []string{"azure", "directory", "http", "manifest", "s3", "tar", "zip"}
+	if got := RegisteredResultWriters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RegisteredResultWriters() = %v, want %v", got, want)
+	}
+}
+
+func TestNewResultWriter(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gofor-registry-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	tests := []struct {
+		name        string
+		kind        string
+		destination string
+		want        interface{}
+		wantErr     string
+	}{
+		{name: "zip", kind: "zip", destination: filepath.Join(directory, "collection.zip"), want: &ZipResultWriter{}},
+		{name: "upper case", kind: "Manifest", destination: filepath.Join(directory, "collection.sha256"), want: &ManifestResultWriter{}},
+		{name: "http", kind: "http", destination: "https://example.com/upload", want: &HttpResultWriter{}},
+		{name: "unknown", kind: "ftp", destination: "ftp://example.com", wantErr: "no result writer named 'ftp'"},
+		{name: "can't create", kind: "tar", destination: filepath.Join(directory, "missing", "collection.tar"), wantErr: "failed to make a tar result writer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resultWriter, err := NewResultWriter(tt.kind, tt.destination, ResultWriterSettings{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NewResultWriter() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewResultWriter() error = %v", err)
+			}
+			if reflect.TypeOf(resultWriter) != reflect.TypeOf(tt.want) {
+				t.Errorf("NewResultWriter() = %T, want %T", resultWriter, tt.want)
+			}
+			if closer, ok := resultWriter.(*ZipResultWriter); ok {
+				_ = closer.FileHandle.Close()
+			}
+			if manifest, ok := resultWriter.(*ManifestResultWriter); ok {
+				_ = manifest.Output.(throttledOutput).Close()
+			}
+		})
+	}
+}
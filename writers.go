@@ -4,72 +4,379 @@ package windowscollector
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	log "github.com/sirupsen/logrus"
+	"hash"
 	"io"
-	"os"
+	"path"
 	"strings"
 	"sync"
+	"time"
 )
 
-type resultWriter interface {
-	ResultWriter(chan fileReader, *sync.WaitGroup) (err error)
+// ResultWriter writes the files of a collection somewhere, such as into a zip or uploaded as one. It reads each
+// CollectedFile from the channel to its end before taking the next, calls Done on the WaitGroup once it's finished,
+// and closes out its output when the context is cancelled. A file it couldn't write is handed back with Failed, one it
+// has safely written can be handed back with Written, and an error it returns, such as when its output couldn't be
+// closed, fails the collection. MultiResultWriter fans the files out to several of them, and RegisterResultWriter
+// makes one available by name.
+type ResultWriter interface {
+	ResultWriter(context.Context, chan CollectedFile, *sync.WaitGroup) (err error)
 }
 
-// ZipResultWriter contains the handles to the file and zip structure
+// CollectedFile is a file handed to a ResultWriter.
+type CollectedFile = fileReader
+
+// ZipResultWriter contains the handles to the file and zip structure. Codec names the registered Codec used for files
+// whose target doesn't pick one, and defaults to deflate. Files are stored under their original directories with the
+// drive letter as the top directory, e.g. c/windows/system32/config/sam, and carry their NTFS timestamps. With a
+// SigningKey the zip ends with the same gofor-index.json of SHA-256 hashes as a tar, and its ed25519 signature, for
+// VerifyZipArchive to check. FileHandle is closed once the zip is, and a PartialFile is only completed when every file
+// made it into the zip.
 type ZipResultWriter struct {
 	ZipWriter  *zip.Writer
-	FileHandle *os.File
+	FileHandle io.Closer
+	Codec      string
+	SigningKey ed25519.PrivateKey
+	Logger     Logger // defaults to logrus' standard logger
+
+	registeredMethods map[uint16]bool
+	entryNames        map[string]bool
+	index             *TarIndex
+	unflushed         fileReader // the last file written, which isn't all in the output until the next entry or the end
 }
 
 type fileReader struct {
 	fullPath string
 	reader   io.Reader
+	codec    string
+	method   string   // how the file is being read, one of the readMethod constants
+	fallback string   // why reading the file raw failed, when it was exported instead
+	links    []string // the file's other paths when it has hard links
+	times    fileTimes
+
+	processors ProcessorChain // the processor chain of the target the file matched, if it has one
+
+	pendingBytes int64           // how much of the file is held in memory until it's written
+	failed       func(err error) // tells the report the result writer couldn't write the file
+	written      func()          // tells the report the result writer has the file safely in its output
+}
+
+// Path is the file's path, e.g. c:\windows\system32\config\sam, or where it goes in the output for what isn't a file
+// on a volume, e.g. report.json.
+func (file fileReader) Path() string {
+	return file.fullPath
+}
+
+// Reader reads the file's content.
+func (file fileReader) Reader() io.Reader {
+	return file.reader
+}
+
+// Codec is the name of the registered Codec the file's target asked for, empty for the result writer's own.
+func (file fileReader) Codec() string {
+	return file.codec
+}
+
+// Links are the file's other paths when it has hard links.
+func (file fileReader) Links() []string {
+	return file.links
+}
+
+// Failed tells the collection that the result writer couldn't write the file, such as when the disk is full, however
+// much of it was read. The report lists it as failed and Collect returns it among its CollectionErrors as a FileError
+// holding a WriteError.
+func (file fileReader) Failed(err error) {
+	if file.failed != nil && err != nil {
+		file.failed(err)
+	}
+}
+
+// Written tells the collection that the result writer has the file safely in its output, such as flushed to its
+// file, so a resumed collection can leave it out. A result writer that can't tell until it's done, such as an upload,
+// doesn't call it. It only counts for a file that was read to its end.
+func (file fileReader) Written() {
+	if file.written != nil {
+		file.written()
+	}
+}
+
+// Times are the file's timestamps from its $STANDARD_INFORMATION, zero when they aren't known.
+func (file fileReader) Times() (created time.Time, modified time.Time, accessed time.Time) {
+	return file.times.created, file.times.modified, file.times.accessed
+}
+
+// fileTimes are the timestamps of a file from its $STANDARD_INFORMATION, zero when they aren't known.
+type fileTimes struct {
+	created  time.Time
+	modified time.Time
+	accessed time.Time
 }
 
-// ResultWriter will export found files to a zip file.
-func (zipResultWriter *ZipResultWriter) ResultWriter(fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
+// ResultWriter will export found files to a zip file. If ctx is cancelled, or reading a file panics, the zip is closed
+// out with whatever has been written so far and isn't completed.
+func (zipResultWriter *ZipResultWriter) ResultWriter(ctx context.Context, fileReaders chan fileReader, waitForFileCopying *sync.WaitGroup) (err error) {
 	defer waitForFileCopying.Done()
+	logger := loggerOrDefault(zipResultWriter.Logger)
+	if zipResultWriter.SigningKey != nil {
+		zipResultWriter.index = &TarIndex{Entries: make([]TarIndexEntry, 0), Tool: currentTool()}
+	}
+	// A panic while reading a file still leaves a zip that can be opened, under its partial name
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			_ = zipResultWriter.writeIndex(false)
+			_ = zipResultWriter.close(false)
+			panic(recovered)
+		}
+	}()
 
 	openChannel := true
 	for openChannel == true {
-		writtenCounter := 0
 		fileReader := fileReader{}
-		fileReader, openChannel = <-fileReaders
+		select {
+		case fileReader, openChannel = <-fileReaders:
+		case <-ctx.Done():
+			logger.Debugf("Collection was cancelled, closing the zip file: %v", ctx.Err())
+			_ = zipResultWriter.writeIndex(false)
+			_ = zipResultWriter.close(false)
+			err = ctx.Err()
+			return
+		}
 		if openChannel == false {
 			break
 		}
-		normalizedFilePath := strings.ReplaceAll(fileReader.fullPath, "\\", "_")
-		normalizedFilePath = strings.ReplaceAll(normalizedFilePath, ":", "_")
+		err = zipResultWriter.writeEntry(logger, fileReader)
+		if err != nil {
+			fileReader.Failed(err)
+			err = fmt.Errorf("resultWriter failed to write '%s' to the output zip: %w", fileReader.fullPath, err)
+			_ = zipResultWriter.close(false)
+			return
+		}
+	}
+	// A collection that failed is cancelled before the files run out
+	if ctx.Err() != nil {
+		_ = zipResultWriter.writeIndex(false)
+		_ = zipResultWriter.close(false)
+		err = ctx.Err()
+		return
+	}
+	err = zipResultWriter.writeIndex(true)
+	if err != nil {
+		err = fmt.Errorf("resultWriter failed to write the index to the output zip: %w", err)
+		_ = zipResultWriter.close(false)
+		return
+	}
+	// Closing writes the zip's central directory, without which it can't be opened
+	err = zipResultWriter.close(true)
+	if err != nil {
+		err = fmt.Errorf("resultWriter failed to finish the output zip: %w", err)
+	}
+	return
+}
+
+// writeEntry copies a file into the zip. Failing to read the file is only logged and noted in the index, since the
+// reader has already told the report, but failing to write it means the zip is broken from here on.
+func (zipResultWriter *ZipResultWriter) writeEntry(logger Logger, fileReader fileReader) (err error) {
+	if zipResultWriter.entryNames == nil {
+		zipResultWriter.entryNames = make(map[string]bool)
+	}
+	entryName := uniqueEntryName(zipResultWriter.entryNames, treeEntryName(fileReader.fullPath))
+	writer, err := zipResultWriter.createEntry(entryName, fileReader.codec, fileReader.times)
+	if err != nil {
+		err = fmt.Errorf("failed to add an entry: %w", err)
+		return
+	}
+	// Adding an entry finishes the one before it, which is written once it's flushed
+	if err = zipResultWriter.ZipWriter.Flush(); err != nil {
+		return
+	}
+	zipResultWriter.unflushed.Written()
+	zipResultWriter.unflushed = fileReader
+	var entryHash hash.Hash
+	if zipResultWriter.index != nil {
+		entryHash = sha256.New()
+		writer = io.MultiWriter(writer, entryHash)
+	}
+	writtenCounter := int64(0)
+	buffer := make([]byte, 32*1024)
+	var readErr error
+	for readErr == nil {
+		var numberOfBytesRead int
+		numberOfBytesRead, readErr = fileReader.reader.Read(buffer)
+		if numberOfBytesRead == 0 {
+			continue
+		}
+		bytesWritten, writeErr := writer.Write(buffer[:numberOfBytesRead])
+		writtenCounter += int64(bytesWritten)
+		if writeErr != nil {
+			err = writeErr
+			return
+		}
+	}
+	if readErr == io.EOF {
+		logger.Debugf("Successfully collected '%s'", fileReader.fullPath)
+	} else {
+		logger.Debugf("Failed to collect '%s' due to %v", fileReader.fullPath, readErr)
+	}
+	if entryHash != nil {
+		entry := TarIndexEntry{Name: entryName, Links: fileReader.links, Size: writtenCounter, SHA256: hex.EncodeToString(entryHash.Sum(nil))}
+		if readErr != io.EOF {
+			entry.Error = readErr.Error()
+		}
+		zipResultWriter.index.Entries = append(zipResultWriter.index.Entries, entry)
+	}
+	return
+}
+
+// close finishes the zip and closes the file under it, if there is one, completing it when the zip is complete.
+func (zipResultWriter *ZipResultWriter) close(complete bool) (err error) {
+	err = zipResultWriter.ZipWriter.Close()
+	if err == nil {
+		zipResultWriter.unflushed.Written()
+	}
+	zipResultWriter.unflushed = fileReader{}
+	if zipResultWriter.FileHandle != nil {
+		if closeErr := closeOutput(zipResultWriter.FileHandle, complete && err == nil); err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// writeIndex ends the zip with the index and its signature when there's a signing key.
+func (zipResultWriter *ZipResultWriter) writeIndex(complete bool) (err error) {
+	if zipResultWriter.index == nil {
+		return
+	}
+	zipResultWriter.index.Complete = complete
+	indexData, err := json.MarshalIndent(zipResultWriter.index, "", "  ")
+	if err != nil {
+		return
+	}
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{name: tarIndexFileName, data: indexData},
+		{name: tarSignatureFileName, data: ed25519.Sign(zipResultWriter.SigningKey, indexData)},
+	} {
 		var writer io.Writer
-		writer, err = zipResultWriter.ZipWriter.Create(normalizedFilePath)
+		writer, err = zipResultWriter.ZipWriter.Create(file.name)
 		if err != nil {
-			err = fmt.Errorf("resultWriter failed to add a file to the output zip: %w", err)
-			zipResultWriter.ZipWriter.Close()
-			zipResultWriter.FileHandle.Close()
 			return
 		}
-		var readErr error
-		for {
-			buffer := make([]byte, 1024)
-			_, readErr = fileReader.reader.Read(buffer)
-			if readErr != nil {
-				break
-			}
-			bytesWritten, writeErr := writer.Write(buffer)
-			if writeErr != nil {
-				log.Panic(writeErr)
-			}
-			writtenCounter += bytesWritten
+		_, err = writer.Write(file.data)
+		if err != nil {
+			return
 		}
-		if readErr == io.EOF {
-			log.Debugf("Successfully collected '%s'", fileReader.fullPath)
-		} else {
-			log.Debugf("Failed to collect '%s' due to %v", fileReader.fullPath, readErr)
+	}
+	return
+}
+
+// createEntry adds a file to the zip using the target's codec, or the writer's codec if the target didn't pick one.
+func (zipResultWriter *ZipResultWriter) createEntry(name string, codecName string, times fileTimes) (writer io.Writer, err error) {
+	if codecName == "" {
+		codecName = zipResultWriter.Codec
+	}
+	codec, err := LookupCodec(codecName)
+	if err != nil {
+		return
+	}
+
+	// Codecs added through RegisterCodec need their compressor registered on this particular zip
+	if codec.Compressor != nil && !zipResultWriter.registeredMethods[codec.Method] {
+		if zipResultWriter.registeredMethods == nil {
+			zipResultWriter.registeredMethods = make(map[uint16]bool)
 		}
+		zipResultWriter.ZipWriter.RegisterCompressor(codec.Method, codec.Compressor)
+		zipResultWriter.registeredMethods[codec.Method] = true
+	}
+
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: codec.Method,
+	}
+	// The zip package adds an extended timestamp field with the modification time on its own, the NTFS field keeps
+	// the creation and access times as well at full precision
+	if !times.modified.IsZero() {
+		header.Modified = times.modified.UTC()
+		header.Extra = ntfsExtraField(times)
 	}
-	zipResultWriter.ZipWriter.Close()
-	zipResultWriter.FileHandle.Close()
-	err = nil
+	writer, err = zipResultWriter.ZipWriter.CreateHeader(header)
 	return
 }
+
+// ntfsExtraField builds the zip extra field that holds a file's NTFS timestamps as FILETIMEs.
+func ntfsExtraField(times fileTimes) (field []byte) {
+	const (
+		tagNtfs       = 0x000a
+		tagTimestamps = 0x0001
+	)
+	field = make([]byte, 36)
+	binary.LittleEndian.PutUint16(field[0:], tagNtfs)
+	binary.LittleEndian.PutUint16(field[2:], 32)
+	binary.LittleEndian.PutUint16(field[8:], tagTimestamps)
+	binary.LittleEndian.PutUint16(field[10:], 24)
+	binary.LittleEndian.PutUint64(field[12:], toFiletime(times.modified))
+	binary.LittleEndian.PutUint64(field[20:], toFiletime(times.accessed))
+	binary.LittleEndian.PutUint64(field[28:], toFiletime(times.created))
+	return
+}
+
+// toFiletime converts a time into the number of 100 nanosecond intervals since January 1, 1601.
+func toFiletime(t time.Time) uint64 {
+	const (
+		secondsFrom1601To1970 = 11644473600
+		intervalsPerSecond    = 10000000
+	)
+	if t.IsZero() {
+		return 0
+	}
+	return uint64((t.Unix()+secondsFrom1601To1970)*intervalsPerSecond + int64(t.Nanosecond()/100))
+}
+
+// treeEntryName turns a full path into the name of its entry in outputs that keep directories, with the drive letter as
+// the top directory, e.g. c:\windows\file becomes c/windows/file. Files that aren't from a volume, such as report.json,
+// stay at the root.
+func treeEntryName(fullPath string) string {
+	name := fullPath
+	if len(name) >= 2 && name[1] == ':' {
+		name = name[:1] + name[2:]
+	} else if strings.HasPrefix(name, espVolume+":") {
+		name = espVolume + name[len(espVolume)+1:]
+	}
+	// Colons are left in alternate data stream names, which most zip tools can't extract on Windows
+	name = strings.ReplaceAll(name, ":", "_")
+	name = strings.ReplaceAll(name, `\`, "/")
+	return strings.TrimLeft(name, "/")
+}
+
+// uniqueEntryName returns name, or name with a number before its extension when an entry by that name is already in
+// entryNames, e.g. c/windows/file (2).txt, and adds it to entryNames. The same file can be collected twice, such as when
+// a hive is exported and its file also matches another target.
+func uniqueEntryName(entryNames map[string]bool, name string) (unique string) {
+	unique = name
+	extension := path.Ext(name)
+	if path.Base(name) == extension {
+		// A name such as .bashrc is all extension
+		extension = ""
+	}
+	for counter := 2; entryNames[strings.ToLower(unique)]; counter++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, extension), counter, extension)
+	}
+	entryNames[strings.ToLower(unique)] = true
+	return
+}
+
+// normalizeFilePath turns a full path into a flat name for an entry in the tar output, e.g. c:\windows\file becomes
+// c__windows_file.
+func normalizeFilePath(fullPath string) string {
+	normalizedFilePath := strings.ReplaceAll(fullPath, "\\", "_")
+	normalizedFilePath = strings.ReplaceAll(normalizedFilePath, ":", "_")
+	return normalizedFilePath
+}
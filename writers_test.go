@@ -5,12 +5,19 @@ package windowscollector
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"io"
+	"io/ioutil"
 	"os"
 	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestZipResultWriter_ResultWriter(t *testing.T) {
@@ -42,7 +49,7 @@ func TestZipResultWriter_ResultWriter(t *testing.T) {
 			dummyData:         []byte{0x00, 0x00, 0x00},
 			listOfFileReaders: []fileReader{},
 			zipToCreate:       `test\testdata\test.zip`,
-			wantZipHash:       "84a75bc35ad74c12cf7225a0fe802f07",
+			wantZipHash:       "69a9ce6d43f0bde724926845a86a9bd7",
 		},
 	}
 	for _, tt := range tests {
@@ -60,7 +67,7 @@ func TestZipResultWriter_ResultWriter(t *testing.T) {
 			tt.args.waitForFileCopying.Add(1)
 			channel := make(chan fileReader, 0)
 			tt.args.fileReaders = channel
-			go tt.zipResultWriter.ResultWriter(tt.args.fileReaders, tt.args.waitForFileCopying)
+			go tt.zipResultWriter.ResultWriter(context.Background(), tt.args.fileReaders, tt.args.waitForFileCopying)
 			for _, each := range tt.listOfFileReaders {
 				tt.args.fileReaders <- each
 			}
@@ -80,3 +87,173 @@ func TestZipResultWriter_ResultWriter(t *testing.T) {
 		})
 	}
 }
+
+func Test_treeEntryName(t *testing.T) {
+	tests := []struct {
+		name     string
+		fullPath string
+		want     string
+	}{
+		{name: "file on a volume", fullPath: `c:\windows\system32\config\sam`, want: "c/windows/system32/config/sam"},
+		{name: "mft", fullPath: `d:\$mft`, want: "d/$mft"},
+		{name: "efi system partition", fullPath: `esp:\efi\microsoft\boot\bcd`, want: "esp/efi/microsoft/boot/bcd"},
+		{name: "alternate data stream", fullPath: `c:\users\file.txt:zone.identifier`, want: "c/users/file.txt_zone.identifier"},
+		{name: "collection metadata", fullPath: reportFileName, want: reportFileName},
+		{name: "cyrillic profile", fullPath: `c:\users\иван\ntuser.dat`, want: "c/users/иван/ntuser.dat"},
+		{name: "cjk profile", fullPath: `c:\users\山田\appdata\local\microsoft\windows\usrclass.dat`, want: "c/users/山田/appdata/local/microsoft/windows/usrclass.dat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := treeEntryName(tt.fullPath); got != tt.want {
+				t.Errorf("treeEntryName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_uniqueEntryName(t *testing.T) {
+	entryNames := make(map[string]bool)
+	for _, want := range []string{"c/file.txt", "c/file (2).txt", "c/file (3).txt"} {
+		if got := uniqueEntryName(entryNames, "c/file.txt"); got != want {
+			t.Errorf("uniqueEntryName() = %s, want %s", got, want)
+		}
+	}
+	if got := uniqueEntryName(entryNames, "c/users/.profile"); got != "c/users/.profile" {
+		t.Errorf("uniqueEntryName() = %s, want c/users/.profile", got)
+	}
+	if got := uniqueEntryName(entryNames, "c/users/.profile"); got != "c/users/.profile (2)" {
+		t.Errorf("uniqueEntryName() = %s, want c/users/.profile (2)", got)
+	}
+}
+
+func TestZipResultWriter_timestamps(t *testing.T) {
+	times := fileTimes{
+		created:  time.Date(2019, 3, 19, 4, 37, 22, 64292900, time.UTC),
+		modified: time.Date(2019, 11, 21, 0, 25, 55, 780858200, time.UTC),
+		accessed: time.Date(2019, 11, 22, 8, 0, 0, 0, time.UTC),
+	}
+	output := new(bytes.Buffer)
+	zipResultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+	fileReaders := make(chan fileReader, 2)
+	fileReaders <- fileReader{fullPath: `c:\windows\file`, reader: bytes.NewReader([]byte("data")), times: times}
+	fileReaders <- fileReader{fullPath: `c:\windows\file`, reader: bytes.NewReader([]byte("data"))}
+	close(fileReaders)
+	waitForFileCopying := sync.WaitGroup{}
+	waitForFileCopying.Add(1)
+	if err := zipResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+		t.Fatalf("ZipResultWriter.ResultWriter() error = %v", err)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+	if len(zipReader.File) != 2 || zipReader.File[0].Name != "c/windows/file" || zipReader.File[1].Name != "c/windows/file (2)" {
+		t.Fatalf("ZipResultWriter.ResultWriter() wrote %d entries, want c/windows/file and c/windows/file (2)", len(zipReader.File))
+	}
+	entry := zipReader.File[0]
+	if !entry.Modified.Equal(times.modified.Truncate(time.Second)) {
+		t.Errorf("entry modified = %v, want %v", entry.Modified, times.modified)
+	}
+	var created uint64
+	for extra := entry.Extra; len(extra) >= 4; {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if tag == 0x000a && size >= 32 {
+			created = binary.LittleEndian.Uint64(extra[4+24:])
+		}
+		extra = extra[4+size:]
+	}
+	if created != toFiletime(times.created) {
+		t.Errorf("entry NTFS created time = %d, want %d", created, toFiletime(times.created))
+	}
+}
+
+func TestZipResultWriter_content(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 10000)
+	tests := []struct {
+		name   string
+		reader io.Reader
+		want   []byte
+	}{
+		{name: "shorter than the buffer", reader: bytes.NewReader([]byte{0x01, 0x02, 0x03}), want: []byte{0x01, 0x02, 0x03}},
+		{name: "several reads", reader: bytes.NewReader(big), want: big},
+		{name: "one byte at a time", reader: iotest.OneByteReader(bytes.NewReader([]byte("regf"))), want: []byte("regf")},
+		{name: "data along with EOF", reader: iotest.DataErrReader(bytes.NewReader([]byte("regf"))), want: []byte("regf")},
+		{name: "empty", reader: bytes.NewReader(nil), want: []byte{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := new(bytes.Buffer)
+			zipResultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(output)}
+			fileReaders := make(chan fileReader, 1)
+			fileReaders <- fileReader{fullPath: `c:\file`, reader: tt.reader}
+			close(fileReaders)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			if err := zipResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying); err != nil {
+				t.Fatalf("ZipResultWriter.ResultWriter() error = %v", err)
+			}
+			zipReader, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+			if err != nil {
+				t.Fatalf("zip.NewReader() error = %v", err)
+			}
+			entry, _ := zipReader.File[0].Open()
+			defer entry.Close()
+			got, _ := ioutil.ReadAll(entry)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ZipResultWriter.ResultWriter() wrote %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+// fullDisk takes a number of bytes and then fails every write, like a disk that fills up.
+type fullDisk struct {
+	remaining int
+}
+
+func (disk *fullDisk) Write(data []byte) (numberOfBytesWritten int, err error) {
+	if len(data) > disk.remaining {
+		numberOfBytesWritten = disk.remaining
+		disk.remaining = 0
+		err = errors.New("there is not enough space on the disk")
+		return
+	}
+	disk.remaining -= len(data)
+	numberOfBytesWritten = len(data)
+	return
+}
+
+func TestZipResultWriter_writeFails(t *testing.T) {
+	big := make([]byte, 1024*1024)
+	_, _ = rand.Read(big)
+	tests := []struct {
+		name       string
+		space      int
+		data       []byte
+		wantErr    string
+		wantFailed bool
+	}{
+		{name: "fills up writing a file", space: 64 * 1024, data: big, wantErr: `resultWriter failed to write 'c:\file' to the output zip: there is not enough space on the disk`, wantFailed: true},
+		{name: "fills up finishing the zip", space: 100, data: []byte("regf"), wantErr: "resultWriter failed to finish the output zip: there is not enough space on the disk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zipResultWriter := ZipResultWriter{ZipWriter: zip.NewWriter(&fullDisk{remaining: tt.space}), Codec: "store"}
+			var failed error
+			fileReaders := make(chan fileReader, 1)
+			fileReaders <- fileReader{fullPath: `c:\file`, reader: bytes.NewReader(tt.data), failed: func(err error) { failed = err }}
+			close(fileReaders)
+			waitForFileCopying := sync.WaitGroup{}
+			waitForFileCopying.Add(1)
+			err := zipResultWriter.ResultWriter(context.Background(), fileReaders, &waitForFileCopying)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ZipResultWriter.ResultWriter() error = %v, want %s", err, tt.wantErr)
+			}
+			if (failed != nil) != tt.wantFailed {
+				t.Errorf("ZipResultWriter.ResultWriter() handed back %v for the file, want it to fail: %v", failed, tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,1285 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	yaraBlockSize      = 1024 * 1024 // files are scanned a block at a time, so they're never held in memory whole
+	yaraHeaderSize     = 64 * 1024   // how much of the start of a file uint16(0) and the like can read
+	yaraMaxMatchLength = 64 * 1024   // how far regular expressions and unbounded jumps can reach past a block
+	yaraMaxOffsets     = 10          // how many offsets of each string's matches are kept
+	yaraUndefined      = math.MinInt64
+)
+
+// YaraRules are YARA rules compiled to scan collected files with. They're matched by a YARA engine written in Go, so
+// the collector needs no libyara, that supports the parts of the language sweeps mostly use: text strings with the
+// nocase, wide, ascii, fullword and private modifiers, hex strings with wildcards, jumps and alternatives, regular
+// expressions, and conditions of and, or, not, comparisons, + and -, string counts and offsets, filesize, the
+// uint8(0)-style integer reads within the first 64 KiB, "of" sets, and other rules. Modules, loops and the xor and base64
+// modifiers aren't supported, and rules that use them fail to parse. Regular expressions use Go's syntax and only match
+// bytes above 0x7f as part of UTF-8.
+type YaraRules struct {
+	rules     []*yaraRule
+	strings   []*yaraString
+	names     map[string]int
+	maxLength int
+}
+
+type yaraRule struct {
+	name      string
+	tags      []string
+	meta      map[string]interface{}
+	private   bool
+	global    bool
+	strings   []int // indexes into YaraRules.strings
+	condition yaraExpr
+}
+
+type yaraString struct {
+	id        string
+	literals  []yaraLiteral
+	nocase    bool
+	fullword  bool
+	private   bool
+	hex       []yaraHexToken
+	regex     *regexp.Regexp
+	maxLength int
+}
+
+// yaraLiteral is a text string in one of its encodings.
+type yaraLiteral struct {
+	data []byte
+	wide bool
+}
+
+// yaraHexToken is a byte of a hex string, matching bytes where byte&mask == value, a jump, or alternatives.
+type yaraHexToken struct {
+	value, mask      byte
+	jump             bool
+	minJump, maxJump int
+	alternatives     [][]yaraHexToken
+}
+
+// YaraMatch is a YARA rule that matched a collected file.
+type YaraMatch struct {
+	Path    string                 `json:"path,omitempty"`
+	Rule    string                 `json:"rule"`
+	Tags    []string               `json:"tags,omitempty"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Strings []YaraStringMatch      `json:"strings,omitempty"`
+}
+
+// YaraStringMatch is where one of a matching rule's strings was found.
+type YaraStringMatch struct {
+	ID      string  `json:"id"`
+	Count   int     `json:"count"`
+	Offsets []int64 `json:"offsets"` // of the first matches
+}
+
+// ParseYaraRules compiles the source of YARA rules.
+func ParseYaraRules(source string) (rules *YaraRules, err error) {
+	rules = &YaraRules{names: make(map[string]int)}
+	if err = rules.parse("", source); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// LoadYaraRules compiles the YARA rules in a file, or in the .yar and .yara files in a directory.
+func LoadYaraRules(path string) (rules *YaraRules, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		err = fmt.Errorf("failed to open the YARA rules: %w", err)
+		return
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		paths = nil
+		for _, pattern := range []string{"*.yar", "*.yara"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			paths = append(paths, matches...)
+		}
+		if len(paths) == 0 {
+			err = fmt.Errorf("there are no .yar or .yara files in %s", path)
+			return
+		}
+	}
+	rules = &YaraRules{names: make(map[string]int)}
+	for _, rulesPath := range paths {
+		var source []byte
+		if source, err = ioutil.ReadFile(rulesPath); err != nil {
+			err = fmt.Errorf("failed to read the YARA rules: %w", err)
+			return nil, err
+		}
+		if err = rules.parse(filepath.Base(rulesPath), string(source)); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// yaraParser parses the source of YARA rules. The language needs to know where it is to tell a hex string from a
+// block, so it's parsed straight from the source rather than from tokens.
+type yaraParser struct {
+	name     string
+	source   string
+	position int
+	rules    *YaraRules
+	rule     *yaraRule
+	ids      map[string]int // the rule's strings by id
+}
+
+func (rules *YaraRules) parse(name string, source string) (err error) {
+	parser := yaraParser{name: name, source: source, rules: rules}
+	for {
+		parser.skipSpace()
+		if parser.done() {
+			return
+		}
+		if err = parser.parseDeclaration(); err != nil {
+			return
+		}
+	}
+}
+
+func (parser *yaraParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(parser.source[:parser.position], "\n") + 1
+	where := fmt.Sprintf("line %d", line)
+	if parser.name != "" {
+		where = fmt.Sprintf("%s line %d", parser.name, line)
+	}
+	return fmt.Errorf("YARA rules %s: %s", where, fmt.Sprintf(format, args...))
+}
+
+func (parser *yaraParser) done() bool {
+	return parser.position >= len(parser.source)
+}
+
+func (parser *yaraParser) peek() byte {
+	if parser.done() {
+		return 0
+	}
+	return parser.source[parser.position]
+}
+
+// skipSpace skips whitespace and comments.
+func (parser *yaraParser) skipSpace() {
+	for !parser.done() {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(parser.peek())):
+			parser.position++
+		case strings.HasPrefix(parser.source[parser.position:], "//"):
+			if end := strings.IndexByte(parser.source[parser.position:], '\n'); end >= 0 {
+				parser.position += end
+			} else {
+				parser.position = len(parser.source)
+			}
+		case strings.HasPrefix(parser.source[parser.position:], "/*"):
+			if end := strings.Index(parser.source[parser.position+2:], "*/"); end >= 0 {
+				parser.position += end + 4
+			} else {
+				parser.position = len(parser.source)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isYaraIdentifierByte(character byte) bool {
+	return character == '_' || character >= 'a' && character <= 'z' || character >= 'A' && character <= 'Z' || character >= '0' && character <= '9'
+}
+
+// identifier reads an identifier, keyword or number, which may be empty.
+func (parser *yaraParser) identifier() string {
+	parser.skipSpace()
+	start := parser.position
+	for !parser.done() && isYaraIdentifierByte(parser.peek()) {
+		parser.position++
+	}
+	return parser.source[start:parser.position]
+}
+
+// lookahead returns the next identifier without reading it.
+func (parser *yaraParser) lookahead() string {
+	position := parser.position
+	identifier := parser.identifier()
+	parser.position = position
+	return identifier
+}
+
+// accept reads symbol if it's next.
+func (parser *yaraParser) accept(symbol string) bool {
+	parser.skipSpace()
+	if strings.HasPrefix(parser.source[parser.position:], symbol) {
+		parser.position += len(symbol)
+		return true
+	}
+	return false
+}
+
+func (parser *yaraParser) expect(symbol string) error {
+	if !parser.accept(symbol) {
+		return parser.errorf("expected '%s'", symbol)
+	}
+	return nil
+}
+
+func (parser *yaraParser) parseDeclaration() (err error) {
+	rule := &yaraRule{meta: make(map[string]interface{})}
+	for {
+		switch keyword := parser.identifier(); keyword {
+		case "import", "include":
+			return parser.errorf("%s isn't supported", keyword)
+		case "private":
+			rule.private = true
+		case "global":
+			rule.global = true
+		case "rule":
+			return parser.parseRule(rule)
+		default:
+			return parser.errorf("expected a rule, not '%s'", keyword)
+		}
+	}
+}
+
+func (parser *yaraParser) parseRule(rule *yaraRule) (err error) {
+	if rule.name = parser.identifier(); rule.name == "" {
+		return parser.errorf("the rule has no name")
+	}
+	if _, exists := parser.rules.names[rule.name]; exists {
+		return parser.errorf("rule %s is defined twice", rule.name)
+	}
+	if parser.accept(":") {
+		for parser.lookahead() != "" {
+			rule.tags = append(rule.tags, parser.identifier())
+		}
+	}
+	if err = parser.expect("{"); err != nil {
+		return
+	}
+	parser.rule, parser.ids = rule, make(map[string]int)
+	for {
+		section := parser.identifier()
+		if err = parser.expect(":"); err != nil {
+			return
+		}
+		switch section {
+		case "meta":
+			err = parser.parseMeta()
+		case "strings":
+			err = parser.parseStrings()
+		case "condition":
+			if rule.condition, err = parser.parseOr(); err == nil {
+				err = parser.expect("}")
+			}
+			if err == nil {
+				parser.rules.names[rule.name] = len(parser.rules.rules)
+				parser.rules.rules = append(parser.rules.rules, rule)
+			}
+			return
+		default:
+			return parser.errorf("unknown section '%s' in rule %s", section, rule.name)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseMeta parses name = value pairs up to the next section.
+func (parser *yaraParser) parseMeta() (err error) {
+	for {
+		position := parser.position
+		name := parser.identifier()
+		if !parser.accept("=") {
+			parser.position = position
+			return
+		}
+		parser.skipSpace()
+		switch {
+		case parser.peek() == '"':
+			var value []byte
+			if value, err = parser.parseText(); err != nil {
+				return
+			}
+			parser.rule.meta[name] = string(value)
+		default:
+			value := parser.identifier()
+			if value == "" && parser.accept("-") {
+				value = "-" + parser.identifier()
+			}
+			if value == "true" || value == "false" {
+				parser.rule.meta[name] = value == "true"
+			} else if number, parseErr := strconv.ParseInt(value, 0, 64); parseErr == nil {
+				parser.rule.meta[name] = number
+			} else {
+				return parser.errorf("meta %s has an invalid value", name)
+			}
+		}
+	}
+}
+
+// parseStrings parses $id = string modifiers up to the condition.
+func (parser *yaraParser) parseStrings() (err error) {
+	for {
+		parser.skipSpace()
+		if parser.peek() != '$' {
+			return
+		}
+		parser.position++
+		str := &yaraString{id: "$" + parser.identifier()}
+		if str.id == "$" {
+			str.id = fmt.Sprintf("$%d", len(parser.rule.strings))
+		} else if _, exists := parser.ids[str.id]; exists {
+			return parser.errorf("string %s is defined twice in rule %s", str.id, parser.rule.name)
+		}
+		if err = parser.expect("="); err != nil {
+			return
+		}
+		parser.skipSpace()
+		switch parser.peek() {
+		case '"':
+			var text []byte
+			if text, err = parser.parseText(); err != nil {
+				return
+			}
+			err = parser.parseTextModifiers(str, text)
+		case '{':
+			parser.position++
+			if str.hex, err = parser.parseHex(0); err != nil {
+				return
+			}
+			if len(str.hex) == 0 || str.hex[0].jump || str.hex[len(str.hex)-1].jump {
+				return parser.errorf("hex string %s can't start or end with a jump", str.id)
+			}
+			str.maxLength = yaraHexMaxLength(str.hex)
+			err = parser.parseOtherModifiers(str, false)
+		case '/':
+			err = parser.parseRegex(str)
+		default:
+			err = parser.errorf("string %s has no value", str.id)
+		}
+		if err != nil {
+			return
+		}
+		parser.ids[str.id] = len(parser.rules.strings)
+		parser.rule.strings = append(parser.rule.strings, len(parser.rules.strings))
+		parser.rules.strings = append(parser.rules.strings, str)
+		if str.maxLength > parser.rules.maxLength {
+			parser.rules.maxLength = str.maxLength
+		}
+	}
+}
+
+// parseText parses a quoted string with its escapes.
+func (parser *yaraParser) parseText() (text []byte, err error) {
+	parser.position++
+	for {
+		if parser.done() || parser.peek() == '\n' {
+			return nil, parser.errorf("unterminated string")
+		}
+		character := parser.peek()
+		parser.position++
+		switch character {
+		case '"':
+			return
+		case '\\':
+			escape := parser.peek()
+			parser.position++
+			switch escape {
+			case 'n':
+				text = append(text, '\n')
+			case 't':
+				text = append(text, '\t')
+			case 'r':
+				text = append(text, '\r')
+			case 'x':
+				if parser.position+2 > len(parser.source) {
+					return nil, parser.errorf("invalid \\x escape")
+				}
+				value, parseErr := strconv.ParseUint(parser.source[parser.position:parser.position+2], 16, 8)
+				if parseErr != nil {
+					return nil, parser.errorf("invalid \\x escape")
+				}
+				text = append(text, byte(value))
+				parser.position += 2
+			default:
+				text = append(text, escape)
+			}
+		default:
+			text = append(text, character)
+		}
+	}
+}
+
+func (parser *yaraParser) parseTextModifiers(str *yaraString, text []byte) (err error) {
+	if len(text) == 0 {
+		return parser.errorf("string %s is empty", str.id)
+	}
+	ascii, wide := false, false
+	for {
+		switch modifier := parser.lookahead(); modifier {
+		case "ascii":
+			ascii = true
+		case "wide":
+			wide = true
+		case "nocase", "fullword", "private":
+			if err = parser.parseOtherModifiers(str, true); err != nil {
+				return
+			}
+			continue
+		case "xor", "base64", "base64wide":
+			return parser.errorf("the %s modifier isn't supported", modifier)
+		default:
+			if ascii || !wide {
+				str.literals = append(str.literals, yaraLiteral{data: text})
+			}
+			if wide {
+				widened := make([]byte, 0, len(text)*2)
+				for _, character := range text {
+					widened = append(widened, character, 0)
+				}
+				str.literals = append(str.literals, yaraLiteral{data: widened, wide: true})
+			}
+			for index := range str.literals {
+				if str.nocase {
+					str.literals[index].data = yaraLower(str.literals[index].data)
+				}
+				if len(str.literals[index].data) > str.maxLength {
+					str.maxLength = len(str.literals[index].data)
+				}
+			}
+			return
+		}
+		parser.identifier()
+	}
+}
+
+// parseOtherModifiers parses the nocase, fullword and private modifiers, stopping at the first other one when
+// untilOther is set.
+func (parser *yaraParser) parseOtherModifiers(str *yaraString, untilOther bool) (err error) {
+	for {
+		switch modifier := parser.lookahead(); modifier {
+		case "nocase":
+			if str.hex != nil {
+				return parser.errorf("hex string %s can't be nocase", str.id)
+			}
+			str.nocase = true
+		case "fullword":
+			str.fullword = true
+		case "private":
+			str.private = true
+		case "ascii", "wide", "xor", "base64", "base64wide":
+			if untilOther {
+				return
+			}
+			return parser.errorf("the %s modifier isn't supported on string %s", modifier, str.id)
+		default:
+			return
+		}
+		parser.identifier()
+		if untilOther {
+			return
+		}
+	}
+}
+
+// parseHex parses a hex string up to its closing brace, or the closing parenthesis of alternatives when depth is set.
+func (parser *yaraParser) parseHex(depth int) (tokens []yaraHexToken, err error) {
+	for {
+		parser.skipSpace()
+		if parser.done() {
+			return nil, parser.errorf("unterminated hex string")
+		}
+		switch character := parser.peek(); {
+		case character == '}' && depth == 0:
+			parser.position++
+			return
+		case (character == '|' || character == ')') && depth > 0:
+			return
+		case character == '[':
+			end := strings.IndexByte(parser.source[parser.position:], ']')
+			if end < 0 {
+				return nil, parser.errorf("unterminated jump")
+			}
+			token := yaraHexToken{jump: true}
+			jump := strings.TrimSpace(parser.source[parser.position+1 : parser.position+end])
+			bounds := strings.SplitN(jump, "-", 2)
+			token.minJump, _ = strconv.Atoi(strings.TrimSpace(bounds[0]))
+			token.maxJump = token.minJump
+			if len(bounds) == 2 {
+				if token.maxJump, err = strconv.Atoi(strings.TrimSpace(bounds[1])); strings.TrimSpace(bounds[1]) == "" {
+					token.maxJump, err = yaraMaxMatchLength, nil
+				}
+			} else if _, err = strconv.Atoi(jump); err != nil {
+				return nil, parser.errorf("invalid jump [%s]", jump)
+			}
+			if err != nil || token.maxJump < token.minJump {
+				return nil, parser.errorf("invalid jump [%s]", jump)
+			}
+			tokens = append(tokens, token)
+			parser.position += end + 1
+		case character == '(':
+			parser.position++
+			token := yaraHexToken{}
+			for {
+				var alternative []yaraHexToken
+				if alternative, err = parser.parseHex(depth + 1); err != nil {
+					return
+				}
+				token.alternatives = append(token.alternatives, alternative)
+				if parser.accept(")") {
+					break
+				}
+				if err = parser.expect("|"); err != nil {
+					return
+				}
+			}
+			tokens = append(tokens, token)
+		default:
+			if parser.position+2 > len(parser.source) {
+				return nil, parser.errorf("unterminated hex string")
+			}
+			token := yaraHexToken{}
+			for index, nibble := range parser.source[parser.position : parser.position+2] {
+				shift := uint(4 - index*4)
+				if nibble == '?' {
+					continue
+				}
+				value, parseErr := strconv.ParseUint(string(nibble), 16, 8)
+				if parseErr != nil {
+					return nil, parser.errorf("invalid hex byte '%s'", parser.source[parser.position:parser.position+2])
+				}
+				token.value |= byte(value) << shift
+				token.mask |= 0xf << shift
+			}
+			tokens = append(tokens, token)
+			parser.position += 2
+		}
+	}
+}
+
+// yaraHexMaxLength is the longest a match of a hex string can be.
+func yaraHexMaxLength(tokens []yaraHexToken) (length int) {
+	for _, token := range tokens {
+		switch {
+		case token.jump:
+			length += token.maxJump
+		case token.alternatives != nil:
+			longest := 0
+			for _, alternative := range token.alternatives {
+				if alternativeLength := yaraHexMaxLength(alternative); alternativeLength > longest {
+					longest = alternativeLength
+				}
+			}
+			length += longest
+		default:
+			length++
+		}
+	}
+	return
+}
+
+func (parser *yaraParser) parseRegex(str *yaraString) (err error) {
+	parser.position++
+	var expression strings.Builder
+	for {
+		if parser.done() || parser.peek() == '\n' {
+			return parser.errorf("unterminated regular expression")
+		}
+		character := parser.peek()
+		parser.position++
+		if character == '/' {
+			break
+		}
+		if character == '\\' && parser.peek() == '/' {
+			character = '/'
+			parser.position++
+		} else if character == '\\' {
+			expression.WriteByte(character)
+			character = parser.peek()
+			parser.position++
+		}
+		expression.WriteByte(character)
+	}
+	flags := ""
+	for !parser.done() && (parser.peek() == 'i' || parser.peek() == 's') {
+		flags += string(parser.peek())
+		parser.position++
+	}
+	if err = parser.parseOtherModifiers(str, false); err != nil {
+		return
+	}
+	if str.nocase && !strings.Contains(flags, "i") {
+		flags += "i"
+	}
+	source := expression.String()
+	if flags != "" {
+		source = "(?" + flags + ")" + source
+	}
+	if str.regex, err = regexp.Compile(source); err != nil {
+		return parser.errorf("invalid regular expression %s: %v", str.id, err)
+	}
+	str.nocase = false
+	str.maxLength = yaraMaxMatchLength
+	return
+}
+
+// yaraExpr is part of a rule's condition. Booleans are 1 and 0, and yaraUndefined is what the integer reads give past
+// the part of the file they can read, which makes the comparisons it's in false.
+type yaraExpr interface {
+	eval(scan *yaraScan) int64
+}
+
+type (
+	yaraConstant    int64
+	yaraFileSize    struct{}
+	yaraStringFound struct{ index int }
+	yaraStringCount struct{ index int }
+	yaraRuleMatched struct{ index int }
+	yaraNot         struct{ expr yaraExpr }
+	yaraStringAt    struct {
+		index  int
+		offset yaraExpr
+	}
+	yaraStringIn struct {
+		index    int
+		from, to yaraExpr
+	}
+	yaraBinary struct {
+		operator    string
+		left, right yaraExpr
+	}
+	yaraOf struct {
+		quantifier yaraExpr // nil for all
+		none       bool
+		strings    []int
+	}
+	yaraIntegerRead struct {
+		size      int
+		signed    bool
+		bigEndian bool
+		offset    yaraExpr
+	}
+)
+
+func (expr yaraConstant) eval(scan *yaraScan) int64    { return int64(expr) }
+func (expr yaraFileSize) eval(scan *yaraScan) int64    { return scan.fileSize }
+func (expr yaraStringFound) eval(scan *yaraScan) int64 { return yaraBool(scan.counts[expr.index] > 0) }
+func (expr yaraStringCount) eval(scan *yaraScan) int64 { return int64(scan.counts[expr.index]) }
+func (expr yaraRuleMatched) eval(scan *yaraScan) int64 { return yaraBool(scan.matched[expr.index]) }
+
+func (expr yaraNot) eval(scan *yaraScan) int64 {
+	value := expr.expr.eval(scan)
+	if value == yaraUndefined {
+		return 0
+	}
+	return yaraBool(value == 0)
+}
+
+func (expr yaraStringAt) eval(scan *yaraScan) int64 {
+	offset := expr.offset.eval(scan)
+	return yaraBool(scan.matchedWithin(expr.index, offset, offset))
+}
+
+func (expr yaraStringIn) eval(scan *yaraScan) int64 {
+	return yaraBool(scan.matchedWithin(expr.index, expr.from.eval(scan), expr.to.eval(scan)))
+}
+
+func (expr yaraBinary) eval(scan *yaraScan) int64 {
+	left := expr.left.eval(scan)
+	switch expr.operator {
+	case "and":
+		return yaraBool(left != 0 && left != yaraUndefined && yaraTrue(expr.right.eval(scan)))
+	case "or":
+		return yaraBool(yaraTrue(left) || yaraTrue(expr.right.eval(scan)))
+	}
+	right := expr.right.eval(scan)
+	if left == yaraUndefined || right == yaraUndefined {
+		return yaraUndefined
+	}
+	switch expr.operator {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "==":
+		return yaraBool(left == right)
+	case "!=":
+		return yaraBool(left != right)
+	case "<":
+		return yaraBool(left < right)
+	case "<=":
+		return yaraBool(left <= right)
+	case ">":
+		return yaraBool(left > right)
+	default:
+		return yaraBool(left >= right)
+	}
+}
+
+func (expr yaraOf) eval(scan *yaraScan) int64 {
+	found := 0
+	for _, index := range expr.strings {
+		if scan.counts[index] > 0 {
+			found++
+		}
+	}
+	switch {
+	case expr.none:
+		return yaraBool(found == 0)
+	case expr.quantifier == nil:
+		return yaraBool(found == len(expr.strings))
+	}
+	return yaraBool(int64(found) >= expr.quantifier.eval(scan))
+}
+
+func (expr yaraIntegerRead) eval(scan *yaraScan) int64 {
+	offset := expr.offset.eval(scan)
+	if offset < 0 || offset+int64(expr.size) > int64(len(scan.header)) {
+		return yaraUndefined
+	}
+	data := scan.header[offset : offset+int64(expr.size)]
+	var value uint64
+	for index := range data {
+		position := index
+		if !expr.bigEndian {
+			position = len(data) - 1 - index
+		}
+		value = value<<8 | uint64(data[position])
+	}
+	if expr.signed {
+		shift := uint(64 - expr.size*8)
+		return int64(value<<shift) >> shift
+	}
+	return int64(value)
+}
+
+func yaraBool(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func yaraTrue(value int64) bool {
+	return value != 0 && value != yaraUndefined
+}
+
+func (parser *yaraParser) parseOr() (expr yaraExpr, err error) {
+	if expr, err = parser.parseAnd(); err != nil {
+		return
+	}
+	for parser.lookahead() == "or" {
+		parser.identifier()
+		var right yaraExpr
+		if right, err = parser.parseAnd(); err != nil {
+			return
+		}
+		expr = yaraBinary{operator: "or", left: expr, right: right}
+	}
+	return
+}
+
+func (parser *yaraParser) parseAnd() (expr yaraExpr, err error) {
+	if expr, err = parser.parseNot(); err != nil {
+		return
+	}
+	for parser.lookahead() == "and" {
+		parser.identifier()
+		var right yaraExpr
+		if right, err = parser.parseNot(); err != nil {
+			return
+		}
+		expr = yaraBinary{operator: "and", left: expr, right: right}
+	}
+	return
+}
+
+func (parser *yaraParser) parseNot() (expr yaraExpr, err error) {
+	if parser.lookahead() == "not" {
+		parser.identifier()
+		if expr, err = parser.parseNot(); err != nil {
+			return
+		}
+		return yaraNot{expr: expr}, nil
+	}
+	return parser.parseComparison()
+}
+
+func (parser *yaraParser) parseComparison() (expr yaraExpr, err error) {
+	if expr, err = parser.parseSum(); err != nil {
+		return
+	}
+	for _, operator := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if parser.accept(operator) {
+			var right yaraExpr
+			if right, err = parser.parseSum(); err != nil {
+				return
+			}
+			return yaraBinary{operator: operator, left: expr, right: right}, nil
+		}
+	}
+	return
+}
+
+func (parser *yaraParser) parseSum() (expr yaraExpr, err error) {
+	if expr, err = parser.parsePrimary(); err != nil {
+		return
+	}
+	for {
+		operator := ""
+		if parser.accept("+") {
+			operator = "+"
+		} else if parser.accept("-") {
+			operator = "-"
+		} else {
+			return
+		}
+		var right yaraExpr
+		if right, err = parser.parsePrimary(); err != nil {
+			return
+		}
+		expr = yaraBinary{operator: operator, left: expr, right: right}
+	}
+}
+
+func (parser *yaraParser) parsePrimary() (expr yaraExpr, err error) {
+	parser.skipSpace()
+	switch character := parser.peek(); {
+	case character == '(':
+		parser.position++
+		if expr, err = parser.parseOr(); err == nil {
+			err = parser.expect(")")
+		}
+		return
+	case character == '$':
+		parser.position++
+		var index int
+		if index, err = parser.stringIndex("$" + parser.identifier()); err != nil {
+			return
+		}
+		switch parser.lookahead() {
+		case "at":
+			parser.identifier()
+			var offset yaraExpr
+			if offset, err = parser.parseSum(); err != nil {
+				return
+			}
+			return yaraStringAt{index: index, offset: offset}, nil
+		case "in":
+			parser.identifier()
+			in := yaraStringIn{index: index}
+			if err = parser.expect("("); err != nil {
+				return
+			}
+			if in.from, err = parser.parseSum(); err != nil {
+				return
+			}
+			if err = parser.expect(".."); err != nil {
+				return
+			}
+			if in.to, err = parser.parseSum(); err != nil {
+				return
+			}
+			return in, parser.expect(")")
+		}
+		return yaraStringFound{index: index}, nil
+	case character == '-':
+		parser.position++
+		if expr, err = parser.parsePrimary(); err != nil {
+			return
+		}
+		return yaraBinary{operator: "-", left: yaraConstant(0), right: expr}, nil
+	case character == '#':
+		parser.position++
+		var index int
+		if index, err = parser.stringIndex("$" + parser.identifier()); err != nil {
+			return
+		}
+		return yaraStringCount{index: index}, nil
+	}
+
+	word := parser.identifier()
+	switch word {
+	case "":
+		return nil, parser.errorf("expected a condition")
+	case "true", "false":
+		return yaraConstant(yaraBool(word == "true")), nil
+	case "filesize":
+		return yaraFileSize{}, nil
+	case "them":
+		return nil, parser.errorf("them has to follow of")
+	case "all":
+		return parser.parseOf(yaraOf{})
+	case "any":
+		return parser.parseOf(yaraOf{quantifier: yaraConstant(1)})
+	case "none":
+		return parser.parseOf(yaraOf{none: true})
+	}
+	if read, ok := yaraIntegerReads[word]; ok {
+		if err = parser.expect("("); err != nil {
+			return
+		}
+		if read.offset, err = parser.parseSum(); err != nil {
+			return
+		}
+		return read, parser.expect(")")
+	}
+	if index, ok := parser.rules.names[word]; ok {
+		return yaraRuleMatched{index: index}, nil
+	}
+	number, err := parseYaraNumber(word)
+	if err != nil {
+		return nil, parser.errorf("unknown identifier '%s'", word)
+	}
+	if parser.lookahead() == "of" {
+		return parser.parseOf(yaraOf{quantifier: yaraConstant(number)})
+	}
+	return yaraConstant(number), nil
+}
+
+// yaraIntegerReads are the functions that read an integer from the file.
+var yaraIntegerReads = map[string]yaraIntegerRead{
+	"uint8": {size: 1}, "uint16": {size: 2}, "uint32": {size: 4},
+	"int8": {size: 1, signed: true}, "int16": {size: 2, signed: true}, "int32": {size: 4, signed: true},
+	"uint16be": {size: 2, bigEndian: true}, "uint32be": {size: 4, bigEndian: true},
+	"int16be": {size: 2, signed: true, bigEndian: true}, "int32be": {size: 4, signed: true, bigEndian: true},
+}
+
+// parseYaraNumber parses a decimal or hex number, with a KB or MB suffix.
+func parseYaraNumber(word string) (number int64, err error) {
+	multiplier := int64(1)
+	if strings.HasSuffix(word, "KB") {
+		word, multiplier = strings.TrimSuffix(word, "KB"), 1024
+	} else if strings.HasSuffix(word, "MB") {
+		word, multiplier = strings.TrimSuffix(word, "MB"), 1024*1024
+	}
+	if number, err = strconv.ParseInt(word, 0, 64); err == nil {
+		number *= multiplier
+	}
+	return
+}
+
+// parseOf parses the rest of an "of" set: of them, or of ($a, $b*).
+func (parser *yaraParser) parseOf(of yaraOf) (expr yaraExpr, err error) {
+	if keyword := parser.identifier(); keyword != "of" {
+		return nil, parser.errorf("expected 'of'")
+	}
+	if parser.lookahead() == "them" {
+		parser.identifier()
+		of.strings = parser.rule.strings
+	} else {
+		if err = parser.expect("("); err != nil {
+			return
+		}
+		for {
+			if err = parser.expect("$"); err != nil {
+				return
+			}
+			pattern := "$" + parser.identifier()
+			if parser.accept("*") {
+				for _, index := range parser.rule.strings {
+					if strings.HasPrefix(parser.rules.strings[index].id, pattern) {
+						of.strings = append(of.strings, index)
+					}
+				}
+			} else {
+				var index int
+				if index, err = parser.stringIndex(pattern); err != nil {
+					return
+				}
+				of.strings = append(of.strings, index)
+			}
+			if parser.accept(")") {
+				break
+			}
+			if err = parser.expect(","); err != nil {
+				return
+			}
+		}
+	}
+	if len(of.strings) == 0 {
+		return nil, parser.errorf("the of set in rule %s has no strings", parser.rule.name)
+	}
+	return of, nil
+}
+
+func (parser *yaraParser) stringIndex(id string) (index int, err error) {
+	index, ok := parser.ids[id]
+	if !ok {
+		err = parser.errorf("rule %s has no string %s", parser.rule.name, id)
+	}
+	return
+}
+
+// yaraScan is what a scan has found so far.
+type yaraScan struct {
+	rules    *YaraRules
+	counts   []int
+	offsets  [][]int64
+	header   []byte
+	fileSize int64
+	matched  []bool
+}
+
+func (scan *yaraScan) matchedWithin(index int, from int64, to int64) bool {
+	for _, offset := range scan.offsets[index] {
+		if offset >= from && offset <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan scans what input reads with the rules, a block at a time, and returns the rules that matched.
+func (rules *YaraRules) Scan(input io.Reader) (matches []YaraMatch, err error) {
+	scan := &yaraScan{rules: rules, counts: make([]int, len(rules.strings)), offsets: make([][]int64, len(rules.strings))}
+	overlap := rules.maxLength
+	buffer := make([]byte, 0, yaraBlockSize+overlap)
+	block := make([]byte, yaraBlockSize)
+	var base, scannedTo int64 // where in the file the buffer starts and what's been searched up to
+	for {
+		numberOfBytesRead, readErr := io.ReadFull(input, block)
+		atEnd := readErr == io.EOF || readErr == io.ErrUnexpectedEOF
+		if readErr != nil && !atEnd {
+			err = readErr
+			return
+		}
+		buffer = append(buffer, block[:numberOfBytesRead]...)
+		if len(scan.header) < yaraHeaderSize {
+			needed := yaraHeaderSize - len(scan.header)
+			if needed > numberOfBytesRead {
+				needed = numberOfBytesRead
+			}
+			scan.header = append(scan.header, block[:needed]...)
+		}
+		scan.fileSize += int64(numberOfBytesRead)
+
+		// Matches that start in the overlap are left for the next block, which will have all of them
+		limit := base + int64(len(buffer))
+		if !atEnd {
+			limit -= int64(overlap)
+		}
+		if limit > scannedTo {
+			scan.search(buffer, int(scannedTo-base), int(limit-base), base)
+			scannedTo = limit
+		}
+		if atEnd {
+			break
+		}
+		// Keep the byte before what's left to search, which fullword looks at
+		keep := int(scannedTo-base) - 1
+		if keep < 0 {
+			keep = 0
+		}
+		buffer = buffer[:copy(buffer, buffer[keep:])]
+		base += int64(keep)
+	}
+	matches = scan.matches()
+	return
+}
+
+// search finds the strings' matches that start between from and to in buffer, which starts at base in the file.
+func (scan *yaraScan) search(buffer []byte, from int, to int, base int64) {
+	var lowered []byte
+	for index, str := range scan.rules.strings {
+		switch {
+		case str.literals != nil:
+			data := buffer
+			if str.nocase {
+				if lowered == nil {
+					lowered = yaraLower(buffer)
+				}
+				data = lowered
+			}
+			for _, literal := range str.literals {
+				for position := from; position < to; {
+					found := bytes.Index(data[position:], literal.data)
+					if found < 0 || position+found >= to {
+						break
+					}
+					position += found
+					if !str.fullword || isYaraFullword(buffer, position, position+len(literal.data), literal.wide) {
+						scan.found(index, base+int64(position))
+					}
+					position++
+				}
+			}
+		case str.hex != nil:
+			first := str.hex[0]
+			for position := from; position < to; position++ {
+				if first.mask == 0xff {
+					found := bytes.IndexByte(buffer[position:to], first.value)
+					if found < 0 {
+						break
+					}
+					position += found
+				}
+				if matchYaraHex(str.hex, buffer, position, func(end int) bool { return true }) {
+					scan.found(index, base+int64(position))
+				}
+			}
+		case str.regex != nil:
+			for _, location := range str.regex.FindAllIndex(buffer[from:], -1) {
+				start, end := from+location[0], from+location[1]
+				if start >= to {
+					break
+				}
+				if end > start && (!str.fullword || isYaraFullword(buffer, start, end, false)) {
+					scan.found(index, base+int64(start))
+				}
+			}
+		}
+	}
+}
+
+func (scan *yaraScan) found(index int, offset int64) {
+	scan.counts[index]++
+	if len(scan.offsets[index]) < yaraMaxOffsets {
+		scan.offsets[index] = append(scan.offsets[index], offset)
+	}
+}
+
+// matchYaraHex matches hex tokens at position, calling then with where the match ends to see whether the rest
+// matches too.
+func matchYaraHex(tokens []yaraHexToken, data []byte, position int, then func(end int) bool) bool {
+	if len(tokens) == 0 {
+		return then(position)
+	}
+	token := tokens[0]
+	switch {
+	case token.jump:
+		for skip := token.minJump; skip <= token.maxJump && position+skip <= len(data); skip++ {
+			if matchYaraHex(tokens[1:], data, position+skip, then) {
+				return true
+			}
+		}
+		return false
+	case token.alternatives != nil:
+		for _, alternative := range token.alternatives {
+			if matchYaraHex(alternative, data, position, func(end int) bool { return matchYaraHex(tokens[1:], data, end, then) }) {
+				return true
+			}
+		}
+		return false
+	}
+	if position >= len(data) || data[position]&token.mask != token.value {
+		return false
+	}
+	return matchYaraHex(tokens[1:], data, position+1, then)
+}
+
+// isYaraFullword reports whether a match isn't next to other letters or digits.
+func isYaraFullword(data []byte, start int, end int, wide bool) bool {
+	before := start - 1
+	if wide {
+		before--
+	}
+	if before >= 0 && isYaraWordByte(data[before]) {
+		return false
+	}
+	return end >= len(data) || !isYaraWordByte(data[end])
+}
+
+// yaraLower lowers ASCII letters alone, leaving the length and the other bytes as they are, which bytes.ToLower
+// doesn't with data that isn't UTF-8.
+func yaraLower(data []byte) []byte {
+	lowered := make([]byte, len(data))
+	for index, character := range data {
+		if character >= 'A' && character <= 'Z' {
+			character += 'a' - 'A'
+		}
+		lowered[index] = character
+	}
+	return lowered
+}
+
+func isYaraWordByte(character byte) bool {
+	return character >= 'a' && character <= 'z' || character >= 'A' && character <= 'Z' || character >= '0' && character <= '9'
+}
+
+// matches evaluates the rules' conditions. When a global rule doesn't match nothing does.
+func (scan *yaraScan) matches() (matches []YaraMatch) {
+	scan.matched = make([]bool, len(scan.rules.rules))
+	for index, rule := range scan.rules.rules {
+		scan.matched[index] = yaraTrue(rule.condition.eval(scan))
+		if rule.global && !scan.matched[index] {
+			return nil
+		}
+	}
+	for index, rule := range scan.rules.rules {
+		if !scan.matched[index] || rule.private {
+			continue
+		}
+		match := YaraMatch{Rule: rule.name, Tags: rule.tags}
+		if len(rule.meta) != 0 {
+			match.Meta = rule.meta
+		}
+		for _, stringIndex := range rule.strings {
+			str := scan.rules.strings[stringIndex]
+			if scan.counts[stringIndex] != 0 && !str.private {
+				match.Strings = append(match.Strings, YaraStringMatch{ID: str.id, Count: scan.counts[stringIndex], Offsets: scan.offsets[stringIndex]})
+			}
+		}
+		matches = append(matches, match)
+	}
+	return
+}
+
+// YaraProcessor scans every file with YARA rules as it's collected. The rules a file matches are written as JSON into
+// a .yara.json file next to it and listed in report.json under yara_matches. Files that match nothing get no copy, so
+// with ReplaceProcessed the output only holds the matches, turning a collection into a sweep.
+type YaraProcessor struct {
+	Rules *YaraRules
+}
+
+// OutputPath is the file's path with .yara.json added.
+func (processor YaraProcessor) OutputPath(fullPath string) string {
+	return fullPath + ".yara.json"
+}
+
+// Process scans the file.
+func (processor YaraProcessor) Process(ctx context.Context, logger Logger, input io.Reader, output io.Writer) (err error) {
+	if processor.Rules == nil {
+		return errors.New("the YARA processor has no rules")
+	}
+	matches, err := processor.Rules.Scan(input)
+	if err != nil || len(matches) == 0 {
+		return
+	}
+	file := processedFileFromContext(ctx)
+	for index := range matches {
+		matches[index].Path = file.fullPath
+	}
+	logger.Infof("%s matched the YARA rules %s.", file.fullPath, yaraRuleNames(matches))
+	file.report.addYaraMatches(matches)
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(matches)
+	return
+}
+
+func yaraRuleNames(matches []YaraMatch) string {
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, match.Rule)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Alec Randazzo
+
+package windowscollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseYaraRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{name: "full rule", source: `
+			// a comment
+			private rule is_pe { condition: uint16(0) == 0x5a4d }
+			rule tagged : malware apt {
+				meta:
+					author = "someone"
+					severity = 3
+					active = true
+				strings:
+					$text = "evil" nocase wide ascii fullword
+					$hex = { 4d 5a ?? [2-4] ( 90 | 91 92 ) }
+					$regex = /ab+c/is
+					$ = "anonymous" private
+				/* a block comment */
+				condition:
+					is_pe and (any of ($text, $h*) or #regex > 2) and not $regex at 0 and filesize < 1MB
+			}`},
+		{name: "module", source: `import "pe" rule a { condition: true }`, wantErr: true},
+		{name: "xor", source: `rule a { strings: $a = "x" xor condition: $a }`, wantErr: true},
+		{name: "unknown string", source: `rule a { strings: $a = "x" condition: $b }`, wantErr: true},
+		{name: "unknown identifier", source: `rule a { condition: pe.is_dll() }`, wantErr: true},
+		{name: "duplicate rule", source: `rule a { condition: true } rule a { condition: false }`, wantErr: true},
+		{name: "jump at the start", source: `rule a { strings: $a = { [2] 4d } condition: $a }`, wantErr: true},
+		{name: "unterminated string", source: `rule a { strings: $a = "x condition: $a }`, wantErr: true},
+		{name: "invalid regex", source: `rule a { strings: $a = /(/ condition: $a }`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseYaraRules(tt.source); (err != nil) != tt.wantErr {
+				t.Errorf("ParseYaraRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestYaraRules_Scan(t *testing.T) {
+	sample := []byte("MZ\x90\x00 header Evil e\x00v\x00i\x00l\x00 abbbc evilness abc")
+	tests := []struct {
+		name      string
+		source    string
+		input     []byte
+		wantRules string
+	}{
+		{name: "text", source: `rule a { strings: $a = "header" condition: $a }`, input: sample, wantRules: "a"},
+		{name: "text missing", source: `rule a { strings: $a = "footer" condition: $a }`, input: sample},
+		{name: "nocase", source: `rule a { strings: $a = "EVIL" nocase condition: #a == 2 }`, input: sample, wantRules: "a"},
+		{name: "fullword", source: `rule a { strings: $a = "evil" nocase fullword condition: #a == 1 }`, input: sample, wantRules: "a"},
+		{name: "wide", source: `rule a { strings: $a = "evil" wide condition: #a == 1 and $a at 17 }`, input: sample, wantRules: "a"},
+		{name: "hex", source: `rule a { strings: $a = { 4d 5a ?0 00 } condition: $a at 0 }`, input: sample, wantRules: "a"},
+		{name: "hex jump and alternatives", source: `rule a { strings: $a = { 4d [1-3] ( 01 | 00 20 68 ) } condition: $a }`, input: sample, wantRules: "a"},
+		{name: "regex", source: `rule a { strings: $a = /ab+c/ condition: #a == 2 and $a in (20..45) }`, input: sample, wantRules: "a"},
+		{name: "integer reads", source: `rule a { condition: uint16(0) == 0x5a4d and uint16be(0) == 0x4d5a and int8(2) == -112 }`, input: sample, wantRules: "a"},
+		{name: "read past the end", source: `rule a { condition: not (uint32(1000) == 0) }`, input: sample},
+		{name: "filesize", source: `rule a { condition: filesize == 44 and filesize < 1KB }`, input: sample, wantRules: "a"},
+		{name: "of", source: `rule a { strings: $a = "evil" $b = "good" $c = "header" condition: 2 of them and none of ($b) and not all of ($*) }`, input: sample, wantRules: "a"},
+		{name: "private and referenced rules", source: `private rule mz { condition: uint16(0) == 0x5a4d } rule a { condition: mz } rule b { condition: not mz }`, input: sample, wantRules: "a"},
+		{name: "global", source: `global rule small { condition: filesize < 10 } rule a { condition: true }`, input: sample},
+		{name: "across blocks", source: `rule a { strings: $a = "needle" $b = { 6e 65 [0-2] 64 6c 65 } condition: $a at 1048573 and $b at 1048573 and #a == 1 }`, input: append(append(make([]byte, yaraBlockSize-3), "needle"...), make([]byte, 10)...), wantRules: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseYaraRules(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matches, err := rules.Scan(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+			if got := yaraRuleNames(matches); got != tt.wantRules {
+				t.Errorf("Scan() matched %q, want %q", got, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestLoadYaraRules(t *testing.T) {
+	directory, err := ioutil.TempDir("", "yara")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	_ = ioutil.WriteFile(filepath.Join(directory, "a.yar"), []byte(`rule a { condition: true }`), 0600)
+	_ = ioutil.WriteFile(filepath.Join(directory, "b.yara"), []byte(`rule b { condition: a }`), 0600)
+	_ = ioutil.WriteFile(filepath.Join(directory, "notes.txt"), []byte(`not a rule`), 0600)
+	rules, err := LoadYaraRules(directory)
+	if err != nil {
+		t.Fatalf("LoadYaraRules() error = %v", err)
+	}
+	if len(rules.rules) != 2 {
+		t.Errorf("LoadYaraRules() loaded %d rules, want 2", len(rules.rules))
+	}
+	if _, err = LoadYaraRules(filepath.Join(directory, "missing.yar")); err == nil {
+		t.Error("LoadYaraRules() error = nil for a missing file")
+	}
+}
+
+func TestYaraProcessor_Process(t *testing.T) {
+	rules, err := ParseYaraRules(`rule mz : pe { meta: description = "an executable" strings: $mz = "MZ" condition: $mz at 0 }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := &reportBuilder{}
+	ctx := contextWithProcessedFile(context.Background(), processedFile{fullPath: `c:\tools\a.exe`, report: builder})
+	output := new(bytes.Buffer)
+	if err = (YaraProcessor{Rules: rules}).Process(ctx, loggerOrDefault(nil), strings.NewReader("MZ..."), output); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	var matches []YaraMatch
+	if err = json.Unmarshal(output.Bytes(), &matches); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Path != `c:\tools\a.exe` || matches[0].Strings[0].Offsets[0] != 0 || matches[0].Meta["description"] != "an executable" {
+		t.Errorf("Process() wrote %s", output)
+	}
+	if report := builder.snapshot(); len(report.YaraMatches) != 1 {
+		t.Errorf("the report has %d YARA matches, want 1", len(report.YaraMatches))
+	}
+
+	output.Reset()
+	if err = (YaraProcessor{Rules: rules}).Process(ctx, loggerOrDefault(nil), strings.NewReader("no match"), output); err != nil || output.Len() != 0 {
+		t.Errorf("Process() wrote %s, error = %v, want nothing for a file that doesn't match", output, err)
+	}
+}